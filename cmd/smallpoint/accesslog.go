@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// The access log has a line per request in the Apache common or combined
+// format, followed by the request ID, or a JSON record.
+
+const accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type accessLogConfig struct {
+	// Format is combined, the default, common or json.
+	Format string `yaml:"format"`
+}
+
+type accessLogRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	RemoteIP  string    `json:"remote_ip"`
+	User      string    `json:"user,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Size      int       `json:"size"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+}
+
+type accessLogger struct {
+	format string
+	logger *log.Logger
+}
+
+func newAccessLogger(config accessLogConfig, w io.Writer) (*accessLogger, error) {
+	format := strings.ToLower(config.Format)
+	switch format {
+	case "":
+		format = "combined"
+	case "common", "combined", "json":
+	default:
+		return nil, fmt.Errorf("invalid access log format '%s'", config.Format)
+	}
+	return &accessLogger{format: format, logger: log.New(w, "", 0)}, nil
+}
+
+func accessLogField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func (l *accessLogger) log(record accessLogRecord) {
+	if l.format == "json" {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		l.logger.Println(string(b))
+		return
+	}
+	size := "-"
+	if record.Size > 0 {
+		size = fmt.Sprint(record.Size)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s", record.RemoteIP, accessLogField(record.User),
+		record.Time.Format(accessLogTimeFormat), record.Method+" "+record.URI+" "+record.Protocol,
+		record.Status, size)
+	if l.format == "combined" {
+		line += fmt.Sprintf(" %q %q", accessLogField(record.Referer), accessLogField(record.UserAgent))
+	}
+	l.logger.Println(line + " " + record.RequestID)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogger(t *testing.T) {
+	record := accessLogRecord{
+		Time:      time.Date(2024, 3, 5, 10, 4, 5, 0, time.UTC),
+		RequestID: "upstream-id.1",
+		RemoteIP:  "192.0.2.1",
+		Method:    getMethod,
+		URI:       "/group_info/?groupname=group1",
+		Protocol:  "HTTP/1.1",
+		Status:    http.StatusOK,
+		Size:      1024,
+		UserAgent: `agent "quoted"`,
+	}
+	expected := map[string]string{
+		"common": `192.0.2.1 - - [05/Mar/2024:10:04:05 +0000] "GET /group_info/?groupname=group1 HTTP/1.1" 200 1024 upstream-id.1` + "\n",
+		"":       `192.0.2.1 - - [05/Mar/2024:10:04:05 +0000] "GET /group_info/?groupname=group1 HTTP/1.1" 200 1024 "-" "agent \"quoted\"" upstream-id.1` + "\n",
+	}
+	for format, line := range expected {
+		var buf bytes.Buffer
+		logger, err := newAccessLogger(accessLogConfig{Format: format}, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.log(record)
+		if buf.String() != line {
+			t.Errorf("unexpected %q line %q", format, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	logger, err := newAccessLogger(accessLogConfig{Format: "JSON"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.User = testUsername
+	logger.log(record)
+	var decoded accessLogRecord
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatalf("bad record %q: %s", buf.String(), err)
+	}
+	if decoded != record {
+		t.Fatalf("unexpected record %+v", decoded)
+	}
+	_, err = newAccessLogger(accessLogConfig{Format: "apache"}, &buf)
+	if err == nil {
+		t.Fatal("the apache format should be invalid")
+	}
+}
+
+func TestAccessLogAndErrorPageRequestID(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	state.accessLogger, err = newAccessLogger(accessLogConfig{Format: "common"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(indexPath, state.defaultPathHandler)
+	req := httptest.NewRequest(getMethod, "/unknown", nil)
+	req.Header.Set(requestIDHeader, "upstream-id.2")
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	state.requestLoggingHandler(mux, mux).ServeHTTP(rr, req)
+
+	var pageData simpleMessagePageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatalf("bad error page %q: %s", rr.Body.String(), err)
+	}
+	if pageData.RequestID != "upstream-id.2" {
+		t.Fatalf("the request ID is missing from the error page %+v", pageData)
+	}
+	line := buf.String()
+	if !strings.Contains(line, " - "+testUsername+" [") || !strings.Contains(line, `"GET /unknown HTTP/1.1" 404 `) ||
+		!strings.HasSuffix(line, " upstream-id.2\n") {
+		t.Fatalf("unexpected access log %q", line)
+	}
+}
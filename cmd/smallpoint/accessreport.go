@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+const (
+	accessRoleMember  = "member"
+	accessRoleManager = "manager"
+)
+
+// accessReportEntry is one way a user gets access to a group. Via lists the
+// groups from the granting group down to the group where the user is a
+// direct member.
+type accessReportEntry struct {
+	Username  string
+	Groupname string
+	Role      string
+	Via       string
+}
+
+func accessViaPath(path []string) string {
+	return strings.Join(path, " > ")
+}
+
+// expandGroupMembers appends one entry per user reachable from the last
+// group in path, following nested groups. visited protects against cycles.
+func (state *RuntimeState) expandGroupMembers(reportGroup string, role string, path []string,
+	visited map[string]bool, entries []accessReportEntry) ([]accessReportEntry, error) {
+	groupname := path[len(path)-1]
+	visited[groupname] = true
+	users, _, err := state.Userinfo.GetusersofaGroup(groupname)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		entries = append(entries, accessReportEntry{Username: user, Groupname: reportGroup,
+			Role: role, Via: accessViaPath(path)})
+	}
+	subgroups, err := state.Userinfo.GetSubgroupsofGroup(groupname)
+	if err != nil {
+		return nil, err
+	}
+	for _, subgroup := range subgroups {
+		if visited[subgroup] {
+			continue
+		}
+		subPath := append(append([]string{}, path...), subgroup)
+		expanded, err := state.expandGroupMembers(reportGroup, role, subPath, visited, entries)
+		if err != nil {
+			// a deleted group can stay a member of its parents
+			if err == userinfo.GroupDoesNotExist {
+				slog.Warn("nested group does not exist", "subgroup", subgroup, "group", groupname)
+				continue
+			}
+			return nil, err
+		}
+		entries = expanded
+	}
+	return entries, nil
+}
+
+// groupAccessReport lists everyone with effective access to groupname, both
+// members and the members of the managing group.
+func (state *RuntimeState) groupAccessReport(groupname string) ([]accessReportEntry, error) {
+	managedBy, err := state.Userinfo.GetDescriptionvalue(groupname)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := state.expandGroupMembers(groupname, accessRoleMember, []string{groupname},
+		make(map[string]bool), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch managedBy {
+	case "":
+	case descriptionAttribute:
+		memberEntries := len(entries)
+		for i := 0; i < memberEntries; i++ {
+			entry := entries[i]
+			entry.Role = accessRoleManager
+			entries = append(entries, entry)
+		}
+	default:
+		expanded, err := state.expandGroupMembers(groupname, accessRoleManager, []string{managedBy},
+			make(map[string]bool), entries)
+		if err != nil {
+			if err == userinfo.GroupDoesNotExist {
+				slog.Warn("managing group does not exist", "managed_by", managedBy, "group", groupname)
+				return entries, nil
+			}
+			return nil, err
+		}
+		entries = expanded
+	}
+	return entries, nil
+}
+
+// userAccessReport lists every group username has access to, directly,
+// through nested groups or by being a member of a managing group.
+func (state *RuntimeState) userAccessReport(username string) ([]accessReportEntry, error) {
+	directGroups, err := state.Userinfo.GetgroupsofUser(username)
+	if err != nil {
+		return nil, err
+	}
+	// effective group -> path from the effective group to the direct group
+	effectiveGroups := make(map[string][]string)
+	var pending [][]string
+	for _, group := range directGroups {
+		pending = append(pending, []string{group})
+	}
+	for len(pending) > 0 {
+		path := pending[0]
+		pending = pending[1:]
+		if _, ok := effectiveGroups[path[0]]; ok {
+			continue
+		}
+		effectiveGroups[path[0]] = path
+		parents, err := state.Userinfo.GetParentgroupsofGroup(path[0])
+		if err != nil {
+			if err == userinfo.GroupDoesNotExist {
+				continue
+			}
+			return nil, err
+		}
+		for _, parent := range parents {
+			pending = append(pending, append([]string{parent}, path...))
+		}
+	}
+	var entries []accessReportEntry
+	for group, path := range effectiveGroups {
+		entries = append(entries, accessReportEntry{Username: username, Groupname: group,
+			Role: accessRoleMember, Via: accessViaPath(path)})
+	}
+	allGroupsManagedBy, err := state.Userinfo.GetAllGroupsManagedBy()
+	if err != nil {
+		return nil, err
+	}
+	for _, groupAndManager := range allGroupsManagedBy {
+		group, managedBy := groupAndManager[0], groupAndManager[1]
+		if managedBy == descriptionAttribute {
+			managedBy = group
+		}
+		path, ok := effectiveGroups[managedBy]
+		if !ok {
+			continue
+		}
+		entries = append(entries, accessReportEntry{Username: username, Groupname: group,
+			Role: accessRoleManager, Via: accessViaPath(path)})
+	}
+	sortAccessReportEntries(entries)
+	return entries, nil
+}
+
+func sortAccessReportEntries(entries []accessReportEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Groupname != entries[j].Groupname {
+			return entries[i].Groupname < entries[j].Groupname
+		}
+		if entries[i].Username != entries[j].Username {
+			return entries[i].Username < entries[j].Username
+		}
+		if entries[i].Role != entries[j].Role {
+			return entries[i].Role < entries[j].Role
+		}
+		return entries[i].Via < entries[j].Via
+	})
+}
+
+func writeAccessReportCSV(w http.ResponseWriter, filename string, entries []accessReportEntry) error {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write([]string{"user", "group", "role", "via"})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = csvWriter.Write([]string{entry.Username, entry.Groupname, entry.Role, entry.Via})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// accessReportHandler is available to auditors for any group or user, and to
+// group managers for the groups they manage.
+func (state *RuntimeState) accessReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	authUser, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	isAuditor, err := state.isAuditor(authUser)
+	if err != nil {
+		requestLogger(r).Error("accessReportHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	groupname := strings.TrimSpace(r.URL.Query().Get("groupname"))
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+	if groupname != "" && username != "" {
+		state.writeFailureResponse(w, r, "Select either a group or a user", http.StatusBadRequest)
+		return
+	}
+	if !isAuditor {
+		if groupname == "" {
+			http.Error(w, "you are not authorized", http.StatusForbidden)
+			return
+		}
+		isGroupAdmin, err := state.requestUserinfo(r).IsgroupAdminorNot(authUser, groupname)
+		if err != nil {
+			if err == userinfo.GroupDoesNotExist {
+				state.writeFailureResponse(w, r, "Group doesn't exist!", http.StatusBadRequest)
+				return
+			}
+			requestLogger(r).Error("accessReportHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		if !isGroupAdmin {
+			http.Error(w, "you are not authorized", http.StatusForbidden)
+			return
+		}
+	}
+	var entries []accessReportEntry
+	var subject string
+	switch {
+	case groupname != "":
+		subject = groupname
+		entries, err = state.groupAccessReport(groupname)
+		if err == userinfo.GroupDoesNotExist {
+			state.writeFailureResponse(w, r, "Group doesn't exist!", http.StatusBadRequest)
+			return
+		}
+		sortAccessReportEntries(entries)
+	case username != "":
+		subject = username
+		entries, err = state.userAccessReport(username)
+	}
+	if err != nil {
+		requestLogger(r).Error("accessReportHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if subject != "" && r.URL.Query().Get("format") == "csv" {
+		filename := fmt.Sprintf("access_report_%s_%s.csv", subject, time.Now().Format(auditDateLayout))
+		err = writeAccessReportCSV(w, filename, entries)
+		if err != nil {
+			requestLogger(r).Error("failed to write the csv", "err", err)
+		}
+		return
+	}
+	csvQuery := r.URL.Query()
+	csvQuery.Set("format", "csv")
+	pageData := accessReportPageData{
+		UserName:     authUser,
+		IsAdmin:      state.requestUserinfo(r).UserisadminOrNot(authUser),
+		Title:        "Access Report",
+		Groupname:    groupname,
+		Username:     username,
+		Subject:      subject,
+		Entries:      entries,
+		CSVExportURL: accessReportPath + "?" + csvQuery.Encode(),
+	}
+	state.renderTemplateOrReturnJson(w, r, "accessReportPage", pageData)
+}
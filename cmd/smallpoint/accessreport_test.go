@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func testHasAccessEntry(entries []accessReportEntry, expected accessReportEntry) bool {
+	for _, entry := range entries {
+		if entry == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAccessReports(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nest group1 inside group3
+	err = state.Userinfo.AddmemberstoExisting(userinfo.GroupInfo{Groupname: "group3",
+		Member: []string{"cn=group1,ou=groups,dc=mgmt,dc=example,dc=com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupEntries, err := state.groupAccessReport("group3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []accessReportEntry{
+		{Username: "user2", Groupname: "group3", Role: accessRoleMember, Via: "group3 > group1"},
+		{Username: "user2", Groupname: "group3", Role: accessRoleManager, Via: "group1"},
+	} {
+		if !testHasAccessEntry(groupEntries, expected) {
+			t.Fatalf("group report %+v missing %+v", groupEntries, expected)
+		}
+	}
+
+	userEntries, err := state.userAccessReport("user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []accessReportEntry{
+		{Username: "user1", Groupname: "group1", Role: accessRoleMember, Via: "group1"},
+		{Username: "user1", Groupname: "group3", Role: accessRoleMember, Via: "group3 > group1"},
+		{Username: "user1", Groupname: "group3", Role: accessRoleManager, Via: "group1"},
+	} {
+		if !testHasAccessEntry(userEntries, expected) {
+			t.Fatalf("user report %+v missing %+v", userEntries, expected)
+		}
+	}
+
+	// user2 is not an auditor so it cannot report on users
+	req, err := http.NewRequest("GET", accessReportPath+"?username=user1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.accessReportHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	// but it manages group3 through group1
+	req, err = http.NewRequest("GET", accessReportPath+"?groupname=group3&format=csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(state.accessReportHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "user1,group3,member,group3 > group1") {
+		t.Fatalf("unexpected csv: %s", rr.Body.String())
+	}
+}
+
+// testDanglingSubgroupUserInfo lists a deleted group among the subgroups of
+// the groups, as LDAP does when the member attribute was not cleaned up.
+type testDanglingSubgroupUserInfo struct {
+	userinfo.UserInfoBackend
+	groupnames map[string]bool
+}
+
+func (u testDanglingSubgroupUserInfo) GetSubgroupsofGroup(groupname string) ([]string, error) {
+	subgroups, err := u.UserInfoBackend.GetSubgroupsofGroup(groupname)
+	if err == nil && u.groupnames[groupname] {
+		subgroups = append(subgroups, "deleted-group")
+	}
+	return subgroups, err
+}
+
+func TestGroupAccessReportDanglingSubgroup(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.AddmemberstoExisting(userinfo.GroupInfo{Groupname: "group3", MemberUid: []string{"user3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// group3 is managed by group1, both have a deleted subgroup
+	state.Userinfo = testDanglingSubgroupUserInfo{UserInfoBackend: state.Userinfo,
+		groupnames: map[string]bool{"group1": true, "group3": true}}
+	entries, err := state.groupAccessReport("group3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []accessReportEntry{
+		{Username: "user3", Groupname: "group3", Role: accessRoleMember, Via: "group3"},
+		{Username: "user1", Groupname: "group3", Role: accessRoleManager, Via: "group1"},
+		{Username: "user2", Groupname: "group3", Role: accessRoleManager, Via: "group1"},
+	} {
+		if !testHasAccessEntry(entries, expected) {
+			t.Fatalf("group report %+v missing %+v", entries, expected)
+		}
+	}
+}
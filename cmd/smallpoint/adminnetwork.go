@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// The admin paths, the group creations and deletions, the service account
+// creations and the APIs of the automation, are also restricted by the
+// network of the client whatever the role of the user: the denied CIDRs are
+// rejected and, when allowed CIDRs are set, only those are accepted. The
+// client is the peer of the connection, the restrictions belong in front of
+// the proxies when smallpoint is behind one.
+
+const securityEventAdminNetworkDenied = "admin_network_denied"
+
+// adminPathPrefixes are the paths restricted by the admin network.
+var adminPathPrefixes = []string{
+	creategroupWebPagePath,
+	deletegroupWebPagePath,
+	// any user requests a service account, the admins approve it
+	serviceAccountRequestPath,
+	serviceAccountApprovalPath,
+	importServiceAccountsPath,
+	importGroupsPath,
+	mergeGroupPath,
+	renameGroupPath,
+	groupArchivePath,
+	groupsAPIPath,
+	serviceAccountsAPIPath,
+	debugPathPrefix,
+	// the token clients of SCIM act as admins
+	scimPath,
+	changeownershipPath,
+	exportGroupsPath,
+	githubTeamsPath,
+	oktaGroupsPath,
+	groupClassificationPath,
+	groupTemplatesPath,
+}
+
+type adminNetworkConfig struct {
+	AllowedCIDRs []string `yaml:"allowed_cidrs"`
+	DeniedCIDRs  []string `yaml:"denied_cidrs"`
+	// ExtraPaths are the other path prefixes restricted like the admin
+	// paths.
+	ExtraPaths []string `yaml:"extra_paths"`
+}
+
+func (config adminNetworkConfig) enabled() bool {
+	return len(config.AllowedCIDRs) > 0 || len(config.DeniedCIDRs) > 0
+}
+
+func (config adminNetworkConfig) check() error {
+	_, err := newAdminNetworkFilter(config)
+	return err
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type adminNetworkFilter struct {
+	allowed  []*net.IPNet
+	denied   []*net.IPNet
+	prefixes []string
+}
+
+func newAdminNetworkFilter(config adminNetworkConfig) (*adminNetworkFilter, error) {
+	allowed, err := parseCIDRs(config.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := parseCIDRs(config.DeniedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	for _, prefix := range config.ExtraPaths {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("the extra path %q must start with /", prefix)
+		}
+	}
+	return &adminNetworkFilter{allowed: allowed, denied: denied,
+		prefixes: append(append([]string(nil), adminPathPrefixes...), config.ExtraPaths...)}, nil
+}
+
+// adminPath reports whether the path is restricted, the cleaned path is
+// matched so that neither /./ nor // get around the restrictions.
+func (f *adminNetworkFilter) adminPath(requestPath string) bool {
+	cleaned := path.Clean("/" + requestPath)
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(cleaned, strings.TrimSuffix(prefix, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedIP reports whether the client may reach the admin paths.
+func (f *adminNetworkFilter) allowedIP(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	if containsIP(f.denied, ip) {
+		return false
+	}
+	return len(f.allowed) == 0 || containsIP(f.allowed, ip)
+}
+
+func (state *RuntimeState) adminNetworkHandler(filter *adminNetworkFilter, handler http.Handler) http.Handler {
+	if filter == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !filter.adminPath(r.URL.Path) || filter.allowedIP(remoteIPFromRequest(r)) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		recordSecurityEvent(r, securityEventAdminNetworkDenied)
+		state.writeFailureResponse(w, r, "the admin pages cannot be reached from your network",
+			http.StatusForbidden)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminNetwork(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := newAdminNetworkFilter(adminNetworkConfig{AllowedCIDRs: []string{"10.0.0.0/8", "fd00::/8"},
+		DeniedCIDRs: []string{"10.6.6.0/24"}, ExtraPaths: []string{auditLogPath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := state.adminNetworkHandler(filter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, test := range []struct {
+		path       string
+		remoteAddr string
+		code       int
+	}{
+		{creategroupPath, "10.1.2.3:1000", http.StatusOK},
+		{creategroupPath, "[fd00::1]:1000", http.StatusOK},
+		{creategroupPath, "192.0.2.1:1000", http.StatusForbidden},
+		{creategroupPath, "10.6.6.6:1000", http.StatusForbidden},
+		{"//delete_group/", "192.0.2.1:1000", http.StatusForbidden},
+		{"/x/../serviceaccount_request/", "192.0.2.1:1000", http.StatusForbidden},
+		{groupsAPIPath + "group1", "192.0.2.1:1000", http.StatusForbidden},
+		{auditLogPath, "192.0.2.1:1000", http.StatusForbidden},
+		// the other paths are not restricted
+		{indexPath, "192.0.2.1:1000", http.StatusOK},
+		{myRequestsPath, "10.6.6.6:1000", http.StatusOK},
+		{createServiceAccountPath, "192.0.2.1:1000", http.StatusOK},
+	} {
+		req := httptest.NewRequest(getMethod, "/", nil)
+		req.URL.Path = test.path
+		req.RemoteAddr = test.remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("%s from %s got %d, expected %d", test.path, test.remoteAddr, rr.Code, test.code)
+		}
+	}
+	for _, prefix := range adminPathPrefixes {
+		for remoteAddr, code := range map[string]int{"10.1.2.3:1000": http.StatusOK,
+			"192.0.2.1:1000": http.StatusForbidden} {
+			req := httptest.NewRequest(getMethod, "/", nil)
+			req.URL.Path = prefix
+			req.RemoteAddr = remoteAddr
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != code {
+				t.Errorf("%s from %s got %d, expected %d", prefix, remoteAddr, rr.Code, code)
+			}
+		}
+	}
+
+	if (adminNetworkConfig{DeniedCIDRs: []string{"10.0.0.1"}}).check() == nil {
+		t.Fatal("the address without a prefix length was accepted")
+	}
+	if (adminNetworkConfig{ExtraPaths: []string{"audit_log"}}).check() == nil {
+		t.Fatal("the relative extra path was accepted")
+	}
+}
@@ -3,10 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/Symantec/ldap-group-management/lib/userinfo"
 )
@@ -30,28 +31,30 @@ func (state *RuntimeState) renderTemplateOrReturnJson(w http.ResponseWriter, r *
 	preferredAcceptType := state.getPreferredAcceptType(r)
 	switch preferredAcceptType {
 	case "text/html":
-		setSecurityHeaders(w)
 		cacheControlValue := "private, max-age=60"
 		if templateName != "simpleMessagePage" {
 			cacheControlValue = "private, max-age=5"
 		}
-		w.Header().Set("Cache-Control", cacheControlValue)
-		err := state.htmlTemplate.ExecuteTemplate(w, templateName, pageData)
+		// handlers may require a stricter policy
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", cacheControlValue)
+		}
+		err := state.executeTemplate(w, templateName, pageData)
 		if err != nil {
-			log.Printf("Failed to execute %v", err)
+			requestLogger(r).Error("Failed to execute the template", "err", err)
 			http.Error(w, "error", http.StatusInternalServerError)
 			return err
 		}
 	default:
 		b, err := json.Marshal(pageData)
 		if err != nil {
-			log.Printf("Failed marshal %v", err)
+			requestLogger(r).Error("Failed marshal", "err", err)
 			http.Error(w, "error", http.StatusInternalServerError)
 			return err
 		}
 		_, err = w.Write(b)
 		if err != nil {
-			log.Printf("Incomplete write %v", err)
+			requestLogger(r).Error("Incomplete write", "err", err)
 			return err
 		}
 	}
@@ -69,13 +72,13 @@ func (state *RuntimeState) createGrouphandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 	//check if user is admin or not
-	if !state.Userinfo.UserisadminOrNot(username) {
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
 		http.Error(w, "you are not authorized ", http.StatusForbidden)
 		return
 	}
 	err = r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("createGrouphandler failed", "err", err)
 		if err.Error() == "missing form body" {
 			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
 		} else {
@@ -88,10 +91,32 @@ func (state *RuntimeState) createGrouphandler(w http.ResponseWriter, r *http.Req
 	groupinfo.Description = r.PostFormValue("description")
 	members := r.PostFormValue("members")
 
+	template, message, err := state.getRequestedGroupTemplate(r.PostFormValue("template"))
+	if err != nil {
+		requestLogger(r).Error("createGrouphandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	ticketID, message := state.requestedTicketID(r.PostFormValue("ticket"))
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	if template != nil {
+		if groupinfo.Description == "" {
+			groupinfo.Description = template.ManagedBy
+		}
+		members += "," + strings.Join(template.Members, ",")
+	}
+
 	//check if the group name already exists or not.
-	groupExistsorNot, _, err := state.Userinfo.GroupnameExistsornot(groupinfo.Groupname)
+	groupExistsorNot, _, err := state.requestUserinfo(r).GroupnameExistsornot(groupinfo.Groupname)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("createGrouphandler failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
@@ -101,9 +126,9 @@ func (state *RuntimeState) createGrouphandler(w http.ResponseWriter, r *http.Req
 	}
 	//if the group managed attribute (description) isn't self-managed and thus another groupname. check if that group exists or not
 	if groupinfo.Description != descriptionAttribute {
-		descriptiongroupExistsorNot, _, err := state.Userinfo.GroupnameExistsornot(groupinfo.Description)
+		descriptiongroupExistsorNot, _, err := state.requestUserinfo(r).GroupnameExistsornot(groupinfo.Description)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("createGrouphandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -114,13 +139,15 @@ func (state *RuntimeState) createGrouphandler(w http.ResponseWriter, r *http.Req
 	}
 
 	//check if all the users to be added exists or not.
+	memberSet := make(map[string]bool)
 	for _, member := range strings.Split(members, ",") {
-		if len(member) < 1 {
+		if len(member) < 1 || memberSet[member] {
 			continue
 		}
-		userExistsorNot, err := state.Userinfo.UsernameExistsornot(member)
+		memberSet[member] = true
+		userExistsorNot, err := state.requestUserinfo(r).UsernameExistsornot(member)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("createGrouphandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -131,20 +158,45 @@ func (state *RuntimeState) createGrouphandler(w http.ResponseWriter, r *http.Req
 		groupinfo.MemberUid = append(groupinfo.MemberUid, member)
 	}
 
-	err = state.Userinfo.CreateGroup(groupinfo)
+	var gidRanges []gidRange
+	if template != nil && template.GidMin > 0 {
+		gidRanges = []gidRange{{Min: template.GidMin, Max: template.GidMax}}
+	}
+	err = state.createLDAPGroup(r, groupinfo, username, gidRanges)
 
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("createGrouphandler failed", "err", err)
+		state.recordAuditEvent(r, username, auditActionCreateGroup, groupinfo.Groupname, "", auditOutcomeFailure, err.Error())
+		if err == errGidRangesExhausted {
+			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
 		http.Error(w, "error occurred! May be group name exists or may be members are not available!", http.StatusInternalServerError)
 		return
 	}
 	if state.sysLog != nil {
 		state.sysLog.Write([]byte(fmt.Sprintf("Group "+"%s"+" was created by "+"%s", groupinfo.Groupname, username)))
 
-		for _, member := range strings.Split(members, ",") {
+		for _, member := range groupinfo.MemberUid {
 			state.sysLog.Write([]byte(fmt.Sprintf("%s"+" was added to Group "+"%s"+" by "+"%s", member, groupinfo.Groupname, username)))
 		}
 	}
+	details := "managed by " + groupinfo.Description
+	if template != nil {
+		details += ", template " + template.Name
+		err = state.applyGroupTemplateMetadata(groupinfo.Groupname, template, username)
+		if err != nil {
+			requestLogger(r).Error("cannot apply the template to the group", "template", template.Name, "group", groupinfo.Groupname, "err", err)
+		}
+	}
+	err = state.linkGroupTicket(r, groupinfo.Groupname, ticketID)
+	if err != nil {
+		requestLogger(r).Error("cannot link the group to the ticket", "ticket", ticketID, "group", groupinfo.Groupname, "err", err)
+	}
+	state.recordAuditEvent(r, username, auditActionCreateGroup, groupinfo.Groupname, "", auditOutcomeSuccess, details)
+	for _, member := range groupinfo.MemberUid {
+		state.recordAuditEvent(r, username, auditActionAddMember, groupinfo.Groupname, member, auditOutcomeSuccess, "")
+	}
 	pageData := simpleMessagePageData{
 		UserName:       username,
 		IsAdmin:        true,
@@ -165,14 +217,17 @@ func (state *RuntimeState) deleteGrouphandler(w http.ResponseWriter, r *http.Req
 	if err != nil {
 		return
 	}
-	if !state.Userinfo.UserisadminOrNot(username) {
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
 		http.Error(w, "you are not authorized", http.StatusForbidden)
 		return
 	}
+	if !state.requireRecentAuthentication(w, r) {
+		return
+	}
 
 	err = r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("deleteGrouphandler failed", "err", err)
 		if err.Error() == "missing form body" {
 			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
 		} else {
@@ -184,9 +239,9 @@ func (state *RuntimeState) deleteGrouphandler(w http.ResponseWriter, r *http.Req
 	groups := r.PostFormValue("groupnames")
 	//check if groupnames are valid or not.
 	for _, eachGroup := range strings.Split(groups, ",") {
-		groupnameExistsorNot, _, err := state.Userinfo.GroupnameExistsornot(eachGroup)
+		groupnameExistsorNot, _, err := state.requestUserinfo(r).GroupnameExistsornot(eachGroup)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("deleteGrouphandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 
@@ -195,36 +250,42 @@ func (state *RuntimeState) deleteGrouphandler(w http.ResponseWriter, r *http.Req
 			state.writeFailureResponse(w, r, fmt.Sprintf("Group %s doesn't exist!", eachGroup), http.StatusBadRequest)
 			return
 		}
+		archive, err := getGroupArchiveFromDB(eachGroup, state)
+		if err != nil {
+			requestLogger(r).Error("deleteGrouphandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
+		if archive != nil {
+			state.writeFailureResponse(w, r, fmt.Sprintf("Group %s is already archived", eachGroup), http.StatusBadRequest)
+			return
+		}
 		groupnames = append(groupnames, eachGroup)
 	}
 
-	err = state.Userinfo.DeleteGroup(groupnames)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "error occurred! May be there is no such group!", http.StatusInternalServerError)
-		return
-	}
-	if state.sysLog != nil {
-		for _, eachGroup := range groupnames {
-			state.sysLog.Write([]byte(fmt.Sprintf("Group "+"%s"+" was deleted by "+"%s", eachGroup, username)))
+	// groups are archived, they are deleted once the retention period is over
+	var deleteAfter time.Time
+	for _, eachGroup := range groupnames {
+		deleteAfter, err = state.archiveGroup(r, username, eachGroup)
+		if err != nil {
+			requestLogger(r).Error("deleteGrouphandler failed", "err", err)
+			http.Error(w, "error occurred! May be there is no such group!", http.StatusInternalServerError)
+			return
 		}
 	}
-	err = deleteEntryofGroupsInDB(groupnames, state)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
-		return
-	}
 	pageData := simpleMessagePageData{
 		UserName:       username,
 		IsAdmin:        true,
 		Title:          "Group Deletion Suucess",
-		SuccessMessage: "Group has been successfully Deleted",
+		SuccessMessage: fmt.Sprintf("Group has been archived, it can be restored until %s", deleteAfter.Format(auditDateLayout)),
+		ContinueURL:    groupArchivePath,
 	}
 	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
 
 }
 
+// createServiceAccounthandler creates the service account for admins, for
+// anybody else it files a request that an admin approves.
 func (state *RuntimeState) createServiceAccounthandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != postMethod {
 		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
@@ -234,13 +295,10 @@ func (state *RuntimeState) createServiceAccounthandler(w http.ResponseWriter, r
 	if err != nil {
 		return
 	}
-	if !state.Userinfo.UserisadminOrNot(username) {
-		http.Error(w, "you are not authorized ", http.StatusForbidden)
-		return
-	}
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	err = r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("createServiceAccounthandler failed", "err", err)
 		if err.Error() == "missing form body" {
 			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
 		} else {
@@ -254,45 +312,105 @@ func (state *RuntimeState) createServiceAccounthandler(w http.ResponseWriter, r
 	groupinfo.LoginShell = r.PostFormValue("loginShell")
 
 	if !(groupinfo.LoginShell == "/bin/false") && !(groupinfo.LoginShell == "/bin/bash") {
-		log.Println("Bad request! Not an valid LoginShell value")
+		requestLogger(r).Info("Bad request, not a valid LoginShell value")
 		http.Error(w, fmt.Sprint("Bad request! Not an valid LoginShell value"), http.StatusBadRequest)
 		return
 	}
 
-	GroupExistsornot, _, err := state.Userinfo.GroupnameExistsornot(groupinfo.Groupname)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+	ownerGroup := strings.TrimSpace(r.PostFormValue("ownerGroup"))
+	justification := strings.TrimSpace(r.PostFormValue("justification"))
+	if !isAdmin && (ownerGroup == "" || justification == "") {
+		http.Error(w, "Bad request! An owner group and a justification are required", http.StatusBadRequest)
 		return
 	}
-	if GroupExistsornot {
-		log.Println("Bad request! A group already exists with that name!")
-		http.Error(w, fmt.Sprint("Bad request! A group already exists with that name!"), http.StatusBadRequest)
-		return
+	if ownerGroup != "" {
+		ownerGroupExists, _, err := state.requestUserinfo(r).GroupnameExistsornot(ownerGroup)
+		if err != nil {
+			requestLogger(r).Error("createServiceAccounthandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
+		// requested owner groups are created on approval
+		if !ownerGroupExists && isAdmin {
+			http.Error(w, fmt.Sprintf("Bad request! Owner group %s does not exist", ownerGroup), http.StatusBadRequest)
+			return
+		}
+		if ownerGroupExists && !isAdmin {
+			isMember, _, err := state.requestUserinfo(r).IsgroupmemberorNot(ownerGroup, username)
+			if err != nil {
+				requestLogger(r).Error("createServiceAccounthandler failed", "err", err)
+				http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+				return
+			}
+			if !isMember {
+				http.Error(w, fmt.Sprintf("Bad request! You are not a member of %s", ownerGroup), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	reviewBy := time.Now().AddDate(0, 0, state.Config.ServiceAccounts.reviewPeriod())
+	if reviewDate := r.PostFormValue("reviewDate"); reviewDate != "" {
+		reviewBy, err = time.ParseInLocation(auditDateLayout, reviewDate, time.Local)
+		if err != nil || !reviewBy.After(time.Now()) {
+			http.Error(w, fmt.Sprintf("Bad request! Invalid review date '%s'", reviewDate), http.StatusBadRequest)
+			return
+		}
 	}
 
-	serviceAccountExists, _, err := state.Userinfo.ServiceAccountExistsornot(groupinfo.Groupname)
+	message, err := state.checkServiceAccountName(groupinfo.Groupname)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("createServiceAccounthandler failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
-	if serviceAccountExists {
-		log.Println("Service Account already exists!")
-		http.Error(w, fmt.Sprint("Service Account already exists!"), http.StatusBadRequest)
+	if message != "" {
+		requestLogger(r).Info(message)
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
 		return
 	}
 
-	err = state.Userinfo.CreateServiceAccount(groupinfo)
+	if !isAdmin {
+		request := serviceAccountRequest{
+			AccountName:   groupinfo.Groupname,
+			Mail:          groupinfo.Mail,
+			LoginShell:    groupinfo.LoginShell,
+			OwnerGroup:    ownerGroup,
+			ReviewBy:      reviewBy,
+			Justification: justification,
+			RequestedBy:   username,
+			Timestamp:     time.Now(),
+		}
+		err = state.fileServiceAccountRequest(r, request)
+		if err != nil {
+			if err == errServiceAccountAlreadyRequested {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			requestLogger(r).Error("createServiceAccounthandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
+		pageData := simpleMessagePageData{
+			UserName:       username,
+			IsAdmin:        false,
+			Title:          "Service Account Requested",
+			SuccessMessage: fmt.Sprintf("Your request for service account %s is waiting for an admin approval", groupinfo.Groupname),
+			ContinueURL:    serviceAccountsPath,
+		}
+		state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+		return
+	}
 
+	err = state.createServiceAccount(r, username, username, groupinfo, ownerGroup, reviewBy)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("createServiceAccounthandler failed", "err", err)
+		if err == errServiceAccountNotRecorded {
+			http.Error(w, "Service Account created but its review date could not be stored", http.StatusInternalServerError)
+			return
+		}
 		http.Error(w, "error occurred! May be group name exists or may be members are not available!", http.StatusInternalServerError)
 		return
 	}
-	if state.sysLog != nil {
-		state.sysLog.Write([]byte(fmt.Sprintf("Service account "+"%s"+" was created by "+"%s", groupinfo.Groupname, username)))
-	}
 	pageData := simpleMessagePageData{
 		UserName:       username,
 		IsAdmin:        true,
@@ -311,14 +429,17 @@ func (state *RuntimeState) changeownership(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		return
 	}
-	if !state.Userinfo.UserisadminOrNot(username) {
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
 		http.Error(w, "you are not authorized", http.StatusForbidden)
 		return
 	}
+	if !state.requireRecentAuthentication(w, r) {
+		return
+	}
 
 	err = r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("changeownership failed", "err", err)
 		if err.Error() == "missing form body" {
 			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
 		} else {
@@ -346,15 +467,17 @@ func (state *RuntimeState) changeownership(w http.ResponseWriter, r *http.Reques
 		if err != nil {
 			return
 		}
-		err = state.Userinfo.ChangeDescription(group, managegroup)
+		err = state.requestUserinfo(r).ChangeDescription(group, managegroup)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("changeownership failed", "err", err)
+			state.recordAuditEvent(r, username, auditActionChangeOwnership, group, "", auditOutcomeFailure, err.Error())
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
 		if state.sysLog != nil {
 			state.sysLog.Write([]byte(fmt.Sprintf("Group %s is managed by %s now, this change was made by %s.", group, managegroup, username)))
 		}
+		state.recordAuditEvent(r, username, auditActionChangeOwnership, group, "", auditOutcomeSuccess, "managed by "+managegroup)
 		donecount += 1
 	}
 	if donecount == 0 {
@@ -380,6 +503,13 @@ document.addEventListener('DOMContentLoaded', function () {
 });
 `
 
+// The group tables read their pages from the table URL filled in below.
+const getGroupsJSTablePagesText = `
+document.addEventListener('DOMContentLoaded', function () {
+                RequestAccess(null, tablePages(%s, [null, 'groupname', 'managed_by', null], array));
+});
+`
+
 const getGroupsJSPendingActionsText = `
 document.addEventListener('DOMContentLoaded', function () {
 
@@ -406,6 +536,31 @@ document.addEventListener('DOMContentLoaded', function () {
 });
 `
 
+// getGroupsManagedByUser returns the groups managed by a group of the user.
+func (state *RuntimeState) getGroupsManagedByUser(r *http.Request, username string) ([][]string, error) {
+	allGroups, err := state.requestUserinfo(r).GetAllGroupsManagedBy()
+	if err != nil {
+		return nil, err
+	}
+	userGroups, err := state.requestUserinfo(r).GetgroupsofUser(username)
+	if err != nil {
+		return nil, err
+	}
+	userGroupMap := make(map[string]interface{})
+	for _, groupName := range userGroups {
+		userGroupMap[groupName] = nil
+	}
+	var groups [][]string
+	for _, groupTuple := range allGroups {
+		managingGroup := groupTuple[1]
+		_, ok := userGroupMap[managingGroup]
+		if ok {
+			groups = append(groups, groupTuple)
+		}
+	}
+	return groups, nil
+}
+
 type groupsJSONData struct {
 	Groups [][]string
 }
@@ -423,23 +578,28 @@ func (state *RuntimeState) getGroupsJSHandler(w http.ResponseWriter, r *http.Req
 	var groupsToSend [][]string
 	switch r.FormValue("type") {
 	case "all":
-		groupsToSend, err = state.Userinfo.GetAllGroupsManagedBy()
+		if r.FormValue("encoding") != "json" {
+			state.writeTablePagesJS(w, getGroupsJSTablePagesText, allGroupsTablePath,
+				url.Values{"tag": {r.FormValue("tag")}})
+			return
+		}
+		groupsToSend, err = state.requestUserinfo(r).GetAllGroupsManagedBy()
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
 	case "pendingRequests":
 		groupsToSend, err = state.getPendingRequestGroupsofUser(username)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
 	case "allNoManager":
-		allgroups, err := state.Userinfo.GetallGroups()
+		allgroups, err := state.requestUserinfo(r).GetallGroups()
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -451,43 +611,55 @@ func (state *RuntimeState) getGroupsJSHandler(w http.ResponseWriter, r *http.Req
 
 			groupsToSend, err = state.getUserPendingActions(username)
 			if err != nil {
-				log.Println(err)
+				requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
 				http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 				return
 			}
 		}
 	case "managedByMe":
-		allGroups, err := state.Userinfo.GetAllGroupsManagedBy()
-		if err != nil {
-			log.Println(err)
-			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		if r.FormValue("encoding") != "json" {
+			state.writeTablePagesJS(w, getGroupsJSTablePagesText, managedGroupsTablePath,
+				url.Values{"tag": {r.FormValue("tag")}})
 			return
 		}
-		userGroups, err := state.Userinfo.GetgroupsofUser(username)
+		groupsToSend, err = state.getGroupsManagedByUser(r, username)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
-		userGroupMap := make(map[string]interface{})
-		for _, groupName := range userGroups {
-			userGroupMap[groupName] = nil
+	default:
+		if r.FormValue("encoding") != "json" {
+			state.writeTablePagesJS(w, getGroupsJSTablePagesText, myGroupsTablePath,
+				url.Values{"tag": {r.FormValue("tag")}})
+			return
 		}
-		for _, groupTuple := range allGroups {
-			managingGroup := groupTuple[1]
-			_, ok := userGroupMap[managingGroup]
-			if ok {
-				groupsToSend = append(groupsToSend, groupTuple)
-			}
+		groupsToSend, err = state.requestUserinfo(r).GetGroupsInfoOfUser(state.Config.TargetLDAP.GroupSearchBaseDNs, username)
+		if err != nil {
+			requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+			return
 		}
+	}
+	switch r.FormValue("type") {
+	case "pendingRequests", "pendingActions":
 	default:
-
-		groupsToSend, err = state.Userinfo.GetGroupsInfoOfUser(state.Config.TargetLDAP.GroupSearchBaseDNs, username)
+		keep, err := state.listedGroupFilter(r.FormValue("tag"))
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
+		if r.FormValue("type") == "allNoManager" {
+			groupsToSend = [][]string{filterGroupNames(groupsToSend[0], keep)}
+		} else {
+			groupsToSend, err = state.appendMemberCounts(filterGroupTuples(groupsToSend, keep))
+			if err != nil {
+				requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
+				http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+				return
+			}
+		}
 	}
 	switch r.FormValue("encoding") {
 	case "json":
@@ -496,7 +668,7 @@ func (state *RuntimeState) getGroupsJSHandler(w http.ResponseWriter, r *http.Req
 		groupsJSON := groupsJSONData{Groups: groupsToSend}
 		err = json.NewEncoder(w).Encode(groupsJSON)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -504,7 +676,7 @@ func (state *RuntimeState) getGroupsJSHandler(w http.ResponseWriter, r *http.Req
 	default:
 		encodedGroups, err := json.Marshal(groupsToSend)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getGroupsJSHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -540,6 +712,13 @@ document.addEventListener('DOMContentLoaded', function () {
 });
 `
 
+// The members table reads its pages from the table URL filled in below.
+const getUsersGroupJSPagesText = `
+document.addEventListener('DOMContentLoaded', function () {
+                Group_Info(null, tablePages(%s, ['username'], pagedUsers));
+});
+`
+
 const getUsersGroupJSText = `
 document.addEventListener('DOMContentLoaded', function () {
                 var groupUsers = %s;
@@ -559,7 +738,7 @@ func (state *RuntimeState) getUsersJSHandler(w http.ResponseWriter, r *http.Requ
 	}
 	_, err := state.GetRemoteUserName(w, r)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("getUsersJSHandler failed", "err", err)
 		return
 	}
 	outputText := getUsersJSText
@@ -568,13 +747,18 @@ func (state *RuntimeState) getUsersJSHandler(w http.ResponseWriter, r *http.Requ
 	case "group":
 		groupName := r.FormValue("groupName")
 		if groupName == "" {
-			log.Printf("No groupName found")
+			requestLogger(r).Info("No groupName found")
 			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
 			return
 		}
-		usersToSend, _, err = state.Userinfo.GetusersofaGroup(groupName)
+		if r.FormValue("encoding") != "json" {
+			state.writeTablePagesJS(w, getUsersGroupJSPagesText, groupMembersTablePath,
+				url.Values{"groupname": {groupName}})
+			return
+		}
+		usersToSend, _, err = state.requestUserinfo(r).GetusersofaGroup(groupName)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getUsersJSHandler failed", "err", err)
 			if err == userinfo.GroupDoesNotExist {
 				http.Error(w, fmt.Sprint("Group doesn't exist!"), http.StatusBadRequest)
 				return
@@ -586,14 +770,14 @@ func (state *RuntimeState) getUsersJSHandler(w http.ResponseWriter, r *http.Requ
 
 	default:
 		if r.FormValue("encoding") == "json" {
-			usersToSend, err = state.Userinfo.GetallUsers()
+			usersToSend, err = state.requestUserinfo(r).GetallUsers()
 			if err != nil {
-				log.Println(err)
+				requestLogger(r).Error("getUsersJSHandler failed", "err", err)
 				http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 				return
 			}
 		} else {
-			go state.Userinfo.GetallUsers()
+			go state.requestUserinfo(r).GetallUsers()
 		}
 	}
 	sort.Strings(usersToSend)
@@ -604,7 +788,7 @@ func (state *RuntimeState) getUsersJSHandler(w http.ResponseWriter, r *http.Requ
 		usersJSON := usersJSONData{Users: usersToSend}
 		err = json.NewEncoder(w).Encode(usersJSON)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getUsersJSHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -612,7 +796,7 @@ func (state *RuntimeState) getUsersJSHandler(w http.ResponseWriter, r *http.Requ
 	default:
 		encodedUsers, err := json.Marshal(usersToSend)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("getUsersJSHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -42,8 +43,26 @@ func testCreateValidAdminCookie(authenticator *authn.Authenticator) http.Cookie
 }
 
 func setupTestState() (RuntimeState, error) {
+	return setupTestStateWithStorage(testdbpath)
+}
+
+// setupTestStateWithOwnDB gives the test a database of its own, for the
+// tests that check the exact rows of the tables.
+func setupTestStateWithOwnDB(t *testing.T) (RuntimeState, error) {
+	return setupTestStateWithStorage("sqlite:" + filepath.Join(t.TempDir(), "test-sqlite3.db"))
+}
+
+// useSynchronizedDirectory replaces the mock directory with one safe for
+// the goroutines the handlers start.
+func useSynchronizedDirectory(state *RuntimeState) {
+	directory := mock.NewSynchronized(mock.New())
+	state.Userinfo = directory
+	state.UserSourceinfo = directory
+}
+
+func setupTestStateWithStorage(storageURL string) (RuntimeState, error) {
 	var state RuntimeState
-	state.Config.Base.StorageURL = testdbpath
+	state.Config.Base.StorageURL = storageURL
 	err := initDB(&state)
 	if err != nil {
 		return state, err
@@ -54,8 +73,7 @@ func setupTestState() (RuntimeState, error) {
 	state.allUsersCacheValue = make(map[string]time.Time)
 	state.pendingUserActionsCache = make(map[string]pendingUserActionsCacheEntry)
 	state.authenticator = authn.NewAuthenticator(state.Config.OpenID, "smallpoint", nil,
-		[]string{}, nil,
-		nil)
+		[]string{}, nil)
 	//state.authenticator.SetExplicitAuthCookie(cookievalueTest, testUsername)
 	//state.authenticator.SetExplicitAuthCookie(adminCookievalueTest, adminTestusername)
 
@@ -81,7 +99,6 @@ func getAdminOnlyEndpoints(state *RuntimeState) map[string]http.HandlerFunc {
 	adminOnlyApiEndpoints := map[string]http.HandlerFunc{
 		creategroupPath:           state.createGrouphandler,
 		deletegroupPath:           state.deleteGrouphandler,
-		createServiceAccountPath:  state.createServiceAccounthandler,
 		changeownershipbuttonPath: state.changeownership,
 	}
 	return adminOnlyApiEndpoints
@@ -100,7 +117,7 @@ func TestMethodsForApiEndPoints(t *testing.T) {
 			t.Fatal(err)
 		}
 		//cookie := testCreateValidCookie()
-		req.AddCookie(&cookie)
+		testAddAuthCookie(req, state.authenticator, cookie)
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(testFunc)
@@ -128,7 +145,7 @@ func TestAdminOnlyAuthnEndpoints(t *testing.T) {
 			t.Fatal(err)
 		}
 		//cookie := testCreateValidCookie()
-		req.AddCookie(&cookie)
+		testAddAuthCookie(req, state.authenticator, cookie)
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(testFunc)
@@ -149,7 +166,7 @@ func TestAdminOnlyAuthnEndpoints(t *testing.T) {
 			t.Fatal(err)
 		}
 		//cookie := testCreateValidCookie()
-		req.AddCookie(&adminCookie)
+		testAddAuthCookie(req, state.authenticator, adminCookie)
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(testFunc)
@@ -167,7 +184,7 @@ func TestAdminOnlyAuthnEndpoints(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		req.AddCookie(&adminCookie)
+		testAddAuthCookie(req, state.authenticator, adminCookie)
 		req.Header.Set("Referer", "https://evilsite.com")
 
 		rr := httptest.NewRecorder()
@@ -194,7 +211,7 @@ func TestCreateGrouphandlerSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidAdminCookie(state.authenticator)
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	rr := httptest.NewRecorder()
@@ -210,7 +227,7 @@ func TestCreateGrouphandlerSuccess(t *testing.T) {
 }
 
 func TestCreateDrouphandlerSuccess(t *testing.T) {
-	state, err := setupTestState()
+	state, err := setupTestStateWithOwnDB(t)
 	if err != nil {
 		log.Println(err)
 	}
@@ -221,7 +238,7 @@ func TestCreateDrouphandlerSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidAdminCookie(state.authenticator)
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	rr := httptest.NewRecorder()
@@ -247,7 +264,7 @@ func TestCreateServiceAccounthandlerSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidAdminCookie(state.authenticator)
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	rr := httptest.NewRecorder()
@@ -273,7 +290,7 @@ func TestChangeownershipSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidAdminCookie(state.authenticator)
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	rr := httptest.NewRecorder()
@@ -299,7 +316,7 @@ func TestGetGroupsJSHandler(t *testing.T) {
 			t.Fatal(err)
 		}
 		cookie := testCreateValidCookie(state.authenticator)
-		req.AddCookie(&cookie)
+		testAddAuthCookie(req, state.authenticator, cookie)
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(state.getGroupsJSHandler)
@@ -325,7 +342,7 @@ func TestGetUsersJSHandler(t *testing.T) {
 			t.Fatal(err)
 		}
 		cookie := testCreateValidCookie(state.authenticator)
-		req.AddCookie(&cookie)
+		testAddAuthCookie(req, state.authenticator, cookie)
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(state.getUsersJSHandler)
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Actions recorded in the audit log. Every state change performed by
+// smallpoint, either in LDAP or in the pending requests DB, must map to one
+// of these.
+const (
+	auditActionCreateGroup          = "create_group"
+	auditActionDeleteGroup          = "delete_group"
+	auditActionChangeOwnership      = "change_ownership"
+	auditActionAddMember            = "add_member"
+	auditActionRemoveMember         = "remove_member"
+	auditActionExitGroup            = "exit_group"
+	auditActionRequestAccess        = "request_access"
+	auditActionCancelRequest        = "cancel_request"
+	auditActionApproveRequest       = "approve_request"
+	auditActionRejectRequest        = "reject_request"
+	auditActionCreateServiceAccount = "create_service_account"
+	auditActionCreateUser           = "create_user"
+	auditActionExpireRequest        = "expire_request"
+
+	auditActionReviewServiceAccount           = "review_service_account"
+	auditActionDisableServiceAccount          = "disable_service_account"
+	auditActionChangeServiceAccountOwner      = "change_service_account_owner"
+	auditActionRequestServiceAccountTakeover  = "request_service_account_takeover"
+	auditActionRejectServiceAccountTakeover   = "reject_service_account_takeover"
+	auditActionRotateServiceAccountCredential = "rotate_service_account_credential"
+	auditActionSetGroupClassification         = "set_group_classification"
+	auditActionRequestServiceAccountAction    = "request_service_account_action"
+	auditActionRejectServiceAccountAction     = "reject_service_account_action"
+	auditActionScheduleServiceAccountDeletion = "schedule_service_account_deletion"
+	auditActionRestoreServiceAccount          = "restore_service_account"
+	auditActionDeleteServiceAccount           = "delete_service_account"
+	auditActionUpdateServiceAccountMetadata   = "update_service_account_metadata"
+	auditActionImportServiceAccount           = "import_service_account"
+	auditActionRequestServiceAccount          = "request_service_account"
+	auditActionRejectServiceAccountRequest    = "reject_service_account_request"
+	auditActionUpdateGroupMetadata            = "update_group_metadata"
+	auditActionSetGroupTags                   = "set_group_tags"
+	auditActionUpdateGroupTemplate            = "update_group_template"
+	auditActionSetGroupMail                   = "set_group_mail"
+	auditActionArchiveGroup                   = "archive_group"
+	auditActionRestoreGroup                   = "restore_group"
+	auditActionRenameGroup                    = "rename_group"
+	auditActionMergeGroup                     = "merge_group"
+	auditActionUndoRemoveMember               = "undo_remove_member"
+	auditActionUpdateGitHubTeamMapping        = "update_github_team_mapping"
+	auditActionUpdateOktaGroupPush            = "update_okta_group_push"
+	auditActionHoldChange                     = "hold_change"
+	auditActionRejectHeldChange               = "reject_held_change"
+)
+
+var auditActions = []string{auditActionCreateGroup, auditActionDeleteGroup,
+	auditActionChangeOwnership, auditActionAddMember, auditActionRemoveMember,
+	auditActionExitGroup, auditActionRequestAccess, auditActionCancelRequest,
+	auditActionApproveRequest, auditActionRejectRequest,
+	auditActionCreateServiceAccount, auditActionCreateUser, auditActionExpireRequest,
+	auditActionReviewServiceAccount, auditActionDisableServiceAccount,
+	auditActionChangeServiceAccountOwner, auditActionRequestServiceAccountTakeover,
+	auditActionRejectServiceAccountTakeover, auditActionRotateServiceAccountCredential,
+	auditActionSetGroupClassification, auditActionRequestServiceAccountAction,
+	auditActionRejectServiceAccountAction, auditActionScheduleServiceAccountDeletion,
+	auditActionRestoreServiceAccount, auditActionDeleteServiceAccount,
+	auditActionUpdateServiceAccountMetadata, auditActionImportServiceAccount,
+	auditActionRequestServiceAccount, auditActionRejectServiceAccountRequest,
+	auditActionUpdateGroupMetadata, auditActionSetGroupTags, auditActionUpdateGroupTemplate,
+	auditActionSetGroupMail, auditActionArchiveGroup, auditActionRestoreGroup,
+	auditActionRenameGroup, auditActionMergeGroup, auditActionUndoRemoveMember,
+	auditActionUpdateGitHubTeamMapping, auditActionUpdateOktaGroupPush, auditActionHoldChange,
+	auditActionRejectHeldChange}
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+)
+
+type auditConfig struct {
+	// Members of this group can browse the audit log in addition to the
+	// super admins.
+	AuditorsGroup string            `yaml:"auditors_group"`
+	Syslog        auditSyslogConfig `yaml:"syslog"`
+	// Sign each audit chain entry with the server TLS key.
+	SignChain bool `yaml:"sign_chain"`
+}
+
+const auditLogMaxWebEntries = 1000
+const auditDateLayout = "2006-01-02"
+
+type auditEvent struct {
+	ID         int64     `json:"id,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	Groupname  string    `json:"group"`
+	Username   string    `json:"user"`
+	RemoteAddr string    `json:"remote_addr"`
+	Outcome    string    `json:"outcome"`
+	Details    string    `json:"details"`
+}
+
+// The audit log is append only, there are intentionally no update or delete
+// statements for this table.
+var insertAuditEventStmt = map[string]string{
+	"sqlite":   "insert into audit_log(time_stamp, actor, action, groupname, username, remote_addr, outcome, details) values (?,?,?,?,?,?,?,?);",
+	"postgres": "insert into audit_log(time_stamp, actor, action, groupname, username, remote_addr, outcome, details) values ($1,$2,$3,$4,$5,$6,$7,$8) returning id;",
+}
+
+// insertAuditEventInDB stores the event and its audit_chain entry in a single
+// transaction, so that no event can be stored without being chained.
+func insertAuditEventInDB(event auditEvent, state *RuntimeState) error {
+	start := time.Now()
+	state.auditChainMutex.Lock()
+	defer state.auditChainMutex.Unlock()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if state.dbType == "postgres" {
+		// serialize writers across all smallpoint instances
+		_, err = tx.Exec("lock table audit_chain in exclusive mode;")
+		if err != nil {
+			return err
+		}
+	}
+	stmtText := insertAuditEventStmt[state.dbType]
+	values := []interface{}{event.Timestamp.Unix(), event.Actor, event.Action, event.Groupname,
+		event.Username, event.RemoteAddr, event.Outcome, event.Details}
+	if state.dbType == "postgres" {
+		err = tx.QueryRow(stmtText, values...).Scan(&event.ID)
+		if err != nil {
+			return err
+		}
+	} else {
+		result, err := tx.Exec(stmtText, values...)
+		if err != nil {
+			return err
+		}
+		event.ID, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+	}
+	err = state.appendAuditChainEntry(tx, event)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// remoteIPFromRequest returns the source address of the request without the
+// port, r can be nil for changes not triggered by an http request.
+func remoteIPFromRequest(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recordAuditEvent stores a single audit event and forwards it to the syslog
+// sink when configured. Failures to write the audit record are logged but do
+// not undo the already performed change.
+func (state *RuntimeState) recordAuditEvent(r *http.Request, actor, action, groupname, username, outcome, details string) error {
+	event := auditEvent{
+		Timestamp:  time.Now(),
+		Actor:      actor,
+		Action:     action,
+		Groupname:  groupname,
+		Username:   username,
+		RemoteAddr: remoteIPFromRequest(r),
+		Outcome:    outcome,
+		Details:    details,
+	}
+	if state.isDryRun(r) {
+		event.Details = strings.TrimSuffix("dry run, "+details, ", ")
+	}
+	if state.auditSink != nil {
+		state.auditSink.Emit(event)
+	}
+	// the dry runs change nothing downstream either
+	if !state.isDryRun(r) {
+		go func() {
+			err := state.notifyMailingListChange(event)
+			if err != nil {
+				requestLogger(r).Error("cannot notify the mail system of the audit event", "event", event, "err", err)
+			}
+		}()
+		err := state.enqueueSCIMPushes(event)
+		if err != nil {
+			slog.Error("cannot queue the SCIM pushes of the audit event", "event", event, "err", err)
+		}
+		if event.IsMembershipChange() {
+			state.groupSyncs.changed(event.Groupname)
+		}
+		state.ticketingAuditEvent(event)
+	}
+	err := insertAuditEventInDB(event, state)
+	if err != nil {
+		requestLogger(r).Error("failed to store audit event", "event", event, "err", err)
+		return err
+	}
+	return nil
+}
+
+type auditEventFilter struct {
+	Actor     string
+	Groupname string
+	// FormerGroupnames match the events recorded before the group was
+	// renamed.
+	FormerGroupnames []string
+	Username         string
+	Action           string
+	From             time.Time
+	To               time.Time
+	Limit            int
+}
+
+func (filter auditEventFilter) sqlWhereClause(dbType string) (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	placeholder := func(value interface{}) string {
+		args = append(args, value)
+		if dbType == "postgres" {
+			return fmt.Sprintf("$%d", len(args))
+		}
+		return "?"
+	}
+	addClause := func(column string, operator string, value interface{}) {
+		clauses = append(clauses, column+operator+placeholder(value))
+	}
+	if filter.Actor != "" {
+		addClause("actor", "=", filter.Actor)
+	}
+	if filter.Groupname != "" {
+		groupClauses := []string{"groupname=" + placeholder(filter.Groupname)}
+		for _, groupname := range filter.FormerGroupnames {
+			groupClauses = append(groupClauses, "groupname="+placeholder(groupname))
+		}
+		clauses = append(clauses, "("+strings.Join(groupClauses, " or ")+")")
+	}
+	if filter.Username != "" {
+		addClause("username", "=", filter.Username)
+	}
+	if filter.Action != "" {
+		addClause("action", "=", filter.Action)
+	}
+	if !filter.From.IsZero() {
+		addClause("time_stamp", ">=", filter.From.Unix())
+	}
+	if !filter.To.IsZero() {
+		addClause("time_stamp", "<", filter.To.Unix())
+	}
+	if len(clauses) == 0 {
+		return "", args
+	}
+	return " where " + strings.Join(clauses, " and "), args
+}
+
+func searchAuditEventsInDB(filter auditEventFilter, state *RuntimeState) ([]auditEvent, error) {
+	start := time.Now()
+	whereClause, args := filter.sqlWhereClause(state.dbType)
+	query := "select id, time_stamp, actor, action, groupname, username, remote_addr, outcome, details from audit_log" +
+		whereClause + " order by id desc"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" limit %d", filter.Limit)
+	}
+	rows, err := state.db.Query(query+";", args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var events []auditEvent
+	for rows.Next() {
+		var event auditEvent
+		var timeStamp int64
+		err = rows.Scan(&event.ID, &timeStamp, &event.Actor, &event.Action, &event.Groupname,
+			&event.Username, &event.RemoteAddr, &event.Outcome, &event.Details)
+		if err != nil {
+			return nil, err
+		}
+		event.Timestamp = time.Unix(timeStamp, 0)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// parseAuditEventFilter reads the filter from the query string, dates are
+// inclusive and expressed as YYYY-MM-DD.
+func parseAuditEventFilter(r *http.Request) (auditEventFilter, error) {
+	q := r.URL.Query()
+	filter := auditEventFilter{
+		Actor:     strings.TrimSpace(q.Get("actor")),
+		Groupname: strings.TrimSpace(q.Get("groupname")),
+		Action:    q.Get("action"),
+	}
+	var err error
+	filter.From, filter.To, err = parseAuditDateRange(q.Get("from"), q.Get("to"))
+	return filter, err
+}
+
+// parseAuditDateRange returns the bounds of the inclusive YYYY-MM-DD dates,
+// the end is the start of the day after to, an empty date is unbounded.
+func parseAuditDateRange(from string, to string) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+	if from != "" {
+		start, err = time.ParseInLocation(auditDateLayout, from, time.Local)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid from date '%s'", from)
+		}
+	}
+	if to != "" {
+		end, err = time.ParseInLocation(auditDateLayout, to, time.Local)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid to date '%s'", to)
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end, nil
+}
+
+func (state *RuntimeState) isAuditor(username string) (bool, error) {
+	if state.Userinfo.UserisadminOrNot(username) {
+		return true, nil
+	}
+	auditorsGroup := state.Config.Audit.AuditorsGroup
+	if auditorsGroup == "" {
+		return false, nil
+	}
+	isMember, _, err := state.Userinfo.IsgroupmemberorNot(auditorsGroup, username)
+	if err != nil {
+		if err == userinfo.GroupDoesNotExist {
+			slog.Warn("auditors group does not exist", "group", auditorsGroup)
+			return false, nil
+		}
+		return false, err
+	}
+	return isMember, nil
+}
+
+func writeAuditEventsCSV(w http.ResponseWriter, events []auditEvent) error {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"audit_log.csv\"")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	return writeAuditCSV(w, events)
+}
+
+func writeAuditCSV(out io.Writer, events []auditEvent) error {
+	csvWriter := csv.NewWriter(out)
+	err := csvWriter.Write([]string{"id", "time", "actor", "action", "group", "user",
+		"remote_addr", "outcome", "details"})
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		err = csvWriter.Write([]string{strconv.FormatInt(event.ID, 10),
+			event.Timestamp.UTC().Format(time.RFC3339), event.Actor, event.Action,
+			event.Groupname, event.Username, event.RemoteAddr, event.Outcome, event.Details})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func (state *RuntimeState) auditLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	isAuditor, err := state.isAuditor(username)
+	if err != nil {
+		requestLogger(r).Error("auditLogHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+	if !isAuditor {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	filter, err := parseAuditEventFilter(r)
+	if err != nil {
+		state.writeFailureResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Groupname != "" {
+		filter.FormerGroupnames, err = state.getGroupFormerNames(filter.Groupname)
+		if err != nil {
+			requestLogger(r).Error("auditLogHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if r.URL.Query().Get("format") == "csv" {
+		events, err := searchAuditEventsInDB(filter, state)
+		if err != nil {
+			requestLogger(r).Error("auditLogHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		err = writeAuditEventsCSV(w, events)
+		if err != nil {
+			requestLogger(r).Error("failed to write the csv", "err", err)
+		}
+		return
+	}
+	filter.Limit = auditLogMaxWebEntries
+	events, err := searchAuditEventsInDB(filter, state)
+	if err != nil {
+		requestLogger(r).Error("auditLogHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	csvQuery := r.URL.Query()
+	csvQuery.Set("format", "csv")
+	pageData := auditLogPageData{
+		UserName:     username,
+		IsAdmin:      state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:        "Audit Log",
+		Actions:      auditActions,
+		Actor:        filter.Actor,
+		Groupname:    filter.Groupname,
+		Action:       filter.Action,
+		From:         r.URL.Query().Get("from"),
+		To:           r.URL.Query().Get("to"),
+		Events:       events,
+		Truncated:    len(events) >= auditLogMaxWebEntries,
+		CSVExportURL: auditLogPath + "?" + csvQuery.Encode(),
+	}
+	state.renderTemplateOrReturnJson(w, r, "auditLogPage", pageData)
+}
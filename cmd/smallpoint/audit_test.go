@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testCountAuditEvents(t *testing.T, state *RuntimeState, action, groupname string) int {
+	var count int
+	query := "select count(*) from audit_log where action=? and groupname=?;"
+	if state.dbType == "postgres" {
+		query = "select count(*) from audit_log where action=$1 and groupname=$2;"
+	}
+	err := state.db.QueryRow(query, action, groupname).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestRecordAuditEvent(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := testCountAuditEvents(t, &state, auditActionAddMember, "audit-test-group")
+	req, err := http.NewRequest("POST", addmembersbuttonPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.10:4567"
+	err = state.recordAuditEvent(req, "user1", auditActionAddMember, "audit-test-group", "user2", auditOutcomeSuccess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := testCountAuditEvents(t, &state, auditActionAddMember, "audit-test-group")
+	if after != before+1 {
+		t.Fatalf("audit event not recorded before=%d after=%d", before, after)
+	}
+	if remoteIPFromRequest(req) != "192.0.2.10" {
+		t.Fatalf("bad remote ip %s", remoteIPFromRequest(req))
+	}
+	if remoteIPFromRequest(nil) != "" {
+		t.Fatal("nil request should have no remote ip")
+	}
+}
+
+func TestCreateGroupIsAudited(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := testCountAuditEvents(t, &state, auditActionCreateGroup, "audited-group")
+	formValues := url.Values{"groupname": {"audited-group"}, "description": {"group1"}, "members": {"user1"}}
+	req, err := http.NewRequest("POST", creategroupPath, strings.NewReader(formValues.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidAdminCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(state.createGrouphandler)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	after := testCountAuditEvents(t, &state, auditActionCreateGroup, "audited-group")
+	if after != before+1 {
+		t.Fatalf("group creation not audited before=%d after=%d", before, after)
+	}
+}
+
+func TestAuditLogHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.recordAuditEvent(nil, "user1", auditActionDeleteGroup, "audit-log-group", "", auditOutcomeSuccess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// non admins cannot see the log
+	req, err := http.NewRequest("GET", auditLogPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.auditLogHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	req, err = http.NewRequest("GET", auditLogPath+"?groupname=audit-log-group&format=csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminCookie := testCreateValidAdminCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, adminCookie)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(state.auditLogHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("bad content type %s", rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Body.String(), "audit-log-group") {
+		t.Fatalf("csv missing filtered event: %s", rr.Body.String())
+	}
+
+	req, err = http.NewRequest("GET", auditLogPath+"?from=not-a-date", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAddAuthCookie(req, state.authenticator, adminCookie)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(state.auditLogHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Every audit_log row has a matching audit_chain row holding the hash of the
+// previous entry and the hash of the row itself. Editing, removing or
+// reordering rows breaks the chain, which is detected by verifyAuditChain.
+
+var lastAuditChainHashStmt = "select hash from audit_chain order by audit_id desc limit 1;"
+
+var insertAuditChainStmt = map[string]string{
+	"sqlite":   "insert into audit_chain(audit_id, prev_hash, hash, signature) values (?,?,?,?);",
+	"postgres": "insert into audit_chain(audit_id, prev_hash, hash, signature) values ($1,$2,$3,$4);",
+}
+
+func writeAuditChainField(h hash.Hash, field string) {
+	fmt.Fprintf(h, "%d:%s;", len(field), field)
+}
+
+// auditEventChainHash returns the hex encoded sha256 of the previous hash and
+// all the stored fields of the event. Fields are length prefixed so that
+// moving content between fields changes the hash.
+func auditEventChainHash(prevHash string, event auditEvent) string {
+	h := sha256.New()
+	for _, field := range []string{prevHash, strconv.FormatInt(event.ID, 10),
+		strconv.FormatInt(event.Timestamp.Unix(), 10), event.Actor, event.Action,
+		event.Groupname, event.Username, event.RemoteAddr, event.Outcome, event.Details} {
+		writeAuditChainField(h, field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func signAuditChainHash(signer crypto.Signer, chainHash string) (string, error) {
+	digest, err := hex.DecodeString(chainHash)
+	if err != nil {
+		return "", err
+	}
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		opts = crypto.Hash(0)
+	}
+	signature, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func verifyAuditChainSignature(publicKey crypto.PublicKey, chainHash string, signature string) error {
+	digest, err := hex.DecodeString(chainHash)
+	if err != nil {
+		return err
+	}
+	rawSignature, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, rawSignature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, rawSignature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, digest, rawSignature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+// appendAuditChainEntry must be called within the transaction that inserted
+// the event, event.ID must already be set.
+func (state *RuntimeState) appendAuditChainEntry(tx *instrumentedTx, event auditEvent) error {
+	var prevHash string
+	err := tx.QueryRow(lastAuditChainHashStmt).Scan(&prevHash)
+	if err == sql.ErrNoRows {
+		// the chain is empty or has been completely pruned
+		prevHash, err = lastPrunedAuditChainHash(tx)
+	}
+	if err != nil {
+		return err
+	}
+	chainHash := auditEventChainHash(prevHash, event)
+	signature := ""
+	if state.auditSigner != nil {
+		signature, err = signAuditChainHash(state.auditSigner, chainHash)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(insertAuditChainStmt[state.dbType], event.ID, prevHash, chainHash, signature)
+	return err
+}
+
+// loadAuditChainSigner uses the server TLS key to sign the audit chain.
+func loadAuditChainSigner(config baseConfig) (crypto.Signer, error) {
+	keyPair, err := tls.LoadX509KeyPair(config.TLSCertFilename, config.TLSKeyFilename)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := keyPair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("server key cannot be used for signing")
+	}
+	return signer, nil
+}
+
+func loadAuditChainPublicKey(certFilename string) (crypto.PublicKey, error) {
+	pemData, err := ioutil.ReadFile(certFilename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", certFilename)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return cert.PublicKey, nil
+}
+
+type auditChainReport struct {
+	Verified  int
+	Unchained int
+	Unsigned  int
+}
+
+var verifyAuditChainStmt = "select a.id, a.time_stamp, a.actor, a.action, a.groupname, a.username, a.remote_addr, a.outcome, a.details, c.prev_hash, c.hash, c.signature " +
+	"from audit_log a left join audit_chain c on a.id = c.audit_id order by a.id;"
+
+// verifyAuditChain walks the whole audit log and returns an error describing
+// the first inconsistency found. Rows written before the chain existed are
+// only accepted at the start of the log. After retention pruned old entries
+// the chain continues from the hash of the last pruned entry. Signatures are checked when
+// publicKey is not nil.
+func verifyAuditChain(state *RuntimeState, publicKey crypto.PublicKey) (auditChainReport, error) {
+	var report auditChainReport
+	rows, err := state.db.Query(verifyAuditChainStmt)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	prevHash, err := lastPrunedAuditChainHash(state.db)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var event auditEvent
+		var timeStamp int64
+		var rowPrevHash, rowHash, signature sql.NullString
+		err = rows.Scan(&event.ID, &timeStamp, &event.Actor, &event.Action, &event.Groupname,
+			&event.Username, &event.RemoteAddr, &event.Outcome, &event.Details,
+			&rowPrevHash, &rowHash, &signature)
+		if err != nil {
+			return report, err
+		}
+		event.Timestamp = time.Unix(timeStamp, 0)
+		if !rowHash.Valid {
+			if report.Verified > 0 {
+				return report, fmt.Errorf("audit entry %d is not part of the chain", event.ID)
+			}
+			report.Unchained++
+			continue
+		}
+		if rowPrevHash.String != prevHash {
+			return report, fmt.Errorf("audit entry %d does not follow the previous entry, entries were removed or reordered", event.ID)
+		}
+		if auditEventChainHash(prevHash, event) != rowHash.String {
+			return report, fmt.Errorf("audit entry %d has been modified", event.ID)
+		}
+		if signature.String == "" {
+			report.Unsigned++
+		} else if publicKey != nil {
+			err = verifyAuditChainSignature(publicKey, rowHash.String, signature.String)
+			if err != nil {
+				return report, fmt.Errorf("audit entry %d has a bad signature: %s", event.ID, err)
+			}
+		}
+		prevHash = rowHash.String
+		report.Verified++
+	}
+	err = rows.Err()
+	if err != nil {
+		return report, err
+	}
+	var chainEntries int
+	err = state.db.QueryRow("select count(*) from audit_chain;").Scan(&chainEntries)
+	if err != nil {
+		return report, err
+	}
+	if chainEntries != report.Verified {
+		return report, fmt.Errorf("audit chain has %d entries but only %d audit entries exist, entries were removed",
+			chainEntries, report.Verified)
+	}
+	return report, nil
+}
+
+// verifyAuditCommand implements the verify-audit command, it returns the
+// process exit code.
+func verifyAuditCommand(state *RuntimeState) int {
+	var publicKey crypto.PublicKey
+	if state.Config.Audit.SignChain {
+		var err error
+		publicKey, err = loadAuditChainPublicKey(state.Config.Base.TLSCertFilename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot load certificate: %s\n", err)
+			return 1
+		}
+	}
+	report, err := verifyAuditChain(state, publicKey)
+	fmt.Printf("verified=%d unsigned=%d pre-chain=%d\n", report.Verified, report.Unsigned, report.Unchained)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log verification FAILED: %s\n", err)
+		return 1
+	}
+	fmt.Println("Audit log verification OK")
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditChainVerification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit_chain_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "audit.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.auditSigner = signer
+	for _, groupname := range []string{"group1", "group2", "group3"} {
+		err = state.recordAuditEvent(nil, "user1", auditActionAddMember, groupname, "user2", auditOutcomeSuccess, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := verifyAuditChain(&state, signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Verified != 3 || report.Unsigned != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	_, err = state.db.Exec("update audit_log set username='user3' where groupname='group2';")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifyAuditChain(&state, signer.Public())
+	if err == nil {
+		t.Fatal("modified audit entry was not detected")
+	}
+	_, err = state.db.Exec("update audit_log set username='user2' where groupname='group2';")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = state.db.Exec("delete from audit_log where groupname='group3';")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifyAuditChain(&state, signer.Public())
+	if err == nil {
+		t.Fatal("removed audit entry was not detected")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// The audit command searches the audit log in the database, for the
+// incidents when the web service is down. It only needs the database
+// settings of the configuration, the directory is not contacted. The
+// events are printed oldest first, as a table, CSV or JSON lines.
+
+var auditQueryFormats = []string{"text", "csv", "json"}
+
+type auditQueryOptions struct {
+	filter auditEventFilter
+	format string
+}
+
+func isOneOf(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAuditQueryArgs(args []string) (auditQueryOptions, string, error) {
+	var options auditQueryOptions
+	flagSet := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	flagSet.StringVar(&options.filter.Actor, "actor", "", "The user who made the changes")
+	flagSet.StringVar(&options.filter.Username, "user", "", "The user the changes were made to")
+	flagSet.StringVar(&options.filter.Groupname, "group", "", "The group, including its former names")
+	flagSet.StringVar(&options.filter.Action, "action", "", "The action")
+	from := flagSet.String("from", "", "The first day, YYYY-MM-DD")
+	to := flagSet.String("to", "", "The last day, YYYY-MM-DD")
+	flagSet.IntVar(&options.filter.Limit, "limit", 0, "The most recent events only, all of them when 0")
+	flagSet.StringVar(&options.format, "format", "text", "text, csv or json")
+	err := flagSet.Parse(args)
+	if err != nil {
+		return options, "", err
+	}
+	if flagSet.NArg() > 1 {
+		return options, "", fmt.Errorf("unexpected arguments %s", strings.Join(flagSet.Args()[1:], " "))
+	}
+	if options.filter.Action != "" && !isOneOf(options.filter.Action, auditActions) {
+		return options, "", fmt.Errorf("unknown action %s, it is one of %s", options.filter.Action,
+			strings.Join(auditActions, ", "))
+	}
+	if !isOneOf(options.format, auditQueryFormats) {
+		return options, "", fmt.Errorf("unknown format %s", options.format)
+	}
+	if options.filter.Limit < 0 {
+		return options, "", fmt.Errorf("invalid limit %d", options.filter.Limit)
+	}
+	options.filter.From, options.filter.To, err = parseAuditDateRange(*from, *to)
+	return options, flagSet.Arg(0), err
+}
+
+func writeAuditEvents(out io.Writer, format string, events []auditEvent) error {
+	switch format {
+	case "csv":
+		return writeAuditCSV(out, events)
+	case "json":
+		encoder := json.NewEncoder(out)
+		for _, event := range events {
+			err := encoder.Encode(event)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tabWriter := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "TIME\tACTOR\tACTION\tGROUP\tUSER\tOUTCOME\tDETAILS")
+	for _, event := range events {
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", event.Timestamp.Format(time.RFC3339),
+			event.Actor, event.Action, event.Groupname, event.Username, event.Outcome, event.Details)
+	}
+	return tabWriter.Flush()
+}
+
+// queryAuditLog returns the events of the filter, oldest first.
+func (state *RuntimeState) queryAuditLog(filter auditEventFilter) ([]auditEvent, error) {
+	if filter.Groupname != "" {
+		var err error
+		filter.FormerGroupnames, err = state.getGroupFormerNames(filter.Groupname)
+		if err != nil {
+			return nil, err
+		}
+	}
+	events, err := searchAuditEventsInDB(filter, state)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// auditQueryCommand implements the audit command, it returns the process
+// exit code.
+func auditQueryCommand(state *RuntimeState, args []string) int {
+	options, filename, err := parseAuditQueryArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\nUsage: audit [-actor USER] [-user USER] [-group GROUP] [-action ACTION] "+
+			"[-from YYYY-MM-DD] [-to YYYY-MM-DD] [-limit N] [-format text|csv|json] [FILE]\n", err)
+		return 2
+	}
+	events, err := state.queryAuditLog(options.filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot search the audit log: %s\n", err)
+		return 1
+	}
+	out := os.Stdout
+	if filename != "" {
+		out, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create %s: %s\n", filename, err)
+			return 1
+		}
+	}
+	err = writeAuditEvents(out, options.format, events)
+	if filename != "" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write the events: %s\n", err)
+		return 1
+	}
+	if filename != "" {
+		fmt.Printf("Wrote %d events to %s\n", len(events), filename)
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditQuery(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []auditEvent{
+		{Actor: "query-admin", Action: auditActionAddMember, Groupname: "query-group", Username: "query-user"},
+		{Actor: "query-admin", Action: auditActionAddMember, Groupname: "query-other", Username: "query-user"},
+		{Actor: "query-user", Action: auditActionRequestAccess, Groupname: "query-group"},
+	} {
+		err = state.recordAuditEvent(nil, event.Actor, event.Action, event.Groupname, event.Username,
+			auditOutcomeSuccess, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	today := time.Now().Format(auditDateLayout)
+	options, filename, err := parseAuditQueryArgs([]string{"-user", "query-user", "-from", today,
+		"-to", today, "-format", "json"})
+	if err != nil || filename != "" {
+		t.Fatalf("cannot parse the arguments: %v %q", err, filename)
+	}
+	events, err := state.queryAuditLog(options.filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Groupname != "query-group" || events[1].Groupname != "query-other" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	var out bytes.Buffer
+	err = writeAuditEvents(&out, options.format, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded auditEvent
+	err = json.NewDecoder(&out).Decode(&decoded)
+	if err != nil || decoded.Username != "query-user" {
+		t.Fatalf("bad JSON output: %v %+v", err, decoded)
+	}
+
+	options, _, err = parseAuditQueryArgs([]string{"-group", "query-group", "-format", "csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err = state.queryAuditLog(options.filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	err = writeAuditEvents(&out, options.format, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][3] != auditActionAddMember || records[2][2] != "query-user" {
+		t.Fatalf("unexpected CSV %v", records)
+	}
+
+	out.Reset()
+	err = writeAuditEvents(&out, "text", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "TIME") || strings.Count(out.String(), "\n") != 3 {
+		t.Fatalf("unexpected table %s", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"-action", "no_such_action"},
+		{"-format", "xml"},
+		{"-from", "yesterday"},
+		{"-limit", "-1"},
+		{"out1", "out2"},
+	} {
+		if _, _, err := parseAuditQueryArgs(args); err == nil {
+			t.Errorf("%v is accepted", args)
+		}
+	}
+}
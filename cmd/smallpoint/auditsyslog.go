@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	auditSyslogFormatJSON = "json"
+	auditSyslogFormatCEF  = "cef"
+
+	// facility authpriv(10), same as the rest of the smallpoint syslog output
+	auditSyslogFacility        = 10
+	auditSyslogSeverityNotice  = 5
+	auditSyslogSeverityWarning = 4
+
+	auditSyslogQueueSize   = 1024
+	auditSyslogDialTimeout = 10 * time.Second
+)
+
+type auditSyslogConfig struct {
+	// Network is one of udp, tcp or tls. Leaving Address empty disables the sink.
+	Network    string `yaml:"network"`
+	Address    string `yaml:"address"`
+	Format     string `yaml:"format"`
+	CAFilename string `yaml:"ca_filename"`
+}
+
+// auditSyslogSink forwards audit events to a remote syslog collector. Events
+// are queued and sent from a single goroutine so that a slow or unreachable
+// collector never blocks the request handlers.
+type auditSyslogSink struct {
+	config    auditSyslogConfig
+	tlsConfig *tls.Config
+	hostname  string
+	events    chan auditEvent
+
+	connMutex sync.Mutex
+	conn      net.Conn
+}
+
+func newAuditSyslogSink(config auditSyslogConfig) (*auditSyslogSink, error) {
+	if config.Format == "" {
+		config.Format = auditSyslogFormatJSON
+	}
+	if config.Format != auditSyslogFormatJSON && config.Format != auditSyslogFormatCEF {
+		return nil, fmt.Errorf("invalid audit syslog format '%s'", config.Format)
+	}
+	sink := &auditSyslogSink{
+		config: config,
+		events: make(chan auditEvent, auditSyslogQueueSize),
+	}
+	switch config.Network {
+	case "udp", "tcp":
+	case "tls":
+		sink.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if config.CAFilename != "" {
+			caData, err := ioutil.ReadFile(config.CAFilename)
+			if err != nil {
+				return nil, err
+			}
+			certPool := x509.NewCertPool()
+			if !certPool.AppendCertsFromPEM(caData) {
+				return nil, errors.New("cannot parse audit syslog CA file")
+			}
+			sink.tlsConfig.RootCAs = certPool
+		}
+	default:
+		return nil, fmt.Errorf("invalid audit syslog network '%s'", config.Network)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	sink.hostname = hostname
+	go sink.loop()
+	return sink, nil
+}
+
+// Emit queues the event for delivery, if the queue is full the event is
+// dropped (it is still in the audit DB).
+func (sink *auditSyslogSink) Emit(event auditEvent) {
+	select {
+	case sink.events <- event:
+	default:
+		slog.Warn("audit syslog queue full, dropping event", "event", event)
+	}
+}
+
+func (sink *auditSyslogSink) loop() {
+	for event := range sink.events {
+		message, err := sink.formatMessage(event)
+		if err != nil {
+			slog.Error("audit syslog: cannot format event", "err", err)
+			continue
+		}
+		err = sink.send(message)
+		if err != nil {
+			slog.Error("audit syslog: cannot send event", "err", err)
+		}
+	}
+}
+
+func (sink *auditSyslogSink) dial() (net.Conn, error) {
+	if sink.config.Network == "tls" {
+		dialer := &net.Dialer{Timeout: auditSyslogDialTimeout}
+		return tls.DialWithDialer(dialer, "tcp", sink.config.Address, sink.tlsConfig)
+	}
+	return net.DialTimeout(sink.config.Network, sink.config.Address, auditSyslogDialTimeout)
+}
+
+// send writes the message and retries once on a fresh connection, as stream
+// connections are often closed by the collector when idle.
+func (sink *auditSyslogSink) send(message []byte) error {
+	if sink.config.Network != "udp" {
+		// RFC 6587 octet counting framing
+		message = append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+	}
+	sink.connMutex.Lock()
+	defer sink.connMutex.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if sink.conn == nil {
+			sink.conn, err = sink.dial()
+			if err != nil {
+				return err
+			}
+		}
+		_, err = sink.conn.Write(message)
+		if err == nil {
+			return nil
+		}
+		sink.conn.Close()
+		sink.conn = nil
+	}
+	return err
+}
+
+// formatMessage builds an RFC 5424 syslog message carrying the event as
+// either JSON or CEF.
+func (sink *auditSyslogSink) formatMessage(event auditEvent) ([]byte, error) {
+	severity := auditSyslogSeverityNotice
+	if event.Outcome != auditOutcomeSuccess {
+		severity = auditSyslogSeverityWarning
+	}
+	var body string
+	switch sink.config.Format {
+	case auditSyslogFormatCEF:
+		body = formatAuditEventCEF(event)
+	default:
+		jsonData, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		body = string(jsonData)
+	}
+	message := fmt.Sprintf("<%d>1 %s %s smallpoint %d audit - %s",
+		auditSyslogFacility*8+severity, event.Timestamp.UTC().Format(time.RFC3339),
+		sink.hostname, os.Getpid(), body)
+	return []byte(message), nil
+}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+var cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+func formatAuditEventCEF(event auditEvent) string {
+	severity := 3
+	if event.Outcome != auditOutcomeSuccess {
+		severity = 6
+	}
+	extensions := []string{
+		"rt=" + fmt.Sprintf("%d", event.Timestamp.UnixNano()/int64(time.Millisecond)),
+		"act=" + cefExtensionEscaper.Replace(event.Action),
+		"suser=" + cefExtensionEscaper.Replace(event.Actor),
+		"duser=" + cefExtensionEscaper.Replace(event.Username),
+		"cs1Label=group",
+		"cs1=" + cefExtensionEscaper.Replace(event.Groupname),
+		"outcome=" + cefExtensionEscaper.Replace(event.Outcome),
+	}
+	if event.RemoteAddr != "" {
+		extensions = append(extensions, "src="+cefExtensionEscaper.Replace(event.RemoteAddr))
+	}
+	if event.Details != "" {
+		extensions = append(extensions, "msg="+cefExtensionEscaper.Replace(event.Details))
+	}
+	return fmt.Sprintf("CEF:0|Symantec|smallpoint|%s|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(Version), cefHeaderEscaper.Replace(event.Action),
+		cefHeaderEscaper.Replace(event.Action), severity, strings.Join(extensions, " "))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditSyslogSinkUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	sink, err := newAuditSyslogSink(auditSyslogConfig{Network: "udp",
+		Address: listener.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := auditEvent{Timestamp: time.Now(), Actor: "user1", Action: auditActionAddMember,
+		Groupname: "group1", Username: "user2", Outcome: auditOutcomeSuccess}
+	sink.Emit(event)
+
+	buf := make([]byte, 4096)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(buf[:n])
+	if !strings.HasPrefix(message, "<85>1 ") {
+		t.Fatalf("bad syslog header: %s", message)
+	}
+	jsonStart := strings.Index(message, "{")
+	if jsonStart < 0 {
+		t.Fatalf("no json payload: %s", message)
+	}
+	var received auditEvent
+	err = json.Unmarshal([]byte(message[jsonStart:]), &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Actor != "user1" || received.Groupname != "group1" || received.Username != "user2" {
+		t.Fatalf("bad event received: %+v", received)
+	}
+}
+
+func TestFormatAuditEventCEF(t *testing.T) {
+	event := auditEvent{Timestamp: time.Unix(1500000000, 0), Actor: "user1",
+		Action: auditActionDeleteGroup, Groupname: "group=1", Outcome: auditOutcomeFailure,
+		Details: "line1\nline2"}
+	cef := formatAuditEventCEF(event)
+	if !strings.HasPrefix(cef, "CEF:0|Symantec|smallpoint|") {
+		t.Fatalf("bad cef header: %s", cef)
+	}
+	for _, expected := range []string{"|delete_group|delete_group|6|", "rt=1500000000000",
+		`cs1=group\=1`, `msg=line1\nline2`, "outcome=failure"} {
+		if !strings.Contains(cef, expected) {
+			t.Fatalf("cef message %s missing %s", cef, expected)
+		}
+	}
+	_, err := newAuditSyslogSink(auditSyslogConfig{Network: "udp", Address: "127.0.0.1:514", Format: "xml"})
+	if err == nil {
+		t.Fatal("invalid format should fail")
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The selected groups are mirrored into AWS IAM Identity Center through its
+// SCIM endpoint, so that the permission sets assigned to the groups follow
+// the LDAP groups. The groups are selected in the configuration, by name or
+// by a group tag, and are created in the identity store when missing. The
+// SCIM API of Identity Center does not list the members of a group, so the
+// pushed members are kept in the DB. The users themselves are provisioned
+// by the identity provider, the members without an Identity Center user are
+// skipped.
+
+const (
+	defaultAWSIdentityCenterInterval = time.Hour
+	// awsIdentityCenterPatchSize is the most members a PATCH of Identity
+	// Center accepts.
+	awsIdentityCenterPatchSize        = 100
+	awsIdentityCenterIntegration      = "aws_identity_center"
+	awsIdentityCenterTargetName       = "aws_identity_center"
+	maxAWSIdentityCenterErrorsPerSync = 10
+)
+
+type awsIdentityCenterConfig struct {
+	// SCIMEndpoint is the SCIM endpoint of the Identity Center instance,
+	// e.g. https://scim.us-east-1.amazonaws.com/abcd-1234/scim/v2/.
+	SCIMEndpoint string `yaml:"scim_endpoint"`
+	// TokenFilename holds the SCIM access token of the instance.
+	TokenFilename string `yaml:"token_filename"`
+	// Groups and the groups with the Tag are mirrored.
+	Groups []string `yaml:"groups"`
+	Tag    string   `yaml:"tag"`
+	// UserNameSuffix is appended to the usernames to get the user names of
+	// Identity Center, e.g. "@example.com".
+	UserNameSuffix string        `yaml:"user_name_suffix"`
+	Interval       time.Duration `yaml:"interval"`
+	Timeout        time.Duration `yaml:"timeout"`
+}
+
+func (config awsIdentityCenterConfig) enabled() bool {
+	return config.SCIMEndpoint != ""
+}
+
+func (config awsIdentityCenterConfig) interval() time.Duration {
+	if config.Interval <= 0 {
+		return defaultAWSIdentityCenterInterval
+	}
+	return config.Interval
+}
+
+func (config awsIdentityCenterConfig) check() error {
+	if !config.enabled() {
+		return nil
+	}
+	parsedURL, err := url.Parse(config.SCIMEndpoint)
+	if err != nil || parsedURL.Scheme != "https" || parsedURL.Host == "" {
+		return fmt.Errorf("scim_endpoint must be an https URL")
+	}
+	if config.TokenFilename == "" {
+		return fmt.Errorf("token_filename is required")
+	}
+	if len(config.Groups) == 0 && config.Tag == "" {
+		return fmt.Errorf("no groups or tag are selected")
+	}
+	return nil
+}
+
+func (config awsIdentityCenterConfig) targetConfig() scimTargetConfig {
+	return scimTargetConfig{
+		Name:           awsIdentityCenterTargetName,
+		URL:            config.SCIMEndpoint,
+		TokenFilename:  config.TokenFilename,
+		Timeout:        config.Timeout,
+		UserNameSuffix: config.UserNameSuffix,
+	}
+}
+
+var getAWSGroupMembersStmt = map[string]string{
+	"sqlite":   "select username, aws_user_id from aws_group_members where groupname=?;",
+	"postgres": "select username, aws_user_id from aws_group_members where groupname=$1;",
+}
+
+var getAWSGroupsStmt = map[string]string{
+	"sqlite":   "select distinct groupname from aws_group_members order by groupname;",
+	"postgres": "select distinct groupname from aws_group_members order by groupname;",
+}
+
+var insertAWSGroupMemberStmt = map[string]string{
+	"sqlite":   "insert into aws_group_members(groupname, username, aws_user_id) values (?,?,?);",
+	"postgres": "insert into aws_group_members(groupname, username, aws_user_id) values ($1,$2,$3);",
+}
+
+var deleteAWSGroupMemberStmt = map[string]string{
+	"sqlite":   "delete from aws_group_members where groupname=? and username=?;",
+	"postgres": "delete from aws_group_members where groupname=$1 and username=$2;",
+}
+
+// getAWSGroupMembersFromDB returns the ids of the pushed members of the
+// group by username.
+func getAWSGroupMembersFromDB(groupname string, state *RuntimeState) (map[string]string, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getAWSGroupMembersStmt[state.dbType], groupname)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	members := make(map[string]string)
+	for rows.Next() {
+		var username, userID string
+		err = rows.Scan(&username, &userID)
+		if err != nil {
+			return nil, err
+		}
+		members[username] = userID
+	}
+	return members, rows.Err()
+}
+
+// awsIdentityCenterGroups returns the selected groups.
+func (state *RuntimeState) awsIdentityCenterGroups() (map[string]bool, error) {
+	config := state.Config.AWSIdentityCenter
+	groups := make(map[string]bool)
+	for _, groupname := range config.Groups {
+		groups[groupname] = true
+	}
+	if config.Tag != "" {
+		tagged, err := getGroupsWithTagFromDB(config.Tag, state)
+		if err != nil {
+			return nil, err
+		}
+		for _, groupname := range tagged {
+			groups[groupname] = true
+		}
+	}
+	return groups, nil
+}
+
+// awsGroupID returns the id of the Identity Center group of the group,
+// creating the group when create is set. It is empty when the group is
+// missing and not created.
+func awsGroupID(client *scimTargetClient, groupname string, create bool) (string, error) {
+	groupID, err := client.findID("Groups", "displayName", groupname)
+	if err != nil || groupID != "" || !create {
+		return groupID, err
+	}
+	var created scimGroup
+	err = client.do(http.MethodPost, "/Groups", struct {
+		Schemas     []string `json:"schemas"`
+		DisplayName string   `json:"displayName"`
+	}{Schemas: []string{scimGroupSchema}, DisplayName: groupname}, &created)
+	if err != nil {
+		return "", err
+	}
+	if created.ID == "" {
+		return "", fmt.Errorf("no id for the created group %s", groupname)
+	}
+	slog.Info("created the AWS Identity Center group", "group", groupname, "id", created.ID)
+	return created.ID, nil
+}
+
+func patchAWSGroup(client *scimTargetClient, groupID string, operation scimPatchOperation) error {
+	return client.do(http.MethodPatch, "/Groups/"+url.PathEscape(groupID), scimPatchRequest{
+		Schemas:    []string{scimPatchOpSchema},
+		Operations: []scimPatchOperation{operation},
+	}, nil)
+}
+
+// syncAWSGroup changes the members of the Identity Center group to the
+// members of the group, or removes the pushed members when the group is no
+// longer selected. It returns the numbers of added, removed and skipped
+// members.
+func (state *RuntimeState) syncAWSGroup(client *scimTargetClient, groupname string, selected bool) (int, int,
+	int, error) {
+	pushed, err := getAWSGroupMembersFromDB(groupname, state)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	members := make(map[string]bool)
+	if selected {
+		users, _, err := state.Userinfo.GetusersofaGroup(groupname)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, user := range users {
+			members[user] = true
+		}
+	}
+	var toAdd, toRemove []string
+	for member := range members {
+		if _, ok := pushed[member]; !ok {
+			toAdd = append(toAdd, member)
+		}
+	}
+	for username := range pushed {
+		if !members[username] {
+			toRemove = append(toRemove, username)
+		}
+	}
+	if len(toAdd) == 0 && len(toRemove) == 0 {
+		return 0, 0, 0, nil
+	}
+	sort.Strings(toAdd)
+	sort.Strings(toRemove)
+	groupID, err := awsGroupID(client, groupname, len(toAdd) > 0)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if groupID == "" {
+		// the group was deleted in Identity Center with its members
+		for _, username := range toRemove {
+			err = execServiceAccountUpdate(state, deleteAWSGroupMemberStmt[state.dbType], groupname, username)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+		}
+		return 0, 0, 0, nil
+	}
+
+	var added, removed, skipped int
+	var adds []scimMember
+	var addedUsers []string
+	flush := func() error {
+		if len(adds) == 0 {
+			return nil
+		}
+		value, err := json.Marshal(adds)
+		if err != nil {
+			return err
+		}
+		err = patchAWSGroup(client, groupID, scimPatchOperation{Op: scimPushChangeAdd, Path: "members",
+			Value: value})
+		if err != nil {
+			return err
+		}
+		for i, username := range addedUsers {
+			err = execServiceAccountUpdate(state, insertAWSGroupMemberStmt[state.dbType], groupname, username,
+				adds[i].Value)
+			if err != nil {
+				return err
+			}
+		}
+		added += len(adds)
+		adds = nil
+		addedUsers = nil
+		return nil
+	}
+	for _, username := range toAdd {
+		userName := username + client.config.UserNameSuffix
+		userID, err := client.findID("Users", "userName", userName)
+		if err != nil {
+			return added, removed, skipped, err
+		}
+		if userID == "" {
+			slog.Warn("the member has no AWS Identity Center user", "group", groupname, "user", username,
+				"user_name", userName)
+			skipped++
+			continue
+		}
+		adds = append(adds, scimMember{Value: userID})
+		addedUsers = append(addedUsers, username)
+		if len(adds) == awsIdentityCenterPatchSize {
+			err = flush()
+			if err != nil {
+				return added, removed, skipped, err
+			}
+		}
+	}
+	err = flush()
+	if err != nil {
+		return added, removed, skipped, err
+	}
+	for _, username := range toRemove {
+		err = patchAWSGroup(client, groupID, scimPatchOperation{Op: scimPushChangeRemove,
+			Path: "members[value eq " + strconv.Quote(pushed[username]) + "]"})
+		if err != nil {
+			return added, removed, skipped, err
+		}
+		err = execServiceAccountUpdate(state, deleteAWSGroupMemberStmt[state.dbType], groupname, username)
+		if err != nil {
+			return added, removed, skipped, err
+		}
+		removed++
+	}
+	return added, removed, skipped, nil
+}
+
+// syncAWSGroups syncs the groups, the failed groups do not stop the others.
+func (state *RuntimeState) syncAWSGroups(groupnames []string, selected map[string]bool) error {
+	state.awsIdentityCenterMutex.Lock()
+	defer state.awsIdentityCenterMutex.Unlock()
+	client, err := newSCIMTargetClient(state.Config.AWSIdentityCenter.targetConfig())
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, groupname := range groupnames {
+		added, removed, skipped, err := state.syncAWSGroup(client, groupname, selected[groupname])
+		if added > 0 || removed > 0 || skipped > 0 {
+			slog.Info("AWS Identity Center group synced", "group", groupname, "added", added,
+				"removed", removed, "skipped", skipped)
+		}
+		if err != nil {
+			failed++
+			metrics.MetricLogGroupPushFailure(awsIdentityCenterIntegration)
+			slog.Error("AWS Identity Center group sync failed", "group", groupname, "err", err)
+			if failed >= maxAWSIdentityCenterErrorsPerSync {
+				break
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d AWS Identity Center group syncs failed", failed)
+	}
+	return nil
+}
+
+// runAWSIdentityCenterSync is the periodic job syncing the selected groups
+// and the groups deselected since their last sync.
+func (state *RuntimeState) runAWSIdentityCenterSync() error {
+	selected, err := state.awsIdentityCenterGroups()
+	if err != nil {
+		return err
+	}
+	pushedGroups, err := queryStringsFromDB(state, getAWSGroupsStmt[state.dbType])
+	if err != nil {
+		return err
+	}
+	groups := make(map[string]bool)
+	for groupname := range selected {
+		groups[groupname] = true
+	}
+	for _, groupname := range pushedGroups {
+		groups[groupname] = true
+	}
+	var groupnames []string
+	for groupname := range groups {
+		groupnames = append(groupnames, groupname)
+	}
+	sort.Strings(groupnames)
+	return state.syncAWSGroups(groupnames, selected)
+}
+
+// awsIdentityCenterMembershipChanged syncs the group after its membership
+// changed when it is selected.
+func (state *RuntimeState) awsIdentityCenterMembershipChanged(groupname string) {
+	if !state.Config.AWSIdentityCenter.enabled() {
+		return
+	}
+	selected, err := state.awsIdentityCenterGroups()
+	if err == nil && selected[groupname] {
+		err = state.syncAWSGroups([]string{groupname}, selected)
+	}
+	if err != nil {
+		slog.Error("cannot sync the group to AWS Identity Center", "group", groupname, "err", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testIdentityCenterServer serves the SCIM endpoint of Identity Center,
+// which does not return the members of the groups. The user ids are the
+// user names, user3 has no user.
+type testIdentityCenterServer struct {
+	mutex   sync.Mutex
+	groups  map[string]map[string]bool
+	patches int
+}
+
+func (s *testIdentityCenterServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r.Header.Get("Authorization") != "Bearer aws-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	filterValue := func(attribute string) string {
+		filter := r.URL.Query().Get("filter")
+		return strings.TrimSuffix(strings.TrimPrefix(filter, attribute+` eq "`), `"`)
+	}
+	resources := []map[string]string{}
+	switch {
+	case r.Method == getMethod && r.URL.Path == "/scim/v2/Users":
+		userName := filterValue("userName")
+		if userName != "user3@example.com" {
+			resources = append(resources, map[string]string{"id": userName})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Resources": resources})
+	case r.Method == getMethod && r.URL.Path == "/scim/v2/Groups":
+		if _, ok := s.groups[filterValue("displayName")]; ok {
+			resources = append(resources, map[string]string{"id": filterValue("displayName")})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Resources": resources})
+	case r.Method == http.MethodPost && r.URL.Path == "/scim/v2/Groups":
+		var group scimGroup
+		json.NewDecoder(r.Body).Decode(&group)
+		s.groups[group.DisplayName] = make(map[string]bool)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"id": group.DisplayName})
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/scim/v2/Groups/"):
+		members, ok := s.groups[strings.TrimPrefix(r.URL.Path, "/scim/v2/Groups/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.patches++
+		var request scimPatchRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		for _, operation := range request.Operations {
+			if operation.Op == scimPushChangeRemove {
+				delete(members, strings.TrimSuffix(strings.TrimPrefix(operation.Path, `members[value eq "`), `"]`))
+				continue
+			}
+			var added []scimMember
+			json.Unmarshal(operation.Value, &added)
+			for _, member := range added {
+				members[member.Value] = true
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *testIdentityCenterServer) members(groupname string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var members []string
+	for member := range s.groups[groupname] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func TestAWSIdentityCenterSync(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("delete from aws_group_members;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aws := &testIdentityCenterServer{groups: make(map[string]map[string]bool)}
+	server := httptest.NewServer(aws)
+	defer server.Close()
+	tokenFilename := filepath.Join(t.TempDir(), "token")
+	err = ioutil.WriteFile(tokenFilename, []byte("aws-token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.AWSIdentityCenter = awsIdentityCenterConfig{SCIMEndpoint: server.URL + "/scim/v2/",
+		TokenFilename: tokenFilename, Groups: []string{"group1"}, UserNameSuffix: "@example.com"}
+
+	err = state.runAWSIdentityCenterSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"user1@example.com", "user2@example.com"}
+	if members := aws.members("group1"); !reflect.DeepEqual(members, expected) {
+		t.Fatalf("group1 members %v", members)
+	}
+	// the synced group is not patched again
+	patches := aws.patches
+	err = state.runAWSIdentityCenterSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.patches != patches {
+		t.Fatalf("%d patches of a synced group", aws.patches-patches)
+	}
+
+	state.Config.AWSIdentityCenter.Groups = []string{"group2"}
+	err = state.runAWSIdentityCenterSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members := aws.members("group1"); len(members) != 0 {
+		t.Fatalf("the members of the deselected group1 were not removed: %v", members)
+	}
+	if members := aws.members("group2"); !reflect.DeepEqual(members, expected) {
+		t.Fatalf("group2 members %v", members)
+	}
+	pushed, err := getAWSGroupMembersFromDB("group1", &state)
+	if err != nil || len(pushed) != 0 {
+		t.Fatalf("pushed group1 members %v, err %v", pushed, err)
+	}
+}
+
+func TestAWSIdentityCenterConfigCheck(t *testing.T) {
+	for _, config := range []awsIdentityCenterConfig{
+		{SCIMEndpoint: "http://scim.example.com/", TokenFilename: "token", Groups: []string{"group1"}},
+		{SCIMEndpoint: "https://scim.example.com/", Groups: []string{"group1"}},
+		{SCIMEndpoint: "https://scim.example.com/", TokenFilename: "token"},
+	} {
+		if config.check() == nil {
+			t.Errorf("invalid config %+v passed the check", config)
+		}
+	}
+	if err := (awsIdentityCenterConfig{}).check(); err != nil {
+		t.Fatalf("the disabled sync failed the check: %s", err)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The backup command writes the application tables to a portable file that
+// the restore command loads into an empty database, of the same or of the
+// other database type. The file holds JSON lines: a header with the schema
+// version, then a line per row. The directory mirror, rebuilt by the next
+// sync, the job leases and the provisioned secrets, generated again by the
+// restored instance, are not backed up. The sealed values are copied as they
+// are, the restored instance needs the same encryption keys.
+
+const backupFormat = "smallpoint-backup"
+
+type backupTable struct {
+	Name string
+	// SerialID tells the tables with an auto incremented id column, their
+	// postgres sequences are moved past the restored ids.
+	SerialID bool
+}
+
+// backupTables lists the tables of the application state, the new tables are
+// added here.
+var backupTables = []backupTable{
+	{Name: "pending_requests", SerialID: true},
+	{Name: "access_requests", SerialID: true},
+	{Name: "membership_removals", SerialID: true},
+	{Name: "audit_log", SerialID: true},
+	{Name: "audit_chain"},
+	{Name: "audit_retention", SerialID: true},
+	{Name: "group_membership_history", SerialID: true},
+	{Name: "compliance_reports"},
+	{Name: "service_accounts"},
+	{Name: "service_account_takeovers", SerialID: true},
+	{Name: "credential_rotations", SerialID: true},
+	{Name: "service_account_lifecycle_requests", SerialID: true},
+	{Name: "service_account_deletions"},
+	{Name: "service_account_metadata"},
+	{Name: "service_account_requests", SerialID: true},
+	{Name: "group_classifications"},
+	{Name: "group_metadata"},
+	{Name: "group_tags"},
+	{Name: "group_templates"},
+	{Name: "gid_reservations"},
+	{Name: "mailing_list_addresses"},
+	{Name: "group_archives"},
+	{Name: "group_renames", SerialID: true},
+	{Name: "user_preferences"},
+	{Name: "scim_push_queue", SerialID: true},
+	{Name: "github_team_mappings"},
+	{Name: "okta_group_pushes"},
+	{Name: "aws_group_members"},
+	{Name: "event_publishing_cursors"},
+	{Name: "hr_webhook_events"},
+	{Name: "group_tickets"},
+	{Name: "held_changes", SerialID: true},
+	{Name: "sql_directory_users"},
+	{Name: "sql_directory_groups"},
+	{Name: "sql_directory_members"},
+	{Name: "sql_directory_service_accounts"},
+}
+
+type backupHeader struct {
+	Format        string    `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	DBType        string    `json:"db_type"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type backupRow struct {
+	Table string                 `json:"table"`
+	Row   map[string]interface{} `json:"row"`
+}
+
+// writeBackup writes the tables from a single read only transaction, the
+// backup is consistent while the server runs.
+func writeBackup(state *RuntimeState, w io.Writer) (map[string]int, error) {
+	status, err := getSchemaStatus(state)
+	if err != nil {
+		return nil, err
+	}
+	sqlTx, err := state.db.BeginTx(context.Background(),
+		&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	tx := &instrumentedTx{sqlTx}
+	defer tx.Rollback()
+	encoder := json.NewEncoder(w)
+	err = encoder.Encode(backupHeader{Format: backupFormat, SchemaVersion: status.Current,
+		DBType: state.dbType, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, table := range backupTables {
+		counts[table.Name], err = writeBackupTable(tx, table.Name, encoder)
+		if err != nil {
+			return counts, fmt.Errorf("%s: %s", table.Name, err)
+		}
+	}
+	return counts, nil
+}
+
+func writeBackupTable(tx *instrumentedTx, table string, encoder *json.Encoder) (int, error) {
+	rows, err := tx.Query("select * from " + table + ";")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return count, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if value, ok := values[i].([]byte); ok {
+				row[column] = string(value)
+				continue
+			}
+			row[column] = values[i]
+		}
+		err = encoder.Encode(backupRow{Table: table, Row: row})
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+// restoreBackup loads a backup in a single transaction into empty tables, a
+// failed restore leaves them empty. The audit log is append only, a restore
+// never deletes rows.
+func restoreBackup(state *RuntimeState, r io.Reader) (map[string]int, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	decoder.UseNumber()
+	var header backupHeader
+	err := decoder.Decode(&header)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the backup header: %s", err)
+	}
+	if header.Format != backupFormat {
+		return nil, errors.New("the file is not a smallpoint backup")
+	}
+	status, err := getSchemaStatus(state)
+	if err != nil {
+		return nil, err
+	}
+	if header.SchemaVersion != status.Current {
+		return nil, fmt.Errorf("the backup has the schema version %d and the database %d, restore with the "+
+			"release that wrote the backup", header.SchemaVersion, status.Current)
+	}
+	tables := make(map[string]backupTable)
+	for _, table := range backupTables {
+		tables[table.Name] = table
+	}
+	tx, err := state.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, table := range backupTables {
+		var count int
+		err = tx.QueryRow("select count(*) from " + table.Name + ";").Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, fmt.Errorf("the table %s is not empty", table.Name)
+		}
+	}
+	counts := make(map[string]int)
+	for {
+		var row backupRow
+		err = decoder.Decode(&row)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counts, err
+		}
+		if _, ok := tables[row.Table]; !ok {
+			return counts, fmt.Errorf("unknown table %s", row.Table)
+		}
+		err = insertBackupRow(tx, state.dbType, row)
+		if err != nil {
+			return counts, fmt.Errorf("%s: %s", row.Table, err)
+		}
+		counts[row.Table]++
+	}
+	if state.dbType == "postgres" {
+		for _, table := range backupTables {
+			if !table.SerialID {
+				continue
+			}
+			_, err = tx.Exec(fmt.Sprintf("select setval(pg_get_serial_sequence('%s', 'id'), "+
+				"coalesce(max(id), 1), max(id) is not null) from %s;", table.Name, table.Name))
+			if err != nil {
+				return counts, err
+			}
+		}
+	}
+	return counts, tx.Commit()
+}
+
+func insertBackupRow(tx *instrumentedTx, dbType string, row backupRow) error {
+	columns := make([]string, 0, len(row.Row))
+	for column := range row.Row {
+		if strings.ContainsAny(column, " ,;()\"'") {
+			return fmt.Errorf("bad column name %q", column)
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	placeholders := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		placeholders[i] = "?"
+		if dbType == "postgres" {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+		args[i] = row.Row[column]
+		if number, ok := args[i].(json.Number); ok {
+			integer, err := number.Int64()
+			if err != nil {
+				return err
+			}
+			args[i] = integer
+		}
+	}
+	_, err := tx.Exec(fmt.Sprintf("insert into %s(%s) values (%s);", row.Table, strings.Join(columns, ", "),
+		strings.Join(placeholders, ",")), args...)
+	return err
+}
+
+func printBackupCounts(counts map[string]int) {
+	for _, table := range backupTables {
+		fmt.Printf("%s=%d\n", table.Name, counts[table.Name])
+	}
+}
+
+func backupCommand(state *RuntimeState, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: backup FILE\n")
+		return 2
+	}
+	file, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create the backup: %s\n", err)
+		return 1
+	}
+	writer := bufio.NewWriter(file)
+	counts, err := writeBackup(state, writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(args[0])
+		fmt.Fprintf(os.Stderr, "Backup FAILED: %s\n", err)
+		return 1
+	}
+	printBackupCounts(counts)
+	fmt.Println("Backup OK")
+	return 0
+}
+
+func restoreCommand(state *RuntimeState, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: restore FILE\n")
+		return 2
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open the backup: %s\n", err)
+		return 1
+	}
+	defer file.Close()
+	counts, err := restoreBackup(state, file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Restore FAILED: %s\n", err)
+		return 1
+	}
+	printBackupCounts(counts)
+	fmt.Println("Restore OK")
+	return 0
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo/mock"
+)
+
+func testInitDB(t *testing.T, filename string) *RuntimeState {
+	var state RuntimeState
+	state.Userinfo = mock.New()
+	state.Config.Base.StorageURL = "sqlite:" + filename
+	err := initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &state
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := testInitDB(t, filepath.Join(dir, "source.db"))
+	defer source.db.Close()
+	err = insertRequestInDB("user2", []string{"group3"}, "", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, groupname := range []string{"group1", "group2"} {
+		err = insertAuditEventInDB(auditEvent{Timestamp: time.Now(), Actor: "user1", Action: "create_group",
+			Groupname: groupname, Outcome: auditOutcomeSuccess, Details: "multi\nline"}, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = setGroupMetadataInDB("group1", groupMetadata{Description: "first group"}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	counts, err := writeBackup(source, &backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["pending_requests"] != 1 || counts["audit_log"] != 2 || counts["audit_chain"] != 2 ||
+		counts["group_metadata"] != 1 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+
+	target := testInitDB(t, filepath.Join(dir, "target.db"))
+	defer target.db.Close()
+	restored, err := restoreBackup(target, bytes.NewReader(backup.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for table, count := range counts {
+		if restored[table] != count {
+			t.Fatalf("%s has %d rows instead of %d", table, restored[table], count)
+		}
+	}
+	events, err := searchAuditEventsInDB(auditEventFilter{Limit: 10}, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Details != "multi\nline" {
+		t.Fatalf("unexpected restored events %+v", events)
+	}
+	_, err = verifyAuditChain(target, nil)
+	if err != nil {
+		t.Fatalf("the restored audit chain does not verify: %s", err)
+	}
+	_, err = restoreBackup(target, bytes.NewReader(backup.Bytes()))
+	if err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Fatalf("the backup was restored over existing rows, err: %v", err)
+	}
+}
+
+// TestBackupTables fails when a table of the application is missing from the
+// backups.
+func TestBackupTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := testInitDB(t, filepath.Join(dir, "tables.db"))
+	defer state.db.Close()
+	rows, err := state.db.Query("select name from sqlite_master where type='table' and name not like 'sqlite_%';")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	backedUp := map[string]bool{"schema_migrations": true, "job_leases": true, "provisioned_secrets": true}
+	for _, table := range backupTables {
+		backedUp[table.Name] = true
+	}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !backedUp[name] && !strings.HasPrefix(name, "directory_") {
+			t.Errorf("the table %s is not backed up", name)
+		}
+	}
+}
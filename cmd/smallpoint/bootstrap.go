@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The bootstrap command prepares a new deployment: it applies the schema
+// migrations, adds the missing organizationalUnit entries of the user, group
+// and service account base DNs, and creates the admin group with the super
+// admins as members. The steps already done are skipped, so it is safe to
+// run again. With -dry-run it only prints what it would do.
+
+const defaultAdminGroup = "smallpoint-admins"
+
+// baseEntryCreator adds the base DN entries of the target directory.
+type baseEntryCreator interface {
+	MissingBaseDNs() ([]string, error)
+	CreateOrganizationalUnit(dn string) error
+}
+
+func (state *RuntimeState) bootstrapSchema(out io.Writer) error {
+	status, err := getSchemaStatus(state)
+	if err != nil {
+		return err
+	}
+	if len(status.Pending) == 0 {
+		fmt.Fprintf(out, "schema version %d is up to date\n", status.Current)
+		return nil
+	}
+	if state.dryRun {
+		fmt.Fprintf(out, "dry run, would migrate the schema to version %d\n", status.Latest)
+		return nil
+	}
+	err = migrateDB(state)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "migrated the schema to version %d\n", status.Latest)
+	return nil
+}
+
+func (state *RuntimeState) bootstrapBaseEntries(out io.Writer, directory baseEntryCreator) error {
+	missing, err := directory.MissingBaseDNs()
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		fmt.Fprintf(out, "the base DNs exist\n")
+	}
+	for _, dn := range missing {
+		if state.dryRun {
+			fmt.Fprintf(out, "dry run, would create %s\n", dn)
+			continue
+		}
+		err = directory.CreateOrganizationalUnit(dn)
+		if err != nil {
+			return fmt.Errorf("cannot create %s: %s", dn, err)
+		}
+		fmt.Fprintf(out, "created %s\n", dn)
+	}
+	return nil
+}
+
+// bootstrapAdminGroup creates the admin group, or adds the super admins
+// missing from it.
+func (state *RuntimeState) bootstrapAdminGroup(out io.Writer, actor string, adminGroup string) error {
+	var admins []string
+	for _, admin := range state.Userinfo.ParseSuperadmins() {
+		admin = strings.TrimSpace(admin)
+		if admin == "" {
+			continue
+		}
+		exists, err := state.Userinfo.UsernameExistsornot(admin)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			fmt.Fprintf(out, "super admin %s is not in the directory, it is left out of %s\n", admin, adminGroup)
+			continue
+		}
+		admins = append(admins, admin)
+	}
+	exists, _, err := state.Userinfo.GroupnameExistsornot(adminGroup)
+	if err != nil {
+		return err
+	}
+	if !exists && state.dryRun {
+		fmt.Fprintf(out, "dry run, would create the admin group %s with %s\n", adminGroup, strings.Join(admins, ", "))
+		return nil
+	}
+	if !exists {
+		err = state.createLDAPGroup(nil, userinfo.GroupInfo{Groupname: adminGroup, Description: descriptionAttribute,
+			MemberUid: admins}, actor, nil)
+		if err != nil {
+			state.recordAuditEvent(nil, actor, auditActionCreateGroup, adminGroup, "", auditOutcomeFailure, err.Error())
+			return err
+		}
+		state.recordAuditEvent(nil, actor, auditActionCreateGroup, adminGroup, "", auditOutcomeSuccess,
+			"managed by "+descriptionAttribute+", bootstrap")
+		for _, admin := range admins {
+			state.recordAuditEvent(nil, actor, auditActionAddMember, adminGroup, admin, auditOutcomeSuccess, "bootstrap")
+		}
+		fmt.Fprintf(out, "created the admin group %s with %s\n", adminGroup, strings.Join(admins, ", "))
+		return nil
+	}
+	var added []string
+	for _, admin := range admins {
+		isMember, _, err := state.Userinfo.IsgroupmemberorNot(adminGroup, admin)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			added = append(added, admin)
+		}
+	}
+	if len(added) == 0 {
+		fmt.Fprintf(out, "the admin group %s exists\n", adminGroup)
+		return nil
+	}
+	if state.dryRun {
+		fmt.Fprintf(out, "dry run, would add %s to the admin group %s\n", strings.Join(added, ", "), adminGroup)
+		return nil
+	}
+	err = state.Userinfo.AddmemberstoExisting(userinfo.GroupInfo{Groupname: adminGroup, MemberUid: added})
+	if err != nil {
+		for _, admin := range added {
+			state.recordAuditEvent(nil, actor, auditActionAddMember, adminGroup, admin, auditOutcomeFailure, err.Error())
+		}
+		return err
+	}
+	for _, admin := range added {
+		state.recordAuditEvent(nil, actor, auditActionAddMember, adminGroup, admin, auditOutcomeSuccess, "bootstrap")
+	}
+	fmt.Fprintf(out, "added %s to the admin group %s\n", strings.Join(added, ", "), adminGroup)
+	return nil
+}
+
+func (state *RuntimeState) bootstrap(out io.Writer, directory baseEntryCreator, actor string,
+	adminGroup string) error {
+	err := state.bootstrapSchema(out)
+	if err != nil {
+		return fmt.Errorf("cannot migrate the schema: %s", err)
+	}
+	err = state.bootstrapBaseEntries(out, directory)
+	if err != nil {
+		return err
+	}
+	err = state.bootstrapAdminGroup(out, actor, adminGroup)
+	if err != nil {
+		return fmt.Errorf("cannot set up the admin group: %s", err)
+	}
+	return nil
+}
+
+// bootstrapCommand implements the bootstrap command, it returns the
+// process exit code.
+func bootstrapCommand(state *RuntimeState, args []string) int {
+	flagSet := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	adminGroup := flagSet.String("admin-group", defaultAdminGroup, "The name of the group of the super admins")
+	err := flagSet.Parse(args)
+	if err != nil || flagSet.NArg() != 0 || *adminGroup == "" {
+		fmt.Fprintf(os.Stderr, "Usage: bootstrap [-admin-group NAME]\n")
+		return 2
+	}
+	actor := "smallpoint"
+	if currentUser, err := user.Current(); err == nil {
+		actor = currentUser.Username
+	}
+	var directory baseEntryCreator = &state.Config.TargetLDAP
+	if state.sqlDirectory != nil {
+		directory = state.sqlDirectory
+	}
+	err = state.bootstrap(os.Stdout, directory, actor, *adminGroup)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bootstrap FAILED: %s\n", err)
+		return 1
+	}
+	fmt.Println("Bootstrap OK")
+	return 0
+}
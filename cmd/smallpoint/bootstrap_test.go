@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testBaseEntries struct {
+	missing []string
+	created []string
+}
+
+func (entries *testBaseEntries) MissingBaseDNs() ([]string, error) {
+	return entries.missing, nil
+}
+
+func (entries *testBaseEntries) CreateOrganizationalUnit(dn string) error {
+	entries.created = append(entries.created, dn)
+	entries.missing = entries.missing[1:]
+	return nil
+}
+
+func TestBootstrap(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := []string{"ou=groups,dc=example,dc=com", "ou=services,dc=example,dc=com"}
+	directory := &testBaseEntries{missing: append([]string{}, missing...)}
+	var out bytes.Buffer
+	err = state.bootstrap(&out, directory, "root", "bootstrap_admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(directory.created, missing) {
+		t.Fatalf("created %v", directory.created)
+	}
+	members, _, err := state.Userinfo.GetusersofaGroup("bootstrap_admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, []string{"user1"}) {
+		t.Fatalf("the admin group has members %v", members)
+	}
+
+	// a second run changes nothing
+	out.Reset()
+	err = state.bootstrap(&out, directory, "root", "bootstrap_admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"is up to date", "the base DNs exist", "the admin group bootstrap_admins exists"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("%q is not in %s", expected, out.String())
+		}
+	}
+	if len(directory.created) != len(missing) {
+		t.Fatalf("created %v", directory.created)
+	}
+
+	// a dry run changes nothing either
+	state.dryRun = true
+	directory = &testBaseEntries{missing: append([]string{}, missing...)}
+	out.Reset()
+	err = state.bootstrap(&out, directory, "root", "dry_run_admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "dry run, would create the admin group dry_run_admins") {
+		t.Errorf("the admin group is not in %s", out.String())
+	}
+	exists, _, err := state.Userinfo.GroupnameExistsornot("dry_run_admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists || len(directory.created) != 0 {
+		t.Fatalf("the dry run created the admin group or %v", directory.created)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The forged auth cookies and OAuth2 states, the rejected OAuth2 codes and
+// the invalid SCIM tokens and HR webhook signatures are authentication
+// failures. Each failure delays the next attempt of the IP, and of the user
+// an invalid cookie claims, twice as long as the previous one, and
+// max_failures within the window locks the IP or the user out. The delayed
+// and locked out requests are answered 429, except the requests of a valid
+// session of a user that others tried to guess.
+
+const (
+	securityEventAuthThrottled = "auth_throttled"
+	securityEventAuthLockout   = "auth_lockout"
+
+	authFailureKeyIP   = "ip"
+	authFailureKeyUser = "user"
+
+	defaultAuthFailureWindow   = 15 * time.Minute
+	defaultAuthLockoutDuration = 15 * time.Minute
+	defaultAuthBaseDelay       = time.Second
+	defaultAuthMaxDelay        = time.Minute
+)
+
+type bruteForceConfig struct {
+	// MaxFailures within Window locks the IP or the user out for
+	// LockoutDuration, 0 disables the protection.
+	MaxFailures     int           `yaml:"max_failures"`
+	Window          time.Duration `yaml:"window"`
+	LockoutDuration time.Duration `yaml:"lockout_duration"`
+	// BaseDelay is the delay after the first failure, it doubles with each
+	// failure up to MaxDelay.
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+}
+
+func (config bruteForceConfig) check() error {
+	if config.MaxFailures < 0 || config.Window < 0 || config.LockoutDuration < 0 || config.BaseDelay < 0 ||
+		config.MaxDelay < 0 {
+		return errors.New("the brute force settings cannot be negative")
+	}
+	if config.BaseDelay > 0 && config.MaxDelay > 0 && config.BaseDelay > config.MaxDelay {
+		return errors.New("base_delay cannot exceed max_delay")
+	}
+	return nil
+}
+
+func (config bruteForceConfig) window() time.Duration {
+	return durationOrDefault(config.Window, defaultAuthFailureWindow)
+}
+
+func (config bruteForceConfig) lockoutDuration() time.Duration {
+	return durationOrDefault(config.LockoutDuration, defaultAuthLockoutDuration)
+}
+
+// delay returns the delay after the failures.
+func (config bruteForceConfig) delay(failures int) time.Duration {
+	baseDelay := durationOrDefault(config.BaseDelay, defaultAuthBaseDelay)
+	maxDelay := durationOrDefault(config.MaxDelay, defaultAuthMaxDelay)
+	delay := float64(baseDelay) * math.Pow(2, float64(failures-1))
+	return time.Duration(math.Min(delay, float64(maxDelay)))
+}
+
+func durationOrDefault(value time.Duration, defaultValue time.Duration) time.Duration {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+type authFailureEntry struct {
+	failures    int
+	lastFailure time.Time
+	blockedTill time.Time
+	locked      bool
+}
+
+// authFailureTracker tracks the failures of the IPs and of the users, it
+// is safe for concurrent use.
+type authFailureTracker struct {
+	config        bruteForceConfig
+	authenticator *authn.Authenticator
+	now           func() time.Time
+
+	mutex     sync.Mutex
+	entries   map[string]*authFailureEntry
+	lastSweep time.Time
+}
+
+func newAuthFailureTracker(config bruteForceConfig, authenticator *authn.Authenticator) *authFailureTracker {
+	return &authFailureTracker{config: config, authenticator: authenticator, now: time.Now,
+		entries: make(map[string]*authFailureEntry)}
+}
+
+func (t *authFailureTracker) enabled() bool {
+	return t != nil && t.config.MaxFailures > 0
+}
+
+// keys returns the keys of the request, the claimed user only for the
+// requests without a valid session.
+func (t *authFailureTracker) keys(r *http.Request) []string {
+	keys := []string{authFailureKeyIP + ":" + remoteIPFromRequest(r)}
+	if t.authenticator == nil || t.authenticator.GetVerifiedUserName(r) != "" {
+		return keys
+	}
+	if username := t.authenticator.ClaimedUserName(r); username != "" {
+		keys = append(keys, authFailureKeyUser+":"+username)
+	}
+	return keys
+}
+
+// recordFailure counts a failure of the request and delays or locks out
+// its IP and claimed user.
+func (t *authFailureTracker) recordFailure(r *http.Request, reason string) {
+	if !t.enabled() {
+		return
+	}
+	metrics.MetricLogAuthFailure(reason)
+	now := t.now()
+	keys := t.keys(r)
+	var lockedOut []string
+	t.mutex.Lock()
+	t.sweep(now)
+	for _, key := range keys {
+		entry, ok := t.entries[key]
+		if !ok || (entry.locked && now.After(entry.blockedTill)) ||
+			(!entry.locked && now.Sub(entry.lastFailure) > t.config.window()) {
+			entry = &authFailureEntry{}
+			t.entries[key] = entry
+		}
+		if entry.locked {
+			continue
+		}
+		entry.failures++
+		entry.lastFailure = now
+		if entry.failures >= t.config.MaxFailures {
+			entry.locked = true
+			entry.blockedTill = now.Add(t.config.lockoutDuration())
+			lockedOut = append(lockedOut, key)
+			continue
+		}
+		entry.blockedTill = now.Add(t.config.delay(entry.failures))
+	}
+	t.updateLockoutMetrics()
+	t.mutex.Unlock()
+	for _, key := range lockedOut {
+		recordSecurityEvent(r, securityEventAuthLockout, "key", key, "reason", reason)
+	}
+}
+
+// blocked returns the time until the request may be retried, 0 when it is
+// not blocked.
+func (t *authFailureTracker) blocked(r *http.Request) (time.Duration, string) {
+	now := t.now()
+	keys := t.keys(r)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var wait time.Duration
+	var blockedKey string
+	for _, key := range keys {
+		entry, ok := t.entries[key]
+		if !ok {
+			continue
+		}
+		if remaining := entry.blockedTill.Sub(now); remaining > wait {
+			wait = remaining
+			blockedKey = key
+		}
+	}
+	return wait, blockedKey
+}
+
+// sweep drops the entries past their window and lockout, the caller holds
+// the mutex.
+func (t *authFailureTracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	for key, entry := range t.entries {
+		if now.After(entry.blockedTill) && now.Sub(entry.lastFailure) > t.config.window() {
+			delete(t.entries, key)
+		}
+	}
+	t.lastSweep = now
+	t.updateLockoutMetrics()
+}
+
+func (t *authFailureTracker) updateLockoutMetrics() {
+	counts := map[string]int{authFailureKeyIP: 0, authFailureKeyUser: 0}
+	now := t.now()
+	for key, entry := range t.entries {
+		if entry.locked && now.Before(entry.blockedTill) {
+			if strings.HasPrefix(key, authFailureKeyIP+":") {
+				counts[authFailureKeyIP]++
+			} else {
+				counts[authFailureKeyUser]++
+			}
+		}
+	}
+	for keyType, count := range counts {
+		metrics.MetricSetAuthLockouts(keyType, count)
+	}
+}
+
+// authnSecurityEvent records the security events of the authenticator and
+// counts its failures.
+func (t *authFailureTracker) authnSecurityEvent(r *http.Request, event string, reason error) {
+	authnSecurityEvent(r, event, reason)
+	switch event {
+	case authn.SecurityEventInvalidCookie, authn.SecurityEventInvalidState, authn.SecurityEventLoginFailed:
+		t.recordFailure(r, event)
+	}
+}
+
+func (t *authFailureTracker) Handler(handler http.Handler) http.Handler {
+	if !t.enabled() {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait, key := t.blocked(r)
+		if wait <= 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		recordSecurityEvent(r, securityEventAuthThrottled, "key", key)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "too many failed authentications", http.StatusTooManyRequests)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+)
+
+func TestBruteForceProtection(t *testing.T) {
+	authenticator := authn.NewAuthenticator(authn.OpenIDConfig{}, "smallpoint", nil, []string{"secret"}, nil)
+	tracker := newAuthFailureTracker(bruteForceConfig{MaxFailures: 3, BaseDelay: time.Second,
+		MaxDelay: 4 * time.Second, LockoutDuration: time.Hour}, authenticator)
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+	authenticator.SetSecurityEventFunc(tracker.authnSecurityEvent)
+	handler := tracker.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticator.GetRemoteUserName(w, r)
+	}))
+	forger := authn.NewAuthenticator(authn.OpenIDConfig{}, "smallpoint", nil, []string{"guess"}, nil)
+	newRequest := func(remoteAddr string, authenticator *authn.Authenticator, username string) *http.Request {
+		req := httptest.NewRequest(getMethod, "/", nil)
+		req.RemoteAddr = remoteAddr
+		cookie := testGenValidCookie(authenticator, username)
+		req.AddCookie(&cookie)
+		return req
+	}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a forged cookie delays the next attempt of the IP and of the user
+	serve(newRequest("10.0.0.1:1000", forger, "victim"))
+	rr := serve(newRequest("10.0.0.1:1000", forger, "victim"))
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Fatalf("the delayed attempt returned %d, retry after %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := serve(newRequest("10.0.0.2:1000", forger, "victim")); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("the attempt on the user from another IP returned %d", rr.Code)
+	}
+	// the valid session of the user is not delayed
+	if rr := serve(newRequest("10.0.0.2:1000", authenticator, "victim")); rr.Code == http.StatusTooManyRequests {
+		t.Fatal("the valid session was delayed")
+	}
+	now = now.Add(time.Second + time.Millisecond)
+	serve(newRequest("10.0.0.3:1000", forger, "victim"))
+	if wait, key := tracker.blocked(newRequest("10.0.0.4:1000", forger, "victim")); wait != 2*time.Second ||
+		key != "user:victim" {
+		t.Fatalf("the second delay is %s for %q", wait, key)
+	}
+	now = now.Add(2*time.Second + time.Millisecond)
+	serve(newRequest("10.0.0.5:1000", forger, "victim"))
+	if wait, _ := tracker.blocked(newRequest("10.0.0.6:1000", forger, "victim")); wait != time.Hour {
+		t.Fatalf("the user is not locked out, wait %s", wait)
+	}
+
+	// the lockout ends after its duration
+	now = now.Add(time.Hour + time.Second)
+	if wait, _ := tracker.blocked(newRequest("10.0.0.6:1000", forger, "victim")); wait != 0 {
+		t.Fatalf("the lockout did not end, wait %s", wait)
+	}
+	serve(newRequest("10.0.0.6:1000", forger, "victim"))
+	if wait, _ := tracker.blocked(newRequest("10.0.0.7:1000", forger, "victim")); wait != time.Second {
+		t.Fatalf("the failures were not reset, wait %s", wait)
+	}
+
+	// the expired cookies are not failures
+	expired, err := authenticator.GenUserCookieValue("user1", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(getMethod, "/", nil)
+	req.RemoteAddr = "10.0.0.8:1000"
+	req.AddCookie(&http.Cookie{Name: authn.AuthCookieName, Value: expired})
+	serve(req)
+	if wait, _ := tracker.blocked(req); wait != 0 {
+		t.Fatal("the expired cookie was counted as a failure")
+	}
+
+	if tracker := newAuthFailureTracker(bruteForceConfig{}, authenticator); tracker.enabled() {
+		t.Fatal("the protection is enabled without max_failures")
+	}
+	if err := (bruteForceConfig{BaseDelay: time.Minute, MaxDelay: time.Second}).check(); err == nil {
+		t.Fatal("base delay over the max delay was accepted")
+	}
+}
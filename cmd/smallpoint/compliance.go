@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+const (
+	compliancePeriodWeekly  = "weekly"
+	compliancePeriodMonthly = "monthly"
+
+	complianceCheckInterval = time.Hour
+)
+
+type complianceReportConfig struct {
+	// Periods is a list of weekly and/or monthly.
+	Periods []string `yaml:"periods"`
+	// Reports are emailed to Recipients and/or written to OutputDirectory.
+	Recipients      []string `yaml:"recipients"`
+	OutputDirectory string   `yaml:"output_directory"`
+}
+
+type groupChurn struct {
+	Groupname string
+	Added     int
+	Removed   int
+}
+
+type complianceReport struct {
+	Period        string
+	Start         time.Time
+	End           time.Time
+	NewGroups     []auditEvent
+	DeletedGroups []auditEvent
+	Churn         []groupChurn
+	// submitted, approved, rejected, cancelled, expired
+	Requests map[string]int
+}
+
+var complianceRequestOutcomes = []struct {
+	Action  string
+	Outcome string
+}{
+	{auditActionRequestAccess, "submitted"},
+	{auditActionApproveRequest, "approved"},
+	{auditActionRejectRequest, "rejected"},
+	{auditActionCancelRequest, "cancelled"},
+	{auditActionExpireRequest, "expired"},
+}
+
+// lastCompleteCompliancePeriod returns the last full week (starting on
+// monday) or month before now.
+func lastCompleteCompliancePeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case compliancePeriodWeekly:
+		daysSinceMonday := (int(today.Weekday()) + 6) % 7
+		end := today.AddDate(0, 0, -daysSinceMonday)
+		return end.AddDate(0, 0, -7), end, nil
+	case compliancePeriodMonthly:
+		end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return end.AddDate(0, -1, 0), end, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid compliance report period '%s'", period)
+}
+
+func (state *RuntimeState) generateComplianceReport(period string, start, end time.Time) (*complianceReport, error) {
+	events, err := searchAuditEventsInDB(auditEventFilter{From: start, To: end}, state)
+	if err != nil {
+		return nil, err
+	}
+	report := &complianceReport{Period: period, Start: start, End: end,
+		Requests: make(map[string]int)}
+	churn := make(map[string]*groupChurn)
+	getChurn := func(groupname string) *groupChurn {
+		entry, ok := churn[groupname]
+		if !ok {
+			entry = &groupChurn{Groupname: groupname}
+			churn[groupname] = entry
+		}
+		return entry
+	}
+	// events are returned newest first
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		if event.Outcome != auditOutcomeSuccess {
+			continue
+		}
+		switch event.Action {
+		case auditActionCreateGroup:
+			report.NewGroups = append(report.NewGroups, event)
+		case auditActionDeleteGroup:
+			report.DeletedGroups = append(report.DeletedGroups, event)
+		case auditActionAddMember:
+			getChurn(event.Groupname).Added++
+		case auditActionRemoveMember, auditActionExitGroup:
+			getChurn(event.Groupname).Removed++
+		}
+		for _, requestOutcome := range complianceRequestOutcomes {
+			if event.Action == requestOutcome.Action {
+				report.Requests[requestOutcome.Outcome]++
+			}
+		}
+	}
+	for _, entry := range churn {
+		report.Churn = append(report.Churn, *entry)
+	}
+	sort.Slice(report.Churn, func(i, j int) bool {
+		return report.Churn[i].Groupname < report.Churn[j].Groupname
+	})
+	return report, nil
+}
+
+func (report *complianceReport) title() string {
+	return fmt.Sprintf("Smallpoint %s compliance report %s - %s", report.Period,
+		report.Start.Format(auditDateLayout), report.End.AddDate(0, 0, -1).Format(auditDateLayout))
+}
+
+func (report *complianceReport) filenameBase() string {
+	return fmt.Sprintf("compliance_%s_%s", report.Period, report.Start.Format(auditDateLayout))
+}
+
+func (report *complianceReport) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+	records := [][]string{{"section", "name", "value"}}
+	for _, event := range report.NewGroups {
+		records = append(records, []string{"new_group", event.Groupname, event.Actor})
+	}
+	for _, event := range report.DeletedGroups {
+		records = append(records, []string{"deleted_group", event.Groupname, event.Actor})
+	}
+	for _, entry := range report.Churn {
+		records = append(records, []string{"members_added", entry.Groupname, strconv.Itoa(entry.Added)},
+			[]string{"members_removed", entry.Groupname, strconv.Itoa(entry.Removed)})
+	}
+	for _, requestOutcome := range complianceRequestOutcomes {
+		records = append(records, []string{"requests", requestOutcome.Outcome,
+			strconv.Itoa(report.Requests[requestOutcome.Outcome])})
+	}
+	err := csvWriter.WriteAll(records)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (report *complianceReport) textLines() []string {
+	lines := []string{report.title(), ""}
+	lines = append(lines, fmt.Sprintf("New groups (%d)", len(report.NewGroups)))
+	for _, event := range report.NewGroups {
+		lines = append(lines, fmt.Sprintf("  %-40s by %-20s %s", event.Groupname, event.Actor,
+			event.Timestamp.UTC().Format(time.RFC3339)))
+	}
+	lines = append(lines, "", fmt.Sprintf("Deleted groups (%d)", len(report.DeletedGroups)))
+	for _, event := range report.DeletedGroups {
+		lines = append(lines, fmt.Sprintf("  %-40s by %-20s %s", event.Groupname, event.Actor,
+			event.Timestamp.UTC().Format(time.RFC3339)))
+	}
+	lines = append(lines, "", "Membership churn", fmt.Sprintf("  %-40s %8s %8s", "group", "added", "removed"))
+	for _, entry := range report.Churn {
+		lines = append(lines, fmt.Sprintf("  %-40s %8d %8d", entry.Groupname, entry.Added, entry.Removed))
+	}
+	lines = append(lines, "", "Access requests")
+	for _, requestOutcome := range complianceRequestOutcomes {
+		lines = append(lines, fmt.Sprintf("  %-40s %8d", requestOutcome.Outcome,
+			report.Requests[requestOutcome.Outcome]))
+	}
+	return lines
+}
+
+func (report *complianceReport) PDF() ([]byte, error) {
+	var buf bytes.Buffer
+	err := writeTextPDF(&buf, report.title(), report.textLines())
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deliverComplianceReport writes the report to the output directory and
+// emails it, depending on what is configured.
+func (state *RuntimeState) deliverComplianceReport(report *complianceReport) error {
+	csvData, err := report.CSV()
+	if err != nil {
+		return err
+	}
+	pdfData, err := report.PDF()
+	if err != nil {
+		return err
+	}
+	attachments := []emailAttachment{
+		{Filename: report.filenameBase() + ".csv", ContentType: "text/csv", Data: csvData},
+		{Filename: report.filenameBase() + ".pdf", ContentType: "application/pdf", Data: pdfData},
+	}
+	config := state.Config.ComplianceReports
+	if config.OutputDirectory != "" {
+		for _, attachment := range attachments {
+			err = ioutil.WriteFile(filepath.Join(config.OutputDirectory, attachment.Filename),
+				attachment.Data, 0640)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(config.Recipients) > 0 {
+		body := fmt.Sprintf("Attached is the %s.\n", report.title())
+		err = state.sendEmailWithAttachments(config.Recipients, report.title(), body, attachments)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var complianceReportExistsStmt = map[string]string{
+	"sqlite":   "select count(*) from compliance_reports where period=? and period_start=?;",
+	"postgres": "select count(*) from compliance_reports where period=$1 and period_start=$2;",
+}
+
+var insertComplianceReportStmt = map[string]string{
+	"sqlite":   "insert into compliance_reports(period, period_start, generated_at) values (?,?,?);",
+	"postgres": "insert into compliance_reports(period, period_start, generated_at) values ($1,$2,$3);",
+}
+
+func complianceReportExistsInDB(period string, start time.Time, state *RuntimeState) (bool, error) {
+	var count int
+	err := state.db.QueryRow(complianceReportExistsStmt[state.dbType], period, start.Unix()).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func insertComplianceReportInDB(period string, start time.Time, state *RuntimeState) error {
+	startTime := time.Now()
+	_, err := state.db.Exec(insertComplianceReportStmt[state.dbType], period, start.Unix(), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(startTime))
+	return nil
+}
+
+// runComplianceReports generates every configured report whose last period
+// has not been reported yet. It runs periodically so that reports missed
+// while smallpoint was down are generated on startup.
+func (state *RuntimeState) runComplianceReports() error {
+	for _, period := range state.Config.ComplianceReports.Periods {
+		start, end, err := lastCompleteCompliancePeriod(period, time.Now())
+		if err != nil {
+			return err
+		}
+		exists, err := complianceReportExistsInDB(period, start, state)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		report, err := state.generateComplianceReport(period, start, end)
+		if err != nil {
+			return err
+		}
+		err = state.deliverComplianceReport(report)
+		if err != nil {
+			return err
+		}
+		err = insertComplianceReportInDB(period, start, state)
+		if err != nil {
+			return err
+		}
+		slog.Info("generated compliance report", "title", report.title())
+	}
+	return nil
+}
+
+func validateComplianceReportConfig(config complianceReportConfig) error {
+	for _, period := range config.Periods {
+		_, _, err := lastCompleteCompliancePeriod(period, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	if config.OutputDirectory != "" {
+		fileInfo, err := os.Stat(config.OutputDirectory)
+		if err != nil {
+			return err
+		}
+		if !fileInfo.IsDir() {
+			return fmt.Errorf("compliance report output %s is not a directory", config.OutputDirectory)
+		}
+	}
+	return nil
+}
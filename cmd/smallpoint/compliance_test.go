@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLastCompleteCompliancePeriod(t *testing.T) {
+	// a wednesday
+	now := time.Date(2019, time.March, 13, 15, 4, 5, 0, time.UTC)
+	start, end, err := lastCompleteCompliancePeriod(compliancePeriodWeekly, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(time.Date(2019, time.March, 4, 0, 0, 0, 0, time.UTC)) ||
+		!end.Equal(time.Date(2019, time.March, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("bad weekly period %s - %s", start, end)
+	}
+	start, end, err = lastCompleteCompliancePeriod(compliancePeriodMonthly, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(time.Date(2019, time.February, 1, 0, 0, 0, 0, time.UTC)) ||
+		!end.Equal(time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("bad monthly period %s - %s", start, end)
+	}
+	_, _, err = lastCompleteCompliancePeriod("daily", now)
+	if err == nil {
+		t.Fatal("invalid period should fail")
+	}
+}
+
+func TestComplianceReportDelivery(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "compliance_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.Config.ComplianceReports.OutputDirectory = dir
+	state.Config.ComplianceReports.Recipients = []string{"auditors@example.com"}
+	mockSMTP := &smtpDialerMock{}
+	smtpClient = func(addr string) (smtpDialer, error) {
+		return mockSMTP, nil
+	}
+
+	start := time.Now().Add(-time.Minute)
+	for _, action := range []string{auditActionCreateGroup, auditActionAddMember, auditActionAddMember,
+		auditActionRequestAccess, auditActionApproveRequest} {
+		err = state.recordAuditEvent(nil, "user1", action, "compliance-group", "user2", auditOutcomeSuccess, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := state.generateComplianceReport(compliancePeriodWeekly, start, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.NewGroups) < 1 || report.Requests["approved"] < 1 {
+		t.Fatalf("bad report %+v", report)
+	}
+	found := false
+	for _, entry := range report.Churn {
+		if entry.Groupname == "compliance-group" && entry.Added == 2 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("churn not reported %+v", report.Churn)
+	}
+	err = state.deliverComplianceReport(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdfData, err := ioutil.ReadFile(filepath.Join(dir, report.filenameBase()+".pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(pdfData, []byte("%PDF-")) || !bytes.Contains(pdfData, []byte("compliance-group")) {
+		t.Fatal("bad pdf report")
+	}
+	csvData, err := ioutil.ReadFile(filepath.Join(dir, report.filenameBase()+".csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(csvData, []byte("members_added,compliance-group,2")) {
+		t.Fatalf("bad csv report %s", csvData)
+	}
+	if !bytes.Contains(mockSMTP.Buffer.Buffer.Bytes(), []byte("Content-Type: application/pdf")) {
+		t.Fatal("pdf not attached to email")
+	}
+}
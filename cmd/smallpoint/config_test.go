@@ -30,7 +30,7 @@ func TestLoadConfigFileBase(t *testing.T) {
 	log.Printf("New config filaneme=%s", configFilename)
 	//prepare secrets file
 	secretsFilename := filepath.Join(dir, "sharedSecrets.txt")
-	secretsText := "supersecret\n"
+	secretsText := "supersecret-0123456789abcdefghijklm\n"
 	err = ioutil.WriteFile(secretsFilename, []byte(secretsText), 0644)
 	if err != nil {
 		t.Fatal(err)
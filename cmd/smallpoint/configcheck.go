@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The -check-config flag validates the configuration file and exits, it
+// reports every problem found with the setting to fix instead of failing at
+// the first one half-way through the startup. With -probe it also connects
+// to the directories, the OpenID provider, the SMTP server and the database.
+
+const configProbeTimeout = 10 * time.Second
+
+type configProblem struct {
+	Setting string
+	Message string
+}
+
+type configChecker struct {
+	config   *AppConfigFile
+	problems []configProblem
+}
+
+func (checker *configChecker) addf(setting string, format string, args ...interface{}) {
+	checker.problems = append(checker.problems, configProblem{Setting: setting,
+		Message: fmt.Sprintf(format, args...)})
+}
+
+// checkError adds err when it is not nil.
+func (checker *configChecker) checkError(setting string, err error) {
+	if err != nil {
+		checker.addf(setting, "%s", err)
+	}
+}
+
+func (checker *configChecker) requireSet(setting string, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		checker.addf(setting, "is required")
+		return false
+	}
+	return true
+}
+
+func (checker *configChecker) checkFileReadable(setting string, filename string) bool {
+	if filename == "" {
+		return false
+	}
+	_, err := ioutil.ReadFile(filename)
+	if err != nil {
+		checker.addf(setting, "cannot read %s: %s", filename, err)
+		return false
+	}
+	return true
+}
+
+func (checker *configChecker) checkDirectory(setting string, dir string) {
+	if dir == "" {
+		return
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		checker.addf(setting, "%s", err)
+		return
+	}
+	if !info.IsDir() {
+		checker.addf(setting, "%s is not a directory", dir)
+	}
+}
+
+func (checker *configChecker) checkBase() {
+	base := checker.config.Base
+	if checker.requireSet("base.http_address", base.HttpAddress) {
+		if _, _, err := net.SplitHostPort(base.HttpAddress); err != nil {
+			checker.addf("base.http_address", "must be host:port or :port: %s", err)
+		}
+	}
+	// the certificate of ACME is not in files
+	acme := checker.config.TLS.ACME.enabled()
+	certSet := !acme && checker.requireSet("base.tls_cert_filename", base.TLSCertFilename)
+	keySet := !acme && checker.requireSet("base.tls_key_filename", base.TLSKeyFilename)
+	if certSet && keySet && checker.checkFileReadable("base.tls_cert_filename", base.TLSCertFilename) &&
+		checker.checkFileReadable("base.tls_key_filename", base.TLSKeyFilename) {
+		if _, err := tls.LoadX509KeyPair(base.TLSCertFilename, base.TLSKeyFilename); err != nil {
+			checker.addf("base.tls_cert_filename", "the certificate and key do not form a pair: %s", err)
+		}
+	}
+	if checker.checkFileReadable("base.client_ca_filename", base.ClientCAFilename) {
+		caCert, _ := ioutil.ReadFile(base.ClientCAFilename)
+		if !x509.NewCertPool().AppendCertsFromPEM(caCert) {
+			checker.addf("base.client_ca_filename", "no PEM certificates in %s", base.ClientCAFilename)
+		}
+	}
+	if _, err := os.Stat(base.ClusterSharedSecretFilename); base.ProvisionSharedSecrets != sharedSecretProvisioningFile ||
+		!os.IsNotExist(err) {
+		checker.checkFileReadable("base.cluster_shared_secret_filename", base.ClusterSharedSecretFilename)
+	}
+	checker.checkError("base.provision_shared_secrets",
+		checkSharedSecretProvisioning(base.ProvisionSharedSecrets, base.ClusterSharedSecretFilename))
+	checker.checkDirectory("base.templates_path", base.TemplatesPath)
+	checker.checkDirectory("base.override_path", base.OverridePath)
+	checker.checkDirectory("base.log_directory", base.LogDirectory)
+	storage := strings.SplitN(base.StorageURL, ":", 2)
+	switch storage[0] {
+	case "", "postgresql":
+	case "sqlite":
+		if len(storage) != 2 {
+			checker.addf("base.storage_url", "must start with sqlite: or postgresql:")
+		} else if storage[1] != "" {
+			checker.checkDirectory("base.storage_url", filepath.Dir(storage[1]))
+		}
+	default:
+		checker.addf("base.storage_url", "must start with sqlite: or postgresql:")
+	}
+}
+
+func (checker *configChecker) checkSMTP() {
+	base := checker.config.Base
+	if checker.requireSet("base.smtp_server", base.SMTPserver) {
+		if _, _, err := net.SplitHostPort(base.SMTPserver); err != nil {
+			checker.addf("base.smtp_server", "must be host:port: %s", err)
+		}
+	}
+	if checker.requireSet("base.smtp_sender_address", base.SmtpSenderAddress) {
+		if _, err := mail.ParseAddress(base.SmtpSenderAddress); err != nil {
+			checker.addf("base.smtp_sender_address", "is not a mail address: %s", err)
+		}
+	}
+}
+
+// setting is a setting of a section and its value.
+type setting struct {
+	name  string
+	value string
+}
+
+func (checker *configChecker) checkLDAP(section string, urls string, bindUsername string,
+	bindPassword string, baseDNs []setting) {
+	if checker.requireSet(section+".ldap_target_urls", urls) {
+		for _, ldapURL := range strings.Split(urls, ",") {
+			parsedURL, err := url.Parse(strings.TrimSpace(ldapURL))
+			if err != nil || parsedURL.Scheme != "ldaps" || parsedURL.Host == "" {
+				checker.addf(section+".ldap_target_urls", "'%s' is not an ldaps://host[:port] URL", ldapURL)
+			}
+		}
+	}
+	checker.requireSet(section+".bind_username", bindUsername)
+	checker.requireSet(section+".bind_password", bindPassword)
+	for _, baseDN := range baseDNs {
+		checker.requireSet(section+"."+baseDN.name, baseDN.value)
+	}
+}
+
+func (checker *configChecker) checkOpenID() {
+	openID := checker.config.OpenID
+	checker.requireSet("openid.client_id", openID.ClientID)
+	checker.requireSet("openid.client_secret", openID.ClientSecret)
+	for _, providerURL := range []setting{
+		{"openid.auth_url", openID.AuthURL},
+		{"openid.token_url", openID.TokenURL},
+		{"openid.userinfo_url", openID.UserinfoURL},
+	} {
+		if !checker.requireSet(providerURL.name, providerURL.value) {
+			continue
+		}
+		parsedURL, err := url.Parse(providerURL.value)
+		if err != nil || parsedURL.Scheme != "https" || parsedURL.Host == "" {
+			checker.addf(providerURL.name, "'%s' is not an https URL", providerURL.value)
+		}
+	}
+}
+
+// checkSections runs the validations of the sections done by the server at
+// startup.
+func (checker *configChecker) checkSections() {
+	config := checker.config
+	_, err := config.Logging.newHandler(ioutil.Discard)
+	checker.checkError("logging", err)
+	if len(config.ComplianceReports.Periods) > 0 {
+		checker.checkError("compliance_reports", validateComplianceReportConfig(config.ComplianceReports))
+	}
+	if config.Retention.enabled() {
+		checker.checkError("retention", validateRetentionConfig(config.Retention))
+	}
+	_, err = newCredentialRotator(config.ServiceAccounts.CredentialRotation, nil)
+	checker.checkError("service_accounts.credential_rotation", err)
+	_, err = config.ServiceAccounts.Naming.compilePattern()
+	checker.checkError("service_accounts.naming", err)
+	checker.checkError("gid_allocation", config.GidAllocation.check())
+	checker.checkError("theme", config.Theme.check())
+	_, err = loadValueEncrypter(config.DBEncryption)
+	checker.checkError("db_encryption", err)
+	if config.SCIM.Enabled {
+		_, err = loadSCIMTokens(config.SCIM.TokensFilename)
+		checker.checkError("scim.tokens_filename", err)
+	}
+	checker.checkError("scim_provisioning", config.SCIMProvisioning.check())
+	checker.checkError("github_team_sync", config.GitHubTeamSync.check())
+	checker.checkError("okta", config.Okta.check())
+	checker.checkError("aws_identity_center", config.AWSIdentityCenter.check())
+	checker.checkError("event_publishing", config.EventPublishing.check())
+	checker.checkError("ticketing", config.Ticketing.check())
+	checker.checkError("security_headers", config.SecurityHeaders.check())
+	checker.checkError("secrets", config.Secrets.check())
+	checker.checkError("brute_force", config.BruteForce.check())
+	checker.checkError("reauthentication", config.Reauthentication.check())
+	checker.checkError("admin_network", config.AdminNetwork.check())
+	checker.checkError("tls", config.TLS.check(config.Base, config.Audit))
+	checker.checkError("privilege_escalation", config.PrivilegeEscalation.check())
+	checker.checkError("sql_directory", config.SQLDirectory.check(config.SourceLDAP.LDAPTargetURLs))
+	if config.HRWebhook.enabled() {
+		_, err = loadWebhookSecret(config.HRWebhook.SecretFilename)
+		checker.checkError("hr_webhook.secret_filename", err)
+	}
+	if config.WebhookSigning.enabled() {
+		_, err = loadWebhookSecret(config.WebhookSigning.SecretFilename)
+		checker.checkError("webhook_signing.secret_filename", err)
+	}
+}
+
+func (checker *configChecker) probeLDAP() {
+	config := checker.config
+	if !config.SQLDirectory.Enabled {
+		checker.checkError("target_config", config.TargetLDAP.Ping())
+	}
+	if config.SourceLDAP.LDAPTargetURLs != "" {
+		checker.checkError("source_config", config.SourceLDAP.Ping())
+	}
+}
+
+func (checker *configChecker) probeSMTP() {
+	conn, err := net.DialTimeout("tcp", checker.config.Base.SMTPserver, configProbeTimeout)
+	if err != nil {
+		checker.addf("base.smtp_server", "cannot connect: %s", err)
+		return
+	}
+	conn.Close()
+}
+
+func (checker *configChecker) probeOpenID() {
+	// any HTTP response shows the provider is reachable
+	client := &http.Client{Timeout: configProbeTimeout}
+	resp, err := client.Get(checker.config.OpenID.TokenURL)
+	if err != nil {
+		checker.addf("openid.token_url", "cannot connect: %s", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// probe connects to the external services, it is only run on a config
+// without problems.
+func (checker *configChecker) probe() {
+	config := checker.config
+	checker.probeLDAP()
+	checker.probeSMTP()
+	checker.probeOpenID()
+	if strings.HasPrefix(config.Base.StorageURL, "postgresql:") {
+		db, err := sql.Open("postgres", config.Base.StorageURL)
+		if err == nil {
+			err = db.Ping()
+			db.Close()
+		}
+		if err != nil {
+			checker.addf("base.storage_url", "cannot connect: %s", err)
+		}
+	}
+}
+
+// checkConfigFile returns the problems of the configuration, the error is
+// set when the file cannot be read or parsed.
+func checkConfigFile(configFilename string, probe bool) ([]configProblem, error) {
+	source, err := ioutil.ReadFile(configFilename)
+	if err != nil {
+		return nil, err
+	}
+	var config AppConfigFile
+	err = yaml.Unmarshal(source, &config)
+	if err != nil {
+		return nil, err
+	}
+	checker := &configChecker{config: &config}
+	// the unknown settings are usually misspelled ones
+	if err = yaml.UnmarshalStrict(source, &AppConfigFile{}); err != nil {
+		checker.addf("", "%s", err)
+	}
+	checker.checkBase()
+	checker.checkSMTP()
+	// the SQL directory replaces the target LDAP
+	if !config.SQLDirectory.Enabled {
+		checker.checkLDAP("target_config", config.TargetLDAP.LDAPTargetURLs, config.TargetLDAP.BindUsername,
+			config.TargetLDAP.BindPassword, []setting{
+				{"user_search_base_dns", config.TargetLDAP.UserSearchBaseDNs},
+				{"group_search_base_dns", config.TargetLDAP.GroupSearchBaseDNs},
+			})
+	}
+	if config.SourceLDAP.LDAPTargetURLs != "" {
+		checker.checkLDAP("source_config", config.SourceLDAP.LDAPTargetURLs, config.SourceLDAP.BindUsername,
+			config.SourceLDAP.BindPassword, []setting{
+				{"user_search_base_dns", config.SourceLDAP.UserSearchBaseDNs},
+			})
+	}
+	checker.checkOpenID()
+	checker.checkSections()
+	if probe && len(checker.problems) == 0 {
+		checker.probe()
+	}
+	return checker.problems, nil
+}
+
+func checkConfigCommand(configFilename string, probe bool, out io.Writer) int {
+	problems, err := checkConfigFile(configFilename, probe)
+	if err != nil {
+		fmt.Fprintf(out, "Cannot load %s: %s\n", configFilename, err)
+		return 1
+	}
+	for _, problem := range problems {
+		if problem.Setting == "" {
+			fmt.Fprintf(out, "%s\n", problem.Message)
+			continue
+		}
+		fmt.Fprintf(out, "%s: %s\n", problem.Setting, problem.Message)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(out, "%s has %d problems\n", configFilename, len(problems))
+		return 1
+	}
+	if probe {
+		fmt.Fprintf(out, "%s is valid and the services are reachable\n", configFilename)
+	} else {
+		fmt.Fprintf(out, "%s is valid\n", configFilename)
+	}
+	return 0
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testWriteCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "smallpoint.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFilename := filepath.Join(dir, "cert.pem")
+	keyFilename := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFilename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFilename, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFilename, keyFilename
+}
+
+func TestCheckConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configcheck_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFilename := filepath.Join(dir, "config.yml")
+	config := AppConfigFile{}
+	config.Base.HttpAddress = ":443"
+	config.Base.TLSCertFilename, config.Base.TLSKeyFilename = testWriteCertificate(t, dir)
+	config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "smallpoint.db")
+	config.Base.SMTPserver = "smtp.example.com:25"
+	config.Base.SmtpSenderAddress = "smallpoint@example.com"
+	config.TargetLDAP.LDAPTargetURLs = "ldaps://ldap.example.com"
+	config.TargetLDAP.BindUsername = "cn=smallpoint"
+	config.TargetLDAP.BindPassword = "secret"
+	config.TargetLDAP.UserSearchBaseDNs = "ou=people,dc=example,dc=com"
+	config.TargetLDAP.GroupSearchBaseDNs = "ou=groups,dc=example,dc=com"
+	config.OpenID.ClientID = "smallpoint"
+	config.OpenID.ClientSecret = "secret"
+	config.OpenID.AuthURL = "https://idp.example.com/auth"
+	config.OpenID.TokenURL = "https://idp.example.com/token"
+	config.OpenID.UserinfoURL = "https://idp.example.com/userinfo"
+	err = writeConfig(configFilename, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if code := checkConfigCommand(configFilename, false, &out); code != 0 {
+		t.Fatalf("valid config returned %d: %s", code, out.String())
+	}
+	// ACME needs no certificate files
+	certFilename, keyFilename := config.Base.TLSCertFilename, config.Base.TLSKeyFilename
+	config.Base.TLSCertFilename, config.Base.TLSKeyFilename = "", ""
+	config.TLS.ACME = acmeConfig{Hostnames: []string{"smallpoint.example.com"}, CacheDirectory: dir}
+	err = writeConfig(configFilename, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if code := checkConfigCommand(configFilename, false, &out); code != 0 {
+		t.Fatalf("valid ACME config returned %d: %s", code, out.String())
+	}
+	config.Base.TLSCertFilename, config.Base.TLSKeyFilename = certFilename, keyFilename
+	config.TLS.ACME = acmeConfig{}
+
+	config.Base.TLSKeyFilename = filepath.Join(dir, "missing.pem")
+	config.Base.SmtpSenderAddress = "smallpoint"
+	config.TargetLDAP.LDAPTargetURLs = "ldap://ldap.example.com"
+	config.OpenID.TokenURL = ""
+	config.Theme.PrimaryColor = "blue"
+	config.Base.StorageURL = "sqlite"
+	err = writeConfig(configFilename, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if code := checkConfigCommand(configFilename, true, &out); code != 1 {
+		t.Fatalf("invalid config returned %d: %s", code, out.String())
+	}
+	for _, expected := range []string{"base.tls_key_filename: cannot read", "base.smtp_sender_address:",
+		"target_config.ldap_target_urls:", "openid.token_url: is required", "theme:", "base.storage_url: must start with sqlite:",
+		"has 6 problems"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("%q is not reported in %s", expected, out.String())
+		}
+	}
+
+	// the misspelled settings are reported
+	err = ioutil.WriteFile(configFilename, []byte("base:\n  http_adress: \":443\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := checkConfigFile(configFilename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) == 0 || !strings.Contains(problems[0].Message, "http_adress") {
+		t.Fatalf("unexpected problems %+v", problems)
+	}
+}
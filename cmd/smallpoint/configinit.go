@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The init command writes a new configuration file from the answers to
+// questions on the server, the LDAP directory, the OpenID provider and the
+// SMTP server. Each section is checked, and its service is connected to,
+// once it is answered, and the section is asked again until it passes or
+// its problems are accepted. The file has comments on the settings and
+// is readable by its owner only, it holds the bind password and the client
+// secret.
+
+const defaultStorageURL = "sqlite:/var/lib/smallpoint/smallpoint.db"
+
+// configWizard asks the questions of the init command.
+type configWizard struct {
+	in     *bufio.Reader
+	out    io.Writer
+	probe  bool
+	config AppConfigFile
+}
+
+// ask returns the answer to the question, the default when the answer is
+// empty.
+func (wizard *configWizard) ask(question string, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(wizard.out, "%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(wizard.out, "%s: ", question)
+	}
+	answer, err := wizard.in.ReadString('\n')
+	if err == io.EOF && answer == "" {
+		return "", errors.New("the input ended before the questions")
+	}
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+func (wizard *configWizard) confirm(question string) (bool, error) {
+	answer, err := wizard.ask(question+" (y/n)", "y")
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(strings.ToLower(answer), "y"), nil
+}
+
+// askSection asks the questions of a section until its check passes or its
+// problems are accepted.
+func (wizard *configWizard) askSection(title string, questions func() error,
+	check func(checker *configChecker)) error {
+	for {
+		fmt.Fprintf(wizard.out, "\n%s\n", title)
+		err := questions()
+		if err != nil {
+			return err
+		}
+		checker := &configChecker{config: &wizard.config}
+		check(checker)
+		if len(checker.problems) == 0 {
+			fmt.Fprintf(wizard.out, "%s: ok\n", title)
+			return nil
+		}
+		for _, problem := range checker.problems {
+			fmt.Fprintf(wizard.out, "  %s: %s\n", problem.Setting, problem.Message)
+		}
+		again, err := wizard.confirm("Answer the " + title + " questions again?")
+		if err != nil || !again {
+			return err
+		}
+	}
+}
+
+// askInto sets each setting to its answer, the current value is the
+// default.
+func (wizard *configWizard) askInto(questions []wizardQuestion) error {
+	for _, question := range questions {
+		answer, err := wizard.ask(question.text, *question.value)
+		if err != nil {
+			return err
+		}
+		*question.value = answer
+	}
+	return nil
+}
+
+type wizardQuestion struct {
+	text  string
+	value *string
+}
+
+func (wizard *configWizard) run() error {
+	config := &wizard.config
+	hostname, _ := os.Hostname()
+	config.Base.HttpAddress = ":443"
+	config.Base.Hostname = hostname
+	config.Base.TLSCertFilename = "/etc/smallpoint/server.pem"
+	config.Base.TLSKeyFilename = "/etc/smallpoint/server.key"
+	config.Base.StorageURL = defaultStorageURL
+	config.TargetLDAP.GroupManageAttribute = "owner"
+	config.TargetLDAP.SearchAttribute = "uid"
+	err := wizard.askSection("server", func() error {
+		return wizard.askInto([]wizardQuestion{
+			{"HTTPS listen address", &config.Base.HttpAddress},
+			{"Host name of the server in the URLs", &config.Base.Hostname},
+			{"TLS certificate file", &config.Base.TLSCertFilename},
+			{"TLS key file", &config.Base.TLSKeyFilename},
+			{"Database, sqlite:FILE or postgresql://...", &config.Base.StorageURL},
+		})
+	}, func(checker *configChecker) {
+		checker.checkBase()
+	})
+	if err != nil {
+		return err
+	}
+	target := &config.TargetLDAP
+	err = wizard.askSection("LDAP", func() error {
+		err := wizard.askInto([]wizardQuestion{
+			{"LDAP URLs, comma separated ldaps://host:port", &target.LDAPTargetURLs},
+			{"Bind DN", &target.BindUsername},
+			{"Bind password", &target.BindPassword},
+			{"Base DN of the users", &target.UserSearchBaseDNs},
+			{"Base DN of the groups", &target.GroupSearchBaseDNs},
+			{"Base DN of the service accounts", &target.ServiceAccountBaseDNs},
+			{"Attribute of the managing group of a group", &target.GroupManageAttribute},
+			{"Attribute of the user names", &target.SearchAttribute},
+			{"Super admins, comma separated user names", &target.Admins},
+		})
+		target.MainBaseDN = baseDNSuffix(target.UserSearchBaseDNs, target.GroupSearchBaseDNs)
+		return err
+	}, func(checker *configChecker) {
+		checker.checkLDAP("target_config", target.LDAPTargetURLs, target.BindUsername, target.BindPassword,
+			[]setting{
+				{"user_search_base_dns", target.UserSearchBaseDNs},
+				{"group_search_base_dns", target.GroupSearchBaseDNs},
+			})
+		checker.requireSet("target_config.super_admins", target.Admins)
+		if wizard.probe && len(checker.problems) == 0 {
+			checker.probeLDAP()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	openID := &config.OpenID
+	err = wizard.askSection("OpenID", func() error {
+		return wizard.askInto([]wizardQuestion{
+			{"Client ID", &openID.ClientID},
+			{"Client secret", &openID.ClientSecret},
+			{"Authorization endpoint URL", &openID.AuthURL},
+			{"Token endpoint URL", &openID.TokenURL},
+			{"Userinfo endpoint URL", &openID.UserinfoURL},
+		})
+	}, func(checker *configChecker) {
+		checker.checkOpenID()
+		if wizard.probe && len(checker.problems) == 0 {
+			checker.probeOpenID()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return wizard.askSection("SMTP", func() error {
+		return wizard.askInto([]wizardQuestion{
+			{"SMTP server, host:port", &config.Base.SMTPserver},
+			{"Sender address of the mails", &config.Base.SmtpSenderAddress},
+		})
+	}, func(checker *configChecker) {
+		checker.checkSMTP()
+		if wizard.probe && len(checker.problems) == 0 {
+			checker.probeSMTP()
+		}
+	})
+}
+
+// baseDNSuffix returns the RDNs common to the end of both DNs.
+func baseDNSuffix(dn1 string, dn2 string) string {
+	rdns1 := strings.Split(dn1, ",")
+	rdns2 := strings.Split(dn2, ",")
+	var suffix []string
+	for i := 1; i <= len(rdns1) && i <= len(rdns2); i++ {
+		rdn := strings.TrimSpace(rdns1[len(rdns1)-i])
+		if !strings.EqualFold(rdn, strings.TrimSpace(rdns2[len(rdns2)-i])) {
+			break
+		}
+		suffix = append([]string{rdn}, suffix...)
+	}
+	return strings.Join(suffix, ",")
+}
+
+func yamlQuote(value string) (string, error) {
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+var configFileTemplate = template.Must(template.New("config").Funcs(template.FuncMap{
+	"quote": yamlQuote,
+}).Parse(`# smallpoint configuration written by smallpoint init on {{.Date}}.
+# Check it with: smallpoint -config FILE -check-config -probe
+
+base:
+  # The host:port the HTTPS server listens on.
+  http_address: {{quote .Config.Base.HttpAddress}}
+  # The host name of the server in the links of the mails.
+  hostname: {{quote .Config.Base.Hostname}}
+  tls_cert_filename: {{quote .Config.Base.TLSCertFilename}}
+  tls_key_filename: {{quote .Config.Base.TLSKeyFilename}}
+  # sqlite:FILE or a postgresql:// URL.
+  storage_url: {{quote .Config.Base.StorageURL}}
+  smtp_server: {{quote .Config.Base.SMTPserver}}
+  smtp_sender_address: {{quote .Config.Base.SmtpSenderAddress}}
+  # The CA of the client certificates of smallpointctl and of the API
+  # clients, none are accepted when empty.
+  # client_ca_filename: /etc/smallpoint/client-ca.pem
+  # The file of the secrets shared by the instances of a cluster.
+  # cluster_shared_secret_filename: /etc/smallpoint/shared-secrets.txt
+
+# The certificates from Let's Encrypt replace the files of the base section.
+# tls:
+#   min_version: "1.2"
+#   acme:
+#     hostnames: [smallpoint.example.com]
+#     email: admin@example.com
+#     cache_directory: /var/lib/smallpoint/acme
+#     http_address: ":80"
+
+openid:
+  client_id: {{quote .Config.OpenID.ClientID}}
+  client_secret: {{quote .Config.OpenID.ClientSecret}}
+  auth_url: {{quote .Config.OpenID.AuthURL}}
+  token_url: {{quote .Config.OpenID.TokenURL}}
+  userinfo_url: {{quote .Config.OpenID.UserinfoURL}}
+
+# The directory of the groups managed by smallpoint.
+target_config:
+  ldap_target_urls: {{quote .Config.TargetLDAP.LDAPTargetURLs}}
+  bind_username: {{quote .Config.TargetLDAP.BindUsername}}
+  bind_password: {{quote .Config.TargetLDAP.BindPassword}}
+  user_search_base_dns: {{quote .Config.TargetLDAP.UserSearchBaseDNs}}
+  group_search_base_dns: {{quote .Config.TargetLDAP.GroupSearchBaseDNs}}
+  service_search_base_dns: {{quote .Config.TargetLDAP.ServiceAccountBaseDNs}}
+  Main_base_dns: {{quote .Config.TargetLDAP.MainBaseDN}}
+  # owner stores the DN of the managing group, any other attribute its name.
+  group_Manage_Attribute: {{quote .Config.TargetLDAP.GroupManageAttribute}}
+  searchAttribute: {{quote .Config.TargetLDAP.SearchAttribute}}
+  # The super admins manage every group, comma separated user names.
+  super_admins: {{quote .Config.TargetLDAP.Admins}}
+
+# An optional directory of the users when it is not the target directory.
+# source_config:
+#   ldap_target_urls: ldaps://users.example.com
+#   bind_username: cn=smallpoint,dc=example,dc=com
+#   bind_password: PASSWORD
+#   user_search_base_dns: ou=people,dc=example,dc=com
+`))
+
+// writeConfigFile writes the commented configuration file of the config.
+func writeConfigFile(out io.Writer, config *AppConfigFile, now time.Time) error {
+	return configFileTemplate.Execute(out, struct {
+		Date   string
+		Config *AppConfigFile
+	}{now.Format("2006-01-02"), config})
+}
+
+// initConfigCommand implements the init command, it returns the process
+// exit code.
+func initConfigCommand(configFilename string, args []string, in io.Reader, out io.Writer) int {
+	flagSet := flag.NewFlagSet("init", flag.ContinueOnError)
+	force := flagSet.Bool("force", false, "Replace an existing configuration file")
+	noProbe := flagSet.Bool("no-probe", false, "Do not connect to the LDAP, OpenID and SMTP servers")
+	err := flagSet.Parse(args)
+	if err != nil || flagSet.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: init [-force] [-no-probe] [FILE]\n")
+		return 2
+	}
+	if flagSet.NArg() == 1 {
+		configFilename = flagSet.Arg(0)
+	}
+	if _, err := os.Stat(configFilename); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "%s exists, use -force to replace it\n", configFilename)
+		return 1
+	}
+	wizard := &configWizard{in: bufio.NewReader(in), out: out, probe: !*noProbe}
+	fmt.Fprintf(out, "Writing a new smallpoint configuration to %s, the defaults are in brackets.\n",
+		configFilename)
+	err = wizard.run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nInit FAILED: %s\n", err)
+		return 1
+	}
+	file, err := os.OpenFile(configFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Init FAILED: %s\n", err)
+		return 1
+	}
+	err = writeConfigFile(file, &wizard.config, time.Now())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Init FAILED: cannot write %s: %s\n", configFilename, err)
+		return 1
+	}
+	fmt.Fprintf(out, "\nWrote %s, run smallpoint -config %s bootstrap to set up the deployment.\n",
+		configFilename, configFilename)
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestInitConfigCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configinit_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFilename, keyFilename := testWriteCertificate(t, dir)
+	answers := []string{
+		// server
+		":8443", "smallpoint.example.com", certFilename, keyFilename,
+		"sqlite:" + filepath.Join(dir, "smallpoint.db"),
+		// LDAP, the URL is fixed on the second round
+		"ldap://ldap.example.com", "cn=smallpoint,dc=example,dc=com", "pass: word",
+		"ou=people,dc=example,dc=com", "ou=groups,dc=example,dc=com", "", "", "", "admin1,admin2",
+		"y", "ldaps://ldap.example.com", "", "", "", "", "", "", "", "",
+		// OpenID
+		"smallpoint", "secret", "https://idp.example.com/auth", "https://idp.example.com/token",
+		"https://idp.example.com/userinfo",
+		// SMTP
+		"mail.example.com:25", "smallpoint@example.com",
+	}
+	configFilename := filepath.Join(dir, "config.yml")
+	var out bytes.Buffer
+	code := initConfigCommand(configFilename, []string{"-no-probe"},
+		strings.NewReader(strings.Join(answers, "\n")+"\n"), &out)
+	if code != 0 {
+		t.Fatalf("init exited with %d: %s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "'ldap://ldap.example.com' is not an ldaps://host[:port] URL") {
+		t.Fatalf("the LDAP URL problem is not reported: %s", out.String())
+	}
+	info, err := os.Stat(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("the config file is readable by others: %s", info.Mode())
+	}
+	source, err := ioutil.ReadFile(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config AppConfigFile
+	err = yaml.UnmarshalStrict(source, &config)
+	if err != nil {
+		t.Fatalf("the config file does not parse: %s\n%s", err, source)
+	}
+	if config.TargetLDAP.LDAPTargetURLs != "ldaps://ldap.example.com" ||
+		config.TargetLDAP.BindPassword != "pass: word" ||
+		config.TargetLDAP.MainBaseDN != "dc=example,dc=com" ||
+		config.TargetLDAP.GroupManageAttribute != "owner" ||
+		config.Base.HttpAddress != ":8443" || config.OpenID.ClientSecret != "secret" ||
+		config.Base.SmtpSenderAddress != "smallpoint@example.com" {
+		t.Fatalf("unexpected config %s", source)
+	}
+	problems, err := checkConfigFile(configFilename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("the config has problems %+v", problems)
+	}
+
+	code = initConfigCommand(configFilename, nil, strings.NewReader(""), &out)
+	if code != 1 {
+		t.Fatalf("init replaced the existing config, exit code %d", code)
+	}
+}
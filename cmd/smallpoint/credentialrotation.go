@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+const (
+	credentialBackendLDAP = "ldap"
+	credentialBackendHTTP = "http"
+
+	defaultCredentialRotationTimeout = 30 * time.Second
+	generatedPasswordBytes           = 24
+)
+
+type credentialRotationConfig struct {
+	// Backend is ldap (the default) to set a new random password directly
+	// in LDAP, or http to ask our secrets system to rotate it.
+	Backend string `yaml:"backend"`
+	// The http backend POSTs {"account": ..., "requested_by": ...} to URL,
+	// the "reference" in the JSON response is kept in the history.
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// A credentialRotator replaces the credentials of a service account. The
+// secret is only returned when it has to be handed to the requester, the
+// reference is an opaque identifier recorded in the rotation history.
+type credentialRotator interface {
+	Name() string
+	Rotate(accountname string, requestedBy string) (secret string, reference string, err error)
+}
+
+type ldapPasswordRotator struct {
+	userInfo userinfo.UserInfoBackend
+}
+
+func (rotator *ldapPasswordRotator) Name() string {
+	return credentialBackendLDAP
+}
+
+func generatePassword() (string, error) {
+	buf := make([]byte, generatedPasswordBytes)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (rotator *ldapPasswordRotator) Rotate(accountname string, requestedBy string) (string, string, error) {
+	password, err := generatePassword()
+	if err != nil {
+		return "", "", err
+	}
+	err = rotator.userInfo.SetServiceAccountPassword(accountname, password)
+	if err != nil {
+		return "", "", err
+	}
+	return password, "", nil
+}
+
+type httpCredentialRotator struct {
+	url    string
+	client *http.Client
+}
+
+type httpCredentialRotationRequest struct {
+	Account     string `json:"account"`
+	RequestedBy string `json:"requested_by"`
+}
+
+type httpCredentialRotationResponse struct {
+	Reference string `json:"reference"`
+}
+
+func (rotator *httpCredentialRotator) Name() string {
+	return credentialBackendHTTP
+}
+
+// Rotate never returns a secret, the secrets system delivers it.
+func (rotator *httpCredentialRotator) Rotate(accountname string, requestedBy string) (string, string, error) {
+	body, err := json.Marshal(httpCredentialRotationRequest{Account: accountname, RequestedBy: requestedBy})
+	if err != nil {
+		return "", "", err
+	}
+	start := time.Now()
+	resp, err := rotator.client.Post(rotator.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	metrics.MetricLogExternalServiceDuration("credential_rotation", time.Since(start))
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", "", fmt.Errorf("credential rotation failed with status %d: %s", resp.StatusCode,
+			strings.TrimSpace(string(respBody)))
+	}
+	var rotationResponse httpCredentialRotationResponse
+	if len(respBody) > 0 {
+		err = json.Unmarshal(respBody, &rotationResponse)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return "", rotationResponse.Reference, nil
+}
+
+func newCredentialRotator(config credentialRotationConfig, userInfo userinfo.UserInfoBackend) (credentialRotator, error) {
+	switch config.Backend {
+	case "", credentialBackendLDAP:
+		return &ldapPasswordRotator{userInfo: userInfo}, nil
+	case credentialBackendHTTP:
+		if config.URL == "" {
+			return nil, errors.New("the http credential rotation backend requires an url")
+		}
+		timeout := config.Timeout
+		if timeout == 0 {
+			timeout = defaultCredentialRotationTimeout
+		}
+		return &httpCredentialRotator{url: config.URL, client: &http.Client{Timeout: timeout}}, nil
+	}
+	return nil, fmt.Errorf("invalid credential rotation backend '%s'", config.Backend)
+}
+
+type credentialRotation struct {
+	Timestamp time.Time
+	RotatedBy string
+	Backend   string
+	Outcome   string
+	Reference string
+}
+
+var insertCredentialRotationStmt = map[string]string{
+	"sqlite":   "insert into credential_rotations(accountname, time_stamp, rotated_by, backend, outcome, reference) values (?,?,?,?,?,?);",
+	"postgres": "insert into credential_rotations(accountname, time_stamp, rotated_by, backend, outcome, reference) values ($1,$2,$3,$4,$5,$6);",
+}
+
+var getCredentialRotationsStmt = map[string]string{
+	"sqlite":   "select time_stamp, rotated_by, backend, outcome, reference from credential_rotations where accountname=? order by id desc;",
+	"postgres": "select time_stamp, rotated_by, backend, outcome, reference from credential_rotations where accountname=$1 order by id desc;",
+}
+
+// getCredentialRotationsFromDB returns the newest rotations first.
+func getCredentialRotationsFromDB(accountname string, state *RuntimeState) ([]credentialRotation, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getCredentialRotationsStmt[state.dbType], accountname)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var rotations []credentialRotation
+	for rows.Next() {
+		var rotation credentialRotation
+		var timeStamp int64
+		err = rows.Scan(&timeStamp, &rotation.RotatedBy, &rotation.Backend, &rotation.Outcome, &rotation.Reference)
+		if err != nil {
+			return nil, err
+		}
+		rotation.Timestamp = time.Unix(timeStamp, 0)
+		rotations = append(rotations, rotation)
+	}
+	return rotations, rows.Err()
+}
+
+// rotateServiceAccountCredential rotates and records the outcome in the
+// history and the audit log.
+func (state *RuntimeState) rotateServiceAccountCredential(r *http.Request, username string,
+	accountname string) (string, error) {
+	rotator := state.credentialRotator
+	if rotator == nil {
+		rotator = &ldapPasswordRotator{userInfo: state.Userinfo}
+	}
+	secret, reference, rotateErr := rotator.Rotate(accountname, username)
+	outcome := auditOutcomeSuccess
+	details := "backend " + rotator.Name()
+	if rotateErr != nil {
+		outcome = auditOutcomeFailure
+		details += ": " + rotateErr.Error()
+	} else if reference != "" {
+		details += " reference " + reference
+	}
+	err := execServiceAccountUpdate(state, insertCredentialRotationStmt[state.dbType], accountname,
+		time.Now().Unix(), username, rotator.Name(), outcome, reference)
+	if err != nil {
+		requestLogger(r).Error("cannot record the credential rotation", "account", accountname, "err", err)
+	}
+	state.recordAuditEvent(r, username, auditActionRotateServiceAccountCredential, accountname, accountname,
+		outcome, details)
+	if rotateErr != nil {
+		return "", rotateErr
+	}
+	if state.sysLog != nil {
+		state.sysLog.Write([]byte(fmt.Sprintf("Credentials of service account %s were rotated by %s", accountname, username)))
+	}
+	return secret, nil
+}
+
+// getManageableServiceAccount writes the error response and returns false
+// unless username owns the account.
+func (state *RuntimeState) getManageableServiceAccount(w http.ResponseWriter, r *http.Request,
+	username string, accountname string) (serviceAccount, bool) {
+	account, err := getServiceAccountFromDB(accountname, state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			state.writeFailureResponse(w, r, "service account not found", http.StatusNotFound)
+			return account, false
+		}
+		requestLogger(r).Error("getManageableServiceAccount failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return account, false
+	}
+	allowed, err := state.canManageServiceAccount(username, account)
+	if err != nil {
+		requestLogger(r).Error("getManageableServiceAccount failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return account, false
+	}
+	if !allowed {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return account, false
+	}
+	return account, true
+}
+
+func (state *RuntimeState) renderCredentialRotations(w http.ResponseWriter, r *http.Request,
+	username string, accountname string, newSecret string) {
+	rotations, err := getCredentialRotationsFromDB(accountname, state)
+	if err != nil {
+		requestLogger(r).Error("renderCredentialRotations failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	pageData := credentialRotationsPageData{
+		UserName:    username,
+		IsAdmin:     state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:       "Credential rotations of " + accountname,
+		AccountName: accountname,
+		NewSecret:   newSecret,
+		Rotations:   rotations,
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	state.renderTemplateOrReturnJson(w, r, "credentialRotationsPage", pageData)
+}
+
+func (state *RuntimeState) credentialRotationsHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	accountname := strings.TrimSpace(r.URL.Query().Get("accountname"))
+	switch r.Method {
+	case getMethod:
+		_, ok := state.getManageableServiceAccount(w, r, username, accountname)
+		if !ok {
+			return
+		}
+		state.renderCredentialRotations(w, r, username, accountname, "")
+	case postMethod:
+		err = r.ParseForm()
+		if err != nil {
+			requestLogger(r).Error("credentialRotationsHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		accountname = strings.TrimSpace(r.PostFormValue("accountname"))
+		account, ok := state.getManageableServiceAccount(w, r, username, accountname)
+		if !ok {
+			return
+		}
+		if !account.IsActive() {
+			state.writeFailureResponse(w, r, "cannot rotate the credentials of a disabled service account", http.StatusBadRequest)
+			return
+		}
+		secret, err := state.rotateServiceAccountCredential(r, username, accountname)
+		if err != nil {
+			requestLogger(r).Error("credentialRotationsHandler failed", "err", err)
+			state.writeFailureResponse(w, r, "credential rotation failed", http.StatusInternalServerError)
+			return
+		}
+		state.renderCredentialRotations(w, r, username, accountname, secret)
+	default:
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func testCreateRotatableServiceAccount(t *testing.T, state *RuntimeState, accountname string) {
+	err := state.Userinfo.CreateServiceAccount(userinfo.GroupInfo{Groupname: accountname,
+		Mail: "team@example.com", LoginShell: "/bin/false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = insertServiceAccountInDB(serviceAccount{AccountName: accountname, OwnerGroup: "group1",
+		CreatedBy: "user1", CreatedAt: time.Now(), ReviewBy: time.Now().AddDate(1, 0, 0),
+		Status: serviceAccountStatusActive}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotateServiceAccountCredentialLDAP(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCreateRotatableServiceAccount(t, &state, "svc_rotate_ldap")
+	req, err := http.NewRequest("POST", credentialRotationsPath,
+		strings.NewReader(url.Values{"accountname": {"svc_rotate_ldap"}}.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.credentialRotationsHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("rotation failed with %d", rr.Code)
+	}
+	var pageData credentialRotationsPageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pageData.NewSecret) < generatedPasswordBytes {
+		t.Fatalf("bad generated password '%s'", pageData.NewSecret)
+	}
+	if len(pageData.Rotations) != 1 || pageData.Rotations[0].RotatedBy != "user2" ||
+		pageData.Rotations[0].Outcome != auditOutcomeSuccess || pageData.Rotations[0].Backend != credentialBackendLDAP {
+		t.Fatalf("bad rotation history %+v", pageData.Rotations)
+	}
+}
+
+func TestRotateServiceAccountCredentialHTTP(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var rotationRequest httpCredentialRotationRequest
+		json.Unmarshal(body, &rotationRequest)
+		if fail || rotationRequest.Account != "svc_rotate_http" {
+			http.Error(w, "vault sealed", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"reference": "secret/svc_rotate_http@v2"}`))
+	}))
+	defer server.Close()
+	state.credentialRotator, err = newCredentialRotator(credentialRotationConfig{Backend: credentialBackendHTTP,
+		URL: server.URL}, state.Userinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCreateRotatableServiceAccount(t, &state, "svc_rotate_http")
+	secret, err := state.rotateServiceAccountCredential(nil, "user1", "svc_rotate_http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" {
+		t.Fatal("the secrets system delivers the secret")
+	}
+	fail = true
+	_, err = state.rotateServiceAccountCredential(nil, "user1", "svc_rotate_http")
+	if err == nil {
+		t.Fatal("rotation should have failed")
+	}
+	rotations, err := getCredentialRotationsFromDB("svc_rotate_http", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotations) != 2 || rotations[0].Outcome != auditOutcomeFailure ||
+		rotations[1].Reference != "secret/svc_rotate_http@v2" {
+		t.Fatalf("bad rotation history %+v", rotations)
+	}
+
+	_, err = newCredentialRotator(credentialRotationConfig{Backend: "carrier-pigeon"}, state.Userinfo)
+	if err == nil {
+		t.Fatal("invalid backend should fail")
+	}
+}
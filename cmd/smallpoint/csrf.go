@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+)
+
+// The browsers send the auth cookie with the requests other sites make them
+// send, so the state-changing requests of a cookie session must also carry
+// the CSRF token of the session, in the X-CSRF-Token header or the
+// csrf_token form field. The token is a MAC of the auth cookie under the
+// cluster secrets, the pages get it in the csrf_token cookie and csrf.js adds
+// it to their forms and requests. The requests from another origin are
+// rejected whatever their session, the client certificates of smallpointctl
+// need no token. The SCIM and HR webhook endpoints authenticate with their
+// own tokens and signatures and are not checked.
+
+const (
+	csrfCookieName    = "csrf_token"
+	csrfHeader        = "X-CSRF-Token"
+	csrfFormField     = "csrf_token"
+	securityEventCSRF = "csrf_rejected"
+)
+
+var csrfSafeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+var (
+	errCrossOriginRequest = errors.New("cross origin request")
+	errInvalidCSRFToken   = errors.New("missing or invalid CSRF token")
+)
+
+// checkRequestOrigin rejects the requests a browser sends from another
+// origin, the other clients send no Origin.
+func checkRequestOrigin(r *http.Request) error {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return errCrossOriginRequest
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Host != r.Host {
+		return errCrossOriginRequest
+	}
+	return nil
+}
+
+// setCSRFCookie gives the token of the session to the scripts of the pages,
+// the cookie is replaced when the session changes.
+func (state *RuntimeState) setCSRFCookie(w http.ResponseWriter, r *http.Request, session *authn.Session) {
+	token := state.authenticator.CSRFToken(r)
+	if token == "" {
+		return
+	}
+	cookie, err := r.Cookie(csrfCookieName)
+	if err == nil && cookie.Value == token {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: csrfCookieName, Value: token, Path: "/", Expires: session.ExpiresAt,
+		Secure: !state.devMode, SameSite: http.SameSiteStrictMode})
+}
+
+// checkCSRFToken rejects the forged state-changing requests, it writes the
+// response of the rejected ones.
+func (state *RuntimeState) checkCSRFToken(w http.ResponseWriter, r *http.Request) error {
+	session := state.authenticator.GetSession(r)
+	cookieSession := session != nil && session.Method == authn.SessionMethodCookie
+	if cookieSession {
+		state.setCSRFCookie(w, r, session)
+	}
+	if csrfSafeMethods[r.Method] {
+		return nil
+	}
+	err := checkRequestOrigin(r)
+	if err == nil && cookieSession {
+		token := r.Header.Get(csrfHeader)
+		if token == "" {
+			token = r.PostFormValue(csrfFormField)
+		}
+		if !state.authenticator.CheckCSRFToken(r, token) {
+			err = errInvalidCSRFToken
+		}
+	}
+	if err != nil {
+		recordSecurityEvent(r, securityEventCSRF, "reason", err.Error())
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return err
+	}
+	return nil
+}
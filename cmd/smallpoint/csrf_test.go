@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+)
+
+// testAddAuthCookie adds the auth cookie and the CSRF token of its session
+// to the request, as the pages do.
+func testAddAuthCookie(req *http.Request, authenticator *authn.Authenticator, cookie http.Cookie) {
+	req.AddCookie(&cookie)
+	req.Header.Set(csrfHeader, authenticator.CSRFToken(req))
+}
+
+func TestCSRFProtection(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	post := func(form url.Values, header map[string]string) int {
+		req := httptest.NewRequest(postMethod, userPreferencesPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&cookie)
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(state.userPreferencesHandler).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// the pages get the token of the session in a cookie
+	req := httptest.NewRequest(getMethod, profilePath, nil)
+	req.AddCookie(&cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.profileHandler).ServeHTTP(rr, req)
+	var token string
+	for _, setCookie := range rr.Result().Cookies() {
+		if setCookie.Name == csrfCookieName {
+			token = setCookie.Value
+		}
+	}
+	if token == "" || token != state.authenticator.CSRFToken(req) {
+		t.Fatalf("the page got the CSRF token %q", token)
+	}
+
+	form := url.Values{"dark_mode": {"true"}}
+	if code := post(form, nil); code != http.StatusForbidden {
+		t.Fatalf("a post without a token returned %d", code)
+	}
+	if code := post(form, map[string]string{csrfHeader: token + "x"}); code != http.StatusForbidden {
+		t.Fatalf("a post with an invalid token returned %d", code)
+	}
+	if code := post(form, map[string]string{csrfHeader: token, "Origin": "https://evil.example.com"}); code !=
+		http.StatusForbidden {
+		t.Fatalf("a cross origin post returned %d", code)
+	}
+	if code := post(form, map[string]string{csrfHeader: token, "Sec-Fetch-Site": "cross-site"}); code !=
+		http.StatusForbidden {
+		t.Fatalf("a cross site post returned %d", code)
+	}
+	if code := post(form, map[string]string{csrfHeader: token}); code != http.StatusSeeOther {
+		t.Fatal("a post with the token in the header was rejected")
+	}
+	form.Set(csrfFormField, token)
+	if code := post(form, map[string]string{"Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"}); code !=
+		http.StatusSeeOther {
+		t.Fatal("a form with the token was rejected")
+	}
+}
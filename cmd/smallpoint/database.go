@@ -7,6 +7,7 @@ import (
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"log"
+	"log/slog"
 	"strings"
 	"time"
 )
@@ -22,7 +23,7 @@ func initDB(state *RuntimeState) (err error) {
 	}
 	splitString := strings.SplitN(storageURL, ":", 2)
 	if len(splitString) < 1 {
-		log.Print("invalid string")
+		slog.Error("invalid string")
 		err := errors.New("Bad storage url string")
 		return err
 	}
@@ -30,56 +31,49 @@ func initDB(state *RuntimeState) (err error) {
 	//initialSleep := time.Second * 3
 	switch splitString[0] {
 	case "sqlite":
-		log.Print("doing sqlite")
-		return initDBSQlite(state, splitString[1])
+		slog.Debug("doing sqlite")
+		err = initDBSQlite(state, splitString[1])
 	case "postgresql":
-		log.Print("doing postgres")
-		return initDBPostgres(state, storageURL)
+		slog.Debug("doing postgres")
+		err = initDBPostgres(state, storageURL)
 	default:
-		log.Print("invalid storage url string")
+		slog.Error("invalid storage url string")
 		err := errors.New("Bad storage url string")
 		return err
 	}
-
-	err = errors.New("invalid state")
-	return err
+	if err != nil {
+		return err
+	}
+	state.Config.Database.configure(state.db)
+	// otherwise the schema is checked once the commands are handled
+	if state.Config.Base.SkipStartupMigrations {
+		return nil
+	}
+	return migrateDB(state)
 }
 
 func initDBSQlite(state *RuntimeState, db string) (err error) {
 	state.dbType = "sqlite"
-	state.db, err = sql.Open("sqlite3", db)
+	dsn, err := sqliteDSN(db, state.Config.SQLite)
 	if err != nil {
 		return err
 	}
-	if true {
-		sqlStmt := `create table if not exists pending_requests (id INTEGER PRIMARY KEY AUTOINCREMENT, username text not null, groupname text not null, time_stamp int not null);`
-		_, err = state.db.Exec(sqlStmt)
-		if err != nil {
-			log.Printf("init sqlite3 err: %s: %q\n", err, sqlStmt)
-			return err
-		}
+	sqlDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	state.db = &instrumentedDB{sqlDB}
+	return setupSQLiteAutoVacuum(state)
 }
 
 func initDBPostgres(state *RuntimeState, db string) (err error) {
 	state.dbType = "postgres"
-	state.db, err = sql.Open("postgres", db)
+	sqlDB, err := sql.Open("postgres", db)
 	if err != nil {
 		return err
 	}
-	log.Printf("post open")
-	/// This should be changed to take care of DB schema
-	if true {
-		sqlStmt := `create table if not exists pending_requests (id SERIAL PRIMARY KEY, username text not null, groupname text not null, time_stamp int not null);`
-		_, err = state.db.Exec(sqlStmt)
-		if err != nil {
-			log.Printf("init postgres err: %s: %q\n", err, sqlStmt)
-			return err
-		}
-	}
-
+	state.db = &instrumentedDB{sqlDB}
+	slog.Debug("post open")
 	return nil
 }
 
@@ -89,82 +83,56 @@ var insertRequestStmt = map[string]string{
 	"postgres": "insert into pending_requests(username, groupname, time_stamp) values ($1,$2,$3);",
 }
 
-func insertRequestInDB(username string, groupnames []string, state *RuntimeState) error {
-
-	stmtText := insertRequestStmt[state.dbType]
-	stmt, err := state.db.Prepare(stmtText)
-	if err != nil {
-		log.Print("Error Preparing statement")
-		log.Fatal(err)
-	}
-	defer stmt.Close()
+// insertRequestInDB queues the requests and records them in access_requests,
+// the groups the user is a member of or already requested are skipped.
+func insertRequestInDB(username string, groupnames []string, justification string, state *RuntimeState) error {
+	var requestedGroups []string
 	for _, entry := range groupnames {
 		IsgroupMember, _, err := state.Userinfo.IsgroupmemberorNot(entry, username)
 		if err != nil {
-			log.Println(err)
+			slog.Error("insertRequestInDB failed", "err", err)
 			return err
 		}
 		if entryExistsorNot(username, entry, state) || IsgroupMember {
 			continue
-		} else {
-
-			_, err = stmt.Exec(username, entry, time.Now().Unix())
-			if err != nil {
-				return err
-			}
 		}
+		requestedGroups = append(requestedGroups, entry)
 	}
-	return nil
-}
-
-//delete the request after approved or declined
-var deleteEntryStmt = map[string]string{
-	"sqlite":   "delete from pending_requests where username= ? and groupname= ?;",
-	"postgres": "delete from pending_requests where username=$1 and groupname= $2;",
-}
-
-func deleteEntryInDB(username string, groupname string, state *RuntimeState) error {
-
-	stmtText := deleteEntryStmt[state.dbType]
-	stmt, err := state.db.Prepare(stmtText)
-	if err != nil {
-		log.Print("Error Preparing statement")
-		log.Fatal(err)
+	if len(requestedGroups) == 0 {
+		return nil
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec(username, groupname)
+	tx, err := state.db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	for _, entry := range requestedGroups {
+		_, err = tx.Exec(insertRequestStmt[state.dbType], username, entry, now)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(insertAccessRequestStmt[state.dbType], username, entry, username, justification, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
 
+//delete the request after approved or declined, see closeRequestInDB
+var deleteEntryStmt = map[string]string{
+	"sqlite":   "delete from pending_requests where username= ? and groupname= ?;",
+	"postgres": "delete from pending_requests where username=$1 and groupname= $2;",
 }
 
-//deleting all groups in DB which are deleted from Target LDAP
+//deleting all groups in DB which are deleted from Target LDAP, see
+//expireRequestsOfGroupsInDB
 var deleteEntryofGroupsStmt = map[string]string{
 	"sqlite":   "delete from pending_requests where groupname= ?;",
 	"postgres": "delete from pending_requests where groupname= $1;",
 }
 
-func deleteEntryofGroupsInDB(groupnames []string, state *RuntimeState) error {
-
-	stmtText := deleteEntryofGroupsStmt[state.dbType]
-	stmt, err := state.db.Prepare(stmtText)
-	if err != nil {
-		log.Print("Error Preparing statement")
-		return err
-	}
-	defer stmt.Close()
-	for _, entry := range groupnames {
-		_, err = stmt.Exec(entry)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-
-}
-
 //Search for a particular request made by a user (or) a group. (for my_pending_actions)
 var findrequestsofUserStmt = map[string]string{
 	"sqlite":   "select groupname from pending_requests where username=?;",
@@ -175,7 +143,7 @@ func findrequestsofUserinDB(username string, state *RuntimeState) ([]string, boo
 	stmtText := findrequestsofUserStmt[state.dbType]
 	stmt, err := state.db.Prepare(stmtText)
 	if err != nil {
-		log.Print("Error Preparing statement")
+		slog.Error("Error preparing statement")
 		log.Fatal(err)
 	}
 	defer stmt.Close()
@@ -183,10 +151,10 @@ func findrequestsofUserinDB(username string, state *RuntimeState) ([]string, boo
 	rows, err := stmt.Query(username)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
-			log.Printf("err='%s'", err)
+			slog.Error("findrequestsofUserinDB failed", "err", err)
 			return nil, false, nil
 		} else {
-			log.Printf("Problem with db ='%s'", err)
+			slog.Error("Problem with db", "err", err)
 			return nil, false, err
 		}
 	}
@@ -212,17 +180,17 @@ func entryExistsorNot(username string, groupname string, state *RuntimeState) bo
 	stmtText := entryExistsorNotStmt[state.dbType]
 	stmt, err := state.db.Prepare(stmtText)
 	if err != nil {
-		log.Print("Error Preparing statement")
+		slog.Error("Error preparing statement")
 		log.Fatal(err)
 	}
 	defer stmt.Close()
 	rows, err := stmt.Query(username, groupname)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
-			log.Printf("err='%s'", err)
+			slog.Error("entryExistsorNot failed", "err", err)
 			return false
 		} else {
-			log.Printf("Problem with db ='%s'", err)
+			slog.Error("Problem with db", "err", err)
 			return false
 		}
 	}
@@ -244,7 +212,7 @@ func getDBentries(state *RuntimeState) ([][]string, error) {
 	stmtText := getDBentriesStmt[state.dbType]
 	stmt, err := state.db.Prepare(stmtText)
 	if err != nil {
-		log.Print("Error Preparing statement")
+		slog.Error("Error preparing statement")
 		log.Fatal(err)
 	}
 	defer stmt.Close()
@@ -252,10 +220,10 @@ func getDBentries(state *RuntimeState) ([][]string, error) {
 	rows, err := stmt.Query()
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
-			log.Printf("err='%s'", err)
+			slog.Error("getDBentries failed", "err", err)
 			return nil, err
 		} else {
-			log.Printf("Problem with db ='%s'", err)
+			slog.Error("Problem with db", "err", err)
 			return nil, err
 		}
 	}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The sensitive values stored in the database are encrypted with envelope
+// encryption: each value is sealed with AES-256-GCM under its own data key,
+// and the data key is sealed under the current key encryption key of the
+// keys file. The associated data binds the value to its table, column and
+// row, a sealed value copied to another row does not open.
+//
+// The keys file holds one base64 encoded 32 byte key per line, e.g. from
+// "openssl rand -base64 32"; the first key seals and every key opens. To
+// rotate, add the new key as the first line and run the reencrypt-secrets
+// command, the old keys can be removed once it succeeds. The values stored
+// before the keys file was configured are read as they are and sealed by the
+// command.
+
+const encryptedValuePrefix = "enc:v1:"
+
+type dbEncryptionConfig struct {
+	KeysFilename string `yaml:"keys_filename"`
+}
+
+// encryptedColumn is a column of sealed values, KeyColumn identifies the row.
+type encryptedColumn struct {
+	Table       string
+	KeyColumn   string
+	ValueColumn string
+}
+
+// encryptedColumns lists the columns sealed with the value encrypter, the
+// reencrypt-secrets command walks them.
+var encryptedColumns = []encryptedColumn{provisionedSecretsColumn}
+
+func (column encryptedColumn) context(rowKey string) string {
+	return column.Table + "." + column.ValueColumn + ":" + rowKey
+}
+
+type encryptionKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+type valueEncrypter struct {
+	current encryptionKey
+	keys    map[string]encryptionKey
+}
+
+func newEncryptionKey(key []byte) (encryptionKey, error) {
+	if len(key) != 32 {
+		return encryptionKey{}, fmt.Errorf("the key has %d bytes instead of 32", len(key))
+	}
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	sum := sha256.Sum256(key)
+	return encryptionKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newValueEncrypter(keys [][]byte) (*valueEncrypter, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+	encrypter := &valueEncrypter{keys: make(map[string]encryptionKey)}
+	for i, key := range keys {
+		encryptionKey, err := newEncryptionKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %s", i+1, err)
+		}
+		if i == 0 {
+			encrypter.current = encryptionKey
+		}
+		encrypter.keys[encryptionKey.id] = encryptionKey
+	}
+	return encrypter, nil
+}
+
+// loadValueEncrypter returns nil when no keys file is configured, the values
+// are then stored as they are.
+func loadValueEncrypter(config dbEncryptionConfig) (*valueEncrypter, error) {
+	if config.KeysFilename == "" {
+		return nil, nil
+	}
+	file, err := os.Open(config.KeysFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var keys [][]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d is not base64", len(keys)+1)
+		}
+		keys = append(keys, key)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return newValueEncrypter(keys)
+}
+
+func sealAESGCM(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openAESGCM(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("the sealed value is truncated")
+	}
+	nonce := sealed[:aead.NonceSize()]
+	return aead.Open(nil, nonce, sealed[aead.NonceSize():], additionalData)
+}
+
+// Encrypt seals plaintext for context, which names the table, the column and
+// the row of the value.
+func (e *valueEncrypter) Encrypt(plaintext string, context string) (string, error) {
+	if e == nil {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, 32)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAESGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := sealAESGCM(dataAEAD, []byte(plaintext), []byte(context))
+	if err != nil {
+		return "", err
+	}
+	sealedKey, err := sealAESGCM(e.current.aead, dataKey, []byte(e.current.id))
+	if err != nil {
+		return "", err
+	}
+	return encryptedValuePrefix + e.current.id + ":" + base64.RawStdEncoding.EncodeToString(sealedKey) +
+		":" + base64.RawStdEncoding.EncodeToString(sealedValue), nil
+}
+
+// Decrypt opens a value sealed by Encrypt, the values stored in plaintext
+// are returned as they are.
+func (e *valueEncrypter) Decrypt(value string, context string) (string, error) {
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return value, nil
+	}
+	if e == nil {
+		return "", errors.New("the value is encrypted and no keys are configured")
+	}
+	fields := strings.Split(strings.TrimPrefix(value, encryptedValuePrefix), ":")
+	if len(fields) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	key, ok := e.keys[fields[0]]
+	if !ok {
+		return "", fmt.Errorf("unknown encryption key %s", fields[0])
+	}
+	sealedKey, err := base64.RawStdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := openAESGCM(key.aead, sealedKey, []byte(key.id))
+	if err != nil {
+		return "", errors.New("cannot open the data key")
+	}
+	dataAEAD, err := newAESGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openAESGCM(dataAEAD, sealedValue, []byte(context))
+	if err != nil {
+		return "", errors.New("cannot open the value")
+	}
+	return string(plaintext), nil
+}
+
+// needsReencryption returns whether value is not sealed under the current
+// key.
+func (e *valueEncrypter) needsReencryption(value string) bool {
+	return !strings.HasPrefix(value, encryptedValuePrefix+e.current.id+":")
+}
+
+// reencryptColumn seals the values of column under the current key and
+// returns how many were changed.
+func (state *RuntimeState) reencryptColumn(column encryptedColumn) (int, error) {
+	rows, err := state.db.Query(fmt.Sprintf("select %s, %s from %s;", column.KeyColumn, column.ValueColumn,
+		column.Table))
+	if err != nil {
+		return 0, err
+	}
+	values := make(map[string]string)
+	for rows.Next() {
+		var rowKey, value string
+		err = rows.Scan(&rowKey, &value)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if state.valueEncrypter.needsReencryption(value) {
+			values[rowKey] = value
+		}
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+	placeholder := func(n int) string {
+		if state.dbType == "postgres" {
+			return fmt.Sprintf("$%d", n)
+		}
+		return "?"
+	}
+	update := fmt.Sprintf("update %s set %s=%s where %s=%s and %s=%s;", column.Table, column.ValueColumn,
+		placeholder(1), column.KeyColumn, placeholder(2), column.ValueColumn, placeholder(3))
+	changed := 0
+	for rowKey, value := range values {
+		plaintext, err := state.valueEncrypter.Decrypt(value, column.context(rowKey))
+		if err != nil {
+			return changed, fmt.Errorf("%s: %s", column.context(rowKey), err)
+		}
+		sealed, err := state.valueEncrypter.Encrypt(plaintext, column.context(rowKey))
+		if err != nil {
+			return changed, err
+		}
+		// the values changed meanwhile are left to the next run
+		_, err = state.db.Exec(update, sealed, rowKey, value)
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+func reencryptSecretsCommand(state *RuntimeState) int {
+	if state.valueEncrypter == nil {
+		fmt.Fprintf(os.Stderr, "No db_encryption keys_filename is configured\n")
+		return 1
+	}
+	for _, column := range encryptedColumns {
+		changed, err := state.reencryptColumn(column)
+		fmt.Printf("%s.%s: reencrypted=%d\n", column.Table, column.ValueColumn, changed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Reencryption FAILED: %s\n", err)
+			return 1
+		}
+	}
+	fmt.Println("Reencryption OK")
+	return 0
+}
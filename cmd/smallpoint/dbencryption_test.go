@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValueEncrypter(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	encrypter, err := newValueEncrypter([][]byte{oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	column := encryptedColumn{Table: "test_secrets", KeyColumn: "name", ValueColumn: "value"}
+	sealed, err := encrypter.Encrypt("secret", column.context("row1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, encryptedValuePrefix) || strings.Contains(sealed, "secret") {
+		t.Fatalf("the value was not sealed %q", sealed)
+	}
+	plaintext, err := encrypter.Decrypt(sealed, column.context("row1"))
+	if err != nil || plaintext != "secret" {
+		t.Fatalf("cannot open the value %q err: %v", plaintext, err)
+	}
+	if _, err := encrypter.Decrypt(sealed, column.context("row2")); err == nil {
+		t.Fatal("the value opened in another row")
+	}
+	plaintext, err = encrypter.Decrypt("plain", column.context("row1"))
+	if err != nil || plaintext != "plain" {
+		t.Fatalf("the plaintext value was changed %q err: %v", plaintext, err)
+	}
+	var noEncrypter *valueEncrypter
+	if _, err := noEncrypter.Decrypt(sealed, column.context("row1")); err == nil {
+		t.Fatal("the value opened without keys")
+	}
+	if _, err := newValueEncrypter([][]byte{[]byte("short")}); err == nil {
+		t.Fatal("the short key was accepted")
+	}
+
+	dir, err := ioutil.TempDir("", "dbencryption_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keysFilename := filepath.Join(dir, "keys")
+	err = ioutil.WriteFile(keysFilename, []byte(base64.StdEncoding.EncodeToString(newKey)+"\n\n"+
+		base64.StdEncoding.EncodeToString(oldKey)+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := loadValueEncrypter(dbEncryptionConfig{KeysFilename: keysFilename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.needsReencryption(sealed) || rotated.needsReencryption(mustEncrypt(t, rotated, "x")) {
+		t.Fatal("bad reencryption check")
+	}
+
+	var state RuntimeState
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "requests.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.db.Close()
+	state.valueEncrypter = rotated
+	_, err = state.db.Exec("create table test_secrets (name text PRIMARY KEY, value text not null);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("insert into test_secrets(name, value) values ('row1', ?), ('row2', 'plain');", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(columns []encryptedColumn) { encryptedColumns = columns }(encryptedColumns)
+	encryptedColumns = []encryptedColumn{column}
+	if code := reencryptSecretsCommand(&state); code != 0 {
+		t.Fatalf("the reencryption failed with %d", code)
+	}
+	newOnly, err := newValueEncrypter([][]byte{newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"row1": "secret", "row2": "plain"} {
+		var value string
+		err = state.db.QueryRow("select value from test_secrets where name=?;", name).Scan(&value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := newOnly.Decrypt(value, column.context(name))
+		if err != nil || plaintext != expected || !strings.HasPrefix(value, encryptedValuePrefix) {
+			t.Fatalf("%s was not reencrypted %q err: %v", name, value, err)
+		}
+	}
+	changed, err := state.reencryptColumn(column)
+	if err != nil || changed != 0 {
+		t.Fatalf("the current values were reencrypted again %d err: %v", changed, err)
+	}
+}
+
+func mustEncrypt(t *testing.T, encrypter *valueEncrypter, plaintext string) string {
+	sealed, err := encrypter.Encrypt(plaintext, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The queries made through instrumentedDB, its transactions and its prepared
+// statements record their latency, labelled by dbQueryName.
+
+type instrumentedDB struct {
+	*sql.DB
+}
+
+type instrumentedTx struct {
+	*sql.Tx
+}
+
+type instrumentedStmt struct {
+	*sql.Stmt
+	query string
+}
+
+// dbQueryName returns the statement and the table of the query, e.g.
+// "select pending_requests".
+func dbQueryName(query string) string {
+	fields := strings.Fields(strings.ToLower(query))
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	statement := fields[0]
+	tableKeyword := ""
+	switch statement {
+	case "select", "delete":
+		tableKeyword = "from"
+	case "insert":
+		tableKeyword = "into"
+	case "update":
+		tableKeyword = "update"
+	default:
+		return statement
+	}
+	for i, field := range fields[:len(fields)-1] {
+		if field == tableKeyword {
+			table := strings.FieldsFunc(fields[i+1], func(r rune) bool {
+				return r == '(' || r == ';' || r == ','
+			})
+			if len(table) > 0 {
+				return statement + " " + table[0]
+			}
+		}
+	}
+	return statement
+}
+
+func observeDBQuery(query string, start time.Time, err error) {
+	metrics.MetricLogDBQueryDuration(dbQueryName(query), time.Since(start), err)
+}
+
+func (db *instrumentedDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := db.DB.Exec(query, args...)
+	observeDBQuery(query, start, err)
+	return result, err
+}
+
+func (db *instrumentedDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.DB.Query(query, args...)
+	observeDBQuery(query, start, err)
+	return rows, err
+}
+
+func (db *instrumentedDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := db.DB.QueryRow(query, args...)
+	observeDBQuery(query, start, row.Err())
+	return row
+}
+
+func (db *instrumentedDB) Prepare(query string) (*instrumentedStmt, error) {
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{Stmt: stmt, query: query}, nil
+}
+
+func (db *instrumentedDB) Begin() (*instrumentedTx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedTx{tx}, nil
+}
+
+func (tx *instrumentedTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := tx.Tx.Exec(query, args...)
+	observeDBQuery(query, start, err)
+	return result, err
+}
+
+func (tx *instrumentedTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := tx.Tx.Query(query, args...)
+	observeDBQuery(query, start, err)
+	return rows, err
+}
+
+func (tx *instrumentedTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := tx.Tx.QueryRow(query, args...)
+	observeDBQuery(query, start, row.Err())
+	return row
+}
+
+func (tx *instrumentedTx) Prepare(query string) (*instrumentedStmt, error) {
+	stmt, err := tx.Tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{Stmt: stmt, query: query}, nil
+}
+
+func (tx *instrumentedTx) Commit() error {
+	start := time.Now()
+	err := tx.Tx.Commit()
+	observeDBQuery("commit", start, err)
+	return err
+}
+
+func (stmt *instrumentedStmt) Exec(args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := stmt.Stmt.Exec(args...)
+	observeDBQuery(stmt.query, start, err)
+	return result, err
+}
+
+func (stmt *instrumentedStmt) Query(args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := stmt.Stmt.Query(args...)
+	observeDBQuery(stmt.query, start, err)
+	return rows, err
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDBQueryName(t *testing.T) {
+	queries := map[string]string{
+		"select count(*) from audit_log where groupname like 'old%';":                   "select audit_log",
+		"\n\tSELECT username FROM pending_requests WHERE groupname=?":                   "select pending_requests",
+		"insert into pending_requests(username, groupname, time_stamp) values (?,?,?);": "insert pending_requests",
+		"update group_archives set delete_after=0 where groupname='archive-group';":     "update group_archives",
+		"delete from audit_log where groupname='group3';":                               "delete audit_log",
+		"create table if not exists group_tags (groupname text not null);":              "create",
+		"commit": "commit",
+		"":       "unknown",
+	}
+	for query, expected := range queries {
+		if name := dbQueryName(query); name != expected {
+			t.Errorf("unexpected name %q of %q", name, query)
+		}
+	}
+}
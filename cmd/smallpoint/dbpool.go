@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+const (
+	defaultDBHealthCheckInterval = 30 * time.Second
+	defaultDBPingTimeout         = 5 * time.Second
+)
+
+// dbPoolConfig sizes the connection pool of the app database, the zero
+// values keep the defaults of database/sql.
+type dbPoolConfig struct {
+	// MaxOpenConnections bounds the connections, unlimited by default.
+	MaxOpenConnections int `yaml:"max_open_connections"`
+	// MaxIdleConnections kept open, 2 by default, a negative value keeps
+	// none.
+	MaxIdleConnections int `yaml:"max_idle_connections"`
+	// ConnectionMaxLifetime after which a connection is closed.
+	ConnectionMaxLifetime time.Duration `yaml:"connection_max_lifetime"`
+	// ConnectionMaxIdleTime after which an idle connection is closed.
+	ConnectionMaxIdleTime time.Duration `yaml:"connection_max_idle_time"`
+	// HealthCheckInterval between the pings of the database, 30s by
+	// default.
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	// PingTimeout of the health checks, 5s by default.
+	PingTimeout time.Duration `yaml:"ping_timeout"`
+}
+
+func (config dbPoolConfig) healthCheckInterval() time.Duration {
+	if config.HealthCheckInterval > 0 {
+		return config.HealthCheckInterval
+	}
+	return defaultDBHealthCheckInterval
+}
+
+func (config dbPoolConfig) pingTimeout() time.Duration {
+	if config.PingTimeout > 0 {
+		return config.PingTimeout
+	}
+	return defaultDBPingTimeout
+}
+
+func (config dbPoolConfig) configure(db *instrumentedDB) {
+	if config.MaxOpenConnections != 0 {
+		db.SetMaxOpenConns(config.MaxOpenConnections)
+	}
+	if config.MaxIdleConnections != 0 {
+		db.SetMaxIdleConns(config.MaxIdleConnections)
+	}
+	if config.ConnectionMaxLifetime != 0 {
+		db.SetConnMaxLifetime(config.ConnectionMaxLifetime)
+	}
+	if config.ConnectionMaxIdleTime != 0 {
+		db.SetConnMaxIdleTime(config.ConnectionMaxIdleTime)
+	}
+	metrics.SetDBStatsFunc(db.Stats)
+}
+
+// pingDB checks the database and records the outcome in the
+// smallpoint_db_up metric.
+func (state *RuntimeState) pingDB(ctx context.Context) error {
+	start := time.Now()
+	err := state.db.PingContext(ctx)
+	metrics.MetricLogDBPing(time.Since(start), err)
+	return err
+}
+
+// startDBHealthChecks pings the database periodically, the changes of its
+// health are logged.
+func (state *RuntimeState) startDBHealthChecks() {
+	config := state.Config.Database
+	go func() {
+		healthy := true
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), config.pingTimeout())
+			err := state.pingDB(ctx)
+			cancel()
+			if err != nil && healthy {
+				slog.Error("the database is unreachable", "err", err)
+			} else if err == nil && !healthy {
+				slog.Info("the database is reachable again")
+			}
+			healthy = err == nil
+			time.Sleep(config.healthCheckInterval())
+		}
+	}()
+}
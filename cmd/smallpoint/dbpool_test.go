@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDBPoolConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbpool_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "requests.db")
+	state.Config.Database = dbPoolConfig{MaxOpenConnections: 3, MaxIdleConnections: 1,
+		ConnectionMaxLifetime: time.Minute}
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := state.db.Stats(); stats.MaxOpenConnections != 3 || stats.OpenConnections > 1 {
+		t.Fatalf("the pool was not configured %+v", stats)
+	}
+	err = state.pingDB(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.db.Close()
+	if state.pingDB(context.Background()) == nil {
+		t.Fatal("the closed database answered the ping")
+	}
+	if state.Config.Database.pingTimeout() != defaultDBPingTimeout ||
+		state.Config.Database.healthCheckInterval() != defaultDBHealthCheckInterval {
+		t.Fatal("bad defaults")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"path"
+	"strings"
+)
+
+// The net/http/pprof and expvar packages register their handlers on
+// http.DefaultServeMux, which serves smallpoint. debugHandler answers every
+// path under debugPathPrefix before they reach it: the profiles and the
+// variables are served to the admins when the debug endpoints are enabled.
+// The CPU profiles and the traces must be shorter than the write timeout of
+// the server, e.g. /debug/pprof/profile?seconds=5.
+
+const debugPathPrefix = "/debug/"
+
+func (state *RuntimeState) debugHandler(handler http.Handler) http.Handler {
+	debugMux := http.NewServeMux()
+	debugMux.HandleFunc("/debug/pprof/", pprof.Index)
+	debugMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	debugMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	debugMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	debugMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	debugMux.Handle("/debug/vars", expvar.Handler())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(path.Clean(r.URL.Path)+"/", debugPathPrefix) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if !state.Config.Base.DebugEndpoints {
+			http.NotFound(w, r)
+			return
+		}
+		username, err := state.GetRemoteUserName(w, r)
+		if err != nil {
+			return
+		}
+		if !state.requestUserinfo(r).UserisadminOrNot(username) {
+			http.Error(w, "you are not authorized", http.StatusForbidden)
+			return
+		}
+		requestLogger(r).Info("debug endpoint", "path", r.URL.Path)
+		debugMux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := state.debugHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != indexPath {
+			t.Errorf("%s reached the server mux", r.URL.Path)
+		}
+	}))
+	serve := func(path string, cookie http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(getMethod, path, nil)
+		testAddAuthCookie(req, state.authenticator, cookie)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	adminCookie := testCreateValidAdminCookie(state.authenticator)
+	if rr := serve(indexPath, adminCookie); rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d of %s", rr.Code, indexPath)
+	}
+	for _, path := range []string{"/debug/pprof/", "/debug/vars", "/debug/../debug/pprof/heap"} {
+		if rr := serve(path, adminCookie); rr.Code != http.StatusNotFound {
+			t.Fatalf("%s is served while the debug endpoints are disabled, %d", path, rr.Code)
+		}
+	}
+
+	state.Config.Base.DebugEndpoints = true
+	if rr := serve("/debug/vars", testCreateValidCookie(state.authenticator)); rr.Code != http.StatusForbidden {
+		t.Fatalf("the variables are served to %s, %d", testUsername, rr.Code)
+	}
+	rr := serve("/debug/vars", adminCookie)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d of the variables", rr.Code)
+	}
+	var vars map[string]interface{}
+	err = json.Unmarshal(rr.Body.Bytes(), &vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vars["memstats"]; !ok {
+		t.Fatalf("the memory statistics are missing from %v", vars)
+	}
+	if rr = serve("/debug/pprof/heap?debug=1", adminCookie); rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d of the heap profile", rr.Code)
+	}
+}
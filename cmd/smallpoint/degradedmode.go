@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// While the circuit breaker of a directory is open the LDAP operations fail at
+// once. The listings are served from the caches, the requests that still fail
+// get a degraded mode page rather than the error of the handler, except the
+// readiness checks.
+
+const degradedModeMessage = "The directory is not reachable, smallpoint runs in degraded mode. " +
+	"The cached listings are shown and the changes are unavailable, please retry in a few minutes."
+
+// directoryUnavailable returns how long the directories are considered down,
+// 0 when they are up.
+func (state *RuntimeState) directoryUnavailable() time.Duration {
+	wait := state.Config.TargetLDAP.Unavailable()
+	if sourceWait := state.Config.SourceLDAP.Unavailable(); sourceWait > wait {
+		wait = sourceWait
+	}
+	return wait
+}
+
+func (state *RuntimeState) writeDegradedModeResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.Header().Set("Cache-Control", "no-store")
+	if state.getPreferredAcceptType(r) == "text/html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	state.writeFailureResponse(w, r, degradedModeMessage, http.StatusServiceUnavailable)
+}
+
+// degradedModeWriter replaces the server errors written while a directory is
+// unavailable with the degraded mode page.
+type degradedModeWriter struct {
+	http.ResponseWriter
+	state       *RuntimeState
+	request     *http.Request
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *degradedModeWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code >= http.StatusInternalServerError {
+		if wait := w.state.directoryUnavailable(); wait > 0 {
+			w.replaced = true
+			w.state.writeDegradedModeResponse(w.ResponseWriter, w.request, wait)
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *degradedModeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (state *RuntimeState) degradedModeHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == readyzPath {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(&degradedModeWriter{ResponseWriter: w, state: state, request: r}, r)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/mock"
+)
+
+type unavailableUserInfo struct {
+	userinfo.UserInfoBackend
+	unavailable bool
+}
+
+func (u *unavailableUserInfo) GetallGroups() ([]string, error) {
+	if u.unavailable {
+		return nil, userinfo.DirectoryUnavailable
+	}
+	return u.UserInfoBackend.GetallGroups()
+}
+
+func TestDegradedModeHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := state.degradedModeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fails" {
+			http.Error(w, "cannot connect to LDAP server", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("listing"))
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(getMethod, path, nil))
+		return rr
+	}
+	if rr := serve("/fails"); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("the error was replaced while the directory is up, %d", rr.Code)
+	}
+
+	// the test directory has no servers, a single failure opens the circuit
+	state.Config.TargetLDAP.CircuitBreakerFailures = 1
+	state.Config.TargetLDAP.CircuitBreakerCooldown = time.Minute
+	_, err = state.Config.TargetLDAP.GetallGroups()
+	if err == nil {
+		t.Fatal("the directory without servers answered")
+	}
+	rr := serve("/fails")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "60" {
+		t.Fatalf("unexpected degraded response %d %v", rr.Code, rr.Header())
+	}
+	var pageData simpleMessagePageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(pageData.ErrorMessage, degradedModeMessage) {
+		t.Fatalf("unexpected message %q", pageData.ErrorMessage)
+	}
+	if rr = serve("/listing"); rr.Code != http.StatusOK || rr.Body.String() != "listing" {
+		t.Fatalf("the cached page was not served, %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCachedUserInfoWhileUnavailable(t *testing.T) {
+	source := &unavailableUserInfo{UserInfoBackend: mock.New()}
+	cached := newCachedUserInfo(source, time.Nanosecond)
+	groups, err := cached.GetallGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.unavailable = true
+	time.Sleep(time.Millisecond)
+	expired, err := cached.GetallGroups()
+	if err != nil || len(expired) != len(groups) {
+		t.Fatalf("the expired listing was not served: %v %v", expired, err)
+	}
+	cached.invalidate()
+	_, err = cached.GetallGroups()
+	if err != userinfo.DirectoryUnavailable {
+		t.Fatalf("the listing without a cached value returned %v", err)
+	}
+}
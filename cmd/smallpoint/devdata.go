@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/mock"
+)
+
+// The generate command fills the dev mode directory and database with
+// synthetic users, groups, subgroups and pending requests, so that the UI
+// and the queries can be evaluated at scale, then the server starts as
+// usual. The directory of dev mode lives in memory, so the data is
+// generated on every start. The same seed generates the same data.
+//
+// One group in ten manages itself, the others are managed by one of these
+// manager groups. The subgroups are members of a group created before them,
+// so that the nesting has no cycle.
+
+const devDataFirstGidNumber = 200000
+
+var devDataGivenNames = []string{"Ada", "Alan", "Barbara", "Claude", "Dennis", "Edsger", "Frances",
+	"Grace", "Hedy", "John", "Ken", "Leslie", "Margaret", "Niklaus", "Radia", "Tim"}
+
+type devDataConfig struct {
+	Users     int
+	Groups    int
+	Members   int
+	Subgroups int
+	Requests  int
+	Prefix    string
+	Seed      int64
+}
+
+type devDataReport struct {
+	Users     int
+	Groups    int
+	Subgroups int
+	Requests  int
+}
+
+func parseDevDataArgs(args []string) (devDataConfig, error) {
+	var config devDataConfig
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.IntVar(&config.Users, "users", 1000, "The number of users")
+	flags.IntVar(&config.Groups, "groups", 100, "The number of groups")
+	flags.IntVar(&config.Members, "members", 20, "The number of members of every group")
+	flags.IntVar(&config.Subgroups, "subgroups", 50, "The number of groups nested in another group")
+	flags.IntVar(&config.Requests, "requests", 200, "The number of pending requests")
+	flags.StringVar(&config.Prefix, "prefix", "synth-", "The prefix of the generated users and groups")
+	flags.Int64Var(&config.Seed, "seed", 1, "The seed of the generator")
+	err := flags.Parse(args)
+	if err != nil {
+		return config, err
+	}
+	if config.Users < 1 || config.Groups < 1 || config.Members < 0 || config.Subgroups < 0 ||
+		config.Requests < 0 {
+		return config, errors.New("generate requires at least 1 user and 1 group")
+	}
+	if config.Prefix == "" {
+		return config, errors.New("generate requires a prefix")
+	}
+	if config.Members > config.Users {
+		return config, fmt.Errorf("groups of %d members need as many users", config.Members)
+	}
+	// the first group has no group created before it
+	if config.Subgroups > config.Groups-1 {
+		return config, fmt.Errorf("%d groups allow %d subgroups at most", config.Groups, config.Groups-1)
+	}
+	if maxRequests := config.Groups * (config.Users - config.Members); config.Requests > maxRequests {
+		return config, fmt.Errorf("%d users and %d groups of %d members allow %d requests at most",
+			config.Users, config.Groups, config.Members, maxRequests)
+	}
+	return config, nil
+}
+
+func (config devDataConfig) username(i int) string {
+	return fmt.Sprintf("%suser%06d", config.Prefix, i)
+}
+
+func (config devDataConfig) groupname(i int) string {
+	return fmt.Sprintf("%sgroup%05d", config.Prefix, i)
+}
+
+// managedBy returns the manager group of the i-th group.
+func (config devDataConfig) managedBy(random *rand.Rand, i int) string {
+	if i%10 == 0 {
+		return descriptionAttribute
+	}
+	return config.groupname(random.Intn(i/10+1) * 10)
+}
+
+// devModeGroupDN returns the DN of a group of the dev mode directory.
+func devModeGroupDN(groupname string) string {
+	return "cn=" + groupname + "," + mock.LdapGroupDN
+}
+
+// generateDevData adds the users, the groups and their members and
+// subgroups to the directory and the pending requests to the database.
+func (state *RuntimeState) generateDevData(config devDataConfig, progress io.Writer) (devDataReport,
+	error) {
+	var report devDataReport
+	random := rand.New(rand.NewSource(config.Seed))
+	directory := state.Userinfo
+	start := time.Now()
+	for i := 0; i < config.Users; i++ {
+		username := config.username(i)
+		givenName := devDataGivenNames[random.Intn(len(devDataGivenNames))]
+		err := directory.CreateUser(username, []string{givenName}, []string{username + "@example.com"})
+		if err != nil {
+			return report, fmt.Errorf("cannot create %s: %s", username, err)
+		}
+		report.Users++
+	}
+	fmt.Fprintf(progress, "users=%d elapsed=%s\n", report.Users, time.Since(start))
+
+	start = time.Now()
+	members := make([]map[int]bool, config.Groups)
+	for i := 0; i < config.Groups; i++ {
+		members[i] = make(map[int]bool)
+		var memberUids []string
+		for len(memberUids) < config.Members {
+			user := random.Intn(config.Users)
+			if members[i][user] {
+				continue
+			}
+			members[i][user] = true
+			memberUids = append(memberUids, config.username(user))
+		}
+		groupname := config.groupname(i)
+		err := directory.CreateGroup(userinfo.GroupInfo{
+			Groupname:   groupname,
+			Description: config.managedBy(random, i),
+			MemberUid:   memberUids,
+			// the directory would look for the highest one on every group
+			GidNumber: fmt.Sprint(devDataFirstGidNumber + i),
+		})
+		if err != nil {
+			return report, fmt.Errorf("cannot create %s: %s", groupname, err)
+		}
+		report.Groups++
+	}
+	for _, i := range random.Perm(config.Groups - 1)[:config.Subgroups] {
+		subgroup := i + 1
+		parent := config.groupname(random.Intn(subgroup))
+		err := directory.AddmemberstoExisting(userinfo.GroupInfo{Groupname: parent,
+			Member: []string{devModeGroupDN(config.groupname(subgroup))}})
+		if err != nil {
+			return report, fmt.Errorf("cannot nest %s in %s: %s", config.groupname(subgroup), parent, err)
+		}
+		report.Subgroups++
+	}
+	fmt.Fprintf(progress, "groups=%d subgroups=%d elapsed=%s\n", report.Groups, report.Subgroups,
+		time.Since(start))
+
+	// the pairs are distinct and the user is never a member of the group,
+	// one transaction keeps the insertion of many requests short
+	start = time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	requested := make(map[[2]int]bool)
+	for report.Requests < config.Requests {
+		pair := [2]int{random.Intn(config.Users), random.Intn(config.Groups)}
+		if requested[pair] || members[pair[1]][pair[0]] {
+			continue
+		}
+		requested[pair] = true
+		username, groupname := config.username(pair[0]), config.groupname(pair[1])
+		_, err = tx.Exec(insertRequestStmt[state.dbType], username, groupname, now)
+		if err != nil {
+			return report, err
+		}
+		_, err = tx.Exec(insertAccessRequestStmt[state.dbType], username, groupname, username,
+			"generated", now)
+		if err != nil {
+			return report, err
+		}
+		report.Requests++
+	}
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+	fmt.Fprintf(progress, "requests=%d elapsed=%s\n", report.Requests, time.Since(start))
+	return report, nil
+}
+
+// generateDevDataCommand returns the exit code of a failed generate command
+// and 0 when the server may start.
+func generateDevDataCommand(state *RuntimeState, args []string) int {
+	if !state.devMode {
+		fmt.Fprintf(os.Stderr, "generate runs in dev mode only, with -devmode\n")
+		return 2
+	}
+	config, err := parseDevDataArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		fmt.Fprintf(os.Stderr, "Usage: generate [-users N] [-groups N] [-members N] [-subgroups N] [-requests N] [-prefix P] [-seed N]\n")
+		return 2
+	}
+	fmt.Printf("generating %d users and %d groups named %s*\n", config.Users, config.Groups, config.Prefix)
+	_, err = state.generateDevData(config, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Generate FAILED: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestParseDevDataArgs(t *testing.T) {
+	config, err := parseDevDataArgs([]string{"-users", "100000", "-groups", "10000", "-prefix", "scale-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Users != 100000 || config.Groups != 10000 || config.Members != 20 || config.Prefix != "scale-" {
+		t.Fatalf("bad config %+v", config)
+	}
+	for _, args := range [][]string{{"-users", "0"}, {"-prefix", ""}, {"-members", "-1"},
+		{"-users", "10", "-members", "11"}, {"-groups", "5", "-subgroups", "5"},
+		{"-users", "10", "-groups", "2", "-members", "8", "-requests", "5"}, {"-unknown"}} {
+		_, err = parseDevDataArgs(args)
+		if err == nil {
+			t.Errorf("%v should be invalid", args)
+		}
+	}
+}
+
+func TestGenerateDevData(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory, err := newDevModeDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Userinfo = directory
+	if code := generateDevDataCommand(&state, nil); code != 2 {
+		t.Fatalf("generate ran out of dev mode, code %d", code)
+	}
+	config := devDataConfig{Users: 50, Groups: 20, Members: 5, Subgroups: 8, Requests: 30, Prefix: "devdata-",
+		Seed: 1}
+	// the test database outlives the runs
+	deleteRequests := func() {
+		for i := 0; i < config.Groups; i++ {
+			_, err := state.db.Exec(deleteEntryofGroupsStmt[state.dbType], config.groupname(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	deleteRequests()
+	defer deleteRequests()
+	report, err := state.generateDevData(config, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (devDataReport{Users: 50, Groups: 20, Subgroups: 8, Requests: 30}) {
+		t.Fatalf("bad report %+v", report)
+	}
+
+	subgroups := 0
+	for i := 0; i < config.Groups; i++ {
+		members, managedBy, err := directory.GetusersofaGroup(config.groupname(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(members) != config.Members {
+			t.Errorf("%s has the members %v", config.groupname(i), members)
+		}
+		if (i%10 == 0) != (managedBy == descriptionAttribute) {
+			t.Errorf("%s is managed by %s", config.groupname(i), managedBy)
+		}
+		children, err := directory.GetSubgroupsofGroup(config.groupname(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, child := range children {
+			if child <= config.groupname(i) {
+				t.Errorf("%s is nested in %s created after it", child, config.groupname(i))
+			}
+		}
+		subgroups += len(children)
+	}
+	if subgroups != config.Subgroups {
+		t.Errorf("%d subgroups", subgroups)
+	}
+
+	entries, err := getDBentries(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry[1], config.Prefix) {
+			continue
+		}
+		requests++
+		isMember, _, err := directory.IsgroupmemberorNot(entry[1], entry[0])
+		if err != nil || isMember {
+			t.Errorf("%s requested %s as a member, err %v", entry[0], entry[1], err)
+		}
+	}
+	if requests != config.Requests {
+		t.Errorf("%d pending requests", requests)
+	}
+
+	// the same seed generates the same data
+	other, err := newDevModeDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Userinfo = other
+	config.Requests = 0
+	if _, err := state.generateDevData(config, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < config.Groups; i++ {
+		want, _, _ := directory.GetusersofaGroup(config.groupname(i))
+		got, _, _ := other.GetusersofaGroup(config.groupname(i))
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("%s has the members %v then %v", config.groupname(i), want, got)
+		}
+	}
+}
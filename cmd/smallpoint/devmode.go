@@ -0,0 +1,273 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+	"github.com/Symantec/ldap-group-management/lib/authn/mockoidc"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/mock"
+)
+
+// The -devmode flag runs the whole application with nothing else to set
+// up: the directory is the in-memory mock backend seeded with sample users
+// and groups, not an LDAP server, the database is SQLite in a new temporary
+// directory, the server listens on plain HTTP on localhost and the cookies
+// lose their Secure flag. The users sign in at the built in mock OpenID
+// Connect provider, by picking one of the users of the directory, so that
+// the whole login flow runs. The config file is optional, its other
+// sections still apply. Anyone reaching the server signs in as any user,
+// so the listen address, the hostname and the database of the config file
+// are ignored: dev mode started on a production host with its config must
+// neither be reachable nor change the production database. The outbound
+// integrations are disabled for the same reason, the changes made in dev
+// mode must not reach the production syslog, event brokers, SCIM targets,
+// GitHub, Okta, AWS or ticketing system.
+
+const (
+	devModeHTTPAddress = "localhost:8080"
+	devModeAdmin       = "admin"
+	devModeClientID    = "smallpoint-dev"
+
+	devOIDCPath = "/dev/oidc"
+)
+
+type devModeUser struct {
+	Username  string
+	GivenName string
+}
+
+type devModeGroup struct {
+	Groupname string
+	ManagedBy string
+	Members   []string
+}
+
+var devModeUsers = []devModeUser{
+	{devModeAdmin, "Admin"},
+	{"alice", "Alice"},
+	{"bob", "Bob"},
+	{"carol", "Carol"},
+	{"dave", "Dave"},
+	{"erin", "Erin"},
+	{"frank", "Frank"},
+}
+
+var devModeGroups = []devModeGroup{
+	{"admins", descriptionAttribute, []string{devModeAdmin}},
+	{"eng-leads", descriptionAttribute, []string{"alice"}},
+	{"engineering", "eng-leads", []string{"alice", "bob", "carol"}},
+	{"oncall", "eng-leads", []string{"bob", "dave"}},
+	{"finance", descriptionAttribute, []string{"erin", "frank"}},
+	{"security", "admins", []string{devModeAdmin, "carol"}},
+}
+
+var devModeServiceAccounts = []userinfo.GroupInfo{
+	{Groupname: "svc-deploy", Mail: "deploy@example.com", LoginShell: "/bin/false"},
+}
+
+// newDevModeDirectory returns the in-memory directory of the sample users
+// and groups, the admin user is the super admin.
+func newDevModeDirectory() (*mock.Synchronized, error) {
+	directory := &mock.MockLdap{
+		Groups:      make(map[string]mock.LdapGroupInfo),
+		Users:       make(map[string]mock.LdapUserInfo),
+		Services:    make(map[string]mock.LdapServiceInfo),
+		SuperAdmins: devModeAdmin,
+	}
+	for _, user := range devModeUsers {
+		err := directory.CreateUser(user.Username, []string{user.GivenName},
+			[]string{user.Username + "@example.com"})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, group := range devModeGroups {
+		err := directory.CreateGroup(userinfo.GroupInfo{Groupname: group.Groupname, Description: group.ManagedBy})
+		if err != nil {
+			return nil, err
+		}
+		err = directory.AddmemberstoExisting(userinfo.GroupInfo{Groupname: group.Groupname,
+			MemberUid: group.Members})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, account := range devModeServiceAccounts {
+		err := directory.CreateServiceAccount(account)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mock.NewSynchronized(directory), nil
+}
+
+// devModeOutboundSections returns the sections of the config file that send
+// the changes or the events to other systems, by their name in the file.
+func devModeOutboundSections(config *AppConfigFile) map[string]interface{} {
+	return map[string]interface{}{
+		"audit.syslog":              &config.Audit.Syslog,
+		"event_publishing":          &config.EventPublishing,
+		"scim_provisioning":         &config.SCIMProvisioning,
+		"github_team_sync":          &config.GitHubTeamSync,
+		"okta":                      &config.Okta,
+		"aws_identity_center":       &config.AWSIdentityCenter,
+		"ticketing":                 &config.Ticketing,
+		"mailing_lists.webhook_url": &config.MailingLists.WebhookURL,
+		"error_reporting":           &config.ErrorReporting,
+		"tracing":                   &config.Tracing,
+		"redis":                     &config.Redis,
+	}
+}
+
+// setDevModeConfig fills in the settings dev mode needs, it replaces the
+// listen address, the hostname and the database of the config file and
+// disables its outbound integrations.
+func setDevModeConfig(config *AppConfigFile) error {
+	if config.Base.HttpAddress != "" || config.Base.Hostname != "" || config.Base.StorageURL != "" {
+		slog.Warn("dev mode ignores the http_address, hostname and storage_url of the config file")
+	}
+	var disabled []string
+	for name, section := range devModeOutboundSections(config) {
+		value := reflect.ValueOf(section).Elem()
+		if !value.IsZero() {
+			disabled = append(disabled, name)
+			value.Set(reflect.Zero(value.Type()))
+		}
+	}
+	if len(disabled) > 0 {
+		sort.Strings(disabled)
+		slog.Warn("dev mode disables the outbound integrations of the config file", "sections", disabled)
+	}
+	config.Base.HttpAddress = devModeHTTPAddress
+	config.Base.Hostname = "http://" + devModeHTTPAddress
+	dataDirectory, err := os.MkdirTemp("", "smallpoint-dev")
+	if err != nil {
+		return err
+	}
+	slog.Info("dev mode data directory", "path", dataDirectory)
+	config.Base.StorageURL = "sqlite:" + filepath.Join(dataDirectory, "smallpoint.db")
+	if config.Base.LogDirectory == "" {
+		config.Base.LogDirectory = dataDirectory
+	}
+	if len(config.Base.SharedSecrets) == 0 && config.Base.ClusterSharedSecretFilename == "" {
+		secret, err := generateSharedSecret()
+		if err != nil {
+			return err
+		}
+		config.Base.SharedSecrets = []string{secret}
+	}
+	// the server calls the token and userinfo endpoints of the mock
+	// provider on itself
+	clientSecret, err := generateSharedSecret()
+	if err != nil {
+		return err
+	}
+	localURL := devModeLocalURL(config.Base.HttpAddress)
+	config.OpenID = authn.OpenIDConfig{
+		ClientID:     devModeClientID,
+		ClientSecret: clientSecret,
+		AuthURL:      devOIDCPath + mockoidc.AuthorizePath,
+		TokenURL:     localURL + devOIDCPath + mockoidc.TokenPath,
+		UserinfoURL:  localURL + devOIDCPath + mockoidc.UserinfoPath,
+		Scopes:       "openid profile email",
+	}
+	config.SecurityHeaders.HSTSMaxAge = -1
+	return nil
+}
+
+// loadDevModeConfig returns the state of dev mode, the config file is read
+// when it exists.
+func loadDevModeConfig(configFilename string) (RuntimeState, error) {
+	var state RuntimeState
+	state.devMode = true
+	if _, err := os.Stat(configFilename); err == nil {
+		err = readConfigFile(configFilename, &state.Config)
+		if err != nil {
+			return state, err
+		}
+	}
+	err := setDevModeConfig(&state.Config)
+	if err != nil {
+		return state, err
+	}
+	err = state.setupFromConfig()
+	if err != nil {
+		return state, err
+	}
+	directory, err := newDevModeDirectory()
+	if err != nil {
+		return state, err
+	}
+	state.Userinfo = directory
+	state.UserSourceinfo = directory
+	state.authenticator.SetInsecureTransport(true)
+	slog.Warn("running in dev mode, do not use it in production", "url", state.Config.Base.Hostname)
+	return state, nil
+}
+
+// devModeLocalURL returns the URL of the server for the server itself.
+func devModeLocalURL(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "http://" + address
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// devModeOIDCDirectory gives the users of the directory to the mock
+// provider.
+type devModeOIDCDirectory struct {
+	state *RuntimeState
+}
+
+func (d devModeOIDCDirectory) User(username string) (*mockoidc.User, error) {
+	exists, err := d.state.Userinfo.UsernameExistsornot(username)
+	if err != nil || !exists {
+		return nil, err
+	}
+	user := mockoidc.User{Username: username}
+	emails, givenNames, err := d.state.Userinfo.GetUserAttributes(username)
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) > 0 {
+		user.Email = emails[0]
+	}
+	if len(givenNames) > 0 {
+		user.Name = givenNames[0]
+	}
+	return &user, nil
+}
+
+func (d devModeOIDCDirectory) Users(limit int) ([]mockoidc.User, error) {
+	usernames, err := d.state.Userinfo.GetallUsers()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(usernames)
+	if len(usernames) > limit {
+		usernames = usernames[:limit]
+	}
+	var users []mockoidc.User
+	for _, username := range usernames {
+		users = append(users, mockoidc.User{Username: username})
+	}
+	return users, nil
+}
+
+// registerDevModeOIDC serves the mock provider the users sign in at.
+func (state *RuntimeState) registerDevModeOIDC(mux *http.ServeMux) {
+	provider := mockoidc.New(state.Config.Base.Hostname+devOIDCPath, state.Config.OpenID.ClientID,
+		state.Config.OpenID.ClientSecret, devModeOIDCDirectory{state: state})
+	provider.RegisterHandlers(mux, devOIDCPath)
+}
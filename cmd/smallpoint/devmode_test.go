@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+	"github.com/Symantec/ldap-group-management/lib/authn/mockoidc"
+)
+
+func TestDevMode(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory, err := newDevModeDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Userinfo = directory
+	groups, err := directory.GetgroupsofUser("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("carol is in %v", groups)
+	}
+	isAdmin, err := directory.IsgroupAdminorNot("alice", "oncall")
+	if err != nil || !isAdmin {
+		t.Fatalf("the eng-leads do not manage oncall, err %v", err)
+	}
+	if !directory.UserisadminOrNot(devModeAdmin) || directory.UserisadminOrNot("alice") {
+		t.Fatal("admin is not the only super admin")
+	}
+
+	// the production settings of the config file are not used
+	var config AppConfigFile
+	config.Base.HttpAddress = ":443"
+	config.Base.Hostname = "https://smallpoint.example.com"
+	config.Base.StorageURL = "postgresql://smallpoint@db.example.com/smallpoint"
+	config.Base.LogDirectory = t.TempDir()
+	config.Audit.Syslog.Address = "syslog.example.com:514"
+	config.EventPublishing.Kafka.RESTProxyURL = "https://kafka.example.com"
+	config.SCIMProvisioning.Targets = []scimTargetConfig{{Name: "app", URL: "https://app.example.com/scim/v2"}}
+	config.GitHubTeamSync.TokenFilename = "/etc/smallpoint/github-token"
+	config.Okta.OrgURL = "https://example.okta.com"
+	config.AWSIdentityCenter.SCIMEndpoint = "https://scim.us-east-1.amazonaws.com/abcd-1234/scim/v2/"
+	config.Ticketing.URL = "https://example.atlassian.net"
+	config.MailingLists.WebhookURL = "https://lists.example.com/hook"
+	err = setDevModeConfig(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Base.HttpAddress != devModeHTTPAddress || config.Base.Hostname != "http://"+devModeHTTPAddress ||
+		config.OpenID.AuthURL != "/dev/oidc/authorize" ||
+		config.OpenID.TokenURL != "http://localhost:8080/dev/oidc/token" ||
+		len(config.Base.SharedSecrets) != 1 || !strings.HasPrefix(config.Base.StorageURL, "sqlite:") {
+		t.Fatalf("unexpected dev mode config %+v %+v", config.Base, config.OpenID)
+	}
+	os.RemoveAll(filepath.Dir(strings.TrimPrefix(config.Base.StorageURL, "sqlite:")))
+	for name, section := range devModeOutboundSections(&config) {
+		if !reflect.ValueOf(section).Elem().IsZero() {
+			t.Fatalf("the %s section is not disabled in dev mode", name)
+		}
+	}
+	if url := devModeLocalURL("0.0.0.0:8080"); url != "http://localhost:8080" {
+		t.Fatalf("unexpected local URL %s", url)
+	}
+
+	// sign in at the mock provider
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	state.Config.Base.Hostname = server.URL
+	state.Config.OpenID = config.OpenID
+	state.Config.OpenID.AuthURL = server.URL + config.OpenID.AuthURL
+	state.Config.OpenID.TokenURL = server.URL + devOIDCPath + mockoidc.TokenPath
+	state.Config.OpenID.UserinfoURL = server.URL + devOIDCPath + mockoidc.UserinfoPath
+	state.authenticator = authn.NewAuthenticator(state.Config.OpenID, "smallpoint", nil,
+		config.Base.SharedSecrets, nil)
+	state.authenticator.SetInsecureTransport(true)
+	state.registerDevModeOIDC(mux)
+	mux.HandleFunc(authn.Oauth2redirectPath, state.authenticator.Oauth2RedirectPathHandler)
+	mux.HandleFunc(indexPath, func(w http.ResponseWriter, r *http.Request) {
+		username, err := state.authenticator.GetRemoteUserName(w, r)
+		if err != nil {
+			return
+		}
+		w.Write([]byte(username))
+	})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+	resp, err := client.Get(server.URL + indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `value="frank"`) {
+		t.Fatalf("the users are not listed: %s", body)
+	}
+	form := resp.Request.URL.Query()
+	form.Set("username", "bob")
+	resp, err = client.PostForm(resp.Request.URL.String(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "bob" {
+		t.Fatalf("the login failed with %d %s", resp.StatusCode, body)
+	}
+	cookies := jar.Cookies(resp.Request.URL)
+	if len(cookies) != 1 || cookies[0].Name != authn.AuthCookieName {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The directory sync mirrors the groups, their members and the user
+// attributes of the target LDAP into the DB on a schedule. mirroredUserInfo
+// serves the listings and lookups of the read heavy pages from the mirror
+// while it is fresh, and reads LDAP again once it is stale. Writes go to LDAP
+// and the groups they touch are refreshed in the mirror right away.
+
+const defaultDirectorySyncStalenessIntervals = 3
+
+type directorySyncConfig struct {
+	// Interval between syncs, 0 disables the mirror.
+	Interval time.Duration `yaml:"interval"`
+	// MaxStaleness is the age of the mirror after which LDAP is read
+	// again, 3 intervals by default.
+	MaxStaleness time.Duration `yaml:"max_staleness"`
+}
+
+func (config directorySyncConfig) maxStaleness() time.Duration {
+	if config.MaxStaleness > 0 {
+		return config.MaxStaleness
+	}
+	return defaultDirectorySyncStalenessIntervals * config.Interval
+}
+
+// directorySyncStatus tells the pages how old the data they show can be.
+type directorySyncStatus struct {
+	Enabled  bool
+	SyncedAt time.Time
+	Stale    bool
+}
+
+const getDirectorySyncStmt = "select completed_at from directory_syncs;"
+
+const getDirectoryGroupsStmt = "select groupname, managed_by from directory_groups order by groupname;"
+
+const getDirectoryUsersStmt = "select username from directory_users order by username;"
+
+var getDirectoryGroupStmt = map[string]string{
+	"sqlite":   "select managed_by from directory_groups where groupname=?;",
+	"postgres": "select managed_by from directory_groups where groupname=$1;",
+}
+
+var getDirectoryGroupMembersStmt = map[string]string{
+	"sqlite":   "select username from directory_members where groupname=? order by username;",
+	"postgres": "select username from directory_members where groupname=$1 order by username;",
+}
+
+var getDirectoryUserGroupsStmt = map[string]string{
+	"sqlite": "select directory_groups.groupname, directory_groups.managed_by from directory_members join directory_groups " +
+		"on directory_groups.groupname=directory_members.groupname where directory_members.username=? order by directory_groups.groupname;",
+	"postgres": "select directory_groups.groupname, directory_groups.managed_by from directory_members join directory_groups " +
+		"on directory_groups.groupname=directory_members.groupname where directory_members.username=$1 order by directory_groups.groupname;",
+}
+
+var getDirectoryUserStmt = map[string]string{
+	"sqlite":   "select email, given_name from directory_users where username=?;",
+	"postgres": "select email, given_name from directory_users where username=$1;",
+}
+
+var insertDirectoryGroupStmt = map[string]string{
+	"sqlite":   "insert into directory_groups(groupname, managed_by) values (?,?);",
+	"postgres": "insert into directory_groups(groupname, managed_by) values ($1,$2);",
+}
+
+var insertDirectoryMemberStmt = map[string]string{
+	"sqlite":   "insert into directory_members(groupname, username) values (?,?);",
+	"postgres": "insert into directory_members(groupname, username) values ($1,$2);",
+}
+
+var insertDirectoryUserStmt = map[string]string{
+	"sqlite":   "insert into directory_users(username, email, given_name) values (?,?,?);",
+	"postgres": "insert into directory_users(username, email, given_name) values ($1,$2,$3);",
+}
+
+var insertDirectorySyncStmt = map[string]string{
+	"sqlite":   "insert into directory_syncs(completed_at, group_count, user_count) values (?,?,?);",
+	"postgres": "insert into directory_syncs(completed_at, group_count, user_count) values ($1,$2,$3);",
+}
+
+var deleteDirectoryGroupStmts = map[string][]string{
+	"sqlite": {"delete from directory_groups where groupname=?;",
+		"delete from directory_members where groupname=?;"},
+	"postgres": {"delete from directory_groups where groupname=$1;",
+		"delete from directory_members where groupname=$1;"},
+}
+
+var deleteDirectoryUserStmt = map[string]string{
+	"sqlite":   "delete from directory_users where username=?;",
+	"postgres": "delete from directory_users where username=$1;",
+}
+
+var renameDirectoryManagerStmt = map[string]string{
+	"sqlite":   "update directory_groups set managed_by=? where managed_by=?;",
+	"postgres": "update directory_groups set managed_by=$1 where managed_by=$2;",
+}
+
+var clearDirectoryMirrorStmts = []string{"delete from directory_groups;", "delete from directory_members;",
+	"delete from directory_users;", "delete from directory_syncs;"}
+
+type directoryGroup struct {
+	groupname string
+	managedBy string
+	members   []string
+}
+
+type directoryUser struct {
+	username  string
+	email     []string
+	givenName []string
+}
+
+type mirroredUserInfo struct {
+	userinfo.UserInfoBackend
+	state        *RuntimeState
+	groupBaseDN  string
+	maxStaleness time.Duration
+
+	mutex    sync.Mutex
+	syncedAt time.Time
+	// written holds the groups written while a sync runs, they are
+	// refreshed again after the sync
+	syncing bool
+	written map[string]bool
+}
+
+func newMirroredUserInfo(source userinfo.UserInfoBackend, state *RuntimeState, groupBaseDN string,
+	config directorySyncConfig) (*mirroredUserInfo, error) {
+	u := &mirroredUserInfo{UserInfoBackend: source, state: state, groupBaseDN: groupBaseDN,
+		maxStaleness: config.maxStaleness(), written: make(map[string]bool)}
+	err := u.loadSyncedAt()
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// loadSyncedAt reads the time of the last sync, the instances that do not
+// run the sync job pick up the syncs of the leader.
+func (u *mirroredUserInfo) loadSyncedAt() error {
+	var completedAt int64
+	err := u.state.db.QueryRow(getDirectorySyncStmt).Scan(&completedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.syncedAt = time.Unix(completedAt, 0)
+	return nil
+}
+
+func (u *mirroredUserInfo) status() directorySyncStatus {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return directorySyncStatus{Enabled: true, SyncedAt: u.syncedAt,
+		Stale: time.Since(u.syncedAt) > u.maxStaleness}
+}
+
+func (u *mirroredUserInfo) fresh() bool {
+	return !u.status().Stale
+}
+
+// sync replaces the mirror with the current content of the directory.
+func (u *mirroredUserInfo) sync() error {
+	u.mutex.Lock()
+	u.syncing = true
+	u.mutex.Unlock()
+	defer func() {
+		u.mutex.Lock()
+		u.syncing = false
+		u.written = make(map[string]bool)
+		u.mutex.Unlock()
+	}()
+	allGroups, err := u.UserInfoBackend.GetAllGroupsManagedBy()
+	if err != nil {
+		return err
+	}
+	groupnames := make([]string, len(allGroups))
+	for i, group := range allGroups {
+		groupnames[i] = group[0]
+	}
+	found := make([]*directoryGroup, len(groupnames))
+	err = runGroupQueries(groupnames, u.state.Config.Base.ldapQueryConcurrency(),
+		func(index int, groupname string) error {
+			members, managedBy, err := u.UserInfoBackend.GetusersofaGroup(groupname)
+			if err != nil {
+				if err == userinfo.GroupDoesNotExist {
+					return nil
+				}
+				return err
+			}
+			found[index] = &directoryGroup{groupname: groupname, managedBy: managedBy, members: members}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	var groups []directoryGroup
+	for _, group := range found {
+		if group != nil {
+			groups = append(groups, *group)
+		}
+	}
+	usernames, err := u.UserInfoBackend.GetallUsers()
+	if err != nil {
+		return err
+	}
+	var users []directoryUser
+	for _, username := range usernames {
+		user := directoryUser{username: username}
+		email, givenName, err := u.UserInfoBackend.GetUserAttributes(username)
+		// the attributes of users without mail or givenName are looked up
+		// in LDAP
+		if err == nil {
+			user.email = email
+			user.givenName = givenName
+		}
+		users = append(users, user)
+	}
+	completedAt := time.Now()
+	err = u.replaceMirror(groups, users, completedAt)
+	if err != nil {
+		return err
+	}
+
+	u.mutex.Lock()
+	u.syncedAt = completedAt
+	var written []string
+	for groupname := range u.written {
+		written = append(written, groupname)
+	}
+	u.mutex.Unlock()
+	for _, groupname := range written {
+		u.refreshGroup(groupname)
+	}
+	return nil
+}
+
+func (u *mirroredUserInfo) replaceMirror(groups []directoryGroup, users []directoryUser, completedAt time.Time) error {
+	state := u.state
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmtText := range clearDirectoryMirrorStmts {
+		_, err = tx.Exec(stmtText)
+		if err != nil {
+			return err
+		}
+	}
+	for _, group := range groups {
+		err = insertDirectoryGroup(tx, state.dbType, group)
+		if err != nil {
+			return err
+		}
+	}
+	for _, user := range users {
+		_, err = tx.Exec(insertDirectoryUserStmt[state.dbType], user.username, strings.Join(user.email, " "),
+			strings.Join(user.givenName, " "))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(insertDirectorySyncStmt[state.dbType], completedAt.Unix(), len(groups), len(users))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func insertDirectoryGroup(tx *instrumentedTx, dbType string, group directoryGroup) error {
+	_, err := tx.Exec(insertDirectoryGroupStmt[dbType], group.groupname, group.managedBy)
+	if err != nil {
+		return err
+	}
+	for _, member := range group.members {
+		_, err = tx.Exec(insertDirectoryMemberStmt[dbType], group.groupname, member)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshGroup copies the group from LDAP to the mirror after a write, the
+// group stays stale until the next sync when this fails.
+func (u *mirroredUserInfo) refreshGroup(groupname string) {
+	u.mutex.Lock()
+	if u.syncing {
+		u.written[groupname] = true
+	}
+	u.mutex.Unlock()
+	err := u.refreshGroupInDB(groupname)
+	if err != nil {
+		slog.Error("cannot refresh the group in the directory mirror", "group", groupname, "err", err)
+	}
+}
+
+func (u *mirroredUserInfo) refreshGroupInDB(groupname string) error {
+	state := u.state
+	members, managedBy, err := u.UserInfoBackend.GetusersofaGroup(groupname)
+	if err != nil && err != userinfo.GroupDoesNotExist {
+		return err
+	}
+	exists := err == nil
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmtText := range deleteDirectoryGroupStmts[state.dbType] {
+		_, err = tx.Exec(stmtText, groupname)
+		if err != nil {
+			return err
+		}
+	}
+	if exists {
+		err = insertDirectoryGroup(tx, state.dbType, directoryGroup{groupname: groupname, managedBy: managedBy,
+			members: members})
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func (u *mirroredUserInfo) refreshUser(username string) {
+	state := u.state
+	// the attributes are left out when they cannot be read
+	email, givenName, _ := u.UserInfoBackend.GetUserAttributes(username)
+	err := execServiceAccountUpdate(state, deleteDirectoryUserStmt[state.dbType], username)
+	if err == nil {
+		err = execServiceAccountUpdate(state, insertDirectoryUserStmt[state.dbType], username,
+			strings.Join(email, " "), strings.Join(givenName, " "))
+	}
+	if err != nil {
+		slog.Error("cannot refresh the user in the directory mirror", "username", username, "err", err)
+	}
+}
+
+func (u *mirroredUserInfo) queryGroupTuples(stmtText string, args ...interface{}) ([][]string, error) {
+	start := time.Now()
+	rows, err := u.state.db.Query(stmtText, args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var groups [][]string
+	for rows.Next() {
+		var groupname, managedBy string
+		err = rows.Scan(&groupname, &managedBy)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, []string{groupname, managedBy})
+	}
+	return groups, rows.Err()
+}
+
+// getGroup returns false when the group is not in the mirror.
+func (u *mirroredUserInfo) getGroup(groupname string) ([]string, string, bool, error) {
+	state := u.state
+	var managedBy string
+	err := state.db.QueryRow(getDirectoryGroupStmt[state.dbType], groupname).Scan(&managedBy)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", false, nil
+		}
+		return nil, "", false, err
+	}
+	members, err := queryStringsFromDB(state, getDirectoryGroupMembersStmt[state.dbType], groupname)
+	return members, managedBy, true, err
+}
+
+// getUser returns false when the attributes of the user are not in the
+// mirror.
+func (u *mirroredUserInfo) getUser(username string) ([]string, []string, bool, error) {
+	state := u.state
+	var email, givenName string
+	err := state.db.QueryRow(getDirectoryUserStmt[state.dbType], username).Scan(&email, &givenName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, false, nil
+		}
+		return nil, nil, false, err
+	}
+	if email == "" || givenName == "" {
+		return nil, nil, false, nil
+	}
+	return strings.Fields(email), strings.Fields(givenName), true, nil
+}
+
+func (u *mirroredUserInfo) GetallGroups() ([]string, error) {
+	if !u.fresh() {
+		return u.UserInfoBackend.GetallGroups()
+	}
+	groups, err := u.queryGroupTuples(getDirectoryGroupsStmt)
+	if err != nil {
+		return nil, err
+	}
+	groupnames := []string{}
+	for _, group := range groups {
+		groupnames = append(groupnames, group[0])
+	}
+	return groupnames, nil
+}
+
+func (u *mirroredUserInfo) GetAllGroupsManagedBy() ([][]string, error) {
+	if !u.fresh() {
+		return u.UserInfoBackend.GetAllGroupsManagedBy()
+	}
+	return u.queryGroupTuples(getDirectoryGroupsStmt)
+}
+
+func (u *mirroredUserInfo) GetgroupsofUser(username string) ([]string, error) {
+	if !u.fresh() {
+		return u.UserInfoBackend.GetgroupsofUser(username)
+	}
+	groups, err := u.queryGroupTuples(getDirectoryUserGroupsStmt[u.state.dbType], username)
+	if err != nil {
+		return nil, err
+	}
+	groupnames := []string{}
+	for _, group := range groups {
+		groupnames = append(groupnames, group[0])
+	}
+	return groupnames, nil
+}
+
+func (u *mirroredUserInfo) GetGroupsInfoOfUser(groupdn string, username string) ([][]string, error) {
+	if groupdn != u.groupBaseDN || !u.fresh() {
+		return u.UserInfoBackend.GetGroupsInfoOfUser(groupdn, username)
+	}
+	return u.queryGroupTuples(getDirectoryUserGroupsStmt[u.state.dbType], username)
+}
+
+func (u *mirroredUserInfo) GetusersofaGroup(groupname string) ([]string, string, error) {
+	if !u.fresh() {
+		return u.UserInfoBackend.GetusersofaGroup(groupname)
+	}
+	members, managedBy, ok, err := u.getGroup(groupname)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		return u.UserInfoBackend.GetusersofaGroup(groupname)
+	}
+	return members, managedBy, nil
+}
+
+func (u *mirroredUserInfo) GetGroupUsersAndManagers(groupname string) ([]string, []string, string, error) {
+	if !u.fresh() {
+		return u.UserInfoBackend.GetGroupUsersAndManagers(groupname)
+	}
+	members, managedBy, ok, err := u.getGroup(groupname)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if !ok {
+		return u.UserInfoBackend.GetGroupUsersAndManagers(groupname)
+	}
+	managers, _, ok, err := u.getGroup(managedBy)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if !ok {
+		return u.UserInfoBackend.GetGroupUsersAndManagers(groupname)
+	}
+	return members, managers, managedBy, nil
+}
+
+func (u *mirroredUserInfo) GetallUsers() ([]string, error) {
+	if !u.fresh() {
+		return u.UserInfoBackend.GetallUsers()
+	}
+	return queryStringsFromDB(u.state, getDirectoryUsersStmt)
+}
+
+func (u *mirroredUserInfo) GetUserAttributes(username string) ([]string, []string, error) {
+	if !u.fresh() {
+		return u.UserInfoBackend.GetUserAttributes(username)
+	}
+	email, givenName, ok, err := u.getUser(username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return u.UserInfoBackend.GetUserAttributes(username)
+	}
+	return email, givenName, nil
+}
+
+func (u *mirroredUserInfo) GetEmailofauser(username string) ([]string, error) {
+	if !u.fresh() {
+		return u.UserInfoBackend.GetEmailofauser(username)
+	}
+	email, _, ok, err := u.getUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return u.UserInfoBackend.GetEmailofauser(username)
+	}
+	return email, nil
+}
+
+// The writes below go to LDAP and refresh the mirror.
+
+func (u *mirroredUserInfo) CreateGroup(groupinfo userinfo.GroupInfo) error {
+	defer u.refreshGroup(groupinfo.Groupname)
+	return u.UserInfoBackend.CreateGroup(groupinfo)
+}
+
+func (u *mirroredUserInfo) DeleteGroup(groupnames []string) error {
+	defer func() {
+		for _, groupname := range groupnames {
+			u.refreshGroup(groupname)
+		}
+	}()
+	return u.UserInfoBackend.DeleteGroup(groupnames)
+}
+
+func (u *mirroredUserInfo) ChangeDescription(groupname string, managegroup string) error {
+	defer u.refreshGroup(groupname)
+	return u.UserInfoBackend.ChangeDescription(groupname, managegroup)
+}
+
+func (u *mirroredUserInfo) RenameGroup(groupname string, newname string) error {
+	err := u.UserInfoBackend.RenameGroup(groupname, newname)
+	u.refreshGroup(groupname)
+	u.refreshGroup(newname)
+	if err != nil {
+		return err
+	}
+	// the groups managed by the group are updated in LDAP by the rename
+	updateErr := execServiceAccountUpdate(u.state, renameDirectoryManagerStmt[u.state.dbType], newname, groupname)
+	if updateErr != nil {
+		slog.Error("cannot rename the manager in the directory mirror", "group", groupname, "err", updateErr)
+	}
+	return nil
+}
+
+func (u *mirroredUserInfo) AddmemberstoExisting(groupinfo userinfo.GroupInfo) error {
+	defer u.refreshGroup(groupinfo.Groupname)
+	return u.UserInfoBackend.AddmemberstoExisting(groupinfo)
+}
+
+func (u *mirroredUserInfo) DeletemembersfromGroup(groupinfo userinfo.GroupInfo) error {
+	defer u.refreshGroup(groupinfo.Groupname)
+	return u.UserInfoBackend.DeletemembersfromGroup(groupinfo)
+}
+
+func (u *mirroredUserInfo) CreateUser(username string, givenName, email []string) error {
+	defer u.refreshUser(username)
+	return u.UserInfoBackend.CreateUser(username, givenName, email)
+}
+
+// directorySyncStatus returns the status of the mirror for the pages.
+func (state *RuntimeState) directorySyncStatus() directorySyncStatus {
+	if state.directoryMirror == nil {
+		return directorySyncStatus{}
+	}
+	return state.directoryMirror.status()
+}
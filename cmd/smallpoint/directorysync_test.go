@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func testMirrorHasGroup(t *testing.T, mirror *mirroredUserInfo, groupname string) bool {
+	groups, err := mirror.GetallGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range groups {
+		if group == groupname {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDirectoryMirror(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := state.Userinfo
+	mirror, err := newMirroredUserInfo(source, &state, "", directorySyncConfig{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Userinfo = mirror
+	state.directoryMirror = mirror
+	err = mirror.sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := state.directorySyncStatus()
+	if !status.Enabled || status.Stale || time.Since(status.SyncedAt) > time.Minute {
+		t.Fatalf("bad status after a sync %+v", status)
+	}
+	members, managedBy, err := mirror.GetusersofaGroup("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, []string{"user1", "user2"}) || managedBy != descriptionAttribute {
+		t.Fatalf("bad mirror of group1 %v managed by %s", members, managedBy)
+	}
+
+	// changes made elsewhere show after the next sync
+	err = source.CreateGroup(userinfo.GroupInfo{Groupname: "mirror-external", Description: descriptionAttribute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testMirrorHasGroup(t, mirror, "mirror-external") {
+		t.Fatal("the listing was not read from the mirror")
+	}
+
+	// writes made here show at once
+	err = mirror.CreateGroup(userinfo.GroupInfo{Groupname: "mirror-new", Description: "group1",
+		MemberUid: []string{"user3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := mirror.GetgroupsofUser("user3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []string{"mirror-new"}) {
+		t.Fatalf("the new group is not in the mirror, user3 groups %v", groups)
+	}
+	err = mirror.DeleteGroup([]string{"mirror-new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testMirrorHasGroup(t, mirror, "mirror-new") {
+		t.Fatal("the deleted group is still in the mirror")
+	}
+
+	mirror.maxStaleness = time.Nanosecond
+	if !state.directorySyncStatus().Stale || !testMirrorHasGroup(t, mirror, "mirror-external") {
+		t.Fatal("LDAP is not read when the mirror is stale")
+	}
+}
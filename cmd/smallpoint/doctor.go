@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo"
+)
+
+// The doctor command runs the checks of the LDAP directories and prints
+// the result of each one, for the setup of a deployment and for the
+// incidents. The target directory is also checked for the schema and,
+// unless -read-only is given, for the permission to add entries.
+
+// directoryDiagnoser is implemented by the LDAP directories and the SQL
+// directory.
+type directoryDiagnoser interface {
+	Diagnose(options ldapuserinfo.DiagnoseOptions) []ldapuserinfo.DiagnosticResult
+}
+
+// writeDiagnosis prints the results, it returns the number of failed
+// checks.
+func writeDiagnosis(out io.Writer, section string, results []ldapuserinfo.DiagnosticResult) int {
+	failed := 0
+	for _, result := range results {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(out, "%s %s %s: %s\n", status, section, result.Check, result.Detail)
+	}
+	return failed
+}
+
+func runDoctor(out io.Writer, target directoryDiagnoser, source directoryDiagnoser, readOnly bool) int {
+	failed := writeDiagnosis(out, "target_config",
+		target.Diagnose(ldapuserinfo.DiagnoseOptions{Schema: true, Write: !readOnly}))
+	if source != nil {
+		failed += writeDiagnosis(out, "source_config", source.Diagnose(ldapuserinfo.DiagnoseOptions{}))
+	}
+	return failed
+}
+
+// doctorCommand implements the doctor command, it returns the process exit
+// code.
+func doctorCommand(state *RuntimeState, args []string) int {
+	flagSet := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	readOnly := flagSet.Bool("read-only", false, "Skip the checks adding and deleting a temporary group")
+	err := flagSet.Parse(args)
+	if err != nil || flagSet.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: doctor [-read-only]\n")
+		return 2
+	}
+	var source directoryDiagnoser
+	if state.Config.SourceLDAP.LDAPTargetURLs != "" {
+		source = &state.Config.SourceLDAP
+	}
+	var target directoryDiagnoser = &state.Config.TargetLDAP
+	if state.sqlDirectory != nil {
+		target = state.sqlDirectory
+	}
+	failed := runDoctor(os.Stdout, target, source, *readOnly)
+	if failed > 0 {
+		fmt.Printf("%d checks FAILED\n", failed)
+		return 1
+	}
+	fmt.Println("All checks passed")
+	return 0
+}
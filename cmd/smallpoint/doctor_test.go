@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo"
+)
+
+type testDiagnoser struct {
+	options ldapuserinfo.DiagnoseOptions
+	err     error
+}
+
+func (d *testDiagnoser) Diagnose(options ldapuserinfo.DiagnoseOptions) []ldapuserinfo.DiagnosticResult {
+	d.options = options
+	results := []ldapuserinfo.DiagnosticResult{{Check: "bind", Passed: true, Detail: "bound"}}
+	if d.err != nil {
+		results = append(results, ldapuserinfo.DiagnosticResult{Check: "schema", Detail: d.err.Error()})
+	}
+	return results
+}
+
+func TestRunDoctor(t *testing.T) {
+	target := &testDiagnoser{}
+	source := &testDiagnoser{err: errors.New("missing attributes memberUid")}
+	var out bytes.Buffer
+	failed := runDoctor(&out, target, source, true)
+	if failed != 1 {
+		t.Fatalf("%d failed checks:\n%s", failed, out.String())
+	}
+	expected := "PASS target_config bind: bound\nPASS source_config bind: bound\n" +
+		"FAIL source_config schema: missing attributes memberUid\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	if !target.options.Schema || target.options.Write || source.options.Schema {
+		t.Fatalf("unexpected options %+v %+v", target.options, source.options)
+	}
+}
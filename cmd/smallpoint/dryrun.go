@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// With the -dry-run flag, or the Smallpoint-Dry-Run header on a request,
+// the LDAP changes are logged instead of applied to rehearse bulk
+// operations. The reads still go to the directory, so the later steps of
+// an operation do not see its earlier changes. The audit events of a dry
+// run are still stored, marked as such, the group archives are not, so
+// that a rehearsed deletion is never carried out.
+
+const dryRunHeader = "Smallpoint-Dry-Run"
+
+// isDryRunRequest returns whether the request asks for a dry run.
+func isDryRunRequest(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	dryRun, _ := strconv.ParseBool(r.Header.Get(dryRunHeader))
+	return dryRun
+}
+
+// isDryRun returns whether the changes of the request are not applied, r
+// is nil outside of a request.
+func (state *RuntimeState) isDryRun(r *http.Request) bool {
+	return state.dryRun || isDryRunRequest(r)
+}
+
+// dryRunUserInfo logs the LDAP modifications of the changing methods and
+// returns without applying them.
+type dryRunUserInfo struct {
+	userinfo.UserInfoBackend
+	logger *slog.Logger
+	// manageAttribute is the attribute naming the managing group.
+	manageAttribute string
+}
+
+func newDryRunUserInfo(directory userinfo.UserInfoBackend, logger *slog.Logger, manageAttribute string) *dryRunUserInfo {
+	if manageAttribute == "" {
+		manageAttribute = "description"
+	}
+	return &dryRunUserInfo{UserInfoBackend: directory, logger: logger, manageAttribute: manageAttribute}
+}
+
+// logChange logs an LDAP operation on the entry named cn, args are the
+// changed attributes and their values.
+func (u *dryRunUserInfo) logChange(operation string, cn string, args ...interface{}) {
+	u.logger.Info("dry run, LDAP change not applied", append([]interface{}{"operation", operation, "cn", cn},
+		args...)...)
+}
+
+func (u *dryRunUserInfo) CreateGroup(groupinfo userinfo.GroupInfo) error {
+	gidNumber := groupinfo.GidNumber
+	if gidNumber == "" {
+		gidNumber = "next free"
+	}
+	u.logChange("add", groupinfo.Groupname, u.manageAttribute, groupinfo.Description,
+		"memberUid", groupinfo.MemberUid, "gidNumber", gidNumber)
+	return nil
+}
+
+func (u *dryRunUserInfo) DeleteGroup(groupnames []string) error {
+	for _, groupname := range groupnames {
+		u.logChange("delete", groupname)
+	}
+	return nil
+}
+
+func (u *dryRunUserInfo) ChangeDescription(groupname string, managegroup string) error {
+	u.logChange("modify", groupname, "replace", u.manageAttribute, "values", []string{managegroup})
+	return nil
+}
+
+func (u *dryRunUserInfo) SetGroupMail(groupname string, addresses []string) error {
+	u.logChange("modify", groupname, "replace", "mail", "values", addresses)
+	return nil
+}
+
+func (u *dryRunUserInfo) RenameGroup(groupname string, newname string) error {
+	u.logChange("rename", groupname, "newcn", newname, "replace", u.manageAttribute+" of the managed groups")
+	return nil
+}
+
+func (u *dryRunUserInfo) AddmemberstoExisting(groupinfo userinfo.GroupInfo) error {
+	u.logChange("modify", groupinfo.Groupname, "add", "memberUid, member", "values", groupinfo.MemberUid)
+	return nil
+}
+
+func (u *dryRunUserInfo) DeletemembersfromGroup(groupinfo userinfo.GroupInfo) error {
+	u.logChange("modify", groupinfo.Groupname, "delete", "memberUid, member", "values", groupinfo.MemberUid)
+	return nil
+}
+
+func (u *dryRunUserInfo) CreateServiceAccount(groupinfo userinfo.GroupInfo) error {
+	u.logChange("add", groupinfo.Groupname, "objectClass", "posixAccount", "mail", groupinfo.Mail,
+		"loginShell", groupinfo.LoginShell)
+	return nil
+}
+
+func (u *dryRunUserInfo) DisableServiceAccount(accountname string) error {
+	u.logChange("modify", accountname, "replace", "nsaccountLock, shadowExpire, loginShell",
+		"values", []string{"true", "1", "/bin/false"})
+	return nil
+}
+
+func (u *dryRunUserInfo) DeleteServiceAccount(accountname string) error {
+	u.logChange("delete", accountname)
+	return nil
+}
+
+func (u *dryRunUserInfo) SetServiceAccountPassword(accountname string, password string) error {
+	// the password is not logged
+	u.logChange("password modify", accountname)
+	return nil
+}
+
+func (u *dryRunUserInfo) CreateUser(username string, givenName, email []string) error {
+	u.logChange("add", username, "objectClass", "posixAccount", "givenName", strings.Join(givenName, " "),
+		"mail", email)
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDryRunRequest(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	formValues := url.Values{"groupname": {"group3"}, "members": {"user2"}}
+	req, err := http.NewRequest("POST", addmembersbuttonPath, strings.NewReader(formValues.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidAdminCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(dryRunHeader, "true")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.addmemberstoExistingGroup).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("dry run returned %d", rr.Code)
+	}
+	isMember, _, err := state.Userinfo.IsgroupmemberorNot("group3", "user2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isMember {
+		t.Fatal("the member was added on a dry run")
+	}
+	events, err := searchAuditEventsInDB(auditEventFilter{Groupname: "group3", Action: auditActionAddMember}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || !strings.HasPrefix(events[0].Details, "dry run") {
+		t.Fatalf("the dry run is not in the audit events %+v", events)
+	}
+}
+
+func TestDryRunGroupDelete(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formValues := url.Values{"groupnames": {"group2"}}
+	req, err := http.NewRequest("POST", deletegroupPath, strings.NewReader(formValues.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAddAuthCookie(req, state.authenticator, testCreateValidAdminCookie(state.authenticator))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(dryRunHeader, "true")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.deleteGrouphandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("dry run returned %d", rr.Code)
+	}
+	archives, err := queryGroupArchivesFromDB(&state, getAllGroupArchivesStmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 0 {
+		t.Fatalf("the dry run archived %+v", archives)
+	}
+	members, _, err := state.Userinfo.GetusersofaGroup("group2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) == 0 {
+		t.Fatal("the members were removed on a dry run")
+	}
+}
+
+func TestDryRunImport(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := state.Userinfo
+	state.dryRun = true
+	state.Userinfo = newDryRunUserInfo(directory, slog.Default(), "")
+	report, err := state.importGroups(nil, "user1", strings.NewReader("groups:\n- name: dryrun_group\n  managed_by: group1\n  members: [user2]\n- name: group3\n  managed_by: group2\n  members: [user1]\n"),
+		groupsFileFormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 1 || report.Changed != 1 {
+		t.Fatalf("bad import report %+v", report)
+	}
+	exists, _, err := directory.GroupnameExistsornot("dryrun_group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("the group was created on a dry run")
+	}
+	managedBy, err := directory.GetDescriptionvalue("group3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if managedBy != "group1" {
+		t.Fatalf("group3 is managed by %s after a dry run", managedBy)
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"github.com/mssola/user_agent"
 	"io"
 	"log"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/smtp"
+	"net/textproto"
 	texttemplate "text/template"
 )
 
@@ -32,9 +38,32 @@ var (
 		if err != nil {
 			return nil, err
 		}
+		if smtpAuth == nil {
+			return c, nil
+		}
+		auth := smtpAuth()
+		if auth == nil {
+			return c, nil
+		}
+		// the credentials are only sent over TLS, except to localhost
+		host, _, _ := net.SplitHostPort(addr)
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			err = c.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+		err = c.Auth(auth)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
 
 		return c, nil
 	}
+	// smtpAuth returns the SMTP credentials, nil when there are none.
+	smtpAuth func() smtp.Auth
 )
 
 ////Request Access email  start.....//////
@@ -45,12 +74,12 @@ func (state *RuntimeState) SendRequestemail(username string, groupnames []string
 	for _, entry := range groupnames {
 		managerEntry, err := state.Userinfo.GetDescriptionvalue(entry)
 		if err != nil {
-			log.Println(err)
+			slog.Error("SendRequestemail failed", "err", err)
 			return err
 		}
-		log.Printf("managerEntry:%s", managerEntry)
+		slog.Debug("sending the request mail", "manager", managerEntry)
 		if managerEntry == "" {
-			log.Printf("no manager for group %s.", entry)
+			slog.Warn("no manager for group", "group", entry)
 			return fmt.Errorf("no manager for group %s", entry)
 
 		}
@@ -60,7 +89,7 @@ func (state *RuntimeState) SendRequestemail(username string, groupnames []string
 		}
 		usersEmail, err = state.Userinfo.GetEmailofusersingroup(managerEntry)
 		if err != nil {
-			log.Printf("SendRequestemail: GetEmailofusersingroup err:%s", err)
+			slog.Error("GetEmailofusersingroup failed", "err", err)
 			return err
 
 		}
@@ -74,13 +103,15 @@ const requestAccessMailTemplateText = `Subject: Request access to group {{.Group
 User {{.RequestedUser}} requested access to group {{.Groupname}}.
 Please take a review at {{.Hostname}}/pending-actions`
 
+var requestAccessMailTemplate = texttemplate.Must(texttemplate.New("mailbody").Parse(requestAccessMailTemplateText))
+
 //send email for requesting access to a group
 func (state *RuntimeState) SuccessRequestemail(requesteduser string, usersEmail []string,
 	groupname, remoteAddr, userAgent string) error {
 	// Connect to the remote SMTP server.
 	c, err := smtpClient(state.Config.Base.SMTPserver)
 	if err != nil {
-		log.Println(err)
+		slog.Error("SuccessRequestemail failed", "err", err)
 		return err
 	}
 	defer c.Close()
@@ -93,7 +124,7 @@ func (state *RuntimeState) SuccessRequestemail(requesteduser string, usersEmail
 	// Send the email body.
 	wc, err := c.Data()
 	if err != nil {
-		log.Println(err)
+		slog.Error("SuccessRequestemail failed", "err", err)
 		return err
 	}
 	defer wc.Close()
@@ -109,11 +140,7 @@ func (state *RuntimeState) SuccessRequestemail(requesteduser string, usersEmail
 		OS:            ua.OS(),
 		OtherUser:     ""}
 
-	templ, err := texttemplate.New("mailbody").Parse(requestAccessMailTemplateText)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = templ.Execute(wc, mailData)
+	err = requestAccessMailTemplate.Execute(wc, mailData)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -128,12 +155,14 @@ func (state *RuntimeState) SuccessRequestemail(requesteduser string, usersEmail
 const requestApproveMailTemplateText = `Subject: Approve access to group {{.Groupname}}
 User {{.OtherUser}} approved user {{.RequestedUser}}'s access request to group {{.Groupname}}`
 
+var requestApproveMailTemplate = texttemplate.Must(texttemplate.New("mailbody").Parse(requestApproveMailTemplateText))
+
 //send approve email
 func (state *RuntimeState) sendApproveemail(username string,
 	userPair [][]string, remoteAddr string, userAgent string) error {
 	userEmail, err := state.Userinfo.GetEmailofauser(username)
 	if err != nil {
-		log.Println(err)
+		slog.Error("sendApproveemail failed", "err", err)
 		return err
 	}
 	for _, entry := range userPair {
@@ -142,18 +171,18 @@ func (state *RuntimeState) sendApproveemail(username string,
 		requesteduser := entry[0]
 		otheruserEmail, err := state.Userinfo.GetEmailofauser(requesteduser)
 		if err != nil {
-			log.Println(err)
+			slog.Error("sendApproveemail failed", "err", err)
 			return err
 		}
 		targetAddress = append(targetAddress, otheruserEmail[0])
 		err = state.approveRequestemail(requesteduser, username, targetAddress, entry[1], remoteAddr, userAgent)
 		if err != nil {
-			log.Println(err)
+			slog.Error("sendApproveemail failed", "err", err)
 			return err
 		}
 		managerGroupName, err := state.Userinfo.GetDescriptionvalue(entry[1])
 		if err != nil {
-			log.Println(err)
+			slog.Error("sendApproveemail failed", "err", err)
 			return err
 		}
 		if managerGroupName == "self-managed" {
@@ -161,12 +190,12 @@ func (state *RuntimeState) sendApproveemail(username string,
 		}
 		otherUsersMail, err := state.Userinfo.GetEmailofusersingroup(managerGroupName)
 		if err != nil {
-			log.Println(err)
+			slog.Error("sendApproveemail failed", "err", err)
 			return err
 		}
 		err = state.approveRequestemail(requesteduser, username, otherUsersMail, entry[1], remoteAddr, userAgent)
 		if err != nil {
-			log.Println(err)
+			slog.Error("sendApproveemail failed", "err", err)
 			return err
 		}
 		targetAddress = nil
@@ -180,7 +209,7 @@ func (state *RuntimeState) approveRequestemail(requesteduser string, otheruser s
 	// Connect to the remote SMTP server.
 	c, err := smtpClient(state.Config.Base.SMTPserver)
 	if err != nil {
-		log.Println(err)
+		slog.Error("approveRequestemail failed", "err", err)
 		return err
 	}
 	defer c.Close()
@@ -193,7 +222,7 @@ func (state *RuntimeState) approveRequestemail(requesteduser string, otheruser s
 	// Send the email body.
 	wc, err := c.Data()
 	if err != nil {
-		log.Println(err)
+		slog.Error("approveRequestemail failed", "err", err)
 		return err
 	}
 	defer wc.Close()
@@ -209,11 +238,7 @@ func (state *RuntimeState) approveRequestemail(requesteduser string, otheruser s
 		OtherUser:     otheruser,
 		Hostname:      state.Config.Base.Hostname}
 
-	templ, err := texttemplate.New("mailbody").Parse(requestApproveMailTemplateText)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = templ.Execute(wc, mailData)
+	err = requestApproveMailTemplate.Execute(wc, mailData)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -227,12 +252,14 @@ func (state *RuntimeState) approveRequestemail(requesteduser string, otheruser s
 const requestRejectMailTemplateText = `Subject: Rejected access to group {{.Groupname}}
 User {{.OtherUser}} rejected user {{.RequestedUser}}'s access request to group {{.Groupname}}`
 
+var requestRejectMailTemplate = texttemplate.Must(texttemplate.New("mailbody").Parse(requestRejectMailTemplateText))
+
 //send reject email
 func (state *RuntimeState) sendRejectemail(username string, userPair [][]string,
 	remoteAddr string, userAgent string) error {
 	userEmail, err := state.Userinfo.GetEmailofauser(username)
 	if err != nil {
-		log.Println(err)
+		slog.Error("sendRejectemail failed", "err", err)
 		return err
 	}
 	for _, entry := range userPair {
@@ -241,40 +268,40 @@ func (state *RuntimeState) sendRejectemail(username string, userPair [][]string,
 		requesteduser := entry[0]
 		otheruserEmail, err := state.Userinfo.GetEmailofauser(requesteduser)
 		if err != nil {
-			log.Println(err)
+			slog.Error("sendRejectemail failed", "err", err)
 			return err
 		}
 		targetAddress = append(targetAddress, otheruserEmail[0])
 		err = state.RejectRequestemail(requesteduser, username, targetAddress, entry[1], remoteAddr, userAgent)
 		if err != nil {
-			log.Println(err)
+			slog.Error("sendRejectemail failed", "err", err)
 			return err
 		}
 		description, err := state.Userinfo.GetDescriptionvalue(entry[1])
 		if err != nil {
-			log.Println(err)
+			slog.Error("sendRejectemail failed", "err", err)
 			return err
 		}
 		if description == "self-managed" {
 			other_users_email, err := state.Userinfo.GetEmailofusersingroup(entry[1])
 			if err != nil {
-				log.Println(err)
+				slog.Error("sendRejectemail failed", "err", err)
 				return err
 			}
 			err = state.RejectRequestemail(requesteduser, username, other_users_email, entry[1], remoteAddr, userAgent)
 			if err != nil {
-				log.Println(err)
+				slog.Error("sendRejectemail failed", "err", err)
 				return err
 			}
 		} else {
 			other_users_email, err := state.Userinfo.GetEmailofusersingroup(description)
 			if err != nil {
-				log.Println(err)
+				slog.Error("sendRejectemail failed", "err", err)
 				return err
 			}
 			err = state.RejectRequestemail(requesteduser, username, other_users_email, entry[1], remoteAddr, userAgent)
 			if err != nil {
-				log.Println(err)
+				slog.Error("sendRejectemail failed", "err", err)
 				return err
 			}
 		}
@@ -288,7 +315,7 @@ func (state *RuntimeState) RejectRequestemail(requesteduser string, otheruser st
 	// Connect to the remote SMTP server.
 	c, err := smtpClient(state.Config.Base.SMTPserver)
 	if err != nil {
-		log.Println(err)
+		slog.Error("RejectRequestemail failed", "err", err)
 		return err
 	}
 	defer c.Close()
@@ -301,7 +328,7 @@ func (state *RuntimeState) RejectRequestemail(requesteduser string, otheruser st
 	// Send the email body.
 	wc, err := c.Data()
 	if err != nil {
-		log.Println(err)
+		slog.Error("RejectRequestemail failed", "err", err)
 		return err
 	}
 	defer wc.Close()
@@ -317,11 +344,7 @@ func (state *RuntimeState) RejectRequestemail(requesteduser string, otheruser st
 		OtherUser:     otheruser,
 		Hostname:      state.Config.Base.Hostname}
 
-	templ, err := texttemplate.New("mailbody").Parse(requestRejectMailTemplateText)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = templ.Execute(wc, mailData)
+	err = requestRejectMailTemplate.Execute(wc, mailData)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -332,3 +355,101 @@ func (state *RuntimeState) RejectRequestemail(requesteduser string, otheruser st
 ///// reject email end/////
 
 /// Email function end////
+
+type emailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// sendEmailWithAttachments sends a plain text email with the given
+// attachments as a multipart/mixed message.
+func (state *RuntimeState) sendEmailWithAttachments(recipients []string, subject string,
+	body string, attachments []emailAttachment) error {
+	c, err := smtpClient(state.Config.Base.SMTPserver)
+	if err != nil {
+		slog.Error("sendEmailWithAttachments failed", "err", err)
+		return err
+	}
+	defer c.Close()
+	err = c.Mail(state.Config.Base.SmtpSenderAddress)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		err = c.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+	wc, err := c.Data()
+	if err != nil {
+		slog.Error("sendEmailWithAttachments failed", "err", err)
+		return err
+	}
+	defer wc.Close()
+	mpWriter := multipart.NewWriter(wc)
+	fmt.Fprintf(wc, "Subject: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		mime.QEncoding.Encode("utf-8", subject), mpWriter.Boundary())
+	part, err := mpWriter.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(part, body)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		part, err = mpWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+		})
+		if err != nil {
+			return err
+		}
+		encoder := base64.NewEncoder(base64.StdEncoding, &lineBreaker{w: part})
+		_, err = encoder.Write(attachment.Data)
+		if err != nil {
+			return err
+		}
+		err = encoder.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mpWriter.Close()
+}
+
+// lineBreaker splits base64 output in lines of 76 characters as required by
+// RFC 2045.
+type lineBreaker struct {
+	w       io.Writer
+	lineLen int
+}
+
+func (l *lineBreaker) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := 76 - l.lineLen
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		n, err := l.w.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		l.lineLen += chunk
+		p = p[chunk:]
+		if l.lineLen == 76 {
+			_, err = l.w.Write([]byte("\r\n"))
+			if err != nil {
+				return written, err
+			}
+			l.lineLen = 0
+		}
+	}
+	return written, nil
+}
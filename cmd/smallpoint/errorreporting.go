@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The panics of the handlers and their 5xx responses are reported to a
+// Sentry compatible collector, with the request, its ID, its user and the
+// start of the error body. The 503 responses, written in degraded mode and by
+// the readiness checks, are not reported. The cookies, the authorization,
+// CSRF token and signature headers and the values of the OAuth2 parameters
+// are left out.
+
+const (
+	errorReportingQueueSize   = 256
+	errorReportingTimeout     = 10 * time.Second
+	errorReportingBodyExcerpt = 1024
+)
+
+var errorReportingSensitiveHeaders = map[string]bool{"Authorization": true, "Cookie": true,
+	http.CanonicalHeaderKey(csrfHeader): true, http.CanonicalHeaderKey(webhookSignatureHeader): true}
+var errorReportingSensitiveParams = map[string]bool{"code": true, "state": true, "access_token": true}
+
+type errorReportingConfig struct {
+	// DSN is the Sentry DSN, https://<key>@<host>/<project>. Leaving it
+	// empty disables the error reporting.
+	DSN         string `yaml:"dsn"`
+	Environment string `yaml:"environment"`
+}
+
+type errorReportRequest struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type errorReportUser struct {
+	Username  string `json:"username,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+}
+
+// errorReport is an event of the Sentry store API.
+type errorReport struct {
+	EventID     string              `json:"event_id"`
+	Timestamp   string              `json:"timestamp"`
+	Platform    string              `json:"platform"`
+	Level       string              `json:"level"`
+	Logger      string              `json:"logger"`
+	ServerName  string              `json:"server_name,omitempty"`
+	Release     string              `json:"release,omitempty"`
+	Environment string              `json:"environment,omitempty"`
+	Message     string              `json:"message"`
+	Request     *errorReportRequest `json:"request,omitempty"`
+	User        *errorReportUser    `json:"user,omitempty"`
+	Tags        map[string]string   `json:"tags,omitempty"`
+	Extra       map[string]string   `json:"extra,omitempty"`
+}
+
+// errorReporter sends the reports from a single goroutine, a slow collector
+// never blocks the request handlers.
+type errorReporter struct {
+	storeURL    string
+	auth        string
+	environment string
+	serverName  string
+	client      *http.Client
+	reports     chan errorReport
+}
+
+func newErrorReporter(config errorReportingConfig, serverName string) (*errorReporter, error) {
+	dsn, err := url.Parse(config.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if dsn.User == nil || dsn.User.Username() == "" || dsn.Host == "" {
+		return nil, errors.New("invalid error reporting DSN, the key or the host is missing")
+	}
+	path := strings.TrimSuffix(dsn.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	projectID := path[slash+1:]
+	if projectID == "" {
+		return nil, errors.New("invalid error reporting DSN, the project is missing")
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=smallpoint/%s, sentry_key=%s",
+		Version, dsn.User.Username())
+	if secret, ok := dsn.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	reporter := &errorReporter{
+		storeURL:    fmt.Sprintf("%s://%s%s/api/%s/store/", dsn.Scheme, dsn.Host, path[:slash], projectID),
+		auth:        auth,
+		environment: config.Environment,
+		serverName:  serverName,
+		client:      &http.Client{Timeout: errorReportingTimeout},
+		reports:     make(chan errorReport, errorReportingQueueSize),
+	}
+	go reporter.loop()
+	return reporter, nil
+}
+
+// Report queues the report, if the queue is full it is dropped.
+func (reporter *errorReporter) Report(report errorReport) {
+	select {
+	case reporter.reports <- report:
+	default:
+		slog.Warn("error reporting queue full, dropping report", "message", report.Message)
+	}
+}
+
+func (reporter *errorReporter) loop() {
+	for report := range reporter.reports {
+		err := reporter.send(report)
+		if err != nil {
+			slog.Error("error reporting: cannot send report", "err", err)
+		}
+	}
+}
+
+func (reporter *errorReporter) send(report errorReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(postMethod, reporter.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", reporter.auth)
+	resp, err := reporter.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the collector returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func newErrorReportID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// newReport returns the report of the request with its context.
+func (reporter *errorReporter) newReport(r *http.Request, username string, level string,
+	message string) errorReport {
+	query := r.URL.Query()
+	for param := range query {
+		if errorReportingSensitiveParams[param] {
+			query.Set(param, "[Filtered]")
+		}
+	}
+	headers := make(map[string]string)
+	for name := range r.Header {
+		if !errorReportingSensitiveHeaders[name] {
+			headers[name] = r.Header.Get(name)
+		}
+	}
+	return errorReport{
+		EventID:     newErrorReportID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Platform:    "go",
+		Level:       level,
+		Logger:      "smallpoint",
+		ServerName:  reporter.serverName,
+		Release:     Version,
+		Environment: reporter.environment,
+		Message:     message,
+		Request: &errorReportRequest{
+			URL:         "https://" + r.Host + r.URL.Path,
+			Method:      r.Method,
+			QueryString: query.Encode(),
+			Headers:     headers,
+		},
+		User: &errorReportUser{Username: username, IPAddress: remoteIPFromRequest(r)},
+		Tags: map[string]string{"request_id": getRequestID(r), "path": r.URL.Path},
+	}
+}
+
+// errorRecordingWriter keeps the status and the start of the body of the
+// server errors.
+type errorRecordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (w *errorRecordingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorRecordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusInternalServerError && len(w.body) < errorReportingBodyExcerpt {
+		excerpt := b
+		if len(excerpt) > errorReportingBodyExcerpt-len(w.body) {
+			excerpt = excerpt[:errorReportingBodyExcerpt-len(w.body)]
+		}
+		w.body = append(w.body, excerpt...)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (state *RuntimeState) errorReportingHandler(handler http.Handler) http.Handler {
+	if state.errorReporter == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &errorRecordingWriter{ResponseWriter: w}
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				report := state.errorReporter.newReport(r, state.errorReportUsername(r), "fatal",
+					fmt.Sprintf("panic: %v", recovered))
+				report.Extra = map[string]string{"stack": string(debug.Stack())}
+				state.errorReporter.Report(report)
+				// the recovery handler logs the panic and renders the error page
+				panic(recovered)
+			}
+			if recorder.status < http.StatusInternalServerError ||
+				recorder.status == http.StatusServiceUnavailable {
+				return
+			}
+			message := fmt.Sprintf("%d %s", recorder.status, http.StatusText(recorder.status))
+			if body := strings.TrimSpace(string(recorder.body)); body != "" {
+				message += ": " + body
+			}
+			report := state.errorReporter.newReport(r, state.errorReportUsername(r), "error", message)
+			report.Tags["status"] = strconv.Itoa(recorder.status)
+			state.errorReporter.Report(report)
+		}()
+		handler.ServeHTTP(recorder, r)
+	})
+}
+
+func (state *RuntimeState) errorReportUsername(r *http.Request) string {
+	if state.authenticator == nil {
+		return ""
+	}
+	return state.authenticator.GetVerifiedUserName(r)
+}
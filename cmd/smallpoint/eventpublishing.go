@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/nats"
+)
+
+// Every audit event, the group changes, the access requests and their
+// approvals among them, is published as a JSON event to a Kafka topic, via
+// the Kafka REST proxy, and/or to NATS subjects. The audit log is the
+// outbox: a periodic job publishes the events after the last published one
+// of each sink, so that the events are published at least once and in
+// order, from the scheduler leader only. A new sink starts with the events
+// recorded after it is enabled.
+
+const (
+	defaultEventPublishingInterval = 10 * time.Second
+	defaultKafkaRESTTimeout        = 10 * time.Second
+	eventPublishingBatchSize       = 100
+	eventTypePrefix                = "smallpoint."
+	kafkaRESTContentType           = "application/vnd.kafka.json.v2+json"
+	kafkaRESTAcceptType            = "application/vnd.kafka.v2+json"
+	maxKafkaRESTResponseSize       = 1 << 20
+)
+
+type kafkaPublishingConfig struct {
+	// RESTProxyURL is the URL of the Kafka REST proxy (v2 API).
+	RESTProxyURL string `yaml:"rest_proxy_url"`
+	Topic        string `yaml:"topic"`
+	// Username and Password authenticate to the proxy when set.
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+type natsPublishingConfig struct {
+	nats.Config `yaml:",inline"`
+	// Subject prefixes the subjects of the events, which end with their
+	// action, e.g. smallpoint.events.add_member.
+	Subject string `yaml:"subject"`
+}
+
+type eventPublishingConfig struct {
+	Interval time.Duration         `yaml:"interval"`
+	Kafka    kafkaPublishingConfig `yaml:"kafka"`
+	NATS     natsPublishingConfig  `yaml:"nats"`
+}
+
+func (config eventPublishingConfig) interval() time.Duration {
+	if config.Interval <= 0 {
+		return defaultEventPublishingInterval
+	}
+	return config.Interval
+}
+
+func (config eventPublishingConfig) check() error {
+	if config.Kafka.RESTProxyURL != "" {
+		parsedURL, err := url.Parse(config.Kafka.RESTProxyURL)
+		if err != nil || (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") || parsedURL.Host == "" {
+			return fmt.Errorf("kafka.rest_proxy_url must be an http(s) URL")
+		}
+		if config.Kafka.Topic == "" {
+			return fmt.Errorf("kafka.topic is required")
+		}
+	}
+	if config.NATS.Address != "" {
+		if config.NATS.Subject == "" || strings.ContainsAny(config.NATS.Subject, " \t*>") ||
+			strings.HasSuffix(config.NATS.Subject, ".") {
+			return fmt.Errorf("nats.subject must be a subject without wildcards")
+		}
+	}
+	return nil
+}
+
+// publishedEvent is the message of an audit event.
+type publishedEvent struct {
+	// Type is the action prefixed with "smallpoint.".
+	Type string `json:"type"`
+	auditEvent
+}
+
+// eventPublisher publishes the events of a sink in order.
+type eventPublisher interface {
+	sink() string
+	publish(events []publishedEvent) error
+}
+
+// publishers returns the publishers of the configured sinks.
+func (config eventPublishingConfig) publishers() []eventPublisher {
+	var publishers []eventPublisher
+	if config.Kafka.RESTProxyURL != "" {
+		timeout := config.Kafka.Timeout
+		if timeout == 0 {
+			timeout = defaultKafkaRESTTimeout
+		}
+		publishers = append(publishers, &kafkaRESTPublisher{config: config.Kafka,
+			client: &http.Client{Timeout: timeout}})
+	}
+	if config.NATS.Address != "" {
+		publishers = append(publishers, &natsPublisher{subject: config.NATS.Subject,
+			client: nats.New(config.NATS.Config)})
+	}
+	return publishers
+}
+
+// kafkaRESTPublisher produces the events to the topic through the REST
+// proxy, keyed by group so that the events of a group stay in order.
+type kafkaRESTPublisher struct {
+	config kafkaPublishingConfig
+	client *http.Client
+}
+
+func (p *kafkaRESTPublisher) sink() string {
+	return "kafka:" + p.config.Topic
+}
+
+func (p *kafkaRESTPublisher) publish(events []publishedEvent) error {
+	type record struct {
+		Key   string         `json:"key"`
+		Value publishedEvent `json:"value"`
+	}
+	var request struct {
+		Records []record `json:"records"`
+	}
+	for _, event := range events {
+		request.Records = append(request.Records, record{Key: event.Groupname, Value: event})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(p.config.RESTProxyURL, "/")+"/topics/"+
+		url.PathEscape(p.config.Topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaRESTContentType)
+	req.Header.Set("Accept", kafkaRESTAcceptType)
+	if p.config.Username != "" {
+		req.SetBasicAuth(p.config.Username, p.config.Password)
+	}
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	metrics.MetricLogExternalServiceDuration("kafka", time.Since(start))
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKafkaRESTResponseSize))
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Message string `json:"message"`
+		Offsets []struct {
+			Error string `json:"error"`
+		} `json:"offsets"`
+	}
+	json.Unmarshal(content, &response)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("producing to %s failed with status %d: %s", p.config.Topic, resp.StatusCode,
+			response.Message)
+	}
+	for _, offset := range response.Offsets {
+		if offset.Error != "" {
+			return fmt.Errorf("producing to %s failed: %s", p.config.Topic, offset.Error)
+		}
+	}
+	return nil
+}
+
+// natsPublisher publishes each event on the subject of its action.
+type natsPublisher struct {
+	subject string
+	client  *nats.Client
+}
+
+func (p *natsPublisher) sink() string {
+	return "nats:" + p.subject
+}
+
+func (p *natsPublisher) publish(events []publishedEvent) error {
+	var messages []nats.Message
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, nats.Message{Subject: p.subject + "." + event.Action, Data: data})
+	}
+	start := time.Now()
+	err := p.client.Publish(messages...)
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("nats", time.Since(start))
+	return nil
+}
+
+var getEventPublishingCursorStmt = map[string]string{
+	"sqlite":   "select last_audit_id from event_publishing_cursors where sink=?;",
+	"postgres": "select last_audit_id from event_publishing_cursors where sink=$1;",
+}
+
+var insertEventPublishingCursorStmt = map[string]string{
+	"sqlite":   "insert into event_publishing_cursors(sink, last_audit_id) select ?, coalesce(max(id), 0) from audit_log;",
+	"postgres": "insert into event_publishing_cursors(sink, last_audit_id) select $1, coalesce(max(id), 0) from audit_log;",
+}
+
+var setEventPublishingCursorStmt = map[string]string{
+	"sqlite":   "update event_publishing_cursors set last_audit_id=? where sink=?;",
+	"postgres": "update event_publishing_cursors set last_audit_id=$1 where sink=$2;",
+}
+
+var getAuditEventsAfterStmt = map[string]string{
+	"sqlite":   "select id, time_stamp, actor, action, groupname, username, remote_addr, outcome, details from audit_log where id > ? order by id limit ?;",
+	"postgres": "select id, time_stamp, actor, action, groupname, username, remote_addr, outcome, details from audit_log where id > $1 order by id limit $2;",
+}
+
+// getEventPublishingCursor returns the id of the last published audit event
+// of the sink, the cursor of a new sink starts at the last recorded event.
+func getEventPublishingCursor(sink string, state *RuntimeState) (int64, error) {
+	ids, err := queryStringsFromDB(state, getEventPublishingCursorStmt[state.dbType], sink)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		err = execServiceAccountUpdate(state, insertEventPublishingCursorStmt[state.dbType], sink)
+		if err != nil {
+			return 0, err
+		}
+		ids, err = queryStringsFromDB(state, getEventPublishingCursorStmt[state.dbType], sink)
+		if err != nil {
+			return 0, err
+		}
+		if len(ids) == 0 {
+			return 0, fmt.Errorf("no cursor for the sink %s", sink)
+		}
+	}
+	var id int64
+	_, err = fmt.Sscan(ids[0], &id)
+	return id, err
+}
+
+func getAuditEventsAfterFromDB(id int64, state *RuntimeState) ([]auditEvent, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getAuditEventsAfterStmt[state.dbType], id, eventPublishingBatchSize)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var events []auditEvent
+	for rows.Next() {
+		var event auditEvent
+		var timeStamp int64
+		err = rows.Scan(&event.ID, &timeStamp, &event.Actor, &event.Action, &event.Groupname,
+			&event.Username, &event.RemoteAddr, &event.Outcome, &event.Details)
+		if err != nil {
+			return nil, err
+		}
+		event.Timestamp = time.Unix(timeStamp, 0)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// isDryRun tells the events of the dry runs, which are not published.
+func (event auditEvent) isDryRun() bool {
+	return event.Details == "dry run" || strings.HasPrefix(event.Details, "dry run, ")
+}
+
+// publishEvents publishes the events recorded since the last run to the
+// sink, in batches.
+func (state *RuntimeState) publishEvents(publisher eventPublisher) (int, error) {
+	sink := publisher.sink()
+	cursor, err := getEventPublishingCursor(sink, state)
+	if err != nil {
+		return 0, err
+	}
+	published := 0
+	for {
+		events, err := getAuditEventsAfterFromDB(cursor, state)
+		if err != nil || len(events) == 0 {
+			return published, err
+		}
+		var batch []publishedEvent
+		for _, event := range events {
+			if !event.isDryRun() {
+				batch = append(batch, publishedEvent{Type: eventTypePrefix + event.Action, auditEvent: event})
+			}
+		}
+		if len(batch) > 0 {
+			err = publisher.publish(batch)
+			if err != nil {
+				return published, err
+			}
+		}
+		cursor = events[len(events)-1].ID
+		err = execServiceAccountUpdate(state, setEventPublishingCursorStmt[state.dbType], cursor, sink)
+		if err != nil {
+			return published, err
+		}
+		published += len(batch)
+		if len(events) < eventPublishingBatchSize {
+			return published, nil
+		}
+	}
+}
+
+// runEventPublishing is the periodic job publishing to every sink, a
+// failing sink does not hold back the others.
+func (state *RuntimeState) runEventPublishing() error {
+	var failed int
+	for _, publisher := range state.eventPublishers {
+		published, err := state.publishEvents(publisher)
+		if published > 0 {
+			slog.Debug("events published", "sink", publisher.sink(), "count", published)
+		}
+		if err != nil {
+			failed++
+			slog.Error("event publishing failed", "sink", publisher.sink(), "err", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d event sinks failed", failed, len(state.eventPublishers))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/nats"
+	"github.com/Symantec/ldap-group-management/lib/nats/natstest"
+)
+
+func TestEventPublishing(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("delete from event_publishing_cursors;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	var records []string
+	failing := false
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Method != http.MethodPost || r.URL.Path != "/topics/smallpoint-events" ||
+			r.Header.Get("Content-Type") != kafkaRESTContentType {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40401,"message":"Topic not found."}`))
+			return
+		}
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error_code":50002,"message":"Kafka error."}`))
+			return
+		}
+		var request struct {
+			Records []struct {
+				Key   string         `json:"key"`
+				Value publishedEvent `json:"value"`
+			} `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		for _, record := range request.Records {
+			records = append(records, record.Key+" "+record.Value.Type+" "+record.Value.Username)
+		}
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+	}))
+	defer proxy.Close()
+	natsServer, err := natstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer natsServer.Close()
+	state.Config.EventPublishing = eventPublishingConfig{
+		Kafka: kafkaPublishingConfig{RESTProxyURL: proxy.URL, Topic: "smallpoint-events"},
+		NATS:  natsPublishingConfig{Config: nats.Config{Address: natsServer.Addr}, Subject: "smallpoint.events"},
+	}
+	state.eventPublishers = state.Config.EventPublishing.publishers()
+
+	// the events recorded before the sinks are enabled are not published
+	state.recordAuditEvent(nil, "user1", auditActionAddMember, "group1", "before", auditOutcomeSuccess, "")
+	err = state.runEventPublishing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 || len(natsServer.Messages()) != 0 {
+		t.Fatalf("published the older events %v", records)
+	}
+
+	state.recordAuditEvent(nil, "user2", auditActionRequestAccess, "group1", "user2", auditOutcomeSuccess, "")
+	state.recordAuditEvent(nil, "user1", auditActionApproveRequest, "group1", "user2", auditOutcomeSuccess,
+		"dry run")
+	state.recordAuditEvent(nil, "user1", auditActionApproveRequest, "group1", "user2", auditOutcomeSuccess, "")
+	mutex.Lock()
+	failing = true
+	mutex.Unlock()
+	err = state.runEventPublishing()
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Fatalf("the failing sink was not reported: %v", err)
+	}
+	messages := natsServer.Messages()
+	if len(messages) != 2 || messages[0].Subject != "smallpoint.events.request_access" ||
+		messages[1].Subject != "smallpoint.events.approve_request" {
+		t.Fatalf("NATS messages %v", messages)
+	}
+	var event publishedEvent
+	err = json.Unmarshal([]byte(messages[1].Data), &event)
+	if err != nil || event.Type != "smallpoint.approve_request" || event.Actor != "user1" || event.ID == 0 {
+		t.Fatalf("NATS event %+v, err %v", event, err)
+	}
+
+	// the failed events are published on the next run
+	mutex.Lock()
+	failing = false
+	mutex.Unlock()
+	err = state.runEventPublishing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "group1 smallpoint.request_access user2,group1 smallpoint.approve_request user2"
+	if strings.Join(records, ",") != expected {
+		t.Fatalf("Kafka records %q", records)
+	}
+	if len(natsServer.Messages()) != 2 {
+		t.Fatalf("NATS events published twice %v", natsServer.Messages())
+	}
+}
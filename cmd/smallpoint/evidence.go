@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// An evidence bundle collects everything an auditor needs to assess a single
+// membership change: the change itself, the request and justification that
+// led to it, who approved it and the tamper evidence of the audit entry.
+
+type evidenceRequest struct {
+	AuditID       int64     `json:"audit_id"`
+	Requester     string    `json:"requester"`
+	Timestamp     time.Time `json:"timestamp"`
+	Justification string    `json:"justification"`
+	RemoteAddr    string    `json:"remote_addr"`
+}
+
+type evidenceApproval struct {
+	Approver      string    `json:"approver"`
+	ApproverEmail []string  `json:"approver_email,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	// direct_change when a manager or admin changed the group without a
+	// request.
+	Type string `json:"type"`
+}
+
+type evidenceBundle struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	GeneratedBy string            `json:"generated_by"`
+	Change      auditEvent        `json:"change"`
+	LDAPChange  string            `json:"ldap_change"`
+	Request     *evidenceRequest  `json:"request,omitempty"`
+	Approval    *evidenceApproval `json:"approval,omitempty"`
+	ChainHash   string            `json:"chain_hash,omitempty"`
+	PrevHash    string            `json:"chain_prev_hash,omitempty"`
+	Signature   string            `json:"chain_signature,omitempty"`
+}
+
+// IsMembershipChange is used by the templates to offer evidence links.
+func (event auditEvent) IsMembershipChange() bool {
+	switch event.Action {
+	case auditActionAddMember, auditActionRemoveMember, auditActionExitGroup, auditActionApproveRequest:
+		return event.Outcome == auditOutcomeSuccess
+	}
+	return false
+}
+
+var getAuditEventByIDStmt = map[string]string{
+	"sqlite": "select a.id, a.time_stamp, a.actor, a.action, a.groupname, a.username, a.remote_addr, a.outcome, a.details, c.prev_hash, c.hash, c.signature " +
+		"from audit_log a left join audit_chain c on a.id = c.audit_id where a.id = ?;",
+	"postgres": "select a.id, a.time_stamp, a.actor, a.action, a.groupname, a.username, a.remote_addr, a.outcome, a.details, c.prev_hash, c.hash, c.signature " +
+		"from audit_log a left join audit_chain c on a.id = c.audit_id where a.id = $1;",
+}
+
+var getLastAccessRequestBeforeStmt = map[string]string{
+	"sqlite":   "select id, time_stamp, actor, remote_addr, details from audit_log where action=? and groupname=? and username=? and outcome=? and id < ? order by id desc limit 1;",
+	"postgres": "select id, time_stamp, actor, remote_addr, details from audit_log where action=$1 and groupname=$2 and username=$3 and outcome=$4 and id < $5 order by id desc limit 1;",
+}
+
+func (state *RuntimeState) buildEvidenceBundle(auditID int64, generatedBy string) (*evidenceBundle, error) {
+	bundle := evidenceBundle{GeneratedAt: time.Now(), GeneratedBy: generatedBy}
+	var timeStamp int64
+	var prevHash, chainHash, signature sql.NullString
+	event := &bundle.Change
+	err := state.db.QueryRow(getAuditEventByIDStmt[state.dbType], auditID).Scan(&event.ID, &timeStamp,
+		&event.Actor, &event.Action, &event.Groupname, &event.Username, &event.RemoteAddr,
+		&event.Outcome, &event.Details, &prevHash, &chainHash, &signature)
+	if err != nil {
+		return nil, err
+	}
+	event.Timestamp = time.Unix(timeStamp, 0)
+	bundle.PrevHash, bundle.ChainHash, bundle.Signature = prevHash.String, chainHash.String, signature.String
+	if !event.IsMembershipChange() {
+		return nil, fmt.Errorf("audit entry %d is not a membership change", auditID)
+	}
+	switch event.Action {
+	case auditActionAddMember, auditActionApproveRequest:
+		bundle.LDAPChange = fmt.Sprintf("added %s to memberUid and member of group %s", event.Username, event.Groupname)
+	default:
+		bundle.LDAPChange = fmt.Sprintf("removed %s from memberUid and member of group %s", event.Username, event.Groupname)
+	}
+
+	switch event.Action {
+	case auditActionApproveRequest:
+		var request evidenceRequest
+		var requestTimeStamp int64
+		err = state.db.QueryRow(getLastAccessRequestBeforeStmt[state.dbType], auditActionRequestAccess,
+			event.Groupname, event.Username, auditOutcomeSuccess, event.ID).Scan(&request.AuditID,
+			&requestTimeStamp, &request.Requester, &request.RemoteAddr, &request.Justification)
+		switch err {
+		case nil:
+			request.Timestamp = time.Unix(requestTimeStamp, 0)
+			bundle.Request = &request
+		case sql.ErrNoRows:
+			// the request predates the audit log or was pruned
+		default:
+			return nil, err
+		}
+		bundle.Approval = &evidenceApproval{Approver: event.Actor, Timestamp: event.Timestamp,
+			Type: "request_approval"}
+	case auditActionExitGroup:
+		bundle.Approval = &evidenceApproval{Approver: event.Actor, Timestamp: event.Timestamp,
+			Type: "self_removal"}
+	default:
+		bundle.Approval = &evidenceApproval{Approver: event.Actor, Timestamp: event.Timestamp,
+			Type: "direct_change"}
+	}
+	email, err := state.Userinfo.GetEmailofauser(bundle.Approval.Approver)
+	if err == nil {
+		bundle.Approval.ApproverEmail = email
+	} else {
+		slog.Error("cannot get the email of the approver", "username", bundle.Approval.Approver, "err", err)
+	}
+	return &bundle, nil
+}
+
+func (bundle *evidenceBundle) textLines() []string {
+	const timeFormat = time.RFC3339
+	event := bundle.Change
+	lines := []string{
+		fmt.Sprintf("Approval evidence for audit entry %d", event.ID),
+		fmt.Sprintf("Generated %s by %s", bundle.GeneratedAt.UTC().Format(timeFormat), bundle.GeneratedBy),
+		"",
+		"Change",
+		fmt.Sprintf("  Action:       %s", event.Action),
+		fmt.Sprintf("  Group:        %s", event.Groupname),
+		fmt.Sprintf("  User:         %s", event.Username),
+		fmt.Sprintf("  Performed by: %s from %s", event.Actor, event.RemoteAddr),
+		fmt.Sprintf("  Time:         %s", event.Timestamp.UTC().Format(timeFormat)),
+		fmt.Sprintf("  LDAP change:  %s", bundle.LDAPChange),
+		"",
+	}
+	if bundle.Request != nil {
+		lines = append(lines, "Request",
+			fmt.Sprintf("  Audit entry:   %d", bundle.Request.AuditID),
+			fmt.Sprintf("  Requested by:  %s from %s", bundle.Request.Requester, bundle.Request.RemoteAddr),
+			fmt.Sprintf("  Time:          %s", bundle.Request.Timestamp.UTC().Format(timeFormat)),
+			fmt.Sprintf("  Justification: %s", bundle.Request.Justification),
+			"")
+	} else if event.Action == auditActionApproveRequest {
+		lines = append(lines, "Request", "  No request found in the audit log", "")
+	}
+	if bundle.Approval != nil {
+		lines = append(lines, "Approval",
+			fmt.Sprintf("  Type:     %s", bundle.Approval.Type),
+			fmt.Sprintf("  Approver: %s %v", bundle.Approval.Approver, bundle.Approval.ApproverEmail),
+			fmt.Sprintf("  Time:     %s", bundle.Approval.Timestamp.UTC().Format(timeFormat)),
+			"")
+	}
+	lines = append(lines, "Audit chain",
+		fmt.Sprintf("  Hash:      %s", bundle.ChainHash),
+		fmt.Sprintf("  Previous:  %s", bundle.PrevHash),
+		fmt.Sprintf("  Signature: %s", bundle.Signature))
+	return lines
+}
+
+func (state *RuntimeState) auditEvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	isAuditor, err := state.isAuditor(username)
+	if err != nil {
+		requestLogger(r).Error("auditEvidenceHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !isAuditor {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	auditID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		state.writeFailureResponse(w, r, "invalid audit id", http.StatusBadRequest)
+		return
+	}
+	bundle, err := state.buildEvidenceBundle(auditID, username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			state.writeFailureResponse(w, r, "audit entry not found", http.StatusNotFound)
+			return
+		}
+		requestLogger(r).Error("auditEvidenceHandler failed", "err", err)
+		state.writeFailureResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filename := fmt.Sprintf("evidence_%d", auditID)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if r.URL.Query().Get("format") == "pdf" {
+		var buf bytes.Buffer
+		err = writeTextPDF(&buf, fmt.Sprintf("Approval evidence %d", auditID), bundle.textLines())
+		if err != nil {
+			requestLogger(r).Error("auditEvidenceHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".pdf"))
+		w.Write(buf.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(bundle)
+	if err != nil {
+		requestLogger(r).Error("failed to write the bundle", "err", err)
+	}
+}
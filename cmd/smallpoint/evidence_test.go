@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuditEvidenceBundle(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.recordAuditEvent(nil, "user3", auditActionRequestAccess, "evidence-group", "user3",
+		auditOutcomeSuccess, "need it for oncall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.recordAuditEvent(nil, "user1", auditActionApproveRequest, "evidence-group", "user3",
+		auditOutcomeSuccess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var approveID int64
+	err = state.db.QueryRow("select max(id) from audit_log where action=? and groupname=?;",
+		auditActionApproveRequest, "evidence-group").Scan(&approveID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidAdminCookie(state.authenticator)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s?id=%d", auditEvidencePath, approveID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.auditEvidenceHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var bundle evidenceBundle
+	err = json.Unmarshal(rr.Body.Bytes(), &bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Request == nil || bundle.Request.Justification != "need it for oncall" {
+		t.Fatalf("request missing from bundle %+v", bundle)
+	}
+	if bundle.Approval == nil || bundle.Approval.Approver != "user1" || bundle.ChainHash == "" {
+		t.Fatalf("approval missing from bundle %+v", bundle)
+	}
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s?id=%d&format=pdf", auditEvidencePath, approveID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(state.auditEvidenceHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !bytes.HasPrefix(rr.Body.Bytes(), []byte("%PDF-")) {
+		t.Fatalf("bad pdf evidence code=%d", rr.Code)
+	}
+
+	// requests are not membership changes
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s?id=%d", auditEvidencePath, approveID-1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(state.auditEvidenceHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// New groups get the lowest free gidNumber of the configured ranges. A
+// gidNumber is free when no group or service account uses it and it is not
+// reserved. Reservations live in the DB so that smallpoint instances sharing
+// the DB do not hand out the same gidNumber, they are released once the group
+// is created and expire in case the instance dies in between. Without ranges
+// the LDAP backend picks the next gidNumber.
+
+const gidReservationTimeout = 5 * time.Minute
+
+var errGidRangesExhausted = errors.New("no free gidNumber in the configured ranges")
+
+type gidRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+type gidAllocationConfig struct {
+	Ranges []gidRange `yaml:"ranges"`
+}
+
+// check returns an error when a range is empty or ranges overlap.
+func (config gidAllocationConfig) check() error {
+	for i, r := range config.Ranges {
+		if r.Min <= 0 || r.Min > r.Max {
+			return fmt.Errorf("invalid gidNumber range %d-%d", r.Min, r.Max)
+		}
+		for _, other := range config.Ranges[:i] {
+			if r.Min <= other.Max && other.Min <= r.Max {
+				return fmt.Errorf("gidNumber range %d-%d overlaps %d-%d", r.Min, r.Max, other.Min, other.Max)
+			}
+		}
+	}
+	return nil
+}
+
+var insertGidReservationStmt = map[string]string{
+	"sqlite":   "insert into gid_reservations(gid_number, groupname, reserved_by, expires_at) values (?,?,?,?);",
+	"postgres": "insert into gid_reservations(gid_number, groupname, reserved_by, expires_at) values ($1,$2,$3,$4);",
+}
+
+var deleteExpiredGidReservationsStmt = map[string]string{
+	"sqlite":   "delete from gid_reservations where expires_at < ?;",
+	"postgres": "delete from gid_reservations where expires_at < $1;",
+}
+
+var deleteGidReservationStmt = map[string]string{
+	"sqlite":   "delete from gid_reservations where gid_number=?;",
+	"postgres": "delete from gid_reservations where gid_number=$1;",
+}
+
+var getGidReservationsStmt = map[string]string{
+	"sqlite":   "select gid_number from gid_reservations where gid_number >= ? and gid_number <= ?;",
+	"postgres": "select gid_number from gid_reservations where gid_number >= $1 and gid_number <= $2;",
+}
+
+func getGidReservationsFromDB(state *RuntimeState, r gidRange) (map[int]bool, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getGidReservationsStmt[state.dbType], r.Min, r.Max)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	reserved := make(map[int]bool)
+	for rows.Next() {
+		var gid int
+		err = rows.Scan(&gid)
+		if err != nil {
+			return nil, err
+		}
+		reserved[gid] = true
+	}
+	return reserved, rows.Err()
+}
+
+// reserveGidNumber returns the reserved gidNumber, the reservation must be
+// released with releaseGidNumber.
+func (state *RuntimeState) reserveGidNumber(groupname string, username string, ranges []gidRange) (string, error) {
+	state.gidAllocationMutex.Lock()
+	defer state.gidAllocationMutex.Unlock()
+	err := execServiceAccountUpdate(state, deleteExpiredGidReservationsStmt[state.dbType], time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	for _, r := range ranges {
+		used, err := state.Userinfo.GetUsedGidNumbers(r.Min, r.Max)
+		if err != nil {
+			return "", err
+		}
+		reserved, err := getGidReservationsFromDB(state, r)
+		if err != nil {
+			return "", err
+		}
+		for gid := r.Min; gid <= r.Max; gid++ {
+			if used[gid] || reserved[gid] {
+				continue
+			}
+			// another instance may have reserved it in the meantime
+			err = execServiceAccountUpdate(state, insertGidReservationStmt[state.dbType], gid, groupname, username,
+				time.Now().Add(gidReservationTimeout).Unix())
+			if err != nil {
+				slog.Error("cannot reserve the gidNumber", "gid", gid, "err", err)
+				continue
+			}
+			return strconv.Itoa(gid), nil
+		}
+	}
+	return "", errGidRangesExhausted
+}
+
+func (state *RuntimeState) releaseGidNumber(gidNumber string) {
+	err := execServiceAccountUpdate(state, deleteGidReservationStmt[state.dbType], gidNumber)
+	if err != nil {
+		slog.Error("cannot release the gidNumber", "gid", gidNumber, "err", err)
+	}
+}
+
+// createLDAPGroup creates the group with a gidNumber of the ranges, the
+// configured ranges are used when none are given.
+func (state *RuntimeState) createLDAPGroup(r *http.Request, groupinfo userinfo.GroupInfo, username string,
+	ranges []gidRange) error {
+	if len(ranges) == 0 {
+		ranges = state.Config.GidAllocation.Ranges
+	}
+	if len(ranges) > 0 && groupinfo.GidNumber == "" {
+		gidNumber, err := state.reserveGidNumber(groupinfo.Groupname, username, ranges)
+		if err != nil {
+			return err
+		}
+		defer state.releaseGidNumber(gidNumber)
+		groupinfo.GidNumber = gidNumber
+	}
+	return state.requestUserinfo(r).CreateGroup(groupinfo)
+}
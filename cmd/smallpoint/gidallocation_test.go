@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestGidAllocationConfigCheck(t *testing.T) {
+	valid := gidAllocationConfig{Ranges: []gidRange{{Min: 100, Max: 199}, {Min: 300, Max: 300}}}
+	if err := valid.check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, ranges := range [][]gidRange{{{Min: 0, Max: 10}}, {{Min: 20, Max: 10}},
+		{{Min: 100, Max: 199}, {Min: 150, Max: 250}}} {
+		if err := (gidAllocationConfig{Ranges: ranges}).check(); err == nil {
+			t.Errorf("ranges %v should be invalid", ranges)
+		}
+	}
+}
+
+func TestReserveGidNumber(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mock service account group1 uses 20010
+	ranges := []gidRange{{Min: 20009, Max: 20010}, {Min: 20011, Max: 20012}}
+	var reserved []string
+	for i := 0; i < 3; i++ {
+		gidNumber, err := state.reserveGidNumber("reserved", "user1", ranges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reserved = append(reserved, gidNumber)
+	}
+	if !reflect.DeepEqual(reserved, []string{"20009", "20011", "20012"}) {
+		t.Fatalf("bad reservations %v", reserved)
+	}
+	_, err = state.reserveGidNumber("reserved", "user1", ranges)
+	if err != errGidRangesExhausted {
+		t.Fatalf("ranges should be exhausted, got %v", err)
+	}
+	for _, gidNumber := range reserved {
+		state.releaseGidNumber(gidNumber)
+	}
+
+	state.Config.GidAllocation.Ranges = ranges
+	err = state.createLDAPGroup(nil, userinfo.GroupInfo{Groupname: "allocated", Description: descriptionAttribute,
+		MemberUid: []string{"user2"}}, "user1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := state.Userinfo.GetUsedGidNumbers(20009, 20012)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(used, map[int]bool{20009: true, 20010: true}) {
+		t.Fatalf("bad used gidNumbers %v", used)
+	}
+	gidNumber, err := state.reserveGidNumber("reserved", "user1", ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gidNumber != "20011" {
+		t.Fatalf("the reservation of the created group was not released, got %s", gidNumber)
+	}
+	state.releaseGidNumber(gidNumber)
+}
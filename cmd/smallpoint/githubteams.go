@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The admins map groups to teams of the GitHub organizations and the
+// members of each team are kept in sync with the members of its group, one
+// way: the members missing from the team are added and the other members of
+// the team are removed. The teams are synced by a periodic job and after
+// each membership change of their group.
+
+const (
+	defaultGitHubAPIURL         = "https://api.github.com"
+	defaultGitHubTeamSyncPeriod = time.Hour
+	defaultGitHubTimeout        = 30 * time.Second
+	githubPageSize              = 100
+	githubServiceName           = "github"
+	maxGitHubSyncErrorLength    = 512
+)
+
+var githubTeamSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type githubTeamSyncConfig struct {
+	// APIURL is set for GitHub Enterprise Server, e.g.
+	// https://github.example.com/api/v3.
+	APIURL string `yaml:"api_url"`
+	// TokenFilename holds a token allowed to manage the teams, the sync is
+	// disabled without it.
+	TokenFilename string `yaml:"token_filename"`
+	// Organizations are the organizations the teams can be picked from.
+	Organizations []string `yaml:"organizations"`
+	// LoginSuffix is appended to the usernames to get the GitHub logins,
+	// e.g. "_acme" for enterprise managed users.
+	LoginSuffix string        `yaml:"login_suffix"`
+	Interval    time.Duration `yaml:"interval"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+func (config githubTeamSyncConfig) enabled() bool {
+	return config.TokenFilename != ""
+}
+
+func (config githubTeamSyncConfig) interval() time.Duration {
+	if config.Interval <= 0 {
+		return defaultGitHubTeamSyncPeriod
+	}
+	return config.Interval
+}
+
+func (config githubTeamSyncConfig) check() error {
+	if !config.enabled() {
+		return nil
+	}
+	if len(config.Organizations) == 0 {
+		return fmt.Errorf("organizations are required")
+	}
+	if config.APIURL != "" {
+		parsedURL, err := url.Parse(config.APIURL)
+		if err != nil || parsedURL.Scheme != "https" || parsedURL.Host == "" {
+			return fmt.Errorf("api_url must be an https URL")
+		}
+	}
+	return nil
+}
+
+func (config githubTeamSyncConfig) isOrganization(organization string) bool {
+	for _, value := range config.Organizations {
+		if strings.EqualFold(value, organization) {
+			return true
+		}
+	}
+	return false
+}
+
+type githubTeamMapping struct {
+	Groupname     string
+	Organization  string
+	Team          string
+	CreatedBy     string
+	CreatedAt     time.Time
+	LastSync      time.Time
+	LastSyncError string
+}
+
+var getGitHubTeamMappingsStmt = map[string]string{
+	"sqlite":   "select groupname, organization, team, created_by, created_at, last_sync, last_sync_error from github_team_mappings order by groupname, organization, team;",
+	"postgres": "select groupname, organization, team, created_by, created_at, last_sync, last_sync_error from github_team_mappings order by groupname, organization, team;",
+}
+
+var getGroupGitHubTeamMappingsStmt = map[string]string{
+	"sqlite":   "select groupname, organization, team, created_by, created_at, last_sync, last_sync_error from github_team_mappings where groupname=? order by organization, team;",
+	"postgres": "select groupname, organization, team, created_by, created_at, last_sync, last_sync_error from github_team_mappings where groupname=$1 order by organization, team;",
+}
+
+var insertGitHubTeamMappingStmt = map[string]string{
+	"sqlite":   "insert into github_team_mappings(groupname, organization, team, created_by, created_at, last_sync, last_sync_error) values (?,?,?,?,?,0,'');",
+	"postgres": "insert into github_team_mappings(groupname, organization, team, created_by, created_at, last_sync, last_sync_error) values ($1,$2,$3,$4,$5,0,'');",
+}
+
+var deleteGitHubTeamMappingStmt = map[string]string{
+	"sqlite":   "delete from github_team_mappings where groupname=? and organization=? and team=?;",
+	"postgres": "delete from github_team_mappings where groupname=$1 and organization=$2 and team=$3;",
+}
+
+var setGitHubTeamSyncStatusStmt = map[string]string{
+	"sqlite":   "update github_team_mappings set last_sync=?, last_sync_error=? where groupname=? and organization=? and team=?;",
+	"postgres": "update github_team_mappings set last_sync=$1, last_sync_error=$2 where groupname=$3 and organization=$4 and team=$5;",
+}
+
+func queryGitHubTeamMappings(state *RuntimeState, stmtText string, args ...interface{}) ([]githubTeamMapping,
+	error) {
+	start := time.Now()
+	rows, err := state.db.Query(stmtText, args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var mappings []githubTeamMapping
+	for rows.Next() {
+		var mapping githubTeamMapping
+		var createdAt, lastSync int64
+		err = rows.Scan(&mapping.Groupname, &mapping.Organization, &mapping.Team, &mapping.CreatedBy, &createdAt,
+			&lastSync, &mapping.LastSyncError)
+		if err != nil {
+			return nil, err
+		}
+		mapping.CreatedAt = time.Unix(createdAt, 0)
+		mapping.LastSync = time.Unix(lastSync, 0)
+		mappings = append(mappings, mapping)
+	}
+	return mappings, rows.Err()
+}
+
+// githubClient calls the REST API of GitHub.
+type githubClient struct {
+	apiURL string
+	token  string
+	client *http.Client
+}
+
+func newGitHubClient(config githubTeamSyncConfig) (*githubClient, error) {
+	token, err := ioutil.ReadFile(config.TokenFilename)
+	if err != nil {
+		return nil, err
+	}
+	apiURL := config.APIURL
+	if apiURL == "" {
+		apiURL = defaultGitHubAPIURL
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultGitHubTimeout
+	}
+	return &githubClient{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (c *githubClient) do(method string, path string, body interface{}, response interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, c.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	metrics.MetricLogExternalServiceDuration(githubServiceName, time.Since(start))
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var githubErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(content, &githubErr) == nil && githubErr.Message != "" {
+			return fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, githubErr.Message)
+		}
+		return fmt.Errorf("%s %s failed with status %d", method, path, resp.StatusCode)
+	}
+	if response == nil || len(content) == 0 {
+		return nil
+	}
+	return json.Unmarshal(content, response)
+}
+
+func githubTeamPath(organization string, team string) string {
+	return "/orgs/" + url.PathEscape(organization) + "/teams/" + url.PathEscape(team)
+}
+
+// teamMembers returns the lower case logins of the members and the
+// maintainers of the team.
+func (c *githubClient) teamMembers(organization string, team string) (map[string]bool, error) {
+	members := make(map[string]bool)
+	for page := 1; ; page++ {
+		var users []struct {
+			Login string `json:"login"`
+		}
+		err := c.do(getMethod, fmt.Sprintf("%s/members?per_page=%d&page=%d", githubTeamPath(organization, team),
+			githubPageSize, page), nil, &users)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			members[strings.ToLower(user.Login)] = true
+		}
+		if len(users) < githubPageSize {
+			return members, nil
+		}
+	}
+}
+
+func (c *githubClient) teamExists(organization string, team string) error {
+	return c.do(getMethod, githubTeamPath(organization, team), nil, nil)
+}
+
+func (c *githubClient) addTeamMember(organization string, team string, login string) error {
+	return c.do(http.MethodPut, githubTeamPath(organization, team)+"/memberships/"+url.PathEscape(login),
+		map[string]string{"role": "member"}, nil)
+}
+
+func (c *githubClient) removeTeamMember(organization string, team string, login string) error {
+	return c.do(http.MethodDelete, githubTeamPath(organization, team)+"/memberships/"+url.PathEscape(login),
+		nil, nil)
+}
+
+// syncGitHubTeam changes the members of the team to the members of the
+// group. The failed changes do not stop the others, the first error is
+// returned.
+func (state *RuntimeState) syncGitHubTeam(client *githubClient, mapping githubTeamMapping) (int, int, error) {
+	members, _, err := state.Userinfo.GetusersofaGroup(mapping.Groupname)
+	if err != nil {
+		return 0, 0, err
+	}
+	logins := make(map[string]bool)
+	for _, member := range members {
+		logins[strings.ToLower(member+state.Config.GitHubTeamSync.LoginSuffix)] = true
+	}
+	teamMembers, err := client.teamMembers(mapping.Organization, mapping.Team)
+	if err != nil {
+		return 0, 0, err
+	}
+	var toAdd, toRemove []string
+	for login := range logins {
+		if !teamMembers[login] {
+			toAdd = append(toAdd, login)
+		}
+	}
+	for login := range teamMembers {
+		if !logins[login] {
+			toRemove = append(toRemove, login)
+		}
+	}
+	sort.Strings(toAdd)
+	sort.Strings(toRemove)
+	var added, removed, failed int
+	var firstErr error
+	for _, login := range toAdd {
+		err = client.addTeamMember(mapping.Organization, mapping.Team, login)
+		if err == nil {
+			added++
+			continue
+		}
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, login := range toRemove {
+		err = client.removeTeamMember(mapping.Organization, mapping.Team, login)
+		if err == nil {
+			removed++
+			continue
+		}
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return added, removed, fmt.Errorf("%d changes failed, the first one: %s", failed, firstErr)
+	}
+	return added, removed, nil
+}
+
+// syncGitHubTeams syncs the teams and records the outcome of each one.
+func (state *RuntimeState) syncGitHubTeams(mappings []githubTeamMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	state.githubTeamSyncMutex.Lock()
+	defer state.githubTeamSyncMutex.Unlock()
+	client, err := newGitHubClient(state.Config.GitHubTeamSync)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, mapping := range mappings {
+		added, removed, err := state.syncGitHubTeam(client, mapping)
+		// the team of a group deleted behind our back is left alone
+		if err == userinfo.GroupDoesNotExist {
+			slog.Warn("skipping the GitHub team of a group that no longer exists", "group", mapping.Groupname,
+				"team", mapping.Organization+"/"+mapping.Team)
+			continue
+		}
+		message := ""
+		if err != nil {
+			failed++
+			message = err.Error()
+			if len(message) > maxGitHubSyncErrorLength {
+				message = message[:maxGitHubSyncErrorLength]
+			}
+			slog.Error("GitHub team sync failed", "group", mapping.Groupname,
+				"team", mapping.Organization+"/"+mapping.Team, "err", err)
+		}
+		if added > 0 || removed > 0 {
+			slog.Info("GitHub team synced", "group", mapping.Groupname,
+				"team", mapping.Organization+"/"+mapping.Team, "added", added, "removed", removed)
+		}
+		err = execServiceAccountUpdate(state, setGitHubTeamSyncStatusStmt[state.dbType], time.Now().Unix(), message,
+			mapping.Groupname, mapping.Organization, mapping.Team)
+		if err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d GitHub teams failed to sync", failed, len(mappings))
+	}
+	return nil
+}
+
+// runGitHubTeamSyncs is the periodic job syncing every mapped team.
+func (state *RuntimeState) runGitHubTeamSyncs() error {
+	mappings, err := queryGitHubTeamMappings(state, getGitHubTeamMappingsStmt[state.dbType])
+	if err != nil {
+		return err
+	}
+	return state.syncGitHubTeams(mappings)
+}
+
+// syncGitHubTeamsOfGroup syncs the teams of the group after a membership
+// change.
+func (state *RuntimeState) syncGitHubTeamsOfGroup(groupname string) {
+	mappings, err := queryGitHubTeamMappings(state, getGroupGitHubTeamMappingsStmt[state.dbType], groupname)
+	if err == nil {
+		err = state.syncGitHubTeams(mappings)
+	}
+	if err != nil {
+		slog.Error("cannot sync the GitHub teams of the group", "group", groupname, "err", err)
+	}
+}
+
+// addGitHubTeamMapping returns the message for the user when the mapping
+// is invalid.
+func (state *RuntimeState) addGitHubTeamMapping(r *http.Request, mapping githubTeamMapping) (string, error) {
+	if !state.Config.GitHubTeamSync.isOrganization(mapping.Organization) {
+		return fmt.Sprintf("organization %s is not configured", mapping.Organization), nil
+	}
+	if !githubTeamSlugPattern.MatchString(mapping.Team) {
+		return "the team must be given by its slug, e.g. site-reliability", nil
+	}
+	exists, _, err := state.requestUserinfo(r).GroupnameExistsornot(mapping.Groupname)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "group " + mapping.Groupname + " does not exist", nil
+	}
+	client, err := newGitHubClient(state.Config.GitHubTeamSync)
+	if err != nil {
+		return "", err
+	}
+	err = client.teamExists(mapping.Organization, mapping.Team)
+	if err != nil {
+		return fmt.Sprintf("cannot find the team %s/%s: %s", mapping.Organization, mapping.Team, err), nil
+	}
+	mappings, err := queryGitHubTeamMappings(state, getGroupGitHubTeamMappingsStmt[state.dbType],
+		mapping.Groupname)
+	if err != nil {
+		return "", err
+	}
+	for _, existing := range mappings {
+		if existing.Organization == mapping.Organization && existing.Team == mapping.Team {
+			return "the group is already mapped to this team", nil
+		}
+	}
+	return "", execServiceAccountUpdate(state, insertGitHubTeamMappingStmt[state.dbType], mapping.Groupname,
+		mapping.Organization, mapping.Team, mapping.CreatedBy, mapping.CreatedAt.Unix())
+}
+
+// githubTeamsHandler lists the mappings of the groups to the GitHub teams,
+// the admins add, delete and sync them.
+func (state *RuntimeState) githubTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	message := ""
+	switch r.Method {
+	case getMethod:
+	case postMethod:
+		if !state.Config.GitHubTeamSync.enabled() {
+			state.writeFailureResponse(w, r, "GitHub team sync is not configured", http.StatusBadRequest)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			requestLogger(r).Error("githubTeamsHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		mapping := githubTeamMapping{
+			Groupname:    r.PostFormValue("groupname"),
+			Organization: r.PostFormValue("organization"),
+			Team:         strings.ToLower(strings.TrimSpace(r.PostFormValue("team"))),
+			CreatedBy:    username,
+			CreatedAt:    time.Now(),
+		}
+		team := mapping.Organization + "/" + mapping.Team
+		switch r.PostFormValue("action") {
+		case "add":
+			failure, err := state.addGitHubTeamMapping(r, mapping)
+			if err != nil {
+				requestLogger(r).Error("githubTeamsHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			if failure != "" {
+				state.writeFailureResponse(w, r, failure, http.StatusBadRequest)
+				return
+			}
+			state.recordAuditEvent(r, username, auditActionUpdateGitHubTeamMapping, mapping.Groupname, "",
+				auditOutcomeSuccess, "mapped to "+team)
+			message = fmt.Sprintf("Group %s is mapped to %s, its members are synced", mapping.Groupname, team)
+			go state.syncGitHubTeamsOfGroup(mapping.Groupname)
+		case "delete":
+			err = execServiceAccountUpdate(state, deleteGitHubTeamMappingStmt[state.dbType], mapping.Groupname,
+				mapping.Organization, mapping.Team)
+			if err != nil {
+				requestLogger(r).Error("githubTeamsHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			state.recordAuditEvent(r, username, auditActionUpdateGitHubTeamMapping, mapping.Groupname, "",
+				auditOutcomeSuccess, "unmapped from "+team)
+			message = fmt.Sprintf("Group %s is no longer mapped to %s, the team members are left alone",
+				mapping.Groupname, team)
+		case "sync":
+			go state.syncGitHubTeamsOfGroup(mapping.Groupname)
+			message = fmt.Sprintf("The teams of group %s are being synced", mapping.Groupname)
+		default:
+			state.writeFailureResponse(w, r, "action must be add, delete or sync", http.StatusBadRequest)
+			return
+		}
+	default:
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	mappings, err := queryGitHubTeamMappings(state, getGitHubTeamMappingsStmt[state.dbType])
+	if err != nil {
+		requestLogger(r).Error("githubTeamsHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	pageData := githubTeamsPageData{
+		UserName:      username,
+		IsAdmin:       true,
+		Title:         "GitHub Teams",
+		Enabled:       state.Config.GitHubTeamSync.enabled(),
+		Organizations: state.Config.GitHubTeamSync.Organizations,
+		Mappings:      mappings,
+		Message:       message,
+	}
+	state.renderTemplateOrReturnJson(w, r, "githubTeamsPage", pageData)
+}
+
+// githubTeamMembershipChanged syncs the teams of the group after its
+// membership changed.
+func (state *RuntimeState) githubTeamMembershipChanged(groupname string) {
+	if state.Config.GitHubTeamSync.enabled() {
+		state.syncGitHubTeamsOfGroup(groupname)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testGitHubServer serves the team endpoints of an organization with a
+// single team.
+type testGitHubServer struct {
+	mutex   sync.Mutex
+	members map[string]bool
+}
+
+func (s *testGitHubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r.Header.Get("Authorization") != "Bearer github-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	const teamPath = "/orgs/acme/teams/sre"
+	switch {
+	case r.Method == getMethod && r.URL.Path == teamPath:
+		w.Write([]byte(`{"slug":"sre"}`))
+	case r.Method == getMethod && r.URL.Path == teamPath+"/members":
+		var users []map[string]string
+		if r.URL.Query().Get("page") == "1" {
+			for login := range s.members {
+				users = append(users, map[string]string{"login": login})
+			}
+		}
+		json.NewEncoder(w).Encode(users)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, teamPath+"/memberships/"):
+		s.members[strings.TrimPrefix(r.URL.Path, teamPath+"/memberships/")] = true
+		w.Write([]byte(`{"state":"active"}`))
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, teamPath+"/memberships/"):
+		delete(s.members, strings.TrimPrefix(r.URL.Path, teamPath+"/memberships/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"Not Found"}`))
+	}
+}
+
+func (s *testGitHubServer) logins() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var logins []string
+	for login := range s.members {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	return logins
+}
+
+func TestGitHubTeams(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("delete from github_team_mappings;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	github := &testGitHubServer{members: map[string]bool{"user1_acme": true, "former_acme": true}}
+	server := httptest.NewServer(github)
+	defer server.Close()
+	tokenFilename := filepath.Join(t.TempDir(), "token")
+	err = ioutil.WriteFile(tokenFilename, []byte("github-token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.GitHubTeamSync = githubTeamSyncConfig{APIURL: server.URL, TokenFilename: tokenFilename,
+		Organizations: []string{"acme"}, LoginSuffix: "_acme"}
+
+	mapping := url.Values{"action": {"add"}, "groupname": {"group1"}, "organization": {"acme"}, "team": {"sre"}}
+	code := testPostServiceAccountForm(t, &state, githubTeamsPath, state.githubTeamsHandler, false, mapping)
+	if code != http.StatusForbidden {
+		t.Fatalf("only admins map teams, got %d", code)
+	}
+	for field, value := range map[string]string{"groupname": "nogroup", "organization": "other",
+		"team": "missing", "action": "rename"} {
+		invalid := url.Values{}
+		for k, v := range mapping {
+			invalid[k] = v
+		}
+		invalid.Set(field, value)
+		code = testPostServiceAccountForm(t, &state, githubTeamsPath, state.githubTeamsHandler, true, invalid)
+		if code != http.StatusBadRequest {
+			t.Errorf("invalid %s should fail, got %d", field, code)
+		}
+	}
+	code = testPostServiceAccountForm(t, &state, githubTeamsPath, state.githubTeamsHandler, true, mapping)
+	if code != http.StatusOK {
+		t.Fatalf("cannot map the team, got %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, githubTeamsPath, state.githubTeamsHandler, true, mapping)
+	if code != http.StatusBadRequest {
+		t.Fatalf("mapping the team twice should fail, got %d", code)
+	}
+
+	// the mapping of a deleted group is skipped
+	_, err = state.db.Exec(`insert into github_team_mappings values ('deleted-group', 'acme', 'sre', 'user1', 0, 0, '');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.runGitHubTeamSyncs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec(`delete from github_team_mappings where groupname='deleted-group';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins := github.logins(); !reflect.DeepEqual(logins, []string{"user1_acme", "user2_acme"}) {
+		t.Fatalf("team members %v", logins)
+	}
+	mappings, err := queryGitHubTeamMappings(&state, getGitHubTeamMappingsStmt[state.dbType])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 1 || mappings[0].LastSync.Unix() == 0 || mappings[0].LastSyncError != "" {
+		t.Fatalf("mappings %+v", mappings)
+	}
+
+	mapping.Set("action", "delete")
+	code = testPostServiceAccountForm(t, &state, githubTeamsPath, state.githubTeamsHandler, true, mapping)
+	if code != http.StatusOK {
+		t.Fatalf("cannot delete the mapping, got %d", code)
+	}
+	mappings, err = queryGitHubTeamMappings(&state, getGitHubTeamMappingsStmt[state.dbType])
+	if err != nil || len(mappings) != 0 {
+		t.Fatalf("mappings %+v, err %v", mappings, err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The groups API is declarative, for the Terraform providers and GitOps
+// reconcilers: a GET returns the managing group and the members of a
+// group, a PUT gives the desired ones and the server applies the
+// difference and returns it. Repeating a PUT changes nothing, and with
+// the Smallpoint-Dry-Run header the difference is returned without being
+// applied. A PUT without members leaves them alone, an empty list removes
+// them all. A PUT on a missing group creates it.
+
+const (
+	maxGroupAPIRequestSize = 1 << 20
+	groupAPISource         = "groups API"
+)
+
+type groupAPIResponse struct {
+	Group   groupSpec     `json:"group"`
+	Changes *groupChanges `json:"changes,omitempty"`
+	DryRun  bool          `json:"dry_run,omitempty"`
+	// Held tells that the changes escalate privileges and wait for the
+	// approval of another admin, none of them were applied.
+	Held bool `json:"held,omitempty"`
+}
+
+func writeGroupAPIResponse(w http.ResponseWriter, r *http.Request, status int, response groupAPIResponse) {
+	b, err := json.Marshal(response)
+	if err != nil {
+		requestLogger(r).Error("Failed marshal", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	if err != nil {
+		requestLogger(r).Error("Incomplete write", "err", err)
+	}
+}
+
+// getGroupSpec returns the current state of the group, nil when it does
+// not exist or is archived.
+func (state *RuntimeState) getGroupSpec(r *http.Request, groupname string) (*groupSpec, error) {
+	exists, _, err := state.requestUserinfo(r).GroupnameExistsornot(groupname)
+	if err != nil || !exists {
+		return nil, err
+	}
+	archive, err := getGroupArchiveFromDB(groupname, state)
+	if err != nil || archive != nil {
+		return nil, err
+	}
+	managedBy, err := state.requestUserinfo(r).GetDescriptionvalue(groupname)
+	if err != nil {
+		return nil, err
+	}
+	members, _, err := state.requestUserinfo(r).GetusersofaGroup(groupname)
+	if err != nil {
+		return nil, err
+	}
+	spec := groupSpec{Name: groupname, ManagedBy: managedBy, Members: append([]string{}, members...)}
+	sort.Strings(spec.Members)
+	return &spec, nil
+}
+
+// putGroup applies the desired state of the request body to the group.
+func (state *RuntimeState) putGroup(w http.ResponseWriter, r *http.Request, username string,
+	groupname string) {
+	var spec groupSpec
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxGroupAPIRequestSize))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&spec)
+	if err != nil {
+		state.writeFailureResponse(w, r, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if spec.Name != "" && spec.Name != groupname {
+		state.writeFailureResponse(w, r, "the name does not match the URL", http.StatusBadRequest)
+		return
+	}
+	spec.Name = groupname
+	for _, member := range spec.Members {
+		if member == "" {
+			state.writeFailureResponse(w, r, "empty member", http.StatusBadRequest)
+			return
+		}
+	}
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
+	exists, _, err := state.requestUserinfo(r).GroupnameExistsornot(groupname)
+	if err != nil {
+		requestLogger(r).Error("putGroup failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		if !isAdmin {
+			http.Error(w, "you are not authorized", http.StatusForbidden)
+			return
+		}
+		if spec.ManagedBy == "" {
+			state.writeFailureResponse(w, r, "managed_by is required to create a group", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if spec.ManagedBy == "" {
+			spec.ManagedBy, err = state.requestUserinfo(r).GetDescriptionvalue(groupname)
+			if err != nil {
+				requestLogger(r).Error("putGroup failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+		}
+		// the group admins change the members, the ownership changes
+		// are for the super admins as on the web pages
+		allowed := isAdmin
+		if !allowed {
+			changes, err := state.diffGroupSpec(r, spec)
+			if err == nil && changes.ManagedBy == "" {
+				allowed, err = state.isGroupAdmin(username, groupname)
+			}
+			if err != nil {
+				requestLogger(r).Error("putGroup failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if !allowed {
+			http.Error(w, "you are not authorized", http.StatusForbidden)
+			return
+		}
+		changes, err := state.diffGroupSpec(r, spec)
+		if err != nil {
+			requestLogger(r).Error("putGroup failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		// removing members and changing the managing group are destructive
+		// as on the web pages
+		if len(changes.Removed) > 0 || changes.ManagedBy != "" {
+			if !state.requireRecentAuthentication(w, r) {
+				return
+			}
+		}
+		held, err := state.holdPrivilegeEscalation(r, username, groupname, changes.Added, changes.Removed)
+		if err != nil {
+			requestLogger(r).Error("putGroup failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		if held {
+			writeGroupAPIResponse(w, r, http.StatusAccepted, groupAPIResponse{Group: spec, Changes: &changes,
+				Held: true})
+			return
+		}
+	}
+	archived, err := state.getArchivedGroups()
+	if err != nil {
+		requestLogger(r).Error("putGroup failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	changes, message, err := state.applyGroupSpec(r, username, spec, archived, groupAPISource)
+	if err != nil {
+		requestLogger(r).Error("putGroup failed", "group", groupname, "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	status := http.StatusOK
+	if changes.Created {
+		status = http.StatusCreated
+	}
+	response := groupAPIResponse{Group: spec, Changes: &changes, DryRun: state.isDryRun(r)}
+	if !response.DryRun {
+		current, err := state.getGroupSpec(r, groupname)
+		if err != nil {
+			requestLogger(r).Error("putGroup failed", "group", groupname, "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		if current != nil {
+			response.Group = *current
+		}
+	}
+	writeGroupAPIResponse(w, r, status, response)
+}
+
+// groupsAPIHandler serves /api/v1/groups/<groupname>.
+func (state *RuntimeState) groupsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod && r.Method != http.MethodPut {
+		state.writeFailureResponse(w, r, "GET or PUT Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	groupname := strings.TrimPrefix(r.URL.Path, groupsAPIPath)
+	if groupname == "" || strings.Contains(groupname, "/") {
+		state.writeFailureResponse(w, r, "the URL must name a group", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPut {
+		state.putGroup(w, r, username, groupname)
+		return
+	}
+	spec, err := state.getGroupSpec(r, groupname)
+	if err != nil {
+		requestLogger(r).Error("groupsAPIHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if spec == nil {
+		state.writeFailureResponse(w, r, "group "+groupname+" does not exist", http.StatusNotFound)
+		return
+	}
+	writeGroupAPIResponse(w, r, http.StatusOK, groupAPIResponse{Group: *spec})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testGroupsAPIRequest(t *testing.T, state *RuntimeState, method string, groupname string, body string,
+	admin bool, dryRun bool) (int, groupAPIResponse) {
+	req, err := http.NewRequest(method, groupsAPIPath+groupname, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	if admin {
+		cookie = testCreateValidAdminCookie(state.authenticator)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	req.Header.Set("Accept", "application/json")
+	if dryRun {
+		req.Header.Set(dryRunHeader, "true")
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.groupsAPIHandler).ServeHTTP(rr, req)
+	var response groupAPIResponse
+	if rr.Code == http.StatusOK || rr.Code == http.StatusCreated {
+		err = json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr.Code, response
+}
+
+func TestGroupsAPI(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := testGroupsAPIRequest(t, &state, getMethod, "api_group", "", false, false)
+	if code != http.StatusNotFound {
+		t.Fatalf("missing group returned %d", code)
+	}
+	desired := `{"managed_by": "self-managed", "members": ["user1"]}`
+	code, _ = testGroupsAPIRequest(t, &state, http.MethodPut, "api_group", desired, false, false)
+	if code != http.StatusForbidden {
+		t.Fatalf("only admins create groups, got %d", code)
+	}
+	code, response := testGroupsAPIRequest(t, &state, http.MethodPut, "api_group", desired, true, false)
+	if code != http.StatusCreated || !response.Changes.Created ||
+		!reflect.DeepEqual(response.Group.Members, []string{"user1"}) {
+		t.Fatalf("create returned %d %+v", code, response)
+	}
+
+	desired = `{"members": ["user3", "user2", "user3"]}`
+	code, response = testGroupsAPIRequest(t, &state, http.MethodPut, "api_group", desired, true, false)
+	expected := groupChanges{Added: []string{"user2", "user3"}, Removed: []string{"user1"}}
+	if code != http.StatusOK || !reflect.DeepEqual(*response.Changes, expected) ||
+		!reflect.DeepEqual(response.Group.Members, []string{"user2", "user3"}) {
+		t.Fatalf("update returned %d %+v", code, response)
+	}
+	// the same desired state changes nothing
+	code, response = testGroupsAPIRequest(t, &state, http.MethodPut, "api_group", desired, true, false)
+	if code != http.StatusOK || response.Changes.changed() {
+		t.Fatalf("repeated update returned %d %+v", code, response)
+	}
+
+	// the dry run returns the changes only
+	code, response = testGroupsAPIRequest(t, &state, http.MethodPut, "api_group", `{"members": []}`, true, true)
+	expected = groupChanges{Added: []string{}, Removed: []string{"user2", "user3"}}
+	if code != http.StatusOK || !response.DryRun || !reflect.DeepEqual(*response.Changes, expected) {
+		t.Fatalf("dry run returned %d %+v", code, response)
+	}
+	code, response = testGroupsAPIRequest(t, &state, getMethod, "api_group", "", false, false)
+	if code != http.StatusOK || !reflect.DeepEqual(response.Group, groupSpec{Name: "api_group",
+		ManagedBy: "self-managed", Members: []string{"user2", "user3"}}) {
+		t.Fatalf("get returned %d %+v", code, response)
+	}
+
+	// the group admins change the members but not the managing group
+	code, _ = testGroupsAPIRequest(t, &state, http.MethodPut, "group1", `{"managed_by": "group2"}`, false, false)
+	if code != http.StatusForbidden {
+		t.Fatalf("group admin changed the managing group, got %d", code)
+	}
+	code, response = testGroupsAPIRequest(t, &state, http.MethodPut, "group1", `{"members": ["user1", "user2", "user3"]}`,
+		false, false)
+	if code != http.StatusOK || !reflect.DeepEqual(response.Changes.Added, []string{"user3"}) {
+		t.Fatalf("group admin update returned %d %+v", code, response)
+	}
+	for _, body := range []string{`{"members": ["nobody"]}`, `{"name": "other"}`, `{"owners": []}`} {
+		code, _ = testGroupsAPIRequest(t, &state, http.MethodPut, "group1", body, true, false)
+		if code != http.StatusBadRequest {
+			t.Errorf("invalid request %s returned %d", body, code)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Deleting a group archives it: its members are removed in LDAP and kept in
+// the DB, and the group is hidden from the group listings. Admins can restore
+// the group with its members until the retention period is over, then the
+// LDAP group is deleted. The LDAP entry stays in place while archived so its
+// name cannot be taken by a new group.
+
+const defaultGroupArchiveRetentionDays = 30
+
+type groupArchiveConfig struct {
+	RetentionDays int `yaml:"retention_days"`
+}
+
+func (config groupArchiveConfig) retentionPeriod() int {
+	if config.RetentionDays > 0 {
+		return config.RetentionDays
+	}
+	return defaultGroupArchiveRetentionDays
+}
+
+type groupArchive struct {
+	Groupname   string
+	Members     []string
+	ArchivedBy  string
+	ArchivedAt  time.Time
+	DeleteAfter time.Time
+}
+
+const groupArchiveColumns = "groupname, members, archived_by, archived_at, delete_after"
+
+var insertGroupArchiveStmt = map[string]string{
+	"sqlite":   "insert into group_archives(" + groupArchiveColumns + ") values (?,?,?,?,?);",
+	"postgres": "insert into group_archives(" + groupArchiveColumns + ") values ($1,$2,$3,$4,$5);",
+}
+
+var getGroupArchiveStmt = map[string]string{
+	"sqlite":   "select " + groupArchiveColumns + " from group_archives where groupname=?;",
+	"postgres": "select " + groupArchiveColumns + " from group_archives where groupname=$1;",
+}
+
+const getAllGroupArchivesStmt = "select " + groupArchiveColumns + " from group_archives order by delete_after;"
+
+var getDueGroupArchivesStmt = map[string]string{
+	"sqlite":   "select " + groupArchiveColumns + " from group_archives where delete_after < ? order by delete_after;",
+	"postgres": "select " + groupArchiveColumns + " from group_archives where delete_after < $1 order by delete_after;",
+}
+
+var deleteGroupArchiveStmt = map[string]string{
+	"sqlite":   "delete from group_archives where groupname=?;",
+	"postgres": "delete from group_archives where groupname=$1;",
+}
+
+// deleteArchivedGroupStmts drop the archive of a deleted group and the rows
+// describing the group, the addresses of its mailing list can be used again
+// and a new group of the same name inherits nothing.
+var deleteArchivedGroupStmts = map[string][]string{
+	"sqlite": {"delete from group_archives where groupname=?;",
+		"delete from mailing_list_addresses where groupname=?;",
+		"delete from group_tags where groupname=?;",
+		"delete from group_metadata where groupname=?;",
+		"delete from group_classifications where groupname=?;",
+		"delete from github_team_mappings where groupname=?;",
+		"delete from okta_group_pushes where groupname=?;",
+		"delete from aws_group_members where groupname=?;",
+		"delete from group_tickets where groupname=?;"},
+	"postgres": {"delete from group_archives where groupname=$1;",
+		"delete from mailing_list_addresses where groupname=$1;",
+		"delete from group_tags where groupname=$1;",
+		"delete from group_metadata where groupname=$1;",
+		"delete from group_classifications where groupname=$1;",
+		"delete from github_team_mappings where groupname=$1;",
+		"delete from okta_group_pushes where groupname=$1;",
+		"delete from aws_group_members where groupname=$1;",
+		"delete from group_tickets where groupname=$1;"},
+}
+
+func deleteArchivedGroupInDB(groupname string, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range deleteArchivedGroupStmts[state.dbType] {
+		_, err = tx.Exec(stmt, groupname)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func scanGroupArchive(row sqlRowScanner) (groupArchive, error) {
+	var archive groupArchive
+	var members string
+	var archivedAt, deleteAfter int64
+	err := row.Scan(&archive.Groupname, &members, &archive.ArchivedBy, &archivedAt, &deleteAfter)
+	archive.Members = strings.Fields(members)
+	archive.ArchivedAt = time.Unix(archivedAt, 0)
+	archive.DeleteAfter = time.Unix(deleteAfter, 0)
+	return archive, err
+}
+
+// getGroupArchiveFromDB returns nil when the group is not archived.
+func getGroupArchiveFromDB(groupname string, state *RuntimeState) (*groupArchive, error) {
+	start := time.Now()
+	archive, err := scanGroupArchive(state.db.QueryRow(getGroupArchiveStmt[state.dbType], groupname))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return &archive, nil
+}
+
+func queryGroupArchivesFromDB(state *RuntimeState, stmtText string, args ...interface{}) ([]groupArchive, error) {
+	start := time.Now()
+	rows, err := state.db.Query(stmtText, args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var archives []groupArchive
+	for rows.Next() {
+		archive, err := scanGroupArchive(rows)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+	return archives, rows.Err()
+}
+
+func (state *RuntimeState) getArchivedGroups() (map[string]bool, error) {
+	archives, err := queryGroupArchivesFromDB(state, getAllGroupArchivesStmt)
+	if err != nil {
+		return nil, err
+	}
+	archived := make(map[string]bool)
+	for _, archive := range archives {
+		archived[archive.Groupname] = true
+	}
+	return archived, nil
+}
+
+// listedGroupFilter returns whether a group shows in the group listings,
+// archived groups are hidden and only the tagged groups show when a tag is
+// given.
+func (state *RuntimeState) listedGroupFilter(tag string) (func(string) bool, error) {
+	archived, err := state.getArchivedGroups()
+	if err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		return func(groupname string) bool { return !archived[groupname] }, nil
+	}
+	tagged, err := state.getGroupsWithTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	return func(groupname string) bool { return tagged[groupname] && !archived[groupname] }, nil
+}
+
+// archiveGroup removes the members of the group and keeps them in the
+// archive, it returns when the group will be deleted. A dry run only logs
+// the removal of the members, the group is not archived and so never
+// deleted.
+func (state *RuntimeState) archiveGroup(r *http.Request, actor string, groupname string) (time.Time, error) {
+	now := time.Now()
+	deleteAfter := now.AddDate(0, 0, state.Config.GroupArchive.retentionPeriod())
+	dryRun := state.isDryRun(r)
+	members, _, err := state.requestUserinfo(r).GetusersofaGroup(groupname)
+	if err != nil {
+		return deleteAfter, err
+	}
+	if !dryRun {
+		err = execServiceAccountUpdate(state, insertGroupArchiveStmt[state.dbType], groupname,
+			strings.Join(members, " "), actor, now.Unix(), deleteAfter.Unix())
+		if err != nil {
+			state.recordAuditEvent(r, actor, auditActionArchiveGroup, groupname, "", auditOutcomeFailure, err.Error())
+			return deleteAfter, err
+		}
+	}
+	if len(members) > 0 {
+		err = state.requestUserinfo(r).DeletemembersfromGroup(userinfo.GroupInfo{Groupname: groupname, MemberUid: members})
+		if err != nil {
+			state.recordAuditEvent(r, actor, auditActionArchiveGroup, groupname, "", auditOutcomeFailure, err.Error())
+			if !dryRun {
+				rollbackErr := execServiceAccountUpdate(state, deleteGroupArchiveStmt[state.dbType], groupname)
+				if rollbackErr != nil {
+					requestLogger(r).Error("cannot remove the archive of the group", "group", groupname, "err", rollbackErr)
+				}
+			}
+			return deleteAfter, err
+		}
+		for _, member := range members {
+			state.recordAuditEvent(r, actor, auditActionRemoveMember, groupname, member, auditOutcomeSuccess,
+				"group archived")
+		}
+	}
+	// requests to join the group are void
+	if !dryRun {
+		err = expireRequestsOfGroupsInDB([]string{groupname}, actor, "the group was archived", state)
+		if err != nil {
+			return deleteAfter, err
+		}
+	}
+	state.recordAuditEvent(r, actor, auditActionArchiveGroup, groupname, "", auditOutcomeSuccess,
+		"delete after "+deleteAfter.Format(auditDateLayout))
+	if state.sysLog != nil {
+		state.sysLog.Write([]byte(fmt.Sprintf("Group %s was archived by %s", groupname, actor)))
+	}
+	return deleteAfter, nil
+}
+
+// restoreGroup adds the archived members that still exist back to the group.
+func (state *RuntimeState) restoreGroup(r *http.Request, actor string, archive groupArchive) error {
+	var members []string
+	for _, member := range archive.Members {
+		exists, err := state.requestUserinfo(r).UsernameExistsornot(member)
+		if err != nil {
+			return err
+		}
+		if exists {
+			members = append(members, member)
+		}
+	}
+	if len(members) > 0 {
+		err := state.requestUserinfo(r).AddmemberstoExisting(userinfo.GroupInfo{Groupname: archive.Groupname, MemberUid: members})
+		if err != nil {
+			state.recordAuditEvent(r, actor, auditActionRestoreGroup, archive.Groupname, "", auditOutcomeFailure,
+				err.Error())
+			return err
+		}
+		for _, member := range members {
+			state.recordAuditEvent(r, actor, auditActionAddMember, archive.Groupname, member, auditOutcomeSuccess,
+				"group restored")
+		}
+	}
+	if !state.isDryRun(r) {
+		err := execServiceAccountUpdate(state, deleteGroupArchiveStmt[state.dbType], archive.Groupname)
+		if err != nil {
+			return err
+		}
+	}
+	state.recordAuditEvent(r, actor, auditActionRestoreGroup, archive.Groupname, "", auditOutcomeSuccess,
+		fmt.Sprintf("archived by %s, restored %d of %d members", archive.ArchivedBy, len(members), len(archive.Members)))
+	if state.sysLog != nil {
+		state.sysLog.Write([]byte(fmt.Sprintf("Group %s was restored by %s", archive.Groupname, actor)))
+	}
+	return nil
+}
+
+// runGroupArchiveDeletions deletes the groups whose retention period is over.
+// The groups stay archived with -dry-run.
+func (state *RuntimeState) runGroupArchiveDeletions() error {
+	if state.dryRun {
+		return nil
+	}
+	archives, err := queryGroupArchivesFromDB(state, getDueGroupArchivesStmt[state.dbType], time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	for _, archive := range archives {
+		err = state.Userinfo.DeleteGroup([]string{archive.Groupname})
+		if err != nil && err != userinfo.GroupDoesNotExist {
+			slog.Error("cannot delete the archived group", "group", archive.Groupname, "err", err)
+			state.recordAuditEvent(nil, "smallpoint", auditActionDeleteGroup, archive.Groupname, "",
+				auditOutcomeFailure, err.Error())
+			continue
+		}
+		err = deleteArchivedGroupInDB(archive.Groupname, state)
+		if err != nil {
+			return err
+		}
+		state.recordAuditEvent(nil, "smallpoint", auditActionDeleteGroup, archive.Groupname, "",
+			auditOutcomeSuccess, "archived by "+archive.ArchivedBy)
+		if state.sysLog != nil {
+			state.sysLog.Write([]byte(fmt.Sprintf("Group %s was deleted, it was archived by %s",
+				archive.Groupname, archive.ArchivedBy)))
+		}
+	}
+	return nil
+}
+
+// groupArchiveHandler lists the archived groups, admins restore a group with
+// a POST.
+func (state *RuntimeState) groupArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case getMethod:
+	case postMethod:
+		err = r.ParseForm()
+		if err != nil {
+			requestLogger(r).Error("groupArchiveHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		if r.PostFormValue("action") != "restore" {
+			state.writeFailureResponse(w, r, "action must be restore", http.StatusBadRequest)
+			return
+		}
+		groupname := r.PostFormValue("groupname")
+		archive, err := getGroupArchiveFromDB(groupname, state)
+		if err != nil {
+			requestLogger(r).Error("groupArchiveHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		if archive == nil {
+			state.writeFailureResponse(w, r, "group "+groupname+" is not archived", http.StatusBadRequest)
+			return
+		}
+		err = state.restoreGroup(r, username, *archive)
+		if err != nil {
+			requestLogger(r).Error("groupArchiveHandler failed", "err", err)
+			state.writeFailureResponse(w, r, "cannot restore the group", http.StatusInternalServerError)
+			return
+		}
+		pageData := simpleMessagePageData{
+			UserName:       username,
+			IsAdmin:        true,
+			Title:          "Group Restored",
+			SuccessMessage: fmt.Sprintf("Group %s was restored", groupname),
+			ContinueURL:    groupinfoPath + "?groupname=" + groupname,
+		}
+		state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+		return
+	default:
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	archives, err := queryGroupArchivesFromDB(state, getAllGroupArchivesStmt)
+	if err != nil {
+		requestLogger(r).Error("groupArchiveHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	pageData := groupArchivePageData{
+		UserName: username,
+		IsAdmin:  true,
+		Title:    "Archived Groups",
+		Archives: archives,
+	}
+	state.renderTemplateOrReturnJson(w, r, "groupArchivePage", pageData)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestGroupArchive(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: "archive-group", Description: "group1",
+		MemberUid: []string{"user2", "user3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := testPostServiceAccountForm(t, &state, deletegroupPath, state.deleteGrouphandler, true,
+		url.Values{"groupnames": {"archive-group"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot archive the group, got %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, deletegroupPath, state.deleteGrouphandler, true,
+		url.Values{"groupnames": {"archive-group"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("archiving an archived group should fail, got %d", code)
+	}
+	members, _, err := state.Userinfo.GetusersofaGroup("archive-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 0 {
+		t.Fatalf("archived group still has members %v", members)
+	}
+	for _, group := range testGetTaggedGroups(t, &state, "allNoManager", "")[0] {
+		if group == "archive-group" {
+			t.Fatal("archived group is listed")
+		}
+	}
+	code = testPostServiceAccountForm(t, &state, addmembersbuttonPath, state.addmemberstoExistingGroup, true,
+		url.Values{"groupname": {"archive-group"}, "members": {"user1"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("adding members to an archived group should fail, got %d", code)
+	}
+
+	code = testPostServiceAccountForm(t, &state, groupArchivePath, state.groupArchiveHandler, false,
+		url.Values{"groupname": {"archive-group"}, "action": {"restore"}})
+	if code != http.StatusForbidden {
+		t.Fatalf("only admins restore groups, got %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, groupArchivePath, state.groupArchiveHandler, true,
+		url.Values{"groupname": {"archive-group"}, "action": {"restore"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot restore the group, got %d", code)
+	}
+	members, _, err = state.Userinfo.GetusersofaGroup("archive-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, []string{"user2", "user3"}) {
+		t.Fatalf("restored group has members %v", members)
+	}
+
+	_, err = state.archiveGroup(nil, "user1", "archive-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.runGroupArchiveDeletions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, _, err := state.Userinfo.GroupnameExistsornot("archive-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("group deleted before the end of the retention period")
+	}
+	_, err = state.db.Exec(`update group_archives set delete_after=0 where groupname='archive-group';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setGroupTagsInDB("archive-group", []string{"finance"}, "user1", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setGroupClassificationInDB("archive-group", groupClassificationNoServiceAccounts, "user1", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rows of the integrations are purged with the group
+	purgedRows := map[string]string{
+		"github_team_mappings": `insert into github_team_mappings values ('archive-group', 'acme', 'sre', 'user1', 0, 0, '');`,
+		"okta_group_pushes":    `insert into okta_group_pushes values ('archive-group', '00g1', 1, 'user1', 0, 0, '', 0);`,
+		"aws_group_members":    `insert into aws_group_members values ('archive-group', 'user2', 'aws-user2');`,
+		"group_tickets":        `insert into group_tickets values ('archive-group', 'TICKET-1', 0);`,
+	}
+	for _, stmt := range purgedRows {
+		_, err = state.db.Exec(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = state.runGroupArchiveDeletions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, _, err = state.Userinfo.GroupnameExistsornot("archive-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := getGroupArchiveFromDB("archive-group", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists || archive != nil {
+		t.Fatalf("group not deleted after the retention period")
+	}
+	tags, err := getGroupTagsFromDB("archive-group", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classification, err := getGroupClassification("archive-group", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 0 || classification != "" {
+		t.Fatalf("the deleted group kept its tags %v and classification %q", tags, classification)
+	}
+	for table := range purgedRows {
+		var count int
+		err = state.db.QueryRow("select count(*) from " + table + " where groupname='archive-group';").Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("the deleted group kept %d rows in %s", count, table)
+		}
+	}
+}
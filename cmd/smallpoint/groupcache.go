@@ -0,0 +1,200 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The group listing pages query LDAP on every request. cachedUserInfo keeps
+// the group listings for a short time and drops them whenever smallpoint
+// writes to LDAP, so the changes made here show at once and the changes made
+// elsewhere show within the TTL. The listings are kept in memory, or in Redis
+// when it is configured so that the instances share them and their
+// invalidations.
+
+const defaultGroupListingCacheTTL = 30 * time.Second
+
+type groupListingCacheConfig struct {
+	// TTL of the cached listings, 0 uses the default and a negative value
+	// disables the cache.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+func (config groupListingCacheConfig) ttl() time.Duration {
+	if config.TTL == 0 {
+		return defaultGroupListingCacheTTL
+	}
+	return config.TTL
+}
+
+type groupListingCacheEntry struct {
+	expiration time.Time
+	groups     []string
+	tuples     [][]string
+}
+
+// groupListingStore keeps the listings. The generation changes on every
+// write, the listings read during a write are not cached.
+type groupListingStore interface {
+	// get returns the entry even when it expired, ok tells whether it is
+	// fresh.
+	get(key string) (entry groupListingCacheEntry, generation uint64, ok bool)
+	put(key string, generation uint64, entry groupListingCacheEntry)
+	invalidate()
+}
+
+type cachedUserInfo struct {
+	userinfo.UserInfoBackend
+	ttl   time.Duration
+	store groupListingStore
+}
+
+func newCachedUserInfo(source userinfo.UserInfoBackend, ttl time.Duration) *cachedUserInfo {
+	return &cachedUserInfo{UserInfoBackend: source, ttl: ttl,
+		store: &memoryGroupListingStore{entries: make(map[string]groupListingCacheEntry)}}
+}
+
+func (u *cachedUserInfo) get(key string) (groupListingCacheEntry, uint64, bool) {
+	return u.store.get(key)
+}
+
+func (u *cachedUserInfo) put(key string, generation uint64, entry groupListingCacheEntry) {
+	entry.expiration = time.Now().Add(u.ttl)
+	u.store.put(key, generation, entry)
+}
+
+func (u *cachedUserInfo) invalidate() {
+	u.store.invalidate()
+}
+
+type memoryGroupListingStore struct {
+	mutex      sync.Mutex
+	generation uint64
+	entries    map[string]groupListingCacheEntry
+}
+
+func (s *memoryGroupListingStore) get(key string) (groupListingCacheEntry, uint64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || !entry.expiration.After(time.Now()) {
+		return entry, s.generation, false
+	}
+	return entry, s.generation, true
+}
+
+func (s *memoryGroupListingStore) put(key string, generation uint64, entry groupListingCacheEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if generation != s.generation {
+		return
+	}
+	s.entries[key] = entry
+}
+
+func (s *memoryGroupListingStore) invalidate() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.generation++
+	s.entries = make(map[string]groupListingCacheEntry)
+}
+
+// Callers sort and filter the listings, they get copies of the cached slices.
+// While the directory is unavailable the expired listings are served rather
+// than an error.
+
+func (u *cachedUserInfo) cachedGroups(key string, load func() ([]string, error)) ([]string, error) {
+	entry, generation, ok := u.get(key)
+	if !ok {
+		groups, err := load()
+		if err == userinfo.DirectoryUnavailable && !entry.expiration.IsZero() {
+			return append([]string(nil), entry.groups...), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.groups = groups
+		u.put(key, generation, entry)
+	}
+	return append([]string(nil), entry.groups...), nil
+}
+
+func (u *cachedUserInfo) cachedTuples(key string, load func() ([][]string, error)) ([][]string, error) {
+	entry, generation, ok := u.get(key)
+	if !ok {
+		tuples, err := load()
+		if err == userinfo.DirectoryUnavailable && !entry.expiration.IsZero() {
+			return append([][]string(nil), entry.tuples...), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.tuples = tuples
+		u.put(key, generation, entry)
+	}
+	return append([][]string(nil), entry.tuples...), nil
+}
+
+func (u *cachedUserInfo) GetallGroups() ([]string, error) {
+	return u.cachedGroups("allGroups", u.UserInfoBackend.GetallGroups)
+}
+
+func (u *cachedUserInfo) GetAllGroupsManagedBy() ([][]string, error) {
+	return u.cachedTuples("allGroupsManagedBy", u.UserInfoBackend.GetAllGroupsManagedBy)
+}
+
+func (u *cachedUserInfo) GetgroupsofUser(username string) ([]string, error) {
+	return u.cachedGroups("groupsOfUser\x00"+username, func() ([]string, error) {
+		return u.UserInfoBackend.GetgroupsofUser(username)
+	})
+}
+
+func (u *cachedUserInfo) GetGroupsInfoOfUser(groupdn string, username string) ([][]string, error) {
+	return u.cachedTuples("groupsInfoOfUser\x00"+groupdn+"\x00"+username, func() ([][]string, error) {
+		return u.UserInfoBackend.GetGroupsInfoOfUser(groupdn, username)
+	})
+}
+
+// The writes below change the group listings.
+
+func (u *cachedUserInfo) CreateGroup(groupinfo userinfo.GroupInfo) error {
+	defer u.invalidate()
+	return u.UserInfoBackend.CreateGroup(groupinfo)
+}
+
+func (u *cachedUserInfo) DeleteGroup(groupnames []string) error {
+	defer u.invalidate()
+	return u.UserInfoBackend.DeleteGroup(groupnames)
+}
+
+func (u *cachedUserInfo) ChangeDescription(groupname string, managegroup string) error {
+	defer u.invalidate()
+	return u.UserInfoBackend.ChangeDescription(groupname, managegroup)
+}
+
+func (u *cachedUserInfo) RenameGroup(groupname string, newname string) error {
+	defer u.invalidate()
+	return u.UserInfoBackend.RenameGroup(groupname, newname)
+}
+
+func (u *cachedUserInfo) AddmemberstoExisting(groupinfo userinfo.GroupInfo) error {
+	defer u.invalidate()
+	return u.UserInfoBackend.AddmemberstoExisting(groupinfo)
+}
+
+func (u *cachedUserInfo) DeletemembersfromGroup(groupinfo userinfo.GroupInfo) error {
+	defer u.invalidate()
+	return u.UserInfoBackend.DeletemembersfromGroup(groupinfo)
+}
+
+func (u *cachedUserInfo) CreateServiceAccount(groupinfo userinfo.GroupInfo) error {
+	defer u.invalidate()
+	return u.UserInfoBackend.CreateServiceAccount(groupinfo)
+}
+
+func (u *cachedUserInfo) DeleteServiceAccount(accountname string) error {
+	defer u.invalidate()
+	return u.UserInfoBackend.DeleteServiceAccount(accountname)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/mock"
+)
+
+type countingUserInfo struct {
+	userinfo.UserInfoBackend
+	allGroupsCalls int
+}
+
+func (u *countingUserInfo) GetallGroups() ([]string, error) {
+	u.allGroupsCalls++
+	return u.UserInfoBackend.GetallGroups()
+}
+
+func TestCachedUserInfo(t *testing.T) {
+	source := &countingUserInfo{UserInfoBackend: mock.New()}
+	cached := newCachedUserInfo(source, time.Hour)
+	for i := 0; i < 2; i++ {
+		groups, err := cached.GetallGroups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// callers may modify the listing
+		groups[0] = "modified"
+	}
+	if source.allGroupsCalls != 1 {
+		t.Fatalf("the listing was loaded %d times", source.allGroupsCalls)
+	}
+
+	err := cached.CreateGroup(userinfo.GroupInfo{Groupname: "cached-new", Description: descriptionAttribute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := cached.GetallGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, group := range groups {
+		if group == "modified" {
+			t.Fatal("the cached listing was modified by a caller")
+		}
+		found = found || group == "cached-new"
+	}
+	if !found || source.allGroupsCalls != 2 {
+		t.Fatalf("the listing was not reloaded after a write, %v", groups)
+	}
+
+	cached.ttl = time.Nanosecond
+	cached.invalidate()
+	for i := 0; i < 2; i++ {
+		_, err = cached.GetallGroups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if source.allGroupsCalls != 4 {
+		t.Fatalf("expired listings were used, %d loads", source.allGroupsCalls)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// A group classification restricts who can be a member of a group. Groups
+// without a classification accept anybody.
+const (
+	groupClassificationServiceAccountsOnly = "service_accounts_only"
+	groupClassificationNoServiceAccounts   = "no_service_accounts"
+)
+
+var groupClassifications = []string{groupClassificationServiceAccountsOnly,
+	groupClassificationNoServiceAccounts}
+
+var getGroupClassificationStmt = map[string]string{
+	"sqlite":   "select classification from group_classifications where groupname=?;",
+	"postgres": "select classification from group_classifications where groupname=$1;",
+}
+
+var setGroupClassificationStmts = map[string][]string{
+	"sqlite": {"delete from group_classifications where groupname=?;",
+		"insert into group_classifications(groupname, classification, updated_by, time_stamp) values (?,?,?,?);"},
+	"postgres": {"delete from group_classifications where groupname=$1;",
+		"insert into group_classifications(groupname, classification, updated_by, time_stamp) values ($1,$2,$3,$4);"},
+}
+
+// getGroupClassification returns an empty classification for unrestricted
+// groups.
+func getGroupClassification(groupname string, state *RuntimeState) (string, error) {
+	start := time.Now()
+	var classification string
+	err := state.db.QueryRow(getGroupClassificationStmt[state.dbType], groupname).Scan(&classification)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return classification, nil
+}
+
+func setGroupClassificationInDB(groupname string, classification string, username string, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := setGroupClassificationStmts[state.dbType]
+	_, err = tx.Exec(stmts[0], groupname)
+	if err != nil {
+		return err
+	}
+	if classification != "" {
+		_, err = tx.Exec(stmts[1], groupname, classification, username, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// classificationViolations returns the members not allowed in a group with
+// the given classification.
+func (state *RuntimeState) classificationViolations(classification string, members []string) ([]string, error) {
+	if classification == "" {
+		return nil, nil
+	}
+	var violations []string
+	for _, member := range members {
+		isServiceAccount, _, err := state.Userinfo.ServiceAccountExistsornot(member)
+		if err != nil {
+			return nil, err
+		}
+		switch classification {
+		case groupClassificationServiceAccountsOnly:
+			if !isServiceAccount {
+				violations = append(violations, member)
+			}
+		case groupClassificationNoServiceAccounts:
+			if isServiceAccount {
+				violations = append(violations, member)
+			}
+		}
+	}
+	return violations, nil
+}
+
+// checkGroupClassification returns a message for the user when some of the
+// members cannot join the group, nobody joins an archived group.
+func (state *RuntimeState) checkGroupClassification(groupname string, members []string) (string, error) {
+	archive, err := getGroupArchiveFromDB(groupname, state)
+	if err != nil {
+		return "", err
+	}
+	if archive != nil {
+		return fmt.Sprintf("Group %s is archived, it cannot get members", groupname), nil
+	}
+	classification, err := getGroupClassification(groupname, state)
+	if err != nil {
+		return "", err
+	}
+	violations, err := state.classificationViolations(classification, members)
+	if err != nil {
+		return "", err
+	}
+	if len(violations) == 0 {
+		return "", nil
+	}
+	if classification == groupClassificationServiceAccountsOnly {
+		return fmt.Sprintf("Group %s only accepts service accounts, %s cannot be added",
+			groupname, strings.Join(violations, ", ")), nil
+	}
+	return fmt.Sprintf("Group %s does not accept service accounts, %s cannot be added",
+		groupname, strings.Join(violations, ", ")), nil
+}
+
+func (state *RuntimeState) groupClassificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("groupClassificationHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	groupname := r.PostFormValue("groupname")
+	classification := r.PostFormValue("classification")
+	validClassification := classification == ""
+	for _, value := range groupClassifications {
+		if classification == value {
+			validClassification = true
+		}
+	}
+	if !validClassification {
+		state.writeFailureResponse(w, r, fmt.Sprintf("invalid classification '%s'", classification), http.StatusBadRequest)
+		return
+	}
+	members, _, err := state.requestUserinfo(r).GetusersofaGroup(groupname)
+	if err != nil {
+		if err == userinfo.GroupDoesNotExist {
+			state.writeFailureResponse(w, r, "group "+groupname+" does not exist", http.StatusBadRequest)
+			return
+		}
+		requestLogger(r).Error("groupClassificationHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	// existing members must be removed before restricting the group
+	violations, err := state.classificationViolations(classification, members)
+	if err != nil {
+		requestLogger(r).Error("groupClassificationHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if len(violations) > 0 {
+		state.writeFailureResponse(w, r, fmt.Sprintf("these members are not allowed by %s: %s",
+			classification, strings.Join(violations, ", ")), http.StatusBadRequest)
+		return
+	}
+	err = setGroupClassificationInDB(groupname, classification, username, state)
+	if err != nil {
+		requestLogger(r).Error("groupClassificationHandler failed", "err", err)
+		state.recordAuditEvent(r, username, auditActionSetGroupClassification, groupname, "", auditOutcomeFailure, err.Error())
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.recordAuditEvent(r, username, auditActionSetGroupClassification, groupname, "", auditOutcomeSuccess,
+		"classification "+classification)
+	message := fmt.Sprintf("Group %s accepts any member", groupname)
+	if classification != "" {
+		message = fmt.Sprintf("Group %s is now classified as %s", groupname, classification)
+	}
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        true,
+		Title:          "Group Classification Changed",
+		SuccessMessage: message,
+		ContinueURL:    groupinfoPath + "?groupname=" + groupname,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
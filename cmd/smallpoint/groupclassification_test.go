@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestGroupClassificationEnforcement(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.CreateServiceAccount(userinfo.GroupInfo{Groupname: "svc_member",
+		Mail: "team@example.com", LoginShell: "/bin/false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mock user1 is also a service account
+	err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: "humans", Description: descriptionAttribute,
+		MemberUid: []string{"user3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	classify := func(admin bool, groupname string, classification string) int {
+		return testPostServiceAccountForm(t, &state, groupClassificationPath, state.groupClassificationHandler,
+			admin, url.Values{"groupname": {groupname}, "classification": {classification}})
+	}
+	addMember := func(groupname string, member string) int {
+		return testPostServiceAccountForm(t, &state, addmembersbuttonPath, state.addmemberstoExistingGroup,
+			true, url.Values{"groupname": {groupname}, "members": {member}})
+	}
+	defer setGroupClassificationInDB("humans", "", "user1", &state)
+	defer setGroupClassificationInDB("group3", "", "user1", &state)
+
+	if code := classify(false, "group3", groupClassificationServiceAccountsOnly); code != http.StatusForbidden {
+		t.Fatalf("only admins classify groups, got %d", code)
+	}
+	if code := classify(true, "group3", "robots_only"); code != http.StatusBadRequest {
+		t.Fatalf("invalid classification should fail, got %d", code)
+	}
+	if code := classify(true, "group1", groupClassificationServiceAccountsOnly); code != http.StatusBadRequest {
+		t.Fatalf("group1 has human members, got %d", code)
+	}
+	if code := classify(true, "group3", groupClassificationServiceAccountsOnly); code != http.StatusOK {
+		t.Fatalf("classification failed with %d", code)
+	}
+	if code := classify(true, "group1", groupClassificationNoServiceAccounts); code != http.StatusBadRequest {
+		t.Fatalf("group1 has service account members, got %d", code)
+	}
+	if code := classify(true, "humans", groupClassificationNoServiceAccounts); code != http.StatusOK {
+		t.Fatalf("classification failed with %d", code)
+	}
+
+	if code := addMember("group3", "user2"); code != http.StatusBadRequest {
+		t.Fatalf("humans cannot join a service account group, got %d", code)
+	}
+	if code := addMember("group3", "svc_member"); code != http.StatusOK {
+		t.Fatalf("adding a service account failed with %d", code)
+	}
+	if code := addMember("humans", "svc_member"); code != http.StatusBadRequest {
+		t.Fatalf("service accounts are banned from humans, got %d", code)
+	}
+
+	// requests and approvals
+	jsonBytes, _ := json.Marshal(map[string][]string{"groups": {"group3"}})
+	req, err := http.NewRequest("POST", requestaccessPath, bytes.NewReader(jsonBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.requestAccessHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("request to a service account group should fail, got %d", rr.Code)
+	}
+	err = insertRequestInDB("svc_member", []string{"humans"}, "", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeRequestInDB("svc_member", "humans", requestStateCancelled, "svc_member", "", &state)
+	jsonBytes, _ = json.Marshal(map[string][][]string{"groups": {{"svc_member", "humans"}}})
+	req, err = http.NewRequest("POST", approverequestPath, bytes.NewReader(jsonBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie = testCreateValidAdminCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(state.approveHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("approval into a group banning service accounts should fail, got %d", rr.Code)
+	}
+	isMember, _, err := state.Userinfo.IsgroupmemberorNot("humans", "svc_member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isMember {
+		t.Fatal("svc_member was added to humans")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Cloning a group creates a new group with the members, the managing group,
+// the metadata, the tags and the classification of an existing group. The
+// mail addresses are not cloned as an address belongs to a single group.
+
+// cloneGroup creates newname from groupname.
+func (state *RuntimeState) cloneGroup(r *http.Request, actor string, groupname string, newname string) error {
+	members, managedBy, err := state.requestUserinfo(r).GetusersofaGroup(groupname)
+	if err != nil {
+		return err
+	}
+	metadata, err := getGroupMetadataFromDB(groupname, state)
+	if err != nil {
+		return err
+	}
+	tags, err := getGroupTagsFromDB(groupname, state)
+	if err != nil {
+		return err
+	}
+	classification, err := getGroupClassification(groupname, state)
+	if err != nil {
+		return err
+	}
+	groupinfo := userinfo.GroupInfo{Groupname: newname, Description: managedBy, MemberUid: members}
+	err = state.createLDAPGroup(r, groupinfo, actor, nil)
+	if err != nil {
+		state.recordAuditEvent(r, actor, auditActionCreateGroup, newname, "", auditOutcomeFailure,
+			"cloning "+groupname+": "+err.Error())
+		return err
+	}
+	state.recordAuditEvent(r, actor, auditActionCreateGroup, newname, "", auditOutcomeSuccess,
+		"managed by "+managedBy+", cloned from "+groupname)
+	for _, member := range members {
+		state.recordAuditEvent(r, actor, auditActionAddMember, newname, member, auditOutcomeSuccess,
+			"cloned from "+groupname)
+	}
+	if state.sysLog != nil {
+		state.sysLog.Write([]byte(fmt.Sprintf("Group %s was cloned from %s by %s", newname, groupname, actor)))
+	}
+
+	// the group exists from here, the metadata that cannot be copied is
+	// left for the owners to fill in
+	if metadata != (groupMetadata{}) {
+		metadata.UpdatedBy = actor
+		metadata.UpdatedAt = time.Now()
+		err = setGroupMetadataInDB(newname, metadata, state)
+		if err != nil {
+			return err
+		}
+	}
+	if len(tags) > 0 {
+		err = setGroupTagsInDB(newname, tags, actor, state)
+		if err != nil {
+			return err
+		}
+	}
+	if classification != "" {
+		err = setGroupClassificationInDB(newname, classification, actor, state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneGroupHandler creates a copy of a group, it is used by the admins.
+func (state *RuntimeState) cloneGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("cloneGroupHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	groupname := r.PostFormValue("groupname")
+	newname := strings.TrimSpace(r.PostFormValue("newname"))
+	message, err := state.checkNewGroupName(groupname, newname)
+	if err != nil {
+		requestLogger(r).Error("cloneGroupHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	err = state.cloneGroup(r, username, groupname, newname)
+	if err != nil {
+		requestLogger(r).Error("cannot clone the group", "group", groupname, "new_name", newname, "err", err)
+		state.writeFailureResponse(w, r, "cannot clone the group", http.StatusInternalServerError)
+		return
+	}
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        true,
+		Title:          "Group Cloned",
+		SuccessMessage: fmt.Sprintf("Group %s was cloned to %s", groupname, newname),
+		ContinueURL:    groupinfoPath + "?groupname=" + newname,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
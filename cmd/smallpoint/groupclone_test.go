@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestCloneGroup(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: "clone-source", Description: "group1",
+		MemberUid: []string{"user2", "user3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := groupMetadata{Description: "team before the split", Purpose: "testing", UpdatedBy: "user2"}
+	err = setGroupMetadataInDB("clone-source", metadata, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setGroupTagsInDB("clone-source", []string{"cloned"}, "user2", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := testPostServiceAccountForm(t, &state, cloneGroupPath, state.cloneGroupHandler, false,
+		url.Values{"groupname": {"clone-source"}, "newname": {"clone-copy"}})
+	if code != http.StatusForbidden {
+		t.Fatalf("only admins clone groups, got %d", code)
+	}
+	for _, newname := range []string{"group1", "bad name"} {
+		code = testPostServiceAccountForm(t, &state, cloneGroupPath, state.cloneGroupHandler, true,
+			url.Values{"groupname": {"clone-source"}, "newname": {newname}})
+		if code != http.StatusBadRequest {
+			t.Errorf("cloning to '%s' should fail, got %d", newname, code)
+		}
+	}
+	code = testPostServiceAccountForm(t, &state, cloneGroupPath, state.cloneGroupHandler, true,
+		url.Values{"groupname": {"clone-source"}, "newname": {"clone-copy"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot clone the group, got %d", code)
+	}
+
+	members, managedBy, err := state.Userinfo.GetusersofaGroup("clone-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, []string{"user2", "user3"}) || managedBy != "group1" {
+		t.Fatalf("bad members %v, managed by %s", members, managedBy)
+	}
+	clonedMetadata, err := getGroupMetadataFromDB("clone-copy", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := getGroupTagsFromDB("clone-copy", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clonedMetadata.Description != metadata.Description || clonedMetadata.UpdatedBy != "user1" ||
+		!reflect.DeepEqual(tags, []string{"cloned"}) {
+		t.Fatalf("bad metadata %+v, tags %v", clonedMetadata, tags)
+	}
+}
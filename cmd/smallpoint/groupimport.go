@@ -0,0 +1,696 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"gopkg.in/yaml.v2"
+)
+
+// The groups are imported from a declarative file listing each group with
+// its managing group and members, the directory is changed to match it. The
+// groups missing from the file are left alone, and so are the members of a
+// group without a members list. The YAML file has a groups list:
+//
+//	groups:
+//	- name: web-admins
+//	  managed_by: self-managed
+//	  members: [user1, user2]
+//
+// The CSV file has a header row with the groupname, managed_by and members
+// columns, the members are a space separated list of usernames.
+
+const maxGroupImportSize = 4 << 20
+
+const (
+	groupsFileFormatYAML = "yaml"
+	groupsFileFormatCSV  = "csv"
+)
+
+const (
+	importOutcomeCreated = "created"
+	importOutcomeChanged = "changed"
+)
+
+type groupSpec struct {
+	Name      string   `yaml:"name" json:"name"`
+	ManagedBy string   `yaml:"managed_by" json:"managed_by"`
+	Members   []string `yaml:"members" json:"members"`
+}
+
+type groupsFile struct {
+	Groups []groupSpec `yaml:"groups"`
+}
+
+type groupImportResult struct {
+	Group   string
+	Outcome string
+	Message string
+}
+
+type groupImportReport struct {
+	Created int
+	Changed int
+	Skipped int
+	Failed  int
+	Results []groupImportResult
+}
+
+func (report *groupImportReport) add(result groupImportResult) {
+	switch result.Outcome {
+	case importOutcomeCreated:
+		report.Created++
+	case importOutcomeChanged:
+		report.Changed++
+	case importOutcomeSkipped:
+		report.Skipped++
+	default:
+		report.Failed++
+	}
+	report.Results = append(report.Results, result)
+}
+
+// groupsFileFormat returns the format of a groups file from its name, YAML
+// unless it has a .csv extension.
+func groupsFileFormat(filename string) string {
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		return groupsFileFormatCSV
+	}
+	return groupsFileFormatYAML
+}
+
+func parseGroupsCSV(input io.Reader) ([]groupSpec, error) {
+	csvReader := csv.NewReader(input)
+	csvReader.TrimLeadingSpace = true
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the CSV header: %s", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"groupname", "managed_by"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("the CSV header has no %s column", required)
+		}
+	}
+	field := func(fields []string, name string) (string, bool) {
+		i, ok := columns[name]
+		if !ok || i >= len(fields) {
+			return "", ok
+		}
+		return strings.TrimSpace(fields[i]), true
+	}
+	var specs []groupSpec
+	for {
+		fields, err := csvReader.Read()
+		if err == io.EOF {
+			return specs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var spec groupSpec
+		spec.Name, _ = field(fields, "groupname")
+		spec.ManagedBy, _ = field(fields, "managed_by")
+		if members, ok := field(fields, "members"); ok {
+			spec.Members = strings.Fields(members)
+		}
+		specs = append(specs, spec)
+	}
+}
+
+// parseGroupsFile reads and validates the groups of a file, an error is
+// returned when the file is invalid as a whole.
+func parseGroupsFile(input io.Reader, format string) ([]groupSpec, error) {
+	var specs []groupSpec
+	switch format {
+	case groupsFileFormatYAML:
+		source, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		var file groupsFile
+		err = yaml.UnmarshalStrict(source, &file)
+		if err != nil {
+			return nil, err
+		}
+		specs = file.Groups
+	case groupsFileFormatCSV:
+		var err error
+		specs, err = parseGroupsCSV(input)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %s, it is yaml or csv", format)
+	}
+	seen := make(map[string]bool)
+	for i, spec := range specs {
+		if spec.Name == "" || spec.ManagedBy == "" {
+			return nil, fmt.Errorf("group %d has no name or managed_by", i+1)
+		}
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("group %s is listed twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		if spec.Members == nil {
+			continue
+		}
+		members := []string{}
+		isListed := make(map[string]bool)
+		for _, member := range spec.Members {
+			if member != "" && !isListed[member] {
+				isListed[member] = true
+				members = append(members, member)
+			}
+		}
+		specs[i].Members = members
+	}
+	return specs, nil
+}
+
+// orderGroupSpecs puts the managing groups listed in the file before the
+// groups they manage, so an empty directory is filled in one import.
+func orderGroupSpecs(specs []groupSpec) []groupSpec {
+	index := make(map[string]int)
+	for i, spec := range specs {
+		index[spec.Name] = i
+	}
+	visited := make([]bool, len(specs))
+	var ordered []groupSpec
+	var visit func(i int)
+	visit = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		if manager, ok := index[specs[i].ManagedBy]; ok {
+			visit(manager)
+		}
+		ordered = append(ordered, specs[i])
+	}
+	for i := range specs {
+		visit(i)
+	}
+	return ordered
+}
+
+// checkGroupSpec returns a message when the managing group or some of the
+// members do not exist.
+func (state *RuntimeState) checkGroupSpec(r *http.Request, spec groupSpec) (string, error) {
+	if spec.ManagedBy != descriptionAttribute && spec.ManagedBy != spec.Name {
+		exists, _, err := state.requestUserinfo(r).GroupnameExistsornot(spec.ManagedBy)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "managing group " + spec.ManagedBy + " does not exist", nil
+		}
+	}
+	for _, member := range spec.Members {
+		exists, err := state.requestUserinfo(r).UsernameExistsornot(member)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "user " + member + " does not exist", nil
+		}
+	}
+	return "", nil
+}
+
+// groupImportSource notes the import in the audit events.
+const groupImportSource = "group import"
+
+// createImportedGroup creates the group of the spec, source notes where the
+// spec comes from in the audit events.
+func (state *RuntimeState) createImportedGroup(r *http.Request, actor string, spec groupSpec, source string) error {
+	// a group managing itself is created self-managed and takes its name
+	// once it exists
+	description := spec.ManagedBy
+	if description == spec.Name {
+		description = descriptionAttribute
+	}
+	err := state.createLDAPGroup(r, userinfo.GroupInfo{Groupname: spec.Name, Description: description,
+		MemberUid: spec.Members}, actor, nil)
+	if err != nil {
+		state.recordAuditEvent(r, actor, auditActionCreateGroup, spec.Name, "", auditOutcomeFailure, err.Error())
+		return err
+	}
+	state.recordAuditEvent(r, actor, auditActionCreateGroup, spec.Name, "", auditOutcomeSuccess,
+		"managed by "+spec.ManagedBy+", "+source)
+	for _, member := range spec.Members {
+		state.recordAuditEvent(r, actor, auditActionAddMember, spec.Name, member, auditOutcomeSuccess, source)
+	}
+	if description != spec.ManagedBy {
+		return state.changeImportedGroupManager(r, actor, spec.Name, spec.ManagedBy, source)
+	}
+	return nil
+}
+
+func (state *RuntimeState) changeImportedGroupManager(r *http.Request, actor string, groupname string,
+	managedBy string, source string) error {
+	err := state.requestUserinfo(r).ChangeDescription(groupname, managedBy)
+	if err != nil {
+		state.recordAuditEvent(r, actor, auditActionChangeOwnership, groupname, "", auditOutcomeFailure, err.Error())
+		return err
+	}
+	state.recordAuditEvent(r, actor, auditActionChangeOwnership, groupname, "", auditOutcomeSuccess,
+		"managed by "+managedBy+", "+source)
+	return nil
+}
+
+// groupChanges are the changes made to a group to match its spec.
+type groupChanges struct {
+	Created bool `json:"created"`
+	// ManagedBy is the new managing group, empty when it is unchanged.
+	ManagedBy string   `json:"managed_by,omitempty"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+}
+
+func (changes groupChanges) changed() bool {
+	return changes.Created || changes.ManagedBy != "" || len(changes.Added) > 0 || len(changes.Removed) > 0
+}
+
+// diffGroupSpec returns the changes needed for the existing group to match
+// the spec, without applying them.
+func (state *RuntimeState) diffGroupSpec(r *http.Request, spec groupSpec) (groupChanges, error) {
+	changes := groupChanges{Added: []string{}, Removed: []string{}}
+	managedBy, err := state.requestUserinfo(r).GetDescriptionvalue(spec.Name)
+	if err != nil {
+		return changes, err
+	}
+	if managedBy != spec.ManagedBy {
+		changes.ManagedBy = spec.ManagedBy
+	}
+	if spec.Members == nil {
+		return changes, nil
+	}
+	members, _, err := state.requestUserinfo(r).GetusersofaGroup(spec.Name)
+	if err != nil {
+		return changes, err
+	}
+	isMember := make(map[string]bool)
+	for _, member := range members {
+		isMember[member] = true
+	}
+	isListed := make(map[string]bool)
+	for _, member := range spec.Members {
+		if !isMember[member] && !isListed[member] {
+			changes.Added = append(changes.Added, member)
+		}
+		isListed[member] = true
+	}
+	for _, member := range members {
+		if !isListed[member] {
+			changes.Removed = append(changes.Removed, member)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	return changes, nil
+}
+
+// applyGroupSpec changes the group to match the spec, creating it when it
+// does not exist. It returns a message instead when the spec cannot be
+// applied, source notes where the spec comes from in the audit events.
+func (state *RuntimeState) applyGroupSpec(r *http.Request, actor string, spec groupSpec,
+	archived map[string]bool, source string) (groupChanges, string, error) {
+	if archived[spec.Name] {
+		return groupChanges{}, "group is archived", nil
+	}
+	message, err := state.checkGroupSpec(r, spec)
+	if err != nil || message != "" {
+		return groupChanges{}, message, err
+	}
+	exists, _, err := state.requestUserinfo(r).GroupnameExistsornot(spec.Name)
+	if err != nil {
+		return groupChanges{}, "", err
+	}
+	if !exists {
+		err = state.createImportedGroup(r, actor, spec, source)
+		if err != nil {
+			return groupChanges{}, "", err
+		}
+		added := append([]string{}, spec.Members...)
+		sort.Strings(added)
+		return groupChanges{Created: true, ManagedBy: spec.ManagedBy, Added: added, Removed: []string{}}, "", nil
+	}
+
+	changes, err := state.diffGroupSpec(r, spec)
+	if err != nil {
+		return changes, "", err
+	}
+	message, err = state.checkGroupClassification(spec.Name, changes.Added)
+	if err != nil || message != "" {
+		return groupChanges{}, message, err
+	}
+
+	if changes.ManagedBy != "" {
+		err = state.changeImportedGroupManager(r, actor, spec.Name, spec.ManagedBy, source)
+		if err != nil {
+			return changes, "", err
+		}
+	}
+	if len(changes.Added) > 0 {
+		err = state.requestUserinfo(r).AddmemberstoExisting(userinfo.GroupInfo{Groupname: spec.Name,
+			MemberUid: changes.Added})
+		if err != nil {
+			for _, member := range changes.Added {
+				state.recordAuditEvent(r, actor, auditActionAddMember, spec.Name, member, auditOutcomeFailure,
+					err.Error())
+			}
+			return changes, "", err
+		}
+		for _, member := range changes.Added {
+			state.recordAuditEvent(r, actor, auditActionAddMember, spec.Name, member, auditOutcomeSuccess, source)
+		}
+	}
+	if len(changes.Removed) > 0 {
+		err = state.requestUserinfo(r).DeletemembersfromGroup(userinfo.GroupInfo{Groupname: spec.Name,
+			MemberUid: changes.Removed})
+		if err != nil {
+			for _, member := range changes.Removed {
+				state.recordAuditEvent(r, actor, auditActionRemoveMember, spec.Name, member, auditOutcomeFailure,
+					err.Error())
+			}
+			return changes, "", err
+		}
+		for _, member := range changes.Removed {
+			state.recordAuditEvent(r, actor, auditActionRemoveMember, spec.Name, member, auditOutcomeSuccess,
+				source)
+		}
+		// the members are removed already, failing to record the removals
+		// only loses the undo
+		_, err = state.recordMembershipRemovals(spec.Name, changes.Removed, actor)
+		if err != nil {
+			requestLogger(r).Error("cannot record the membership removals", "group", spec.Name, "err", err)
+		}
+	}
+	return changes, "", nil
+}
+
+// reconcileGroup changes the group to match the spec, it returns the
+// outcome and a message describing the changes.
+func (state *RuntimeState) reconcileGroup(r *http.Request, actor string, spec groupSpec,
+	archived map[string]bool) (string, string, error) {
+	exists, _, err := state.requestUserinfo(r).GroupnameExistsornot(spec.Name)
+	if err != nil {
+		return "", "", err
+	}
+	if exists && !archived[spec.Name] {
+		changes, err := state.diffGroupSpec(r, spec)
+		if err != nil {
+			return "", "", err
+		}
+		held, err := state.holdPrivilegeEscalation(r, actor, spec.Name, changes.Added, changes.Removed)
+		if err != nil {
+			return "", "", err
+		}
+		if held {
+			return importOutcomeSkipped, "the change may escalate privileges, it is held until another admin " +
+				"approves it", nil
+		}
+	}
+	changes, message, err := state.applyGroupSpec(r, actor, spec, archived, groupImportSource)
+	if err != nil {
+		return "", "", err
+	}
+	if message != "" {
+		return importOutcomeFailed, message, nil
+	}
+	if changes.Created {
+		return importOutcomeCreated, fmt.Sprintf("managed by %s with %d members", spec.ManagedBy,
+			len(spec.Members)), nil
+	}
+	var descriptions []string
+	if changes.ManagedBy != "" {
+		descriptions = append(descriptions, "managed by "+changes.ManagedBy)
+	}
+	if len(changes.Added) > 0 {
+		descriptions = append(descriptions, "added "+strings.Join(changes.Added, ", "))
+	}
+	if len(changes.Removed) > 0 {
+		descriptions = append(descriptions, "removed "+strings.Join(changes.Removed, ", "))
+	}
+	if len(descriptions) == 0 {
+		return importOutcomeSkipped, "already up to date", nil
+	}
+	return importOutcomeChanged, strings.Join(descriptions, "; "), nil
+}
+
+// importGroups reconciles every group of the file, a failing group does
+// not stop the import. An error is returned when the file itself is
+// invalid.
+func (state *RuntimeState) importGroups(r *http.Request, actor string, input io.Reader,
+	format string) (groupImportReport, error) {
+	var report groupImportReport
+	specs, err := parseGroupsFile(input, format)
+	if err != nil {
+		return report, err
+	}
+	archived, err := state.getArchivedGroups()
+	if err != nil {
+		return report, err
+	}
+	for _, spec := range orderGroupSpecs(specs) {
+		outcome, message, err := state.reconcileGroup(r, actor, spec, archived)
+		if err != nil {
+			requestLogger(r).Error("cannot import the group", "group", spec.Name, "err", err)
+			outcome = importOutcomeFailed
+			message = "internal error"
+		}
+		report.add(groupImportResult{Group: spec.Name, Outcome: outcome, Message: message})
+	}
+	return report, nil
+}
+
+// exportGroups returns the groups of the directory sorted by name, the
+// archived groups are left out.
+func (state *RuntimeState) exportGroups(r *http.Request) ([]groupSpec, error) {
+	groups, err := state.requestUserinfo(r).GetAllGroupsManagedBy()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := state.getArchivedGroups()
+	if err != nil {
+		return nil, err
+	}
+	var specs []groupSpec
+	for _, group := range groups {
+		if len(group) < 2 || archived[group[0]] {
+			continue
+		}
+		members, _, err := state.requestUserinfo(r).GetusersofaGroup(group[0])
+		if err != nil {
+			return nil, err
+		}
+		sortedMembers := append([]string{}, members...)
+		sort.Strings(sortedMembers)
+		specs = append(specs, groupSpec{Name: group[0], ManagedBy: group[1], Members: sortedMembers})
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs, nil
+}
+
+func writeGroupsFile(out io.Writer, format string, specs []groupSpec) error {
+	switch format {
+	case groupsFileFormatYAML:
+		source, err := yaml.Marshal(groupsFile{Groups: specs})
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(source)
+		return err
+	case groupsFileFormatCSV:
+		csvWriter := csv.NewWriter(out)
+		csvWriter.Write([]string{"groupname", "managed_by", "members"})
+		for _, spec := range specs {
+			csvWriter.Write([]string{spec.Name, spec.ManagedBy, strings.Join(spec.Members, " ")})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	return fmt.Errorf("unknown format %s, it is yaml or csv", format)
+}
+
+func (state *RuntimeState) importGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	pageData := groupImportPageData{
+		UserName: username,
+		IsAdmin:  true,
+		Title:    "Import Groups",
+	}
+	switch r.Method {
+	case getMethod:
+	case postMethod:
+		if !state.requireRecentAuthentication(w, r) {
+			return
+		}
+		var input io.Reader = http.MaxBytesReader(w, r.Body, maxGroupImportSize)
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = groupsFileFormatYAML
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+				format = groupsFileFormatCSV
+			}
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			err = r.ParseMultipartForm(maxGroupImportSize)
+			if err != nil {
+				state.writeFailureResponse(w, r, fmt.Sprint(err), http.StatusBadRequest)
+				return
+			}
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				state.writeFailureResponse(w, r, "a groups file is required", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			input = file
+			format = groupsFileFormat(header.Filename)
+		}
+		report, err := state.importGroups(r, username, input, format)
+		if err != nil {
+			state.writeFailureResponse(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pageData.Report = &report
+	default:
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	state.renderTemplateOrReturnJson(w, r, "groupImportPage", pageData)
+}
+
+func (state *RuntimeState) exportGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	contentType := "text/csv"
+	switch format {
+	case "", groupsFileFormatYAML:
+		format = groupsFileFormatYAML
+		contentType = "application/yaml"
+	case groupsFileFormatCSV:
+	default:
+		state.writeFailureResponse(w, r, "format is yaml or csv", http.StatusBadRequest)
+		return
+	}
+	specs, err := state.exportGroups(r)
+	if err != nil {
+		requestLogger(r).Error("exportGroupsHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=groups."+format)
+	err = writeGroupsFile(w, format, specs)
+	if err != nil {
+		requestLogger(r).Error("exportGroupsHandler failed", "err", err)
+	}
+}
+
+// importGroupsCommand implements the import-groups command, it returns the
+// process exit code.
+func importGroupsCommand(state *RuntimeState, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: import-groups FILE\n")
+		return 2
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open %s: %s\n", args[0], err)
+		return 1
+	}
+	defer file.Close()
+	actor := "smallpoint"
+	if currentUser, err := user.Current(); err == nil {
+		actor = currentUser.Username
+	}
+	report, err := state.importGroups(nil, actor, file, groupsFileFormat(args[0]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import FAILED: %s\n", err)
+		return 1
+	}
+	for _, result := range report.Results {
+		fmt.Printf("%s: %s %s\n", result.Group, result.Outcome, result.Message)
+	}
+	fmt.Printf("created=%d changed=%d skipped=%d failed=%d\n", report.Created, report.Changed, report.Skipped,
+		report.Failed)
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// exportGroupsCommand implements the export-groups command, the groups are
+// written to the standard output without a file.
+func exportGroupsCommand(state *RuntimeState, args []string) int {
+	flags := flag.NewFlagSet("export-groups", flag.ContinueOnError)
+	format := flags.String("format", "", "The format of the file, yaml or csv, from the file extension when empty")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: export-groups [-format yaml|csv] [FILE]\n")
+		return 2
+	}
+	if *format == "" {
+		*format = groupsFileFormat(flags.Arg(0))
+	}
+	specs, err := state.exportGroups(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export FAILED: %s\n", err)
+		return 1
+	}
+	if flags.NArg() == 0 {
+		err = writeGroupsFile(os.Stdout, *format, specs)
+	} else {
+		var file *os.File
+		file, err = os.OpenFile(flags.Arg(0), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			err = writeGroupsFile(file, *format, specs)
+			closeErr := file.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(flags.Arg(0))
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export FAILED: %s\n", err)
+		return 1
+	}
+	return 0
+}
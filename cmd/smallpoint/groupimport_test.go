@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func testImportGroups(t *testing.T, state *RuntimeState, contentType string, data string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", importGroupsPath, strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidAdminCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.importGroupsHandler).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestImportGroups(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the managing group is listed after the group it manages
+	yamlData := `groups:
+- name: import_web
+  managed_by: import_admins
+  members: [user2, user3]
+- name: import_admins
+  managed_by: self-managed
+  members: [user1]
+- name: group1
+  managed_by: self-managed
+  members: [user1, user2]
+- name: group2
+  managed_by: group1
+- name: import_broken
+  managed_by: group1
+  members: [nobody]
+`
+	rr := testImportGroups(t, &state, "application/yaml", yamlData)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("import failed with %d: %s", rr.Code, rr.Body.String())
+	}
+	var pageData groupImportPageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := pageData.Report
+	if report == nil || report.Created != 2 || report.Changed != 1 || report.Skipped != 1 || report.Failed != 1 {
+		t.Fatalf("bad import report %+v", report)
+	}
+	if report.Results[0].Group != "import_admins" || report.Results[1].Group != "import_web" {
+		t.Fatalf("the managing group is not created first %+v", report.Results)
+	}
+	managedBy, err := state.Userinfo.GetDescriptionvalue("import_web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if managedBy != "import_admins" {
+		t.Fatalf("import_web is managed by %s", managedBy)
+	}
+	// the members missing from the file are removed, the groups without a
+	// members list keep theirs
+	members, _, err := state.Userinfo.GetusersofaGroup("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	members = append([]string{}, members...)
+	sort.Strings(members)
+	if !reflect.DeepEqual(members, []string{"user1", "user2"}) {
+		t.Fatalf("group1 has members %v", members)
+	}
+	isMember, _, err := state.Userinfo.IsgroupmemberorNot("group2", "user2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isMember {
+		t.Fatal("the members of group2 were changed")
+	}
+
+	// importing the export again changes nothing
+	specs, err := state.exportGroups(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported bytes.Buffer
+	err = writeGroupsFile(&exported, groupsFileFormatCSV, specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = testImportGroups(t, &state, "text/csv", exported.String())
+	if rr.Code != http.StatusOK {
+		t.Fatalf("import failed with %d: %s", rr.Code, rr.Body.String())
+	}
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := pageData.Report; report.Skipped != len(specs) {
+		t.Fatalf("bad reimport report %+v", report)
+	}
+
+	if rr := testImportGroups(t, &state, "text/csv", "groupname,members\ngroup1,user1\n"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("a missing managed_by column should fail, got %d", rr.Code)
+	}
+	if rr := testImportGroups(t, &state, "application/yaml", "groups:\n- name: group1\n  managed_by: group2\n- name: group1\n  managed_by: group2\n"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("a group listed twice should fail, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Merging a group into another adds its members to the other group, moves
+// the groups and service accounts it manages and its pending requests, then
+// archives it. The report lists every change.
+
+type groupMergeReport struct {
+	Group       string
+	MergedGroup string
+	// AddedMembers were members of the merged group only.
+	AddedMembers    []string
+	ManagedGroups   []string
+	ServiceAccounts []string
+	// RetargetedRequests are the users whose requests now target the group,
+	// the requests of DroppedRequests were dropped as they are members
+	// or have already requested the group.
+	RetargetedRequests []string
+	DroppedRequests    []string
+	DeleteAfter        time.Time
+}
+
+var getServiceAccountsOwnedByStmt = map[string]string{
+	"sqlite":   "select accountname from service_accounts where owner_group=? order by accountname;",
+	"postgres": "select accountname from service_accounts where owner_group=$1 order by accountname;",
+}
+
+var getPendingRequestUsersStmt = map[string]string{
+	"sqlite":   "select username from pending_requests where groupname=? order by username;",
+	"postgres": "select username from pending_requests where groupname=$1 order by username;",
+}
+
+var retargetPendingRequestStmt = map[string]string{
+	"sqlite":   "update pending_requests set groupname=? where username=? and groupname=?;",
+	"postgres": "update pending_requests set groupname=$1 where username=$2 and groupname=$3;",
+}
+
+// checkGroupMerge returns a message for the user when the groups cannot be
+// merged.
+func (state *RuntimeState) checkGroupMerge(groupname string, mergedGroup string) (string, error) {
+	if groupname == mergedGroup {
+		return "a group cannot be merged into itself", nil
+	}
+	for _, name := range []string{groupname, mergedGroup} {
+		exists, _, err := state.Userinfo.GroupnameExistsornot(name)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return fmt.Sprintf("group %s does not exist", name), nil
+		}
+		archive, err := getGroupArchiveFromDB(name, state)
+		if err != nil {
+			return "", err
+		}
+		if archive != nil {
+			return fmt.Sprintf("group %s is archived", name), nil
+		}
+	}
+	return "", nil
+}
+
+// mergeGroup merges mergedGroup into groupname, it returns a message for the
+// user when the members of mergedGroup cannot join groupname.
+func (state *RuntimeState) mergeGroup(r *http.Request, actor string, groupname string,
+	mergedGroup string) (groupMergeReport, string, error) {
+	report := groupMergeReport{Group: groupname, MergedGroup: mergedGroup}
+	members, _, err := state.requestUserinfo(r).GetusersofaGroup(groupname)
+	if err != nil {
+		return report, "", err
+	}
+	mergedMembers, _, err := state.requestUserinfo(r).GetusersofaGroup(mergedGroup)
+	if err != nil {
+		return report, "", err
+	}
+	isMember := make(map[string]bool)
+	for _, member := range members {
+		isMember[member] = true
+	}
+	for _, member := range mergedMembers {
+		if !isMember[member] {
+			report.AddedMembers = append(report.AddedMembers, member)
+		}
+	}
+	sort.Strings(report.AddedMembers)
+	message, err := state.checkGroupClassification(groupname, report.AddedMembers)
+	if err != nil || message != "" {
+		return report, message, err
+	}
+	// nothing is merged until the members may join
+	held, err := state.holdPrivilegeEscalation(r, actor, groupname, report.AddedMembers, nil)
+	if err != nil {
+		return report, "", err
+	}
+	if held {
+		return report, fmt.Sprintf("adding the members of %s to %s may escalate privileges, it is held until "+
+			"another admin approves it, merge the groups again then", mergedGroup, groupname), nil
+	}
+
+	if len(report.AddedMembers) > 0 {
+		err = state.requestUserinfo(r).AddmemberstoExisting(userinfo.GroupInfo{Groupname: groupname,
+			MemberUid: report.AddedMembers})
+		if err != nil {
+			return report, "", err
+		}
+		for _, member := range report.AddedMembers {
+			isMember[member] = true
+			state.recordAuditEvent(r, actor, auditActionAddMember, groupname, member, auditOutcomeSuccess,
+				"merged from "+mergedGroup)
+		}
+	}
+
+	allGroups, err := state.requestUserinfo(r).GetAllGroupsManagedBy()
+	if err != nil {
+		return report, "", err
+	}
+	for _, group := range allGroups {
+		if len(group) < 2 || group[1] != mergedGroup || group[0] == mergedGroup {
+			continue
+		}
+		manager := groupname
+		if group[0] == groupname {
+			manager = descriptionAttribute
+		}
+		err = state.requestUserinfo(r).ChangeDescription(group[0], manager)
+		if err != nil {
+			state.recordAuditEvent(r, actor, auditActionChangeOwnership, group[0], "", auditOutcomeFailure, err.Error())
+			return report, "", err
+		}
+		state.recordAuditEvent(r, actor, auditActionChangeOwnership, group[0], "", auditOutcomeSuccess,
+			"managed by "+manager+", merged from "+mergedGroup)
+		report.ManagedGroups = append(report.ManagedGroups, group[0])
+	}
+	sort.Strings(report.ManagedGroups)
+
+	report.ServiceAccounts, err = queryStringsFromDB(state, getServiceAccountsOwnedByStmt[state.dbType], mergedGroup)
+	if err != nil {
+		return report, "", err
+	}
+	for _, accountName := range report.ServiceAccounts {
+		account, err := getServiceAccountFromDB(accountName, state)
+		if err != nil {
+			return report, "", err
+		}
+		err = state.transferServiceAccount(r, actor, account, groupname, ", merged from "+mergedGroup)
+		if err != nil {
+			return report, "", err
+		}
+	}
+
+	requesters, err := queryStringsFromDB(state, getPendingRequestUsersStmt[state.dbType], mergedGroup)
+	if err != nil {
+		return report, "", err
+	}
+	for _, username := range requesters {
+		// requests of users that joined or requested the group are dropped
+		// with the merged group
+		if isMember[username] || entryExistsorNot(username, groupname, state) {
+			report.DroppedRequests = append(report.DroppedRequests, username)
+			continue
+		}
+		err = execServiceAccountUpdate(state, retargetPendingRequestStmt[state.dbType], groupname, username,
+			mergedGroup)
+		if err != nil {
+			return report, "", err
+		}
+		err = execServiceAccountUpdate(state, retargetAccessRequestStmt[state.dbType], groupname, username,
+			mergedGroup)
+		if err != nil {
+			return report, "", err
+		}
+		report.RetargetedRequests = append(report.RetargetedRequests, username)
+	}
+
+	report.DeleteAfter, err = state.archiveGroup(r, actor, mergedGroup)
+	if err != nil {
+		return report, "", err
+	}
+	state.recordAuditEvent(r, actor, auditActionMergeGroup, groupname, "", auditOutcomeSuccess,
+		fmt.Sprintf("merged %s: %d members added, %d groups and %d service accounts moved, %d requests retargeted",
+			mergedGroup, len(report.AddedMembers), len(report.ManagedGroups), len(report.ServiceAccounts),
+			len(report.RetargetedRequests)))
+	return report, "", nil
+}
+
+// mergeGroupHandler merges a group into another, it is used by the admins.
+func (state *RuntimeState) mergeGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	if !state.requireRecentAuthentication(w, r) {
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("mergeGroupHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	groupname := r.PostFormValue("groupname")
+	mergedGroup := r.PostFormValue("mergedGroup")
+	message, err := state.checkGroupMerge(groupname, mergedGroup)
+	if err != nil {
+		requestLogger(r).Error("mergeGroupHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	report, message, err := state.mergeGroup(r, username, groupname, mergedGroup)
+	if err != nil {
+		requestLogger(r).Error("mergeGroupHandler failed", "err", err)
+		state.recordAuditEvent(r, username, auditActionMergeGroup, groupname, "", auditOutcomeFailure,
+			"merging "+mergedGroup+": "+err.Error())
+		state.writeFailureResponse(w, r, "cannot merge the groups, the merge is incomplete", http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	pageData := groupMergePageData{
+		UserName: username,
+		IsAdmin:  true,
+		Title:    "Group Merge Report",
+		Report:   report,
+	}
+	state.renderTemplateOrReturnJson(w, r, "groupMergePage", pageData)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestMergeGroup(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range []userinfo.GroupInfo{
+		{Groupname: "merge-a", Description: "self-managed", MemberUid: []string{"user1"}},
+		{Groupname: "merge-b", Description: "self-managed", MemberUid: []string{"user2"}},
+		{Groupname: "merge-managed", Description: "merge-b"},
+	} {
+		err = state.Userinfo.CreateGroup(group)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	testCreateRotatableServiceAccount(t, &state, "svc_merge")
+	err = execServiceAccountUpdate(&state, updateServiceAccountOwnerStmt[state.dbType], "merge-b", "svc_merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"user1", "user3"} {
+		err = insertRequestInDB(username, []string{"merge-b"}, "", &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code := testPostServiceAccountForm(t, &state, mergeGroupPath, state.mergeGroupHandler, false,
+		url.Values{"groupname": {"merge-a"}, "mergedGroup": {"merge-b"}})
+	if code != http.StatusForbidden {
+		t.Fatalf("only admins merge groups, got %d", code)
+	}
+	for _, mergedGroup := range []string{"merge-a", "nogroup"} {
+		code = testPostServiceAccountForm(t, &state, mergeGroupPath, state.mergeGroupHandler, true,
+			url.Values{"groupname": {"merge-a"}, "mergedGroup": {mergedGroup}})
+		if code != http.StatusBadRequest {
+			t.Errorf("merging %s should fail, got %d", mergedGroup, code)
+		}
+	}
+
+	report, message, err := state.mergeGroup(nil, "user1", "merge-a", "merge-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != "" {
+		t.Fatal(message)
+	}
+	expected := groupMergeReport{Group: "merge-a", MergedGroup: "merge-b", AddedMembers: []string{"user2"},
+		ManagedGroups: []string{"merge-managed"}, ServiceAccounts: []string{"svc_merge"},
+		RetargetedRequests: []string{"user3"}, DroppedRequests: []string{"user1"}, DeleteAfter: report.DeleteAfter}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("bad merge report %+v", report)
+	}
+	members, _, err := state.Userinfo.GetusersofaGroup("merge-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, managedBy, err := state.Userinfo.GetusersofaGroup("merge-managed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, []string{"user1", "user2"}) || managedBy != "merge-a" {
+		t.Fatalf("bad members %v, merge-managed managed by %s", members, managedBy)
+	}
+	account, err := getServiceAccountFromDB("svc_merge", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := getGroupArchiveFromDB("merge-b", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.OwnerGroup != "merge-a" || archive == nil || !entryExistsorNot("user3", "merge-a", &state) {
+		t.Fatalf("service account owned by %s, archive %v", account.OwnerGroup, archive)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The LDAP description attribute of a group holds its managing group, so the
+// human readable metadata of groups is kept in the local DB.
+
+const groupMetadataMaxLength = 1024
+
+type groupMetadata struct {
+	Description  string
+	ContactEmail string
+	Purpose      string
+	UpdatedBy    string
+	UpdatedAt    time.Time
+}
+
+var getGroupMetadataStmt = map[string]string{
+	"sqlite":   "select description, contact_email, purpose, updated_by, time_stamp from group_metadata where groupname=?;",
+	"postgres": "select description, contact_email, purpose, updated_by, time_stamp from group_metadata where groupname=$1;",
+}
+
+var setGroupMetadataStmts = map[string][]string{
+	"sqlite": {"delete from group_metadata where groupname=?;",
+		"insert into group_metadata(groupname, description, contact_email, purpose, updated_by, time_stamp) values (?,?,?,?,?,?);"},
+	"postgres": {"delete from group_metadata where groupname=$1;",
+		"insert into group_metadata(groupname, description, contact_email, purpose, updated_by, time_stamp) values ($1,$2,$3,$4,$5,$6);"},
+}
+
+// getGroupMetadataFromDB returns empty metadata for groups that were never
+// described.
+func getGroupMetadataFromDB(groupname string, state *RuntimeState) (groupMetadata, error) {
+	start := time.Now()
+	var metadata groupMetadata
+	var timeStamp int64
+	err := state.db.QueryRow(getGroupMetadataStmt[state.dbType], groupname).Scan(&metadata.Description,
+		&metadata.ContactEmail, &metadata.Purpose, &metadata.UpdatedBy, &timeStamp)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return metadata, nil
+		}
+		return metadata, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	metadata.UpdatedAt = time.Unix(timeStamp, 0)
+	return metadata, nil
+}
+
+func setGroupMetadataInDB(groupname string, metadata groupMetadata, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := setGroupMetadataStmts[state.dbType]
+	_, err = tx.Exec(stmts[0], groupname)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(stmts[1], groupname, metadata.Description, metadata.ContactEmail, metadata.Purpose,
+		metadata.UpdatedBy, metadata.UpdatedAt.Unix())
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// groupMetadataFromForm returns the metadata and a message for the user when
+// a field is invalid.
+func groupMetadataFromForm(r *http.Request) (groupMetadata, string) {
+	metadata := groupMetadata{
+		Description:  strings.TrimSpace(r.PostFormValue("description")),
+		ContactEmail: strings.TrimSpace(r.PostFormValue("contactEmail")),
+		Purpose:      strings.TrimSpace(r.PostFormValue("purpose")),
+	}
+	fields := []struct {
+		name  string
+		value string
+	}{{"description", metadata.Description}, {"contactEmail", metadata.ContactEmail},
+		{"purpose", metadata.Purpose}}
+	for _, field := range fields {
+		if len(field.value) > groupMetadataMaxLength {
+			return metadata, fmt.Sprintf("%s is longer than %d characters", field.name, groupMetadataMaxLength)
+		}
+	}
+	if metadata.ContactEmail != "" {
+		address, err := mail.ParseAddress(metadata.ContactEmail)
+		if err != nil || address.Address != metadata.ContactEmail {
+			return metadata, fmt.Sprintf("invalid contact email '%s'", metadata.ContactEmail)
+		}
+	}
+	return metadata, ""
+}
+
+// groupMetadataHandler is used by the group owners to edit the metadata of
+// their group.
+func (state *RuntimeState) groupMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("groupMetadataHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	groupname := r.PostFormValue("groupname")
+	groupExists, _, err := state.requestUserinfo(r).GroupnameExistsornot(groupname)
+	if err != nil {
+		requestLogger(r).Error("groupMetadataHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !groupExists {
+		state.writeFailureResponse(w, r, "group "+groupname+" does not exist", http.StatusBadRequest)
+		return
+	}
+	isGroupAdmin, err := state.isGroupAdmin(username, groupname)
+	if err != nil {
+		requestLogger(r).Error("groupMetadataHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !isGroupAdmin {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	metadata, message := groupMetadataFromForm(r)
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	metadata.UpdatedBy = username
+	metadata.UpdatedAt = time.Now()
+	err = setGroupMetadataInDB(groupname, metadata, state)
+	if err != nil {
+		requestLogger(r).Error("groupMetadataHandler failed", "err", err)
+		state.recordAuditEvent(r, username, auditActionUpdateGroupMetadata, groupname, "", auditOutcomeFailure, err.Error())
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.recordAuditEvent(r, username, auditActionUpdateGroupMetadata, groupname, "", auditOutcomeSuccess,
+		"contact "+metadata.ContactEmail)
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:          "Group Metadata Updated",
+		SuccessMessage: fmt.Sprintf("The metadata of group %s was updated", groupname),
+		ContinueURL:    groupinfoPath + "?groupname=" + groupname,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
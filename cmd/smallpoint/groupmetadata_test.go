@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestGroupMetadata(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalidForms := []url.Values{
+		{"groupname": {"nogroup"}, "description": {"missing group"}},
+		{"groupname": {"group2"}, "contactEmail": {"not an email"}},
+		{"groupname": {"group2"}, "contactEmail": {"Team <team@example.com>"}},
+	}
+	for _, form := range invalidForms {
+		code := testPostServiceAccountForm(t, &state, groupMetadataPath, state.groupMetadataHandler, false, form)
+		if code != http.StatusBadRequest {
+			t.Errorf("form %v should fail, got %d", form, code)
+		}
+	}
+	code := testPostServiceAccountForm(t, &state, groupMetadataPath, state.groupMetadataHandler, false,
+		url.Values{"groupname": {"group2"}, "description": {"Release engineers"},
+			"contactEmail": {"release@example.com"}, "purpose": {"deploy access"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot update the metadata, got %d", code)
+	}
+
+	req, err := http.NewRequest("GET", groupinfoPath+"?groupname=group2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.groupInfoWebpage).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("group info failed with %d", rr.Code)
+	}
+	var pageData groupInfoPageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := pageData.Metadata
+	if metadata.Description != "Release engineers" || metadata.ContactEmail != "release@example.com" ||
+		metadata.Purpose != "deploy access" || metadata.UpdatedBy != "user2" {
+		t.Fatalf("bad group metadata %+v", metadata)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Listings that need a query per group run them on a bounded pool of
+// workers, large directories would otherwise take minutes to list.
+
+const defaultLDAPQueryConcurrency = 8
+
+func (config baseConfig) ldapQueryConcurrency() int {
+	if config.LDAPQueryConcurrency > 0 {
+		return config.LDAPQueryConcurrency
+	}
+	return defaultLDAPQueryConcurrency
+}
+
+// runGroupQueries calls query for every group with at most concurrency
+// queries running at once, it returns the first error once every query is
+// done.
+func runGroupQueries(groupnames []string, concurrency int, query func(index int, groupname string) error) error {
+	indexes := make(chan int)
+	var firstErr error
+	var errMutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(groupnames); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				err := query(index, groupnames[index])
+				if err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMutex.Unlock()
+				}
+			}
+		}()
+	}
+	for index := range groupnames {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+	return firstErr
+}
+
+// appendMemberCounts adds the member count of every group to its tuple.
+func (state *RuntimeState) appendMemberCounts(groups [][]string) ([][]string, error) {
+	groupnames := make([]string, len(groups))
+	for i, group := range groups {
+		groupnames[i] = group[0]
+	}
+	counts := make([]string, len(groups))
+	err := runGroupQueries(groupnames, state.Config.Base.ldapQueryConcurrency(),
+		func(index int, groupname string) error {
+			members, _, err := state.Userinfo.GetusersofaGroup(groupname)
+			if err != nil {
+				// the group was deleted since it was listed
+				if err == userinfo.GroupDoesNotExist {
+					return nil
+				}
+				return err
+			}
+			counts[index] = strconv.Itoa(len(members))
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	withCounts := make([][]string, len(groups))
+	for i, group := range groups {
+		withCounts[i] = append(append([]string(nil), group...), counts[i])
+	}
+	return withCounts, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunGroupQueries(t *testing.T) {
+	var groupnames []string
+	for i := 0; i < 20; i++ {
+		groupnames = append(groupnames, fmt.Sprintf("group%d", i))
+	}
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	queried := make([]string, len(groupnames))
+	err := runGroupQueries(groupnames, 3, func(index int, groupname string) error {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(time.Millisecond)
+		queried[index] = groupname
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(queried, groupnames) || maxRunning > 3 || maxRunning < 2 {
+		t.Fatalf("queried %v with %d queries at once", queried, maxRunning)
+	}
+
+	failure := errors.New("query failed")
+	err = runGroupQueries(groupnames, 3, func(index int, groupname string) error {
+		if index == 5 {
+			return failure
+		}
+		return nil
+	})
+	if err != failure {
+		t.Fatalf("the failure was not returned, got %v", err)
+	}
+}
+
+func TestGroupListingMemberCounts(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]string)
+	for _, group := range testGetTaggedGroups(t, &state, "all", "") {
+		if len(group) != 3 {
+			t.Fatalf("bad group tuple %v", group)
+		}
+		counts[group[0]] = group[2]
+	}
+	if counts["group1"] != "2" || counts["group3"] != "0" {
+		t.Fatalf("bad member counts %v", counts)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// Renaming a group renames it in LDAP, where the groups it manages are
+// updated, and moves every reference to the group name kept in the DB. The
+// audit log is never rewritten, the renames are recorded so that searching
+// the audit log for a group also finds the events of its former names.
+
+var groupNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// groupReferenceColumns are the columns holding group names. The GID
+// reservations are left out, they only last while a group is created and
+// are looked up by GID.
+var groupReferenceColumns = []struct {
+	table  string
+	column string
+}{
+	{"pending_requests", "groupname"},
+	{"access_requests", "groupname"},
+	{"membership_removals", "groupname"},
+	{"group_membership_history", "groupname"},
+	{"group_classifications", "groupname"},
+	{"group_metadata", "groupname"},
+	{"group_tags", "groupname"},
+	{"mailing_list_addresses", "groupname"},
+	{"service_accounts", "owner_group"},
+	{"service_account_takeovers", "owner_group"},
+	{"service_account_requests", "owner_group"},
+	{"group_templates", "managed_by"},
+	{"github_team_mappings", "groupname"},
+	{"okta_group_pushes", "groupname"},
+	{"aws_group_members", "groupname"},
+	{"group_tickets", "groupname"},
+	{"held_changes", "groupname"},
+	{"scim_push_queue", "groupname"},
+}
+
+var insertGroupRenameStmt = map[string]string{
+	"sqlite":   "insert into group_renames(old_name, new_name, renamed_by, time_stamp) values (?,?,?,?);",
+	"postgres": "insert into group_renames(old_name, new_name, renamed_by, time_stamp) values ($1,$2,$3,$4);",
+}
+
+var getGroupFormerNamesStmt = map[string]string{
+	"sqlite":   "select old_name from group_renames where new_name=?;",
+	"postgres": "select old_name from group_renames where new_name=$1;",
+}
+
+func renameGroupReferencesInDB(groupname string, newname string, username string, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, reference := range groupReferenceColumns {
+		stmtText := fmt.Sprintf("update %s set %s=? where %s=?;", reference.table, reference.column, reference.column)
+		if state.dbType == "postgres" {
+			stmtText = fmt.Sprintf("update %s set %s=$1 where %s=$2;", reference.table, reference.column, reference.column)
+		}
+		_, err = tx.Exec(stmtText, newname, groupname)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(insertGroupRenameStmt[state.dbType], groupname, newname, username, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// getGroupFormerNames returns every name the group had before its renames.
+func (state *RuntimeState) getGroupFormerNames(groupname string) ([]string, error) {
+	seen := map[string]bool{groupname: true}
+	var formerNames []string
+	toVisit := []string{groupname}
+	for len(toVisit) > 0 {
+		names, err := queryStringsFromDB(state, getGroupFormerNamesStmt[state.dbType], toVisit[0])
+		if err != nil {
+			return nil, err
+		}
+		toVisit = toVisit[1:]
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			formerNames = append(formerNames, name)
+			toVisit = append(toVisit, name)
+		}
+	}
+	return formerNames, nil
+}
+
+// checkNewGroupName returns a message for the user when a group named newname
+// cannot be made from the group, by a rename or a clone.
+func (state *RuntimeState) checkNewGroupName(groupname string, newname string) (string, error) {
+	if !groupNamePattern.MatchString(newname) {
+		return fmt.Sprintf("invalid group name '%s'", newname), nil
+	}
+	if newname == groupname {
+		return "the new name is the current name", nil
+	}
+	exists, _, err := state.Userinfo.GroupnameExistsornot(groupname)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return fmt.Sprintf("group %s does not exist", groupname), nil
+	}
+	exists, _, err = state.Userinfo.GroupnameExistsornot(newname)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return fmt.Sprintf("group %s already exists", newname), nil
+	}
+	archive, err := getGroupArchiveFromDB(groupname, state)
+	if err != nil {
+		return "", err
+	}
+	if archive != nil {
+		return fmt.Sprintf("group %s is archived, restore it first", groupname), nil
+	}
+	return "", nil
+}
+
+// renameGroupHandler renames a group, it is used by the admins.
+func (state *RuntimeState) renameGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("renameGroupHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	groupname := r.PostFormValue("groupname")
+	newname := strings.TrimSpace(r.PostFormValue("newname"))
+	message, err := state.checkNewGroupName(groupname, newname)
+	if err != nil {
+		requestLogger(r).Error("renameGroupHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	err = state.requestUserinfo(r).RenameGroup(groupname, newname)
+	if err != nil {
+		requestLogger(r).Error("renameGroupHandler failed", "err", err)
+		state.recordAuditEvent(r, username, auditActionRenameGroup, groupname, "", auditOutcomeFailure, err.Error())
+		state.writeFailureResponse(w, r, "cannot rename the group", http.StatusInternalServerError)
+		return
+	}
+	err = renameGroupReferencesInDB(groupname, newname, username, state)
+	if err != nil {
+		// the group is renamed, the references must be fixed by hand
+		requestLogger(r).Error("cannot rename the references of the group", "group", groupname, "new_name", newname, "err", err)
+		state.recordAuditEvent(r, username, auditActionRenameGroup, newname, "", auditOutcomeFailure,
+			"renamed from "+groupname+", references not renamed: "+err.Error())
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.recordAuditEvent(r, username, auditActionRenameGroup, newname, "", auditOutcomeSuccess,
+		"renamed from "+groupname)
+	if state.sysLog != nil {
+		state.sysLog.Write([]byte(fmt.Sprintf("Group %s was renamed to %s by %s", groupname, newname, username)))
+	}
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        true,
+		Title:          "Group Renamed",
+		SuccessMessage: fmt.Sprintf("Group %s was renamed to %s", groupname, newname),
+		ContinueURL:    groupinfoPath + "?groupname=" + newname,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestRenameGroup(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range []userinfo.GroupInfo{
+		{Groupname: "rename-old", Description: "self-managed", MemberUid: []string{"user2"}},
+		{Groupname: "rename-managed", Description: "rename-old", MemberUid: []string{"user3"}},
+	} {
+		err = state.Userinfo.CreateGroup(group)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = setGroupTagsInDB("rename-old", []string{"renamed"}, "user1", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.recordAuditEvent(nil, "user1", auditActionCreateGroup, "rename-old", "", auditOutcomeSuccess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec(insertSCIMPushStmt[state.dbType], "downstream", "rename-old", "user2", "add", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := testPostServiceAccountForm(t, &state, renameGroupPath, state.renameGroupHandler, false,
+		url.Values{"groupname": {"rename-old"}, "newname": {"rename-new"}})
+	if code != http.StatusForbidden {
+		t.Fatalf("only admins rename groups, got %d", code)
+	}
+	for _, newname := range []string{"group1", "bad name", "rename-old"} {
+		code = testPostServiceAccountForm(t, &state, renameGroupPath, state.renameGroupHandler, true,
+			url.Values{"groupname": {"rename-old"}, "newname": {newname}})
+		if code != http.StatusBadRequest {
+			t.Errorf("renaming to '%s' should fail, got %d", newname, code)
+		}
+	}
+	code = testPostServiceAccountForm(t, &state, renameGroupPath, state.renameGroupHandler, true,
+		url.Values{"groupname": {"rename-old"}, "newname": {"rename-new"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot rename the group, got %d", code)
+	}
+
+	members, _, err := state.Userinfo.GetusersofaGroup("rename-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, managedBy, err := state.Userinfo.GetusersofaGroup("rename-managed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, []string{"user2"}) || managedBy != "rename-new" {
+		t.Fatalf("bad renamed group members %v, managed group managed by %s", members, managedBy)
+	}
+	tags, err := getGroupTagsFromDB("rename-new", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"renamed"}) {
+		t.Fatalf("tags not renamed %v", tags)
+	}
+	var queuedGroup string
+	err = state.db.QueryRow("select groupname from scim_push_queue where target='downstream';").Scan(&queuedGroup)
+	if err != nil || queuedGroup != "rename-new" {
+		t.Fatalf("the queued SCIM push is for %q, err %v", queuedGroup, err)
+	}
+	formerNames, err := state.getGroupFormerNames("rename-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := searchAuditEventsInDB(auditEventFilter{Groupname: "rename-new", FormerGroupnames: formerNames},
+		&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, event := range events {
+		actions = append(actions, event.Action)
+	}
+	if !reflect.DeepEqual(actions, []string{auditActionRenameGroup, auditActionCreateGroup}) {
+		t.Fatalf("bad audit events of the renamed group %v", actions)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// groupSyncDelay is how long the membership changes of a group are
+// collected before the group is synced to GitHub, Okta and AWS.
+const groupSyncDelay = 5 * time.Second
+
+// groupSyncQueue coalesces the membership changes of each group, a bulk
+// change of a group syncs it once instead of once per member.
+type groupSyncQueue struct {
+	delay time.Duration
+	sync  func(groupname string)
+
+	mutex   sync.Mutex
+	pending map[string]*time.Timer
+}
+
+func newGroupSyncQueue(delay time.Duration, sync func(groupname string)) *groupSyncQueue {
+	return &groupSyncQueue{delay: delay, sync: sync, pending: make(map[string]*time.Timer)}
+}
+
+// changed schedules the sync of the group, the pending sync of the group
+// is pushed back instead when there is one.
+func (q *groupSyncQueue) changed(groupname string) {
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	// a timer that cannot be stopped is already syncing, the change may
+	// have been missed so a new sync is scheduled
+	if timer, ok := q.pending[groupname]; ok && timer.Stop() {
+		timer.Reset(q.delay)
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(q.delay, func() {
+		q.mutex.Lock()
+		if q.pending[groupname] == timer {
+			delete(q.pending, groupname)
+		}
+		q.mutex.Unlock()
+		q.sync(groupname)
+	})
+	q.pending[groupname] = timer
+}
+
+// syncGroupDownstream syncs the group to the systems it is mirrored to.
+func (state *RuntimeState) syncGroupDownstream(groupname string) {
+	state.githubTeamMembershipChanged(groupname)
+	state.oktaMembershipChanged(groupname)
+	state.awsIdentityCenterMembershipChanged(groupname)
+}
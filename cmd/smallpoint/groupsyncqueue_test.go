@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupSyncQueue(t *testing.T) {
+	var mutex sync.Mutex
+	synced := make(map[string]int)
+	queue := newGroupSyncQueue(50*time.Millisecond, func(groupname string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		synced[groupname]++
+	})
+	for i := 0; i < 20; i++ {
+		queue.changed("group1")
+	}
+	queue.changed("group2")
+	time.Sleep(200 * time.Millisecond)
+	mutex.Lock()
+	if synced["group1"] != 1 || synced["group2"] != 1 {
+		t.Fatalf("the changes should be synced once per group, got %v", synced)
+	}
+	mutex.Unlock()
+
+	queue.changed("group1")
+	time.Sleep(200 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if synced["group1"] != 2 {
+		t.Fatalf("a later change should sync again, got %v", synced)
+	}
+
+	var nilQueue *groupSyncQueue
+	nilQueue.changed("group1")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// Tags such as "prod-access" or "mailing-list" categorize groups so that
+// users can browse the groups of a category. Tags are kept in the local DB.
+
+const (
+	groupTagMaxLength = 64
+	maxTagsPerGroup   = 16
+)
+
+var groupTagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+var getGroupTagsStmt = map[string]string{
+	"sqlite":   "select tag from group_tags where groupname=? order by tag;",
+	"postgres": "select tag from group_tags where groupname=$1 order by tag;",
+}
+
+var getGroupsWithTagStmt = map[string]string{
+	"sqlite":   "select groupname from group_tags where tag=? order by groupname;",
+	"postgres": "select groupname from group_tags where tag=$1 order by groupname;",
+}
+
+const getGroupTagCountsStmt = "select tag, count(*) from group_tags group by tag order by tag;"
+
+var setGroupTagsStmts = map[string][]string{
+	"sqlite": {"delete from group_tags where groupname=?;",
+		"insert into group_tags(groupname, tag, updated_by, time_stamp) values (?,?,?,?);"},
+	"postgres": {"delete from group_tags where groupname=$1;",
+		"insert into group_tags(groupname, tag, updated_by, time_stamp) values ($1,$2,$3,$4);"},
+}
+
+type groupTagCount struct {
+	Tag    string
+	Groups int
+}
+
+func queryStringsFromDB(state *RuntimeState, stmtText string, args ...interface{}) ([]string, error) {
+	start := time.Now()
+	rows, err := state.db.Query(stmtText, args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var values []string
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+func getGroupTagsFromDB(groupname string, state *RuntimeState) ([]string, error) {
+	return queryStringsFromDB(state, getGroupTagsStmt[state.dbType], groupname)
+}
+
+func getGroupsWithTagFromDB(tag string, state *RuntimeState) ([]string, error) {
+	return queryStringsFromDB(state, getGroupsWithTagStmt[state.dbType], tag)
+}
+
+func getGroupTagCountsFromDB(state *RuntimeState) ([]groupTagCount, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getGroupTagCountsStmt)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var counts []groupTagCount
+	for rows.Next() {
+		var count groupTagCount
+		err = rows.Scan(&count.Tag, &count.Groups)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+func setGroupTagsInDB(groupname string, tags []string, username string, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := setGroupTagsStmts[state.dbType]
+	_, err = tx.Exec(stmts[0], groupname)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, tag := range tags {
+		_, err = tx.Exec(stmts[1], groupname, tag, username, now)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// parseGroupTags splits a comma or space separated list of tags, it returns
+// the sorted tags without duplicates and a message for the user when a tag
+// is invalid.
+func parseGroupTags(text string) ([]string, string) {
+	tagSet := make(map[string]bool)
+	for _, tag := range strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return c == ',' || c == ' ' || c == '\t' || c == '\n' || c == '\r'
+	}) {
+		if len(tag) > groupTagMaxLength || !groupTagPattern.MatchString(tag) {
+			return nil, fmt.Sprintf("invalid tag '%s', tags use lowercase letters, digits, '_', '.' and '-'", tag)
+		}
+		tagSet[tag] = true
+	}
+	if len(tagSet) > maxTagsPerGroup {
+		return nil, fmt.Sprintf("a group can have at most %d tags", maxTagsPerGroup)
+	}
+	tags := make([]string, 0, len(tagSet))
+	for tag := range tagSet {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, ""
+}
+
+func (state *RuntimeState) getGroupsWithTag(tag string) (map[string]bool, error) {
+	taggedGroups, err := getGroupsWithTagFromDB(tag, state)
+	if err != nil {
+		return nil, err
+	}
+	tagged := make(map[string]bool)
+	for _, groupname := range taggedGroups {
+		tagged[groupname] = true
+	}
+	return tagged, nil
+}
+
+// filterGroupTuples keeps the tuples of the groups to keep, the first element
+// of each tuple is the group name.
+func filterGroupTuples(groups [][]string, keep func(string) bool) [][]string {
+	filtered := [][]string{}
+	for _, group := range groups {
+		if len(group) > 0 && keep(group[0]) {
+			filtered = append(filtered, group)
+		}
+	}
+	return filtered
+}
+
+func filterGroupNames(groupnames []string, keep func(string) bool) []string {
+	filtered := []string{}
+	for _, groupname := range groupnames {
+		if keep(groupname) {
+			filtered = append(filtered, groupname)
+		}
+	}
+	return filtered
+}
+
+// groupTagsHandler replaces the tags of a group, it is used by the group
+// owners.
+func (state *RuntimeState) groupTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("groupTagsHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	groupname := r.PostFormValue("groupname")
+	groupExists, _, err := state.requestUserinfo(r).GroupnameExistsornot(groupname)
+	if err != nil {
+		requestLogger(r).Error("groupTagsHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !groupExists {
+		state.writeFailureResponse(w, r, "group "+groupname+" does not exist", http.StatusBadRequest)
+		return
+	}
+	isGroupAdmin, err := state.isGroupAdmin(username, groupname)
+	if err != nil {
+		requestLogger(r).Error("groupTagsHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !isGroupAdmin {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	tags, message := parseGroupTags(r.PostFormValue("tags"))
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	err = setGroupTagsInDB(groupname, tags, username, state)
+	if err != nil {
+		requestLogger(r).Error("groupTagsHandler failed", "err", err)
+		state.recordAuditEvent(r, username, auditActionSetGroupTags, groupname, "", auditOutcomeFailure, err.Error())
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.recordAuditEvent(r, username, auditActionSetGroupTags, groupname, "", auditOutcomeSuccess,
+		"tags "+strings.Join(tags, ","))
+	message = fmt.Sprintf("Group %s has no tags", groupname)
+	if len(tags) > 0 {
+		message = fmt.Sprintf("Group %s is tagged %s", groupname, strings.Join(tags, ", "))
+	}
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:          "Group Tags Updated",
+		SuccessMessage: message,
+		ContinueURL:    groupinfoPath + "?groupname=" + groupname,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func testGetTaggedGroups(t *testing.T, state *RuntimeState, groupType string, tag string) [][]string {
+	req, err := http.NewRequest("GET", getGroupsJSPath+"?"+url.Values{"type": {groupType}, "encoding": {"json"},
+		"tag": {tag}}.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.getGroupsJSHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("getGroups.js failed with %d", rr.Code)
+	}
+	var groups groupsJSONData
+	err = json.Unmarshal(rr.Body.Bytes(), &groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return groups.Groups
+}
+
+func TestParseGroupTags(t *testing.T) {
+	tags, message := parseGroupTags("prod-access, Mailing-List\tprod-access,,")
+	if message != "" || !reflect.DeepEqual(tags, []string{"mailing-list", "prod-access"}) {
+		t.Fatalf("bad tags %v %s", tags, message)
+	}
+	for _, text := range []string{"bad!", "-leading", "a b c d e f g h i j k l m n o p q"} {
+		_, message = parseGroupTags(text)
+		if message == "" {
+			t.Errorf("tags '%s' should be invalid", text)
+		}
+	}
+}
+
+func TestGroupTags(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := testPostServiceAccountForm(t, &state, groupTagsPath, state.groupTagsHandler, false,
+		url.Values{"groupname": {"group3"}, "tags": {"prod-access deprecated"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot tag group3, got %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, groupTagsPath, state.groupTagsHandler, false,
+		url.Values{"groupname": {"group3"}, "tags": {"bad!"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("invalid tag should fail, got %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, groupTagsPath, state.groupTagsHandler, false,
+		url.Values{"groupname": {"nogroup"}, "tags": {"prod-access"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("tagging a missing group should fail, got %d", code)
+	}
+	tags, err := getGroupTagsFromDB("group3", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"deprecated", "prod-access"}) {
+		t.Fatalf("bad tags %v", tags)
+	}
+
+	groups := testGetTaggedGroups(t, &state, "all", "deprecated")
+	if len(groups) != 1 || groups[0][0] != "group3" {
+		t.Fatalf("bad tagged groups %v", groups)
+	}
+	groups = testGetTaggedGroups(t, &state, "allNoManager", "deprecated")
+	if !reflect.DeepEqual(groups, [][]string{{"group3"}}) {
+		t.Fatalf("bad tagged groups %v", groups)
+	}
+	groups = testGetTaggedGroups(t, &state, "all", "unused")
+	if len(groups) != 0 {
+		t.Fatalf("bad tagged groups %v", groups)
+	}
+}
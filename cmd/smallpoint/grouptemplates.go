@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// Group templates are defined by the admins to provision groups of the same
+// kind consistently. Creating a group from a template adds the template
+// members, defaults the managing group and allocates the gidNumber from the
+// template range. The description and tags of the template are copied to the
+// group metadata and tags.
+
+var groupTemplateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+type groupTemplate struct {
+	Name        string
+	Description string
+	// ManagedBy is the default managing group, empty lets the creator
+	// choose.
+	ManagedBy string
+	Members   []string
+	Tags      []string
+	// Groups get a gidNumber in [GidMin, GidMax] when both are set.
+	GidMin    int
+	GidMax    int
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
+const groupTemplateColumns = "name, description, managed_by, members, tags, gid_min, gid_max, updated_by, time_stamp"
+
+var getGroupTemplateStmt = map[string]string{
+	"sqlite":   "select " + groupTemplateColumns + " from group_templates where name=?;",
+	"postgres": "select " + groupTemplateColumns + " from group_templates where name=$1;",
+}
+
+const getAllGroupTemplatesStmt = "select " + groupTemplateColumns + " from group_templates order by name;"
+
+var setGroupTemplateStmts = map[string][]string{
+	"sqlite": {"delete from group_templates where name=?;",
+		"insert into group_templates(" + groupTemplateColumns + ") values (?,?,?,?,?,?,?,?,?);"},
+	"postgres": {"delete from group_templates where name=$1;",
+		"insert into group_templates(" + groupTemplateColumns + ") values ($1,$2,$3,$4,$5,$6,$7,$8,$9);"},
+}
+
+var deleteGroupTemplateStmt = map[string]string{
+	"sqlite":   "delete from group_templates where name=?;",
+	"postgres": "delete from group_templates where name=$1;",
+}
+
+func scanGroupTemplate(row sqlRowScanner) (groupTemplate, error) {
+	var template groupTemplate
+	var members, tags string
+	var timeStamp int64
+	err := row.Scan(&template.Name, &template.Description, &template.ManagedBy, &members, &tags,
+		&template.GidMin, &template.GidMax, &template.UpdatedBy, &timeStamp)
+	template.Members = strings.Fields(members)
+	template.Tags = strings.Fields(tags)
+	template.UpdatedAt = time.Unix(timeStamp, 0)
+	return template, err
+}
+
+func getGroupTemplateFromDB(name string, state *RuntimeState) (groupTemplate, error) {
+	start := time.Now()
+	template, err := scanGroupTemplate(state.db.QueryRow(getGroupTemplateStmt[state.dbType], name))
+	if err != nil {
+		return template, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return template, nil
+}
+
+func getAllGroupTemplatesFromDB(state *RuntimeState) ([]groupTemplate, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getAllGroupTemplatesStmt)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var templates []groupTemplate
+	for rows.Next() {
+		template, err := scanGroupTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+func setGroupTemplateInDB(template groupTemplate, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := setGroupTemplateStmts[state.dbType]
+	_, err = tx.Exec(stmts[0], template.Name)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(stmts[1], template.Name, template.Description, template.ManagedBy,
+		strings.Join(template.Members, " "), strings.Join(template.Tags, " "), template.GidMin, template.GidMax,
+		template.UpdatedBy, template.UpdatedAt.Unix())
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// groupTemplateFromForm returns the template and a message for the user when
+// the template is invalid.
+func (state *RuntimeState) groupTemplateFromForm(r *http.Request) (groupTemplate, string, error) {
+	template := groupTemplate{
+		Name:        strings.TrimSpace(r.PostFormValue("name")),
+		Description: strings.TrimSpace(r.PostFormValue("description")),
+		ManagedBy:   strings.TrimSpace(r.PostFormValue("managedBy")),
+		Members:     strings.FieldsFunc(r.PostFormValue("members"), func(c rune) bool { return c == ',' || c == ' ' }),
+	}
+	if !groupTemplateNamePattern.MatchString(template.Name) {
+		return template, fmt.Sprintf("invalid template name '%s'", template.Name), nil
+	}
+	if len(template.Description) > groupMetadataMaxLength {
+		return template, fmt.Sprintf("description is longer than %d characters", groupMetadataMaxLength), nil
+	}
+	var message string
+	template.Tags, message = parseGroupTags(r.PostFormValue("tags"))
+	if message != "" {
+		return template, message, nil
+	}
+	if template.ManagedBy != "" && template.ManagedBy != descriptionAttribute {
+		exists, _, err := state.requestUserinfo(r).GroupnameExistsornot(template.ManagedBy)
+		if err != nil {
+			return template, "", err
+		}
+		if !exists {
+			return template, fmt.Sprintf("managing group %s does not exist", template.ManagedBy), nil
+		}
+	}
+	for _, member := range template.Members {
+		exists, err := state.requestUserinfo(r).UsernameExistsornot(member)
+		if err != nil {
+			return template, "", err
+		}
+		if !exists {
+			return template, fmt.Sprintf("user %s does not exist", member), nil
+		}
+	}
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{{"gidMin", &template.GidMin}, {"gidMax", &template.GidMax}} {
+		text := strings.TrimSpace(r.PostFormValue(field.name))
+		if text == "" {
+			continue
+		}
+		value, err := strconv.Atoi(text)
+		if err != nil || value <= 0 {
+			return template, fmt.Sprintf("invalid %s '%s'", field.name, text), nil
+		}
+		*field.value = value
+	}
+	if (template.GidMin == 0) != (template.GidMax == 0) || template.GidMin > template.GidMax {
+		return template, "the gidNumber range needs a minimum lower than the maximum", nil
+	}
+	return template, "", nil
+}
+
+// groupTemplatesHandler lists the group templates, admins save or delete a
+// template with a POST.
+func (state *RuntimeState) groupTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case getMethod:
+	case postMethod:
+		err = r.ParseForm()
+		if err != nil {
+			requestLogger(r).Error("groupTemplatesHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		switch r.PostFormValue("action") {
+		case "save":
+			template, message, err := state.groupTemplateFromForm(r)
+			if err != nil {
+				requestLogger(r).Error("groupTemplatesHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			if message != "" {
+				state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+				return
+			}
+			template.UpdatedBy = username
+			template.UpdatedAt = time.Now()
+			err = setGroupTemplateInDB(template, state)
+			if err != nil {
+				requestLogger(r).Error("groupTemplatesHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			state.recordAuditEvent(r, username, auditActionUpdateGroupTemplate, "", "", auditOutcomeSuccess,
+				"saved template "+template.Name)
+		case "delete":
+			name := r.PostFormValue("name")
+			err = execServiceAccountUpdate(state, deleteGroupTemplateStmt[state.dbType], name)
+			if err != nil {
+				requestLogger(r).Error("groupTemplatesHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			state.recordAuditEvent(r, username, auditActionUpdateGroupTemplate, "", "", auditOutcomeSuccess,
+				"deleted template "+name)
+		default:
+			state.writeFailureResponse(w, r, "action must be save or delete", http.StatusBadRequest)
+			return
+		}
+	default:
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	templates, err := getAllGroupTemplatesFromDB(state)
+	if err != nil {
+		requestLogger(r).Error("groupTemplatesHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	pageData := groupTemplatesPageData{
+		UserName:  username,
+		IsAdmin:   true,
+		Title:     "Group Templates",
+		Templates: templates,
+	}
+	state.renderTemplateOrReturnJson(w, r, "groupTemplatesPage", pageData)
+}
+
+// getRequestedGroupTemplate returns nil when no template is requested.
+func (state *RuntimeState) getRequestedGroupTemplate(name string) (*groupTemplate, string, error) {
+	if name == "" {
+		return nil, "", nil
+	}
+	template, err := getGroupTemplateFromDB(name, state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Sprintf("group template %s does not exist", name), nil
+		}
+		return nil, "", err
+	}
+	return &template, "", nil
+}
+
+// applyGroupTemplateMetadata copies the description and the tags of the
+// template to a group created from it.
+func (state *RuntimeState) applyGroupTemplateMetadata(groupname string, template *groupTemplate, username string) error {
+	if template.Description != "" {
+		err := setGroupMetadataInDB(groupname, groupMetadata{Description: template.Description,
+			UpdatedBy: username, UpdatedAt: time.Now()}, state)
+		if err != nil {
+			return err
+		}
+	}
+	if len(template.Tags) > 0 {
+		return setGroupTagsInDB(groupname, template.Tags, username, state)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestGroupTemplates(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the create group page warms up the caches in the background
+	useSynchronizedDirectory(&state)
+	template := url.Values{"action": {"save"}, "name": {"team"}, "managedBy": {"group1"},
+		"members": {"user3"}, "gidMin": {"30000"}, "gidMax": {"30010"}, "tags": {"team"},
+		"description": {"Team group"}}
+	code := testPostServiceAccountForm(t, &state, groupTemplatesPath, state.groupTemplatesHandler, false, template)
+	if code != http.StatusForbidden {
+		t.Fatalf("only admins define templates, got %d", code)
+	}
+	for field, value := range map[string]string{"name": "bad name", "managedBy": "nogroup",
+		"members": "user3,nouser", "gidMin": "30011", "gidMax": "", "tags": "bad!"} {
+		invalid := url.Values{}
+		for k, v := range template {
+			invalid[k] = v
+		}
+		invalid.Set(field, value)
+		code = testPostServiceAccountForm(t, &state, groupTemplatesPath, state.groupTemplatesHandler, true, invalid)
+		if code != http.StatusBadRequest {
+			t.Errorf("invalid %s should fail, got %d", field, code)
+		}
+	}
+	code = testPostServiceAccountForm(t, &state, groupTemplatesPath, state.groupTemplatesHandler, true, template)
+	if code != http.StatusOK {
+		t.Fatalf("cannot save the template, got %d", code)
+	}
+
+	req, err := http.NewRequest("GET", creategroupWebPagePath+"?template=team", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidAdminCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.creategroupWebpageHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create group page failed with %d", rr.Code)
+	}
+
+	code = testPostServiceAccountForm(t, &state, creategroupPath, state.createGrouphandler, true,
+		url.Values{"groupname": {"team_alpha"}, "members": {"user2"}, "template": {"missing"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("missing template should fail, got %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, creategroupPath, state.createGrouphandler, true,
+		url.Values{"groupname": {"team_alpha"}, "members": {"user2,user3"}, "template": {"team"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot create the group from the template, got %d", code)
+	}
+	members, managedBy, err := state.Userinfo.GetusersofaGroup("team_alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, []string{"user2", "user3"}) || managedBy != "group1" {
+		t.Fatalf("bad group members %v managed by %s", members, managedBy)
+	}
+	used, err := state.Userinfo.GetUsedGidNumbers(30000, 30010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(used, map[int]bool{30000: true}) {
+		t.Fatalf("the group did not get a gidNumber from the template range, used %v", used)
+	}
+	tags, err := getGroupTagsFromDB("team_alpha", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := getGroupMetadataFromDB("team_alpha", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"team"}) || metadata.Description != "Team group" {
+		t.Fatalf("template not applied, tags %v metadata %+v", tags, metadata)
+	}
+}
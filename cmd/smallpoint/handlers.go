@@ -5,14 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Symantec/ldap-group-management/lib/userinfo"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/Symantec/keymaster/lib/instrumentedwriter"
 )
 
 const postMethod = "POST"
@@ -29,15 +28,15 @@ func checkCSRF(w http.ResponseWriter, r *http.Request) (bool, error) {
 		}
 		referer := r.Referer()
 		if len(referer) > 0 && len(r.Host) > 0 {
-			log.Printf("ref =%s, host=%s", referer, r.Host)
+			requestLogger(r).Debug("checking the referer", "referer", referer, "host", r.Host)
 			refererURL, err := url.Parse(referer)
 			if err != nil {
-				log.Println(err)
+				requestLogger(r).Error("checkCSRF failed", "err", err)
 				return false, err
 			}
-			log.Println(3, "refHost =%s, host=%s", refererURL.Host, r.Host)
+			requestLogger(r).Debug("checking the referer", "referer_host", refererURL.Host, "host", r.Host)
 			if refererURL.Host != r.Host {
-				log.Printf("CSRF detected.... rejecting with a 400")
+				requestLogger(r).Warn("CSRF detected, rejecting with a 400")
 				http.Error(w, "you are not authorized", http.StatusUnauthorized)
 				err := errors.New("CSRF detected... rejecting")
 				return false, err
@@ -48,23 +47,11 @@ func checkCSRF(w http.ResponseWriter, r *http.Request) (bool, error) {
 	return true, nil
 }
 
-func setSecurityHeaders(w http.ResponseWriter) {
-	//all common security headers go here
-	w.Header().Set("Strict-Transport-Security", "max-age=1209600")
-	w.Header().Set("X-Frame-Options", "DENY")
-	w.Header().Set("X-XSS-Protection", "1")
-	w.Header().Set("Content-Security-Policy",
-		"default-src 'self';"+
-			" script-src 'self' cdn.datatables.net maxcdn.bootstrapcdn.com code.jquery.com; "+
-			" style-src 'self' cdn.datatables.net maxcdn.bootstrapcdn.com cdnjs.cloudflare.com fonts.googleapis.com 'unsafe-inline';"+
-			" font-src cdnjs.cloudflare.com fonts.gstatic.com fonts.googleapis.com maxcdn.bootstrapcdn.com;"+
-			" img-src 'self' cdn.datatables.net")
-}
-
 func (state *RuntimeState) writeFailureResponse(w http.ResponseWriter, r *http.Request, message string, code int) {
 	pageData := simpleMessagePageData{
 		Title:        "Error",
 		ErrorMessage: fmt.Sprintf("%d %s. %s\n", code, http.StatusText(code), message),
+		RequestID:    getRequestID(r),
 	}
 	if code == 404 {
 		pageData.ContinueURL = "/"
@@ -78,7 +65,7 @@ func (state *RuntimeState) defaultPathHandler(w http.ResponseWriter, r *http.Req
 
 	if r.URL.Path == "/favicon.ico" {
 		w.Header().Set("Cache-Control", "public, max-age=120")
-		http.Redirect(w, r, "/images/favicon.ico", http.StatusFound)
+		http.Redirect(w, r, state.staticAssets.url("/images/favicon.ico"), http.StatusFound)
 		return
 	}
 
@@ -104,21 +91,23 @@ func (state *RuntimeState) createUserorNot(username string) error {
 	}
 	found, err := state.Userinfo.UsernameExistsornot(username)
 	if err != nil {
-		log.Println(err)
+		slog.Error("createUserorNot failed", "err", err)
 		return err
 	}
 
 	if !found {
 		email, givenName, err := state.UserSourceinfo.GetUserAttributes(username)
 		if err != nil {
-			log.Println(err)
+			slog.Error("createUserorNot failed", "err", err)
 			return err
 		}
 		err = state.Userinfo.CreateUser(username, givenName, email)
 		if err != nil {
-			log.Println(err)
+			slog.Error("createUserorNot failed", "err", err)
+			state.recordAuditEvent(nil, username, auditActionCreateUser, "", username, auditOutcomeFailure, err.Error())
 			return err
 		}
+		state.recordAuditEvent(nil, username, auditActionCreateUser, "", username, auditOutcomeSuccess, "")
 	}
 	state.allUsersRWLock.Lock()
 	state.allUsersCacheValue[username] = time.Now().Add(allUsersCacheDuration)
@@ -126,17 +115,10 @@ func (state *RuntimeState) createUserorNot(username string) error {
 	return nil
 }
 
-func setLoggerUsername(w http.ResponseWriter, authUser string) {
-	_, ok := w.(*instrumentedwriter.LoggingWriter)
-	if ok {
-		w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
-	}
-}
-
 func (state *RuntimeState) GetRemoteUserName(w http.ResponseWriter, r *http.Request) (string, error) {
 	_, err := checkCSRF(w, r)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("GetRemoteUserName failed", "err", err)
 		if err != errCSRFToRootRedirected {
 			http.Error(w, fmt.Sprint(err), http.StatusUnauthorized)
 		}
@@ -146,12 +128,15 @@ func (state *RuntimeState) GetRemoteUserName(w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		return "", err
 	}
-	setLoggerUsername(w, username)
+	err = state.checkCSRFToken(w, r)
+	if err != nil {
+		return "", err
+	}
 
 	//TODO: add test case for it
 	err = state.createUserorNot(username)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("GetRemoteUserName failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return "", err
 	}
@@ -164,11 +149,21 @@ func (state *RuntimeState) allGroupsHandler(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		return
 	}
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
+	tagCounts, err := getGroupTagCountsFromDB(state)
+	if err != nil {
+		requestLogger(r).Error("allGroupsHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := allGroupsPageData{
 		UserName: username,
 		IsAdmin:  isAdmin,
 		Title:    "All Groups",
+		Tag:      strings.TrimSpace(r.URL.Query().Get("tag")),
+		Tags:     tagCounts,
+
+		DirectorySync: state.directorySyncStatus(),
 	}
 	state.renderTemplateOrReturnJson(w, r, "allGroupsPage", pageData)
 	return
@@ -181,18 +176,19 @@ func (state *RuntimeState) mygroupsHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
-	setSecurityHeaders(w)
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	w.Header().Set("Cache-Control", "private, max-age=30")
 	pageData := myGroupsPageData{
 		UserName:  username,
 		IsAdmin:   isAdmin,
 		Title:     "My Groups",
 		JSSources: []string{"/getGroups.js"},
+
+		DirectorySync: state.directorySyncStatus(),
 	}
-	err = state.htmlTemplate.ExecuteTemplate(w, "myGroupsPage", pageData)
+	err = state.executeTemplate(w, "myGroupsPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -205,18 +201,19 @@ func (state *RuntimeState) myManagedGroupsHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
-	setSecurityHeaders(w)
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	w.Header().Set("Cache-Control", "private, max-age=30")
 	pageData := myGroupsPageData{
 		UserName:  username,
 		IsAdmin:   isAdmin,
 		Title:     "My Managed Groups",
 		JSSources: []string{"/getGroups.js?type=managedByMe"},
+
+		DirectorySync: state.directorySyncStatus(),
 	}
-	err = state.htmlTemplate.ExecuteTemplate(w, "myGroupsPage", pageData)
+	err = state.executeTemplate(w, "myGroupsPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -228,35 +225,44 @@ func (state *RuntimeState) getPendingRequestGroupsofUser(username string) ([][]s
 	go state.cleanupPendingRequests()
 	groupsPendingInDB, _, err := findrequestsofUserinDB(username, state)
 	if err != nil {
-		log.Println(err)
+		slog.Error("getPendingRequestGroupsofUser failed", "err", err)
 		return nil, err
 	}
-	log.Printf("groupsPendingInDB=%+v", groupsPendingInDB)
+	slog.Debug("pending groups in db", "groups", groupsPendingInDB)
 	if len(groupsPendingInDB) == 0 {
 		return [][]string{}, nil
 	}
 
 	userGroups, err := state.Userinfo.GetgroupsofUser(username)
 	if err != nil {
-		log.Printf("getPendingRequestGroupsofUser, GetgroupsofUser, err:%s", err)
+		slog.Error("GetgroupsofUser failed", "err", err)
+		return nil, err
+	}
+	isMember := make(map[string]bool)
+	for _, userGroup := range userGroups {
+		isMember[userGroup] = true
+	}
+	// the managers come from the cached listing, not from a lookup per
+	// request
+	allGroups, err := state.Userinfo.GetAllGroupsManagedBy()
+	if err != nil {
+		slog.Error("GetAllGroupsManagedBy failed", "err", err)
 		return nil, err
 	}
-	var actualPendingGroups []string
-	var found bool
+	group2manager := make(map[string]string)
+	for _, entry := range allGroups {
+		group2manager[entry[0]] = entry[1]
+	}
+	actualPendingGroups := [][]string{}
 	for _, requestedGroupName := range groupsPendingInDB {
-		found = false
-		for _, userGroup := range userGroups {
-			if requestedGroupName == userGroup {
-				found = true
-			}
-			break
-		}
-		if !found {
-			actualPendingGroups = append(actualPendingGroups, requestedGroupName)
+		managerGroup, ok := group2manager[requestedGroupName]
+		if !ok || isMember[requestedGroupName] {
+			continue
 		}
+		actualPendingGroups = append(actualPendingGroups, []string{requestedGroupName, managerGroup})
 	}
-	log.Printf("actualPendingGroups =%+v, len=%d", actualPendingGroups, len(actualPendingGroups))
-	return state.Userinfo.GetGroupandManagedbyAttributeValue(actualPendingGroups)
+	slog.Debug("actual pending groups", "groups", actualPendingGroups)
+	return actualPendingGroups, nil
 
 }
 
@@ -266,25 +272,24 @@ func (state *RuntimeState) pendingRequests(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		return
 	}
-	go state.Userinfo.GetAllGroupsManagedBy() // warm up cache
+	go state.requestUserinfo(r).GetAllGroupsManagedBy() // warm up cache
 	_, hasRequests, err := findrequestsofUserinDB(username, state)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("pendingRequests failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := pendingRequestsPageData{
 		UserName:           username,
 		IsAdmin:            isAdmin,
 		Title:              "Pending Group Requests",
 		HasPendingRequests: hasRequests,
 	}
-	setSecurityHeaders(w)
 	w.Header().Set("Cache-Control", "private, max-age=30")
-	err = state.htmlTemplate.ExecuteTemplate(w, "pendingRequestsPage", pageData)
+	err = state.executeTemplate(w, "pendingRequestsPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -298,20 +303,37 @@ func (state *RuntimeState) creategroupWebpageHandler(w http.ResponseWriter, r *h
 	}
 
 	// next two lines warm up cache
-	go state.Userinfo.GetallUsers()
-	go state.Userinfo.GetallGroups()
+	go state.requestUserinfo(r).GetallUsers()
+	go state.requestUserinfo(r).GetallGroups()
 
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
+	templates, err := getAllGroupTemplatesFromDB(state)
+	if err != nil {
+		requestLogger(r).Error("creategroupWebpageHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	template, message, err := state.getRequestedGroupTemplate(r.URL.Query().Get("template"))
+	if err != nil {
+		requestLogger(r).Error("creategroupWebpageHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := createGroupPageData{
-		UserName: username,
-		IsAdmin:  isAdmin,
-		Title:    "Create Group",
+		UserName:  username,
+		IsAdmin:   isAdmin,
+		Title:     "Create Group",
+		Templates: templates,
+		Template:  template,
 	}
-	setSecurityHeaders(w)
 	w.Header().Set("Cache-Control", "private, max-age=30")
-	err = state.htmlTemplate.ExecuteTemplate(w, "createGroupPage", pageData)
+	err = state.executeTemplate(w, "createGroupPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -322,18 +344,17 @@ func (state *RuntimeState) deletegroupWebpageHandler(w http.ResponseWriter, r *h
 	if err != nil {
 		return
 	}
-	go state.Userinfo.GetallGroups() //cache warmup
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
+	go state.requestUserinfo(r).GetallGroups() //cache warmup
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := deleteGroupPageData{
 		UserName: username,
 		IsAdmin:  isAdmin,
 		Title:    "Delete Group",
 	}
-	setSecurityHeaders(w)
 	w.Header().Set("Cache-Control", "private, max-age=30")
-	err = state.htmlTemplate.ExecuteTemplate(w, "deleteGroupPage", pageData)
+	err = state.executeTemplate(w, "deleteGroupPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -350,14 +371,14 @@ func (state *RuntimeState) requestAccessHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	userExistsornot, err := state.Userinfo.UsernameExistsornot(username)
+	userExistsornot, err := state.requestUserinfo(r).UsernameExistsornot(username)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("requestAccessHandler failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
 	if !userExistsornot {
-		log.Println("requestAccessHandler: Bad request! user does not exist")
+		requestLogger(r).Info("Bad request, user does not exist")
 		http.Error(w, fmt.Sprint("Bad request, user does not exist!"), http.StatusBadRequest)
 		return
 	}
@@ -365,7 +386,7 @@ func (state *RuntimeState) requestAccessHandler(w http.ResponseWriter, r *http.R
 	var out map[string][]string
 	err = json.NewDecoder(r.Body).Decode(&out)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("requestAccessHandler failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
@@ -376,16 +397,44 @@ func (state *RuntimeState) requestAccessHandler(w http.ResponseWriter, r *http.R
 		if err != nil {
 			return
 		}
+		message, err := state.checkGroupClassification(entry, []string{username})
+		if err != nil {
+			requestLogger(r).Error("requestAccessHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
+		if message != "" {
+			http.Error(w, message, http.StatusBadRequest)
+			return
+		}
+	}
+	// the justification is also kept in the audit log as the details of the
+	// request
+	justification := strings.TrimSpace(strings.Join(out["justification"], "\n"))
+	ticketID, message := state.requestedTicketID(strings.Join(out["ticket"], ""))
+	if message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
 	}
-	err = insertRequestInDB(username, out["groups"], state)
+	err = insertRequestInDB(username, out["groups"], justification, state)
 	if err != nil {
-		log.Printf("requestAccessHandler: Error inserting request into DB err:: %s", err)
+		requestLogger(r).Error("Error inserting request into DB", "err", err)
+		for _, entry := range out["groups"] {
+			state.recordAuditEvent(r, username, auditActionRequestAccess, entry, username, auditOutcomeFailure, err.Error())
+		}
 		http.Error(w, "oops! an error occured.", http.StatusInternalServerError)
 		return
 	}
+	err = state.linkAccessRequestTickets(r, username, out["groups"], ticketID)
+	if err != nil {
+		requestLogger(r).Error("cannot link the requests to the ticket", "ticket", ticketID, "err", err)
+	}
+	for _, entry := range out["groups"] {
+		state.recordAuditEvent(r, username, auditActionRequestAccess, entry, username, auditOutcomeSuccess, justification)
+	}
 	go state.SendRequestemail(username, out["groups"], r.RemoteAddr, r.UserAgent())
 
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := simpleMessagePageData{
 		UserName:       username,
 		IsAdmin:        isAdmin,
@@ -405,27 +454,27 @@ func (state *RuntimeState) deleteRequests(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		return
 	}
-	userExistsornot, err := state.Userinfo.UsernameExistsornot(username)
+	userExistsornot, err := state.requestUserinfo(r).UsernameExistsornot(username)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("deleteRequests failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
 	if !userExistsornot {
-		log.Println("Bad request!")
+		requestLogger(r).Info("Bad request")
 		http.Error(w, fmt.Sprint("Bad request!"), http.StatusBadRequest)
 		return
 	}
 	var out map[string][]string
 	err = json.NewDecoder(r.Body).Decode(&out)
 	if err != nil {
-		log.Print(err)
+		requestLogger(r).Error("deleteRequests failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
 	_, ok := out["groups"]
 	if !ok {
-		log.Println("Bad request, missing required JSON attributes")
+		requestLogger(r).Info("Bad request, missing required JSON attributes")
 		http.Error(w, fmt.Sprint("Bad request!, Bad request, missing required JSON attributes"), http.StatusBadRequest)
 		return
 	}
@@ -437,12 +486,14 @@ func (state *RuntimeState) deleteRequests(w http.ResponseWriter, r *http.Request
 	}
 
 	for _, entry := range out["groups"] {
-		err = deleteEntryInDB(username, entry, state)
+		err = closeRequestInDB(username, entry, requestStateCancelled, username, "", state)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("deleteRequests failed", "err", err)
+			state.recordAuditEvent(r, username, auditActionCancelRequest, entry, username, auditOutcomeFailure, err.Error())
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
+		state.recordAuditEvent(r, username, auditActionCancelRequest, entry, username, auditOutcomeSuccess, "")
 	}
 	w.WriteHeader(http.StatusOK)
 }
@@ -459,14 +510,14 @@ func (state *RuntimeState) exitfromGroup(w http.ResponseWriter, r *http.Request)
 	var out map[string][]string
 	err = json.NewDecoder(r.Body).Decode(&out)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("exitfromGroup failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 
 	}
 	_, ok := out["groups"]
 	if !ok {
-		log.Println("Bad request, missing required JSON attributes")
+		requestLogger(r).Info("Bad request, missing required JSON attributes")
 		http.Error(w, fmt.Sprint("Bad request!, Bad request, missing required JSON attributes"), http.StatusBadRequest)
 		return
 	}
@@ -477,9 +528,9 @@ func (state *RuntimeState) exitfromGroup(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	for _, entry := range out["groups"] {
-		IsgroupMember, _, err := state.Userinfo.IsgroupmemberorNot(entry, username)
+		IsgroupMember, _, err := state.requestUserinfo(r).IsgroupmemberorNot(entry, username)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("exitfromGroup failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -492,46 +543,83 @@ func (state *RuntimeState) exitfromGroup(w http.ResponseWriter, r *http.Request)
 	groupinfo.MemberUid = append(groupinfo.MemberUid, username)
 	for _, entry := range out["groups"] {
 		groupinfo.Groupname = entry
-		err = state.Userinfo.DeletemembersfromGroup(groupinfo)
+		err = state.requestUserinfo(r).DeletemembersfromGroup(groupinfo)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("exitfromGroup failed", "err", err)
+			state.recordAuditEvent(r, username, auditActionExitGroup, entry, username, auditOutcomeFailure, err.Error())
 			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
 			return
 		}
 		if state.sysLog != nil {
 			state.sysLog.Write([]byte(fmt.Sprintf("%s"+" exited from Group "+"%s", username, entry)))
 		}
+		state.recordAuditEvent(r, username, auditActionExitGroup, entry, username, auditOutcomeSuccess, "")
 	}
 	w.WriteHeader(http.StatusOK)
 
 }
 
+const pendingRequestsCleanupInterval = time.Second * 30
+
+// cleanupPendingRequests drops the requests of the users that joined the
+// group and the requests for deleted groups. The pending pages start it on
+// every load, it runs at most once per pendingRequestsCleanupInterval and
+// looks up the members of every requested group once.
 func (state *RuntimeState) cleanupPendingRequests() error {
+	state.pendingRequestsCleanupMutex.Lock()
+	if time.Since(state.pendingRequestsCleanupStart) < pendingRequestsCleanupInterval {
+		state.pendingRequestsCleanupMutex.Unlock()
+		return nil
+	}
+	state.pendingRequestsCleanupStart = time.Now()
+	state.pendingRequestsCleanupMutex.Unlock()
+
 	DBentries, err := getDBentries(state)
 	if err != nil {
-		log.Printf("getUserPendingActions: getDBEntries err: %s", err)
+		slog.Error("getDBEntries failed", "err", err)
 		return err
 	}
+	requestingUsers := make(map[string][]string)
+	var groupNames []string
 	for _, entry := range DBentries {
-		//log.Printf("cleanupPendingRequests: top of loop entry=%+v", entry)
 		groupName := entry[1]
-		requestingUser := entry[0]
-		invalidGroup := false
-		Ismember, _, err := state.Userinfo.IsgroupmemberorNot(groupName, requestingUser)
+		if _, ok := requestingUsers[groupName]; !ok {
+			groupNames = append(groupNames, groupName)
+		}
+		requestingUsers[groupName] = append(requestingUsers[groupName], entry[0])
+	}
+	groupMembers := make([]map[string]bool, len(groupNames))
+	invalidGroups := make([]bool, len(groupNames))
+	runGroupQueries(groupNames, state.Config.Base.ldapQueryConcurrency(), func(index int, groupName string) error {
+		members, _, err := state.Userinfo.GetusersofaGroup(groupName)
 		if err != nil {
 			if err != userinfo.GroupDoesNotExist {
-				log.Printf("getUserPendingActions: isggroupmemberor not err: %s", err)
-				continue
+				slog.Error("GetusersofaGroup failed", "err", err)
+				return nil
 			}
-			invalidGroup = true
+			invalidGroups[index] = true
+			return nil
+		}
+		groupMembers[index] = make(map[string]bool)
+		for _, member := range members {
+			groupMembers[index][member] = true
 		}
-		if Ismember || invalidGroup {
-			err := deleteEntryInDB(requestingUser, groupName, state)
+		return nil
+	})
+	for index, groupName := range groupNames {
+		for _, requestingUser := range requestingUsers[groupName] {
+			if !invalidGroups[index] && !groupMembers[index][requestingUser] {
+				continue
+			}
+			comment := "already a member of the group"
+			if invalidGroups[index] {
+				comment = "the group does not exist"
+			}
+			err := closeRequestInDB(requestingUser, groupName, requestStateExpired, "smallpoint", comment, state)
 			if err != nil {
-				log.Println(err)
+				slog.Error("cleanupPendingRequests failed", "err", err)
 				return err
 			}
-			continue
 		}
 	}
 	return nil
@@ -577,16 +665,16 @@ func (state *RuntimeState) getUserPendingActionsNonCached(username string) ([][]
 
 	c := make(chan error)
 
-	var DBentries [][]string
-	go func(c chan error, DBentries *[][]string) {
+	var requests []accessRequest
+	go func(c chan error, requests *[]accessRequest) {
 		var err error
-		*DBentries, err = getDBentries(state)
+		*requests, err = searchAccessRequestsInDB(accessRequestFilter{State: requestStatePending}, state)
 		if err != nil {
-			log.Printf("getUserPendingActions: getDBEntries err: %s", err)
+			slog.Error("searchAccessRequestsInDB failed", "err", err)
 			c <- err
 		}
 		c <- nil
-	}(c, &DBentries)
+	}(c, &requests)
 
 	var userGroups []string
 	go func(c chan error, userGroups *[]string) {
@@ -618,10 +706,11 @@ func (state *RuntimeState) getUserPendingActionsNonCached(username string) ([][]
 		group2manager[entry[0]] = entry[1]
 	}
 
+	// entry: [username groupname requested_at justification]
 	var rvalue [][]string
-	for _, entry := range DBentries {
+	for _, request := range requests {
 		//log.Printf("getUserPendingActions: top of loop entry=%+v", entry)
-		groupName := entry[1]
+		groupName := request.Groupname
 		//requestingUser := entry[0]
 		//fmt.Println(groupName)
 		managerGroup := group2manager[groupName]
@@ -638,7 +727,8 @@ func (state *RuntimeState) getUserPendingActionsNonCached(username string) ([][]
 			continue
 		}
 
-		rvalue = append(rvalue, entry)
+		rvalue = append(rvalue, []string{request.Username, groupName,
+			request.CreatedAt.Format(auditDateLayout), request.Justification})
 
 	}
 	return rvalue, nil
@@ -650,27 +740,26 @@ func (state *RuntimeState) pendingActions(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		return
 	}
-	go state.Userinfo.GetAllGroupsManagedBy() //warm up cache
+	go state.requestUserinfo(r).GetAllGroupsManagedBy() //warm up cache
 	//DBentries, err := getDBentries(state)
 	userPendingActions, err := state.getUserPendingActions(username)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("pendingActions failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
 
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := pendingActionsPageData{
 		UserName:          username,
 		IsAdmin:           isAdmin,
 		Title:             "Pending Group Requests",
 		HasPendingActions: len(userPendingActions) > 0,
 	}
-	setSecurityHeaders(w)
 	w.Header().Set("Cache-Control", "private, max-age=30")
-	err = state.htmlTemplate.ExecuteTemplate(w, "pendingActionsPage", pageData)
+	err = state.executeTemplate(w, "pendingActionsPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -687,46 +776,59 @@ func (state *RuntimeState) approveHandler(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		return
 	}
-	var out map[string][][]string
+	var out requestDecision
 	err = json.NewDecoder(r.Body).Decode(&out)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("approveHandler failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
 
-	//log.Println(out["groups"])//[[username1,groupname1][username2,groupname2]]
-	userPair, ok := out["groups"]
-	if !ok {
-		log.Println("Bad request, missing required JSON attributes")
+	//log.Println(out.Groups)//[[username1,groupname1][username2,groupname2]]
+	userPair := out.Groups
+	if userPair == nil || !out.validPairs() {
+		requestLogger(r).Info("Bad request, missing required JSON attributes")
 		http.Error(w, fmt.Sprint("Bad request!, Bad request, missing required JSON attributes"), http.StatusBadRequest)
 		return
 	}
 	//entry:[username1 groupname1]
 
-	//check [username1 groupname1] exists or not
+	//check [username1 groupname1] exists or not, the requests of a batch
+	//target a few groups and every user and group is checked once
+	checkedUsers := make(map[string]bool)
+	requestingUsers := make(map[string][]string)
+	var requestedGroups []string
 	for _, entry := range userPair {
 		requestingUser := entry[0]
 		requestedGroup := entry[1]
-		userExistsornot, err := state.Userinfo.UsernameExistsornot(requestingUser)
+		if _, ok := requestingUsers[requestedGroup]; !ok {
+			requestedGroups = append(requestedGroups, requestedGroup)
+		}
+		requestingUsers[requestedGroup] = append(requestingUsers[requestedGroup], requestingUser)
+		if checkedUsers[requestingUser] {
+			continue
+		}
+		userExistsornot, err := state.requestUserinfo(r).UsernameExistsornot(requestingUser)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("approveHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
 		if !userExistsornot {
-			log.Println("Bad request!")
+			requestLogger(r).Info("Bad request")
 			http.Error(w, fmt.Sprint("Bad request!"), http.StatusBadRequest)
 			return
 		}
-		log.Printf("after user exists check")
+		checkedUsers[requestingUser] = true
+	}
+	for _, requestedGroup := range requestedGroups {
 		err = state.groupExistsorNot(w, requestedGroup)
 		if err != nil {
 			return
 		}
-		IsgroupAdmin, err := state.Userinfo.IsgroupAdminorNot(authUser, requestedGroup)
+		IsgroupAdmin, err := state.requestUserinfo(r).IsgroupAdminorNot(authUser, requestedGroup)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("approveHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -734,21 +836,46 @@ func (state *RuntimeState) approveHandler(w http.ResponseWriter, r *http.Request
 			http.Error(w, fmt.Sprint("Bad request!"), http.StatusBadRequest)
 			return
 		}
+		// the classification may have changed since the request was made
+		message, err := state.checkGroupClassification(requestedGroup, requestingUsers[requestedGroup])
+		if err != nil {
+			requestLogger(r).Error("approveHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+			return
+		}
+		if message != "" {
+			http.Error(w, message, http.StatusBadRequest)
+			return
+		}
+		if message := state.checkSelfApproval(r, authUser, requestedGroup, requestingUsers[requestedGroup]); message != "" {
+			http.Error(w, message, http.StatusForbidden)
+			return
+		}
 	}
 	//entry:[user group]
+	groupMembers := make(map[string]map[string]bool)
 	for _, entry := range userPair {
 		requestingUser := entry[0]
 		requestedGroup := entry[1]
-		log.Printf("Loop2: requestingUser =%s requestedGroup=%s", requestingUser, requestedGroup)
-		Isgroupmember, _, err := state.Userinfo.IsgroupmemberorNot(entry[1], requestingUser)
-		if err != nil {
-			log.Println(err)
+		requestLogger(r).Debug("approving the request", "requesting_user", requestingUser, "group", requestedGroup)
+		members, ok := groupMembers[requestedGroup]
+		if !ok {
+			members = make(map[string]bool)
+			users, _, err := state.requestUserinfo(r).GetusersofaGroup(requestedGroup)
+			if err != nil {
+				requestLogger(r).Error("approveHandler failed", "err", err)
+			}
+			for _, user := range users {
+				members[user] = true
+			}
+			groupMembers[requestedGroup] = members
 		}
-		if Isgroupmember {
-			err = deleteEntryInDB(requestingUser, requestedGroup, state)
+		if members[requestingUser] {
+			err = closeRequestInDB(requestingUser, requestedGroup, requestStateApproved, authUser,
+				"already a member of the group", state)
 			if err != nil {
 				//fmt.Println("error me")
-				log.Println(err)
+				requestLogger(r).Error("approveHandler failed", "err", err)
 			}
 			continue
 
@@ -756,22 +883,25 @@ func (state *RuntimeState) approveHandler(w http.ResponseWriter, r *http.Request
 		var groupinfo userinfo.GroupInfo
 		groupinfo.Groupname = requestedGroup
 		groupinfo.MemberUid = append(groupinfo.MemberUid, requestingUser)
-		err = state.Userinfo.AddmemberstoExisting(groupinfo)
+		err = state.requestUserinfo(r).AddmemberstoExisting(groupinfo)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("approveHandler failed", "err", err)
+			state.recordAuditEvent(r, authUser, auditActionApproveRequest, requestedGroup, requestingUser, auditOutcomeFailure, err.Error())
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
 		if state.sysLog != nil {
 			state.sysLog.Write([]byte(fmt.Sprintf("%s"+" joined Group "+"%s"+" approved by "+"%s", requestingUser, requestedGroup, authUser)))
 		}
-		err = deleteEntryInDB(requestingUser, requestedGroup, state)
+		state.recordAuditEvent(r, authUser, auditActionApproveRequest, requestedGroup, requestingUser, auditOutcomeSuccess, out.Comment)
+		members[requestingUser] = true
+		err = closeRequestInDB(requestingUser, requestedGroup, requestStateApproved, authUser, out.Comment, state)
 		if err != nil {
 			fmt.Println("error here!")
-			log.Println(err)
+			requestLogger(r).Error("approveHandler failed", "err", err)
 		}
 	}
-	go state.sendApproveemail(authUser, out["groups"], r.RemoteAddr, r.UserAgent())
+	go state.sendApproveemail(authUser, out.Groups, r.RemoteAddr, r.UserAgent())
 	w.WriteHeader(http.StatusOK)
 
 }
@@ -786,24 +916,28 @@ func (state *RuntimeState) rejectHandler(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return
 	}
-	var out map[string][][]string
+	var out requestDecision
 	err = json.NewDecoder(r.Body).Decode(&out)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("rejectHandler failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
-	_, ok := out["groups"]
-	if !ok {
-		log.Println("Bad request, missing required JSON attributes")
+	if out.Groups == nil || !out.validPairs() {
+		requestLogger(r).Info("Bad request, missing required JSON attributes")
 		http.Error(w, fmt.Sprint("Bad request!, Bad request, missing required JSON attributes"), http.StatusBadRequest)
 		return
 	}
 	//this handler just deletes requests from the DB, so check if the user is authorized to reject or not.
-	for _, entry := range out["groups"] {
-		IsgroupAdmin, err := state.Userinfo.IsgroupAdminorNot(username, entry[1])
+	checkedGroups := make(map[string]bool)
+	for _, entry := range out.Groups {
+		if checkedGroups[entry[1]] {
+			continue
+		}
+		checkedGroups[entry[1]] = true
+		IsgroupAdmin, err := state.requestUserinfo(r).IsgroupAdminorNot(username, entry[1])
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("rejectHandler failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -813,26 +947,28 @@ func (state *RuntimeState) rejectHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	//check if the entry still exists or not.
-	for _, entry := range out["groups"] {
+	for _, entry := range out.Groups {
 		entryExists := entryExistsorNot(entry[0], entry[1], state)
 		if !entryExists {
-			log.Println("entry doesn't exist!")
+			requestLogger(r).Info("entry doesn't exist")
 			http.Error(w, fmt.Sprintf("%s doesn't exist in DB! Refresh your page!", entry), http.StatusInternalServerError)
 			return
 		}
 	}
-	for _, entry := range out["groups"] {
+	for _, entry := range out.Groups {
 		//fmt.Println(entry[0], entry[1])
-		err = deleteEntryInDB(entry[0], entry[1], state)
+		err = closeRequestInDB(entry[0], entry[1], requestStateDenied, username, out.Comment, state)
 		if err != nil {
 			//fmt.Println("I am the error")
-			log.Println(err)
+			requestLogger(r).Error("rejectHandler failed", "err", err)
+			state.recordAuditEvent(r, username, auditActionRejectRequest, entry[1], entry[0], auditOutcomeFailure, err.Error())
 			http.Error(w, fmt.Sprintf("error occurred while process request of %s", entry), http.StatusInternalServerError)
 			return
 
 		}
+		state.recordAuditEvent(r, username, auditActionRejectRequest, entry[1], entry[0], auditOutcomeSuccess, out.Comment)
 	}
-	go state.sendRejectemail(username, out["groups"], r.RemoteAddr, r.UserAgent())
+	go state.sendRejectemail(username, out.Groups, r.RemoteAddr, r.UserAgent())
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -842,19 +978,18 @@ func (state *RuntimeState) addmemberstoGroupWebpageHandler(w http.ResponseWriter
 		return
 	}
 	// warm up caches
-	go state.Userinfo.GetallUsers()
-	go state.Userinfo.GetallGroups()
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
+	go state.requestUserinfo(r).GetallUsers()
+	go state.requestUserinfo(r).GetallGroups()
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := addMembersToGroupPagData{
 		UserName: username,
 		IsAdmin:  isAdmin,
 		Title:    "Add Members To Group",
 	}
-	setSecurityHeaders(w)
 	w.Header().Set("Cache-Control", "private, max-age=30")
-	err = state.htmlTemplate.ExecuteTemplate(w, "addMembersToGroupPage", pageData)
+	err = state.executeTemplate(w, "addMembersToGroupPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -873,7 +1008,7 @@ func (state *RuntimeState) addmemberstoExistingGroup(w http.ResponseWriter, r *h
 
 	err = r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("addmemberstoExistingGroup failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
@@ -888,32 +1023,32 @@ func (state *RuntimeState) addmemberstoExistingGroup(w http.ResponseWriter, r *h
 	}
 	isAdmin, err := state.isGroupAdmin(username, groupinfo.Groupname)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("addmemberstoExistingGroup failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
 	if !isAdmin {
-		log.Printf("User %s is not admin for group %s ", username, groupinfo.Groupname)
+		requestLogger(r).Warn("user is not admin of the group", "username", username, "group", groupinfo.Groupname)
 		http.Error(w, "Not authorized", http.StatusForbidden)
 		return
 	}
 
 	//check if given member exists or not and see if he is already a groupmember if yes continue.
 	for _, member := range strings.Split(members, ",") {
-		userExistsornot, err := state.Userinfo.UsernameExistsornot(member)
+		userExistsornot, err := state.requestUserinfo(r).UsernameExistsornot(member)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("addmemberstoExistingGroup failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
 		if !userExistsornot {
-			log.Println("Bad request!")
+			requestLogger(r).Info("Bad request")
 			http.Error(w, fmt.Sprint("Bad request! Username doesn't exist!", member), http.StatusBadRequest)
 			return
 		}
-		IsgroupMember, _, err := state.Userinfo.IsgroupmemberorNot(groupinfo.Groupname, member)
+		IsgroupMember, _, err := state.requestUserinfo(r).IsgroupmemberorNot(groupinfo.Groupname, member)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("addmemberstoExistingGroup failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -922,22 +1057,48 @@ func (state *RuntimeState) addmemberstoExistingGroup(w http.ResponseWriter, r *h
 		}
 		groupinfo.MemberUid = append(groupinfo.MemberUid, member)
 	}
+	message, err := state.checkGroupClassification(groupinfo.Groupname, groupinfo.MemberUid)
+	if err != nil {
+		requestLogger(r).Error("addmemberstoExistingGroup failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+		return
+	}
+	held, err := state.holdPrivilegeEscalation(r, username, groupinfo.Groupname, groupinfo.MemberUid, nil)
+	if err != nil {
+		requestLogger(r).Error("addmemberstoExistingGroup failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+	if held {
+		state.writeHeldChangeResponse(w, r, username, groupinfo.Groupname)
+		return
+	}
 
 	if len(groupinfo.MemberUid) > 0 {
-		err = state.Userinfo.AddmemberstoExisting(groupinfo)
+		err = state.requestUserinfo(r).AddmemberstoExisting(groupinfo)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("addmemberstoExistingGroup failed", "err", err)
+			for _, member := range groupinfo.MemberUid {
+				state.recordAuditEvent(r, username, auditActionAddMember, groupinfo.Groupname, member, auditOutcomeFailure, err.Error())
+			}
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
 	}
+	for _, member := range groupinfo.MemberUid {
+		state.recordAuditEvent(r, username, auditActionAddMember, groupinfo.Groupname, member, auditOutcomeSuccess, "")
+	}
 	if state.sysLog != nil {
 		for _, member := range strings.Split(members, ",") {
 			state.sysLog.Write([]byte(fmt.Sprintf("%s"+" was added to Group "+"%s"+" by "+"%s", member, groupinfo.Groupname, username)))
 		}
 	}
 
-	isGlobalAdmin := state.Userinfo.UserisadminOrNot(username)
+	isGlobalAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := simpleMessagePageData{
 		UserName:       username,
 		IsAdmin:        isGlobalAdmin,
@@ -953,17 +1114,16 @@ func (state *RuntimeState) deletemembersfromGroupWebpageHandler(w http.ResponseW
 	if err != nil {
 		return
 	}
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := deleteMembersFromGroupPageData{
 		UserName: username,
 		IsAdmin:  isAdmin,
 		Title:    "Delete Memebers From Group",
 	}
-	setSecurityHeaders(w)
 	w.Header().Set("Cache-Control", "private, max-age=30")
-	err = state.htmlTemplate.ExecuteTemplate(w, "deleteMembersFromGroupPage", pageData)
+	err = state.executeTemplate(w, "deleteMembersFromGroupPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -981,7 +1141,7 @@ func (state *RuntimeState) deletemembersfromExistingGroup(w http.ResponseWriter,
 
 	err = r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("deletemembersfromExistingGroup failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
@@ -991,26 +1151,25 @@ func (state *RuntimeState) deletemembersfromExistingGroup(w http.ResponseWriter,
 	members := r.PostFormValue("members")
 	////// TODO: @SLR9511: why is done this way?... please revisit
 	if members == "" {
-		log.Printf("no members")
-		isAdmin := state.Userinfo.UserisadminOrNot(username)
+		requestLogger(r).Debug("no members")
+		isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 		pageData := deleteMembersFromGroupPageData{
 			UserName:  username,
 			IsAdmin:   isAdmin,
 			GroupName: groupinfo.Groupname,
 			Title:     "Delete Memebers From Group",
 		}
-		setSecurityHeaders(w)
 		w.Header().Set("Cache-Control", "private, max-age=30")
-		err = state.htmlTemplate.ExecuteTemplate(w, "deleteMembersFromGroupPage", pageData)
+		err = state.executeTemplate(w, "deleteMembersFromGroupPage", pageData)
 		if err != nil {
-			log.Printf("Failed to execute %v", err)
+			requestLogger(r).Error("Failed to execute the template", "err", err)
 			http.Error(w, "error", http.StatusInternalServerError)
 			return
 		}
 		return
 	}
-	log.Println("delete these members", members)
-	log.Println("now continue")
+	requestLogger(r).Debug("delete these members", "members", members)
+	requestLogger(r).Debug("now continue")
 	//check if groupname given by user exists or not
 	err = state.groupExistsorNot(w, groupinfo.Groupname)
 	if err != nil {
@@ -1018,31 +1177,34 @@ func (state *RuntimeState) deletemembersfromExistingGroup(w http.ResponseWriter,
 	}
 	isAdmin, err := state.isGroupAdmin(username, groupinfo.Groupname)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("deletemembersfromExistingGroup failed", "err", err)
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
 	if !isAdmin {
-		log.Printf("Unauthorized")
+		requestLogger(r).Warn("Unauthorized")
 		http.Error(w, fmt.Sprint(err), http.StatusForbidden)
 		return
 	}
+	if !state.requireRecentAuthentication(w, r) {
+		return
+	}
 
 	for _, member := range strings.Split(members, ",") {
-		userExistsornot, err := state.Userinfo.UsernameExistsornot(member)
+		userExistsornot, err := state.requestUserinfo(r).UsernameExistsornot(member)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("deletemembersfromExistingGroup failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
 		if !userExistsornot {
-			log.Println("Bad request!")
+			requestLogger(r).Info("Bad request")
 			http.Error(w, fmt.Sprint("Bad request! Check if the usernames exists or not!"), http.StatusBadRequest)
 			return
 		}
-		IsgroupMember, _, err := state.Userinfo.IsgroupmemberorNot(groupinfo.Groupname, member)
+		IsgroupMember, _, err := state.requestUserinfo(r).IsgroupmemberorNot(groupinfo.Groupname, member)
 		if err != nil {
-			log.Println(err)
+			requestLogger(r).Error("deletemembersfromExistingGroup failed", "err", err)
 			http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 			return
 		}
@@ -1051,10 +1213,23 @@ func (state *RuntimeState) deletemembersfromExistingGroup(w http.ResponseWriter,
 		}
 		groupinfo.MemberUid = append(groupinfo.MemberUid, member)
 	}
+	held, err := state.holdPrivilegeEscalation(r, username, groupinfo.Groupname, nil, groupinfo.MemberUid)
+	if err != nil {
+		requestLogger(r).Error("deletemembersfromExistingGroup failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+	if held {
+		state.writeHeldChangeResponse(w, r, username, groupinfo.Groupname)
+		return
+	}
 
-	err = state.Userinfo.DeletemembersfromGroup(groupinfo)
+	err = state.requestUserinfo(r).DeletemembersfromGroup(groupinfo)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("deletemembersfromExistingGroup failed", "err", err)
+		for _, member := range groupinfo.MemberUid {
+			state.recordAuditEvent(r, username, auditActionRemoveMember, groupinfo.Groupname, member, auditOutcomeFailure, err.Error())
+		}
 		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
@@ -1063,13 +1238,27 @@ func (state *RuntimeState) deletemembersfromExistingGroup(w http.ResponseWriter,
 			state.sysLog.Write([]byte(fmt.Sprintf("%s was deleted from Group %s by %s", member, groupinfo.Groupname, username)))
 		}
 	}
-	isGlobalAdmin := state.Userinfo.UserisadminOrNot(username)
+	for _, member := range groupinfo.MemberUid {
+		state.recordAuditEvent(r, username, auditActionRemoveMember, groupinfo.Groupname, member, auditOutcomeSuccess, "")
+	}
+	successMessage := "Selected Members have been successfully deleted from the group"
+	continueURL := groupinfoPath + "?groupname=" + groupinfo.Groupname
+	// the members are removed already, failing to record the removals
+	// only loses the undo
+	undoUntil, err := state.recordMembershipRemovals(groupinfo.Groupname, groupinfo.MemberUid, username)
+	if err != nil {
+		requestLogger(r).Error("cannot record the membership removals", "err", err)
+	} else if len(groupinfo.MemberUid) > 0 {
+		successMessage += fmt.Sprintf(", the removal can be undone until %s", undoUntil.UTC().Format("2006-01-02 15:04 MST"))
+		continueURL = membershipUndoPath + "?groupname=" + url.QueryEscape(groupinfo.Groupname)
+	}
+	isGlobalAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := simpleMessagePageData{
 		UserName:       username,
 		IsAdmin:        isGlobalAdmin,
 		Title:          "Members Successfully Deleted",
-		SuccessMessage: "Selected Members have been successfully deleted from the group",
-		ContinueURL:    groupinfoPath + "?groupname=" + groupinfo.Groupname,
+		SuccessMessage: successMessage,
+		ContinueURL:    continueURL,
 	}
 	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
 }
@@ -1079,21 +1268,18 @@ func (state *RuntimeState) createserviceAccountPageHandler(w http.ResponseWriter
 	if err != nil {
 		return
 	}
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
-	if !isAdmin {
-		http.Error(w, "you are not authorized", http.StatusForbidden)
-		return
-	}
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	pageData := createServiceAccountPageData{
-		UserName: username,
-		IsAdmin:  isAdmin,
-		Title:    "Create Service Account",
+		UserName:    username,
+		IsAdmin:     isAdmin,
+		Title:       "Create Service Account",
+		ReviewDays:  state.Config.ServiceAccounts.reviewPeriod(),
+		NamingRules: state.Config.ServiceAccounts.Naming.describe(),
 	}
-	setSecurityHeaders(w)
 	w.Header().Set("Cache-Control", "private, max-age=30")
-	err = state.htmlTemplate.ExecuteTemplate(w, "createServiceAccountPage", pageData)
+	err = state.executeTemplate(w, "createServiceAccountPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -1102,7 +1288,7 @@ func (state *RuntimeState) createserviceAccountPageHandler(w http.ResponseWriter
 func (state *RuntimeState) groupExistsorNot(w http.ResponseWriter, groupname string) error {
 	GroupExistsornot, _, err := state.Userinfo.GroupnameExistsornot(groupname)
 	if err != nil {
-		log.Println(err)
+		slog.Error("groupExistsorNot failed", "err", err)
 		if err == userinfo.GroupDoesNotExist {
 			http.Error(w, fmt.Sprint("Bad request!"), http.StatusBadRequest)
 		} else {
@@ -1111,7 +1297,7 @@ func (state *RuntimeState) groupExistsorNot(w http.ResponseWriter, groupname str
 		return err
 	}
 	if !GroupExistsornot {
-		log.Println("Bad request!")
+		slog.Info("Bad request")
 		http.Error(w, fmt.Sprint("Bad request!"), http.StatusBadRequest)
 		return err
 	}
@@ -1141,19 +1327,19 @@ func (state *RuntimeState) groupInfoWebpage(w http.ResponseWriter, r *http.Reque
 	q := r.URL.Query()
 	params, ok := q["groupname"]
 	if !ok {
-		log.Println("couldn't parse the URL")
+		requestLogger(r).Info("couldn't parse the URL")
 		http.Error(w, "couldn't parse the URL", http.StatusInternalServerError)
 		return
 	}
 
-	go state.Userinfo.GetallUsers() //warm up cache
+	go state.requestUserinfo(r).GetallUsers() //warm up cache
 
 	//var response Response
 
 	groupName := params[0] //username is "cn" Attribute of a User
-	groupMembers, managerMembers, managedby, err := state.Userinfo.GetGroupUsersAndManagers(groupName)
+	groupMembers, managerMembers, managedby, err := state.requestUserinfo(r).GetGroupUsersAndManagers(groupName)
 	if err != nil {
-		log.Println(err)
+		requestLogger(r).Error("groupInfoWebpage failed", "err", err)
 		if err == userinfo.GroupDoesNotExist {
 			http.Error(w, fmt.Sprint("Group doesn't exist!"), http.StatusBadRequest)
 			return
@@ -1176,24 +1362,62 @@ func (state *RuntimeState) groupInfoWebpage(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
-	pageData := groupInfoPageData{
-		UserName:            username,
-		IsAdmin:             isAdmin,
-		Title:               "Group information for group X",
-		IsMember:            IsgroupMember,
-		IsGroupAdmin:        IsgroupAdmin || isAdmin,
-		GroupName:           groupName,
-		GroupManagedbyValue: managedby,
-	}
-	setSecurityHeaders(w)
-	w.Header().Set("Cache-Control", "private, max-age=15")
-	err = state.htmlTemplate.ExecuteTemplate(w, "groupInfoPage", pageData)
+	classification, err := getGroupClassification(groupName, state)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
-		http.Error(w, "error", http.StatusInternalServerError)
+		requestLogger(r).Error("groupInfoWebpage failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+
+	metadata, err := getGroupMetadataFromDB(groupName, state)
+	if err != nil {
+		requestLogger(r).Error("groupInfoWebpage failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+	tags, err := getGroupTagsFromDB(groupName, state)
+	if err != nil {
+		requestLogger(r).Error("groupInfoWebpage failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+	mailAddresses, err := getGroupMailAddressesFromDB(groupName, state)
+	if err != nil {
+		requestLogger(r).Error("groupInfoWebpage failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
 		return
 	}
+	archive, err := getGroupArchiveFromDB(groupName, state)
+	if err != nil {
+		requestLogger(r).Error("groupInfoWebpage failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+
+	owners := append([]string{}, managerMembers...)
+	sort.Strings(owners)
+
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
+	pageData := groupInfoPageData{
+		UserName:             username,
+		IsAdmin:              isAdmin,
+		Title:                "Group information for group X",
+		IsMember:             IsgroupMember,
+		IsGroupAdmin:         IsgroupAdmin || isAdmin,
+		GroupName:            groupName,
+		GroupManagedbyValue:  managedby,
+		Classification:       classification,
+		GroupClassifications: groupClassifications,
+		Metadata:             metadata,
+		Tags:                 tags,
+		Mail:                 mailAddresses,
+		Archive:              archive,
+		DirectorySync:        state.directorySyncStatus(),
+		MemberCount:          len(groupMembers),
+		Owners:               owners,
+	}
+	w.Header().Set("Cache-Control", "private, max-age=15")
+	state.renderTemplateOrReturnJson(w, r, "groupInfoPage", pageData)
 }
 
 func (state *RuntimeState) changeownershipWebpageHandler(w http.ResponseWriter, r *http.Request) {
@@ -1201,7 +1425,7 @@ func (state *RuntimeState) changeownershipWebpageHandler(w http.ResponseWriter,
 	if err != nil {
 		return
 	}
-	isAdmin := state.Userinfo.UserisadminOrNot(username)
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
 	if !isAdmin {
 		http.Error(w, "you are not authorized", http.StatusForbidden)
 		return
@@ -1211,11 +1435,10 @@ func (state *RuntimeState) changeownershipWebpageHandler(w http.ResponseWriter,
 		IsAdmin:  isAdmin,
 		Title:    "Change Group OwnerShip",
 	}
-	setSecurityHeaders(w)
 	w.Header().Set("Cache-Control", "private, max-age=30")
-	err = state.htmlTemplate.ExecuteTemplate(w, "changeGroupOwnershipPage", pageData)
+	err = state.executeTemplate(w, "changeGroupOwnershipPage", pageData)
 	if err != nil {
-		log.Printf("Failed to execute %v", err)
+		requestLogger(r).Error("Failed to execute the template", "err", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
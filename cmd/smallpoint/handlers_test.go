@@ -19,14 +19,15 @@ func TestNonAdminWebPathsHandlerSuccess(t *testing.T) {
 	}
 	validTestGroupInfoPath := groupinfoPath + "?groupname=group1"
 	testWebEndpoints := map[string]http.HandlerFunc{
-		indexPath:                  state.mygroupsHandler,
-		allLDAPgroupsPath:          state.allGroupsHandler,
-		myManagedGroupsWebPagePath: state.myManagedGroupsHandler,
-		pendingactionsPath:         state.pendingActions,
-		pendingrequestsPath:        state.pendingRequests,
-		addmembersPath:             state.addmemberstoGroupWebpageHandler,
-		deletemembersPath:          state.deletemembersfromGroupWebpageHandler,
-		validTestGroupInfoPath:     state.groupInfoWebpage,
+		indexPath:                   state.mygroupsHandler,
+		allLDAPgroupsPath:           state.allGroupsHandler,
+		myManagedGroupsWebPagePath:  state.myManagedGroupsHandler,
+		pendingactionsPath:          state.pendingActions,
+		pendingrequestsPath:         state.pendingRequests,
+		addmembersPath:              state.addmemberstoGroupWebpageHandler,
+		deletemembersPath:           state.deletemembersfromGroupWebpageHandler,
+		validTestGroupInfoPath:      state.groupInfoWebpage,
+		createServiceAccWebPagePath: state.createserviceAccountPageHandler,
 		// The next two should be admin paths, but not now,
 		creategroupWebPagePath: state.creategroupWebpageHandler,
 		deletegroupWebPagePath: state.deletegroupWebpageHandler,
@@ -41,7 +42,7 @@ func TestNonAdminWebPathsHandlerSuccess(t *testing.T) {
 			t.Fatal(err)
 		}
 		//cookie := testCreateValidCookie()
-		req.AddCookie(&cookie)
+		testAddAuthCookie(req, state.authenticator, cookie)
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(testFunc)
@@ -60,7 +61,7 @@ func TestNonAdminWebPathsHandlerSuccess(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		req.AddCookie(&cookie)
+		testAddAuthCookie(req, state.authenticator, cookie)
 		req.Header.Set("Accept", "text/html")
 
 		rr := httptest.NewRecorder()
@@ -99,8 +100,7 @@ func TestAdminOnlyWebPaths(t *testing.T) {
 		log.Println(err)
 	}
 	testWebEndpoints := map[string]http.HandlerFunc{
-		changeownershipPath:         state.changeownershipWebpageHandler,
-		createServiceAccWebPagePath: state.createserviceAccountPageHandler,
+		changeownershipPath: state.changeownershipWebpageHandler,
 	}
 
 	adminCookie := testCreateValidAdminCookie(state.authenticator)
@@ -111,7 +111,7 @@ func TestAdminOnlyWebPaths(t *testing.T) {
 			t.Fatal(err)
 		}
 		//cookie := testCreateValidCookie()
-		req.AddCookie(&adminCookie)
+		testAddAuthCookie(req, state.authenticator, adminCookie)
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(testFunc)
@@ -132,7 +132,7 @@ func TestAdminOnlyWebPaths(t *testing.T) {
 			t.Fatal(err)
 		}
 		//cookie := testCreateValidCookie()
-		req.AddCookie(&cookie)
+		testAddAuthCookie(req, state.authenticator, cookie)
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(testFunc)
@@ -182,7 +182,7 @@ func TestRequestAccessHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidCookie(state.authenticator) //testCreateValidAdminCookie()
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	//This is actually not neded
 	req.Header.Set("Content-Type", "application/json")
 
@@ -202,7 +202,7 @@ func TestRequestAccessHandler(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	delReq.AddCookie(&cookie)
+	testAddAuthCookie(delReq, state.authenticator, cookie)
 	delReq.Header.Set("Content-Type", "application/json")
 
 	rr2 := httptest.NewRecorder()
@@ -233,7 +233,7 @@ func TestAddmemberstoExistingGroupSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidAdminCookie(state.authenticator)
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	rr := httptest.NewRecorder()
@@ -258,7 +258,7 @@ func TestApproveHandler(t *testing.T) {
 		return client, nil
 	}
 	//Need to add a request to the DB
-	err = insertRequestInDB("user2", []string{"group3"}, &state)
+	err = insertRequestInDB("user2", []string{"group3"}, "", &state)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -276,7 +276,7 @@ func TestApproveHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidCookie(state.authenticator) //testCreateValidAdminCookie()
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	//This is actually not neded
 	req.Header.Set("Content-Type", "application/json")
 
@@ -301,7 +301,7 @@ func TestRejectHandler(t *testing.T) {
 		return client, nil
 	}
 	//Need to add a request to the DB
-	err = insertRequestInDB("user2", []string{"group3"}, &state)
+	err = insertRequestInDB("user2", []string{"group3"}, "", &state)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -318,7 +318,7 @@ func TestRejectHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidCookie(state.authenticator) //testCreateValidAdminCookie()
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	//This is actually not neded
 	req.Header.Set("Content-Type", "application/json")
 
@@ -356,7 +356,7 @@ func TestExitfromGroup(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidCookie(state.authenticator) //testCreateValidAdminCookie()
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	//This is actually not neded
 	req.Header.Set("Content-Type", "application/json")
 
@@ -387,7 +387,7 @@ func TestDeletemembersfromExistingGroupMinimal(t *testing.T) {
 		t.Fatal(err)
 	}
 	cookie := testCreateValidAdminCookie(state.authenticator)
-	req.AddCookie(&cookie)
+	testAddAuthCookie(req, state.authenticator, cookie)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(state.deletemembersfromExistingGroup)
@@ -535,3 +535,47 @@ func TestDefaultPathHandler(t *testing.T) {
 	}
 
 }
+
+// FuzzPostJSONHandlers sends the bodies to the handlers of the JSON POST
+// requests, as an admin so that most of the checks are reached.
+func FuzzPostJSONHandlers(f *testing.F) {
+	state, err := setupTestState()
+	if err != nil {
+		f.Fatal(err)
+	}
+	handlers := []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{requestaccessPath, state.requestAccessHandler},
+		{deleterequestsPath, state.deleteRequests},
+		{exitgroupPath, state.exitfromGroup},
+		{approverequestPath, state.approveHandler},
+		{rejectrequestPath, state.rejectHandler},
+	}
+	for _, seed := range []string{
+		`{"groups":["group1"]}`,
+		`{"groups":[["user2","group1"]],"comment":"approved"}`,
+		`{"groups":[["user2"]]}`,
+		`{"groups":[[]]}`,
+		`{"groups":null}`,
+		`{"groups":"group1"}`,
+		`[]`,
+		`{`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	cookie := testCreateValidAdminCookie(state.authenticator)
+	f.Fuzz(func(t *testing.T, body []byte) {
+		for _, h := range handlers {
+			req := httptest.NewRequest(postMethod, h.path, bytes.NewReader(body))
+			testAddAuthCookie(req, state.authenticator, cookie)
+			rr := httptest.NewRecorder()
+			h.handler.ServeHTTP(rr, req)
+			if rr.Code == 0 {
+				t.Fatalf("%s wrote no response", h.path)
+			}
+		}
+	})
+}
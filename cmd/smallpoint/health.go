@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The health endpoints are not authenticated, they are probed by the load
+// balancers and by Kubernetes. healthzPath answers while the process serves
+// requests, readyzPath answers 503 while a dependency is missing so that the
+// instance stops receiving traffic. The errors of the checks are logged, the
+// responses only carry the status of each check.
+
+const readinessCheckTimeout = 5 * time.Second
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readinessChecks returns the checks of the dependencies by name.
+func (state *RuntimeState) readinessChecks() map[string]func(ctx context.Context) error {
+	checks := map[string]func(ctx context.Context) error{
+		"ldap": func(ctx context.Context) error {
+			err := state.Userinfo.Ping()
+			if err != nil {
+				return err
+			}
+			return state.UserSourceinfo.Ping()
+		},
+		"db": func(ctx context.Context) error {
+			return state.pingDB(ctx)
+		},
+		"templates": func(ctx context.Context) error {
+			if state.htmlTemplate == nil {
+				return errors.New("the templates are not loaded")
+			}
+			return nil
+		},
+		"shutdown": func(ctx context.Context) error {
+			if state.shuttingDown.Load() {
+				return errors.New("the server is shutting down")
+			}
+			return nil
+		},
+		"secrets": func(ctx context.Context) error {
+			if state.Config.Base.ClusterSharedSecretFilename != "" && len(state.Config.Base.SharedSecrets) == 0 {
+				return errors.New("the cluster shared secrets are empty")
+			}
+			if state.Config.OpenID.ClientSecret == "" {
+				return errors.New("the OpenID client secret is missing")
+			}
+			if state.Config.TargetLDAP.BindPassword == "" && !state.Config.SQLDirectory.Enabled {
+				return errors.New("the target LDAP bind password is missing")
+			}
+			if err := state.secretsRefreshError(); err != nil {
+				return fmt.Errorf("the secrets refresh failed: %s", err)
+			}
+			return nil
+		},
+	}
+	if state.redisClient != nil {
+		checks["redis"] = func(ctx context.Context) error {
+			return state.redisClient.Ping()
+		}
+	}
+	return checks
+}
+
+func (state *RuntimeState) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod && r.Method != http.MethodHead {
+		http.Error(w, "error", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+func (state *RuntimeState) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod && r.Method != http.MethodHead {
+		http.Error(w, "error", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+	response := readinessResponse{Status: "ok", Checks: make(map[string]string)}
+	for name, check := range state.readinessChecks() {
+		err := check(ctx)
+		if err != nil {
+			requestLogger(r).Warn("readiness check failed", "check", name, "err", err)
+			response.Status = "unavailable"
+			response.Checks[name] = "failed"
+			continue
+		}
+		response.Checks[name] = "ok"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		requestLogger(r).Error("cannot write the readiness response", "err", err)
+	}
+}
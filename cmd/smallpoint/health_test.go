@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+type unreachableUserInfo struct {
+	userinfo.UserInfoBackend
+}
+
+func (unreachableUserInfo) Ping() error {
+	return errors.New("cannot connect to LDAP server")
+}
+
+func TestHealthzHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	state.healthzHandler(rr, httptest.NewRequest(getMethod, healthzPath, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	state.healthzHandler(rr, httptest.NewRequest(postMethod, healthzPath, nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %d of the %s request", rr.Code, postMethod)
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readiness := func() (int, readinessResponse) {
+		rr := httptest.NewRecorder()
+		state.degradedModeHandler(http.HandlerFunc(state.readyzHandler)).ServeHTTP(rr,
+			httptest.NewRequest(getMethod, readyzPath, nil))
+		var response readinessResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatalf("bad response %q: %s", rr.Body.String(), err)
+		}
+		return rr.Code, response
+	}
+	code, response := readiness()
+	if code != http.StatusServiceUnavailable || response.Checks["secrets"] != "failed" {
+		t.Fatalf("the instance without secrets is ready: %d %+v", code, response)
+	}
+
+	state.Config.OpenID.ClientSecret = "secret"
+	state.Config.TargetLDAP.BindPassword = "password"
+	code, response = readiness()
+	if code != http.StatusOK || response.Status != "ok" || len(response.Checks) != 5 {
+		t.Fatalf("the instance is not ready: %d %+v", code, response)
+	}
+
+	state.shuttingDown.Store(true)
+	code, response = readiness()
+	if code != http.StatusServiceUnavailable || response.Checks["shutdown"] != "failed" {
+		t.Fatalf("the instance shutting down is ready: %d %+v", code, response)
+	}
+	state.shuttingDown.Store(false)
+
+	state.Userinfo = unreachableUserInfo{state.Userinfo}
+	code, response = readiness()
+	if code != http.StatusServiceUnavailable || response.Checks["ldap"] != "failed" ||
+		response.Checks["db"] != "ok" {
+		t.Fatalf("the instance without LDAP is ready: %d %+v", code, response)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The HR system posts the hire, transfer and termination events of the
+// employees to the HR webhook, which applies the configured actions with
+// the usual audit events: the hires join the default groups and the groups
+// of their department, the transfers move from the groups of the previous
+// department to the ones of the new department, and the terminations are
+// deprovisioned, removed from every group with their pending requests
+// cancelled. The requests are signed with HMAC-SHA256 in the
+// Smallpoint-Signature header, "t=<unix time>,v1=<hex digest>" where the
+// digest is over "<unix time>.<body>", and each event id is applied once.
+
+const (
+	hrWebhookMaxBodySize  = 64 << 10
+	hrWebhookMaxClockSkew = 5 * time.Minute
+	hrWebhookActor        = "hr:webhook"
+
+	hrEventHire        = "hire"
+	hrEventTransfer    = "transfer"
+	hrEventTermination = "termination"
+)
+
+type hrWebhookConfig struct {
+	// SecretFilename holds the secret shared with the HR system, the
+	// webhook is disabled without it.
+	SecretFilename string `yaml:"secret_filename"`
+	// DefaultGroups are joined by every hire.
+	DefaultGroups []string `yaml:"default_groups"`
+	// DepartmentGroups are the groups of the members of each department.
+	DepartmentGroups map[string][]string `yaml:"department_groups"`
+	// DeprovisionTerminations removes the terminated employees from every
+	// group, otherwise the terminations are only recorded.
+	DeprovisionTerminations bool `yaml:"deprovision_terminations"`
+}
+
+func (config hrWebhookConfig) enabled() bool {
+	return config.SecretFilename != ""
+}
+
+// loadWebhookSecret reads the secret of the HR webhook or of the webhook
+// signing.
+func loadWebhookSecret(filename string) ([]byte, error) {
+	secret, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	secret = []byte(strings.TrimSpace(string(secret)))
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("the webhook secret must have at least 16 characters")
+	}
+	return secret, nil
+}
+
+type hrEvent struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Username           string `json:"username"`
+	Department         string `json:"department"`
+	PreviousDepartment string `json:"previous_department"`
+}
+
+type hrEventResult struct {
+	ID        string   `json:"id"`
+	Duplicate bool     `json:"duplicate,omitempty"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Cancelled []string `json:"cancelled_requests"`
+	// Held are the groups the user joins once another admin approves it.
+	Held []string `json:"held,omitempty"`
+}
+
+var getHREventStmt = map[string]string{
+	"sqlite":   "select event_id from hr_webhook_events where event_id=?;",
+	"postgres": "select event_id from hr_webhook_events where event_id=$1;",
+}
+
+var insertHREventStmt = map[string]string{
+	"sqlite":   "insert into hr_webhook_events(event_id, event_type, username, received_at, details) values (?,?,?,?,?);",
+	"postgres": "insert into hr_webhook_events(event_id, event_type, username, received_at, details) values ($1,$2,$3,$4,$5);",
+}
+
+// verifyHRWebhookSignature checks the signature header of the body.
+func verifyHRWebhookSignature(secret []byte, header string, body []byte, now time.Time) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	unixTime, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return fmt.Errorf("malformed %s header", webhookSignatureHeader)
+	}
+	skew := now.Sub(time.Unix(unixTime, 0))
+	if skew > hrWebhookMaxClockSkew || skew < -hrWebhookMaxClockSkew {
+		return fmt.Errorf("the signature time is too far from the server time")
+	}
+	received, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed %s header", webhookSignatureHeader)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(received, mac.Sum(nil)) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// addHRGroups adds the user to the groups it is not a member of yet.
+func (state *RuntimeState) addHRGroups(r *http.Request, event hrEvent, groupnames []string,
+	result *hrEventResult) error {
+	details := "hr " + event.Type + " " + event.ID
+	for _, groupname := range groupnames {
+		isMember, _, err := state.requestUserinfo(r).IsgroupmemberorNot(groupname, event.Username)
+		if err != nil {
+			return err
+		}
+		if isMember {
+			continue
+		}
+		message, err := state.checkGroupClassification(groupname, []string{event.Username})
+		if err != nil {
+			return err
+		}
+		if message != "" {
+			return fmt.Errorf("cannot add %s to %s: %s", event.Username, groupname, message)
+		}
+		held, err := state.holdPrivilegeEscalation(r, hrWebhookActor, groupname, []string{event.Username}, nil)
+		if err != nil {
+			return err
+		}
+		if held {
+			result.Held = append(result.Held, groupname)
+			continue
+		}
+		err = state.requestUserinfo(r).AddmemberstoExisting(userinfo.GroupInfo{Groupname: groupname,
+			MemberUid: []string{event.Username}})
+		if err != nil {
+			state.recordAuditEvent(r, hrWebhookActor, auditActionAddMember, groupname, event.Username,
+				auditOutcomeFailure, err.Error())
+			return err
+		}
+		state.recordAuditEvent(r, hrWebhookActor, auditActionAddMember, groupname, event.Username,
+			auditOutcomeSuccess, details)
+		result.Added = append(result.Added, groupname)
+	}
+	return nil
+}
+
+// removeHRGroups removes the user from the groups it is a member of.
+func (state *RuntimeState) removeHRGroups(r *http.Request, event hrEvent, groupnames []string,
+	result *hrEventResult) error {
+	details := "hr " + event.Type + " " + event.ID
+	for _, groupname := range groupnames {
+		isMember, _, err := state.requestUserinfo(r).IsgroupmemberorNot(groupname, event.Username)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			continue
+		}
+		err = state.requestUserinfo(r).DeletemembersfromGroup(userinfo.GroupInfo{Groupname: groupname,
+			MemberUid: []string{event.Username}})
+		if err != nil {
+			state.recordAuditEvent(r, hrWebhookActor, auditActionRemoveMember, groupname, event.Username,
+				auditOutcomeFailure, err.Error())
+			return err
+		}
+		state.recordAuditEvent(r, hrWebhookActor, auditActionRemoveMember, groupname, event.Username,
+			auditOutcomeSuccess, details)
+		_, err = state.recordMembershipRemovals(groupname, []string{event.Username}, hrWebhookActor)
+		if err != nil {
+			requestLogger(r).Error("cannot record the membership removal", "group", groupname, "err", err)
+		}
+		result.Removed = append(result.Removed, groupname)
+	}
+	return nil
+}
+
+// deprovisionUser removes the user from every group and cancels its
+// pending access requests.
+func (state *RuntimeState) deprovisionUser(r *http.Request, event hrEvent, result *hrEventResult) error {
+	groupnames, err := state.requestUserinfo(r).GetgroupsofUser(event.Username)
+	if err != nil {
+		return err
+	}
+	sort.Strings(groupnames)
+	err = state.removeHRGroups(r, event, groupnames, result)
+	if err != nil {
+		return err
+	}
+	requests, err := searchAccessRequestsInDB(accessRequestFilter{Username: event.Username,
+		State: requestStatePending}, state)
+	if err != nil {
+		return err
+	}
+	for _, request := range requests {
+		err = closeRequestInDB(event.Username, request.Groupname, requestStateCancelled, hrWebhookActor,
+			"hr termination "+event.ID, state)
+		if err != nil {
+			state.recordAuditEvent(r, hrWebhookActor, auditActionCancelRequest, request.Groupname,
+				event.Username, auditOutcomeFailure, err.Error())
+			return err
+		}
+		state.recordAuditEvent(r, hrWebhookActor, auditActionCancelRequest, request.Groupname, event.Username,
+			auditOutcomeSuccess, "hr termination "+event.ID)
+		result.Cancelled = append(result.Cancelled, request.Groupname)
+	}
+	return nil
+}
+
+// applyHREvent runs the actions of the event, the actions already done by
+// an earlier failed attempt are skipped.
+func (state *RuntimeState) applyHREvent(r *http.Request, event hrEvent) (hrEventResult, error) {
+	config := state.Config.HRWebhook
+	result := hrEventResult{ID: event.ID, Added: []string{}, Removed: []string{}, Cancelled: []string{}}
+	var err error
+	switch event.Type {
+	case hrEventHire:
+		groupnames := append(append([]string{}, config.DefaultGroups...), config.DepartmentGroups[event.Department]...)
+		err = state.addHRGroups(r, event, groupnames, &result)
+	case hrEventTransfer:
+		newGroups := make(map[string]bool)
+		for _, groupname := range config.DepartmentGroups[event.Department] {
+			newGroups[groupname] = true
+		}
+		var oldGroups []string
+		for _, groupname := range config.DepartmentGroups[event.PreviousDepartment] {
+			if !newGroups[groupname] {
+				oldGroups = append(oldGroups, groupname)
+			}
+		}
+		err = state.addHRGroups(r, event, config.DepartmentGroups[event.Department], &result)
+		if err == nil {
+			err = state.removeHRGroups(r, event, oldGroups, &result)
+		}
+	case hrEventTermination:
+		if config.DeprovisionTerminations {
+			err = state.deprovisionUser(r, event, &result)
+		}
+	}
+	return result, err
+}
+
+func (state *RuntimeState) hrWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		http.Error(w, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, hrWebhookMaxBodySize+1))
+	if err != nil {
+		http.Error(w, "cannot read the body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > hrWebhookMaxBodySize {
+		http.Error(w, "the body is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	err = verifyHRWebhookSignature(state.hrWebhookSecret, r.Header.Get(webhookSignatureHeader), body,
+		time.Now())
+	if err != nil {
+		requestLogger(r).Warn("rejected HR webhook request", "err", err)
+		state.authFailures.recordFailure(r, "invalid_hr_webhook_signature")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var event hrEvent
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid event: %s", err), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case event.ID == "" || event.Username == "":
+		err = fmt.Errorf("the id and the username are required")
+	case event.Type != hrEventHire && event.Type != hrEventTransfer && event.Type != hrEventTermination:
+		err = fmt.Errorf("unknown event type '%s'", event.Type)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	seen, err := queryStringsFromDB(state, getHREventStmt[state.dbType], event.ID)
+	if err != nil {
+		requestLogger(r).Error("hrWebhookHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	result := hrEventResult{ID: event.ID, Duplicate: true}
+	if len(seen) == 0 {
+		exists, err := state.requestUserinfo(r).UsernameExistsornot(event.Username)
+		if err != nil {
+			requestLogger(r).Error("hrWebhookHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			// the account may not be created yet, the HR system retries
+			http.Error(w, "user "+event.Username+" does not exist", http.StatusConflict)
+			return
+		}
+		result, err = state.applyHREvent(r, event)
+		if err != nil {
+			requestLogger(r).Error("cannot apply the HR event", "event", event, "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		details := fmt.Sprintf("added to %s; removed from %s; cancelled %s", strings.Join(result.Added, ","),
+			strings.Join(result.Removed, ","), strings.Join(result.Cancelled, ","))
+		err = execServiceAccountUpdate(state, insertHREventStmt[state.dbType], event.ID, event.Type,
+			event.Username, time.Now().Unix(), details)
+		if err != nil {
+			requestLogger(r).Error("cannot record the HR event", "event", event, "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		requestLogger(r).Info("HR event applied", "id", event.ID, "type", event.Type, "user", event.Username,
+			"added", result.Added, "removed", result.Removed, "cancelled", result.Cancelled)
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		requestLogger(r).Error("Failed marshal", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(b)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHRWebhookSecret = "0123456789abcdef0123"
+
+func testHRWebhookSignature(body string, now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testHRWebhookSecret))
+	mac.Write([]byte(timestamp + "." + body))
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func testPostHREvent(t *testing.T, state *RuntimeState, body string, signature string) (int, hrEventResult) {
+	req, err := http.NewRequest(postMethod, hrWebhookPath, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(webhookSignatureHeader, signature)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.hrWebhookHandler).ServeHTTP(rr, req)
+	var result hrEventResult
+	if rr.Code == http.StatusOK {
+		err = json.Unmarshal(rr.Body.Bytes(), &result)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr.Code, result
+}
+
+func TestHRWebhook(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "hrwebhook_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "hrwebhook.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.hrWebhookSecret = []byte(testHRWebhookSecret)
+	state.Config.HRWebhook = hrWebhookConfig{SecretFilename: "secret", DefaultGroups: []string{"group1"},
+		DepartmentGroups:        map[string][]string{"eng": {"group2"}, "sales": {"group1"}},
+		DeprovisionTerminations: true}
+	now := time.Now()
+
+	hire := `{"id": "evt-1", "type": "hire", "username": "user3", "department": "eng"}`
+	for _, signature := range []string{"", "t=1,v1=00", testHRWebhookSignature(hire, now.Add(-time.Hour)),
+		testHRWebhookSignature(hire+" ", now)} {
+		code, _ := testPostHREvent(t, &state, hire, signature)
+		if code != http.StatusUnauthorized {
+			t.Errorf("signature %q accepted with %d", signature, code)
+		}
+	}
+	code, result := testPostHREvent(t, &state, hire, testHRWebhookSignature(hire, now))
+	if code != http.StatusOK || !reflect.DeepEqual(result.Added, []string{"group1", "group2"}) {
+		t.Fatalf("hire returned %d %+v", code, result)
+	}
+	code, result = testPostHREvent(t, &state, hire, testHRWebhookSignature(hire, now))
+	if code != http.StatusOK || !result.Duplicate || len(result.Added) != 0 {
+		t.Fatalf("repeated hire returned %d %+v", code, result)
+	}
+
+	transfer := `{"id": "evt-2", "type": "transfer", "username": "user3", "department": "sales",
+		"previous_department": "eng"}`
+	code, result = testPostHREvent(t, &state, transfer, testHRWebhookSignature(transfer, now))
+	if code != http.StatusOK || len(result.Added) != 0 || !reflect.DeepEqual(result.Removed, []string{"group2"}) {
+		t.Fatalf("transfer returned %d %+v", code, result)
+	}
+
+	err = insertRequestInDB("user3", []string{"group2"}, "", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	termination := `{"id": "evt-3", "type": "termination", "username": "user3"}`
+	code, result = testPostHREvent(t, &state, termination, testHRWebhookSignature(termination, now))
+	if code != http.StatusOK || !reflect.DeepEqual(result.Removed, []string{"group1"}) ||
+		!reflect.DeepEqual(result.Cancelled, []string{"group2"}) {
+		t.Fatalf("termination returned %d %+v", code, result)
+	}
+	groups, err := state.Userinfo.GetgroupsofUser("user3")
+	if err != nil || len(groups) != 0 {
+		t.Fatalf("groups of the terminated user %v, err %v", groups, err)
+	}
+
+	unknown := `{"id": "evt-4", "type": "hire", "username": "nobody"}`
+	code, _ = testPostHREvent(t, &state, unknown, testHRWebhookSignature(unknown, now))
+	if code != http.StatusConflict {
+		t.Fatalf("hire of an unknown user returned %d", code)
+	}
+	invalid := `{"id": "evt-5", "type": "promotion", "username": "user3"}`
+	code, _ = testPostHREvent(t, &state, invalid, testHRWebhookSignature(invalid, now))
+	if code != http.StatusBadRequest {
+		t.Fatalf("unknown event type returned %d", code)
+	}
+}
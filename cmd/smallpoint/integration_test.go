@@ -0,0 +1,205 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo/openldaptest"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// The integration tests run the handlers against OpenLDAP, and against
+// PostgreSQL instead of SQLite when SMALLPOINT_INTEGRATION_DATABASE is
+// postgres, in containers. Run them with
+//
+//	go test -tags integration ./cmd/smallpoint/ -run Integration
+
+const integrationDatabaseEnv = "SMALLPOINT_INTEGRATION_DATABASE"
+
+// startIntegrationPostgres returns the storage URL of a new PostgreSQL
+// server.
+func startIntegrationPostgres(t *testing.T, ctx context.Context) string {
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "postgres:16-alpine",
+			ExposedPorts: []string{"5432/tcp"},
+			Env: map[string]string{
+				"POSTGRES_USER":     "smallpoint",
+				"POSTGRES_PASSWORD": "smallpoint",
+				"POSTGRES_DB":       "smallpoint",
+			},
+			// the server restarts once initialized
+			WaitingFor: wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).WithStartupTimeout(2 * time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { container.Terminate(context.Background()) })
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := container.MappedPort(ctx, "5432/tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "postgresql://smallpoint:smallpoint@" + net.JoinHostPort(host, port.Port()) +
+		"/smallpoint?sslmode=disable"
+}
+
+// setupIntegrationState returns the test state on a new OpenLDAP server
+// seeded with the users of the unit tests, user1 is the super admin.
+func setupIntegrationState(t *testing.T) RuntimeState {
+	ctx := context.Background()
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch database := os.Getenv(integrationDatabaseEnv); database {
+	case "", "sqlite":
+	case "postgres":
+		state.Config.Base.StorageURL = startIntegrationPostgres(t, ctx)
+		if err := initDB(&state); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unsupported %s %s", integrationDatabaseEnv, database)
+	}
+	server, err := openldaptest.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Terminate(context.Background()) })
+	source := server.Source(adminTestusername)
+	for _, username := range []string{"user1", "user2", "user3"} {
+		err := source.CreateUser(username, []string{username}, []string{username + "@example.com"})
+		if err != nil {
+			t.Fatalf("cannot create %s: %s", username, err)
+		}
+	}
+	err = source.CreateGroup(userinfo.GroupInfo{Groupname: "admins", Description: descriptionAttribute,
+		MemberUid: []string{"user1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Userinfo = source
+	state.UserSourceinfo = source
+	return state
+}
+
+func testPostJSON(t *testing.T, state *RuntimeState, path string, handler http.HandlerFunc, admin bool,
+	value interface{}) int {
+	body, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	if admin {
+		cookie = testCreateValidAdminCookie(state.authenticator)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestIntegrationHandlers(t *testing.T) {
+	state := setupIntegrationState(t)
+	isMember := func(groupname string, username string) bool {
+		member, _, err := state.Userinfo.IsgroupmemberorNot(groupname, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return member
+	}
+	post := func(path string, handler http.HandlerFunc, admin bool, form url.Values) {
+		if code := testPostServiceAccountForm(t, &state, path, handler, admin, form); code != http.StatusOK {
+			t.Fatalf("%s %v failed with %d", path, form, code)
+		}
+	}
+
+	post(creategroupPath, state.createGrouphandler, true,
+		url.Values{"groupname": {"integration"}, "description": {"admins"}, "members": {"user3"}})
+	if !isMember("integration", "user3") {
+		t.Fatal("the members of the new group are missing")
+	}
+	req := httptest.NewRequest(getMethod, allLDAPgroupsPath, nil)
+	testAddAuthCookie(req, state.authenticator, testCreateValidCookie(state.authenticator))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.allGroupsHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "integration") {
+		t.Fatalf("the new group is not listed, got %d", rr.Code)
+	}
+
+	// user2 requests the membership and an admin approves it
+	code := testPostJSON(t, &state, requestaccessPath, state.requestAccessHandler, false,
+		map[string][]string{"groups": {"integration"}})
+	if code != http.StatusOK {
+		t.Fatalf("the request failed with %d", code)
+	}
+	code = testPostJSON(t, &state, approverequestPath, state.approveHandler, true,
+		map[string][][]string{"groups": {{testUsername, "integration"}}})
+	if code != http.StatusOK || !isMember("integration", testUsername) {
+		t.Fatalf("the approval failed with %d", code)
+	}
+	groups, err := state.Userinfo.GetgroupsofUser(testUsername)
+	if err != nil || len(groups) != 1 || groups[0] != "integration" {
+		t.Fatalf("unexpected groups of %s %v, err %v", testUsername, groups, err)
+	}
+
+	post(deletemembersbuttonPath, state.deletemembersfromExistingGroup, true,
+		url.Values{"groupname": {"integration"}, "members": {"user3"}})
+	if isMember("integration", "user3") {
+		t.Fatal("the removed member is still in the group")
+	}
+	post(addmembersbuttonPath, state.addmemberstoExistingGroup, true,
+		url.Values{"groupname": {"integration"}, "members": {"user3"}})
+	if !isMember("integration", "user3") {
+		t.Fatal("the added member is missing")
+	}
+
+	post(changeownershipbuttonPath, state.changeownership, true,
+		url.Values{"groupnames": {"integration"}, "managegroup": {"integration"}})
+	managedBy, err := state.Userinfo.GetDescriptionvalue("integration")
+	if err != nil || managedBy != "integration" {
+		t.Fatalf("the group is managed by %q, err %v", managedBy, err)
+	}
+	// the members now manage the group
+	isAdmin, err := state.Userinfo.IsgroupAdminorNot(testUsername, "integration")
+	if err != nil || !isAdmin {
+		t.Fatalf("%s does not manage the group, err %v", testUsername, err)
+	}
+
+	post(createServiceAccountPath, state.createServiceAccounthandler, true, url.Values{
+		"AccountName": {"svc_integration"}, "mail": {"team@example.com"}, "loginShell": {"/bin/false"}})
+	exists, _, err := state.Userinfo.ServiceAccountExistsornot("svc_integration")
+	if err != nil || !exists {
+		t.Fatalf("the service account is missing, err %v", err)
+	}
+
+	post(deletegroupPath, state.deleteGrouphandler, true, url.Values{"groupnames": {"integration"}})
+	exists, _, err = state.Userinfo.GroupnameExistsornot("integration")
+	if err != nil || exists {
+		t.Fatalf("the deleted group exists, err %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// startPeriodicJob runs job right away and then every interval on its own
+// goroutine. Errors are logged and the job is retried on the next interval.
+// The jobs act on the shared state, they run on the scheduler leader only.
+// They stop on shutdown, a running job is finished first.
+func (state *RuntimeState) startPeriodicJob(name string, interval time.Duration, job func() error) {
+	state.jobs.running.Add(1)
+	go func() {
+		defer state.jobs.running.Done()
+		for {
+			if !state.isSchedulerLeader() {
+				slog.Debug("periodic job skipped on a follower", "job", name)
+				if !state.jobs.sleep(interval) {
+					return
+				}
+				continue
+			}
+			start := time.Now()
+			err := job()
+			if err != nil {
+				slog.Error("periodic job failed", "job", name, "err", err)
+			} else {
+				slog.Info("periodic job done", "job", name, "duration", time.Since(start))
+			}
+			if !state.jobs.sleep(interval) {
+				return
+			}
+		}
+	}()
+}
+
+// startFollowerJob runs job every interval while another instance is the
+// scheduler leader, to pick up the state its jobs maintain.
+func (state *RuntimeState) startFollowerJob(name string, interval time.Duration, job func() error) {
+	state.jobs.running.Add(1)
+	go func() {
+		defer state.jobs.running.Done()
+		for {
+			if !state.isSchedulerLeader() {
+				err := job()
+				if err != nil {
+					slog.Error("follower job failed", "job", name, "err", err)
+				}
+			}
+			if !state.jobs.sleep(interval) {
+				return
+			}
+		}
+	}()
+}
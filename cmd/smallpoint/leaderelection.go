@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// With several instances sharing the database, the periodic jobs run on the
+// leader only. The leader holds a lease in the job_leases table and renews it
+// every third of the lease duration; when it stops, another instance takes
+// the lease over once it expires. An instance stops running the jobs a third
+// of the lease duration before its lease expires, the remaining third covers
+// the clock skew between the instances.
+//
+// The jobs check the leadership at every interval: after a takeover the new
+// leader runs each job at its own next interval.
+
+const (
+	schedulerLeaseName           = "scheduler"
+	defaultLeaderElectionLease   = 30 * time.Second
+	leaderElectionRenewDivisions = 3
+)
+
+type leaderElectionConfig struct {
+	// Enabled elects the instance that runs the periodic jobs, for the
+	// deployments with several instances.
+	Enabled bool `yaml:"enabled"`
+	// LeaseDuration after which the jobs of a stopped leader are taken
+	// over, 30s by default.
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+}
+
+func (config leaderElectionConfig) leaseDuration() time.Duration {
+	if config.LeaseDuration > 0 {
+		return config.LeaseDuration
+	}
+	return defaultLeaderElectionLease
+}
+
+// the lease is taken when it is free, expired or already held
+var acquireLeaseStmt = map[string]string{
+	"sqlite": "insert into job_leases(name, holder, expires_at) values (?,?,?) on conflict(name) do update " +
+		"set holder=excluded.holder, expires_at=excluded.expires_at " +
+		"where job_leases.holder=excluded.holder or job_leases.expires_at<?;",
+	"postgres": "insert into job_leases(name, holder, expires_at) values ($1,$2,$3) on conflict(name) do update " +
+		"set holder=excluded.holder, expires_at=excluded.expires_at " +
+		"where job_leases.holder=excluded.holder or job_leases.expires_at<$4;",
+}
+
+var releaseLeaseStmt = map[string]string{
+	"sqlite":   "delete from job_leases where name=? and holder=?;",
+	"postgres": "delete from job_leases where name=$1 and holder=$2;",
+}
+
+type leaderElector struct {
+	state         *RuntimeState
+	holder        string
+	leaseDuration time.Duration
+
+	mutex       sync.Mutex
+	leader      bool
+	leaderUntil time.Time
+}
+
+func newLeaderElector(state *RuntimeState, config leaderElectionConfig) (*leaderElector, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return nil, err
+	}
+	return &leaderElector{state: state, holder: hostname + "-" + hex.EncodeToString(suffix),
+		leaseDuration: config.leaseDuration()}, nil
+}
+
+// acquire takes or renews the lease.
+func (e *leaderElector) acquire() error {
+	start := time.Now()
+	expiresAt := start.Add(e.leaseDuration).Unix()
+	result, err := e.state.db.Exec(acquireLeaseStmt[e.state.dbType], schedulerLeaseName, e.holder, expiresAt,
+		start.Unix())
+	if err != nil {
+		return err
+	}
+	acquired, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if acquired == 0 {
+		e.leaderUntil = time.Time{}
+	} else {
+		e.leaderUntil = time.Unix(expiresAt, 0).Add(-e.leaseDuration / leaderElectionRenewDivisions)
+	}
+	leader := acquired > 0
+	if leader != e.leader {
+		slog.Info("scheduler leadership changed", "holder", e.holder, "leader", leader)
+		metrics.MetricSetSchedulerLeader(leader)
+	}
+	e.leader = leader
+	return nil
+}
+
+func (e *leaderElector) isLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return time.Now().Before(e.leaderUntil)
+}
+
+// release gives the lease up when it is held, for another instance to take
+// it over without waiting for it to expire.
+func (e *leaderElector) release() error {
+	e.mutex.Lock()
+	e.leaderUntil = time.Time{}
+	e.mutex.Unlock()
+	_, err := e.state.db.Exec(releaseLeaseStmt[e.state.dbType], schedulerLeaseName, e.holder)
+	return err
+}
+
+// start takes the lease if it is free before the jobs start, and then renews
+// it on its own goroutine until the jobs stop.
+func (e *leaderElector) start() {
+	err := e.acquire()
+	if err != nil {
+		slog.Error("cannot acquire the scheduler lease", "err", err)
+	}
+	go func() {
+		for {
+			if !e.state.jobs.sleep(e.leaseDuration / leaderElectionRenewDivisions) {
+				return
+			}
+			err := e.acquire()
+			if err != nil {
+				slog.Error("cannot acquire the scheduler lease", "err", err)
+			}
+		}
+	}()
+}
+
+// isSchedulerLeader returns whether the instance runs the periodic jobs.
+func (state *RuntimeState) isSchedulerLeader() bool {
+	if state.leaderElector == nil {
+		return true
+	}
+	return state.leaderElector.isLeader()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLeaderElection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaderelection_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "requests.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.db.Close()
+	if !state.isSchedulerLeader() {
+		t.Fatal("the single instance is not the leader")
+	}
+
+	config := leaderElectionConfig{Enabled: true, LeaseDuration: time.Minute}
+	first, err := newLeaderElector(&state, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newLeaderElector(&state, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elector := range []*leaderElector{first, second, first} {
+		err = elector.acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !first.isLeader() || second.isLeader() {
+		t.Fatalf("unexpected leaders first=%v second=%v", first.isLeader(), second.isLeader())
+	}
+	state.leaderElector = second
+	if state.isSchedulerLeader() {
+		t.Fatal("the follower runs the jobs")
+	}
+
+	// the expired lease is taken over
+	_, err = state.db.Exec("update job_leases set expires_at=? where name=?;", time.Now().Add(-time.Second).Unix(),
+		schedulerLeaseName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elector := range []*leaderElector{second, first} {
+		err = elector.acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.isLeader() || !second.isLeader() || !state.isSchedulerLeader() {
+		t.Fatalf("the lease was not taken over first=%v second=%v", first.isLeader(), second.isLeader())
+	}
+	// the released lease is taken over at once
+	err = second.release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = first.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.isLeader() || second.isLeader() {
+		t.Fatalf("the released lease was not taken over first=%v second=%v", first.isLeader(), second.isLeader())
+	}
+}
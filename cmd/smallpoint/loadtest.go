@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The loadtest command sizes a deployment before rollout. It populates the
+// configured directory, which should be a test directory, with users and
+// self-managed groups named after a prefix and drives request, pending
+// requests and approval traffic through the handlers, bypassing TLS and the
+// network. The mails are rendered and dropped. The groups are deleted at the
+// end unless -cleanup=false, the users are kept and reused by the next runs.
+
+const (
+	loadTestOperationRequest  = "request"
+	loadTestOperationPending  = "pending"
+	loadTestOperationApprove  = "approve"
+	loadTestOperationPopulate = "populate"
+)
+
+type loadTestConfig struct {
+	Users       int
+	Groups      int
+	Requests    int
+	Concurrency int
+	Prefix      string
+	Cleanup     bool
+}
+
+func parseLoadTestArgs(args []string) (loadTestConfig, error) {
+	var config loadTestConfig
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.IntVar(&config.Users, "users", 100, "The number of users")
+	flags.IntVar(&config.Groups, "groups", 20, "The number of groups")
+	flags.IntVar(&config.Requests, "requests", 200, "The number of access requests to make and approve")
+	flags.IntVar(&config.Concurrency, "concurrency", 4, "The number of concurrent clients")
+	flags.StringVar(&config.Prefix, "prefix", "loadtest-", "The prefix of the generated users and groups")
+	flags.BoolVar(&config.Cleanup, "cleanup", true, "Delete the generated groups at the end")
+	err := flags.Parse(args)
+	if err != nil {
+		return config, err
+	}
+	if config.Users < 2 || config.Groups < 1 || config.Requests < 0 || config.Concurrency < 1 {
+		return config, errors.New("loadtest requires at least 2 users, 1 group and 1 client")
+	}
+	if config.Prefix == "" {
+		return config, errors.New("loadtest requires a prefix")
+	}
+	// every request is made by a user outside of the group
+	if maxRequests := config.Groups * (config.Users - 1); config.Requests > maxRequests {
+		return config, fmt.Errorf("%d users and %d groups allow %d requests at most",
+			config.Users, config.Groups, maxRequests)
+	}
+	return config, nil
+}
+
+func (config loadTestConfig) username(i int) string {
+	return fmt.Sprintf("%suser%d", config.Prefix, i%config.Users)
+}
+
+func (config loadTestConfig) groupname(i int) string {
+	return fmt.Sprintf("%sgroup%d", config.Prefix, i%config.Groups)
+}
+
+// requestPair returns the user, the group and the manager of the group of
+// the i-th request. The pairs are distinct and the user is never the manager
+// of the group, the i-th user.
+func (config loadTestConfig) requestPair(i int) (string, string, string) {
+	group := i % config.Groups
+	offset := i/config.Groups + 1
+	return config.username(group + offset), config.groupname(group), config.username(group)
+}
+
+type loadTestStats struct {
+	Operation string
+	Errors    int
+	durations []time.Duration
+}
+
+func (stats *loadTestStats) percentile(p float64) time.Duration {
+	if len(stats.durations) == 0 {
+		return 0
+	}
+	return stats.durations[int(p*float64(len(stats.durations)-1))]
+}
+
+func (stats *loadTestStats) String() string {
+	sort.Slice(stats.durations, func(i, j int) bool { return stats.durations[i] < stats.durations[j] })
+	return fmt.Sprintf("%s count=%d errors=%d p50=%s p95=%s p99=%s max=%s", stats.Operation,
+		len(stats.durations), stats.Errors, stats.percentile(0.5), stats.percentile(0.95),
+		stats.percentile(0.99), stats.percentile(1))
+}
+
+type loadTestReport struct {
+	UsersCreated  int
+	GroupsCreated int
+	Elapsed       time.Duration
+
+	mutex      sync.Mutex
+	operations map[string]*loadTestStats
+	firstError error
+}
+
+func (report *loadTestReport) record(operation string, duration time.Duration, err error) {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+	if report.operations == nil {
+		report.operations = make(map[string]*loadTestStats)
+	}
+	stats, ok := report.operations[operation]
+	if !ok {
+		stats = &loadTestStats{Operation: operation}
+		report.operations[operation] = stats
+	}
+	stats.durations = append(stats.durations, duration)
+	if err != nil {
+		stats.Errors++
+		if report.firstError == nil {
+			report.firstError = err
+		}
+	}
+}
+
+// succeeded returns the number of the operations that did not fail.
+func (report *loadTestReport) succeeded(operation string) int {
+	stats, ok := report.operations[operation]
+	if !ok {
+		return 0
+	}
+	return len(stats.durations) - stats.Errors
+}
+
+func (report *loadTestReport) errors() int {
+	var errors int
+	for _, stats := range report.operations {
+		errors += stats.Errors
+	}
+	return errors
+}
+
+// populateLoadTestDirectory creates the missing users and groups, the i-th
+// group is self-managed with the i-th user as its member.
+func (state *RuntimeState) populateLoadTestDirectory(config loadTestConfig, report *loadTestReport) error {
+	for i := 0; i < config.Users; i++ {
+		username := config.username(i)
+		start := time.Now()
+		exists, err := state.Userinfo.UsernameExistsornot(username)
+		if err == nil && !exists {
+			err = state.Userinfo.CreateUser(username, []string{username},
+				[]string{username + "@example.com"})
+			report.UsersCreated++
+		}
+		report.record(loadTestOperationPopulate, time.Since(start), err)
+		if err != nil {
+			return fmt.Errorf("cannot create user %s: %s", username, err)
+		}
+	}
+	for i := 0; i < config.Groups; i++ {
+		groupname := config.groupname(i)
+		start := time.Now()
+		exists, _, err := state.Userinfo.GroupnameExistsornot(groupname)
+		if err == nil && !exists {
+			err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: groupname,
+				Description: descriptionAttribute, MemberUid: []string{config.username(i)}})
+			report.GroupsCreated++
+		}
+		report.record(loadTestOperationPopulate, time.Since(start), err)
+		if err != nil {
+			return fmt.Errorf("cannot create group %s: %s", groupname, err)
+		}
+	}
+	return nil
+}
+
+// serveLoadTestRequest runs a handler as username.
+func (state *RuntimeState) serveLoadTestRequest(username string, method string, path string,
+	handler http.HandlerFunc, body interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, path, reader)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	cookieValue, err := state.authenticator.GenUserCookieValue(username, expiresAt)
+	if err != nil {
+		return err
+	}
+	req.AddCookie(&http.Cookie{Name: authn.AuthCookieName, Value: cookieValue, Expires: expiresAt})
+	req.Header.Set(csrfHeader, state.authenticator.CSRFToken(req))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	if rr.Code != http.StatusOK {
+		return fmt.Errorf("%s %s as %s returned %d: %s", method, path, username, rr.Code, rr.Body.String())
+	}
+	return nil
+}
+
+func (state *RuntimeState) timeLoadTestRequest(report *loadTestReport, operation string, username string,
+	method string, path string, handler http.HandlerFunc, body interface{}) error {
+	start := time.Now()
+	err := state.serveLoadTestRequest(username, method, path, handler, body)
+	report.record(operation, time.Since(start), err)
+	return err
+}
+
+// runLoadTestTraffic requests access for the request pairs, lists the
+// pending requests of the manager and approves the request.
+func (state *RuntimeState) runLoadTestTraffic(config loadTestConfig, report *loadTestReport) {
+	requests := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range requests {
+				username, groupname, manager := config.requestPair(i)
+				err := state.timeLoadTestRequest(report, loadTestOperationRequest, username, postMethod,
+					requestaccessPath, state.requestAccessHandler,
+					map[string][]string{"groups": {groupname}})
+				if err != nil {
+					continue
+				}
+				state.timeLoadTestRequest(report, loadTestOperationPending, manager, getMethod,
+					pendingrequestsPath, state.pendingRequests, nil)
+				state.timeLoadTestRequest(report, loadTestOperationApprove, manager, postMethod,
+					approverequestPath, state.approveHandler,
+					map[string][][]string{"groups": {{username, groupname}}})
+			}
+		}()
+	}
+	start := time.Now()
+	for i := 0; i < config.Requests; i++ {
+		requests <- i
+	}
+	close(requests)
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+}
+
+func (state *RuntimeState) cleanupLoadTestDirectory(config loadTestConfig) error {
+	groupnames := make([]string, config.Groups)
+	for i := range groupnames {
+		groupnames[i] = config.groupname(i)
+	}
+	err := state.Userinfo.DeleteGroup(groupnames)
+	if err != nil {
+		return err
+	}
+	// the requests that failed are left pending
+	return expireRequestsOfGroupsInDB(groupnames, "smallpoint", "load test cleanup", state)
+}
+
+func (state *RuntimeState) runLoadTest(config loadTestConfig) (*loadTestReport, error) {
+	report := &loadTestReport{}
+	err := state.populateLoadTestDirectory(config, report)
+	if err != nil {
+		return report, err
+	}
+	state.runLoadTestTraffic(config, report)
+	if config.Cleanup {
+		err = state.cleanupLoadTestDirectory(config)
+	}
+	return report, err
+}
+
+type discardSMTPDialer struct{}
+
+func (discardSMTPDialer) Close() error      { return nil }
+func (discardSMTPDialer) Mail(string) error { return nil }
+func (discardSMTPDialer) Rcpt(string) error { return nil }
+func (discardSMTPDialer) Data() (io.WriteCloser, error) {
+	return nopWriteCloser{ioutil.Discard}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func loadTestCommand(state *RuntimeState, args []string) int {
+	config, err := parseLoadTestArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		fmt.Fprintf(os.Stderr, "Usage: loadtest [-users N] [-groups N] [-requests N] [-concurrency N] [-prefix P] [-cleanup=false]\n")
+		return 2
+	}
+	smtpClient = func(addr string) (smtpDialer, error) {
+		return discardSMTPDialer{}, nil
+	}
+	fmt.Printf("populating %d users and %d groups named %s*\n", config.Users, config.Groups, config.Prefix)
+	report, err := state.runLoadTest(config)
+	fmt.Printf("users created=%d groups created=%d\n", report.UsersCreated, report.GroupsCreated)
+	for _, operation := range []string{loadTestOperationPopulate, loadTestOperationRequest,
+		loadTestOperationPending, loadTestOperationApprove} {
+		if stats, ok := report.operations[operation]; ok {
+			fmt.Println(stats)
+		}
+	}
+	if report.Elapsed > 0 {
+		approved := report.succeeded(loadTestOperationApprove)
+		fmt.Printf("approved requests=%d elapsed=%s throughput=%.1f/s\n", approved, report.Elapsed,
+			float64(approved)/report.Elapsed.Seconds())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Load test FAILED: %s\n", err)
+		return 1
+	}
+	if report.errors() > 0 {
+		fmt.Fprintf(os.Stderr, "Load test FAILED, first error: %s\n", report.firstError)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseLoadTestArgs(t *testing.T) {
+	config, err := parseLoadTestArgs([]string{"-users", "10", "-groups", "3", "-requests", "27", "-prefix", "lt-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Users != 10 || config.Groups != 3 || config.Requests != 27 || config.Prefix != "lt-" || !config.Cleanup {
+		t.Fatalf("bad config %+v", config)
+	}
+	for _, args := range [][]string{{"-users", "1"}, {"-prefix", ""}, {"-concurrency", "0"},
+		{"-users", "10", "-groups", "3", "-requests", "28"}, {"-unknown"}} {
+		_, err = parseLoadTestArgs(args)
+		if err == nil {
+			t.Errorf("%v should be invalid", args)
+		}
+	}
+	// the pairs are distinct and never request the group of the manager
+	seen := make(map[string]bool)
+	for i := 0; i < config.Requests; i++ {
+		username, groupname, manager := config.requestPair(i)
+		if username == manager || seen[username+groupname] {
+			t.Fatalf("bad request pair %d: %s %s %s", i, username, groupname, manager)
+		}
+		seen[username+groupname] = true
+	}
+}
+
+func TestRunLoadTest(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useSynchronizedDirectory(&state)
+	config := loadTestConfig{Users: 3, Groups: 2, Requests: 4, Concurrency: 1, Prefix: "loadtest-run-",
+		Cleanup: true}
+	report, err := state.runLoadTest(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UsersCreated != 3 || report.GroupsCreated != 2 || report.errors() != 0 ||
+		report.succeeded(loadTestOperationApprove) != config.Requests {
+		t.Fatalf("bad report %+v, first error %v", report, report.firstError)
+	}
+	for _, operation := range []string{loadTestOperationRequest, loadTestOperationPending, loadTestOperationApprove} {
+		if stats := report.operations[operation]; stats == nil || len(stats.durations) != config.Requests {
+			t.Fatalf("bad %s stats %+v", operation, stats)
+		}
+	}
+	// the groups are gone, the users are kept for the next run
+	exists, _, err := state.Userinfo.GroupnameExistsornot(config.groupname(0))
+	if err != nil || exists {
+		t.Fatalf("the group was not cleaned up, exists=%v err=%v", exists, err)
+	}
+	config.Cleanup = false
+	report, err = state.runLoadTest(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UsersCreated != 0 || report.GroupsCreated != 2 || report.errors() != 0 {
+		t.Fatalf("bad second report %+v, first error %v", report, report.firstError)
+	}
+	members, _, err := state.Userinfo.GetusersofaGroup(config.groupname(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 3 {
+		t.Fatalf("the requests were not approved, members %v", members)
+	}
+}
+
+// The benchmarks below cover the hot paths of the group pages and of the
+// access requests.
+
+func BenchmarkPageTableRows(b *testing.B) {
+	rows := make([][]string, 10000)
+	for i := range rows {
+		rows[i] = []string{fmt.Sprintf("group%d", i), fmt.Sprintf("manager%d", i%100)}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pageTableRows(rows, tablePageRequest{Page: 3, Size: defaultTablePageSize, Search: "manager4"})
+	}
+}
+
+func BenchmarkAllGroupsTableHandler(b *testing.B) {
+	state, err := setupTestState()
+	if err != nil {
+		b.Fatal(err)
+	}
+	config := loadTestConfig{Users: 100, Groups: 1000, Prefix: "bench-table-"}
+	err = state.populateLoadTestDirectory(config, &loadTestReport{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	path := allGroupsTablePath + "?" + url.Values{"page": {"2"}, "search": {"bench"}}.Encode()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = state.serveLoadTestRequest(testUsername, getMethod, path, state.allGroupsTableHandler, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPendingRequestGroupsofUser(b *testing.B) {
+	state, err := setupTestState()
+	if err != nil {
+		b.Fatal(err)
+	}
+	config := loadTestConfig{Users: 50, Groups: 20, Prefix: "bench-pending-"}
+	err = state.populateLoadTestDirectory(config, &loadTestReport{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		username, groupname, _ := config.requestPair(i)
+		err = insertRequestInDB(username, []string{groupname}, "", &state)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = state.serveLoadTestRequest(config.username(0), getMethod, pendingrequestsPath,
+			state.pendingRequests, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	err = state.cleanupLoadTestDirectory(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkRequestAndApprove measures a request, the pending requests page of
+// the manager and the approval.
+func BenchmarkRequestAndApprove(b *testing.B) {
+	state, err := setupTestState()
+	if err != nil {
+		b.Fatal(err)
+	}
+	smtpClient = func(addr string) (smtpDialer, error) {
+		return discardSMTPDialer{}, nil
+	}
+	config := loadTestConfig{Users: b.N + 1, Groups: 1, Requests: b.N, Concurrency: 1, Prefix: "bench-approve-"}
+	report := &loadTestReport{}
+	err = state.populateLoadTestDirectory(config, report)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	state.runLoadTestTraffic(config, report)
+	b.StopTimer()
+	if report.errors() != 0 {
+		b.Fatal(report.firstError)
+	}
+	err = state.cleanupLoadTestDirectory(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestLoadTestRequestFailure(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.serveLoadTestRequest(testUsername, getMethod, requestaccessPath, state.requestAccessHandler, nil)
+	if err == nil {
+		t.Fatalf("the %s request should fail with %d", getMethod, http.StatusMethodNotAllowed)
+	}
+}
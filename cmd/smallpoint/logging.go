@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The logs are written with log/slog as text or JSON records. The handlers
+// log with requestLogger, the records carry the request ID, the user, the
+// handler of the request and its trace ID when traced. The output of the log
+// package, used by the libraries, is logged at info level. The request ID is
+// returned in the requestIDHeader and shown on the error pages.
+
+const requestIDHeader = "X-Request-Id"
+
+// The request IDs set by the proxies in front of smallpoint are kept.
+var validRequestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type loggingConfig struct {
+	// Format is text, the default, or json.
+	Format string `yaml:"format"`
+	// Level is debug, info, the default, warn or error.
+	Level string `yaml:"level"`
+}
+
+func (config loggingConfig) newHandler(w io.Writer) (slog.Handler, error) {
+	var level slog.Level
+	if config.Level != "" {
+		err := level.UnmarshalText([]byte(config.Level))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level '%s'", config.Level)
+		}
+	}
+	options := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(config.Format) {
+	case "", "text":
+		return slog.NewTextHandler(w, options), nil
+	case "json":
+		return slog.NewJSONHandler(w, options), nil
+	}
+	return nil, fmt.Errorf("invalid log format '%s'", config.Format)
+}
+
+func setupLogging(config loggingConfig) error {
+	handler, err := config.newHandler(os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+type requestLoggerKey struct{}
+
+type requestIDKey struct{}
+
+// requestLogger returns the logger of the request, the default one when r
+// is nil, as in the background jobs.
+func requestLogger(r *http.Request) *slog.Logger {
+	if r == nil {
+		return slog.Default()
+	}
+	if logger, ok := r.Context().Value(requestLoggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// getRequestID returns the ID of the request, empty outside of
+// requestLoggingHandler.
+func getRequestID(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	requestID, _ := r.Context().Value(requestIDKey{}).(string)
+	return requestID
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+type statusRecordingWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusRecordingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// requestLoggingHandler sets up the ID and the logger of the requests, logs
+// their status and latency and writes the access log. mux gives the handler
+// patterns.
+func (state *RuntimeState) requestLoggingHandler(handler http.Handler, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestIDRegexp.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		_, pattern := mux.Handler(r)
+		logger := slog.Default().With("request_id", requestID, "handler", pattern)
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			logger = logger.With("trace_id", spanContext.TraceID().String())
+		}
+		var username string
+		if state.authenticator != nil {
+			if username = state.authenticator.GetVerifiedUserName(r); username != "" {
+				logger = logger.With("user", username)
+			}
+		}
+		ctx := context.WithValue(r.Context(), requestLoggerKey{}, logger)
+		r = r.WithContext(context.WithValue(ctx, requestIDKey{}, requestID))
+		recorder := &statusRecordingWriter{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if recorder.status == http.StatusForbidden {
+			recordSecurityEvent(r, securityEventForbidden)
+		}
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		latency := float64(time.Since(start).Microseconds()) / 1000
+		logger.Log(r.Context(), level, "request", "method", r.Method, "path", r.URL.Path,
+			"status", recorder.status, "latency_ms", latency)
+		if state.accessLogger != nil {
+			state.accessLogger.log(accessLogRecord{Time: start, RequestID: requestID, RemoteIP: remoteIPFromRequest(r),
+				User: username, Method: r.Method, URI: r.RequestURI, Protocol: r.Proto,
+				Status: recorder.status, Size: recorder.size, Referer: r.Referer(),
+				UserAgent: r.UserAgent(), LatencyMs: latency})
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoggingConfig(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := loggingConfig{Format: "json", Level: "warn"}.newHandler(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(handler)
+	logger.Info("dropped")
+	logger.Warn("kept", "group", "group1")
+	var record map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &record)
+	if err != nil {
+		t.Fatalf("bad record %q: %s", buf.String(), err)
+	}
+	if record["msg"] != "kept" || record["level"] != "WARN" || record["group"] != "group1" {
+		t.Fatalf("bad record %v", record)
+	}
+	for _, config := range []loggingConfig{{Format: "xml"}, {Level: "loud"}} {
+		_, err = config.newHandler(&buf)
+		if err == nil {
+			t.Errorf("%+v should be invalid", config)
+		}
+	}
+}
+
+func TestRequestLoggingHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	handler, err := loggingConfig{Format: "json", Level: "debug"}.newHandler(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultLogger, logWriter, logFlags := slog.Default(), log.Writer(), log.Flags()
+	slog.SetDefault(slog.New(handler))
+	defer func() {
+		slog.SetDefault(defaultLogger)
+		log.SetOutput(logWriter)
+		log.SetFlags(logFlags)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(groupinfoPath, func(w http.ResponseWriter, r *http.Request) {
+		requestLogger(r).Error("handler failed", "group", "group1")
+		http.Error(w, "error", http.StatusInternalServerError)
+	})
+	req := httptest.NewRequest(getMethod, groupinfoPath+"group1", nil)
+	req.Header.Set(requestIDHeader, "upstream-id.1")
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	state.requestLoggingHandler(mux, mux).ServeHTTP(rr, req)
+	if rr.Header().Get(requestIDHeader) != "upstream-id.1" {
+		t.Fatalf("the request ID was not kept, %q", rr.Header().Get(requestIDHeader))
+	}
+
+	records := testRequestRecords(t, &buf, "upstream-id.1")
+	if len(records) != 2 {
+		t.Fatalf("unexpected log %q", buf.String())
+	}
+	for _, record := range records {
+		if record["user"] != testUsername || record["handler"] != groupinfoPath || record["level"] != "ERROR" {
+			t.Fatalf("bad record %v", record)
+		}
+	}
+	if records[0]["group"] != "group1" {
+		t.Fatalf("bad handler record %v", records[0])
+	}
+	if records[1]["msg"] != "request" || records[1]["status"] != float64(http.StatusInternalServerError) {
+		t.Fatalf("bad request record %v", records[1])
+	}
+	if _, ok := records[1]["latency_ms"]; !ok {
+		t.Fatalf("the latency is missing from %v", records[1])
+	}
+
+	// the invalid request IDs are replaced
+	buf.Reset()
+	req = httptest.NewRequest(getMethod, "/unknown", nil)
+	req.Header.Set(requestIDHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	state.requestLoggingHandler(mux, mux).ServeHTTP(rr, req)
+	requestID := rr.Header().Get(requestIDHeader)
+	if !validRequestIDRegexp.MatchString(requestID) || requestID == "bad id\n" {
+		t.Fatalf("bad request ID %q", requestID)
+	}
+	records = testRequestRecords(t, &buf, requestID)
+	if len(records) != 1 || records[0]["status"] != float64(http.StatusNotFound) {
+		t.Fatalf("bad log %q", buf.String())
+	}
+	if _, ok := records[0]["user"]; ok {
+		t.Fatalf("the anonymous request has a user, %v", records[0])
+	}
+}
+
+// testRequestRecords returns the records of the request, the goroutines left
+// by the other tests may log concurrently.
+func testRequestRecords(t *testing.T, buf *bytes.Buffer, requestID string) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		err := json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record["request_id"] == requestID {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestRequestLoggerWithoutRequest(t *testing.T) {
+	if requestLogger(nil) != slog.Default() {
+		t.Fatal("the logger of no request is not the default one")
+	}
+	if requestID := getRequestID(nil); requestID != "" {
+		t.Fatalf("no request has the request ID %q", requestID)
+	}
+}
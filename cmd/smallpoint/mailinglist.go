@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// A group becomes a mailing list when it gets a mail address. The primary
+// address and the aliases are stored in the mail attribute of the group and
+// in the local DB, which keeps an address from being used by two groups. The
+// mail system can be told about membership changes of mailing lists with a
+// webhook.
+
+const (
+	maxMailAliasesPerGroup      = 16
+	defaultMailWebhookTimeout   = 10 * time.Second
+	mailWebhookChangeAdded      = "added"
+	mailWebhookChangeRemoved    = "removed"
+	mailingListAddressMaxLength = 254
+)
+
+type mailingListConfig struct {
+	// Domains are the domains allowed for group addresses, any domain is
+	// allowed when empty.
+	Domains        []string      `yaml:"domains"`
+	WebhookURL     string        `yaml:"webhook_url"`
+	WebhookTimeout time.Duration `yaml:"webhook_timeout"`
+}
+
+type groupMailAddresses struct {
+	Address string
+	Aliases []string
+}
+
+var getGroupMailAddressesStmt = map[string]string{
+	"sqlite":   "select address, is_primary from mailing_list_addresses where groupname=? order by address;",
+	"postgres": "select address, is_primary from mailing_list_addresses where groupname=$1 order by address;",
+}
+
+var getMailAddressGroupStmt = map[string]string{
+	"sqlite":   "select groupname from mailing_list_addresses where address=?;",
+	"postgres": "select groupname from mailing_list_addresses where address=$1;",
+}
+
+var setGroupMailAddressesStmts = map[string][]string{
+	"sqlite": {"delete from mailing_list_addresses where groupname=?;",
+		"insert into mailing_list_addresses(address, groupname, is_primary, updated_by, time_stamp) values (?,?,?,?,?);"},
+	"postgres": {"delete from mailing_list_addresses where groupname=$1;",
+		"insert into mailing_list_addresses(address, groupname, is_primary, updated_by, time_stamp) values ($1,$2,$3,$4,$5);"},
+}
+
+func getGroupMailAddressesFromDB(groupname string, state *RuntimeState) (groupMailAddresses, error) {
+	var addresses groupMailAddresses
+	start := time.Now()
+	rows, err := state.db.Query(getGroupMailAddressesStmt[state.dbType], groupname)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return addresses, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	for rows.Next() {
+		var address string
+		var isPrimary int
+		err = rows.Scan(&address, &isPrimary)
+		if err != nil {
+			return addresses, err
+		}
+		if isPrimary != 0 {
+			addresses.Address = address
+		} else {
+			addresses.Aliases = append(addresses.Aliases, address)
+		}
+	}
+	return addresses, rows.Err()
+}
+
+// setGroupMailAddressesInDB replaces the addresses of the group, an empty
+// primary address removes them.
+func setGroupMailAddressesInDB(groupname string, addresses groupMailAddresses, username string, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := setGroupMailAddressesStmts[state.dbType]
+	_, err = tx.Exec(stmts[0], groupname)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	if addresses.Address != "" {
+		_, err = tx.Exec(stmts[1], addresses.Address, groupname, 1, username, now)
+		if err != nil {
+			return err
+		}
+		for _, alias := range addresses.Aliases {
+			_, err = tx.Exec(stmts[1], alias, groupname, 0, username, now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func (addresses groupMailAddresses) all() []string {
+	if addresses.Address == "" {
+		return nil
+	}
+	return append([]string{addresses.Address}, addresses.Aliases...)
+}
+
+// parseMailAddress returns the lowercased address and a message for the user
+// when it is invalid.
+func (config mailingListConfig) parseMailAddress(text string) (string, string) {
+	address := strings.ToLower(strings.TrimSpace(text))
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != address || len(address) > mailingListAddressMaxLength {
+		return "", fmt.Sprintf("invalid mail address '%s'", text)
+	}
+	if len(config.Domains) == 0 {
+		return address, ""
+	}
+	domain := address[strings.LastIndex(address, "@")+1:]
+	for _, allowed := range config.Domains {
+		if domain == strings.ToLower(allowed) {
+			return address, ""
+		}
+	}
+	return "", fmt.Sprintf("mail address '%s' is not in the domains %s", text, strings.Join(config.Domains, ", "))
+}
+
+// groupMailAddressesFromForm returns the addresses and a message for the user
+// when they are invalid.
+func (state *RuntimeState) groupMailAddressesFromForm(r *http.Request) (groupMailAddresses, string) {
+	var addresses groupMailAddresses
+	var message string
+	addresses.Address, message = state.Config.MailingLists.parseMailAddress(r.PostFormValue("address"))
+	if message != "" {
+		return addresses, message
+	}
+	seen := map[string]bool{addresses.Address: true}
+	for _, text := range strings.FieldsFunc(r.PostFormValue("aliases"), func(c rune) bool {
+		return c == ',' || c == ' ' || c == '\t' || c == '\n' || c == '\r'
+	}) {
+		alias, message := state.Config.MailingLists.parseMailAddress(text)
+		if message != "" {
+			return addresses, message
+		}
+		if seen[alias] {
+			continue
+		}
+		seen[alias] = true
+		addresses.Aliases = append(addresses.Aliases, alias)
+	}
+	if len(addresses.Aliases) > maxMailAliasesPerGroup {
+		return addresses, fmt.Sprintf("a group can have at most %d aliases", maxMailAliasesPerGroup)
+	}
+	return addresses, ""
+}
+
+// checkMailAddressesUnused returns a message for the user when an address is
+// used by another group, a user or a service account.
+func (state *RuntimeState) checkMailAddressesUnused(groupname string, addresses groupMailAddresses) (string, error) {
+	for _, address := range addresses.all() {
+		groups, err := queryStringsFromDB(state, getMailAddressGroupStmt[state.dbType], address)
+		if err != nil {
+			return "", err
+		}
+		for _, owner := range groups {
+			if owner != groupname {
+				return fmt.Sprintf("mail address %s is used by the group %s", address, owner), nil
+			}
+		}
+		owners, err := state.Userinfo.GetMailOwners(address)
+		if err != nil {
+			return "", err
+		}
+		for _, owner := range owners {
+			if owner != groupname {
+				return fmt.Sprintf("mail address %s is used by %s", address, owner), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// groupMailHandler sets or removes the mail addresses of a group, it is used
+// by the group owners.
+func (state *RuntimeState) groupMailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("groupMailHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	groupname := r.PostFormValue("groupname")
+	groupExists, _, err := state.requestUserinfo(r).GroupnameExistsornot(groupname)
+	if err != nil {
+		requestLogger(r).Error("groupMailHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !groupExists {
+		state.writeFailureResponse(w, r, "group "+groupname+" does not exist", http.StatusBadRequest)
+		return
+	}
+	isGroupAdmin, err := state.isGroupAdmin(username, groupname)
+	if err != nil {
+		requestLogger(r).Error("groupMailHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !isGroupAdmin {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	var addresses groupMailAddresses
+	var message string
+	switch r.PostFormValue("action") {
+	case "set":
+		addresses, message = state.groupMailAddressesFromForm(r)
+		if message == "" {
+			message, err = state.checkMailAddressesUnused(groupname, addresses)
+			if err != nil {
+				requestLogger(r).Error("groupMailHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if message != "" {
+			state.writeFailureResponse(w, r, message, http.StatusBadRequest)
+			return
+		}
+	case "remove":
+	default:
+		state.writeFailureResponse(w, r, "action must be set or remove", http.StatusBadRequest)
+		return
+	}
+	err = state.requestUserinfo(r).SetGroupMail(groupname, addresses.all())
+	if err == nil {
+		err = setGroupMailAddressesInDB(groupname, addresses, username, state)
+	}
+	if err != nil {
+		requestLogger(r).Error("groupMailHandler failed", "err", err)
+		state.recordAuditEvent(r, username, auditActionSetGroupMail, groupname, "", auditOutcomeFailure, err.Error())
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	details := "removed mail addresses"
+	message = fmt.Sprintf("Group %s is no longer a mailing list", groupname)
+	if addresses.Address != "" {
+		details = "mail " + strings.Join(addresses.all(), ",")
+		message = fmt.Sprintf("Group %s is the mailing list %s", groupname, addresses.Address)
+	}
+	state.recordAuditEvent(r, username, auditActionSetGroupMail, groupname, "", auditOutcomeSuccess, details)
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:          "Group Mail Updated",
+		SuccessMessage: message,
+		ContinueURL:    groupinfoPath + "?groupname=" + groupname,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
+
+type mailWebhookNotification struct {
+	Group    string   `json:"group"`
+	Address  string   `json:"address"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Change   string   `json:"change"`
+	Username string   `json:"username"`
+	Actor    string   `json:"actor"`
+}
+
+// notifyMailingListChange posts the membership change of the event to the
+// mail webhook when the group is a mailing list.
+func (state *RuntimeState) notifyMailingListChange(event auditEvent) error {
+	config := state.Config.MailingLists
+	if config.WebhookURL == "" || !event.IsMembershipChange() {
+		return nil
+	}
+	addresses, err := getGroupMailAddressesFromDB(event.Groupname, state)
+	if err != nil {
+		return err
+	}
+	if addresses.Address == "" {
+		return nil
+	}
+	change := mailWebhookChangeAdded
+	if event.Action == auditActionRemoveMember || event.Action == auditActionExitGroup {
+		change = mailWebhookChangeRemoved
+	}
+	body, err := json.Marshal(mailWebhookNotification{Group: event.Groupname, Address: addresses.Address,
+		Aliases: addresses.Aliases, Change: change, Username: event.Username, Actor: event.Actor})
+	if err != nil {
+		return err
+	}
+	timeout := config.WebhookTimeout
+	if timeout == 0 {
+		timeout = defaultMailWebhookTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	req, err := state.newWebhookRequest(config.WebhookURL, body)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	metrics.MetricLogExternalServiceDuration("mail_webhook", time.Since(start))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("mail webhook failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestGroupMail(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.MailingLists.Domains = []string{"lists.example.com"}
+	code := testPostServiceAccountForm(t, &state, groupMailPath, state.groupMailHandler, false,
+		url.Values{"groupname": {"group2"}, "action": {"set"}, "address": {"Group2@lists.example.com"},
+			"aliases": {"g2@lists.example.com, group2@lists.example.com"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot set the mail of group2, got %d", code)
+	}
+	addresses, err := getGroupMailAddressesFromDB("group2", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := groupMailAddresses{Address: "group2@lists.example.com", Aliases: []string{"g2@lists.example.com"}}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Fatalf("bad addresses %+v", addresses)
+	}
+	owners, err := state.Userinfo.GetMailOwners("g2@lists.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(owners, []string{"group2"}) {
+		t.Fatalf("the group mail attribute was not set, owners %v", owners)
+	}
+
+	state.Config.MailingLists.Domains = append(state.Config.MailingLists.Domains, "example.com")
+	for _, form := range []url.Values{
+		{"address": {"g2@lists.example.com"}},
+		{"address": {"group3@lists.example.com"}, "aliases": {"group2@lists.example.com"}},
+		{"address": {"user1@example.com"}},
+		{"address": {"group3@other.example.com"}},
+		{"address": {"Group 3 <group3@lists.example.com>"}},
+	} {
+		form.Set("groupname", "group3")
+		form.Set("action", "set")
+		code = testPostServiceAccountForm(t, &state, groupMailPath, state.groupMailHandler, false, form)
+		if code != http.StatusBadRequest {
+			t.Errorf("setting %v should fail, got %d", form, code)
+		}
+	}
+
+	code = testPostServiceAccountForm(t, &state, groupMailPath, state.groupMailHandler, false,
+		url.Values{"groupname": {"group2"}, "action": {"remove"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot remove the mail of group2, got %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, groupMailPath, state.groupMailHandler, false,
+		url.Values{"groupname": {"group3"}, "action": {"set"}, "address": {"g2@lists.example.com"}})
+	if code != http.StatusOK {
+		t.Fatalf("a removed address cannot be used again, got %d", code)
+	}
+}
+
+func TestNotifyMailingListChange(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notifications []mailWebhookNotification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification mailWebhookNotification
+		err := json.NewDecoder(r.Body).Decode(&notification)
+		if err != nil {
+			t.Error(err)
+		}
+		notifications = append(notifications, notification)
+	}))
+	defer server.Close()
+	state.Config.MailingLists.WebhookURL = server.URL
+	err = setGroupMailAddressesInDB("webhook-list", groupMailAddresses{Address: "webhook-list@example.com"},
+		"user1", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []auditEvent{
+		{Actor: "user1", Action: auditActionAddMember, Groupname: "webhook-list", Username: "user3", Outcome: auditOutcomeSuccess},
+		{Actor: "user3", Action: auditActionExitGroup, Groupname: "webhook-list", Username: "user3", Outcome: auditOutcomeSuccess},
+		{Actor: "user1", Action: auditActionAddMember, Groupname: "webhook-list", Username: "user2", Outcome: auditOutcomeFailure},
+		{Actor: "user1", Action: auditActionAddMember, Groupname: "group1", Username: "user3", Outcome: auditOutcomeSuccess},
+	} {
+		err = state.notifyMailingListChange(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []mailWebhookNotification{
+		{Group: "webhook-list", Address: "webhook-list@example.com", Change: mailWebhookChangeAdded, Username: "user3", Actor: "user1"},
+		{Group: "webhook-list", Address: "webhook-list@example.com", Change: mailWebhookChangeRemoved, Username: "user3", Actor: "user3"},
+	}
+	if !reflect.DeepEqual(notifications, expected) {
+		t.Fatalf("bad notifications %+v", notifications)
+	}
+}
@@ -2,26 +2,35 @@ package main
 
 import (
 	"bufio"
-	"crypto/tls"
-	"crypto/x509"
-	"database/sql"
+	"crypto"
+	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/ldap-group-management/lib/redis"
 	"github.com/Symantec/ldap-group-management/lib/userinfo"
 	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/acme/autocert"
 	"gopkg.in/natefinch/lumberjack.v2"
 	"gopkg.in/yaml.v2"
 	"html/template"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"log/syslog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/Symantec/ldap-group-management/lib/authn"
@@ -35,11 +44,33 @@ type baseConfig struct {
 	TemplatesPath               string `yaml:"templates_path"`
 	SMTPserver                  string `yaml:"smtp_server"`
 	SmtpSenderAddress           string `yaml:"smtp_sender_address"`
+	SMTPUsername                string `yaml:"smtp_username"`
+	SMTPPassword                string `yaml:"smtp_password"`
 	ClientCAFilename            string `yaml:"client_ca_filename"`
 	LogDirectory                string `yaml:"log_directory"`
 	ClusterSharedSecretFilename string `yaml:"cluster_shared_secret_filename"`
 	SharedSecrets               []string
 	Hostname                    string `yaml:"hostname"`
+	// LDAPQueryConcurrency bounds the LDAP queries made in parallel for
+	// the groups of a listing.
+	LDAPQueryConcurrency int `yaml:"ldap_query_concurrency"`
+	// TemplatesDevMode parses the templates on every request and serves
+	// the static assets from the disk, for template development only.
+	TemplatesDevMode bool `yaml:"templates_dev_mode"`
+	// OverridePath is an optional directory with *.tmpl files and css,
+	// images and js directories which replace the templates and the static
+	// assets of the same name, for the local branding.
+	OverridePath string `yaml:"override_path"`
+	// DebugEndpoints serves the pprof profiles and the expvar variables
+	// under /debug/ to the admins.
+	DebugEndpoints bool `yaml:"debug_endpoints"`
+	// SkipStartupMigrations leaves the schema migrations to the migrate
+	// command, the server then refuses to start on an older schema.
+	SkipStartupMigrations bool `yaml:"skip_startup_migrations"`
+	// ProvisionSharedSecrets generates the missing shared secret on the
+	// first start: "file" writes it to ClusterSharedSecretFilename, "db"
+	// stores it in the database.
+	ProvisionSharedSecrets string `yaml:"provision_shared_secrets"`
 }
 
 type AppConfigFile struct {
@@ -47,6 +78,48 @@ type AppConfigFile struct {
 	OpenID     authn.OpenIDConfig              `yaml:"openid"`
 	SourceLDAP ldapuserinfo.UserInfoLDAPSource `yaml:"source_config"`
 	TargetLDAP ldapuserinfo.UserInfoLDAPSource `yaml:"target_config"`
+	Audit      auditConfig                     `yaml:"audit"`
+	History    membershipHistoryConfig         `yaml:"membership_history"`
+
+	ComplianceReports complianceReportConfig  `yaml:"compliance_reports"`
+	Retention         retentionConfig         `yaml:"retention"`
+	ServiceAccounts   serviceAccountConfig    `yaml:"service_accounts"`
+	GidAllocation     gidAllocationConfig     `yaml:"gid_allocation"`
+	MailingLists      mailingListConfig       `yaml:"mailing_lists"`
+	GroupArchive      groupArchiveConfig      `yaml:"group_archive"`
+	GroupListingCache groupListingCacheConfig `yaml:"group_listing_cache"`
+	DirectorySync     directorySyncConfig     `yaml:"directory_sync"`
+	SQLDirectory      sqlDirectoryConfig      `yaml:"sql_directory"`
+	RateLimits        rateLimitConfig         `yaml:"rate_limits"`
+	Logging           loggingConfig           `yaml:"logging"`
+	AccessLog         accessLogConfig         `yaml:"access_log"`
+	ErrorReporting    errorReportingConfig    `yaml:"error_reporting"`
+	Tracing           tracingConfig           `yaml:"tracing"`
+	Database          dbPoolConfig            `yaml:"database"`
+	DBEncryption      dbEncryptionConfig      `yaml:"db_encryption"`
+	LeaderElection    leaderElectionConfig    `yaml:"leader_election"`
+	Redis             redisConfig             `yaml:"redis"`
+	SQLite            sqliteConfig            `yaml:"sqlite"`
+	MembershipUndo    membershipUndoConfig    `yaml:"membership_undo"`
+	Theme             themeConfig             `yaml:"theme"`
+	Shutdown          shutdownConfig          `yaml:"shutdown"`
+	SCIM              scimConfig              `yaml:"scim"`
+	SCIMProvisioning  scimProvisioningConfig  `yaml:"scim_provisioning"`
+	GitHubTeamSync    githubTeamSyncConfig    `yaml:"github_team_sync"`
+	Okta              oktaConfig              `yaml:"okta"`
+	AWSIdentityCenter awsIdentityCenterConfig `yaml:"aws_identity_center"`
+	EventPublishing   eventPublishingConfig   `yaml:"event_publishing"`
+	HRWebhook         hrWebhookConfig         `yaml:"hr_webhook"`
+	SecurityHeaders   securityHeadersConfig   `yaml:"security_headers"`
+	Ticketing         ticketingConfig         `yaml:"ticketing"`
+	Secrets           secretsConfig           `yaml:"secrets"`
+	BruteForce        bruteForceConfig        `yaml:"brute_force"`
+	WebhookSigning    webhookSigningConfig    `yaml:"webhook_signing"`
+	Reauthentication  reauthenticationConfig  `yaml:"reauthentication"`
+	AdminNetwork      adminNetworkConfig      `yaml:"admin_network"`
+	TLS               tlsServerConfig         `yaml:"tls"`
+
+	PrivilegeEscalation privilegeEscalationConfig `yaml:"privilege_escalation"`
 }
 
 type pendingUserActionsCacheEntry struct {
@@ -57,17 +130,63 @@ type pendingUserActionsCacheEntry struct {
 type RuntimeState struct {
 	Config         AppConfigFile
 	dbType         string
-	db             *sql.DB
-	Userinfo       userinfo.UserInfo
-	UserSourceinfo userinfo.UserInfo
+	db             *instrumentedDB
+	Userinfo       userinfo.UserInfoBackend
+	UserSourceinfo userinfo.UserInfoBackend
 	htmlTemplate   *template.Template
 	sysLog         *syslog.Writer
 	authenticator  *authn.Authenticator
+	auditSink      *auditSyslogSink
+	auditSigner    crypto.Signer
+
+	credentialRotator         credentialRotator
+	serviceAccountNamePattern *regexp.Regexp
 
 	allUsersRWLock               sync.RWMutex
 	allUsersCacheValue           map[string]time.Time
 	pendingUserActionsCacheMutex sync.Mutex
 	pendingUserActionsCache      map[string]pendingUserActionsCacheEntry
+	pendingRequestsCleanupMutex  sync.Mutex
+	pendingRequestsCleanupStart  time.Time
+	auditChainMutex              sync.Mutex
+	gidAllocationMutex           sync.Mutex
+	directoryMirror              *mirroredUserInfo
+	sqlDirectory                 *sqlUserInfo
+	staticAssets                 *staticAssets
+	tracerProvider               trace.TracerProvider
+	accessLogger                 *accessLogger
+	errorReporter                *errorReporter
+	valueEncrypter               *valueEncrypter
+	leaderElector                *leaderElector
+	redisClient                  *redis.Client
+	// dryRun is set by the -dry-run flag.
+	dryRun bool
+	// devMode is set by the -devmode flag.
+	devMode      bool
+	jobs         jobRunner
+	shuttingDown atomic.Bool
+	// scimTokens are the names of the SCIM clients by the SHA-256 of their
+	// bearer tokens.
+	scimTokens map[[sha256.Size]byte]string
+	// githubTeamSyncMutex runs one GitHub team sync at a time.
+	githubTeamSyncMutex sync.Mutex
+	// oktaPushMutex runs one Okta group push at a time.
+	oktaPushMutex sync.Mutex
+	// awsIdentityCenterMutex runs one AWS Identity Center sync at a time.
+	awsIdentityCenterMutex sync.Mutex
+	// groupSyncs coalesces the syncs of the changed groups to GitHub, Okta
+	// and AWS.
+	groupSyncs *groupSyncQueue
+	// eventPublishers publish the audit events to the configured sinks.
+	eventPublishers []eventPublisher
+	// hrWebhookSecret signs the requests of the HR webhook.
+	hrWebhookSecret []byte
+	// secrets reads the secrets from Vault.
+	secrets secretStore
+	// authFailures delays and locks out the failed authentications.
+	authFailures *authFailureTracker
+	// webhookSigningSecret signs the webhooks sent.
+	webhookSigningSecret []byte
 }
 
 type GetGroups struct {
@@ -98,17 +217,24 @@ type Response struct {
 	GroupUsers          []string
 }
 
-type httpLogger struct {
-	AccessLogger *log.Logger
-}
-
 var (
 	Version        = "No version provided"
 	configFilename = flag.String("config", "/etc/smallpoint/config.yml", "The filename of the configuration")
+	checkConfig    = flag.Bool("check-config", false, "Validate the configuration, print its problems and exit")
+	probeConfig    = flag.Bool("probe", false, "With -check-config, also connect to the LDAP, OpenID, SMTP and database servers")
+	dryRun         = flag.Bool("dry-run", false, "Log the LDAP changes instead of applying them")
+	devMode        = flag.Bool("devmode", false, "Run on plain HTTP with an in-memory directory of sample users and groups, for development only")
+	printVersion   = flag.Bool("version", false, "Print the version and exit")
 )
 
 const (
 	metricsPath                 = "/metrics"
+	healthzPath                 = "/healthz"
+	readyzPath                  = "/readyz"
+	versionPath                 = "/api/version"
+	scimPath                    = "/scim/v2/"
+	githubTeamsPath             = "/github_teams"
+	oktaGroupsPath              = "/okta_groups"
 	cacheRefreshDuration        = 6 * time.Hour
 	descriptionAttribute        = "self-managed"
 	cookieExpirationHours       = 12
@@ -136,6 +262,47 @@ const (
 	changeownershipbuttonPath   = "/change_owner/"
 	changeownershipPath         = "/change_owner"
 	myManagedGroupsWebPagePath  = "/my_managed_groups"
+	auditLogPath                = "/audit_log"
+	accessReportPath            = "/access_report"
+	groupHistoryPath            = "/group_history"
+	auditEvidencePath           = "/audit_evidence"
+	serviceAccountsPath         = "/service_accounts"
+	reviewServiceAccountPath    = "/review_serviceaccount/"
+	changeSAOwnerWebPagePath    = "/change_serviceaccount_owner"
+	changeSAOwnerPath           = "/change_serviceaccount_owner/"
+	serviceAccountTakeoverPath  = "/serviceaccount_takeover/"
+	credentialRotationsPath     = "/serviceaccount_credentials"
+	groupClassificationPath     = "/group_classification/"
+	serviceAccountLifecyclePath = "/serviceaccount_lifecycle/"
+	serviceAccountApprovalPath  = "/serviceaccount_lifecycle_approval/"
+	serviceAccountInfoPath      = "/serviceaccount_info"
+	importServiceAccountsPath   = "/import_serviceaccounts"
+	importGroupsPath            = "/import_groups"
+	exportGroupsPath            = "/export_groups"
+	serviceAccountRequestPath   = "/serviceaccount_request/"
+	serviceAccountsAPIPath      = "/api/v1/serviceaccounts"
+	groupMetadataPath           = "/group_metadata/"
+	groupTagsPath               = "/group_tags/"
+	groupTemplatesPath          = "/group_templates"
+	groupMailPath               = "/group_mail/"
+	groupArchivePath            = "/group_archive"
+	renameGroupPath             = "/rename_group/"
+	mergeGroupPath              = "/merge_group/"
+	cloneGroupPath              = "/clone_group/"
+	allGroupsTablePath          = "/api/v1/tables/all_groups"
+	groupMembersTablePath       = "/api/v1/tables/group_members"
+	myGroupsTablePath           = "/api/v1/tables/my_groups"
+	managedGroupsTablePath      = "/api/v1/tables/managed_groups"
+	accessRequestsAPIPath       = "/api/v1/requests"
+	userSearchAPIPath           = "/api/v1/users/search"
+	groupsAPIPath               = "/api/v1/groups/"
+	hrWebhookPath               = "/hr_webhook"
+	membershipUndoPath          = "/membership_undo"
+	heldChangesPath             = "/held_changes"
+	userPreferencesPath         = "/preferences"
+	myRequestsPath              = "/my_requests"
+	profilePath                 = "/profile"
+	cancelRequestPath           = "/cancel_request"
 
 	getGroupsJSPath = "/getGroups.js"
 	getUsersJSPath  = "/getUsers.js"
@@ -147,50 +314,125 @@ const (
 	jsPath     = "/js/"
 )
 
-func (l httpLogger) Log(record instrumentedwriter.LogRecord) {
-	if l.AccessLogger != nil {
-		l.AccessLogger.Printf("%s -  %s [%s] \"%s %s %s\" %d %d \"%s\"\n",
-			record.Ip, record.Username, record.Time, record.Method,
-			record.Uri, record.Protocol, record.Status, record.Size, record.UserAgent)
-	}
-}
-
 func (state *RuntimeState) loadTemplates() (err error) {
 
-	state.htmlTemplate = template.New("main")
-
 	//Load extra templates
-	templatesPath := state.Config.Base.TemplatesPath
-	if _, err = os.Stat(templatesPath); err != nil {
-		return err
+	for _, dir := range []string{state.Config.Base.TemplatesPath, state.Config.Base.OverridePath} {
+		if dir == "" {
+			continue
+		}
+		if _, err = os.Stat(dir); err != nil {
+			return err
+		}
 	}
 
-	//Eventally this will include the customization path
-	templateFiles := []string{}
-	for _, templateFilename := range templateFiles {
-		templatePath := filepath.Join(templatesPath, templateFilename)
-		_, err = state.htmlTemplate.ParseFiles(templatePath)
+	// the pages link to the hashed names of the static assets, in dev mode
+	// they link to the files
+	state.staticAssets = nil
+	if !state.Config.Base.TemplatesDevMode {
+		staticDirs := []string{cssPath, imagesPath, jsPath}
+		state.staticAssets, err = loadStaticAssets(state.templateFileSystems(), staticDirs,
+			http.FileServer(state.staticFileSystem()))
 		if err != nil {
 			return err
 		}
 	}
+	state.htmlTemplate, err = state.parseTemplates()
+	return err
+}
+
+// templateFileSystems returns the built in templates, then the templates
+// path and the override directory when they are set. The files of a file
+// system replace the ones of the same name of the previous ones.
+func (state *RuntimeState) templateFileSystems() []fs.FS {
+	fileSystems := []fs.FS{embeddedTemplatesFS()}
+	for _, dir := range []string{state.Config.Base.TemplatesPath, state.Config.Base.OverridePath} {
+		if dir != "" {
+			fileSystems = append(fileSystems, os.DirFS(dir))
+		}
+	}
+	return fileSystems
+}
+
+// staticFileSystem serves the static files of the override directory before
+// the ones of the templates path and the built in ones.
+func (state *RuntimeState) staticFileSystem() http.FileSystem {
+	var layers layeredFileSystem
+	for _, fileSystem := range state.templateFileSystems() {
+		layers = append(layeredFileSystem{http.FS(fileSystem)}, layers...)
+	}
+	return layers
+}
+
+// parseTemplates parses the built in templates, then the *.tmpl files of the
+// templates path and of the override directory which replace the templates
+// they define.
+func (state *RuntimeState) parseTemplates() (*template.Template, error) {
+	htmlTemplate := template.New("main").Funcs(template.FuncMap{
+		"asset":    state.staticAssets.url,
+		"theme":    state.Config.Theme.withDefaults,
+		"darkMode": state.userPrefersDarkMode,
+	})
 
 	/// Load the oter built in templates
 	extraTemplates := []string{commonCSSText, commonJSText, headerHTMLText,
-		footerHTMLText, sidebarHTMLText, myGroupsPageText, allGroupsPageText,
+		footerHTMLText, brandingHTMLText, themeCSSText, sidebarHTMLText, myGroupsPageText, allGroupsPageText,
 		pendingRequestsPageText, pendingActionsPageText,
 		createGroupPageText, deleteGroupPageText,
 		simpleMessagePageText, addMembersToGroupPageText, groupInfoPageText,
 		createServiceAccountPageText, changeGroupOwnershipPageText,
-		deleteMembersFromGroupPageText, commonHeadText}
+		deleteMembersFromGroupPageText, commonHeadText, auditLogPageText,
+		accessReportPageText, groupHistoryPageText, serviceAccountsPageText,
+		changeServiceAccountOwnerPageText, credentialRotationsPageText,
+		serviceAccountInfoPageText, serviceAccountImportPageText,
+		groupTemplatesPageText, groupArchivePageText,
+		groupMergePageText, directorySyncHTMLText, membershipUndoPageText,
+		myRequestsPageText,
+		profilePageText, groupImportPageText, githubTeamsPageText, oktaGroupsPageText,
+		heldChangesPageText}
 	for _, templateString := range extraTemplates {
-		_, err = state.htmlTemplate.Parse(templateString)
+		_, err := htmlTemplate.Parse(templateString)
 		if err != nil {
-			return err
+			return nil, err
+		}
+	}
+	for _, fileSystem := range state.templateFileSystems() {
+		templateFiles, err := fs.Glob(fileSystem, "*.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		if len(templateFiles) > 0 {
+			_, err = htmlTemplate.ParseFS(fileSystem, templateFiles...)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	return nil
+	// html/template escapes a template when it is first executed, the
+	// escaping errors are reported here instead of on the first request.
+	// Running the templates without data fails for other reasons, those
+	// errors are ignored.
+	for _, pageTemplate := range htmlTemplate.Templates() {
+		err := pageTemplate.Execute(ioutil.Discard, nil)
+		if escapeErr, ok := err.(*template.Error); ok {
+			return nil, escapeErr
+		}
+	}
+	return htmlTemplate, nil
+}
+
+// executeTemplate renders the named template, in dev mode the templates are
+// parsed again to pick the changes made to the files.
+func (state *RuntimeState) executeTemplate(w io.Writer, name string, data interface{}) error {
+	if !state.Config.Base.TemplatesDevMode {
+		return state.htmlTemplate.ExecuteTemplate(w, name, data)
+	}
+	htmlTemplate, err := state.parseTemplates()
+	if err != nil {
+		return err
+	}
+	return htmlTemplate.ExecuteTemplate(w, name, data)
 }
 
 func getClusterSecretsFile(clusterSecretsFilename string) ([]string, error) {
@@ -223,54 +465,125 @@ func loadConfig(configFilename string) (RuntimeState, error) {
 
 	var state RuntimeState
 
+	err := readConfigFile(configFilename, &state.Config)
+	if err != nil {
+		return state, err
+	}
+	err = state.setupFromConfig()
+	return state, err
+}
+
+func readConfigFile(configFilename string, config *AppConfigFile) error {
 	if _, err := os.Stat(configFilename); os.IsNotExist(err) {
 		err = fmt.Errorf("mising config file failure. Filename=%s", configFilename)
-		return state, err
+		return err
 	}
 	//ioutil.ReadFile returns a byte slice (i.e)(source)
 	source, err := ioutil.ReadFile(configFilename)
 	if err != nil {
 		err = errors.New("cannot read config file")
-		return state, err
+		return err
 	}
 
 	//Unmarshall(source []byte,out interface{})decodes the source byte slice/value and puts them in out.
-	err = yaml.Unmarshal(source, &state.Config)
+	err = yaml.Unmarshal(source, config)
 
 	if err != nil {
 		err = errors.New("Cannot parse config file")
-		log.Printf("Source=%s", source)
-		return state, err
+		slog.Debug("Cannot parse config file", "source", string(source))
+		return err
 	}
+	return nil
+}
 
+// setupFromConfig sets up the templates, the database, the directories and
+// the authenticator of the loaded config.
+func (state *RuntimeState) setupFromConfig() error {
 	//Load extra templates
-	err = state.loadTemplates()
+	err := state.loadTemplates()
 	if err != nil {
-		return state, err
+		return err
 	}
 
-	err = initDB(&state)
+	err = initDB(state)
 	if err != nil {
-		return state, err
+		return err
 	}
 
 	state.Userinfo = &state.Config.TargetLDAP
 	state.allUsersCacheValue = make(map[string]time.Time)
 	state.pendingUserActionsCache = make(map[string]pendingUserActionsCacheEntry)
 	state.UserSourceinfo = &state.Config.SourceLDAP
+	if state.Config.SQLDirectory.Enabled {
+		directory := newSQLUserInfo(state, state.Config.SQLDirectory)
+		state.sqlDirectory = directory
+		state.Userinfo = directory
+		if state.Config.SourceLDAP.LDAPTargetURLs == "" {
+			state.UserSourceinfo = sqlDirectoryUserSource{directory}
+		}
+	}
 
+	err = checkSharedSecretProvisioning(state.Config.Base.ProvisionSharedSecrets,
+		state.Config.Base.ClusterSharedSecretFilename)
+	if err != nil {
+		return err
+	}
 	if len(state.Config.Base.ClusterSharedSecretFilename) > 1 {
 		state.Config.Base.SharedSecrets, err = getClusterSecretsFile(state.Config.Base.ClusterSharedSecretFilename)
+		if os.IsNotExist(err) && state.Config.Base.ProvisionSharedSecrets == sharedSecretProvisioningFile {
+			state.Config.Base.SharedSecrets, err = provisionClusterSecretsFile(
+				state.Config.Base.ClusterSharedSecretFilename)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if state.Config.Secrets.enabled() {
+		err = state.loadSecrets()
 		if err != nil {
-			return state, err
+			return err
+		}
+	}
+	state.setSMTPCredentials(state.Config.Base.SMTPUsername, state.Config.Base.SMTPPassword)
+	state.valueEncrypter, err = loadValueEncrypter(state.Config.DBEncryption)
+	if err != nil {
+		return err
+	}
+	if state.Config.Redis.Address != "" {
+		state.redisClient = redis.New(state.Config.Redis.Config)
+		if len(state.Config.Base.SharedSecrets) == 0 {
+			secret, err := getRedisSessionSecret(state.redisClient, state.Config.Redis.keyPrefix())
+			if err != nil {
+				return err
+			}
+			state.Config.Base.SharedSecrets = []string{secret}
 		}
 	}
+	if len(state.Config.Base.SharedSecrets) == 0 &&
+		state.Config.Base.ProvisionSharedSecrets == sharedSecretProvisioningDB {
+		secret, err := state.provisionDBSharedSecret()
+		if err != nil {
+			return err
+		}
+		state.Config.Base.SharedSecrets = []string{secret}
+	}
+	err = checkSharedSecrets(state.Config.Base.SharedSecrets)
+	if err != nil {
+		return err
+	}
+	if len(state.Config.Base.SharedSecrets) == 0 {
+		slog.Warn("no shared secrets, the sessions end on restart and are not shared by the instances")
+	}
 	//
-	state.authenticator = authn.NewAuthenticator(state.Config.OpenID, "smallpoint", nil,
-		state.Config.Base.SharedSecrets, nil,
-		nil)
+	// the calls to the OpenID provider are traced once tracing is set up
+	netClient := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	state.authenticator = authn.NewAuthenticator(state.Config.OpenID, "smallpoint", netClient,
+		state.Config.Base.SharedSecrets, nil)
+	state.authFailures = newAuthFailureTracker(state.Config.BruteForce, state.authenticator)
+	state.authenticator.SetSecurityEventFunc(state.authFailures.authnSecurityEvent)
+	state.groupSyncs = newGroupSyncQueue(groupSyncDelay, state.syncGroupDownstream)
 
-	return state, err
+	return err
 }
 
 type mailAttributes struct {
@@ -284,6 +597,26 @@ type mailAttributes struct {
 
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s (version %s):\n", os.Args[0], Version)
+	fmt.Fprintf(os.Stderr, "  %s [flags] [command]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  init [-force] [-no-probe] [FILE]\twrite a new configuration file from the answers to questions and exit\n")
+	fmt.Fprintf(os.Stderr, "  verify-audit\tverify the integrity of the audit log and exit\n")
+	fmt.Fprintf(os.Stderr, "  audit [-actor USER] [-user USER] [-group GROUP] [-action ACTION] [-from DATE] [-to DATE] [-format text|csv|json] [FILE]\n")
+	fmt.Fprintf(os.Stderr, "    \tsearch the audit log in the database, with the web service down too, and exit\n")
+	fmt.Fprintf(os.Stderr, "  doctor [-read-only]\tcheck the TLS, the bind, the base DNs, the schema and the write permission of the directories and exit\n")
+	fmt.Fprintf(os.Stderr, "  migrate [-status]\tapply the pending schema migrations and exit\n")
+	fmt.Fprintf(os.Stderr, "  bootstrap [-admin-group NAME]\tset up the schema, the base DNs and the admin group of a new deployment and exit\n")
+	fmt.Fprintf(os.Stderr, "  reencrypt-secrets\tseal the stored secrets under the current encryption key and exit\n")
+	fmt.Fprintf(os.Stderr, "  backup FILE\twrite the application state to a new portable backup file and exit\n")
+	fmt.Fprintf(os.Stderr, "  restore FILE\tload a backup file into an empty database and exit\n")
+	fmt.Fprintf(os.Stderr, "  import-serviceaccounts FILE\timport existing service accounts from a CSV file and exit\n")
+	fmt.Fprintf(os.Stderr, "  import-groups FILE\tchange the groups to match a YAML or CSV file and exit\n")
+	fmt.Fprintf(os.Stderr, "  export-groups [-format yaml|csv] [FILE]\twrite the groups to a YAML or CSV file and exit\n")
+	fmt.Fprintf(os.Stderr, "  loadtest [-users N] [-groups N] [-requests N] [-concurrency N] [-prefix P] [-cleanup=false]\n")
+	fmt.Fprintf(os.Stderr, "    \tpopulate the configured test directory, drive request and approval traffic and exit\n")
+	fmt.Fprintf(os.Stderr, "  generate [-users N] [-groups N] [-members N] [-subgroups N] [-requests N] [-prefix P] [-seed N]\n")
+	fmt.Fprintf(os.Stderr, "    \twith -devmode, fill the in-memory directory and the database with synthetic data and serve\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
 	flag.PrintDefaults()
 }
 
@@ -291,19 +624,258 @@ func main() {
 	flag.Usage = Usage
 	flag.Parse()
 
-	state, err := loadConfig(*configFilename)
+	if *printVersion {
+		writeVersion(os.Stdout, buildVersionInfo())
+		os.Exit(0)
+	}
+	if *checkConfig {
+		os.Exit(checkConfigCommand(*configFilename, *probeConfig, os.Stdout))
+	}
+	// there is no configuration to load yet
+	if flag.Arg(0) == "init" {
+		os.Exit(initConfigCommand(*configFilename, flag.Args()[1:], os.Stdin, os.Stdout))
+	}
+	var state RuntimeState
+	var err error
+	if *devMode {
+		state, err = loadDevModeConfig(*configFilename)
+	} else {
+		state, err = loadConfig(*configFilename)
+	}
 	if err != nil {
 		panic(err)
 	}
+	err = setupLogging(state.Config.Logging)
+	if err != nil {
+		log.Fatalf("Invalid logging config err: %s", err)
+	}
+	err = state.setupTracing()
+	if err != nil {
+		log.Fatalf("Cannot set up tracing err: %s", err)
+	}
+	// the mirror keeps the state to reach the DB, it is set up once the
+	// state is in place
+	if state.Config.DirectorySync.Interval > 0 {
+		state.directoryMirror, err = newMirroredUserInfo(state.Userinfo, &state,
+			state.Config.TargetLDAP.GroupSearchBaseDNs, state.Config.DirectorySync)
+		if err != nil {
+			log.Fatalf("Cannot load the directory mirror err: %s", err)
+		}
+		state.Userinfo = state.directoryMirror
+	}
+	if state.Config.GroupListingCache.ttl() > 0 {
+		cached := newCachedUserInfo(state.Userinfo, state.Config.GroupListingCache.ttl())
+		if state.redisClient != nil {
+			cached.store = newRedisGroupListingStore(state.redisClient, state.Config.Redis.keyPrefix())
+		}
+		state.Userinfo = cached
+	}
+	if *dryRun {
+		state.dryRun = true
+		state.Userinfo = newDryRunUserInfo(state.Userinfo, slog.Default(), state.Config.TargetLDAP.GroupManageAttribute)
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "verify-audit":
+		os.Exit(verifyAuditCommand(&state))
+	case "audit":
+		os.Exit(auditQueryCommand(&state, flag.Args()[1:]))
+	case "doctor":
+		os.Exit(doctorCommand(&state, flag.Args()[1:]))
+	case "migrate":
+		os.Exit(migrateCommand(&state, flag.Args()[1:]))
+	case "bootstrap":
+		os.Exit(bootstrapCommand(&state, flag.Args()[1:]))
+	case "reencrypt-secrets":
+		os.Exit(reencryptSecretsCommand(&state))
+	case "backup":
+		os.Exit(backupCommand(&state, flag.Args()[1:]))
+	case "restore":
+		os.Exit(restoreCommand(&state, flag.Args()[1:]))
+	case "import-serviceaccounts":
+		os.Exit(importServiceAccountsCommand(&state, flag.Arg(1)))
+	case "import-groups":
+		os.Exit(importGroupsCommand(&state, flag.Args()[1:]))
+	case "export-groups":
+		os.Exit(exportGroupsCommand(&state, flag.Args()[1:]))
+	case "loadtest":
+		os.Exit(loadTestCommand(&state, flag.Args()[1:]))
+	case "generate":
+		if code := generateDevDataCommand(&state, flag.Args()[1:]); code != 0 {
+			os.Exit(code)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if state.Config.Base.SkipStartupMigrations {
+		err = checkDBSchema(&state)
+		if err != nil {
+			log.Fatalf("Cannot use the database err: %s", err)
+		}
+	}
+
+	if state.Config.Audit.SignChain {
+		state.auditSigner, err = loadAuditChainSigner(state.Config.Base)
+		if err != nil {
+			log.Fatalf("Cannot load audit chain signing key err: %s", err)
+		}
+	}
 
 	//start to log
 	state.sysLog, err = syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTHPRIV, "smallpoint")
 	if err != nil {
-		log.Fatalf("System log failed")
+		if !state.devMode {
+			log.Fatalf("System log failed")
+		}
+		slog.Warn("no system log", "err", err)
+	} else {
+		defer state.sysLog.Close()
+	}
+
+	if state.Config.Audit.Syslog.Address != "" {
+		state.auditSink, err = newAuditSyslogSink(state.Config.Audit.Syslog)
+		if err != nil {
+			log.Fatalf("Cannot setup audit syslog sink err: %s", err)
+		}
+	}
+
+	if state.Config.LeaderElection.Enabled {
+		state.leaderElector, err = newLeaderElector(&state, state.Config.LeaderElection)
+		if err != nil {
+			log.Fatalf("Cannot set up leader election err: %s", err)
+		}
+		state.leaderElector.start()
+	}
+	if state.Config.History.SnapshotInterval > 0 {
+		state.startPeriodicJob("membership_snapshot", state.Config.History.SnapshotInterval,
+			state.recordMembershipSnapshot)
+	}
+	if len(state.Config.ComplianceReports.Periods) > 0 {
+		err = validateComplianceReportConfig(state.Config.ComplianceReports)
+		if err != nil {
+			log.Fatalf("Invalid compliance report config err: %s", err)
+		}
+		state.startPeriodicJob("compliance_reports", complianceCheckInterval,
+			state.runComplianceReports)
+	}
+	if state.Config.Retention.enabled() {
+		err = validateRetentionConfig(state.Config.Retention)
+		if err != nil {
+			log.Fatalf("Invalid retention config err: %s", err)
+		}
+		state.startPeriodicJob("retention", retentionCheckInterval, state.runRetention)
+	}
+	state.credentialRotator, err = newCredentialRotator(state.Config.ServiceAccounts.CredentialRotation,
+		state.Userinfo)
+	if err != nil {
+		log.Fatalf("Invalid credential rotation config err: %s", err)
+	}
+	state.serviceAccountNamePattern, err = state.Config.ServiceAccounts.Naming.compilePattern()
+	if err != nil {
+		log.Fatalf("Invalid service account naming pattern err: %s", err)
+	}
+	err = state.Config.GidAllocation.check()
+	if err != nil {
+		log.Fatalf("Invalid gid allocation config err: %s", err)
+	}
+	err = state.Config.Theme.check()
+	if err != nil {
+		log.Fatalf("Invalid theme config err: %s", err)
+	}
+	err = state.Config.SCIMProvisioning.check()
+	if err != nil {
+		log.Fatalf("Invalid SCIM provisioning config err: %s", err)
+	}
+	err = state.Config.GitHubTeamSync.check()
+	if err != nil {
+		log.Fatalf("Invalid GitHub team sync config err: %s", err)
+	}
+	err = state.Config.Okta.check()
+	if err != nil {
+		log.Fatalf("Invalid Okta config err: %s", err)
+	}
+	err = state.Config.AWSIdentityCenter.check()
+	if err != nil {
+		log.Fatalf("Invalid AWS Identity Center config err: %s", err)
+	}
+	err = state.Config.EventPublishing.check()
+	if err != nil {
+		log.Fatalf("Invalid event publishing config err: %s", err)
+	}
+	err = state.Config.Ticketing.check()
+	if err != nil {
+		log.Fatalf("Invalid ticketing config err: %s", err)
+	}
+	err = state.Config.SecurityHeaders.check()
+	if err != nil {
+		log.Fatalf("Invalid security headers config err: %s", err)
+	}
+	err = state.Config.Secrets.check()
+	if err != nil {
+		log.Fatalf("Invalid secrets config err: %s", err)
+	}
+	err = state.Config.BruteForce.check()
+	if err != nil {
+		log.Fatalf("Invalid brute force config err: %s", err)
+	}
+	err = state.Config.Reauthentication.check()
+	if err != nil {
+		log.Fatalf("Invalid reauthentication config err: %s", err)
+	}
+	err = state.Config.TLS.check(state.Config.Base, state.Config.Audit)
+	if err != nil {
+		log.Fatalf("Invalid TLS config err: %s", err)
+	}
+	err = state.Config.PrivilegeEscalation.check()
+	if err != nil {
+		log.Fatalf("Invalid privilege escalation config err: %s", err)
+	}
+	smtpAuth = state.smtpAuth
+	if state.secrets.client != nil {
+		state.startSecretsRefresh()
+	}
+	state.eventPublishers = state.Config.EventPublishing.publishers()
+	state.startPeriodicJob("service_account_reviews", serviceAccountReviewCheckInterval,
+		state.runServiceAccountReviews)
+	state.startPeriodicJob("service_account_deletions", serviceAccountReviewCheckInterval,
+		state.runServiceAccountDeletions)
+	state.startPeriodicJob("group_archive_deletions", serviceAccountReviewCheckInterval,
+		state.runGroupArchiveDeletions)
+	state.startDBHealthChecks()
+	if state.dbType == "sqlite" {
+		state.startPeriodicJob("sqlite_maintenance", state.Config.SQLite.maintenanceInterval(),
+			state.runSQLiteMaintenance)
+	}
+	if len(state.Config.SCIMProvisioning.Targets) > 0 {
+		state.startPeriodicJob("scim_push", state.Config.SCIMProvisioning.interval(), state.runSCIMPushes)
+	}
+	if state.Config.GitHubTeamSync.enabled() {
+		state.startPeriodicJob("github_team_sync", state.Config.GitHubTeamSync.interval(),
+			state.runGitHubTeamSyncs)
+	}
+	if state.Config.Okta.enabled() {
+		state.startPeriodicJob("okta_group_push", state.Config.Okta.interval(), state.runOktaGroupPushes)
+	}
+	if state.Config.AWSIdentityCenter.enabled() {
+		state.startPeriodicJob("aws_identity_center_sync", state.Config.AWSIdentityCenter.interval(),
+			state.runAWSIdentityCenterSync)
+	}
+	if len(state.eventPublishers) > 0 {
+		state.startPeriodicJob("event_publishing", state.Config.EventPublishing.interval(),
+			state.runEventPublishing)
+	}
+	if state.directoryMirror != nil {
+		state.startPeriodicJob("directory_sync", state.Config.DirectorySync.Interval, state.directoryMirror.sync)
+		state.startFollowerJob("directory_sync_status", state.Config.DirectorySync.Interval,
+			state.directoryMirror.loadSyncedAt)
 	}
-	defer state.sysLog.Close()
 
 	http.Handle(metricsPath, promhttp.Handler())
+	http.HandleFunc(healthzPath, state.healthzHandler)
+	http.HandleFunc(readyzPath, state.readyzHandler)
+	http.Handle(versionPath, http.HandlerFunc(state.versionHandler))
 
 	http.HandleFunc(authn.Oauth2redirectPath, state.authenticator.Oauth2RedirectPathHandler)
 
@@ -341,33 +913,94 @@ func main() {
 	http.Handle(getUsersJSPath, http.HandlerFunc(state.getUsersJSHandler))
 
 	http.Handle(myManagedGroupsWebPagePath, http.HandlerFunc(state.myManagedGroupsHandler))
-
-	fs := http.FileServer(http.Dir(state.Config.Base.TemplatesPath))
-	http.Handle(cssPath, fs)
-	http.Handle(imagesPath, fs)
-	http.Handle(jsPath, fs)
-
-	var clientCACertPool *x509.CertPool
-	if len(state.Config.Base.ClientCAFilename) > 0 {
-		clientCACertPool = x509.NewCertPool()
-		caCert, err := ioutil.ReadFile(state.Config.Base.ClientCAFilename)
+	http.Handle(auditLogPath, http.HandlerFunc(state.auditLogHandler))
+	http.Handle(accessReportPath, http.HandlerFunc(state.accessReportHandler))
+	http.Handle(groupHistoryPath, http.HandlerFunc(state.groupHistoryHandler))
+	http.Handle(auditEvidencePath, http.HandlerFunc(state.auditEvidenceHandler))
+	http.Handle(serviceAccountsPath, http.HandlerFunc(state.serviceAccountsHandler))
+	http.Handle(reviewServiceAccountPath, http.HandlerFunc(state.reviewServiceAccountHandler))
+	http.Handle(changeSAOwnerWebPagePath, http.HandlerFunc(state.changeServiceAccountOwnerWebpageHandler))
+	http.Handle(changeSAOwnerPath, http.HandlerFunc(state.changeServiceAccountOwnerHandler))
+	http.Handle(serviceAccountTakeoverPath, http.HandlerFunc(state.serviceAccountTakeoverHandler))
+	http.Handle(credentialRotationsPath, http.HandlerFunc(state.credentialRotationsHandler))
+	http.Handle(groupClassificationPath, http.HandlerFunc(state.groupClassificationHandler))
+	http.Handle(serviceAccountLifecyclePath, http.HandlerFunc(state.serviceAccountLifecycleHandler))
+	http.Handle(serviceAccountApprovalPath, http.HandlerFunc(state.serviceAccountLifecycleApprovalHandler))
+	http.Handle(serviceAccountInfoPath, http.HandlerFunc(state.serviceAccountInfoHandler))
+	http.Handle(importServiceAccountsPath, http.HandlerFunc(state.importServiceAccountsHandler))
+	http.Handle(importGroupsPath, http.HandlerFunc(state.importGroupsHandler))
+	http.Handle(exportGroupsPath, http.HandlerFunc(state.exportGroupsHandler))
+	http.Handle(serviceAccountRequestPath, http.HandlerFunc(state.serviceAccountRequestHandler))
+	http.Handle(serviceAccountsAPIPath, http.HandlerFunc(state.serviceAccountsAPIHandler))
+	http.Handle(groupMetadataPath, http.HandlerFunc(state.groupMetadataHandler))
+	http.Handle(groupTagsPath, http.HandlerFunc(state.groupTagsHandler))
+	http.Handle(groupTemplatesPath, http.HandlerFunc(state.groupTemplatesHandler))
+	http.Handle(groupMailPath, http.HandlerFunc(state.groupMailHandler))
+	http.Handle(groupArchivePath, http.HandlerFunc(state.groupArchiveHandler))
+	http.Handle(renameGroupPath, http.HandlerFunc(state.renameGroupHandler))
+	http.Handle(mergeGroupPath, http.HandlerFunc(state.mergeGroupHandler))
+	http.Handle(cloneGroupPath, http.HandlerFunc(state.cloneGroupHandler))
+	http.Handle(allGroupsTablePath, http.HandlerFunc(state.allGroupsTableHandler))
+	http.Handle(groupMembersTablePath, http.HandlerFunc(state.groupMembersTableHandler))
+	http.Handle(myGroupsTablePath, http.HandlerFunc(state.myGroupsTableHandler))
+	http.Handle(managedGroupsTablePath, http.HandlerFunc(state.managedGroupsTableHandler))
+	http.Handle(accessRequestsAPIPath, http.HandlerFunc(state.accessRequestsAPIHandler))
+	http.Handle(userSearchAPIPath, http.HandlerFunc(state.userSearchHandler))
+	http.Handle(membershipUndoPath, http.HandlerFunc(state.membershipUndoHandler))
+	http.Handle(userPreferencesPath, http.HandlerFunc(state.userPreferencesHandler))
+	http.Handle(myRequestsPath, http.HandlerFunc(state.myRequestsHandler))
+	http.Handle(profilePath, http.HandlerFunc(state.profileHandler))
+	http.Handle(cancelRequestPath, http.HandlerFunc(state.cancelRequestHandler))
+	http.Handle(githubTeamsPath, http.HandlerFunc(state.githubTeamsHandler))
+	http.Handle(oktaGroupsPath, http.HandlerFunc(state.oktaGroupsHandler))
+	http.Handle(heldChangesPath, http.HandlerFunc(state.heldChangesHandler))
+	http.Handle(groupsAPIPath, http.HandlerFunc(state.groupsAPIHandler))
+	if state.devMode {
+		state.registerDevModeOIDC(http.DefaultServeMux)
+	}
+	if state.Config.SCIM.Enabled {
+		state.scimTokens, err = loadSCIMTokens(state.Config.SCIM.TokensFilename)
 		if err != nil {
-			log.Fatalf("cannot read clientCA file err=%s", err)
+			log.Fatalf("Cannot load the SCIM tokens err: %s", err)
+		}
+		http.Handle(scimPath, http.HandlerFunc(state.scimHandler))
+	}
+	if state.Config.HRWebhook.enabled() {
+		state.hrWebhookSecret, err = loadWebhookSecret(state.Config.HRWebhook.SecretFilename)
+		if err != nil {
+			log.Fatalf("Cannot load the HR webhook secret err: %s", err)
+		}
+		http.Handle(hrWebhookPath, http.HandlerFunc(state.hrWebhookHandler))
+	}
+	if state.Config.WebhookSigning.enabled() {
+		state.webhookSigningSecret, err = loadWebhookSecret(state.Config.WebhookSigning.SecretFilename)
+		if err != nil {
+			log.Fatalf("Cannot load the webhook signing secret err: %s", err)
 		}
-		clientCACertPool.AppendCertsFromPEM(caCert)
 	}
 
-	tlsConfig := &tls.Config{
-		MinVersion:               tls.VersionTLS12,
-		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-		PreferServerCipherSuites: true,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		},
-		ClientAuth: tls.VerifyClientCertIfGiven,
-		ClientCAs:  clientCACertPool,
+	var staticHandler http.Handler = state.staticAssets
+	if state.Config.Base.TemplatesDevMode {
+		staticHandler = http.FileServer(state.staticFileSystem())
+	}
+	http.Handle(cssPath, staticHandler)
+	http.Handle(imagesPath, staticHandler)
+	http.Handle(jsPath, staticHandler)
+
+	clientCACertPool, err := loadClientCAs(state.Config.Base.ClientCAFilename)
+	if err != nil {
+		log.Fatalf("cannot read clientCA file err=%s", err)
+	}
+	var acmeManager *autocert.Manager
+	if state.Config.TLS.ACME.enabled() {
+		acmeManager = newACMEManager(state.Config.TLS.ACME)
+		if state.Config.TLS.ACME.HTTPAddress != "" {
+			serveACMEHTTP(state.Config.TLS.ACME.HTTPAddress, acmeManager)
+		}
+	}
+	tlsConfig, err := newTLSConfig(state.Config.TLS, clientCACertPool, acmeManager)
+	if err != nil {
+		log.Fatalf("Invalid TLS config err: %s", err)
 	}
 
 	l := &lumberjack.Logger{
@@ -377,17 +1010,46 @@ func main() {
 		MaxAge:     28,   //days
 		Compress:   true, // disabled by default
 	}
-	accessLogger := httpLogger{AccessLogger: log.New(l, "", 0)}
+	state.accessLogger, err = newAccessLogger(state.Config.AccessLog, l)
+	if err != nil {
+		log.Fatalf("Invalid access log config err: %s", err)
+	}
+	rateLimiter := newRateLimiter(state.Config.RateLimits, state.authenticator)
+	var adminNetwork *adminNetworkFilter
+	if state.Config.AdminNetwork.enabled() {
+		adminNetwork, err = newAdminNetworkFilter(state.Config.AdminNetwork)
+		if err != nil {
+			log.Fatalf("Invalid admin network config err: %s", err)
+		}
+	}
+	if state.Config.ErrorReporting.DSN != "" {
+		state.errorReporter, err = newErrorReporter(state.Config.ErrorReporting, state.Config.Base.Hostname)
+		if err != nil {
+			log.Fatalf("Invalid error reporting config err: %s", err)
+		}
+	}
+	handler := state.requestLoggingHandler(state.securityHeadersHandler(state.recoveryHandler(
+		state.errorReportingHandler(rateLimiter.Handler(state.adminNetworkHandler(adminNetwork,
+			state.authFailures.Handler(state.degradedModeHandler(state.debugHandler(http.DefaultServeMux)))))))),
+		http.DefaultServeMux)
+	handler = state.tracingHandler(handler, http.DefaultServeMux)
 	serviceServer := &http.Server{
 		Addr:         state.Config.Base.HttpAddress,
-		Handler:      instrumentedwriter.NewLoggingHandler(http.DefaultServeMux, accessLogger),
+		Handler:      handler,
 		TLSConfig:    tlsConfig,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
 
-	err = serviceServer.ListenAndServeTLS(state.Config.Base.TLSCertFilename, state.Config.Base.TLSKeyFilename)
+	listener, err := listen(state.Config.Base.HttpAddress, state.Config.Shutdown.ReusePort)
+	if err != nil {
+		log.Fatalf("Cannot listen on %s err: %s", state.Config.Base.HttpAddress, err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	err = state.serve(serviceServer, listener, state.Config.Base.TLSCertFilename, state.Config.Base.TLSKeyFilename,
+		signals)
 	if err != nil {
 		log.Fatalf("Failed to start service server, err=%s", err)
 	}
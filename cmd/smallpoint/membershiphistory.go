@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Group membership history is event sourced: every snapshot of LDAP is
+// compared with the membership rebuilt from the stored changes and only the
+// differences are stored. Changes done outside smallpoint are picked up on
+// the next snapshot.
+
+const (
+	membershipChangeAdded   = "added"
+	membershipChangeRemoved = "removed"
+)
+
+type membershipHistoryConfig struct {
+	// How often LDAP group membership is snapshotted, 0 disables history.
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+}
+
+type membershipChange struct {
+	Timestamp time.Time
+	Groupname string
+	Username  string
+	Change    string
+}
+
+var insertMembershipChangeStmt = map[string]string{
+	"sqlite":   "insert into group_membership_history(time_stamp, groupname, username, change) values (?,?,?,?);",
+	"postgres": "insert into group_membership_history(time_stamp, groupname, username, change) values ($1,$2,$3,$4);",
+}
+
+var getMembershipChangesOfGroupStmt = map[string]string{
+	"sqlite":   "select time_stamp, groupname, username, change from group_membership_history where groupname=? order by id;",
+	"postgres": "select time_stamp, groupname, username, change from group_membership_history where groupname=$1 order by id;",
+}
+
+var getAllMembershipChangesStmt = "select time_stamp, groupname, username, change from group_membership_history order by id;"
+
+// getMembershipChangesFromDB returns the changes in the order they were
+// recorded, an empty groupname returns the changes of all groups.
+func getMembershipChangesFromDB(groupname string, state *RuntimeState) ([]membershipChange, error) {
+	start := time.Now()
+	var stmtText string
+	var args []interface{}
+	if groupname == "" {
+		stmtText = getAllMembershipChangesStmt
+	} else {
+		stmtText = getMembershipChangesOfGroupStmt[state.dbType]
+		args = append(args, groupname)
+	}
+	rows, err := state.db.Query(stmtText, args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var changes []membershipChange
+	for rows.Next() {
+		var change membershipChange
+		var timeStamp int64
+		err = rows.Scan(&timeStamp, &change.Groupname, &change.Username, &change.Change)
+		if err != nil {
+			return nil, err
+		}
+		change.Timestamp = time.Unix(timeStamp, 0)
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// foldMembershipChanges rebuilds the membership of every group as it was
+// just before until. A zero until applies all the changes.
+func foldMembershipChanges(changes []membershipChange, until time.Time) map[string]map[string]bool {
+	groups := make(map[string]map[string]bool)
+	for _, change := range changes {
+		if !until.IsZero() && !change.Timestamp.Before(until) {
+			break
+		}
+		members, ok := groups[change.Groupname]
+		if !ok {
+			members = make(map[string]bool)
+			groups[change.Groupname] = members
+		}
+		switch change.Change {
+		case membershipChangeAdded:
+			members[change.Username] = true
+		case membershipChangeRemoved:
+			delete(members, change.Username)
+		}
+	}
+	return groups
+}
+
+// diffMembership returns the sorted users only present in after and only
+// present in before.
+func diffMembership(before, after map[string]bool) (added []string, removed []string) {
+	for user := range after {
+		if !before[user] {
+			added = append(added, user)
+		}
+	}
+	for user := range before {
+		if !after[user] {
+			removed = append(removed, user)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// recordMembershipSnapshot compares LDAP with the recorded history and
+// stores the differences.
+func (state *RuntimeState) recordMembershipSnapshot() error {
+	changes, err := getMembershipChangesFromDB("", state)
+	if err != nil {
+		return err
+	}
+	recorded := foldMembershipChanges(changes, time.Time{})
+	allGroups, err := state.Userinfo.GetallGroups()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var newChanges []membershipChange
+	seenGroups := make(map[string]bool)
+	for _, groupname := range allGroups {
+		seenGroups[groupname] = true
+		users, _, err := state.Userinfo.GetusersofaGroup(groupname)
+		if err != nil {
+			if err == userinfo.GroupDoesNotExist {
+				// deleted while we were running
+				seenGroups[groupname] = false
+				continue
+			}
+			return err
+		}
+		current := make(map[string]bool)
+		for _, user := range users {
+			current[user] = true
+		}
+		added, removed := diffMembership(recorded[groupname], current)
+		for _, user := range added {
+			newChanges = append(newChanges, membershipChange{now, groupname, user, membershipChangeAdded})
+		}
+		for _, user := range removed {
+			newChanges = append(newChanges, membershipChange{now, groupname, user, membershipChangeRemoved})
+		}
+	}
+	// groups deleted since the last snapshot
+	for groupname, members := range recorded {
+		if seenGroups[groupname] {
+			continue
+		}
+		_, removed := diffMembership(members, nil)
+		for _, user := range removed {
+			newChanges = append(newChanges, membershipChange{now, groupname, user, membershipChangeRemoved})
+		}
+	}
+	if len(newChanges) == 0 {
+		return nil
+	}
+	return insertMembershipChangesInDB(newChanges, state)
+}
+
+func insertMembershipChangesInDB(changes []membershipChange, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(insertMembershipChangeStmt[state.dbType])
+	if err != nil {
+		slog.Error("Error preparing statement")
+		return err
+	}
+	defer stmt.Close()
+	for _, change := range changes {
+		_, err = stmt.Exec(change.Timestamp.Unix(), change.Groupname, change.Username, change.Change)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func (state *RuntimeState) groupHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	q := r.URL.Query()
+	groupname := strings.TrimSpace(q.Get("groupname"))
+	if groupname == "" {
+		state.writeFailureResponse(w, r, "groupname is required", http.StatusBadRequest)
+		return
+	}
+	// dates are inclusive, the diff compares the membership at the end of
+	// each day.
+	var from, to time.Time
+	if fromText := q.Get("from"); fromText != "" {
+		from, err = time.ParseInLocation(auditDateLayout, fromText, time.Local)
+		if err != nil {
+			state.writeFailureResponse(w, r, fmt.Sprintf("invalid from date '%s'", fromText), http.StatusBadRequest)
+			return
+		}
+		from = from.AddDate(0, 0, 1)
+	}
+	if toText := q.Get("to"); toText != "" {
+		to, err = time.ParseInLocation(auditDateLayout, toText, time.Local)
+		if err != nil {
+			state.writeFailureResponse(w, r, fmt.Sprintf("invalid to date '%s'", toText), http.StatusBadRequest)
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	changes, err := getMembershipChangesFromDB(groupname, state)
+	if err != nil {
+		requestLogger(r).Error("groupHistoryHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	pageData := groupHistoryPageData{
+		UserName:  username,
+		IsAdmin:   state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:     "Membership history of " + groupname,
+		GroupName: groupname,
+		From:      q.Get("from"),
+		To:        q.Get("to"),
+	}
+	if !from.IsZero() && !to.IsZero() {
+		pageData.HasDiff = true
+		pageData.DiffAdded, pageData.DiffRemoved = diffMembership(
+			foldMembershipChanges(changes, from)[groupname],
+			foldMembershipChanges(changes, to)[groupname])
+	}
+	// newest first
+	for i := len(changes) - 1; i >= 0; i-- {
+		pageData.Changes = append(pageData.Changes, changes[i])
+	}
+	state.renderTemplateOrReturnJson(w, r, "groupHistoryPage", pageData)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/mock"
+)
+
+func TestRecordMembershipSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "membership_history_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "history.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Userinfo = mock.New()
+	err = state.recordMembershipSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeChange := time.Now().Add(time.Second)
+	err = state.Userinfo.AddmemberstoExisting(userinfo.GroupInfo{Groupname: "group1", MemberUid: []string{"user3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.DeletemembersfromGroup(userinfo.GroupInfo{Groupname: "group1", MemberUid: []string{"user2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.DeleteGroup([]string{"group2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.recordMembershipSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := getMembershipChangesFromDB("", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := foldMembershipChanges(changes, time.Time{})
+	if !reflect.DeepEqual(current["group1"], map[string]bool{"user1": true, "user3": true}) {
+		t.Fatalf("bad group1 membership %v", current["group1"])
+	}
+	if len(current["group2"]) != 0 {
+		t.Fatalf("deleted group still has members %v", current["group2"])
+	}
+	// move the second snapshot into the future to diff both states
+	for i := range changes {
+		if i >= 4 {
+			changes[i].Timestamp = beforeChange.Add(time.Hour)
+		}
+	}
+	added, removed := diffMembership(foldMembershipChanges(changes, beforeChange)["group1"],
+		foldMembershipChanges(changes, time.Time{})["group1"])
+	if !reflect.DeepEqual(added, []string{"user3"}) || !reflect.DeepEqual(removed, []string{"user2"}) {
+		t.Fatalf("bad diff added=%v removed=%v", added, removed)
+	}
+	// a snapshot without changes records nothing
+	err = state.recordMembershipSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newChanges, err := getMembershipChangesFromDB("", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newChanges) != len(changes) {
+		t.Fatalf("unchanged snapshot added entries %d != %d", len(newChanges), len(changes))
+	}
+}
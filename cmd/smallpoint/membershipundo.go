@@ -0,0 +1,317 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The members removed from a group are recorded, the admins of the group can
+// add them back during the undo window to recover from a wrong bulk removal.
+// The removals stay in the DB once the window is over or they are undone.
+
+const defaultMembershipUndoWindow = time.Hour * 24
+
+type membershipUndoConfig struct {
+	Window time.Duration `yaml:"window"`
+}
+
+func (config membershipUndoConfig) window() time.Duration {
+	if config.Window > 0 {
+		return config.Window
+	}
+	return defaultMembershipUndoWindow
+}
+
+type membershipRemoval struct {
+	ID         int64
+	Groupname  string
+	Username   string
+	RemovedBy  string
+	RemovedAt  time.Time
+	UndoUntil  time.Time
+	RestoredBy string
+	RestoredAt time.Time
+}
+
+func (removal membershipRemoval) undoable(now time.Time) bool {
+	return removal.RestoredAt.IsZero() && now.Before(removal.UndoUntil)
+}
+
+const membershipRemovalColumns = "id, groupname, username, removed_by, removed_at, undo_until, restored_by, restored_at"
+
+var insertMembershipRemovalStmt = map[string]string{
+	"sqlite":   "insert into membership_removals(groupname, username, removed_by, removed_at, undo_until, restored_by, restored_at) values (?,?,?,?,?,'',0);",
+	"postgres": "insert into membership_removals(groupname, username, removed_by, removed_at, undo_until, restored_by, restored_at) values ($1,$2,$3,$4,$5,'',0);",
+}
+
+var getMembershipRemovalStmt = map[string]string{
+	"sqlite":   "select " + membershipRemovalColumns + " from membership_removals where id=?;",
+	"postgres": "select " + membershipRemovalColumns + " from membership_removals where id=$1;",
+}
+
+var getUndoableMembershipRemovalsStmt = map[string]string{
+	"sqlite":   "select " + membershipRemovalColumns + " from membership_removals where restored_at=0 and undo_until>? order by id desc;",
+	"postgres": "select " + membershipRemovalColumns + " from membership_removals where restored_at=0 and undo_until>$1 order by id desc;",
+}
+
+// the removal is only restored once
+var restoreMembershipRemovalStmt = map[string]string{
+	"sqlite":   "update membership_removals set restored_by=?, restored_at=? where id=? and restored_at=0;",
+	"postgres": "update membership_removals set restored_by=$1, restored_at=$2 where id=$3 and restored_at=0;",
+}
+
+func scanMembershipRemoval(row sqlRowScanner) (membershipRemoval, error) {
+	var removal membershipRemoval
+	var removedAt, undoUntil, restoredAt int64
+	err := row.Scan(&removal.ID, &removal.Groupname, &removal.Username, &removal.RemovedBy, &removedAt,
+		&undoUntil, &removal.RestoredBy, &restoredAt)
+	removal.RemovedAt = time.Unix(removedAt, 0)
+	removal.UndoUntil = time.Unix(undoUntil, 0)
+	if restoredAt != 0 {
+		removal.RestoredAt = time.Unix(restoredAt, 0)
+	}
+	return removal, err
+}
+
+func insertMembershipRemovalsInDB(groupname string, usernames []string, removedBy string, removedAt time.Time,
+	undoUntil time.Time, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, username := range usernames {
+		_, err = tx.Exec(insertMembershipRemovalStmt[state.dbType], groupname, username, removedBy,
+			removedAt.Unix(), undoUntil.Unix())
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// getMembershipRemovalFromDB returns nil when there is no such removal.
+func getMembershipRemovalFromDB(id int64, state *RuntimeState) (*membershipRemoval, error) {
+	start := time.Now()
+	removal, err := scanMembershipRemoval(state.db.QueryRow(getMembershipRemovalStmt[state.dbType], id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return &removal, nil
+}
+
+// getUndoableMembershipRemovalsFromDB returns the removals that can still be
+// undone, the latest first.
+func getUndoableMembershipRemovalsFromDB(now time.Time, state *RuntimeState) ([]membershipRemoval, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getUndoableMembershipRemovalsStmt[state.dbType], now.Unix())
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var removals []membershipRemoval
+	for rows.Next() {
+		removal, err := scanMembershipRemoval(rows)
+		if err != nil {
+			return nil, err
+		}
+		removals = append(removals, removal)
+	}
+	return removals, rows.Err()
+}
+
+// recordMembershipRemovals records the members removed by actor, it returns
+// the end of the undo window.
+func (state *RuntimeState) recordMembershipRemovals(groupname string, usernames []string,
+	actor string) (time.Time, error) {
+	now := time.Now()
+	undoUntil := now.Add(state.Config.MembershipUndo.window())
+	if len(usernames) == 0 {
+		return undoUntil, nil
+	}
+	return undoUntil, insertMembershipRemovalsInDB(groupname, usernames, actor, now, undoUntil, state)
+}
+
+// undoMembershipRemoval adds the member back, the members that joined the
+// group again since are left alone. It returns true when adding the member
+// back is held for approval, the removal can then still be undone.
+func (state *RuntimeState) undoMembershipRemoval(r *http.Request, actor string, removal membershipRemoval) (bool,
+	error) {
+	isMember, _, err := state.requestUserinfo(r).IsgroupmemberorNot(removal.Groupname, removal.Username)
+	if err != nil {
+		return false, err
+	}
+	if !isMember {
+		held, err := state.holdPrivilegeEscalation(r, actor, removal.Groupname, []string{removal.Username}, nil)
+		if err != nil || held {
+			return held, err
+		}
+		err = state.requestUserinfo(r).AddmemberstoExisting(userinfo.GroupInfo{Groupname: removal.Groupname,
+			MemberUid: []string{removal.Username}})
+		if err != nil {
+			state.recordAuditEvent(r, actor, auditActionUndoRemoveMember, removal.Groupname, removal.Username,
+				auditOutcomeFailure, err.Error())
+			return false, err
+		}
+	}
+	_, err = state.db.Exec(restoreMembershipRemovalStmt[state.dbType], actor, time.Now().Unix(), removal.ID)
+	if err != nil {
+		return false, err
+	}
+	state.recordAuditEvent(r, actor, auditActionUndoRemoveMember, removal.Groupname, removal.Username,
+		auditOutcomeSuccess, fmt.Sprintf("removed by %s at %s", removal.RemovedBy,
+			removal.RemovedAt.UTC().Format(time.RFC3339)))
+	return false, nil
+}
+
+// membershipUndoHandler lists the removals that can be undone and undoes the
+// removals posted, it is used by the admins of the groups.
+func (state *RuntimeState) membershipUndoHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	switch r.Method {
+	case getMethod:
+	case postMethod:
+		err = r.ParseForm()
+		if err != nil {
+			requestLogger(r).Error("membershipUndoHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		var removals []membershipRemoval
+		for _, idText := range r.PostForm["id"] {
+			id, err := strconv.ParseInt(idText, 10, 64)
+			if err != nil {
+				state.writeFailureResponse(w, r, fmt.Sprintf("invalid id '%s'", idText), http.StatusBadRequest)
+				return
+			}
+			removal, err := getMembershipRemovalFromDB(id, state)
+			if err != nil {
+				requestLogger(r).Error("membershipUndoHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			if removal == nil || !removal.undoable(time.Now()) {
+				state.writeFailureResponse(w, r, fmt.Sprintf("removal %d cannot be undone", id),
+					http.StatusBadRequest)
+				return
+			}
+			isAdmin, err := state.isGroupAdmin(username, removal.Groupname)
+			if err != nil {
+				requestLogger(r).Error("membershipUndoHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			if !isAdmin {
+				http.Error(w, "you are not authorized", http.StatusForbidden)
+				return
+			}
+			archive, err := getGroupArchiveFromDB(removal.Groupname, state)
+			if err != nil {
+				requestLogger(r).Error("membershipUndoHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			if archive != nil {
+				state.writeFailureResponse(w, r, "group "+removal.Groupname+" is archived", http.StatusBadRequest)
+				return
+			}
+			removals = append(removals, *removal)
+		}
+		if len(removals) == 0 {
+			state.writeFailureResponse(w, r, "id is required", http.StatusBadRequest)
+			return
+		}
+		var restored, held []string
+		for _, removal := range removals {
+			isHeld, err := state.undoMembershipRemoval(r, username, removal)
+			if err != nil {
+				requestLogger(r).Error("membershipUndoHandler failed", "err", err)
+				state.writeFailureResponse(w, r, "cannot add back "+removal.Username+" to "+removal.Groupname,
+					http.StatusInternalServerError)
+				return
+			}
+			if isHeld {
+				held = append(held, removal.Username+" to "+removal.Groupname)
+				continue
+			}
+			restored = append(restored, removal.Username+" to "+removal.Groupname)
+		}
+		var messages []string
+		if len(restored) > 0 {
+			messages = append(messages, "Added back "+strings.Join(restored, ", "))
+		}
+		if len(held) > 0 {
+			messages = append(messages, "Adding back "+strings.Join(held, ", ")+
+				" may escalate privileges, it is held until another admin approves it")
+		}
+		pageData := simpleMessagePageData{
+			UserName:       username,
+			IsAdmin:        state.requestUserinfo(r).UserisadminOrNot(username),
+			Title:          "Removals Undone",
+			SuccessMessage: strings.Join(messages, ". "),
+			ContinueURL:    groupinfoPath + "?groupname=" + removals[0].Groupname,
+		}
+		state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+		return
+	default:
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	groupname := strings.TrimSpace(r.URL.Query().Get("groupname"))
+	removals, err := getUndoableMembershipRemovalsFromDB(time.Now(), state)
+	if err != nil {
+		requestLogger(r).Error("membershipUndoHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	pageData := membershipUndoPageData{
+		UserName:  username,
+		IsAdmin:   state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:     "Undo Member Removals",
+		GroupName: groupname,
+		Removals:  []membershipRemoval{},
+	}
+	isAdminOf := make(map[string]bool)
+	for _, removal := range removals {
+		if groupname != "" && removal.Groupname != groupname {
+			continue
+		}
+		isAdmin, ok := isAdminOf[removal.Groupname]
+		if !ok {
+			isAdmin, err = state.isGroupAdmin(username, removal.Groupname)
+			if err != nil {
+				requestLogger(r).Error("membershipUndoHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			isAdminOf[removal.Groupname] = isAdmin
+		}
+		if isAdmin {
+			pageData.Removals = append(pageData.Removals, removal)
+		}
+	}
+	state.renderTemplateOrReturnJson(w, r, "membershipUndoPage", pageData)
+}
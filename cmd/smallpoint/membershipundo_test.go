@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func testGetUndoableRemovals(t *testing.T, state *RuntimeState, groupname string) []membershipRemoval {
+	req, err := http.NewRequest("GET", membershipUndoPath+"?groupname="+groupname, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidAdminCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.membershipUndoHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("cannot list the removals, got %d", rr.Code)
+	}
+	var pageData membershipUndoPageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pageData.Removals
+}
+
+func TestMembershipUndo(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: "undo-group", Description: "group1",
+		MemberUid: []string{"user2", "user3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := testPostServiceAccountForm(t, &state, deletemembersbuttonPath, state.deletemembersfromExistingGroup,
+		true, url.Values{"groupname": {"undo-group"}, "members": {"user2,user3"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot remove the members, got %d", code)
+	}
+	removals := testGetUndoableRemovals(t, &state, "undo-group")
+	if len(removals) != 2 || removals[0].RemovedBy != "user1" {
+		t.Fatalf("unexpected removals %+v", removals)
+	}
+	var removalID string
+	for _, removal := range removals {
+		if removal.Username == "user3" {
+			removalID = strconv.FormatInt(removal.ID, 10)
+		}
+	}
+
+	code = testPostServiceAccountForm(t, &state, membershipUndoPath, state.membershipUndoHandler, true,
+		url.Values{"id": {"0"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("undoing an unknown removal should fail, got %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, membershipUndoPath, state.membershipUndoHandler, true,
+		url.Values{"id": {removalID}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot undo the removal, got %d", code)
+	}
+	isMember, _, err := state.Userinfo.IsgroupmemberorNot("undo-group", "user3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isMember {
+		t.Fatal("the member was not added back")
+	}
+	code = testPostServiceAccountForm(t, &state, membershipUndoPath, state.membershipUndoHandler, true,
+		url.Values{"id": {removalID}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("a removal is only undone once, got %d", code)
+	}
+	removals = testGetUndoableRemovals(t, &state, "undo-group")
+	if len(removals) != 1 || removals[0].Username != "user2" {
+		t.Fatalf("unexpected removals %+v", removals)
+	}
+
+	// past the undo window
+	err = insertMembershipRemovalsInDB("undo-group", []string{"user1"}, "user1", time.Now().Add(-time.Hour),
+		time.Now().Add(-time.Minute), &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removals = testGetUndoableRemovals(t, &state, "undo-group")
+	if len(removals) != 1 {
+		t.Fatalf("an expired removal is listed %+v", removals)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// The schema is versioned by the sequential migrations below, built into the
+// binary. Each migration has its statements for both database types and is
+// applied in its own transaction; the schema_migrations table records the
+// applied versions with the checksum of their statements, a migration
+// changed after it was applied is refused. Migrations are only ever
+// appended: the changes to an existing table go in a new migration.
+//
+// The migrations are applied at startup unless skip_startup_migrations is
+// set, the migrate command then applies them and the server refuses to start
+// on a schema that is not current.
+
+type schemaMigration struct {
+	Version     int
+	Description string
+	Statements  map[string][]string
+}
+
+var schemaMigrations = []schemaMigration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		// the tables may predate the migrations
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table if not exists pending_requests (id INTEGER PRIMARY KEY AUTOINCREMENT, username text not null, groupname text not null, time_stamp int not null);`,
+				`create table if not exists audit_log (id INTEGER PRIMARY KEY AUTOINCREMENT, time_stamp int not null, actor text not null, action text not null, groupname text not null, username text not null, remote_addr text not null, outcome text not null, details text not null);`,
+				`create table if not exists audit_chain (audit_id INTEGER PRIMARY KEY, prev_hash text not null, hash text not null, signature text not null);`,
+				`create table if not exists group_membership_history (id INTEGER PRIMARY KEY AUTOINCREMENT, time_stamp int not null, groupname text not null, username text not null, change text not null);`,
+				`create table if not exists compliance_reports (period text not null, period_start int not null, generated_at int not null, PRIMARY KEY (period, period_start));`,
+				`create table if not exists audit_retention (id INTEGER PRIMARY KEY AUTOINCREMENT, pruned_through_id int not null, last_hash text not null, time_stamp int not null, archive_file text not null);`,
+				`create table if not exists service_accounts (accountname text PRIMARY KEY, owner_group text not null, mail text not null, created_by text not null, created_at int not null, review_by int not null, last_notified int not null, status text not null);`,
+				`create table if not exists service_account_takeovers (id INTEGER PRIMARY KEY AUTOINCREMENT, accountname text not null, requested_by text not null, owner_group text not null, justification text not null, time_stamp int not null);`,
+				`create table if not exists credential_rotations (id INTEGER PRIMARY KEY AUTOINCREMENT, accountname text not null, time_stamp int not null, rotated_by text not null, backend text not null, outcome text not null, reference text not null);`,
+				`create table if not exists group_classifications (groupname text PRIMARY KEY, classification text not null, updated_by text not null, time_stamp int not null);`,
+				`create table if not exists service_account_lifecycle_requests (id INTEGER PRIMARY KEY AUTOINCREMENT, accountname text not null, action text not null, requested_by text not null, justification text not null, time_stamp int not null);`,
+				`create table if not exists service_account_deletions (accountname text PRIMARY KEY, scheduled_by text not null, delete_after int not null);`,
+				`create table if not exists service_account_metadata (accountname text PRIMARY KEY, purpose text not null, team text not null, cost_center text not null, ticket text not null, updated_by text not null, time_stamp int not null);`,
+				`create table if not exists service_account_requests (id INTEGER PRIMARY KEY AUTOINCREMENT, accountname text not null, mail text not null, login_shell text not null, owner_group text not null, review_by int not null, justification text not null, requested_by text not null, time_stamp int not null);`,
+				`create table if not exists group_metadata (groupname text PRIMARY KEY, description text not null, contact_email text not null, purpose text not null, updated_by text not null, time_stamp int not null);`,
+				`create table if not exists group_tags (groupname text not null, tag text not null, updated_by text not null, time_stamp int not null, PRIMARY KEY (groupname, tag));`,
+				`create table if not exists group_templates (name text PRIMARY KEY, description text not null, managed_by text not null, members text not null, tags text not null, gid_min int not null, gid_max int not null, updated_by text not null, time_stamp int not null);`,
+				`create table if not exists gid_reservations (gid_number int PRIMARY KEY, groupname text not null, reserved_by text not null, expires_at int not null);`,
+				`create table if not exists mailing_list_addresses (address text PRIMARY KEY, groupname text not null, is_primary int not null, updated_by text not null, time_stamp int not null);`,
+				`create table if not exists group_archives (groupname text PRIMARY KEY, members text not null, archived_by text not null, archived_at int not null, delete_after int not null);`,
+				`create table if not exists group_renames (id INTEGER PRIMARY KEY AUTOINCREMENT, old_name text not null, new_name text not null, renamed_by text not null, time_stamp int not null);`,
+				`create table if not exists directory_groups (groupname text PRIMARY KEY, managed_by text not null);`,
+				`create table if not exists directory_members (groupname text not null, username text not null, PRIMARY KEY (groupname, username));`,
+				`create table if not exists directory_users (username text PRIMARY KEY, email text not null, given_name text not null);`,
+				`create table if not exists directory_syncs (completed_at int not null, group_count int not null, user_count int not null);`,
+			},
+			"postgres": {
+				`create table if not exists pending_requests (id SERIAL PRIMARY KEY, username text not null, groupname text not null, time_stamp int not null);`,
+				`create table if not exists audit_log (id SERIAL PRIMARY KEY, time_stamp bigint not null, actor text not null, action text not null, groupname text not null, username text not null, remote_addr text not null, outcome text not null, details text not null);`,
+				`create table if not exists audit_chain (audit_id bigint PRIMARY KEY, prev_hash text not null, hash text not null, signature text not null);`,
+				`create table if not exists group_membership_history (id SERIAL PRIMARY KEY, time_stamp bigint not null, groupname text not null, username text not null, change text not null);`,
+				`create table if not exists compliance_reports (period text not null, period_start bigint not null, generated_at bigint not null, PRIMARY KEY (period, period_start));`,
+				`create table if not exists audit_retention (id SERIAL PRIMARY KEY, pruned_through_id bigint not null, last_hash text not null, time_stamp bigint not null, archive_file text not null);`,
+				`create table if not exists service_accounts (accountname text PRIMARY KEY, owner_group text not null, mail text not null, created_by text not null, created_at bigint not null, review_by bigint not null, last_notified bigint not null, status text not null);`,
+				`create table if not exists service_account_takeovers (id SERIAL PRIMARY KEY, accountname text not null, requested_by text not null, owner_group text not null, justification text not null, time_stamp bigint not null);`,
+				`create table if not exists credential_rotations (id SERIAL PRIMARY KEY, accountname text not null, time_stamp bigint not null, rotated_by text not null, backend text not null, outcome text not null, reference text not null);`,
+				`create table if not exists group_classifications (groupname text PRIMARY KEY, classification text not null, updated_by text not null, time_stamp bigint not null);`,
+				`create table if not exists service_account_lifecycle_requests (id SERIAL PRIMARY KEY, accountname text not null, action text not null, requested_by text not null, justification text not null, time_stamp bigint not null);`,
+				`create table if not exists service_account_deletions (accountname text PRIMARY KEY, scheduled_by text not null, delete_after bigint not null);`,
+				`create table if not exists service_account_metadata (accountname text PRIMARY KEY, purpose text not null, team text not null, cost_center text not null, ticket text not null, updated_by text not null, time_stamp bigint not null);`,
+				`create table if not exists service_account_requests (id SERIAL PRIMARY KEY, accountname text not null, mail text not null, login_shell text not null, owner_group text not null, review_by bigint not null, justification text not null, requested_by text not null, time_stamp bigint not null);`,
+				`create table if not exists group_metadata (groupname text PRIMARY KEY, description text not null, contact_email text not null, purpose text not null, updated_by text not null, time_stamp bigint not null);`,
+				`create table if not exists group_tags (groupname text not null, tag text not null, updated_by text not null, time_stamp bigint not null, PRIMARY KEY (groupname, tag));`,
+				`create table if not exists group_templates (name text PRIMARY KEY, description text not null, managed_by text not null, members text not null, tags text not null, gid_min bigint not null, gid_max bigint not null, updated_by text not null, time_stamp bigint not null);`,
+				`create table if not exists gid_reservations (gid_number bigint PRIMARY KEY, groupname text not null, reserved_by text not null, expires_at bigint not null);`,
+				`create table if not exists mailing_list_addresses (address text PRIMARY KEY, groupname text not null, is_primary int not null, updated_by text not null, time_stamp bigint not null);`,
+				`create table if not exists group_archives (groupname text PRIMARY KEY, members text not null, archived_by text not null, archived_at bigint not null, delete_after bigint not null);`,
+				`create table if not exists group_renames (id SERIAL PRIMARY KEY, old_name text not null, new_name text not null, renamed_by text not null, time_stamp bigint not null);`,
+				`create table if not exists directory_groups (groupname text PRIMARY KEY, managed_by text not null);`,
+				`create table if not exists directory_members (groupname text not null, username text not null, PRIMARY KEY (groupname, username));`,
+				`create table if not exists directory_users (username text PRIMARY KEY, email text not null, given_name text not null);`,
+				`create table if not exists directory_syncs (completed_at bigint not null, group_count bigint not null, user_count bigint not null);`,
+			},
+		},
+	},
+	{
+		Version:     2,
+		Description: "job leases",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table job_leases (name text PRIMARY KEY, holder text not null, expires_at int not null);`,
+			},
+			"postgres": {
+				`create table job_leases (name text PRIMARY KEY, holder text not null, expires_at bigint not null);`,
+			},
+		},
+	},
+	{
+		Version:     3,
+		Description: "access request lifecycle",
+		// the requests pending at the upgrade were made by their users, their
+		// justification is only in the audit log
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table access_requests (id INTEGER PRIMARY KEY AUTOINCREMENT, username text not null, groupname text not null, requested_by text not null, justification text not null, state text not null, created_at int not null, decided_by text not null, decided_at int not null, decision_comment text not null);`,
+				`create index access_requests_username on access_requests(username);`,
+				`create index access_requests_groupname on access_requests(groupname, state);`,
+				`insert into access_requests(username, groupname, requested_by, justification, state, created_at, decided_by, decided_at, decision_comment) select username, groupname, username, '', 'pending', time_stamp, '', 0, '' from pending_requests order by id;`,
+			},
+			"postgres": {
+				`create table access_requests (id SERIAL PRIMARY KEY, username text not null, groupname text not null, requested_by text not null, justification text not null, state text not null, created_at bigint not null, decided_by text not null, decided_at bigint not null, decision_comment text not null);`,
+				`create index access_requests_username on access_requests(username);`,
+				`create index access_requests_groupname on access_requests(groupname, state);`,
+				`insert into access_requests(username, groupname, requested_by, justification, state, created_at, decided_by, decided_at, decision_comment) select username, groupname, username, '', 'pending', time_stamp, '', 0, '' from pending_requests order by id;`,
+			},
+		},
+	},
+	{
+		Version:     4,
+		Description: "membership removals",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table membership_removals (id INTEGER PRIMARY KEY AUTOINCREMENT, groupname text not null, username text not null, removed_by text not null, removed_at int not null, undo_until int not null, restored_by text not null, restored_at int not null);`,
+				`create index membership_removals_undo_until on membership_removals(undo_until);`,
+			},
+			"postgres": {
+				`create table membership_removals (id SERIAL PRIMARY KEY, groupname text not null, username text not null, removed_by text not null, removed_at bigint not null, undo_until bigint not null, restored_by text not null, restored_at bigint not null);`,
+				`create index membership_removals_undo_until on membership_removals(undo_until);`,
+			},
+		},
+	},
+	{
+		Version:     5,
+		Description: "user preferences",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table user_preferences (username text not null primary key, dark_mode int not null, updated_at int not null);`,
+			},
+			"postgres": {
+				`create table user_preferences (username text not null primary key, dark_mode int not null, updated_at bigint not null);`,
+			},
+		},
+	},
+	{
+		Version:     6,
+		Description: "SCIM push queue",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table scim_push_queue (id INTEGER PRIMARY KEY AUTOINCREMENT, target text not null, groupname text not null, username text not null, change text not null, attempts int not null, next_attempt int not null, last_error text not null, created_at int not null);`,
+			},
+			"postgres": {
+				`create table scim_push_queue (id SERIAL PRIMARY KEY, target text not null, groupname text not null, username text not null, change text not null, attempts int not null, next_attempt bigint not null, last_error text not null, created_at bigint not null);`,
+			},
+		},
+	},
+	{
+		Version:     7,
+		Description: "GitHub team mappings",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table github_team_mappings (groupname text not null, organization text not null, team text not null, created_by text not null, created_at int not null, last_sync int not null, last_sync_error text not null, PRIMARY KEY (groupname, organization, team));`,
+			},
+			"postgres": {
+				`create table github_team_mappings (groupname text not null, organization text not null, team text not null, created_by text not null, created_at bigint not null, last_sync bigint not null, last_sync_error text not null, PRIMARY KEY (groupname, organization, team));`,
+			},
+		},
+	},
+	{
+		Version:     8,
+		Description: "Okta group pushes",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table okta_group_pushes (groupname text PRIMARY KEY, okta_group_id text not null, enabled int not null, updated_by text not null, updated_at int not null, last_push int not null, last_push_error text not null, failures int not null);`,
+			},
+			"postgres": {
+				`create table okta_group_pushes (groupname text PRIMARY KEY, okta_group_id text not null, enabled int not null, updated_by text not null, updated_at bigint not null, last_push bigint not null, last_push_error text not null, failures int not null);`,
+			},
+		},
+	},
+	{
+		Version:     9,
+		Description: "AWS Identity Center group members",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table aws_group_members (groupname text not null, username text not null, aws_user_id text not null, PRIMARY KEY (groupname, username));`,
+			},
+			"postgres": {
+				`create table aws_group_members (groupname text not null, username text not null, aws_user_id text not null, PRIMARY KEY (groupname, username));`,
+			},
+		},
+	},
+	{
+		Version:     10,
+		Description: "Event publishing cursors",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table event_publishing_cursors (sink text PRIMARY KEY, last_audit_id int not null);`,
+			},
+			"postgres": {
+				`create table event_publishing_cursors (sink text PRIMARY KEY, last_audit_id bigint not null);`,
+			},
+		},
+	},
+	{
+		Version:     11,
+		Description: "HR webhook events",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table hr_webhook_events (event_id text PRIMARY KEY, event_type text not null, username text not null, received_at int not null, details text not null);`,
+			},
+			"postgres": {
+				`create table hr_webhook_events (event_id text PRIMARY KEY, event_type text not null, username text not null, received_at bigint not null, details text not null);`,
+			},
+		},
+	},
+	{
+		Version:     12,
+		Description: "tickets of the access requests and the group creations",
+		Statements: map[string][]string{
+			"sqlite": {
+				`alter table access_requests add column ticket_id text not null default '';`,
+				`create table group_tickets (groupname text PRIMARY KEY, ticket_id text not null, created_at int not null);`,
+			},
+			"postgres": {
+				`alter table access_requests add column ticket_id text not null default '';`,
+				`create table group_tickets (groupname text PRIMARY KEY, ticket_id text not null, created_at bigint not null);`,
+			},
+		},
+	},
+	{
+		Version:     13,
+		Description: "provisioned shared secrets",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table provisioned_secrets (name text PRIMARY KEY, value text not null, created_at int not null);`,
+			},
+			"postgres": {
+				`create table provisioned_secrets (name text PRIMARY KEY, value text not null, created_at bigint not null);`,
+			},
+		},
+	},
+	{
+		Version:     14,
+		Description: "changes held for approval",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table held_changes (id INTEGER PRIMARY KEY AUTOINCREMENT, groupname text not null, action text not null, usernames text not null, requested_by text not null, risk text not null, time_stamp int not null);`,
+			},
+			"postgres": {
+				`create table held_changes (id SERIAL PRIMARY KEY, groupname text not null, action text not null, usernames text not null, requested_by text not null, risk text not null, time_stamp bigint not null);`,
+			},
+		},
+	},
+	{
+		Version:     15,
+		Description: "SQL directory",
+		Statements: map[string][]string{
+			"sqlite": {
+				`create table sql_directory_users (username text PRIMARY KEY, email text not null, given_name text not null);`,
+				`create table sql_directory_groups (groupname text PRIMARY KEY, managed_by text not null, gid_number int not null, mail text not null);`,
+				`create table sql_directory_members (groupname text not null, username text not null, PRIMARY KEY (groupname, username));`,
+				`create index sql_directory_members_username on sql_directory_members (username);`,
+				`create table sql_directory_service_accounts (accountname text PRIMARY KEY, uid_number int not null, gid_number int not null, mail text not null, login_shell text not null, password text not null, disabled int not null);`,
+			},
+			"postgres": {
+				`create table sql_directory_users (username text PRIMARY KEY, email text not null, given_name text not null);`,
+				`create table sql_directory_groups (groupname text PRIMARY KEY, managed_by text not null, gid_number int not null, mail text not null);`,
+				`create table sql_directory_members (groupname text not null, username text not null, PRIMARY KEY (groupname, username));`,
+				`create index sql_directory_members_username on sql_directory_members (username);`,
+				`create table sql_directory_service_accounts (accountname text PRIMARY KEY, uid_number int not null, gid_number int not null, mail text not null, login_shell text not null, password text not null, disabled int not null);`,
+			},
+		},
+	},
+}
+
+var createSchemaMigrationsStmt = map[string]string{
+	"sqlite":   "create table if not exists schema_migrations (version int PRIMARY KEY, description text not null, checksum text not null, applied_at int not null);",
+	"postgres": "create table if not exists schema_migrations (version int PRIMARY KEY, description text not null, checksum text not null, applied_at bigint not null);",
+}
+
+var getSchemaMigrationsStmt = map[string]string{
+	"sqlite":   "select version, checksum from schema_migrations order by version;",
+	"postgres": "select version, checksum from schema_migrations order by version;",
+}
+
+var getSchemaMigrationStmt = map[string]string{
+	"sqlite":   "select checksum from schema_migrations where version=?;",
+	"postgres": "select checksum from schema_migrations where version=$1;",
+}
+
+var insertSchemaMigrationStmt = map[string]string{
+	"sqlite":   "insert into schema_migrations(version, description, checksum, applied_at) values (?,?,?,?);",
+	"postgres": "insert into schema_migrations(version, description, checksum, applied_at) values ($1,$2,$3,$4);",
+}
+
+// the instances starting together apply the migrations one at a time
+const schemaMigrationsLockID = 0x736d616c6c706e74
+
+func (m schemaMigration) checksum(dbType string) string {
+	hash := sha256.New()
+	for _, stmt := range m.Statements[dbType] {
+		hash.Write([]byte(stmt))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type schemaStatus struct {
+	Current int
+	Latest  int
+	Pending []schemaMigration
+}
+
+// getSchemaStatus returns the version of the schema and the pending
+// migrations. It fails when the recorded migrations do not match the
+// migrations of the binary.
+func getSchemaStatus(state *RuntimeState) (schemaStatus, error) {
+	status := schemaStatus{Latest: schemaMigrations[len(schemaMigrations)-1].Version}
+	_, err := state.db.Exec(createSchemaMigrationsStmt[state.dbType])
+	if err != nil {
+		return status, err
+	}
+	rows, err := state.db.Query(getSchemaMigrationsStmt[state.dbType])
+	if err != nil {
+		return status, err
+	}
+	defer rows.Close()
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var checksum string
+		err = rows.Scan(&version, &checksum)
+		if err != nil {
+			return status, err
+		}
+		applied[version] = checksum
+		if version > status.Current {
+			status.Current = version
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return status, err
+	}
+	if status.Current > status.Latest {
+		return status, fmt.Errorf("the schema version %d is newer than the version %d of this binary",
+			status.Current, status.Latest)
+	}
+	for _, migration := range schemaMigrations {
+		checksum, ok := applied[migration.Version]
+		if !ok {
+			status.Pending = append(status.Pending, migration)
+			continue
+		}
+		if checksum != migration.checksum(state.dbType) {
+			return status, fmt.Errorf("the applied migration %d (%s) was changed",
+				migration.Version, migration.Description)
+		}
+	}
+	return status, nil
+}
+
+// migrateDB applies the pending migrations in order.
+func migrateDB(state *RuntimeState) error {
+	status, err := getSchemaStatus(state)
+	if err != nil {
+		return err
+	}
+	for _, migration := range status.Pending {
+		applied, err := applySchemaMigration(state, migration)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %s", migration.Version, migration.Description, err)
+		}
+		if applied {
+			slog.Info("applied schema migration", "version", migration.Version,
+				"description", migration.Description)
+		}
+	}
+	return nil
+}
+
+// applySchemaMigration applies the migration unless another instance did it
+// meanwhile.
+func applySchemaMigration(state *RuntimeState, migration schemaMigration) (bool, error) {
+	tx, err := state.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if state.dbType == "postgres" {
+		_, err = tx.Exec("select pg_advisory_xact_lock($1);", schemaMigrationsLockID)
+		if err != nil {
+			return false, err
+		}
+	}
+	checksum := migration.checksum(state.dbType)
+	var appliedChecksum string
+	err = tx.QueryRow(getSchemaMigrationStmt[state.dbType], migration.Version).Scan(&appliedChecksum)
+	switch {
+	case err == nil && appliedChecksum == checksum:
+		return false, nil
+	case err == nil:
+		return false, errors.New("it was applied with other statements")
+	case err != sql.ErrNoRows:
+		return false, err
+	}
+	for _, stmt := range migration.Statements[state.dbType] {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", strings.TrimSpace(stmt), err)
+		}
+	}
+	_, err = tx.Exec(insertSchemaMigrationStmt[state.dbType], migration.Version, migration.Description,
+		checksum, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// checkDBSchema fails unless the schema is current, for the instances that
+// leave the migrations to the migrate command.
+func checkDBSchema(state *RuntimeState) error {
+	status, err := getSchemaStatus(state)
+	if err != nil {
+		return err
+	}
+	if len(status.Pending) > 0 {
+		return fmt.Errorf("the schema version %d is behind the version %d, run the migrate command",
+			status.Current, status.Latest)
+	}
+	return nil
+}
+
+func migrateCommand(state *RuntimeState, args []string) int {
+	flagSet := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	statusOnly := flagSet.Bool("status", false, "Print the schema version and the pending migrations only")
+	err := flagSet.Parse(args)
+	if err != nil {
+		return 2
+	}
+	status, err := getSchemaStatus(state)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read the schema version: %s\n", err)
+		return 1
+	}
+	fmt.Printf("schema version %d, latest %d\n", status.Current, status.Latest)
+	for _, migration := range status.Pending {
+		fmt.Printf("pending %d %s\n", migration.Version, migration.Description)
+	}
+	if *statusOnly || len(status.Pending) == 0 {
+		return 0
+	}
+	err = migrateDB(state)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration FAILED: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Migrated to schema version %d\n", status.Latest)
+	return 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSchemaMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrations_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "migrations.db")
+	state.Config.Base.SkipStartupMigrations = true
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkDBSchema(&state) == nil {
+		t.Fatal("the empty schema was accepted")
+	}
+	err = migrateDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkDBSchema(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("select count(*) from pending_requests;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaultMigrations := schemaMigrations
+	defer func() { schemaMigrations = defaultMigrations }()
+	added := schemaMigration{Version: len(defaultMigrations) + 1, Description: "test table",
+		Statements: map[string][]string{
+			"sqlite":   {"create table migration_test (name text not null);"},
+			"postgres": {"create table migration_test (name text not null);"},
+		}}
+	schemaMigrations = append(defaultMigrations[:len(defaultMigrations):len(defaultMigrations)], added)
+	status, err := getSchemaStatus(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Current != added.Version-1 || len(status.Pending) != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	err = migrateDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the applied migrations are not applied again
+	err = migrateDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("insert into migration_test(name) values ('name');")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added.Statements = map[string][]string{"sqlite": {"create table migration_test2 (name text);"}}
+	schemaMigrations[len(schemaMigrations)-1] = added
+	if checkDBSchema(&state) == nil {
+		t.Fatal("the changed migration was accepted")
+	}
+	schemaMigrations = defaultMigrations
+	if checkDBSchema(&state) == nil {
+		t.Fatal("the newer schema was accepted")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// myRequest is a row of the my requests page.
+type myRequest struct {
+	accessRequest
+	// ApproverGroup is the group whose members decide the request, it is
+	// empty when the group no longer exists.
+	ApproverGroup string
+	Age           string
+}
+
+// formatRequestAge rounds the age of a request for the my requests page.
+func formatRequestAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%d minutes", int(age/time.Minute))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(age/time.Hour))
+	default:
+		return fmt.Sprintf("%d days", int(age/(24*time.Hour)))
+	}
+}
+
+// myRequestsHandler lists the requests of the user, the latest first.
+func (state *RuntimeState) myRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	requests, err := searchAccessRequestsInDB(accessRequestFilter{Username: username}, state)
+	if err != nil {
+		requestLogger(r).Error("myRequestsHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	var groupnames []string
+	for _, request := range requests {
+		groupnames = append(groupnames, request.Groupname)
+	}
+	approverGroups := make(map[string]string)
+	if len(groupnames) > 0 {
+		groupsAndManagers, err := state.requestUserinfo(r).GetGroupandManagedbyAttributeValue(groupnames)
+		if err != nil {
+			requestLogger(r).Error("myRequestsHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		for _, groupAndManager := range groupsAndManagers {
+			approverGroups[groupAndManager[0]] = groupAndManager[1]
+			if groupAndManager[1] == descriptionAttribute {
+				approverGroups[groupAndManager[0]] = groupAndManager[0]
+			}
+		}
+	}
+	now := time.Now()
+	pageData := myRequestsPageData{
+		UserName: username,
+		IsAdmin:  state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:    "My Requests",
+		Requests: []myRequest{},
+	}
+	for _, request := range requests {
+		pageData.Requests = append(pageData.Requests, myRequest{
+			accessRequest: request,
+			ApproverGroup: approverGroups[request.Groupname],
+			Age:           formatRequestAge(now.Sub(request.CreatedAt)),
+		})
+	}
+	sort.SliceStable(pageData.Requests, func(i, j int) bool {
+		return pageData.Requests[i].ID > pageData.Requests[j].ID
+	})
+	w.Header().Set("Cache-Control", "private, no-cache")
+	state.renderTemplateOrReturnJson(w, r, "myRequestsPage", pageData)
+}
+
+// cancelRequestHandler withdraws a pending request of the user.
+func (state *RuntimeState) cancelRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		state.writeFailureResponse(w, r, fmt.Sprintf("invalid id '%s'", r.FormValue("id")), http.StatusBadRequest)
+		return
+	}
+	request, err := getAccessRequestFromDB(id, state)
+	if err != nil {
+		requestLogger(r).Error("cancelRequestHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if request == nil {
+		state.writeFailureResponse(w, r, fmt.Sprintf("request %d does not exist", id), http.StatusNotFound)
+		return
+	}
+	if request.Username != username {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	if request.State != requestStatePending {
+		state.writeFailureResponse(w, r, fmt.Sprintf("request %d is %s", id, request.State), http.StatusBadRequest)
+		return
+	}
+	err = closeRequestInDB(username, request.Groupname, requestStateCancelled, username, "", state)
+	if err != nil {
+		requestLogger(r).Error("cancelRequestHandler failed", "err", err)
+		state.recordAuditEvent(r, username, auditActionCancelRequest, request.Groupname, username,
+			auditOutcomeFailure, err.Error())
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.recordAuditEvent(r, username, auditActionCancelRequest, request.Groupname, username,
+		auditOutcomeSuccess, "")
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:          "Request Cancelled",
+		SuccessMessage: "Your request to join " + request.Groupname + " was cancelled",
+		ContinueURL:    myRequestsPath,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFormatRequestAge(t *testing.T) {
+	tests := map[time.Duration]string{
+		time.Second * 10:   "just now",
+		time.Minute * 5:    "5 minutes",
+		time.Hour * 30:     "30 hours",
+		time.Hour * 24 * 9: "9 days",
+	}
+	for age, expected := range tests {
+		if formatted := formatRequestAge(age); formatted != expected {
+			t.Errorf("%s formatted as %s", age, formatted)
+		}
+	}
+}
+
+func TestMyRequests(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "myrequests_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "myrequests.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = insertRequestInDB("user2", []string{"group3"}, "on call", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = insertRequestInDB("user1", []string{"group3"}, "", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getMyRequests := func() []myRequest {
+		req, err := http.NewRequest(getMethod, myRequestsPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookie := testCreateValidCookie(state.authenticator)
+		testAddAuthCookie(req, state.authenticator, cookie)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(state.myRequestsHandler).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("my requests returned %d", rr.Code)
+		}
+		var pageData myRequestsPageData
+		err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pageData.Requests
+	}
+	requests := getMyRequests()
+	if len(requests) != 1 || requests[0].Groupname != "group3" || requests[0].State != requestStatePending ||
+		requests[0].ApproverGroup != "group1" || requests[0].Age != "just now" {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	others, err := searchAccessRequestsInDB(accessRequestFilter{Username: "user1"}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := func(id string) int {
+		return testPostServiceAccountForm(t, &state, cancelRequestPath, state.cancelRequestHandler, false,
+			url.Values{"id": {id}})
+	}
+	if code := cancel(strconv.FormatInt(others[0].ID, 10)); code != http.StatusForbidden {
+		t.Fatalf("cancelling the request of another user returned %d", code)
+	}
+	if code := cancel("0"); code != http.StatusNotFound {
+		t.Fatalf("cancelling an unknown request returned %d", code)
+	}
+	id := strconv.FormatInt(requests[0].ID, 10)
+	if code := cancel(id); code != http.StatusOK {
+		t.Fatalf("cancel returned %d", code)
+	}
+	if code := cancel(id); code != http.StatusBadRequest {
+		t.Fatalf("cancelling twice returned %d", code)
+	}
+	requests = getMyRequests()
+	if len(requests) != 1 || requests[0].State != requestStateCancelled || requests[0].DecidedBy != "user2" {
+		t.Fatalf("the request was not cancelled %+v", requests)
+	}
+	if entryExistsorNot("user2", "group3", &state) {
+		t.Fatal("the cancelled request is still pending")
+	}
+}
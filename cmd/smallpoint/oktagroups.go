@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The members of the groups enabled for Okta are pushed to Okta groups for
+// the apps that cannot read the directory. The admins pick the Okta group
+// of each group and can disable the push of a group without losing its
+// mapping. The members missing from the Okta group are added and the other
+// users of the Okta group are removed, by a periodic job and after each
+// membership change. After alert_after failures in a row of a group the
+// alert recipients are mailed, and again once the push recovers.
+
+const (
+	defaultOktaPushInterval   = time.Hour
+	defaultOktaTimeout        = 30 * time.Second
+	defaultOktaAlertAfter     = 3
+	oktaPageSize              = 200
+	oktaServiceName           = "okta"
+	maxOktaPushErrorLength    = 512
+	oktaGroupPushIntegration  = "okta"
+	maxOktaResponseSize       = 4 << 20
+	oktaGroupPushAlertSubject = "Okta push of group %s"
+)
+
+var oktaGroupIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{10,32}$`)
+
+type oktaConfig struct {
+	// OrgURL is the URL of the Okta org, e.g. https://example.okta.com.
+	OrgURL string `yaml:"org_url"`
+	// TokenFilename holds an API token allowed to manage the groups, the
+	// push is disabled without it.
+	TokenFilename string `yaml:"token_filename"`
+	// LoginSuffix is appended to the usernames to get the Okta logins, e.g.
+	// "@example.com".
+	LoginSuffix string        `yaml:"login_suffix"`
+	Interval    time.Duration `yaml:"interval"`
+	Timeout     time.Duration `yaml:"timeout"`
+	// AlertRecipients are mailed when the push of a group fails AlertAfter
+	// times in a row.
+	AlertRecipients []string `yaml:"alert_recipients"`
+	AlertAfter      int      `yaml:"alert_after"`
+}
+
+func (config oktaConfig) enabled() bool {
+	return config.TokenFilename != ""
+}
+
+func (config oktaConfig) interval() time.Duration {
+	if config.Interval <= 0 {
+		return defaultOktaPushInterval
+	}
+	return config.Interval
+}
+
+func (config oktaConfig) alertAfter() int {
+	if config.AlertAfter <= 0 {
+		return defaultOktaAlertAfter
+	}
+	return config.AlertAfter
+}
+
+func (config oktaConfig) check() error {
+	if !config.enabled() {
+		return nil
+	}
+	parsedURL, err := url.Parse(config.OrgURL)
+	if err != nil || parsedURL.Scheme != "https" || parsedURL.Host == "" {
+		return fmt.Errorf("org_url must be an https URL")
+	}
+	return nil
+}
+
+type oktaGroupPush struct {
+	Groupname     string
+	OktaGroupID   string
+	Enabled       bool
+	UpdatedBy     string
+	UpdatedAt     time.Time
+	LastPush      time.Time
+	LastPushError string
+	// Failures counts the failed pushes since the last successful one.
+	Failures int
+}
+
+var getOktaGroupPushesStmt = map[string]string{
+	"sqlite":   "select groupname, okta_group_id, enabled, updated_by, updated_at, last_push, last_push_error, failures from okta_group_pushes order by groupname;",
+	"postgres": "select groupname, okta_group_id, enabled, updated_by, updated_at, last_push, last_push_error, failures from okta_group_pushes order by groupname;",
+}
+
+var getOktaGroupPushStmt = map[string]string{
+	"sqlite":   "select groupname, okta_group_id, enabled, updated_by, updated_at, last_push, last_push_error, failures from okta_group_pushes where groupname=?;",
+	"postgres": "select groupname, okta_group_id, enabled, updated_by, updated_at, last_push, last_push_error, failures from okta_group_pushes where groupname=$1;",
+}
+
+var setOktaGroupPushStmts = map[string][]string{
+	"sqlite": {"delete from okta_group_pushes where groupname=?;",
+		"insert into okta_group_pushes(groupname, okta_group_id, enabled, updated_by, updated_at, last_push, last_push_error, failures) values (?,?,1,?,?,0,'',0);"},
+	"postgres": {"delete from okta_group_pushes where groupname=$1;",
+		"insert into okta_group_pushes(groupname, okta_group_id, enabled, updated_by, updated_at, last_push, last_push_error, failures) values ($1,$2,1,$3,$4,0,'',0);"},
+}
+
+var enableOktaGroupPushStmt = map[string]string{
+	"sqlite":   "update okta_group_pushes set enabled=?, updated_by=?, updated_at=? where groupname=?;",
+	"postgres": "update okta_group_pushes set enabled=$1, updated_by=$2, updated_at=$3 where groupname=$4;",
+}
+
+var deleteOktaGroupPushStmt = map[string]string{
+	"sqlite":   "delete from okta_group_pushes where groupname=?;",
+	"postgres": "delete from okta_group_pushes where groupname=$1;",
+}
+
+var setOktaGroupPushStatusStmt = map[string]string{
+	"sqlite":   "update okta_group_pushes set last_push=?, last_push_error=?, failures=? where groupname=?;",
+	"postgres": "update okta_group_pushes set last_push=$1, last_push_error=$2, failures=$3 where groupname=$4;",
+}
+
+func queryOktaGroupPushes(state *RuntimeState, stmtText string, args ...interface{}) ([]oktaGroupPush, error) {
+	start := time.Now()
+	rows, err := state.db.Query(stmtText, args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var pushes []oktaGroupPush
+	for rows.Next() {
+		var push oktaGroupPush
+		var enabled int
+		var updatedAt, lastPush int64
+		err = rows.Scan(&push.Groupname, &push.OktaGroupID, &enabled, &push.UpdatedBy, &updatedAt, &lastPush,
+			&push.LastPushError, &push.Failures)
+		if err != nil {
+			return nil, err
+		}
+		push.Enabled = enabled != 0
+		push.UpdatedAt = time.Unix(updatedAt, 0)
+		push.LastPush = time.Unix(lastPush, 0)
+		pushes = append(pushes, push)
+	}
+	return pushes, rows.Err()
+}
+
+func setOktaGroupPushInDB(push oktaGroupPush, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := setOktaGroupPushStmts[state.dbType]
+	_, err = tx.Exec(stmts[0], push.Groupname)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(stmts[1], push.Groupname, push.OktaGroupID, push.UpdatedBy, push.UpdatedAt.Unix())
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// oktaClient calls the management API of Okta.
+type oktaClient struct {
+	orgURL string
+	token  string
+	client *http.Client
+}
+
+func newOktaClient(config oktaConfig) (*oktaClient, error) {
+	token, err := ioutil.ReadFile(config.TokenFilename)
+	if err != nil {
+		return nil, err
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultOktaTimeout
+	}
+	return &oktaClient{
+		orgURL: strings.TrimSuffix(config.OrgURL, "/"),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// do calls the API, it returns the URL of the next page of a list.
+func (c *oktaClient) do(method string, requestURL string, body interface{}, response interface{}) (string,
+	error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	if strings.HasPrefix(requestURL, "/") {
+		requestURL = c.orgURL + requestURL
+	}
+	req, err := http.NewRequest(method, requestURL, reader)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "SSWS "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	metrics.MetricLogExternalServiceDuration(oktaServiceName, time.Since(start))
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOktaResponseSize))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var oktaErr struct {
+			ErrorSummary string `json:"errorSummary"`
+		}
+		if json.Unmarshal(content, &oktaErr) == nil && oktaErr.ErrorSummary != "" {
+			return "", fmt.Errorf("%s %s failed with status %d: %s", method, req.URL.Path, resp.StatusCode,
+				oktaErr.ErrorSummary)
+		}
+		return "", fmt.Errorf("%s %s failed with status %d", method, req.URL.Path, resp.StatusCode)
+	}
+	if response != nil && len(content) > 0 {
+		err = json.Unmarshal(content, response)
+		if err != nil {
+			return "", err
+		}
+	}
+	return nextLink(resp.Header.Values("Link")), nil
+}
+
+var linkNextPattern = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="next"`)
+
+// nextLink returns the URL of the next page of the Link headers.
+func nextLink(links []string) string {
+	for _, link := range links {
+		match := linkNextPattern.FindStringSubmatch(link)
+		if match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+type oktaUser struct {
+	ID      string `json:"id"`
+	Profile struct {
+		Login string `json:"login"`
+	} `json:"profile"`
+}
+
+func oktaGroupPath(groupID string) string {
+	return "/api/v1/groups/" + url.PathEscape(groupID)
+}
+
+// groupUsers returns the ids of the users of the group by lower case login.
+func (c *oktaClient) groupUsers(groupID string) (map[string]string, error) {
+	users := make(map[string]string)
+	next := fmt.Sprintf("%s/users?limit=%d", oktaGroupPath(groupID), oktaPageSize)
+	for next != "" {
+		var page []oktaUser
+		var err error
+		next, err = c.do(getMethod, next, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range page {
+			users[strings.ToLower(user.Profile.Login)] = user.ID
+		}
+		// the token is only sent to the org
+		if next != "" && !strings.HasPrefix(next, c.orgURL+"/") {
+			return nil, fmt.Errorf("the next page %s is not on the org", next)
+		}
+	}
+	return users, nil
+}
+
+func (c *oktaClient) userID(login string) (string, error) {
+	var user oktaUser
+	_, err := c.do(getMethod, "/api/v1/users/"+url.PathEscape(login), nil, &user)
+	return user.ID, err
+}
+
+func (c *oktaClient) groupExists(groupID string) error {
+	_, err := c.do(getMethod, oktaGroupPath(groupID), nil, nil)
+	return err
+}
+
+// pushOktaGroup changes the users of the Okta group to the members of the
+// group. The failed changes do not stop the others, the first error is
+// returned.
+func (state *RuntimeState) pushOktaGroup(client *oktaClient, push oktaGroupPush) (int, int, error) {
+	members, _, err := state.Userinfo.GetusersofaGroup(push.Groupname)
+	if err != nil {
+		return 0, 0, err
+	}
+	logins := make(map[string]bool)
+	for _, member := range members {
+		logins[strings.ToLower(member+state.Config.Okta.LoginSuffix)] = true
+	}
+	users, err := client.groupUsers(push.OktaGroupID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var toAdd, toRemove []string
+	for login := range logins {
+		if _, ok := users[login]; !ok {
+			toAdd = append(toAdd, login)
+		}
+	}
+	for login := range users {
+		if !logins[login] {
+			toRemove = append(toRemove, login)
+		}
+	}
+	sort.Strings(toAdd)
+	sort.Strings(toRemove)
+	var added, removed, failed int
+	var firstErr error
+	fail := func(err error) {
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, login := range toAdd {
+		userID, err := client.userID(login)
+		if err == nil {
+			_, err = client.do(http.MethodPut, oktaGroupPath(push.OktaGroupID)+"/users/"+url.PathEscape(userID),
+				nil, nil)
+		}
+		if err != nil {
+			fail(err)
+			continue
+		}
+		added++
+	}
+	for _, login := range toRemove {
+		_, err = client.do(http.MethodDelete, oktaGroupPath(push.OktaGroupID)+"/users/"+
+			url.PathEscape(users[login]), nil, nil)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		removed++
+	}
+	if firstErr != nil {
+		return added, removed, fmt.Errorf("%d changes failed, the first one: %s", failed, firstErr)
+	}
+	return added, removed, nil
+}
+
+// alertOktaGroupPush mails the alert recipients about the push of the
+// group, failing or recovered.
+func (state *RuntimeState) alertOktaGroupPush(push oktaGroupPush, failures int, pushErr error) {
+	recipients := state.Config.Okta.AlertRecipients
+	body := fmt.Sprintf("The members of group %s are pushed to the Okta group %s again.\n", push.Groupname,
+		push.OktaGroupID)
+	if pushErr != nil {
+		slog.Error("Okta group push keeps failing", "group", push.Groupname, "failures", failures, "err", pushErr)
+		body = fmt.Sprintf("The members of group %s could not be pushed to the Okta group %s %d times in a row, "+
+			"the Okta group is out of date.\n\nThe last error: %s\n", push.Groupname, push.OktaGroupID, failures,
+			pushErr)
+	}
+	if len(recipients) == 0 {
+		return
+	}
+	err := state.sendEmailWithAttachments(recipients, fmt.Sprintf(oktaGroupPushAlertSubject, push.Groupname),
+		body, nil)
+	if err != nil {
+		slog.Error("cannot mail the Okta group push alert", "group", push.Groupname, "err", err)
+	}
+}
+
+// pushOktaGroups pushes the enabled groups and records the outcome of each
+// one.
+func (state *RuntimeState) pushOktaGroups(pushes []oktaGroupPush) error {
+	state.oktaPushMutex.Lock()
+	defer state.oktaPushMutex.Unlock()
+	var client *oktaClient
+	var clientErr error
+	var failed, enabled int
+	for _, push := range pushes {
+		if !push.Enabled {
+			continue
+		}
+		enabled++
+		if client == nil && clientErr == nil {
+			client, clientErr = newOktaClient(state.Config.Okta)
+		}
+		pushErr := clientErr
+		var added, removed int
+		if pushErr == nil {
+			added, removed, pushErr = state.pushOktaGroup(client, push)
+		}
+		failures := 0
+		message := ""
+		if pushErr != nil {
+			failed++
+			failures = push.Failures + 1
+			metrics.MetricLogGroupPushFailure(oktaGroupPushIntegration)
+			slog.Error("Okta group push failed", "group", push.Groupname, "okta_group", push.OktaGroupID,
+				"err", pushErr)
+			message = pushErr.Error()
+			if len(message) > maxOktaPushErrorLength {
+				message = message[:maxOktaPushErrorLength]
+			}
+		}
+		if added > 0 || removed > 0 {
+			slog.Info("Okta group pushed", "group", push.Groupname, "okta_group", push.OktaGroupID,
+				"added", added, "removed", removed)
+		}
+		err := execServiceAccountUpdate(state, setOktaGroupPushStatusStmt[state.dbType], time.Now().Unix(), message,
+			failures, push.Groupname)
+		if err != nil {
+			return err
+		}
+		alertAfter := state.Config.Okta.alertAfter()
+		if failures == alertAfter || (pushErr == nil && push.Failures >= alertAfter) {
+			state.alertOktaGroupPush(push, failures, pushErr)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d Okta group pushes failed", failed, enabled)
+	}
+	return nil
+}
+
+// runOktaGroupPushes is the periodic job pushing every enabled group.
+func (state *RuntimeState) runOktaGroupPushes() error {
+	pushes, err := queryOktaGroupPushes(state, getOktaGroupPushesStmt[state.dbType])
+	if err != nil {
+		return err
+	}
+	return state.pushOktaGroups(pushes)
+}
+
+// pushOktaGroupOf pushes the group when it is enabled for Okta.
+func (state *RuntimeState) pushOktaGroupOf(groupname string) {
+	pushes, err := queryOktaGroupPushes(state, getOktaGroupPushStmt[state.dbType], groupname)
+	if err == nil {
+		err = state.pushOktaGroups(pushes)
+	}
+	if err != nil {
+		slog.Error("cannot push the group to Okta", "group", groupname, "err", err)
+	}
+}
+
+// oktaMembershipChanged pushes the group after its membership changed.
+func (state *RuntimeState) oktaMembershipChanged(groupname string) {
+	if state.Config.Okta.enabled() {
+		state.pushOktaGroupOf(groupname)
+	}
+}
+
+// setOktaGroupPush returns the message for the user when the push is
+// invalid.
+func (state *RuntimeState) setOktaGroupPush(r *http.Request, push oktaGroupPush) (string, error) {
+	if !oktaGroupIDPattern.MatchString(push.OktaGroupID) {
+		return "the Okta group must be given by its id, e.g. 00g1emaKYZTWRYYRRTSK", nil
+	}
+	exists, _, err := state.requestUserinfo(r).GroupnameExistsornot(push.Groupname)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "group " + push.Groupname + " does not exist", nil
+	}
+	client, err := newOktaClient(state.Config.Okta)
+	if err != nil {
+		return "", err
+	}
+	err = client.groupExists(push.OktaGroupID)
+	if err != nil {
+		return fmt.Sprintf("cannot find the Okta group %s: %s", push.OktaGroupID, err), nil
+	}
+	return "", setOktaGroupPushInDB(push, state)
+}
+
+// oktaGroupsHandler lists the groups pushed to Okta, the admins add,
+// enable, disable, delete and push them.
+func (state *RuntimeState) oktaGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	message := ""
+	switch r.Method {
+	case getMethod:
+	case postMethod:
+		if !state.Config.Okta.enabled() {
+			state.writeFailureResponse(w, r, "Okta group push is not configured", http.StatusBadRequest)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			requestLogger(r).Error("oktaGroupsHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		groupname := r.PostFormValue("groupname")
+		action := r.PostFormValue("action")
+		details := ""
+		switch action {
+		case "set":
+			push := oktaGroupPush{Groupname: groupname, OktaGroupID: strings.TrimSpace(r.PostFormValue("okta_group")),
+				UpdatedBy: username, UpdatedAt: time.Now()}
+			failure, err := state.setOktaGroupPush(r, push)
+			if err != nil {
+				requestLogger(r).Error("oktaGroupsHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			if failure != "" {
+				state.writeFailureResponse(w, r, failure, http.StatusBadRequest)
+				return
+			}
+			details = "pushed to " + push.OktaGroupID
+			message = fmt.Sprintf("Group %s is pushed to the Okta group %s", groupname, push.OktaGroupID)
+			go state.pushOktaGroupOf(groupname)
+		case "enable", "disable":
+			enabled := 0
+			if action == "enable" {
+				enabled = 1
+			}
+			err = execServiceAccountUpdate(state, enableOktaGroupPushStmt[state.dbType], enabled, username,
+				time.Now().Unix(), groupname)
+			details = action + "d the push"
+			message = fmt.Sprintf("The Okta push of group %s is %sd", groupname, action)
+			if action == "enable" {
+				go state.pushOktaGroupOf(groupname)
+			}
+		case "delete":
+			err = execServiceAccountUpdate(state, deleteOktaGroupPushStmt[state.dbType], groupname)
+			details = "deleted the push"
+			message = fmt.Sprintf("Group %s is no longer pushed to Okta, the Okta group is left alone", groupname)
+		case "push":
+			go state.pushOktaGroupOf(groupname)
+			message = fmt.Sprintf("Group %s is being pushed to Okta", groupname)
+		default:
+			state.writeFailureResponse(w, r, "action must be set, enable, disable, delete or push",
+				http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("oktaGroupsHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		if details != "" {
+			state.recordAuditEvent(r, username, auditActionUpdateOktaGroupPush, groupname, "", auditOutcomeSuccess,
+				details)
+		}
+	default:
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	pushes, err := queryOktaGroupPushes(state, getOktaGroupPushesStmt[state.dbType])
+	if err != nil {
+		requestLogger(r).Error("oktaGroupsHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	pageData := oktaGroupsPageData{
+		UserName:   username,
+		IsAdmin:    true,
+		Title:      "Okta Groups",
+		Enabled:    state.Config.Okta.enabled(),
+		AlertAfter: state.Config.Okta.alertAfter(),
+		Pushes:     pushes,
+		Message:    message,
+	}
+	state.renderTemplateOrReturnJson(w, r, "oktaGroupsPage", pageData)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testOktaGroupID = "00g1emaKYZTWRYYRRTSK"
+
+// testOktaServer serves the group endpoints of a single Okta group, the
+// user ids are the logins.
+type testOktaServer struct {
+	mutex   sync.Mutex
+	failing bool
+	users   map[string]bool
+}
+
+func (s *testOktaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r.Header.Get("Authorization") != "SSWS okta-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.failing {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"errorSummary":"API call exceeded rate limit"}`))
+		return
+	}
+	groupPath := "/api/v1/groups/" + testOktaGroupID
+	switch {
+	case r.Method == getMethod && r.URL.Path == groupPath:
+		w.Write([]byte(`{"id":"` + testOktaGroupID + `"}`))
+	case r.Method == getMethod && r.URL.Path == groupPath+"/users":
+		var logins []string
+		for login := range s.users {
+			logins = append(logins, login)
+		}
+		sort.Strings(logins)
+		// a page per user
+		after := r.URL.Query().Get("after")
+		var page []map[string]interface{}
+		for i, login := range logins {
+			if login > after {
+				page = append(page, map[string]interface{}{"id": login,
+					"profile": map[string]string{"login": login}})
+				if i < len(logins)-1 {
+					w.Header().Set("Link", `<http://`+r.Host+groupPath+`/users?after=`+login+`>; rel="next"`)
+				}
+				break
+			}
+		}
+		json.NewEncoder(w).Encode(page)
+	case r.Method == getMethod && strings.HasPrefix(r.URL.Path, "/api/v1/users/"):
+		login := strings.TrimPrefix(r.URL.Path, "/api/v1/users/")
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": login, "profile": map[string]string{"login": login}})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, groupPath+"/users/"):
+		s.users[strings.TrimPrefix(r.URL.Path, groupPath+"/users/")] = true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, groupPath+"/users/"):
+		delete(s.users, strings.TrimPrefix(r.URL.Path, groupPath+"/users/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errorSummary":"Not found: Resource not found"}`))
+	}
+}
+
+func (s *testOktaServer) setFailing(failing bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failing = failing
+}
+
+func (s *testOktaServer) logins() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var logins []string
+	for login := range s.users {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	return logins
+}
+
+func TestOktaGroupPushes(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("delete from okta_group_pushes;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	okta := &testOktaServer{users: map[string]bool{"former@example.com": true, "user1@example.com": true}}
+	server := httptest.NewServer(okta)
+	defer server.Close()
+	tokenFilename := filepath.Join(t.TempDir(), "token")
+	err = ioutil.WriteFile(tokenFilename, []byte("okta-token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Okta = oktaConfig{OrgURL: server.URL, TokenFilename: tokenFilename, LoginSuffix: "@example.com",
+		AlertRecipients: []string{"oncall@example.com"}, AlertAfter: 2}
+	var mails []string
+	smtpClient = func(addr string) (smtpDialer, error) {
+		client := &smtpDialerMock{}
+		mails = append(mails, addr)
+		return client, nil
+	}
+
+	push := url.Values{"action": {"set"}, "groupname": {"group1"}, "okta_group": {testOktaGroupID}}
+	code := testPostServiceAccountForm(t, &state, oktaGroupsPath, state.oktaGroupsHandler, false, push)
+	if code != http.StatusForbidden {
+		t.Fatalf("only admins push groups, got %d", code)
+	}
+	for field, value := range map[string]string{"groupname": "nogroup", "okta_group": "00gmissingmissing",
+		"action": "rename"} {
+		invalid := url.Values{}
+		for k, v := range push {
+			invalid[k] = v
+		}
+		invalid.Set(field, value)
+		code = testPostServiceAccountForm(t, &state, oktaGroupsPath, state.oktaGroupsHandler, true, invalid)
+		if code != http.StatusBadRequest {
+			t.Errorf("invalid %s should fail, got %d", field, code)
+		}
+	}
+	// the push is set up without the background push of the handler
+	err = setOktaGroupPushInDB(oktaGroupPush{Groupname: "group1", OktaGroupID: testOktaGroupID,
+		UpdatedBy: "user1", UpdatedAt: time.Now()}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	okta.setFailing(true)
+	for i := 0; i < 3; i++ {
+		if state.runOktaGroupPushes() == nil {
+			t.Fatal("the failed push was not reported")
+		}
+	}
+	pushes, err := queryOktaGroupPushes(&state, getOktaGroupPushesStmt[state.dbType])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pushes) != 1 || pushes[0].Failures != 3 || !strings.Contains(pushes[0].LastPushError, "rate limit") {
+		t.Fatalf("pushes %+v", pushes)
+	}
+	if len(mails) != 1 {
+		t.Fatalf("%d alerts mailed after the failures", len(mails))
+	}
+
+	okta.setFailing(false)
+	err = state.runOktaGroupPushes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins := okta.logins(); !reflect.DeepEqual(logins, []string{"user1@example.com", "user2@example.com"}) {
+		t.Fatalf("Okta group users %v", logins)
+	}
+	if len(mails) != 2 {
+		t.Fatalf("%d alerts mailed after the recovery", len(mails))
+	}
+	pushes, err = queryOktaGroupPushes(&state, getOktaGroupPushesStmt[state.dbType])
+	if err != nil || len(pushes) != 1 || pushes[0].Failures != 0 || pushes[0].LastPushError != "" {
+		t.Fatalf("pushes %+v, err %v", pushes, err)
+	}
+
+	code = testPostServiceAccountForm(t, &state, oktaGroupsPath, state.oktaGroupsHandler, true,
+		url.Values{"action": {"disable"}, "groupname": {"group1"}})
+	if code != http.StatusOK {
+		t.Fatalf("cannot disable the push, got %d", code)
+	}
+	okta.setFailing(true)
+	err = state.runOktaGroupPushes()
+	if err != nil {
+		t.Fatalf("the disabled push ran: %s", err)
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	link := nextLink([]string{`<https://example.okta.com/api/v1/groups/x/users?limit=200>; rel="self"`,
+		`<https://example.okta.com/api/v1/groups/x/users?after=a&limit=200>; rel="next"`})
+	if link != "https://example.okta.com/api/v1/groups/x/users?after=a&limit=200" {
+		t.Fatalf("next link %s", link)
+	}
+	if nextLink(nil) != "" {
+		t.Fatal("next link without Link header")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A minimal PDF writer for text only reports. It emits letter sized pages
+// using the builtin Courier font so that columns can be aligned with spaces.
+
+const (
+	pdfPageWidth    = 612
+	pdfPageHeight   = 792
+	pdfMargin       = 50
+	pdfFontSize     = 9
+	pdfLineHeight   = 11
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+	pdfMaxLineChars = 95
+)
+
+var pdfTextEscaper = strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+
+// pdfSanitizeLine keeps printable ASCII only, which is what the builtin
+// fonts can render without embedding an encoding.
+func pdfSanitizeLine(line string) string {
+	var buf bytes.Buffer
+	for _, r := range line {
+		if r < 32 || r > 126 {
+			r = '?'
+		}
+		buf.WriteRune(r)
+	}
+	return pdfTextEscaper.Replace(buf.String())
+}
+
+// wrapPDFLines splits lines longer than what fits in a page.
+func wrapPDFLines(lines []string) []string {
+	var wrapped []string
+	for _, line := range lines {
+		for len(line) > pdfMaxLineChars {
+			wrapped = append(wrapped, line[:pdfMaxLineChars])
+			line = "    " + line[pdfMaxLineChars:]
+		}
+		wrapped = append(wrapped, line)
+	}
+	return wrapped
+}
+
+func writeTextPDF(w io.Writer, title string, lines []string) error {
+	lines = wrapPDFLines(lines)
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	var buf bytes.Buffer
+	var offsets []int
+	startObject := func() {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n", len(offsets))
+	}
+	buf.WriteString("%PDF-1.4\n")
+	// objects 1-4 are fixed, then a page and content object per page
+	startObject()
+	buf.WriteString("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	startObject()
+	var kids []string
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	fmt.Fprintf(&buf, "<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(pages))
+	startObject()
+	buf.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>\nendobj\n")
+	startObject()
+	fmt.Fprintf(&buf, "<< /Title (%s) /Producer (smallpoint) >>\nendobj\n", pdfSanitizeLine(title))
+	for i, pageLines := range pages {
+		startObject()
+		fmt.Fprintf(&buf, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>\nendobj\n",
+			pdfPageWidth, pdfPageHeight, 6+2*i)
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLineHeight,
+			pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range pageLines {
+			fmt.Fprintf(&content, "(%s) '\n", pdfSanitizeLine(line))
+		}
+		content.WriteString("ET\n")
+		startObject()
+		fmt.Fprintf(&buf, "<< /Length %d >>\nstream\n", content.Len())
+		buf.Write(content.Bytes())
+		buf.WriteString("endstream\nendobj\n")
+	}
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(offsets)+1, xrefOffset)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+type memberLookupCountingUserInfo struct {
+	userinfo.UserInfoBackend
+	mutex   sync.Mutex
+	lookups map[string]int
+}
+
+func (u *memberLookupCountingUserInfo) GetusersofaGroup(groupname string) ([]string, string, error) {
+	u.mutex.Lock()
+	u.lookups[groupname]++
+	u.mutex.Unlock()
+	return u.UserInfoBackend.GetusersofaGroup(groupname)
+}
+
+func TestPendingRequestsLookups(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, groupname := range []string{"pending-joined", "pending-deleted"} {
+		err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: groupname, Description: "group1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = insertRequestInDB("user3", []string{"pending-joined", "pending-deleted"}, "", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = insertRequestInDB("user1", []string{"pending-joined"}, "", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = state.Userinfo.AddmemberstoExisting(userinfo.GroupInfo{Groupname: "pending-joined",
+		MemberUid: []string{"user1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.DeleteGroup([]string{"pending-deleted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &memberLookupCountingUserInfo{UserInfoBackend: state.Userinfo, lookups: make(map[string]int)}
+	state.Userinfo = counting
+	err = state.cleanupPendingRequests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counting.lookups["pending-joined"] != 1 || counting.lookups["pending-deleted"] != 1 {
+		t.Fatalf("the members were looked up %v times", counting.lookups)
+	}
+	if entryExistsorNot("user1", "pending-joined", &state) || entryExistsorNot("user3", "pending-deleted", &state) {
+		t.Fatal("the requests of members and deleted groups should be dropped")
+	}
+	if !entryExistsorNot("user3", "pending-joined", &state) {
+		t.Fatal("the request of user3 should be kept")
+	}
+	// the cleanups run once per interval
+	err = state.cleanupPendingRequests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counting.lookups["pending-joined"] != 1 {
+		t.Fatal("the cleanup ran again within the interval")
+	}
+
+	// approving a batch looks up the members of the group once
+	err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: "pending-approved", Description: "group1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"user1", "user3"} {
+		err = insertRequestInDB(username, []string{"pending-approved"}, "", &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	counting.lookups = make(map[string]int)
+	jsonBytes, err := json.Marshal(map[string][][]string{
+		"groups": {{"user1", "pending-approved"}, {"user3", "pending-approved"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(postMethod, approverequestPath, bytes.NewReader(jsonBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.approveHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("approval failed with %d", rr.Code)
+	}
+	if counting.lookups["pending-approved"] != 1 {
+		t.Fatalf("the members were looked up %d times", counting.lookups["pending-approved"])
+	}
+	members, _, err := state.Userinfo.GetusersofaGroup("pending-approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("bad members %v", members)
+	}
+
+	// the managers of the requested groups come from the listing
+	groups, err := state.getPendingRequestGroupsofUser("user3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, [][]string{{"pending-joined", "group1"}}) {
+		t.Fatalf("bad pending requests %v", groups)
+	}
+}
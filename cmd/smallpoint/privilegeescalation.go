@@ -0,0 +1,386 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The changes that escalate privileges are detected: a user adding
+// themselves to a protected group, approving their own request to one, and
+// the removal of mass_removal_threshold members or more at once. They are
+// logged as privilege_escalation security events and mailed to the alert
+// recipients at once. Every path adding or removing members checks them:
+// the web pages, the group API, SCIM, the merges, the imports, the HR
+// events and the undos. With require_approval the changes are not applied but
+// held until another super admin approves them on the held changes page,
+// and the self approvals are refused, the request waits for another
+// manager.
+
+const (
+	securityEventPrivilegeEscalation = "privilege_escalation"
+
+	escalationSelfAddProtected   = "self_add_protected_group"
+	escalationSelfApproval       = "self_approval_protected_group"
+	escalationMassRemoval        = "mass_removal"
+	privilegeEscalationAlertSubj = "Privilege escalation in group %s"
+
+	heldChangeAddMembers    = "add_members"
+	heldChangeRemoveMembers = "remove_members"
+)
+
+type privilegeEscalationConfig struct {
+	// ProtectedGroups are the groups granting privileges, e.g. the admin
+	// groups of the other systems.
+	ProtectedGroups []string `yaml:"protected_groups"`
+	// MassRemovalThreshold is the number of members removed at once that
+	// is a mass removal, 0 disables the detection.
+	MassRemovalThreshold int      `yaml:"mass_removal_threshold"`
+	AlertRecipients      []string `yaml:"alert_recipients"`
+	// RequireApproval holds the detected changes for the approval of
+	// another super admin.
+	RequireApproval bool `yaml:"require_approval"`
+}
+
+func (config privilegeEscalationConfig) check() error {
+	if config.MassRemovalThreshold < 0 {
+		return errors.New("mass_removal_threshold cannot be negative")
+	}
+	return nil
+}
+
+func (config privilegeEscalationConfig) protectedGroup(groupname string) bool {
+	for _, protected := range config.ProtectedGroups {
+		if protected == groupname {
+			return true
+		}
+	}
+	return false
+}
+
+// detect returns the escalation of the change of the actor, "" for none.
+func (config privilegeEscalationConfig) detect(actor string, groupname string, added []string,
+	removed []string) string {
+	if config.protectedGroup(groupname) {
+		for _, member := range added {
+			if member == actor {
+				return escalationSelfAddProtected
+			}
+		}
+	}
+	if config.MassRemovalThreshold > 0 && len(removed) >= config.MassRemovalThreshold {
+		return escalationMassRemoval
+	}
+	return ""
+}
+
+type heldChange struct {
+	ID          int64     `json:"id"`
+	Groupname   string    `json:"groupname"`
+	Action      string    `json:"action"`
+	Usernames   []string  `json:"usernames"`
+	RequestedBy string    `json:"requested_by"`
+	Risk        string    `json:"risk"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+var insertHeldChangeStmt = map[string]string{
+	"sqlite":   "insert into held_changes(groupname, action, usernames, requested_by, risk, time_stamp) values (?,?,?,?,?,?);",
+	"postgres": "insert into held_changes(groupname, action, usernames, requested_by, risk, time_stamp) values ($1,$2,$3,$4,$5,$6);",
+}
+
+var heldChangeExistsStmt = map[string]string{
+	"sqlite":   "select count(*) from held_changes where groupname=? and action=? and usernames=? and requested_by=?;",
+	"postgres": "select count(*) from held_changes where groupname=$1 and action=$2 and usernames=$3 and requested_by=$4;",
+}
+
+var getHeldChangeStmt = map[string]string{
+	"sqlite":   "select id, groupname, action, usernames, requested_by, risk, time_stamp from held_changes where id=?;",
+	"postgres": "select id, groupname, action, usernames, requested_by, risk, time_stamp from held_changes where id=$1;",
+}
+
+var getHeldChangesStmt = "select id, groupname, action, usernames, requested_by, risk, time_stamp from held_changes order by id;"
+
+var deleteHeldChangeStmt = map[string]string{
+	"sqlite":   "delete from held_changes where id=?;",
+	"postgres": "delete from held_changes where id=$1;",
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanHeldChange(row rowScanner) (heldChange, error) {
+	var change heldChange
+	var usernames string
+	var timestamp int64
+	err := row.Scan(&change.ID, &change.Groupname, &change.Action, &usernames, &change.RequestedBy,
+		&change.Risk, &timestamp)
+	if err != nil {
+		return change, err
+	}
+	change.Usernames = strings.Split(usernames, ",")
+	change.Timestamp = time.Unix(timestamp, 0)
+	return change, nil
+}
+
+// insertHeldChange stores the change unless the same one is held already,
+// the API clients retry their changes.
+func insertHeldChange(change heldChange, state *RuntimeState) error {
+	usernames := strings.Join(change.Usernames, ",")
+	start := time.Now()
+	var count int
+	err := state.db.QueryRow(heldChangeExistsStmt[state.dbType], change.Groupname, change.Action, usernames,
+		change.RequestedBy).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = state.db.Exec(insertHeldChangeStmt[state.dbType], change.Groupname, change.Action, usernames,
+		change.RequestedBy, change.Risk, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func getHeldChangeFromDB(id int64, state *RuntimeState) (heldChange, error) {
+	start := time.Now()
+	change, err := scanHeldChange(state.db.QueryRow(getHeldChangeStmt[state.dbType], id))
+	if err != nil {
+		return change, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return change, nil
+}
+
+func getHeldChangesFromDB(state *RuntimeState) ([]heldChange, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getHeldChangesStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var changes []heldChange
+	for rows.Next() {
+		change, err := scanHeldChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return changes, nil
+}
+
+// alertPrivilegeEscalation records the security event and mails the alert
+// recipients.
+func (state *RuntimeState) alertPrivilegeEscalation(r *http.Request, actor string, groupname string,
+	risk string, usernames []string) {
+	recordSecurityEvent(r, securityEventPrivilegeEscalation, "risk", risk, "actor", actor, "group", groupname,
+		"users", strings.Join(usernames, ","))
+	recipients := state.Config.PrivilegeEscalation.AlertRecipients
+	if len(recipients) == 0 {
+		return
+	}
+	body := fmt.Sprintf("%s changed group %s, the users %s, detected as %s from %s.\n", actor, groupname,
+		strings.Join(usernames, ", "), risk, remoteIPFromRequest(r))
+	if state.Config.PrivilegeEscalation.RequireApproval && risk != escalationSelfApproval {
+		body += "The change is held until another admin approves it.\n"
+	}
+	go func() {
+		err := state.sendEmailWithAttachments(recipients, fmt.Sprintf(privilegeEscalationAlertSubj, groupname),
+			body, nil)
+		if err != nil {
+			slog.Error("cannot mail the privilege escalation alert", "group", groupname, "err", err)
+		}
+	}()
+}
+
+// holdPrivilegeEscalation alerts about the escalating changes, it returns
+// true when the change is held for approval rather than applied.
+func (state *RuntimeState) holdPrivilegeEscalation(r *http.Request, actor string, groupname string,
+	added []string, removed []string) (bool, error) {
+	config := state.Config.PrivilegeEscalation
+	if state.isDryRun(r) {
+		return false, nil
+	}
+	risk := config.detect(actor, groupname, added, removed)
+	if risk == "" {
+		return false, nil
+	}
+	action, usernames := heldChangeAddMembers, added
+	if risk == escalationMassRemoval {
+		action, usernames = heldChangeRemoveMembers, removed
+	}
+	state.alertPrivilegeEscalation(r, actor, groupname, risk, usernames)
+	if !config.RequireApproval {
+		return false, nil
+	}
+	err := insertHeldChange(heldChange{Groupname: groupname, Action: action, Usernames: usernames,
+		RequestedBy: actor, Risk: risk}, state)
+	if err != nil {
+		return false, err
+	}
+	state.recordAuditEvent(r, actor, auditActionHoldChange, groupname, strings.Join(usernames, ","),
+		auditOutcomeSuccess, action+": "+risk)
+	return true, nil
+}
+
+// checkSelfApproval returns the message refusing the approval of the own
+// requests to the protected groups, "" when the approval may proceed.
+func (state *RuntimeState) checkSelfApproval(r *http.Request, approver string, groupname string,
+	requestingUsers []string) string {
+	config := state.Config.PrivilegeEscalation
+	if !config.protectedGroup(groupname) {
+		return ""
+	}
+	for _, user := range requestingUsers {
+		if user != approver {
+			continue
+		}
+		state.alertPrivilegeEscalation(r, approver, groupname, escalationSelfApproval, []string{approver})
+		if config.RequireApproval {
+			return fmt.Sprintf("group %s is protected, your request must be approved by another manager",
+				groupname)
+		}
+	}
+	return ""
+}
+
+// applyHeldChange applies the approved change to the directory.
+func (state *RuntimeState) applyHeldChange(r *http.Request, approver string, change heldChange) error {
+	groupinfo := userinfo.GroupInfo{Groupname: change.Groupname, MemberUid: change.Usernames}
+	auditAction := auditActionAddMember
+	var err error
+	if change.Action == heldChangeAddMembers {
+		err = state.requestUserinfo(r).AddmemberstoExisting(groupinfo)
+	} else {
+		auditAction = auditActionRemoveMember
+		err = state.requestUserinfo(r).DeletemembersfromGroup(groupinfo)
+	}
+	outcome, details := auditOutcomeSuccess, "requested by "+change.RequestedBy
+	if err != nil {
+		outcome, details = auditOutcomeFailure, err.Error()
+	}
+	for _, member := range change.Usernames {
+		state.recordAuditEvent(r, approver, auditAction, change.Groupname, member, outcome, details)
+	}
+	if err != nil {
+		return err
+	}
+	if change.Action == heldChangeRemoveMembers {
+		_, err = state.recordMembershipRemovals(change.Groupname, change.Usernames, approver)
+		if err != nil {
+			requestLogger(r).Error("cannot record the membership removals", "err", err)
+		}
+	}
+	return nil
+}
+
+// heldChangesHandler lists the held changes to the super admins and
+// approves or rejects them, nobody approves their own changes.
+func (state *RuntimeState) heldChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod && r.Method != postMethod {
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	pageData := heldChangesPageData{
+		Title:    "Held Changes",
+		IsAdmin:  true,
+		UserName: username,
+	}
+	if r.Method == postMethod {
+		id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+		if err != nil {
+			state.writeFailureResponse(w, r, "invalid change id", http.StatusBadRequest)
+			return
+		}
+		decision := r.PostFormValue("action")
+		if decision != "approve" && decision != "reject" {
+			state.writeFailureResponse(w, r, "action must be approve or reject", http.StatusBadRequest)
+			return
+		}
+		change, err := getHeldChangeFromDB(id, state)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				state.writeFailureResponse(w, r, "held change not found", http.StatusNotFound)
+				return
+			}
+			requestLogger(r).Error("heldChangesHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		if decision == "approve" {
+			if change.RequestedBy == username {
+				state.writeFailureResponse(w, r, "another admin must approve your change", http.StatusForbidden)
+				return
+			}
+			if !state.requireRecentAuthentication(w, r) {
+				return
+			}
+			err = state.applyHeldChange(r, username, change)
+			if err != nil {
+				requestLogger(r).Error("heldChangesHandler failed", "err", err)
+				state.writeFailureResponse(w, r, "cannot apply the change", http.StatusInternalServerError)
+				return
+			}
+			pageData.Message = fmt.Sprintf("The change of group %s was applied", change.Groupname)
+		} else {
+			state.recordAuditEvent(r, username, auditActionRejectHeldChange, change.Groupname,
+				strings.Join(change.Usernames, ","), auditOutcomeSuccess, change.Action+" requested by "+
+					change.RequestedBy)
+			pageData.Message = fmt.Sprintf("The change of group %s was rejected", change.Groupname)
+		}
+		err = execServiceAccountUpdate(state, deleteHeldChangeStmt[state.dbType], change.ID)
+		if err != nil {
+			requestLogger(r).Error("heldChangesHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+	}
+	pageData.Changes, err = getHeldChangesFromDB(state)
+	if err != nil {
+		requestLogger(r).Error("heldChangesHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.renderTemplateOrReturnJson(w, r, "heldChangesPage", pageData)
+}
+
+// writeHeldChangeResponse tells the user the change waits for approval.
+func (state *RuntimeState) writeHeldChangeResponse(w http.ResponseWriter, r *http.Request, username string,
+	groupname string) {
+	pageData := simpleMessagePageData{
+		UserName: username,
+		IsAdmin:  state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:    "Change Held For Approval",
+		SuccessMessage: fmt.Sprintf("The change of group %s may escalate privileges, it is held until another "+
+			"admin approves it", groupname),
+		ContinueURL: groupinfoPath + "?groupname=" + groupname,
+	}
+	w.WriteHeader(http.StatusAccepted)
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestPrivilegeEscalation(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.PrivilegeEscalation = privilegeEscalationConfig{ProtectedGroups: []string{"escalation_admins"},
+		MassRemovalThreshold: 2, RequireApproval: true}
+	err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: "escalation_admins",
+		Description: "group1", MemberUid: []string{"user1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: "escalation_mass",
+		Description: descriptionAttribute, MemberUid: []string{"user1", "user2", "user3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	isMember := func(groupname string, username string) bool {
+		member, _, err := state.Userinfo.IsgroupmemberorNot(groupname, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return member
+	}
+	heldChanges := func() []heldChange {
+		changes, err := getHeldChangesFromDB(&state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changes
+	}
+	decide := func(admin bool, id int64, action string) int {
+		return testPostServiceAccountForm(t, &state, heldChangesPath, state.heldChangesHandler, admin,
+			url.Values{"id": {strconv.FormatInt(id, 10)}, "action": {action}})
+	}
+
+	// user2 manages the group through group1 and adds themselves
+	code := testPostServiceAccountForm(t, &state, addmembersbuttonPath, state.addmemberstoExistingGroup, false,
+		url.Values{"groupname": {"escalation_admins"}, "members": {"user2"}})
+	if code != http.StatusAccepted {
+		t.Fatalf("the self add was not held, got %d", code)
+	}
+	if isMember("escalation_admins", "user2") {
+		t.Fatal("the held self add was applied")
+	}
+	// adding the others is not an escalation
+	code = testPostServiceAccountForm(t, &state, addmembersbuttonPath, state.addmemberstoExistingGroup, false,
+		url.Values{"groupname": {"escalation_admins"}, "members": {"user3"}})
+	if code != http.StatusOK || !isMember("escalation_admins", "user3") {
+		t.Fatalf("adding another user failed with %d", code)
+	}
+	changes := heldChanges()
+	if len(changes) != 1 || changes[0].RequestedBy != testUsername || changes[0].Risk != escalationSelfAddProtected {
+		t.Fatalf("unexpected held changes %+v", changes)
+	}
+	if code := decide(false, changes[0].ID, "approve"); code != http.StatusForbidden {
+		t.Fatalf("only the super admins approve, got %d", code)
+	}
+	if code := decide(true, changes[0].ID, "approve"); code != http.StatusOK {
+		t.Fatalf("the approval failed with %d", code)
+	}
+	if !isMember("escalation_admins", "user2") || len(heldChanges()) != 0 {
+		t.Fatal("the approved change was not applied")
+	}
+
+	// the mass removal of the admin waits for another admin
+	code = testPostServiceAccountForm(t, &state, deletemembersbuttonPath, state.deletemembersfromExistingGroup,
+		true, url.Values{"groupname": {"escalation_mass"}, "members": {"user2,user3"}})
+	if code != http.StatusAccepted {
+		t.Fatalf("the mass removal was not held, got %d", code)
+	}
+	changes = heldChanges()
+	if len(changes) != 1 || changes[0].Action != heldChangeRemoveMembers || len(changes[0].Usernames) != 2 {
+		t.Fatalf("unexpected held changes %+v", changes)
+	}
+	if code := decide(true, changes[0].ID, "approve"); code != http.StatusForbidden {
+		t.Fatalf("the admin approved their own change, got %d", code)
+	}
+	if code := decide(true, changes[0].ID, "reject"); code != http.StatusOK {
+		t.Fatalf("the rejection failed with %d", code)
+	}
+	if !isMember("escalation_mass", "user3") || len(heldChanges()) != 0 {
+		t.Fatal("the rejected change was applied")
+	}
+	if code := decide(true, changes[0].ID, "reject"); code != http.StatusNotFound {
+		t.Fatalf("the rejected change was found again, got %d", code)
+	}
+
+	if (privilegeEscalationConfig{MassRemovalThreshold: -1}).check() == nil {
+		t.Fatal("the negative threshold was accepted")
+	}
+}
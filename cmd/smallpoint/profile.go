@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// profileMembership is a group of the user, Via is empty for the direct
+// memberships.
+type profileMembership struct {
+	Groupname string
+	Via       string
+}
+
+// profileHandler shows the memberships, ownerships, pending requests,
+// service accounts and session of the user in one page.
+func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	entries, err := state.userAccessReport(username)
+	if err != nil {
+		requestLogger(r).Error("profileHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	pageData := profilePageData{
+		UserName:        username,
+		IsAdmin:         state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:           "My Profile",
+		Memberships:     []profileMembership{},
+		OwnedGroups:     []string{},
+		Requests:        []accessRequest{},
+		ServiceAccounts: []serviceAccount{},
+		Session:         state.authenticator.GetSession(r),
+	}
+	memberOf := make(map[string]bool)
+	for _, entry := range entries {
+		switch entry.Role {
+		case accessRoleMember:
+			memberOf[entry.Groupname] = true
+			membership := profileMembership{Groupname: entry.Groupname}
+			if entry.Via != entry.Groupname {
+				membership.Via = entry.Via
+			}
+			pageData.Memberships = append(pageData.Memberships, membership)
+		case accessRoleManager:
+			pageData.OwnedGroups = append(pageData.OwnedGroups, entry.Groupname)
+		}
+	}
+	sort.Strings(pageData.OwnedGroups)
+	requests, err := searchAccessRequestsInDB(accessRequestFilter{Username: username,
+		State: requestStatePending}, state)
+	if err != nil {
+		requestLogger(r).Error("profileHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	pageData.Requests = append(pageData.Requests, requests...)
+	accounts, err := getAllServiceAccountsFromDB(state)
+	if err != nil {
+		requestLogger(r).Error("profileHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	for _, account := range accounts {
+		if memberOf[account.OwnerGroup] {
+			pageData.ServiceAccounts = append(pageData.ServiceAccounts, account)
+		}
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	state.renderTemplateOrReturnJson(w, r, "profilePage", pageData)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+)
+
+func TestProfileHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "profile_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "profile.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = insertRequestInDB("user2", []string{"group3"}, "on call", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = insertServiceAccountInDB(serviceAccount{AccountName: "svc_profile", OwnerGroup: "group1",
+		CreatedAt: time.Now(), ReviewBy: time.Now().Add(time.Hour), Status: serviceAccountStatusActive}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = insertServiceAccountInDB(serviceAccount{AccountName: "svc_other", OwnerGroup: "group3",
+		CreatedAt: time.Now(), ReviewBy: time.Now().Add(time.Hour), Status: serviceAccountStatusActive}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(getMethod, profilePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	state.profileHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s returned %d", profilePath, rr.Code)
+	}
+	var pageData profilePageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memberships := make(map[string]bool)
+	for _, membership := range pageData.Memberships {
+		memberships[membership.Groupname] = true
+	}
+	if !memberships["group1"] || !memberships["group2"] || memberships["group3"] {
+		t.Fatalf("bad memberships %+v", pageData.Memberships)
+	}
+	ownsGroup3 := false
+	for _, groupname := range pageData.OwnedGroups {
+		ownsGroup3 = ownsGroup3 || groupname == "group3"
+	}
+	if !ownsGroup3 {
+		t.Fatalf("bad owned groups %v", pageData.OwnedGroups)
+	}
+	if len(pageData.Requests) != 1 || pageData.Requests[0].Groupname != "group3" {
+		t.Fatalf("bad requests %+v", pageData.Requests)
+	}
+	if len(pageData.ServiceAccounts) != 1 || pageData.ServiceAccounts[0].AccountName != "svc_profile" {
+		t.Fatalf("bad service accounts %+v", pageData.ServiceAccounts)
+	}
+	if pageData.Session == nil || pageData.Session.Username != "user2" ||
+		pageData.Session.Method != authn.SessionMethodCookie || pageData.Session.ExpiresAt.IsZero() {
+		t.Fatalf("bad session %+v", pageData.Session)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+)
+
+// Runaway scripts have hammered the request and authn endpoints. The rate
+// limiter sits in front of every handler, it limits the authn redirects and
+// the requests that change state, per user when the request is authenticated
+// and per IP otherwise.
+
+const (
+	rateLimitClassAuth     = "auth"
+	rateLimitClassMutating = "mutating"
+
+	rateLimitSweepInterval = 5 * time.Minute
+)
+
+type rateLimitClassConfig struct {
+	// RequestsPerMinute is the sustained rate, 0 disables the limit.
+	RequestsPerMinute float64 `yaml:"requests_per_minute"`
+	// Burst is the number of requests allowed at once, it defaults to the
+	// requests per minute.
+	Burst int `yaml:"burst"`
+}
+
+type rateLimitConfig struct {
+	Auth     rateLimitClassConfig `yaml:"auth"`
+	Mutating rateLimitClassConfig `yaml:"mutating"`
+}
+
+func (config rateLimitClassConfig) burst() float64 {
+	if config.Burst > 0 {
+		return float64(config.Burst)
+	}
+	return math.Max(1, config.RequestsPerMinute)
+}
+
+type rateLimitBucket struct {
+	class   string
+	tokens  float64
+	updated time.Time
+}
+
+type rateLimiter struct {
+	classes  map[string]rateLimitClassConfig
+	userName func(r *http.Request) string
+	now      func() time.Time
+
+	mutex     sync.Mutex
+	buckets   map[string]*rateLimitBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(config rateLimitConfig, authenticator *authn.Authenticator) *rateLimiter {
+	limiter := &rateLimiter{
+		classes: map[string]rateLimitClassConfig{
+			rateLimitClassAuth:     config.Auth,
+			rateLimitClassMutating: config.Mutating,
+		},
+		userName: func(r *http.Request) string { return "" },
+		now:      time.Now,
+		buckets:  make(map[string]*rateLimitBucket),
+	}
+	if authenticator != nil {
+		limiter.userName = authenticator.GetVerifiedUserName
+	}
+	return limiter
+}
+
+// requestClass returns the class of the request, or "" when it is not
+// limited.
+func requestClass(r *http.Request) string {
+	if r.URL.Path == authn.Oauth2redirectPath {
+		return rateLimitClassAuth
+	}
+	if r.Method != getMethod && r.Method != http.MethodHead {
+		return rateLimitClassMutating
+	}
+	return ""
+}
+
+// allow takes a token from the bucket of key, when the bucket is empty it
+// returns the time until the next token.
+func (l *rateLimiter) allow(class string, key string) (bool, time.Duration) {
+	config := l.classes[class]
+	rate := config.RequestsPerMinute / float64(time.Minute)
+	burst := config.burst()
+	now := l.now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	bucketKey := class + "\x00" + key
+	bucket, ok := l.buckets[bucketKey]
+	if !ok {
+		bucket = &rateLimitBucket{class: class, tokens: burst, updated: now}
+		l.buckets[bucketKey] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+float64(now.Sub(bucket.updated))*rate)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / rate)
+}
+
+// sweep drops the buckets that have refilled, they are the same as a new
+// bucket.
+func (l *rateLimiter) sweep(now time.Time) {
+	for bucketKey, bucket := range l.buckets {
+		config := l.classes[bucket.class]
+		refill := time.Duration((config.burst() - bucket.tokens) / config.RequestsPerMinute * float64(time.Minute))
+		if now.Sub(bucket.updated) >= refill {
+			delete(l.buckets, bucketKey)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *rateLimiter) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := requestClass(r)
+		if class == "" || l.classes[class].RequestsPerMinute <= 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		key := "ip:" + remoteIPFromRequest(r)
+		if username := l.userName(r); username != "" {
+			key = "user:" + username
+		}
+		ok, retryAfter := l.allow(class, key)
+		if !ok {
+			recordSecurityEvent(r, securityEventRateLimited, "class", class, "key", key)
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := newRateLimiter(rateLimitConfig{
+		Mutating: rateLimitClassConfig{RequestsPerMinute: 60, Burst: 2}}, nil)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.allow(rateLimitClassMutating, "user:user1"); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	ok, retryAfter := limiter.allow(rateLimitClassMutating, "user:user1")
+	if ok {
+		t.Fatal("request over the burst should be limited")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("unexpected retry after %s", retryAfter)
+	}
+	// other users have their own bucket
+	if ok, _ := limiter.allow(rateLimitClassMutating, "user:user2"); !ok {
+		t.Fatal("request of another user should be allowed")
+	}
+	now = now.Add(time.Second)
+	if ok, _ := limiter.allow(rateLimitClassMutating, "user:user1"); !ok {
+		t.Fatal("request after the refill should be allowed")
+	}
+
+	// refilled buckets are dropped
+	now = now.Add(rateLimitSweepInterval + time.Second)
+	limiter.allow(rateLimitClassMutating, "user:user3")
+	if len(limiter.buckets) != 1 {
+		t.Fatalf("expected 1 bucket after the sweep, got %d", len(limiter.buckets))
+	}
+}
+
+func TestRateLimiterHandler(t *testing.T) {
+	limiter := newRateLimiter(rateLimitConfig{
+		Auth:     rateLimitClassConfig{RequestsPerMinute: 1},
+		Mutating: rateLimitClassConfig{RequestsPerMinute: 1}}, nil)
+	limiter.userName = func(r *http.Request) string { return r.Header.Get("X-Test-User") }
+	handler := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(method string, path string, remoteAddr string, username string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		if username != "" {
+			req.Header.Set("X-Test-User", username)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// reads are not limited
+	for i := 0; i < 3; i++ {
+		if rr := serve(getMethod, creategroupWebPagePath, "10.0.0.1:1000", "user1"); rr.Code != http.StatusOK {
+			t.Fatalf("GET returned %d", rr.Code)
+		}
+	}
+	if rr := serve(postMethod, requestaccessPath, "10.0.0.1:1000", "user1"); rr.Code != http.StatusOK {
+		t.Fatalf("first POST returned %d", rr.Code)
+	}
+	rr := serve(postMethod, requestaccessPath, "10.0.0.1:1001", "user1")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("second POST returned %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Fatalf("unexpected Retry-After %q", rr.Header().Get("Retry-After"))
+	}
+	// another user behind the same IP is limited on its own
+	if rr := serve(postMethod, requestaccessPath, "10.0.0.1:1000", "user2"); rr.Code != http.StatusOK {
+		t.Fatalf("POST of another user returned %d", rr.Code)
+	}
+
+	// unauthenticated requests are limited per IP
+	if rr := serve(getMethod, authn.Oauth2redirectPath, "10.0.0.2:1000", ""); rr.Code != http.StatusOK {
+		t.Fatalf("first redirect returned %d", rr.Code)
+	}
+	if rr := serve(getMethod, authn.Oauth2redirectPath, "10.0.0.2:1001", ""); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("second redirect returned %d", rr.Code)
+	}
+	if rr := serve(getMethod, authn.Oauth2redirectPath, "10.0.0.3:1000", ""); rr.Code != http.StatusOK {
+		t.Fatalf("redirect from another IP returned %d", rr.Code)
+	}
+}
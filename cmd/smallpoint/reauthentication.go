@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+)
+
+// The destructive operations, the group deletions and merges, the member
+// removals, the group imports and the ownership changes, require a recent
+// authentication at the OpenID provider when reauthentication.max_age is
+// set, whatever the age of the session. The older sessions are sent to the
+// provider, which prompts the user to log in again, with the second factor
+// of acr_values when set, and the user returns to the form to submit it
+// again. The client certificates of smallpointctl need no reauthentication.
+
+const securityEventReauthenticationRequired = "reauthentication_required"
+
+type reauthenticationConfig struct {
+	// MaxAge is the age of the authentication past which the destructive
+	// operations prompt the user again, 0 disables the prompts.
+	MaxAge time.Duration `yaml:"max_age"`
+	// ACRValues are the authentication classes, e.g. of a second factor,
+	// requested from the provider, the session must have one of them.
+	ACRValues []string `yaml:"acr_values"`
+}
+
+func (config reauthenticationConfig) check() error {
+	if config.MaxAge < 0 {
+		return errors.New("max_age cannot be negative")
+	}
+	if len(config.ACRValues) > 0 && config.MaxAge == 0 {
+		return errors.New("acr_values requires max_age")
+	}
+	return nil
+}
+
+// recentlyAuthenticated reports whether the session authenticated within
+// max_age with one of the required classes.
+func (config reauthenticationConfig) recentlyAuthenticated(session *authn.Session, now time.Time) bool {
+	if session.AuthTime.IsZero() || now.Sub(session.AuthTime) > config.MaxAge {
+		return false
+	}
+	if len(config.ACRValues) == 0 {
+		return true
+	}
+	for _, acr := range config.ACRValues {
+		if session.ACR == acr {
+			return true
+		}
+	}
+	return false
+}
+
+// reauthenticationReturnURL returns the page the request was submitted from,
+// the user submits the form again once authenticated.
+func reauthenticationReturnURL(r *http.Request) string {
+	referer, err := url.Parse(r.Referer())
+	if err != nil || referer.Host != r.Host || referer.Path == "" {
+		return indexPath
+	}
+	return referer.RequestURI()
+}
+
+// requireRecentAuthentication returns true when the destructive request may
+// proceed, otherwise it redirects the user to the provider.
+func (state *RuntimeState) requireRecentAuthentication(w http.ResponseWriter, r *http.Request) bool {
+	config := state.Config.Reauthentication
+	if config.MaxAge == 0 {
+		return true
+	}
+	session := state.authenticator.GetSession(r)
+	if session == nil || session.Method != authn.SessionMethodCookie ||
+		config.recentlyAuthenticated(session, time.Now()) {
+		return true
+	}
+	recordSecurityEvent(r, securityEventReauthenticationRequired, "username", session.Username)
+	reauthenticationURL, err := state.authenticator.ReauthenticationURL(r, reauthenticationReturnURL(r),
+		strings.Join(config.ACRValues, " "))
+	if err != nil {
+		requestLogger(r).Error("requireRecentAuthentication failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return false
+	}
+	http.Redirect(w, r, reauthenticationURL, http.StatusSeeOther)
+	return false
+}
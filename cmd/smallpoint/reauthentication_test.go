@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestReauthentication(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: "reauth-group", Description: "group1",
+		MemberUid: []string{"user2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Reauthentication = reauthenticationConfig{MaxAge: 5 * time.Minute, ACRValues: []string{"mfa"}}
+	deleteGroup := func(authTime time.Time, acr string) *httptest.ResponseRecorder {
+		form := url.Values{"groupnames": {"reauth-group"}}
+		req := httptest.NewRequest(postMethod, deletegroupPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Referer", "https://"+req.Host+"/delete_group")
+		expires := time.Now().Add(time.Hour)
+		cookieValue, err := state.authenticator.GenAuthenticatedCookieValue(adminTestusername, expires,
+			authTime, acr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testAddAuthCookie(req, state.authenticator, http.Cookie{Name: authn.AuthCookieName, Value: cookieValue})
+		rr := httptest.NewRecorder()
+		state.deleteGrouphandler(rr, req)
+		return rr
+	}
+
+	// the old and the single factor authentications are sent to the provider
+	for _, rr := range []*httptest.ResponseRecorder{
+		deleteGroup(time.Now().Add(-time.Hour), "mfa"),
+		deleteGroup(time.Now(), "pwd"),
+	} {
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("the stale session got %d", rr.Code)
+		}
+		location, err := url.Parse(rr.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if location.Query().Get("prompt") != "login" || location.Query().Get("acr_values") != "mfa" {
+			t.Fatalf("bad reauthentication redirect %s", location)
+		}
+	}
+	archive, err := getGroupArchiveFromDB("reauth-group", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive != nil {
+		t.Fatal("the group was archived without a reauthentication")
+	}
+	if rr := deleteGroup(time.Now().Add(-time.Minute), "mfa"); rr.Code != http.StatusOK {
+		t.Fatalf("the reauthenticated session got %d", rr.Code)
+	}
+
+	if reauthenticationReturnURL(httptest.NewRequest(postMethod, deletegroupPath, nil)) != indexPath {
+		t.Fatal("the request without a referer does not return to the index")
+	}
+	if err := (reauthenticationConfig{ACRValues: []string{"mfa"}}).check(); err == nil {
+		t.Fatal("acr_values without max_age was accepted")
+	}
+}
+
+func TestReauthenticationGroupAPI(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Reauthentication = reauthenticationConfig{MaxAge: 5 * time.Minute}
+	putGroup := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, groupsAPIPath+"group1", strings.NewReader(body))
+		cookieValue, err := state.authenticator.GenAuthenticatedCookieValue(adminTestusername,
+			time.Now().Add(time.Hour), time.Now().Add(-time.Hour), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testAddAuthCookie(req, state.authenticator, http.Cookie{Name: authn.AuthCookieName, Value: cookieValue})
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		state.groupsAPIHandler(rr, req)
+		return rr.Code
+	}
+
+	// adding a member needs no recent authentication
+	if code := putGroup(`{"members":["user1","user2","user3"]}`); code != http.StatusOK {
+		t.Fatalf("adding a member got %d", code)
+	}
+	for _, body := range []string{`{"members":[]}`, `{"managed_by":"group2"}`} {
+		if code := putGroup(body); code != http.StatusSeeOther {
+			t.Fatalf("%s from a stale session got %d", body, code)
+		}
+	}
+	members, _, err := state.Userinfo.GetusersofaGroup("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 3 {
+		t.Fatalf("the members were removed without a reauthentication, %v", members)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+const panicFailureMessage = "Something went wrong on our side, please try again later. " +
+	"If the problem persists, report it with the request ID below."
+
+// recoveryHandler recovers the panics of the handlers, logs them with their
+// stack and the request context and renders the error page instead of
+// dropping the connection. Once the handler has started its response the
+// page cannot be rendered, the response is then left truncated.
+func (state *RuntimeState) recoveryHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecordingWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			requestLogger(r).Error("handler panic", "panic", fmt.Sprint(recovered),
+				"stack", string(debug.Stack()))
+			if recorder.status != 0 {
+				return
+			}
+			// drop the headers of the partial response
+			for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding",
+				"Content-Disposition"} {
+				w.Header().Del(key)
+			}
+			state.writeFailureResponse(recorder, r, panicFailureMessage, http.StatusInternalServerError)
+		}()
+		handler.ServeHTTP(recorder, r)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	handler, err := loggingConfig{Format: "json", Level: "info"}.newHandler(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultLogger, logWriter, logFlags := slog.Default(), log.Writer(), log.Flags()
+	slog.SetDefault(slog.New(handler))
+	defer func() {
+		slog.SetDefault(defaultLogger)
+		log.SetOutput(logWriter)
+		log.SetFlags(logFlags)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		panic("cannot happen")
+	})
+	mux.HandleFunc("/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("cannot happen")
+	})
+	serve := func(path, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(getMethod, path, nil)
+		req.Header.Set(requestIDHeader, requestID)
+		req.Header.Set("Accept", "text/html")
+		cookie := testCreateValidCookie(state.authenticator)
+		testAddAuthCookie(req, state.authenticator, cookie)
+		rr := httptest.NewRecorder()
+		state.requestLoggingHandler(state.recoveryHandler(mux), mux).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("/panic", "panic-id")
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status %d", rr.Code)
+	}
+	if strings.Contains(rr.Header().Get("Content-Type"), "octet-stream") {
+		t.Fatalf("the headers of the handler were kept %v", rr.Header())
+	}
+	if !strings.Contains(rr.Body.String(), "panic-id") {
+		t.Fatalf("the request ID is missing from the page %q", rr.Body.String())
+	}
+	records := testRequestRecords(t, &buf, "panic-id")
+	if len(records) != 2 {
+		t.Fatalf("unexpected log %q", buf.String())
+	}
+	if records[0]["msg"] != "handler panic" || records[0]["panic"] != "cannot happen" ||
+		records[0]["user"] != testUsername ||
+		!strings.Contains(records[0]["stack"].(string), "recovery_test.go") {
+		t.Fatalf("bad panic record %v", records[0])
+	}
+	if records[1]["status"] != float64(http.StatusInternalServerError) {
+		t.Fatalf("bad request record %v", records[1])
+	}
+
+	// the started responses are left as they are
+	rr = serve("/partial", "partial-id")
+	if rr.Code != http.StatusOK || rr.Body.String() != "partial" {
+		t.Fatalf("the partial response was changed %d %q", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/redis"
+)
+
+// With several instances, Redis shares the state they keep: the cached group
+// listings and their invalidations, and the secret signing the session
+// cookies when no cluster_shared_secret_filename is configured, so that the
+// sessions survive the restarts and are accepted by every instance.
+
+const (
+	defaultRedisKeyPrefix = "smallpoint:"
+	// the expired listings are served while the directory is unavailable
+	redisStaleListingRetention = time.Hour
+)
+
+type redisConfig struct {
+	redis.Config `yaml:",inline"`
+	// KeyPrefix of the keys, "smallpoint:" by default.
+	KeyPrefix string `yaml:"key_prefix"`
+}
+
+func (config redisConfig) keyPrefix() string {
+	if config.KeyPrefix != "" {
+		return config.KeyPrefix
+	}
+	return defaultRedisKeyPrefix
+}
+
+// getRedisSessionSecret returns the shared secret of the session cookies, the
+// first instance creates it.
+func getRedisSessionSecret(client *redis.Client, keyPrefix string) (string, error) {
+	key := keyPrefix + "session_secret"
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	_, err = client.SetNX(key, base64.StdEncoding.EncodeToString(secret))
+	if err != nil {
+		return "", err
+	}
+	return client.Get(key)
+}
+
+// redisGroupListingStore keeps the listings of each generation under their
+// own keys, an invalidation moves to the next generation and the listings of
+// the previous ones expire.
+type redisGroupListingStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+type redisGroupListingEntry struct {
+	Expiration time.Time  `json:"expiration"`
+	Groups     []string   `json:"groups,omitempty"`
+	Tuples     [][]string `json:"tuples,omitempty"`
+}
+
+func newRedisGroupListingStore(client *redis.Client, keyPrefix string) *redisGroupListingStore {
+	return &redisGroupListingStore{client: client, keyPrefix: keyPrefix + "group_listings:"}
+}
+
+func (s *redisGroupListingStore) entryKey(generation uint64, key string) string {
+	return s.keyPrefix + strconv.FormatUint(generation, 10) + ":" + key
+}
+
+// The errors of Redis are logged and the listings are then read from the
+// directory.
+
+func (s *redisGroupListingStore) get(key string) (groupListingCacheEntry, uint64, bool) {
+	var entry groupListingCacheEntry
+	value, err := s.client.Get(s.keyPrefix + "generation")
+	if err != nil && err != redis.ErrNil {
+		slog.Warn("cannot read the group listing generation from redis", "err", err)
+		return entry, 0, false
+	}
+	var generation uint64
+	if err == nil {
+		generation, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			slog.Warn("bad group listing generation in redis", "err", err)
+			return entry, 0, false
+		}
+	}
+	value, err = s.client.Get(s.entryKey(generation, key))
+	if err != nil {
+		if err != redis.ErrNil {
+			slog.Warn("cannot read the group listing from redis", "err", err)
+		}
+		return entry, generation, false
+	}
+	var stored redisGroupListingEntry
+	err = json.Unmarshal([]byte(value), &stored)
+	if err != nil {
+		slog.Warn("bad group listing in redis", "err", err)
+		return entry, generation, false
+	}
+	entry = groupListingCacheEntry{expiration: stored.Expiration, groups: stored.Groups, tuples: stored.Tuples}
+	return entry, generation, entry.expiration.After(time.Now())
+}
+
+func (s *redisGroupListingStore) put(key string, generation uint64, entry groupListingCacheEntry) {
+	value, err := json.Marshal(redisGroupListingEntry{Expiration: entry.expiration, Groups: entry.groups,
+		Tuples: entry.tuples})
+	if err != nil {
+		slog.Warn("cannot encode the group listing", "err", err)
+		return
+	}
+	err = s.client.Set(s.entryKey(generation, key), string(value),
+		time.Until(entry.expiration)+redisStaleListingRetention)
+	if err != nil {
+		slog.Warn("cannot write the group listing to redis", "err", err)
+	}
+}
+
+func (s *redisGroupListingStore) invalidate() {
+	_, err := s.client.Incr(s.keyPrefix + "generation")
+	if err != nil {
+		slog.Error("cannot invalidate the group listings in redis", "err", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/redis"
+	"github.com/Symantec/ldap-group-management/lib/redis/redistest"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/mock"
+)
+
+func TestRedisSharedState(t *testing.T) {
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.New(redis.Config{Address: server.Addr})
+	defer client.Close()
+
+	secret, err := getRedisSessionSecret(client, defaultRedisKeyPrefix)
+	if err != nil || len(secret) < 32 {
+		t.Fatalf("bad session secret %q err: %v", secret, err)
+	}
+	otherSecret, err := getRedisSessionSecret(client, defaultRedisKeyPrefix)
+	if err != nil || otherSecret != secret {
+		t.Fatalf("the instances have different secrets, err: %v", err)
+	}
+
+	// two instances with the same directory
+	directory := mock.New()
+	var instances []*cachedUserInfo
+	var sources []*countingUserInfo
+	for i := 0; i < 2; i++ {
+		source := &countingUserInfo{UserInfoBackend: directory}
+		cached := newCachedUserInfo(source, time.Hour)
+		cached.store = newRedisGroupListingStore(client, defaultRedisKeyPrefix)
+		instances = append(instances, cached)
+		sources = append(sources, source)
+	}
+	for _, cached := range instances {
+		_, err := cached.GetallGroups()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sources[0].allGroupsCalls != 1 || sources[1].allGroupsCalls != 0 {
+		t.Fatalf("the listing is not shared, loaded %d and %d times", sources[0].allGroupsCalls,
+			sources[1].allGroupsCalls)
+	}
+	err = instances[1].CreateGroup(userinfo.GroupInfo{Groupname: "redis-new", Description: descriptionAttribute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := instances[0].GetallGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, group := range groups {
+		found = found || group == "redis-new"
+	}
+	if !found || sources[0].allGroupsCalls != 2 {
+		t.Fatalf("the write of the other instance was not seen, %v", groups)
+	}
+
+	// the listings are read from the directory while redis is down
+	server.Close()
+	client.Close()
+	_, err = instances[0].GetallGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sources[0].allGroupsCalls != 3 {
+		t.Fatalf("the listing was loaded %d times", sources[0].allGroupsCalls)
+	}
+}
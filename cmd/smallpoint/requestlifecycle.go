@@ -0,0 +1,303 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// Access requests keep their whole lifecycle. The pending_requests table is
+// the queue of the open requests the pages work from, access_requests keeps
+// every request with who made it and why, and how it was decided. A request
+// leaves the queue in the same transaction that records its decision, a
+// request is pending until then and its decision is final.
+
+const (
+	requestStatePending   = "pending"
+	requestStateApproved  = "approved"
+	requestStateDenied    = "denied"
+	requestStateCancelled = "cancelled"
+	requestStateExpired   = "expired"
+)
+
+var requestStates = map[string]bool{requestStatePending: true, requestStateApproved: true,
+	requestStateDenied: true, requestStateCancelled: true, requestStateExpired: true}
+
+type accessRequest struct {
+	ID            int64
+	Username      string
+	Groupname     string
+	RequestedBy   string
+	Justification string
+	State         string
+	CreatedAt     time.Time
+	// The decision fields are empty while the request is pending.
+	DecidedBy       string
+	DecidedAt       time.Time
+	DecisionComment string
+	// TicketID is the ticket of the request in the ticketing system, empty
+	// without one.
+	TicketID string
+}
+
+// requestDecision is the body of the approve and reject requests, the
+// comment is recorded with the decision of every request.
+type requestDecision struct {
+	Groups  [][]string `json:"groups"`
+	Comment string     `json:"comment"`
+}
+
+// validPairs returns whether every entry is a username and group pair.
+func (d requestDecision) validPairs() bool {
+	for _, entry := range d.Groups {
+		if len(entry) != 2 {
+			return false
+		}
+	}
+	return true
+}
+
+var insertAccessRequestStmt = map[string]string{
+	"sqlite":   "insert into access_requests(username, groupname, requested_by, justification, state, created_at, decided_by, decided_at, decision_comment) values (?,?,?,?,'pending',?,'',0,'');",
+	"postgres": "insert into access_requests(username, groupname, requested_by, justification, state, created_at, decided_by, decided_at, decision_comment) values ($1,$2,$3,$4,'pending',$5,'',0,'');",
+}
+
+var closeAccessRequestStmt = map[string]string{
+	"sqlite":   "update access_requests set state=?, decided_by=?, decided_at=?, decision_comment=? where username=? and groupname=? and state='pending';",
+	"postgres": "update access_requests set state=$1, decided_by=$2, decided_at=$3, decision_comment=$4 where username=$5 and groupname=$6 and state='pending';",
+}
+
+var closeAccessRequestsOfGroupStmt = map[string]string{
+	"sqlite":   "update access_requests set state=?, decided_by=?, decided_at=?, decision_comment=? where groupname=? and state='pending';",
+	"postgres": "update access_requests set state=$1, decided_by=$2, decided_at=$3, decision_comment=$4 where groupname=$5 and state='pending';",
+}
+
+var retargetAccessRequestStmt = map[string]string{
+	"sqlite":   "update access_requests set groupname=? where username=? and groupname=? and state='pending';",
+	"postgres": "update access_requests set groupname=$1 where username=$2 and groupname=$3 and state='pending';",
+}
+
+// closeRequestInDB removes the request from the queue and records its
+// decision.
+func closeRequestInDB(username string, groupname string, newState string, actor string, comment string,
+	state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(deleteEntryStmt[state.dbType], username, groupname)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(closeAccessRequestStmt[state.dbType], newState, actor, time.Now().Unix(), comment,
+		username, groupname)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// expireRequestsOfGroupsInDB closes every open request to join the groups.
+func expireRequestsOfGroupsInDB(groupnames []string, actor string, comment string, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	for _, groupname := range groupnames {
+		_, err = tx.Exec(deleteEntryofGroupsStmt[state.dbType], groupname)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(closeAccessRequestsOfGroupStmt[state.dbType], requestStateExpired, actor, now, comment,
+			groupname)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// accessRequestFilter selects the requests, empty fields match every
+// request.
+type accessRequestFilter struct {
+	Username  string
+	Groupname string
+	State     string
+}
+
+func (filter accessRequestFilter) sqlWhereClause(dbType string) (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	addClause := func(column string, value interface{}) {
+		args = append(args, value)
+		if dbType == "postgres" {
+			clauses = append(clauses, fmt.Sprintf("%s=$%d", column, len(args)))
+			return
+		}
+		clauses = append(clauses, column+"=?")
+	}
+	if filter.Username != "" {
+		addClause("username", filter.Username)
+	}
+	if filter.Groupname != "" {
+		addClause("groupname", filter.Groupname)
+	}
+	if filter.State != "" {
+		addClause("state", filter.State)
+	}
+	if len(clauses) == 0 {
+		return "", args
+	}
+	return " where " + strings.Join(clauses, " and "), args
+}
+
+const accessRequestColumns = "id, username, groupname, requested_by, justification, state, created_at, " +
+	"decided_by, decided_at, decision_comment, ticket_id"
+
+var getAccessRequestStmt = map[string]string{
+	"sqlite":   "select " + accessRequestColumns + " from access_requests where id=?;",
+	"postgres": "select " + accessRequestColumns + " from access_requests where id=$1;",
+}
+
+func scanAccessRequest(row sqlRowScanner) (accessRequest, error) {
+	var request accessRequest
+	var createdAt, decidedAt int64
+	err := row.Scan(&request.ID, &request.Username, &request.Groupname, &request.RequestedBy,
+		&request.Justification, &request.State, &createdAt, &request.DecidedBy, &decidedAt,
+		&request.DecisionComment, &request.TicketID)
+	request.CreatedAt = time.Unix(createdAt, 0)
+	if decidedAt != 0 {
+		request.DecidedAt = time.Unix(decidedAt, 0)
+	}
+	return request, err
+}
+
+// getAccessRequestFromDB returns nil when there is no such request.
+func getAccessRequestFromDB(id int64, state *RuntimeState) (*accessRequest, error) {
+	start := time.Now()
+	request, err := scanAccessRequest(state.db.QueryRow(getAccessRequestStmt[state.dbType], id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return &request, nil
+}
+
+// searchAccessRequestsInDB returns the requests, the oldest first.
+func searchAccessRequestsInDB(filter accessRequestFilter, state *RuntimeState) ([]accessRequest, error) {
+	start := time.Now()
+	whereClause, args := filter.sqlWhereClause(state.dbType)
+	rows, err := state.db.Query("select "+accessRequestColumns+" from access_requests"+whereClause+" order by id;",
+		args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var requests []accessRequest
+	for rows.Next() {
+		request, err := scanAccessRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+type accessRequestsAPIResponse struct {
+	Requests []accessRequest
+}
+
+// accessRequestsAPIHandler lists the access requests. Users see their own
+// requests, the admins of a group the requests to join it and the auditors
+// every request.
+func (state *RuntimeState) accessRequestsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	q := r.URL.Query()
+	filter := accessRequestFilter{
+		Username:  strings.TrimSpace(q.Get("user")),
+		Groupname: strings.TrimSpace(q.Get("group")),
+		State:     q.Get("state"),
+	}
+	if filter.State != "" && !requestStates[filter.State] {
+		state.writeFailureResponse(w, r, fmt.Sprintf("invalid state '%s'", filter.State), http.StatusBadRequest)
+		return
+	}
+	if filter.Username == "" && filter.Groupname == "" {
+		filter.Username = username
+	}
+	if filter.Username != username {
+		isAuditor, err := state.isAuditor(username)
+		if err != nil {
+			requestLogger(r).Error("accessRequestsAPIHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		allowed := isAuditor
+		if !allowed && filter.Groupname != "" {
+			allowed, err = state.requestUserinfo(r).IsgroupAdminorNot(username, filter.Groupname)
+			if err != nil {
+				requestLogger(r).Error("accessRequestsAPIHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if !allowed {
+			http.Error(w, "you are not authorized", http.StatusForbidden)
+			return
+		}
+	}
+	requests, err := searchAccessRequestsInDB(filter, state)
+	if err != nil {
+		requestLogger(r).Error("accessRequestsAPIHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	response := accessRequestsAPIResponse{Requests: []accessRequest{}}
+	response.Requests = append(response.Requests, requests...)
+	b, err := json.Marshal(response)
+	if err != nil {
+		requestLogger(r).Error("Failed marshal", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(b)
+	if err != nil {
+		requestLogger(r).Error("accessRequestsAPIHandler failed", "err", err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func testDecideRequest(t *testing.T, state *RuntimeState, handler http.HandlerFunc, path string,
+	decision requestDecision) {
+	body, err := json.Marshal(decision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s returned %d: %s", path, rr.Code, rr.Body.String())
+	}
+}
+
+func testGetAccessRequestsAPI(t *testing.T, state *RuntimeState, query string) (int, []accessRequest) {
+	req, err := http.NewRequest("GET", accessRequestsAPIPath+"?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.accessRequestsAPIHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		return rr.Code, nil
+	}
+	var response accessRequestsAPIResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, response.Requests
+}
+
+func TestAccessRequestLifecycle(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pending requests are cleaned up in the background
+	useSynchronizedDirectory(&state)
+	dir, err := ioutil.TempDir("", "requests_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "requests.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smtpClient = func(addr string) (smtpDialer, error) {
+		return &smtpDialerMock{}, nil
+	}
+
+	err = insertRequestInDB("user2", []string{"group3"}, "on call rotation", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := searchAccessRequestsInDB(accessRequestFilter{State: requestStatePending}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].RequestedBy != "user2" || pending[0].Justification != "on call rotation" {
+		t.Fatalf("unexpected pending requests %+v", pending)
+	}
+	actions, err := state.getUserPendingActionsNonCached("user2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || len(actions[0]) != 4 || actions[0][3] != "on call rotation" {
+		t.Fatalf("unexpected pending actions %v", actions)
+	}
+	testDecideRequest(t, &state, state.rejectHandler, rejectrequestPath,
+		requestDecision{Groups: [][]string{{"user2", "group3"}}, Comment: "not needed"})
+
+	err = insertRequestInDB("user2", []string{"group3"}, "new project", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDecideRequest(t, &state, state.approveHandler, approverequestPath,
+		requestDecision{Groups: [][]string{{"user2", "group3"}}, Comment: "welcome"})
+
+	requests, err := searchAccessRequestsInDB(accessRequestFilter{Username: "user2"}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	if requests[0].State != requestStateDenied || requests[0].DecidedBy != "user2" ||
+		requests[0].DecisionComment != "not needed" || requests[0].DecidedAt.IsZero() {
+		t.Fatalf("unexpected denied request %+v", requests[0])
+	}
+	if requests[1].State != requestStateApproved || requests[1].Justification != "new project" ||
+		requests[1].DecisionComment != "welcome" {
+		t.Fatalf("unexpected approved request %+v", requests[1])
+	}
+	if entryExistsorNot("user2", "group3", &state) {
+		t.Fatal("the approved request is still pending")
+	}
+
+	err = state.Userinfo.CreateGroup(userinfo.GroupInfo{Groupname: "requests-archived", Description: "group1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = insertRequestInDB("user2", []string{"requests-archived"}, "", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = expireRequestsOfGroupsInDB([]string{"requests-archived"}, "user1", "the group was archived", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err = searchAccessRequestsInDB(accessRequestFilter{Groupname: "requests-archived"}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].State != requestStateExpired ||
+		entryExistsorNot("user2", "requests-archived", &state) {
+		t.Fatalf("the request was not expired %+v", requests)
+	}
+
+	queries := []struct {
+		query    string
+		code     int
+		expected int
+	}{
+		{"", http.StatusOK, 3},
+		{"state=approved", http.StatusOK, 1},
+		{"group=group3", http.StatusOK, 2},
+		{"user=user1", http.StatusForbidden, 0},
+		{"state=gone", http.StatusBadRequest, 0},
+	}
+	for _, test := range queries {
+		code, requests := testGetAccessRequestsAPI(t, &state, test.query)
+		if code != test.code || len(requests) != test.expected {
+			t.Errorf("query '%s' returned %d %+v", test.query, code, requests)
+		}
+	}
+}
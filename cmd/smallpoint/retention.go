@@ -0,0 +1,334 @@
+package main
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+const retentionCheckInterval = 6 * time.Hour
+
+// Completed requests are removed from pending_requests as soon as they are
+// approved, rejected or cancelled, their record is kept in the audit log. So
+// retention applies to the audit log and to requests left open for too long.
+type retentionConfig struct {
+	// Audit entries older than AuditDays are archived and pruned, 0 keeps
+	// them forever.
+	AuditDays int `yaml:"audit_days"`
+	// Requests nobody acted upon for PendingRequestDays are archived and
+	// pruned, 0 keeps them forever.
+	PendingRequestDays int `yaml:"pending_request_days"`
+	// Archives are written here as gzipped JSON lines before pruning.
+	ArchiveDirectory string `yaml:"archive_directory"`
+}
+
+func (config retentionConfig) enabled() bool {
+	return config.AuditDays > 0 || config.PendingRequestDays > 0
+}
+
+func validateRetentionConfig(config retentionConfig) error {
+	if config.AuditDays < 0 || config.PendingRequestDays < 0 {
+		return errors.New("retention days cannot be negative")
+	}
+	if config.ArchiveDirectory == "" {
+		return errors.New("retention requires an archive_directory")
+	}
+	fileInfo, err := os.Stat(config.ArchiveDirectory)
+	if err != nil {
+		return err
+	}
+	if !fileInfo.IsDir() {
+		return fmt.Errorf("retention archive %s is not a directory", config.ArchiveDirectory)
+	}
+	return nil
+}
+
+// retentionArchive writes one JSON document per line into a gzip file. The
+// file only gets its final name once it has been completely written.
+type retentionArchive struct {
+	filename   string
+	file       *os.File
+	gzipWriter *gzip.Writer
+	encoder    *json.Encoder
+	entries    int
+}
+
+func newRetentionArchive(directory string, prefix string, now time.Time) (*retentionArchive, error) {
+	filename := filepath.Join(directory, fmt.Sprintf("%s_%s.jsonl.gz", prefix, now.UTC().Format("20060102T150405Z")))
+	file, err := os.OpenFile(filename+".tmp", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return nil, err
+	}
+	gzipWriter := gzip.NewWriter(file)
+	return &retentionArchive{filename: filename, file: file, gzipWriter: gzipWriter,
+		encoder: json.NewEncoder(gzipWriter)}, nil
+}
+
+func (archive *retentionArchive) Write(entry interface{}) error {
+	archive.entries++
+	return archive.encoder.Encode(entry)
+}
+
+// Commit flushes the archive to stable storage, entries must not be pruned
+// unless Commit succeeds.
+func (archive *retentionArchive) Commit() error {
+	err := archive.gzipWriter.Close()
+	if err != nil {
+		archive.Abort()
+		return err
+	}
+	err = archive.file.Sync()
+	if err != nil {
+		archive.Abort()
+		return err
+	}
+	err = archive.file.Close()
+	if err != nil {
+		os.Remove(archive.file.Name())
+		return err
+	}
+	return os.Rename(archive.file.Name(), archive.filename)
+}
+
+func (archive *retentionArchive) Abort() {
+	archive.file.Close()
+	os.Remove(archive.file.Name())
+}
+
+type archivedAuditEntry struct {
+	auditEvent
+	PrevHash  string `json:"prev_hash,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+var maxAuditIDBeforeStmt = map[string]string{
+	"sqlite":   "select max(id) from audit_log where time_stamp < ?;",
+	"postgres": "select max(id) from audit_log where time_stamp < $1;",
+}
+
+var archiveAuditEntriesStmt = map[string]string{
+	"sqlite": "select a.id, a.time_stamp, a.actor, a.action, a.groupname, a.username, a.remote_addr, a.outcome, a.details, c.prev_hash, c.hash, c.signature " +
+		"from audit_log a left join audit_chain c on a.id = c.audit_id where a.id <= ? order by a.id;",
+	"postgres": "select a.id, a.time_stamp, a.actor, a.action, a.groupname, a.username, a.remote_addr, a.outcome, a.details, c.prev_hash, c.hash, c.signature " +
+		"from audit_log a left join audit_chain c on a.id = c.audit_id where a.id <= $1 order by a.id;",
+}
+
+var pruneAuditStmts = map[string][]string{
+	"sqlite": {"delete from audit_chain where audit_id <= ?;",
+		"delete from audit_log where id <= ?;"},
+	"postgres": {"delete from audit_chain where audit_id <= $1;",
+		"delete from audit_log where id <= $1;"},
+}
+
+var insertAuditRetentionStmt = map[string]string{
+	"sqlite":   "insert into audit_retention(pruned_through_id, last_hash, time_stamp, archive_file) values (?,?,?,?);",
+	"postgres": "insert into audit_retention(pruned_through_id, last_hash, time_stamp, archive_file) values ($1,$2,$3,$4);",
+}
+
+// lastPrunedAuditChainHash is where the live audit chain continues from
+// after older entries were pruned.
+const lastPrunedAuditChainHashStmt = "select last_hash from audit_retention order by pruned_through_id desc limit 1;"
+
+type sqlQueryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func lastPrunedAuditChainHash(db sqlQueryRower) (string, error) {
+	var lastHash string
+	err := db.QueryRow(lastPrunedAuditChainHashStmt).Scan(&lastHash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return lastHash, err
+}
+
+// archiveAndPruneAudit moves every audit entry recorded before cutoff into an
+// archive. The hash of the last pruned entry is kept so that the remaining
+// chain can still be verified.
+func (state *RuntimeState) archiveAndPruneAudit(cutoff time.Time) error {
+	state.auditChainMutex.Lock()
+	defer state.auditChainMutex.Unlock()
+	var maxID sql.NullInt64
+	err := state.db.QueryRow(maxAuditIDBeforeStmt[state.dbType], cutoff.Unix()).Scan(&maxID)
+	if err != nil {
+		return err
+	}
+	if !maxID.Valid {
+		return nil
+	}
+	archive, err := newRetentionArchive(state.Config.Retention.ArchiveDirectory, "audit_archive", time.Now())
+	if err != nil {
+		return err
+	}
+	lastHash, err := state.writeAuditArchive(archive, maxID.Int64)
+	if err != nil {
+		archive.Abort()
+		return err
+	}
+	err = archive.Commit()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmtText := range pruneAuditStmts[state.dbType] {
+		_, err = tx.Exec(stmtText, maxID.Int64)
+		if err != nil {
+			return err
+		}
+	}
+	if lastHash == "" {
+		// only legacy unchained entries were pruned
+		lastHash, err = lastPrunedAuditChainHash(tx)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(insertAuditRetentionStmt[state.dbType], maxID.Int64, lastHash,
+		time.Now().Unix(), filepath.Base(archive.filename))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	slog.Info("retention: archived audit entries", "count", archive.entries, "file", archive.filename)
+	return nil
+}
+
+// writeAuditArchive returns the chain hash of the last archived entry.
+func (state *RuntimeState) writeAuditArchive(archive *retentionArchive, maxID int64) (string, error) {
+	rows, err := state.db.Query(archiveAuditEntriesStmt[state.dbType], maxID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	lastHash := ""
+	for rows.Next() {
+		var entry archivedAuditEntry
+		var timeStamp int64
+		var prevHash, hash, signature sql.NullString
+		err = rows.Scan(&entry.ID, &timeStamp, &entry.Actor, &entry.Action, &entry.Groupname,
+			&entry.Username, &entry.RemoteAddr, &entry.Outcome, &entry.Details,
+			&prevHash, &hash, &signature)
+		if err != nil {
+			return "", err
+		}
+		entry.Timestamp = time.Unix(timeStamp, 0)
+		entry.PrevHash, entry.Hash, entry.Signature = prevHash.String, hash.String, signature.String
+		if hash.Valid {
+			lastHash = hash.String
+		}
+		err = archive.Write(entry)
+		if err != nil {
+			return "", err
+		}
+	}
+	return lastHash, rows.Err()
+}
+
+type archivedPendingRequest struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"user"`
+	Groupname string    `json:"group"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var getStalePendingRequestsStmt = map[string]string{
+	"sqlite":   "select id, username, groupname, time_stamp from pending_requests where time_stamp < ?;",
+	"postgres": "select id, username, groupname, time_stamp from pending_requests where time_stamp < $1;",
+}
+
+var deletePendingRequestByIDStmt = map[string]string{
+	"sqlite":   "delete from pending_requests where id = ?;",
+	"postgres": "delete from pending_requests where id = $1;",
+}
+
+func (state *RuntimeState) archiveAndPrunePendingRequests(cutoff time.Time) error {
+	rows, err := state.db.Query(getStalePendingRequestsStmt[state.dbType], cutoff.Unix())
+	if err != nil {
+		return err
+	}
+	var requests []archivedPendingRequest
+	for rows.Next() {
+		var request archivedPendingRequest
+		var timeStamp int64
+		err = rows.Scan(&request.ID, &request.Username, &request.Groupname, &timeStamp)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		request.Timestamp = time.Unix(timeStamp, 0)
+		requests = append(requests, request)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	archive, err := newRetentionArchive(state.Config.Retention.ArchiveDirectory, "pending_requests_archive", time.Now())
+	if err != nil {
+		return err
+	}
+	for _, request := range requests {
+		err = archive.Write(request)
+		if err != nil {
+			archive.Abort()
+			return err
+		}
+	}
+	err = archive.Commit()
+	if err != nil {
+		return err
+	}
+	for _, request := range requests {
+		_, err = state.db.Exec(deletePendingRequestByIDStmt[state.dbType], request.ID)
+		if err != nil {
+			return err
+		}
+		_, err = state.db.Exec(closeAccessRequestStmt[state.dbType], requestStateExpired, "smallpoint",
+			time.Now().Unix(), "request older than retention period", request.Username, request.Groupname)
+		if err != nil {
+			return err
+		}
+		state.recordAuditEvent(nil, "smallpoint", auditActionExpireRequest, request.Groupname,
+			request.Username, auditOutcomeSuccess, "request older than retention period")
+	}
+	slog.Info("retention: archived pending requests", "count", len(requests), "file", archive.filename)
+	return nil
+}
+
+func (state *RuntimeState) runRetention() error {
+	config := state.Config.Retention
+	now := time.Now()
+	if config.PendingRequestDays > 0 {
+		err := state.archiveAndPrunePendingRequests(now.AddDate(0, 0, -config.PendingRequestDays))
+		if err != nil {
+			return err
+		}
+	}
+	if config.AuditDays > 0 {
+		err := state.archiveAndPruneAudit(now.AddDate(0, 0, -config.AuditDays))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionArchivesAndPrunes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "retention.db")
+	state.Config.Retention = retentionConfig{AuditDays: 30, PendingRequestDays: 60, ArchiveDirectory: dir}
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().AddDate(0, 0, -90)
+	for _, groupname := range []string{"old1", "old2"} {
+		err = insertAuditEventInDB(auditEvent{Timestamp: old, Actor: "user1", Action: auditActionCreateGroup,
+			Groupname: groupname, Outcome: auditOutcomeSuccess}, &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = state.recordAuditEvent(nil, "user1", auditActionCreateGroup, "new1", "", auditOutcomeSuccess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("insert into pending_requests(username, groupname, time_stamp) values (?,?,?);",
+		"user2", "group1", old.Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = state.runRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var remaining int
+	err = state.db.QueryRow("select count(*) from audit_log where groupname like 'old%';").Scan(&remaining)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Fatalf("old audit entries not pruned, %d left", remaining)
+	}
+	err = state.db.QueryRow("select count(*) from pending_requests;").Scan(&remaining)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Fatalf("stale requests not pruned, %d left", remaining)
+	}
+	archives, err := filepath.Glob(filepath.Join(dir, "audit_archive_*.jsonl.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 {
+		t.Fatalf("expected one audit archive got %v", archives)
+	}
+	file, err := os.Open(archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivedLines := 0
+	scanner := bufio.NewScanner(gzipReader)
+	for scanner.Scan() {
+		archivedLines++
+	}
+	if archivedLines != 2 {
+		t.Fatalf("expected 2 archived entries got %d", archivedLines)
+	}
+
+	// the chain must still verify after pruning and keep growing
+	err = state.recordAuditEvent(nil, "user1", auditActionDeleteGroup, "new1", "", auditOutcomeSuccess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifyAuditChain(&state, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The SCIM 2.0 endpoints (RFC 7643 and RFC 7644) serve the users and the
+// groups of the target directory to the provisioning of the SaaS apps and
+// of the identity providers. The ids of the resources are the user and
+// group names. The membership of the groups is changed with PATCH requests,
+// the users and the groups are not created or deleted through SCIM.
+//
+// The SCIM clients authenticate with a bearer token of the tokens file and
+// act as admins. The users authenticated by a client certificate may also
+// change the groups they manage, the cookie sessions are only allowed to
+// read.
+
+const (
+	scimContentType                 = "application/scim+json"
+	scimUserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimDefaultCount                = 100
+	scimMaxCount                    = 200
+	scimAuditDetails                = "scim"
+)
+
+type scimConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// TokensFilename is the file of the bearer tokens of the SCIM clients,
+	// a NAME:TOKEN line per client.
+	TokensFilename string `yaml:"tokens_filename"`
+}
+
+// loadSCIMTokens returns the client names by the SHA-256 of their tokens.
+func loadSCIMTokens(filename string) (map[[sha256.Size]byte]string, error) {
+	tokens := make(map[[sha256.Size]byte]string)
+	if filename == "" {
+		return tokens, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 || fields[0] == "" || len(fields[1]) < 16 {
+			return nil, fmt.Errorf("%s:%d: expected NAME:TOKEN with a token of 16 characters or more",
+				filename, lineNumber)
+		}
+		tokens[sha256.Sum256([]byte(fields[1]))] = fields[0]
+	}
+	return tokens, scanner.Err()
+}
+
+type scimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+type scimMember struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimUser struct {
+	Schemas  []string     `json:"schemas"`
+	ID       string       `json:"id"`
+	UserName string       `json:"userName"`
+	Active   bool         `json:"active"`
+	Emails   []scimEmail  `json:"emails,omitempty"`
+	Groups   []scimMember `json:"groups,omitempty"`
+	Meta     scimMeta     `json:"meta"`
+}
+
+type scimGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	DisplayName string       `json:"displayName"`
+	Members     []scimMember `json:"members,omitempty"`
+	Meta        scimMeta     `json:"meta"`
+}
+
+type scimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type scimErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+	Status   string   `json:"status"`
+}
+
+type scimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+// scimError is an error returned to the SCIM client.
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (err *scimError) Error() string {
+	return err.detail
+}
+
+func newSCIMError(status int, scimType string, format string, args ...interface{}) *scimError {
+	return &scimError{status: status, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+func writeSCIMResponse(w http.ResponseWriter, r *http.Request, status int, response interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		requestLogger(r).Error("cannot write the SCIM response", "err", err)
+	}
+}
+
+// writeSCIMError writes the error, the errors not meant for the client are
+// logged and answered with a 500.
+func writeSCIMError(w http.ResponseWriter, r *http.Request, err error) {
+	var clientErr *scimError
+	if !errors.As(err, &clientErr) {
+		requestLogger(r).Error("SCIM request failed", "err", err)
+		clientErr = newSCIMError(http.StatusInternalServerError, "", "error")
+	}
+	writeSCIMResponse(w, r, clientErr.status, scimErrorResponse{
+		Schemas:  []string{scimErrorSchema},
+		ScimType: clientErr.scimType,
+		Detail:   clientErr.detail,
+		Status:   strconv.Itoa(clientErr.status),
+	})
+}
+
+// scimCaller is the authenticated caller of a SCIM request.
+type scimCaller struct {
+	actor string
+	// client is set for the SCIM clients of the tokens file.
+	client bool
+}
+
+func (state *RuntimeState) authenticateSCIM(r *http.Request) (scimCaller, error) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		name, ok := state.scimTokens[sha256.Sum256([]byte(token))]
+		if token == authorization || !ok {
+			state.authFailures.recordFailure(r, "invalid_scim_token")
+			return scimCaller{}, newSCIMError(http.StatusUnauthorized, "", "invalid bearer token")
+		}
+		return scimCaller{actor: "scim:" + name, client: true}, nil
+	}
+	session := state.authenticator.GetSession(r)
+	if session == nil {
+		return scimCaller{}, newSCIMError(http.StatusUnauthorized, "", "authentication required")
+	}
+	if r.Method != getMethod && session.Method != authn.SessionMethodCertificate {
+		return scimCaller{}, newSCIMError(http.StatusUnauthorized, "",
+			"changes require a bearer token or a client certificate")
+	}
+	return scimCaller{actor: session.Username}, nil
+}
+
+func scimLocation(r *http.Request, resourceType string, id string) string {
+	return "https://" + r.Host + scimPath + resourceType + "/" + url.PathEscape(id)
+}
+
+var scimFilterPattern = regexp.MustCompile(`(?i)^\s*([a-z.]+)\s+eq\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// parseSCIMFilter returns the value of an `attribute eq "value"` filter,
+// the only filter supported, the attribute is matched case insensitively.
+func parseSCIMFilter(filter string, attribute string) (string, bool, error) {
+	if filter == "" {
+		return "", false, nil
+	}
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil || !strings.EqualFold(match[1], attribute) {
+		return "", false, newSCIMError(http.StatusBadRequest, "invalidFilter",
+			"only %s eq \"value\" filters are supported", attribute)
+	}
+	value, err := strconv.Unquote(match[2])
+	if err != nil {
+		return "", false, newSCIMError(http.StatusBadRequest, "invalidFilter", "invalid filter value %s", match[2])
+	}
+	return value, true, nil
+}
+
+// scimPage returns the page of the names selected by the query.
+func scimPage(names []string, query url.Values, attribute string) ([]string, int, int, error) {
+	startIndex, count := 1, scimDefaultCount
+	var err error
+	if value := query.Get("startIndex"); value != "" {
+		startIndex, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, 0, 0, newSCIMError(http.StatusBadRequest, "invalidValue", "invalid startIndex")
+		}
+		// less than 1 is interpreted as 1
+		if startIndex < 1 {
+			startIndex = 1
+		}
+	}
+	if value := query.Get("count"); value != "" {
+		count, err = strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return nil, 0, 0, newSCIMError(http.StatusBadRequest, "invalidValue", "invalid count")
+		}
+		if count > scimMaxCount {
+			count = scimMaxCount
+		}
+	}
+	value, filtered, err := parseSCIMFilter(query.Get("filter"), attribute)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var selected []string
+	for _, name := range names {
+		if !filtered || name == value {
+			selected = append(selected, name)
+		}
+	}
+	sort.Strings(selected)
+	total := len(selected)
+	if startIndex > total {
+		return nil, total, startIndex, nil
+	}
+	end := startIndex - 1 + count
+	if end > total {
+		end = total
+	}
+	return selected[startIndex-1 : end], total, startIndex, nil
+}
+
+func (state *RuntimeState) scimUserResource(r *http.Request, username string, withGroups bool) (scimUser, error) {
+	user := scimUser{
+		Schemas:  []string{scimUserSchema},
+		ID:       username,
+		UserName: username,
+		Active:   true,
+		Meta:     scimMeta{ResourceType: "User", Location: scimLocation(r, "Users", username)},
+	}
+	emails, err := state.requestUserinfo(r).GetEmailofauser(username)
+	if err != nil {
+		return user, err
+	}
+	for i, email := range emails {
+		user.Emails = append(user.Emails, scimEmail{Value: email, Primary: i == 0})
+	}
+	if !withGroups {
+		return user, nil
+	}
+	groups, err := state.requestUserinfo(r).GetgroupsofUser(username)
+	if err != nil {
+		return user, err
+	}
+	sort.Strings(groups)
+	for _, groupname := range groups {
+		user.Groups = append(user.Groups, scimMember{Value: groupname, Display: groupname,
+			Ref: scimLocation(r, "Groups", groupname)})
+	}
+	return user, nil
+}
+
+func (state *RuntimeState) scimGroupResource(r *http.Request, groupname string, withMembers bool) (scimGroup,
+	error) {
+	group := scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          groupname,
+		DisplayName: groupname,
+		Meta:        scimMeta{ResourceType: "Group", Location: scimLocation(r, "Groups", groupname)},
+	}
+	if !withMembers {
+		return group, nil
+	}
+	members, _, err := state.requestUserinfo(r).GetusersofaGroup(groupname)
+	if err != nil {
+		return group, err
+	}
+	sort.Strings(members)
+	for _, member := range members {
+		group.Members = append(group.Members, scimMember{Value: member, Display: member,
+			Ref: scimLocation(r, "Users", member)})
+	}
+	return group, nil
+}
+
+func scimExcludesMembers(query url.Values) bool {
+	for _, attribute := range strings.Split(query.Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			return true
+		}
+	}
+	return false
+}
+
+func (state *RuntimeState) listSCIMUsers(r *http.Request) (interface{}, error) {
+	usernames, err := state.requestUserinfo(r).GetallUsers()
+	if err != nil {
+		return nil, err
+	}
+	page, total, startIndex, err := scimPage(usernames, r.URL.Query(), "userName")
+	if err != nil {
+		return nil, err
+	}
+	response := scimListResponse{Schemas: []string{scimListResponseSchema}, TotalResults: total,
+		StartIndex: startIndex, ItemsPerPage: len(page), Resources: []interface{}{}}
+	for _, username := range page {
+		user, err := state.scimUserResource(r, username, false)
+		if err != nil {
+			return nil, err
+		}
+		response.Resources = append(response.Resources, user)
+	}
+	return response, nil
+}
+
+// scimGroupExists returns whether the group exists and is not archived.
+func (state *RuntimeState) scimGroupExists(r *http.Request, groupname string) (bool, error) {
+	exists, _, err := state.requestUserinfo(r).GroupnameExistsornot(groupname)
+	if err != nil || !exists {
+		return false, err
+	}
+	archive, err := getGroupArchiveFromDB(groupname, state)
+	if err != nil {
+		return false, err
+	}
+	return archive == nil, nil
+}
+
+func (state *RuntimeState) listSCIMGroups(r *http.Request) (interface{}, error) {
+	groupnames, err := state.requestUserinfo(r).GetallGroups()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := state.getArchivedGroups()
+	if err != nil {
+		return nil, err
+	}
+	var active []string
+	for _, groupname := range groupnames {
+		if !archived[groupname] {
+			active = append(active, groupname)
+		}
+	}
+	query := r.URL.Query()
+	page, total, startIndex, err := scimPage(active, query, "displayName")
+	if err != nil {
+		return nil, err
+	}
+	response := scimListResponse{Schemas: []string{scimListResponseSchema}, TotalResults: total,
+		StartIndex: startIndex, ItemsPerPage: len(page), Resources: []interface{}{}}
+	for _, groupname := range page {
+		group, err := state.scimGroupResource(r, groupname, !scimExcludesMembers(query))
+		if err != nil {
+			return nil, err
+		}
+		response.Resources = append(response.Resources, group)
+	}
+	return response, nil
+}
+
+func scimMemberValues(value json.RawMessage) ([]string, error) {
+	var members []scimMember
+	err := json.Unmarshal(value, &members)
+	if err != nil {
+		return nil, newSCIMError(http.StatusBadRequest, "invalidValue", "members must be a list of {\"value\": USER}")
+	}
+	var usernames []string
+	for _, member := range members {
+		if member.Value == "" {
+			return nil, newSCIMError(http.StatusBadRequest, "invalidValue", "a member has no value")
+		}
+		usernames = append(usernames, member.Value)
+	}
+	return usernames, nil
+}
+
+var scimMemberPathPattern = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+("(?:[^"\\]|\\.)*")\s*\]$`)
+
+// applySCIMPatch returns the members of the group once the operations are
+// applied to the current members.
+func applySCIMPatch(groupname string, members []string, operations []scimPatchOperation) (map[string]bool,
+	error) {
+	result := make(map[string]bool)
+	for _, member := range members {
+		result[member] = true
+	}
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		path := strings.TrimSpace(operation.Path)
+		value := operation.Value
+		if path == "" && op != "remove" {
+			// the value is a partial group, as sent by some providers
+			var partial struct {
+				DisplayName *string         `json:"displayName"`
+				Members     json.RawMessage `json:"members"`
+			}
+			err := json.Unmarshal(value, &partial)
+			if err != nil {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidValue", "invalid value: %s", err)
+			}
+			if partial.DisplayName != nil && *partial.DisplayName != groupname {
+				return nil, newSCIMError(http.StatusBadRequest, "mutability", "groups cannot be renamed")
+			}
+			if partial.Members == nil {
+				continue
+			}
+			path, value = "members", partial.Members
+		}
+		if match := scimMemberPathPattern.FindStringSubmatch(path); match != nil && op == "remove" {
+			username, err := strconv.Unquote(match[1])
+			if err != nil {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidPath", "invalid path %s", path)
+			}
+			delete(result, username)
+			continue
+		}
+		if !strings.EqualFold(path, "members") {
+			return nil, newSCIMError(http.StatusBadRequest, "invalidPath", "only the members can be changed")
+		}
+		var usernames []string
+		if len(value) > 0 && string(value) != "null" {
+			var err error
+			usernames, err = scimMemberValues(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch op {
+		case "add":
+			for _, username := range usernames {
+				result[username] = true
+			}
+		case "remove":
+			// without a value every member is removed
+			if usernames == nil {
+				result = make(map[string]bool)
+			}
+			for _, username := range usernames {
+				delete(result, username)
+			}
+		case "replace":
+			result = make(map[string]bool)
+			for _, username := range usernames {
+				result[username] = true
+			}
+		default:
+			return nil, newSCIMError(http.StatusBadRequest, "invalidSyntax", "unknown operation %s", operation.Op)
+		}
+	}
+	return result, nil
+}
+
+// patchSCIMGroup applies the membership changes of the request, it returns
+// true when they are held for approval rather than applied.
+func (state *RuntimeState) patchSCIMGroup(r *http.Request, caller scimCaller, groupname string) (bool, error) {
+	if !caller.client {
+		isAdmin, err := state.isGroupAdmin(caller.actor, groupname)
+		if err != nil {
+			return false, err
+		}
+		if !isAdmin {
+			return false, newSCIMError(http.StatusForbidden, "", "you are not authorized")
+		}
+	}
+	var request scimPatchRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&request)
+	if err != nil {
+		return false, newSCIMError(http.StatusBadRequest, "invalidSyntax", "invalid request: %s", err)
+	}
+	if len(request.Schemas) != 1 || request.Schemas[0] != scimPatchOpSchema {
+		return false, newSCIMError(http.StatusBadRequest, "invalidSyntax", "the schema must be %s",
+			scimPatchOpSchema)
+	}
+	members, _, err := state.requestUserinfo(r).GetusersofaGroup(groupname)
+	if err != nil {
+		return false, err
+	}
+	result, err := applySCIMPatch(groupname, members, request.Operations)
+	if err != nil {
+		return false, err
+	}
+	current := make(map[string]bool)
+	for _, member := range members {
+		current[member] = true
+	}
+	var added, removed []string
+	for username := range result {
+		if !current[username] {
+			added = append(added, username)
+		}
+	}
+	for _, username := range members {
+		if !result[username] {
+			removed = append(removed, username)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	for _, username := range added {
+		exists, err := state.requestUserinfo(r).UsernameExistsornot(username)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, newSCIMError(http.StatusBadRequest, "invalidValue", "user %s does not exist", username)
+		}
+	}
+	message, err := state.checkGroupClassification(groupname, added)
+	if err != nil {
+		return false, err
+	}
+	if message != "" {
+		return false, newSCIMError(http.StatusBadRequest, "invalidValue", "%s", message)
+	}
+	held, err := state.holdPrivilegeEscalation(r, caller.actor, groupname, added, removed)
+	if err != nil || held {
+		return held, err
+	}
+	if len(added) > 0 {
+		err = state.requestUserinfo(r).AddmemberstoExisting(userinfo.GroupInfo{Groupname: groupname,
+			MemberUid: added})
+		outcome, details := auditOutcomeSuccess, scimAuditDetails
+		if err != nil {
+			outcome, details = auditOutcomeFailure, err.Error()
+		}
+		for _, username := range added {
+			state.recordAuditEvent(r, caller.actor, auditActionAddMember, groupname, username, outcome, details)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	if len(removed) > 0 {
+		err = state.requestUserinfo(r).DeletemembersfromGroup(userinfo.GroupInfo{Groupname: groupname,
+			MemberUid: removed})
+		outcome, details := auditOutcomeSuccess, scimAuditDetails
+		if err != nil {
+			outcome, details = auditOutcomeFailure, err.Error()
+		}
+		for _, username := range removed {
+			state.recordAuditEvent(r, caller.actor, auditActionRemoveMember, groupname, username, outcome, details)
+		}
+		if err != nil {
+			return false, err
+		}
+		// the members are removed already, failing to record the removals
+		// only loses the undo
+		_, err = state.recordMembershipRemovals(groupname, removed, caller.actor)
+		if err != nil {
+			requestLogger(r).Error("cannot record the membership removals", "err", err)
+		}
+	}
+	return false, nil
+}
+
+func scimServiceProviderConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":        []string{scimServiceProviderConfigSchema},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "A token of the SCIM tokens file of smallpoint",
+		}},
+	}
+}
+
+// scimHandler serves the SCIM resources under scimPath.
+func (state *RuntimeState) scimHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := state.authenticateSCIM(r)
+	if err != nil {
+		requestLogger(r).Warn("SCIM authentication failed", "err", err)
+		writeSCIMError(w, r, err)
+		return
+	}
+	resource := strings.TrimPrefix(r.URL.Path, scimPath)
+	resourceType, id := resource, ""
+	if i := strings.Index(resource, "/"); i >= 0 {
+		resourceType, id = resource[:i], resource[i+1:]
+	}
+	if r.Method != getMethod && !(r.Method == http.MethodPatch && resourceType == "Groups" && id != "") {
+		writeSCIMError(w, r, newSCIMError(http.StatusMethodNotAllowed, "",
+			"only the members of the groups can be changed, with PATCH"))
+		return
+	}
+	var response interface{}
+	status := http.StatusOK
+	switch {
+	case resourceType == "ServiceProviderConfig" && id == "":
+		response = scimServiceProviderConfig()
+	case resourceType == "Users" && id == "":
+		response, err = state.listSCIMUsers(r)
+	case resourceType == "Users":
+		var exists bool
+		exists, err = state.requestUserinfo(r).UsernameExistsornot(id)
+		if err == nil && !exists {
+			err = newSCIMError(http.StatusNotFound, "", "user %s not found", id)
+		}
+		if err == nil {
+			response, err = state.scimUserResource(r, id, true)
+		}
+	case resourceType == "Groups" && id == "":
+		response, err = state.listSCIMGroups(r)
+	case resourceType == "Groups":
+		var exists bool
+		exists, err = state.scimGroupExists(r, id)
+		if err == nil && !exists {
+			err = newSCIMError(http.StatusNotFound, "", "group %s not found", id)
+		}
+		if err == nil && r.Method == http.MethodPatch {
+			var held bool
+			held, err = state.patchSCIMGroup(r, caller, id)
+			// the group is returned unchanged, the change waits for the
+			// approval of another admin
+			if held {
+				status = http.StatusAccepted
+			}
+		}
+		if err == nil {
+			response, err = state.scimGroupResource(r, id, !scimExcludesMembers(r.URL.Query()))
+		}
+	default:
+		err = newSCIMError(http.StatusNotFound, "", "unknown resource %s", resource)
+	}
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	writeSCIMResponse(w, r, status, response)
+}
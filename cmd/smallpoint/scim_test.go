@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSCIMToken = "0123456789abcdef0123"
+
+func testSCIMRequest(state *RuntimeState, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testSCIMToken)
+	rr := httptest.NewRecorder()
+	state.scimHandler(rr, req)
+	return rr
+}
+
+func TestSCIMHandler(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.scimTokens = map[[sha256.Size]byte]string{sha256.Sum256([]byte(testSCIMToken)): "provisioner"}
+
+	rr := httptest.NewRecorder()
+	state.scimHandler(rr, httptest.NewRequest(getMethod, scimPath+"Users", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated request returned %d", rr.Code)
+	}
+	req := httptest.NewRequest(http.MethodPatch, scimPath+"Groups/group1", strings.NewReader("{}"))
+	cookie := testCreateValidAdminCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr = httptest.NewRecorder()
+	state.scimHandler(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("PATCH with a cookie returned %d", rr.Code)
+	}
+
+	rr = testSCIMRequest(&state, getMethod, scimPath+`Users?filter=userName+eq+"user2"`, "")
+	var list scimListResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &list)
+	if rr.Code != http.StatusOK || err != nil || list.TotalResults != 1 || len(list.Resources) != 1 {
+		t.Fatalf("returned %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = testSCIMRequest(&state, getMethod, scimPath+"Users/user2", "")
+	var user scimUser
+	err = json.Unmarshal(rr.Body.Bytes(), &user)
+	if err != nil || user.UserName != "user2" || len(user.Emails) != 1 || len(user.Groups) != 2 {
+		t.Fatalf("returned %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = testSCIMRequest(&state, getMethod, scimPath+"Users/nobody", "")
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), scimErrorSchema) {
+		t.Fatalf("returned %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = testSCIMRequest(&state, getMethod, scimPath+`Groups?filter=members+eq+"user2"`, "")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalidFilter") {
+		t.Fatalf("returned %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = testSCIMRequest(&state, http.MethodDelete, scimPath+"Groups/group1", "")
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE returned %d", rr.Code)
+	}
+
+	patch := `{"schemas":["` + scimPatchOpSchema + `"],"Operations":[
+		{"op":"Add","path":"members","value":[{"value":"user3"}]},
+		{"op":"remove","path":"members[value eq \"user2\"]"}]}`
+	rr = testSCIMRequest(&state, http.MethodPatch, scimPath+"Groups/group1", patch)
+	var group scimGroup
+	err = json.Unmarshal(rr.Body.Bytes(), &group)
+	if rr.Code != http.StatusOK || err != nil {
+		t.Fatalf("returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var members []string
+	for _, member := range group.Members {
+		members = append(members, member.Value)
+	}
+	if !reflect.DeepEqual(members, []string{"user1", "user3"}) {
+		t.Fatalf("members %v", members)
+	}
+	events, err := searchAuditEventsInDB(auditEventFilter{Actor: "scim:provisioner", Groupname: "group1"}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("%d audit events", len(events))
+	}
+
+	rr = testSCIMRequest(&state, http.MethodPatch, scimPath+"Groups/group1", `{"schemas":["`+scimPatchOpSchema+
+		`"],"Operations":[{"op":"replace","value":{"displayName":"other"}}]}`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "mutability") {
+		t.Fatalf("returned %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSCIMPrivilegeEscalation(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.scimTokens = map[[sha256.Size]byte]string{sha256.Sum256([]byte(testSCIMToken)): "provisioner"}
+	state.Config.PrivilegeEscalation = privilegeEscalationConfig{MassRemovalThreshold: 2, RequireApproval: true}
+
+	rr := testSCIMRequest(&state, http.MethodPatch, scimPath+"Groups/group1", `{"schemas":["`+
+		scimPatchOpSchema+`"],"Operations":[{"op":"remove","path":"members"}]}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("the mass removal was not held, returned %d: %s", rr.Code, rr.Body.String())
+	}
+	members, _, err := state.Userinfo.GetusersofaGroup("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("the held removal was applied, members %v", members)
+	}
+	changes, err := getHeldChangesFromDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != heldChangeRemoveMembers || changes[0].RequestedBy != "scim:provisioner" {
+		t.Fatalf("held changes %+v", changes)
+	}
+}
+
+func TestApplySCIMPatch(t *testing.T) {
+	operations := []scimPatchOperation{
+		{Op: "replace", Value: json.RawMessage(`{"members":[{"value":"a"},{"value":"b"}]}`)},
+		{Op: "add", Path: "members", Value: json.RawMessage(`[{"value":"c"}]`)},
+		{Op: "remove", Path: "members", Value: json.RawMessage(`[{"value":"a"}]`)},
+	}
+	result, err := applySCIMPatch("group", []string{"x"}, operations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, map[string]bool{"b": true, "c": true}) {
+		t.Fatalf("members %v", result)
+	}
+	_, err = applySCIMPatch("group", nil, []scimPatchOperation{{Op: "add", Path: "description"}})
+	if err == nil {
+		t.Fatal("changing the description was accepted")
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The membership changes of the groups mapped to a downstream SCIM target
+// (a SaaS app or an identity provider) are pushed to it with SCIM PATCH
+// requests. The changes are queued in the DB when they are made and sent by
+// a periodic job, in order for each member of a group; the failed pushes
+// are retried with an exponential backoff until max_attempts.
+
+const (
+	scimPushChangeAdd               = "add"
+	scimPushChangeRemove            = "remove"
+	defaultSCIMPushInterval         = time.Minute
+	defaultSCIMPushTimeout          = 30 * time.Second
+	defaultSCIMPushMaxAttempts      = 10
+	maxSCIMPushBackoff              = 6 * time.Hour
+	scimPushBatchSize               = 500
+	maxSCIMPushErrorLength          = 512
+	scimProvisioningServiceName     = "scim_provisioning"
+	scimProvisioningResponseMaxSize = 1 << 20
+)
+
+type scimTargetConfig struct {
+	Name string `yaml:"name"`
+	// URL is the base URL of the SCIM API of the target, the /Users and
+	// /Groups endpoints are under it.
+	URL string `yaml:"url"`
+	// TokenFilename holds the bearer token for the target, it is read on
+	// each run so that it can be rotated without a restart.
+	TokenFilename string        `yaml:"token_filename"`
+	Timeout       time.Duration `yaml:"timeout"`
+	// Groups maps the smallpoint groups to the ids of the groups of the
+	// target, only the changes of the mapped groups are pushed.
+	Groups map[string]string `yaml:"groups"`
+	// UserNameSuffix is appended to the usernames to get the userName of
+	// the users of the target, e.g. "@example.com".
+	UserNameSuffix string `yaml:"user_name_suffix"`
+}
+
+type scimProvisioningConfig struct {
+	Interval    time.Duration      `yaml:"interval"`
+	MaxAttempts int                `yaml:"max_attempts"`
+	Targets     []scimTargetConfig `yaml:"targets"`
+}
+
+func (config scimProvisioningConfig) interval() time.Duration {
+	if config.Interval <= 0 {
+		return defaultSCIMPushInterval
+	}
+	return config.Interval
+}
+
+func (config scimProvisioningConfig) maxAttempts() int {
+	if config.MaxAttempts <= 0 {
+		return defaultSCIMPushMaxAttempts
+	}
+	return config.MaxAttempts
+}
+
+func (config scimProvisioningConfig) check() error {
+	names := make(map[string]bool)
+	for _, target := range config.Targets {
+		if target.Name == "" || names[target.Name] {
+			return fmt.Errorf("the targets need a unique name")
+		}
+		names[target.Name] = true
+		parsedURL, err := url.Parse(target.URL)
+		if err != nil || parsedURL.Scheme != "https" || parsedURL.Host == "" {
+			return fmt.Errorf("target %s: the url must be an https URL", target.Name)
+		}
+		if target.TokenFilename == "" {
+			return fmt.Errorf("target %s: token_filename is required", target.Name)
+		}
+		if len(target.Groups) == 0 {
+			return fmt.Errorf("target %s: no groups are mapped", target.Name)
+		}
+	}
+	return nil
+}
+
+// scimPush is a queued membership change for a target.
+type scimPush struct {
+	ID          int64
+	Target      string
+	Groupname   string
+	Username    string
+	Change      string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	CreatedAt   time.Time
+}
+
+var insertSCIMPushStmt = map[string]string{
+	"sqlite":   "insert into scim_push_queue(target, groupname, username, change, attempts, next_attempt, last_error, created_at) values (?,?,?,?,0,?,'',?);",
+	"postgres": "insert into scim_push_queue(target, groupname, username, change, attempts, next_attempt, last_error, created_at) values ($1,$2,$3,$4,0,$5,'',$6);",
+}
+
+var getQueuedSCIMPushesStmt = map[string]string{
+	"sqlite":   "select id, target, groupname, username, change, attempts, next_attempt, last_error, created_at from scim_push_queue order by id limit ?;",
+	"postgres": "select id, target, groupname, username, change, attempts, next_attempt, last_error, created_at from scim_push_queue order by id limit $1;",
+}
+
+var deleteSCIMPushStmt = map[string]string{
+	"sqlite":   "delete from scim_push_queue where id=?;",
+	"postgres": "delete from scim_push_queue where id=$1;",
+}
+
+var retrySCIMPushStmt = map[string]string{
+	"sqlite":   "update scim_push_queue set attempts=?, next_attempt=?, last_error=? where id=?;",
+	"postgres": "update scim_push_queue set attempts=$1, next_attempt=$2, last_error=$3 where id=$4;",
+}
+
+// enqueueSCIMPushes queues the membership change of the event for the
+// targets the group is mapped to.
+func (state *RuntimeState) enqueueSCIMPushes(event auditEvent) error {
+	if !event.IsMembershipChange() {
+		return nil
+	}
+	change := scimPushChangeAdd
+	if event.Action == auditActionRemoveMember || event.Action == auditActionExitGroup {
+		change = scimPushChangeRemove
+	}
+	for _, target := range state.Config.SCIMProvisioning.Targets {
+		if _, ok := target.Groups[event.Groupname]; !ok {
+			continue
+		}
+		start := time.Now()
+		_, err := state.db.Exec(insertSCIMPushStmt[state.dbType], target.Name, event.Groupname, event.Username,
+			change, event.Timestamp.Unix(), event.Timestamp.Unix())
+		if err != nil {
+			return err
+		}
+		metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	}
+	return nil
+}
+
+// getQueuedSCIMPushesFromDB returns the oldest queued pushes, due or not.
+func getQueuedSCIMPushesFromDB(state *RuntimeState) ([]scimPush, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getQueuedSCIMPushesStmt[state.dbType], scimPushBatchSize)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var pushes []scimPush
+	for rows.Next() {
+		var push scimPush
+		var nextAttempt, createdAt int64
+		err = rows.Scan(&push.ID, &push.Target, &push.Groupname, &push.Username, &push.Change, &push.Attempts,
+			&nextAttempt, &push.LastError, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		push.NextAttempt = time.Unix(nextAttempt, 0)
+		push.CreatedAt = time.Unix(createdAt, 0)
+		pushes = append(pushes, push)
+	}
+	return pushes, rows.Err()
+}
+
+// scimPushBackoff returns the wait before the next attempt, doubling from a
+// minute.
+func scimPushBackoff(attempts int) time.Duration {
+	backoff := time.Minute
+	for i := 1; i < attempts && backoff < maxSCIMPushBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxSCIMPushBackoff {
+		return maxSCIMPushBackoff
+	}
+	return backoff
+}
+
+// scimTargetClient sends the SCIM requests to a target.
+type scimTargetClient struct {
+	config scimTargetConfig
+	token  string
+	client *http.Client
+	// userIDs caches the ids of the target users for a run.
+	userIDs map[string]string
+}
+
+func newSCIMTargetClient(config scimTargetConfig) (*scimTargetClient, error) {
+	token, err := ioutil.ReadFile(config.TokenFilename)
+	if err != nil {
+		return nil, err
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultSCIMPushTimeout
+	}
+	return &scimTargetClient{
+		config:  config,
+		token:   strings.TrimSpace(string(token)),
+		client:  &http.Client{Timeout: timeout},
+		userIDs: make(map[string]string),
+	}, nil
+}
+
+func (c *scimTargetClient) do(method string, path string, body interface{}, response interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.config.URL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", scimContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", scimContentType)
+	}
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	metrics.MetricLogExternalServiceDuration(scimProvisioningServiceName, time.Since(start))
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, scimProvisioningResponseMaxSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var scimErr scimErrorResponse
+		if json.Unmarshal(content, &scimErr) == nil && scimErr.Detail != "" {
+			return fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, scimErr.Detail)
+		}
+		return fmt.Errorf("%s %s failed with status %d", method, path, resp.StatusCode)
+	}
+	if response == nil || len(content) == 0 {
+		return nil
+	}
+	return json.Unmarshal(content, response)
+}
+
+// findID returns the id of the resource with the attribute value, empty
+// when there is none.
+func (c *scimTargetClient) findID(resourceType string, attribute string, value string) (string, error) {
+	filter := attribute + " eq " + strconv.Quote(value)
+	var list struct {
+		Resources []struct {
+			ID string `json:"id"`
+		} `json:"Resources"`
+	}
+	err := c.do(getMethod, "/"+resourceType+"?filter="+url.QueryEscape(filter), nil, &list)
+	if err != nil {
+		return "", err
+	}
+	if len(list.Resources) > 1 {
+		return "", fmt.Errorf("%d %s of the target have the %s %s", len(list.Resources), resourceType,
+			attribute, value)
+	}
+	if len(list.Resources) == 0 {
+		return "", nil
+	}
+	return list.Resources[0].ID, nil
+}
+
+// userID looks up the id of the target user of username.
+func (c *scimTargetClient) userID(username string) (string, error) {
+	if id, ok := c.userIDs[username]; ok {
+		return id, nil
+	}
+	userName := username + c.config.UserNameSuffix
+	id, err := c.findID("Users", "userName", userName)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", fmt.Errorf("the target has no user with the userName %s", userName)
+	}
+	c.userIDs[username] = id
+	return id, nil
+}
+
+// push applies the membership change to the group of the target.
+func (c *scimTargetClient) push(push scimPush) error {
+	groupID, ok := c.config.Groups[push.Groupname]
+	if !ok {
+		return fmt.Errorf("group %s is not mapped", push.Groupname)
+	}
+	userID, err := c.userID(push.Username)
+	if err != nil {
+		return err
+	}
+	operation := scimPatchOperation{Op: scimPushChangeAdd, Path: "members"}
+	if push.Change == scimPushChangeRemove {
+		operation = scimPatchOperation{Op: scimPushChangeRemove,
+			Path: "members[value eq " + strconv.Quote(userID) + "]"}
+	} else {
+		operation.Value, err = json.Marshal([]scimMember{{Value: userID}})
+		if err != nil {
+			return err
+		}
+	}
+	return c.do(http.MethodPatch, "/Groups/"+url.PathEscape(groupID), scimPatchRequest{
+		Schemas:    []string{scimPatchOpSchema},
+		Operations: []scimPatchOperation{operation},
+	}, nil)
+}
+
+// runSCIMPushes sends the due pushes. A push that fails holds back the
+// later changes of the same member of the group until it is sent or given
+// up, so that the target sees the changes in order.
+func (state *RuntimeState) runSCIMPushes() error {
+	config := state.Config.SCIMProvisioning
+	pushes, err := getQueuedSCIMPushesFromDB(state)
+	if err != nil {
+		return err
+	}
+	targets := make(map[string]scimTargetConfig)
+	for _, target := range config.Targets {
+		targets[target.Name] = target
+	}
+	clients := make(map[string]*scimTargetClient)
+	clientErrors := make(map[string]error)
+	blocked := make(map[string]bool)
+	now := time.Now()
+	var failed int
+	for _, push := range pushes {
+		key := push.Target + "\x00" + push.Groupname + "\x00" + push.Username
+		if blocked[key] {
+			continue
+		}
+		if push.NextAttempt.After(now) {
+			blocked[key] = true
+			continue
+		}
+		target, ok := targets[push.Target]
+		if !ok {
+			// the target was removed from the configuration
+			slog.Warn("dropping the SCIM push of a removed target", "target", push.Target,
+				"group", push.Groupname, "user", push.Username)
+			_, err = state.db.Exec(deleteSCIMPushStmt[state.dbType], push.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		client, ok := clients[push.Target]
+		if !ok && clientErrors[push.Target] == nil {
+			client, err = newSCIMTargetClient(target)
+			clients[push.Target] = client
+			clientErrors[push.Target] = err
+		}
+		pushErr := clientErrors[push.Target]
+		if pushErr == nil {
+			pushErr = client.push(push)
+		}
+		if pushErr == nil {
+			_, err = state.db.Exec(deleteSCIMPushStmt[state.dbType], push.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		failed++
+		push.Attempts++
+		if push.Attempts >= config.maxAttempts() {
+			slog.Error("giving up the SCIM push", "target", push.Target, "group", push.Groupname,
+				"user", push.Username, "change", push.Change, "attempts", push.Attempts, "err", pushErr)
+			_, err = state.db.Exec(deleteSCIMPushStmt[state.dbType], push.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		blocked[key] = true
+		message := pushErr.Error()
+		if len(message) > maxSCIMPushErrorLength {
+			message = message[:maxSCIMPushErrorLength]
+		}
+		_, err = state.db.Exec(retrySCIMPushStmt[state.dbType], push.Attempts,
+			now.Add(scimPushBackoff(push.Attempts)).Unix(), message, push.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d SCIM pushes failed, they are retried later", failed)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSCIMPushes(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("delete from scim_push_queue;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patches []string
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer target-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == getMethod && r.URL.Path == "/scim/v2/Users":
+			userName := strings.TrimSuffix(strings.TrimPrefix(r.URL.Query().Get("filter"), `userName eq "`), `"`)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Resources": []map[string]string{{"id": "id-" + userName}}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/scim/v2/Groups/remote1":
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var request scimPatchRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			operation := request.Operations[0]
+			patches = append(patches, operation.Op+" "+operation.Path+" "+string(operation.Value))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	tokenFilename := filepath.Join(t.TempDir(), "token")
+	err = ioutil.WriteFile(tokenFilename, []byte("target-token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.SCIMProvisioning.Targets = []scimTargetConfig{{Name: "app", URL: server.URL + "/scim/v2/",
+		TokenFilename: tokenFilename, Groups: map[string]string{"group1": "remote1"},
+		UserNameSuffix: "@example.com"}}
+
+	state.recordAuditEvent(nil, "user1", auditActionAddMember, "group1", "user3", auditOutcomeSuccess, "")
+	state.recordAuditEvent(nil, "user1", auditActionRemoveMember, "group1", "user3", auditOutcomeSuccess, "")
+	state.recordAuditEvent(nil, "user1", auditActionRemoveMember, "group1", "user2", auditOutcomeSuccess, "")
+	state.recordAuditEvent(nil, "user1", auditActionAddMember, "group2", "user3", auditOutcomeSuccess, "")
+	state.recordAuditEvent(nil, "user1", auditActionAddMember, "group1", "user2", auditOutcomeFailure, "")
+
+	// the add of user3 fails and holds back its removal
+	err = state.runSCIMPushes()
+	if err == nil {
+		t.Fatal("the failed push was not reported")
+	}
+	expected := []string{`remove members[value eq "id-user2@example.com"] `}
+	if !reflect.DeepEqual(patches, expected) {
+		t.Fatalf("patches %q", patches)
+	}
+	pushes, err := getQueuedSCIMPushesFromDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pushes) != 2 || pushes[0].Attempts != 1 || !strings.Contains(pushes[0].LastError, "503") ||
+		!pushes[0].NextAttempt.After(time.Now()) || pushes[1].Attempts != 0 {
+		t.Fatalf("queued pushes %+v", pushes)
+	}
+
+	_, err = state.db.Exec("update scim_push_queue set next_attempt=0;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.runSCIMPushes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, `add members [{"value":"id-user3@example.com"}]`,
+		`remove members[value eq "id-user3@example.com"] `)
+	if !reflect.DeepEqual(patches, expected) {
+		t.Fatalf("patches %q", patches)
+	}
+	pushes, err = getQueuedSCIMPushesFromDB(&state)
+	if err != nil || len(pushes) != 0 {
+		t.Fatalf("queued pushes %+v, err %v", pushes, err)
+	}
+}
+
+func TestSCIMPushBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{1: time.Minute, 3: 4 * time.Minute,
+		20: maxSCIMPushBackoff} {
+		if backoff := scimPushBackoff(attempts); backoff != expected {
+			t.Errorf("backoff %s after %d attempts, expected %s", backoff, attempts, expected)
+		}
+	}
+}
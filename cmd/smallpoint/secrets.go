@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo"
+	"github.com/Symantec/ldap-group-management/lib/vault"
+)
+
+// The secrets may be read from Vault instead of the config file and the
+// cluster secret file. Each secret is a reference path#key, e.g.
+// secret/data/smallpoint/oidc#client_secret, the references that are not
+// set keep the value of the config. The secrets are read before the
+// server starts and again every refresh interval on every instance, the
+// rotated values replace the former ones without a restart.
+
+const defaultSecretsRefreshInterval = 5 * time.Minute
+
+type secretsConfig struct {
+	Vault vault.Config `yaml:"vault"`
+	// RefreshInterval between the reads of the secrets, 5m by default.
+	RefreshInterval    time.Duration `yaml:"refresh_interval"`
+	OpenIDClientSecret string        `yaml:"openid_client_secret"`
+	// SharedSecrets holds the cluster secrets one per line, the first one
+	// signs the cookies.
+	SharedSecrets      string `yaml:"shared_secrets"`
+	TargetBindPassword string `yaml:"target_bind_password"`
+	SourceBindPassword string `yaml:"source_bind_password"`
+	SMTPUsername       string `yaml:"smtp_username"`
+	SMTPPassword       string `yaml:"smtp_password"`
+}
+
+func (config secretsConfig) enabled() bool {
+	return config.Vault.Address != ""
+}
+
+func (config secretsConfig) refreshInterval() time.Duration {
+	if config.RefreshInterval > 0 {
+		return config.RefreshInterval
+	}
+	return defaultSecretsRefreshInterval
+}
+
+func (config secretsConfig) references() map[string]string {
+	return map[string]string{
+		"openid_client_secret": config.OpenIDClientSecret,
+		"shared_secrets":       config.SharedSecrets,
+		"target_bind_password": config.TargetBindPassword,
+		"source_bind_password": config.SourceBindPassword,
+		"smtp_username":        config.SMTPUsername,
+		"smtp_password":        config.SMTPPassword,
+	}
+}
+
+func (config secretsConfig) check() error {
+	references := config.references()
+	if !config.enabled() {
+		for name, reference := range references {
+			if reference != "" {
+				return fmt.Errorf("%s is set without vault.address", name)
+			}
+		}
+		return nil
+	}
+	err := config.Vault.Check()
+	if err != nil {
+		return err
+	}
+	for name, reference := range references {
+		if reference == "" {
+			continue
+		}
+		if _, _, err := vault.ParseReference(reference); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// secretStore holds the client reading the secrets and the secrets that
+// are not kept by their users.
+type secretStore struct {
+	client *vault.Client
+	// targetLDAP and sourceLDAP are the directories in use, the config
+	// holds copies once loadConfig returns.
+	targetLDAP *ldapuserinfo.UserInfoLDAPSource
+	sourceLDAP *ldapuserinfo.UserInfoLDAPSource
+
+	mutex        sync.RWMutex
+	smtpUsername string
+	smtpPassword string
+	// refreshErr is the error of the last refresh, reported by the health
+	// checks.
+	refreshErr error
+}
+
+// secretValues are the secrets read, by the name of their reference.
+type secretValues map[string]string
+
+func (state *RuntimeState) readSecrets() (secretValues, error) {
+	values := make(secretValues)
+	for name, reference := range state.Config.Secrets.references() {
+		if reference == "" {
+			continue
+		}
+		value, err := state.secrets.client.Secret(reference)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %w", name, err)
+		}
+		if strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("the secret %s is empty", name)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+func (values secretValues) sharedSecrets() []string {
+	var secrets []string
+	for _, secret := range strings.Split(values["shared_secrets"], "\n") {
+		secret = strings.TrimSpace(secret)
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// loadSecrets reads the secrets into the config, before the authenticator
+// and the connections are set up.
+func (state *RuntimeState) loadSecrets() error {
+	err := state.Config.Secrets.check()
+	if err != nil {
+		return err
+	}
+	state.secrets.client, err = vault.New(state.Config.Secrets.Vault)
+	if err != nil {
+		return err
+	}
+	values, err := state.readSecrets()
+	if err != nil {
+		return err
+	}
+	config := &state.Config
+	state.secrets.targetLDAP = &config.TargetLDAP
+	state.secrets.sourceLDAP = &config.SourceLDAP
+	setString := func(name string, field *string) {
+		if value, ok := values[name]; ok {
+			*field = value
+		}
+	}
+	setString("openid_client_secret", &config.OpenID.ClientSecret)
+	setString("target_bind_password", &config.TargetLDAP.BindPassword)
+	setString("source_bind_password", &config.SourceLDAP.BindPassword)
+	setString("smtp_username", &config.Base.SMTPUsername)
+	setString("smtp_password", &config.Base.SMTPPassword)
+	if _, ok := values["shared_secrets"]; ok {
+		config.Base.SharedSecrets = values.sharedSecrets()
+	}
+	return nil
+}
+
+// refreshSecrets reads the secrets again and hands the rotated ones to
+// their users, the secrets in use are kept when a read fails.
+func (state *RuntimeState) refreshSecrets() error {
+	values, err := state.readSecrets()
+	if _, ok := values["shared_secrets"]; ok && err == nil {
+		// a weak rotated secret is refused like at the start
+		err = checkSharedSecrets(values.sharedSecrets())
+	}
+	state.secrets.mutex.Lock()
+	state.secrets.refreshErr = err
+	state.secrets.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if value, ok := values["openid_client_secret"]; ok {
+		state.authenticator.SetClientSecret(value)
+	}
+	if _, ok := values["shared_secrets"]; ok {
+		state.authenticator.SetSharedSecrets(values.sharedSecrets())
+	}
+	if value, ok := values["target_bind_password"]; ok {
+		state.secrets.targetLDAP.SetBindPassword(value)
+	}
+	if value, ok := values["source_bind_password"]; ok {
+		state.secrets.sourceLDAP.SetBindPassword(value)
+	}
+	state.secrets.mutex.Lock()
+	defer state.secrets.mutex.Unlock()
+	if value, ok := values["smtp_username"]; ok {
+		state.secrets.smtpUsername = value
+	}
+	if value, ok := values["smtp_password"]; ok {
+		state.secrets.smtpPassword = value
+	}
+	slog.Debug("secrets refreshed", "count", len(values))
+	return nil
+}
+
+// secretsRefreshError returns the error of the last refresh.
+func (state *RuntimeState) secretsRefreshError() error {
+	state.secrets.mutex.RLock()
+	defer state.secrets.mutex.RUnlock()
+	return state.secrets.refreshErr
+}
+
+func (state *RuntimeState) setSMTPCredentials(username string, password string) {
+	state.secrets.mutex.Lock()
+	defer state.secrets.mutex.Unlock()
+	state.secrets.smtpUsername = username
+	state.secrets.smtpPassword = password
+}
+
+// smtpAuth returns the PLAIN auth of the SMTP credentials, nil without
+// credentials.
+func (state *RuntimeState) smtpAuth() smtp.Auth {
+	state.secrets.mutex.RLock()
+	defer state.secrets.mutex.RUnlock()
+	if state.secrets.smtpUsername == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(state.Config.Base.SMTPserver)
+	if err != nil {
+		host = state.Config.Base.SMTPserver
+	}
+	return smtp.PlainAuth("", state.secrets.smtpUsername, state.secrets.smtpPassword, host)
+}
+
+// startSecretsRefresh refreshes the secrets on every instance, the leader
+// and the followers alike.
+func (state *RuntimeState) startSecretsRefresh() {
+	interval := state.Config.Secrets.refreshInterval()
+	state.startPeriodicJob("secrets_refresh", interval, state.refreshSecrets)
+	state.startFollowerJob("secrets_refresh", interval, state.refreshSecrets)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+	"github.com/Symantec/ldap-group-management/lib/vault"
+	"github.com/Symantec/ldap-group-management/lib/vault/vaulttest"
+)
+
+func TestSecretsFromVault(t *testing.T) {
+	const oldSecret = "old-secret-0123456789abcdefghijklmn"
+	const newSecret = "new-secret-0123456789abcdefghijklmn"
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.SetSecret("smallpoint/oidc", map[string]interface{}{"client_secret": "oidc-secret"})
+	server.SetSecret("smallpoint/cluster", map[string]interface{}{"secrets": oldSecret + "\n"})
+	server.SetSecret("smallpoint/ldap", map[string]interface{}{"password": "bind-password"})
+	server.SetSecret("smallpoint/smtp", map[string]interface{}{"username": "mailer", "password": "mail-password"})
+	t.Setenv("VAULT_TOKEN", server.Token)
+
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.SMTPserver = "localhost:25"
+	state.Config.Secrets = secretsConfig{Vault: vault.Config{Address: server.URL, InsecureHTTP: true},
+		OpenIDClientSecret: "secret/data/smallpoint/oidc#client_secret",
+		SharedSecrets:      "secret/data/smallpoint/cluster#secrets",
+		TargetBindPassword: "secret/data/smallpoint/ldap#password",
+		SMTPUsername:       "secret/data/smallpoint/smtp#username",
+		SMTPPassword:       "secret/data/smallpoint/smtp#password"}
+	err = state.loadSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Config.OpenID.ClientSecret != "oidc-secret" ||
+		state.Config.TargetLDAP.BindPassword != "bind-password" ||
+		!reflect.DeepEqual(state.Config.Base.SharedSecrets, []string{oldSecret}) {
+		t.Fatal("the secrets were not loaded into the config")
+	}
+	if state.Config.SourceLDAP.BindPassword != "" {
+		t.Fatal("the secret without a reference was set")
+	}
+	state.setSMTPCredentials(state.Config.Base.SMTPUsername, state.Config.Base.SMTPPassword)
+	if state.smtpAuth() == nil {
+		t.Fatal("no SMTP auth with the credentials")
+	}
+	state.authenticator = authn.NewAuthenticator(state.Config.OpenID, "smallpoint", nil,
+		state.Config.Base.SharedSecrets, nil)
+	cookie := testCreateValidCookie(state.authenticator)
+	req := httptest.NewRequest(getMethod, "/", nil)
+	req.AddCookie(&cookie)
+
+	// the rotated secrets replace the former ones
+	server.SetSecret("smallpoint/cluster", map[string]interface{}{"secrets": newSecret + "\n" + oldSecret})
+	err = state.refreshSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.authenticator.GetVerifiedUserName(req) != testUsername {
+		t.Fatal("the cookie of the former secret was rejected")
+	}
+	server.SetSecret("smallpoint/cluster", map[string]interface{}{"secrets": newSecret})
+	err = state.refreshSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.authenticator.GetVerifiedUserName(req) != "" {
+		t.Fatal("the cookie of the dropped secret was accepted")
+	}
+
+	// a weak rotated secret is refused
+	server.SetSecret("smallpoint/cluster", map[string]interface{}{"secrets": "short"})
+	if err := state.refreshSecrets(); err == nil {
+		t.Fatal("the weak shared secret was accepted")
+	}
+	server.SetSecret("smallpoint/cluster", map[string]interface{}{"secrets": newSecret})
+
+	// a failed refresh keeps the secrets in use and is reported
+	server.SetSecret("smallpoint/smtp", map[string]interface{}{"username": "mailer"})
+	if err := state.refreshSecrets(); err == nil || state.secretsRefreshError() == nil {
+		t.Fatal("the refresh with a missing secret succeeded")
+	}
+	if state.smtpAuth() == nil {
+		t.Fatal("the SMTP credentials were dropped")
+	}
+
+	state.Config.Secrets.Vault.Address = ""
+	if err := state.Config.Secrets.check(); err == nil {
+		t.Fatal("references without vault were accepted")
+	}
+	state.Config.Secrets = secretsConfig{Vault: vault.Config{Address: server.URL, InsecureHTTP: true}, SMTPPassword: "no-key"}
+	if err := state.Config.Secrets.check(); err == nil {
+		t.Fatal("reference without key was accepted")
+	}
+}
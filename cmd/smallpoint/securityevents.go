@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The security events are logged at warn level with a security_event
+// attribute and counted by the smallpoint_security_events_total metric. The
+// authenticator reports the rejected auth cookies and OAuth2 states, the
+// forbidden responses and the rate limited requests are reported here.
+
+const (
+	securityEventForbidden   = "forbidden_access"
+	securityEventRateLimited = "rate_limited"
+)
+
+// recordSecurityEvent logs the event, r is nil for the changes of the
+// command line and the background jobs.
+func recordSecurityEvent(r *http.Request, event string, attrs ...interface{}) {
+	metrics.MetricLogSecurityEvent(event)
+	if r != nil {
+		attrs = append([]interface{}{"remote_ip", remoteIPFromRequest(r), "method", r.Method, "path", r.URL.Path},
+			attrs...)
+	}
+	attrs = append([]interface{}{"security_event", event}, attrs...)
+	requestLogger(r).Warn("security event", attrs...)
+}
+
+// authnSecurityEvent records the security events of the authenticator.
+func authnSecurityEvent(r *http.Request, event string, reason error) {
+	if reason != nil {
+		recordSecurityEvent(r, event, "reason", reason.Error())
+		return
+	}
+	recordSecurityEvent(r, event)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForbiddenSecurityEvent(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	handler, err := loggingConfig{Format: "json", Level: "info"}.newHandler(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultLogger, logWriter, logFlags := slog.Default(), log.Writer(), log.Flags()
+	slog.SetDefault(slog.New(handler))
+	defer func() {
+		slog.SetDefault(defaultLogger)
+		log.SetOutput(logWriter)
+		log.SetFlags(logFlags)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(deletegroupWebPagePath, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+	})
+	req := httptest.NewRequest(getMethod, deletegroupWebPagePath, nil)
+	req.Header.Set(requestIDHeader, "forbidden-id")
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	state.requestLoggingHandler(mux, mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	var events []map[string]interface{}
+	for _, record := range testRequestRecords(t, &buf, "forbidden-id") {
+		if _, ok := record["security_event"]; ok {
+			events = append(events, record)
+		}
+	}
+	if len(events) != 1 {
+		t.Fatalf("unexpected log %q", buf.String())
+	}
+	event := events[0]
+	if event["security_event"] != securityEventForbidden || event["level"] != "WARN" ||
+		event["user"] != testUsername || event["path"] != deletegroupWebPagePath {
+		t.Fatalf("bad security event %v", event)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every response gets the security headers, the pages, the APIs, the
+// static assets and the error responses alike. The defaults suit the
+// built in pages, the branding that loads its logo or fonts from elsewhere
+// extends the policy in the config.
+
+const (
+	defaultContentSecurityPolicy = "default-src 'self';" +
+		" script-src 'self' cdn.datatables.net maxcdn.bootstrapcdn.com code.jquery.com; " +
+		" style-src 'self' cdn.datatables.net maxcdn.bootstrapcdn.com cdnjs.cloudflare.com fonts.googleapis.com 'unsafe-inline';" +
+		" font-src cdnjs.cloudflare.com fonts.gstatic.com fonts.googleapis.com maxcdn.bootstrapcdn.com;" +
+		" img-src 'self' cdn.datatables.net"
+	defaultFrameOptions   = "DENY"
+	defaultReferrerPolicy = "same-origin"
+	defaultHSTSMaxAge     = 14 * 24 * time.Hour
+)
+
+type securityHeadersConfig struct {
+	ContentSecurityPolicy string `yaml:"content_security_policy"`
+	// FrameOptions is DENY or SAMEORIGIN.
+	FrameOptions   string `yaml:"frame_options"`
+	ReferrerPolicy string `yaml:"referrer_policy"`
+	// HSTSMaxAge is the max-age of Strict-Transport-Security, a negative
+	// one drops the header.
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age"`
+	HSTSIncludeSubdomains bool          `yaml:"hsts_include_subdomains"`
+	// Headers are set after the others, an empty value drops a header.
+	Headers map[string]string `yaml:"headers"`
+}
+
+func (config securityHeadersConfig) check() error {
+	switch strings.ToUpper(config.FrameOptions) {
+	case "", "DENY", "SAMEORIGIN":
+	default:
+		return fmt.Errorf("frame_options must be DENY or SAMEORIGIN")
+	}
+	values := []string{config.ContentSecurityPolicy, config.ReferrerPolicy}
+	for name, value := range config.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		values = append(values, value)
+	}
+	for _, value := range values {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("the header values must be on one line")
+		}
+	}
+	return nil
+}
+
+// headers returns the headers of the responses.
+func (config securityHeadersConfig) headers() http.Header {
+	headers := make(http.Header)
+	headers.Set("Content-Security-Policy", defaultString(config.ContentSecurityPolicy,
+		defaultContentSecurityPolicy))
+	headers.Set("X-Frame-Options", strings.ToUpper(defaultString(config.FrameOptions, defaultFrameOptions)))
+	headers.Set("Referrer-Policy", defaultString(config.ReferrerPolicy, defaultReferrerPolicy))
+	headers.Set("X-Content-Type-Options", "nosniff")
+	headers.Set("X-XSS-Protection", "1")
+	maxAge := config.HSTSMaxAge
+	if maxAge == 0 {
+		maxAge = defaultHSTSMaxAge
+	}
+	if maxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		headers.Set("Strict-Transport-Security", hsts)
+	}
+	for name, value := range config.Headers {
+		if value == "" {
+			headers.Del(name)
+			continue
+		}
+		headers.Set(name, value)
+	}
+	return headers
+}
+
+func defaultString(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// securityHeadersHandler sets the security headers before the handler
+// runs, the handlers may still replace them.
+func (state *RuntimeState) securityHeadersHandler(handler http.Handler) http.Handler {
+	headers := state.Config.SecurityHeaders.headers()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range headers {
+			w.Header()[name] = values
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
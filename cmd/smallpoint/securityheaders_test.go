@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func() http.Header {
+		handler := state.securityHeadersHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "not found", http.StatusNotFound)
+		}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(getMethod, "/missing", nil))
+		return rr.Result().Header
+	}
+
+	// the error responses get the defaults too
+	headers := serve()
+	expected := map[string]string{
+		"Content-Security-Policy":   defaultContentSecurityPolicy,
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "same-origin",
+		"Strict-Transport-Security": "max-age=1209600",
+		"X-Content-Type-Options":    "nosniff",
+		"Cache-Control":             "no-store",
+	}
+	for name, value := range expected {
+		if headers.Get(name) != value {
+			t.Errorf("%s is %q", name, headers.Get(name))
+		}
+	}
+
+	state.Config.SecurityHeaders = securityHeadersConfig{
+		ContentSecurityPolicy: "default-src 'self'; img-src 'self' https://cdn.example.com",
+		FrameOptions:          "sameorigin",
+		ReferrerPolicy:        "no-referrer",
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		Headers:               map[string]string{"X-XSS-Protection": "", "Permissions-Policy": "camera=()"},
+	}
+	err = state.Config.SecurityHeaders.check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers = serve()
+	expected = map[string]string{
+		"Content-Security-Policy":   "default-src 'self'; img-src 'self' https://cdn.example.com",
+		"X-Frame-Options":           "SAMEORIGIN",
+		"Referrer-Policy":           "no-referrer",
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"Permissions-Policy":        "camera=()",
+		"X-XSS-Protection":          "",
+	}
+	for name, value := range expected {
+		if headers.Get(name) != value {
+			t.Errorf("%s is %q", name, headers.Get(name))
+		}
+	}
+	state.Config.SecurityHeaders.HSTSMaxAge = -1
+	if headers = serve(); headers.Get("Strict-Transport-Security") != "" {
+		t.Errorf("HSTS was not dropped")
+	}
+
+	for _, config := range []securityHeadersConfig{{FrameOptions: "ALLOW-FROM https://example.com"},
+		{ReferrerPolicy: "origin\r\nSet-Cookie: a=b"}, {Headers: map[string]string{"Bad Name": "x"}}} {
+		if config.check() == nil {
+			t.Errorf("invalid config %+v accepted", config)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Service accounts created through smallpoint are tracked in the local DB so
+// that their owners periodically confirm they are still needed. Accounts
+// whose review lapses are disabled in LDAP.
+
+const (
+	serviceAccountStatusActive   = "active"
+	serviceAccountStatusDisabled = "disabled"
+
+	defaultServiceAccountReviewDays = 365
+	defaultServiceAccountNotifyDays = 14
+
+	serviceAccountReviewCheckInterval = time.Hour
+)
+
+type serviceAccountConfig struct {
+	// Owners must review their service accounts every ReviewDays, the
+	// default is 365.
+	ReviewDays int `yaml:"review_days"`
+	// Owners are notified NotifyDays before the review date, the default
+	// is 14.
+	NotifyDays int `yaml:"notify_days"`
+	// Deleted accounts are kept disabled for DeletionGraceDays before
+	// their LDAP entries are removed, the default is 30.
+	DeletionGraceDays int `yaml:"deletion_grace_days"`
+
+	CredentialRotation credentialRotationConfig   `yaml:"credential_rotation"`
+	Naming             serviceAccountNamingConfig `yaml:"naming"`
+}
+
+func (config serviceAccountConfig) reviewPeriod() int {
+	if config.ReviewDays > 0 {
+		return config.ReviewDays
+	}
+	return defaultServiceAccountReviewDays
+}
+
+func (config serviceAccountConfig) notifyPeriod() int {
+	if config.NotifyDays > 0 {
+		return config.NotifyDays
+	}
+	return defaultServiceAccountNotifyDays
+}
+
+type serviceAccount struct {
+	AccountName string
+	// Members of OwnerGroup own the account, the account mail is always
+	// notified.
+	OwnerGroup   string
+	Mail         string
+	CreatedBy    string
+	CreatedAt    time.Time
+	ReviewBy     time.Time
+	LastNotified time.Time
+	Status       string
+}
+
+func (account serviceAccount) IsActive() bool {
+	return account.Status == serviceAccountStatusActive
+}
+
+const serviceAccountColumns = "accountname, owner_group, mail, created_by, created_at, review_by, last_notified, status"
+
+var insertServiceAccountStmts = map[string][]string{
+	"sqlite": {"delete from service_accounts where accountname=?;",
+		"insert into service_accounts(" + serviceAccountColumns + ") values (?,?,?,?,?,?,?,?);"},
+	"postgres": {"delete from service_accounts where accountname=$1;",
+		"insert into service_accounts(" + serviceAccountColumns + ") values ($1,$2,$3,$4,$5,$6,$7,$8);"},
+}
+
+var getServiceAccountStmt = map[string]string{
+	"sqlite":   "select " + serviceAccountColumns + " from service_accounts where accountname=?;",
+	"postgres": "select " + serviceAccountColumns + " from service_accounts where accountname=$1;",
+}
+
+var getAllServiceAccountsStmt = "select " + serviceAccountColumns + " from service_accounts order by accountname;"
+
+var getServiceAccountsDueForReviewStmt = map[string]string{
+	"sqlite":   "select " + serviceAccountColumns + " from service_accounts where status=? and review_by < ? order by accountname;",
+	"postgres": "select " + serviceAccountColumns + " from service_accounts where status=$1 and review_by < $2 order by accountname;",
+}
+
+var updateServiceAccountReviewStmt = map[string]string{
+	"sqlite":   "update service_accounts set review_by=?, last_notified=? where accountname=?;",
+	"postgres": "update service_accounts set review_by=$1, last_notified=$2 where accountname=$3;",
+}
+
+var updateServiceAccountLastNotifiedStmt = map[string]string{
+	"sqlite":   "update service_accounts set last_notified=? where accountname=?;",
+	"postgres": "update service_accounts set last_notified=$1 where accountname=$2;",
+}
+
+var updateServiceAccountStatusStmt = map[string]string{
+	"sqlite":   "update service_accounts set status=? where accountname=?;",
+	"postgres": "update service_accounts set status=$1 where accountname=$2;",
+}
+
+type sqlRowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanServiceAccount(row sqlRowScanner) (serviceAccount, error) {
+	var account serviceAccount
+	var createdAt, reviewBy, lastNotified int64
+	err := row.Scan(&account.AccountName, &account.OwnerGroup, &account.Mail, &account.CreatedBy,
+		&createdAt, &reviewBy, &lastNotified, &account.Status)
+	if err != nil {
+		return account, err
+	}
+	account.CreatedAt = time.Unix(createdAt, 0)
+	account.ReviewBy = time.Unix(reviewBy, 0)
+	if lastNotified > 0 {
+		account.LastNotified = time.Unix(lastNotified, 0)
+	}
+	return account, nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// insertServiceAccountInDB replaces any stale record left by an account of
+// the same name that no longer exists in LDAP.
+func insertServiceAccountInDB(account serviceAccount, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := insertServiceAccountStmts[state.dbType]
+	_, err = tx.Exec(stmts[0], account.AccountName)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(stmts[1], account.AccountName, account.OwnerGroup, account.Mail,
+		account.CreatedBy, account.CreatedAt.Unix(), account.ReviewBy.Unix(),
+		unixOrZero(account.LastNotified), account.Status)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func getServiceAccountFromDB(accountName string, state *RuntimeState) (serviceAccount, error) {
+	start := time.Now()
+	account, err := scanServiceAccount(state.db.QueryRow(getServiceAccountStmt[state.dbType], accountName))
+	if err != nil {
+		return account, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return account, nil
+}
+
+func queryServiceAccountsFromDB(state *RuntimeState, stmtText string, args ...interface{}) ([]serviceAccount, error) {
+	start := time.Now()
+	rows, err := state.db.Query(stmtText, args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var accounts []serviceAccount
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+func getAllServiceAccountsFromDB(state *RuntimeState) ([]serviceAccount, error) {
+	return queryServiceAccountsFromDB(state, getAllServiceAccountsStmt)
+}
+
+func execServiceAccountUpdate(state *RuntimeState, stmtText string, args ...interface{}) error {
+	start := time.Now()
+	_, err := state.db.Exec(stmtText, args...)
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// serviceAccountOwnersEmail returns the account mail followed by the email
+// of every member of its owner group.
+func (state *RuntimeState) serviceAccountOwnersEmail(account serviceAccount) ([]string, error) {
+	var recipients []string
+	if account.Mail != "" {
+		recipients = append(recipients, account.Mail)
+	}
+	if account.OwnerGroup == "" {
+		return recipients, nil
+	}
+	ownersEmail, err := state.Userinfo.GetEmailofusersingroup(account.OwnerGroup)
+	if err != nil {
+		if err == userinfo.GroupDoesNotExist {
+			slog.Warn("owner group of the service account does not exist",
+				"group", account.OwnerGroup, "account", account.AccountName)
+			return recipients, nil
+		}
+		return nil, err
+	}
+	return append(recipients, ownersEmail...), nil
+}
+
+// canManageServiceAccount returns true for admins and members of the owner
+// group of the account.
+func (state *RuntimeState) canManageServiceAccount(username string, account serviceAccount) (bool, error) {
+	if state.Userinfo.UserisadminOrNot(username) {
+		return true, nil
+	}
+	if account.OwnerGroup == "" {
+		return false, nil
+	}
+	isMember, _, err := state.Userinfo.IsgroupmemberorNot(account.OwnerGroup, username)
+	if err != nil {
+		if err == userinfo.GroupDoesNotExist {
+			return false, nil
+		}
+		return false, err
+	}
+	return isMember, nil
+}
+
+func (state *RuntimeState) sendServiceAccountEmail(account serviceAccount, subject string, body string) error {
+	recipients, err := state.serviceAccountOwnersEmail(account)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		slog.Warn("service account has no owners to notify", "account", account.AccountName)
+		return nil
+	}
+	return state.sendEmailWithAttachments(recipients, subject, body, nil)
+}
+
+const serviceAccountReviewMailBody = `The service account %s must be reviewed by its owners before %s.
+If it is still needed please mark it as reviewed at %s%s, otherwise it
+will be disabled.
+`
+
+const serviceAccountDisabledMailBody = `The service account %s has been disabled because its review was due on %s.
+Please contact the smallpoint administrators if it is still needed.
+`
+
+// disableLapsedServiceAccount locks the account in LDAP and marks it as
+// disabled.
+func (state *RuntimeState) disableLapsedServiceAccount(account serviceAccount) error {
+	details := fmt.Sprintf("review lapsed on %s", account.ReviewBy.Format(auditDateLayout))
+	err := state.disableServiceAccount(nil, "smallpoint", account, details)
+	if err != nil {
+		return err
+	}
+	return state.sendServiceAccountEmail(account, "Service account "+account.AccountName+" disabled",
+		fmt.Sprintf(serviceAccountDisabledMailBody, account.AccountName, account.ReviewBy.Format(auditDateLayout)))
+}
+
+// runServiceAccountReviews notifies the owners of accounts that are due for
+// review once per review period and disables the accounts whose review date
+// has passed.
+func (state *RuntimeState) runServiceAccountReviews() error {
+	now := time.Now()
+	notifyBefore := now.AddDate(0, 0, state.Config.ServiceAccounts.notifyPeriod())
+	accounts, err := queryServiceAccountsFromDB(state, getServiceAccountsDueForReviewStmt[state.dbType],
+		serviceAccountStatusActive, notifyBefore.Unix())
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if account.ReviewBy.Before(now) {
+			err = state.disableLapsedServiceAccount(account)
+			if err != nil {
+				slog.Error("cannot disable the service account", "account", account.AccountName, "err", err)
+			}
+			continue
+		}
+		if !account.LastNotified.IsZero() {
+			continue
+		}
+		err = state.sendServiceAccountEmail(account, "Review of service account "+account.AccountName,
+			fmt.Sprintf(serviceAccountReviewMailBody, account.AccountName,
+				account.ReviewBy.Format(auditDateLayout), state.Config.Base.Hostname, serviceAccountsPath))
+		if err != nil {
+			slog.Error("cannot notify the owners of the service account", "account", account.AccountName, "err", err)
+			continue
+		}
+		err = execServiceAccountUpdate(state, updateServiceAccountLastNotifiedStmt[state.dbType],
+			now.Unix(), account.AccountName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (state *RuntimeState) serviceAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	allAccounts, err := getAllServiceAccountsFromDB(state)
+	if err != nil {
+		requestLogger(r).Error("serviceAccountsHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
+	var accounts []serviceAccount
+	if isAdmin {
+		accounts = allAccounts
+	} else {
+		userGroups, err := state.requestUserinfo(r).GetgroupsofUser(username)
+		if err != nil {
+			requestLogger(r).Error("serviceAccountsHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		isMember := make(map[string]bool)
+		for _, groupname := range userGroups {
+			isMember[groupname] = true
+		}
+		for _, account := range allAccounts {
+			if account.OwnerGroup != "" && isMember[account.OwnerGroup] {
+				accounts = append(accounts, account)
+			}
+		}
+	}
+	pageData := serviceAccountsPageData{
+		UserName:        username,
+		IsAdmin:         isAdmin,
+		Title:           "Service Accounts",
+		ServiceAccounts: accounts,
+	}
+	requests, err := getAllServiceAccountRequestsFromDB(state)
+	if err != nil {
+		requestLogger(r).Error("serviceAccountsHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	for _, request := range requests {
+		if isAdmin || request.RequestedBy == username {
+			pageData.PendingRequests = append(pageData.PendingRequests, request)
+		}
+	}
+	if isAdmin {
+		pageData.PendingTakeovers, err = getAllServiceAccountTakeoversFromDB(state)
+		if err == nil {
+			pageData.PendingLifecycleRequests, err = getAllServiceAccountLifecycleRequestsFromDB(state)
+		}
+		if err == nil {
+			pageData.ScheduledDeletions, err = getAllServiceAccountDeletionsFromDB(state)
+		}
+		if err != nil {
+			requestLogger(r).Error("serviceAccountsHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+	}
+	state.renderTemplateOrReturnJson(w, r, "serviceAccountsPage", pageData)
+}
+
+func (state *RuntimeState) reviewServiceAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("reviewServiceAccountHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	accountName := strings.TrimSpace(r.PostFormValue("accountname"))
+	account, err := getServiceAccountFromDB(accountName, state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			state.writeFailureResponse(w, r, "service account not found", http.StatusNotFound)
+			return
+		}
+		requestLogger(r).Error("reviewServiceAccountHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	allowed, err := state.canManageServiceAccount(username, account)
+	if err != nil {
+		requestLogger(r).Error("reviewServiceAccountHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	if !account.IsActive() {
+		state.writeFailureResponse(w, r, "disabled service accounts cannot be reviewed", http.StatusBadRequest)
+		return
+	}
+	reviewBy := time.Now().AddDate(0, 0, state.Config.ServiceAccounts.reviewPeriod())
+	err = execServiceAccountUpdate(state, updateServiceAccountReviewStmt[state.dbType],
+		reviewBy.Unix(), 0, account.AccountName)
+	if err != nil {
+		requestLogger(r).Error("reviewServiceAccountHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.recordAuditEvent(r, username, auditActionReviewServiceAccount, account.AccountName,
+		account.AccountName, auditOutcomeSuccess, "next review by "+reviewBy.Format(auditDateLayout))
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:          "Service Account Reviewed",
+		SuccessMessage: fmt.Sprintf("Service account %s must be reviewed again by %s", account.AccountName, reviewBy.Format(auditDateLayout)),
+		ContinueURL:    serviceAccountsPath,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
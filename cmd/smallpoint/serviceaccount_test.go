@@ -0,0 +1,340 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func testPostServiceAccountForm(t *testing.T, state *RuntimeState, path string, handler http.HandlerFunc,
+	admin bool, formValues url.Values) int {
+	req, err := http.NewRequest("POST", path, strings.NewReader(formValues.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	if admin {
+		cookie = testCreateValidAdminCookie(state.authenticator)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestCreateServiceAccountWithReviewDate(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reviewDate := time.Now().AddDate(0, 1, 0).Format(auditDateLayout)
+	formValues := url.Values{"AccountName": {"svc_review"}, "mail": {"team@example.com"},
+		"loginShell": {"/bin/false"}, "ownerGroup": {"group1"}, "reviewDate": {reviewDate}}
+	code := testPostServiceAccountForm(t, &state, createServiceAccountPath,
+		state.createServiceAccounthandler, true, formValues)
+	if code != http.StatusOK {
+		t.Fatalf("create failed with %d", code)
+	}
+	account, err := getServiceAccountFromDB("svc_review", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.OwnerGroup != "group1" || account.CreatedBy != "user1" || !account.IsActive() ||
+		account.ReviewBy.Format(auditDateLayout) != reviewDate {
+		t.Fatalf("bad service account record %+v", account)
+	}
+
+	formValues.Set("AccountName", "svc_bad_owner")
+	formValues.Set("ownerGroup", "nonexistent")
+	code = testPostServiceAccountForm(t, &state, createServiceAccountPath,
+		state.createServiceAccounthandler, true, formValues)
+	if code != http.StatusBadRequest {
+		t.Fatalf("unknown owner group should fail, got %d", code)
+	}
+	formValues.Set("ownerGroup", "group1")
+	formValues.Set("reviewDate", "2001-01-01")
+	code = testPostServiceAccountForm(t, &state, createServiceAccountPath,
+		state.createServiceAccounthandler, true, formValues)
+	if code != http.StatusBadRequest {
+		t.Fatalf("past review date should fail, got %d", code)
+	}
+}
+
+func TestServiceAccountReviewJob(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mockSMTP *smtpDialerMock
+	smtpClient = func(addr string) (smtpDialer, error) {
+		mockSMTP = &smtpDialerMock{}
+		return mockSMTP, nil
+	}
+	now := time.Now()
+	for _, account := range []serviceAccount{
+		{AccountName: "svc_lapsed", OwnerGroup: "group1", ReviewBy: now.Add(-time.Hour)},
+		{AccountName: "svc_due", OwnerGroup: "group1", ReviewBy: now.AddDate(0, 0, 3)},
+		{AccountName: "svc_later", OwnerGroup: "group1", ReviewBy: now.AddDate(0, 6, 0)},
+	} {
+		err = state.Userinfo.CreateServiceAccount(userinfo.GroupInfo{Groupname: account.AccountName,
+			Mail: "team@example.com", LoginShell: "/bin/bash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		account.Mail = "team@example.com"
+		account.CreatedBy = "user1"
+		account.CreatedAt = now
+		account.Status = serviceAccountStatusActive
+		err = insertServiceAccountInDB(account, &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = state.runServiceAccountReviews()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lapsed, err := getServiceAccountFromDB("svc_lapsed", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lapsed.IsActive() {
+		t.Fatal("lapsed service account was not disabled")
+	}
+	events, err := searchAuditEventsInDB(auditEventFilter{Action: auditActionDisableServiceAccount,
+		Groupname: "svc_lapsed"}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Outcome != auditOutcomeSuccess {
+		t.Fatalf("disable not audited %+v", events)
+	}
+	due, err := getServiceAccountFromDB("svc_due", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due.LastNotified.IsZero() {
+		t.Fatal("owners of svc_due were not notified")
+	}
+	later, err := getServiceAccountFromDB("svc_later", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !later.LastNotified.IsZero() || !later.IsActive() {
+		t.Fatalf("svc_later should be left alone %+v", later)
+	}
+
+	// owners are notified only once per review period
+	mockSMTP = nil
+	err = state.runServiceAccountReviews()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mockSMTP != nil && strings.Contains(mockSMTP.Buffer.Buffer.String(), "svc_due") {
+		t.Fatal("owners of svc_due were notified twice")
+	}
+
+	// a review by an owner restarts the review period
+	code := testPostServiceAccountForm(t, &state, reviewServiceAccountPath,
+		state.reviewServiceAccountHandler, false, url.Values{"accountname": {"svc_due"}})
+	if code != http.StatusOK {
+		t.Fatalf("review failed with %d", code)
+	}
+	due, err = getServiceAccountFromDB("svc_due", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !due.LastNotified.IsZero() || due.ReviewBy.Before(now.AddDate(0, 0, defaultServiceAccountReviewDays-1)) {
+		t.Fatalf("review did not extend the account %+v", due)
+	}
+	code = testPostServiceAccountForm(t, &state, reviewServiceAccountPath,
+		state.reviewServiceAccountHandler, false, url.Values{"accountname": {"svc_lapsed"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("disabled account review should fail, got %d", code)
+	}
+}
+
+func TestServiceAccountOwnershipTransfer(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smtpClient = func(addr string) (smtpDialer, error) {
+		return &smtpDialerMock{}, nil
+	}
+	err = insertServiceAccountInDB(serviceAccount{AccountName: "svc_owned", OwnerGroup: "group1",
+		CreatedBy: "user1", CreatedAt: time.Now(), ReviewBy: time.Now().AddDate(1, 0, 0),
+		Status: serviceAccountStatusActive}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// user2 is an owner through group1
+	code := testPostServiceAccountForm(t, &state, changeSAOwnerPath, state.changeServiceAccountOwnerHandler,
+		false, url.Values{"accountname": {"svc_owned"}, "ownerGroup": {"group3"}})
+	if code != http.StatusOK {
+		t.Fatalf("owner transfer failed with %d", code)
+	}
+	account, err := getServiceAccountFromDB("svc_owned", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.OwnerGroup != "group3" {
+		t.Fatalf("owner not changed %+v", account)
+	}
+
+	// group3 has no members left, so user2 has to take it over
+	takeoverForm := url.Values{"accountname": {"svc_owned"}, "ownerGroup": {"group1"}}
+	code = testPostServiceAccountForm(t, &state, changeSAOwnerPath, state.changeServiceAccountOwnerHandler,
+		false, takeoverForm)
+	if code != http.StatusBadRequest {
+		t.Fatalf("takeover without justification should fail, got %d", code)
+	}
+	takeoverForm.Set("justification", "owners left the company")
+	code = testPostServiceAccountForm(t, &state, changeSAOwnerPath, state.changeServiceAccountOwnerHandler,
+		false, takeoverForm)
+	if code != http.StatusOK {
+		t.Fatalf("takeover request failed with %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, changeSAOwnerPath, state.changeServiceAccountOwnerHandler,
+		false, takeoverForm)
+	if code != http.StatusBadRequest {
+		t.Fatalf("duplicate takeover request should fail, got %d", code)
+	}
+	account, err = getServiceAccountFromDB("svc_owned", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.OwnerGroup != "group3" {
+		t.Fatal("takeover must wait for an admin approval")
+	}
+	takeovers, err := getAllServiceAccountTakeoversFromDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var takeoverID string
+	for _, takeover := range takeovers {
+		if takeover.AccountName == "svc_owned" {
+			takeoverID = strconv.FormatInt(takeover.ID, 10)
+		}
+	}
+	if takeoverID == "" {
+		t.Fatal("takeover request not stored")
+	}
+	decision := url.Values{"id": {takeoverID}, "action": {"approve"}}
+	code = testPostServiceAccountForm(t, &state, serviceAccountTakeoverPath, state.serviceAccountTakeoverHandler,
+		false, decision)
+	if code != http.StatusForbidden {
+		t.Fatalf("non admins cannot approve takeovers, got %d", code)
+	}
+	code = testPostServiceAccountForm(t, &state, serviceAccountTakeoverPath, state.serviceAccountTakeoverHandler,
+		true, decision)
+	if code != http.StatusOK {
+		t.Fatalf("takeover approval failed with %d", code)
+	}
+	account, err = getServiceAccountFromDB("svc_owned", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.OwnerGroup != "group1" {
+		t.Fatalf("takeover not applied %+v", account)
+	}
+	events, err := searchAuditEventsInDB(auditEventFilter{Action: auditActionChangeServiceAccountOwner,
+		Groupname: "svc_owned"}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Actor != "user1" {
+		t.Fatalf("ownership changes not audited %+v", events)
+	}
+}
+
+func TestServiceAccountRequestApproval(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(accountname string, ownerGroup string, justification string) int {
+		return testPostServiceAccountForm(t, &state, createServiceAccountPath, state.createServiceAccounthandler,
+			false, url.Values{"AccountName": {accountname}, "mail": {"team@example.com"},
+				"loginShell": {"/bin/false"}, "ownerGroup": {ownerGroup}, "justification": {justification}})
+	}
+	if code := request("svc_requested", "requested_owners", ""); code != http.StatusBadRequest {
+		t.Fatalf("a justification is required, got %d", code)
+	}
+	if code := request("svc_requested", "group3", "deployments"); code != http.StatusBadRequest {
+		t.Fatalf("user2 does not belong to group3, got %d", code)
+	}
+	if code := request("svc_requested", "requested_owners", "deployments"); code != http.StatusOK {
+		t.Fatalf("request failed with %d", code)
+	}
+	if code := request("svc_requested", "group2", "deployments"); code != http.StatusBadRequest {
+		t.Fatalf("duplicate request should fail, got %d", code)
+	}
+	if code := request("svc_rejected", "group2", "testing"); code != http.StatusOK {
+		t.Fatalf("request failed with %d", code)
+	}
+	exists, _, err := state.Userinfo.ServiceAccountExistsornot("svc_requested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("service account was created before the approval")
+	}
+
+	requests, err := getAllServiceAccountRequestsFromDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decide := func(accountname string, action string) int {
+		for _, pending := range requests {
+			if pending.AccountName == accountname {
+				return testPostServiceAccountForm(t, &state, serviceAccountRequestPath,
+					state.serviceAccountRequestHandler, true,
+					url.Values{"id": {strconv.FormatInt(pending.ID, 10)}, "action": {action}})
+			}
+		}
+		t.Fatalf("no pending request for %s", accountname)
+		return 0
+	}
+	if code := decide("svc_requested", "approve"); code != http.StatusOK {
+		t.Fatalf("approval failed with %d", code)
+	}
+	if code := decide("svc_rejected", "reject"); code != http.StatusOK {
+		t.Fatalf("rejection failed with %d", code)
+	}
+	account, err := getServiceAccountFromDB("svc_requested", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.OwnerGroup != "requested_owners" || account.CreatedBy != "user2" {
+		t.Fatalf("bad service account %+v", account)
+	}
+	isMember, _, err := state.Userinfo.IsgroupmemberorNot("requested_owners", "user2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isMember {
+		t.Fatal("requester does not own the new owner group")
+	}
+	exists, _, err = state.Userinfo.ServiceAccountExistsornot("svc_rejected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("rejected service account was created")
+	}
+	requests, err = getAllServiceAccountRequestsFromDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("requests were not removed %+v", requests)
+	}
+}
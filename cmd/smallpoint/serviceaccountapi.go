@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serviceAccountFilter selects the service accounts returned by the API,
+// empty fields match every account.
+type serviceAccountFilter struct {
+	Query      string
+	OwnerGroup string
+	Status     string
+	// Accounts whose review date falls in [ExpiresAfter, ExpiresBefore)
+	// are selected.
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+}
+
+func (filter serviceAccountFilter) matches(account serviceAccount) bool {
+	if filter.Query != "" && !strings.Contains(strings.ToLower(account.AccountName), strings.ToLower(filter.Query)) {
+		return false
+	}
+	if filter.OwnerGroup != "" && account.OwnerGroup != filter.OwnerGroup {
+		return false
+	}
+	if filter.Status != "" && account.Status != filter.Status {
+		return false
+	}
+	if !filter.ExpiresAfter.IsZero() && account.ReviewBy.Before(filter.ExpiresAfter) {
+		return false
+	}
+	if !filter.ExpiresBefore.IsZero() && !account.ReviewBy.Before(filter.ExpiresBefore) {
+		return false
+	}
+	return true
+}
+
+// parseServiceAccountFilter reads the filter from the query string. Dates
+// are inclusive and expressed as YYYY-MM-DD, expires_within is a number of
+// days from now and includes accounts whose review already lapsed.
+func parseServiceAccountFilter(r *http.Request) (serviceAccountFilter, error) {
+	q := r.URL.Query()
+	filter := serviceAccountFilter{
+		Query:      strings.TrimSpace(q.Get("q")),
+		OwnerGroup: strings.TrimSpace(q.Get("owner")),
+		Status:     q.Get("status"),
+	}
+	switch filter.Status {
+	case "", serviceAccountStatusActive, serviceAccountStatusDisabled, serviceAccountStatusPendingDeletion:
+	default:
+		return filter, fmt.Errorf("invalid status '%s'", filter.Status)
+	}
+	var err error
+	if after := q.Get("expires_after"); after != "" {
+		filter.ExpiresAfter, err = time.ParseInLocation(auditDateLayout, after, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid expires_after date '%s'", after)
+		}
+	}
+	if before := q.Get("expires_before"); before != "" {
+		filter.ExpiresBefore, err = time.ParseInLocation(auditDateLayout, before, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid expires_before date '%s'", before)
+		}
+		filter.ExpiresBefore = filter.ExpiresBefore.AddDate(0, 0, 1)
+	}
+	if within := q.Get("expires_within"); within != "" {
+		days, err := strconv.Atoi(within)
+		if err != nil || days < 0 {
+			return filter, fmt.Errorf("invalid expires_within '%s'", within)
+		}
+		if !filter.ExpiresBefore.IsZero() {
+			return filter, fmt.Errorf("expires_within cannot be combined with expires_before")
+		}
+		filter.ExpiresBefore = time.Now().AddDate(0, 0, days)
+	}
+	return filter, nil
+}
+
+type serviceAccountAPIEntry struct {
+	AccountName string
+	OwnerGroup  string
+	Mail        string
+	CreatedBy   string
+	CreatedAt   time.Time
+	ReviewBy    time.Time
+	Status      string
+	Metadata    serviceAccountMetadata
+}
+
+type serviceAccountsAPIResponse struct {
+	ServiceAccounts []serviceAccountAPIEntry
+}
+
+// serviceAccountsAPIHandler lists the service accounts for inventory
+// tooling. Auditors see every account, other users see the accounts owned by
+// their groups.
+func (state *RuntimeState) serviceAccountsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	filter, err := parseServiceAccountFilter(r)
+	if err != nil {
+		state.writeFailureResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	isAuditor, err := state.isAuditor(username)
+	if err != nil {
+		requestLogger(r).Error("serviceAccountsAPIHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	accounts, err := getAllServiceAccountsFromDB(state)
+	if err != nil {
+		requestLogger(r).Error("serviceAccountsAPIHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	response := serviceAccountsAPIResponse{ServiceAccounts: []serviceAccountAPIEntry{}}
+	for _, account := range accounts {
+		if !filter.matches(account) {
+			continue
+		}
+		if !isAuditor {
+			canManage, err := state.canManageServiceAccount(username, account)
+			if err != nil {
+				requestLogger(r).Error("serviceAccountsAPIHandler failed", "err", err)
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			if !canManage {
+				continue
+			}
+		}
+		metadata, err := getServiceAccountMetadataFromDB(account.AccountName, state)
+		if err != nil {
+			requestLogger(r).Error("serviceAccountsAPIHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		response.ServiceAccounts = append(response.ServiceAccounts, serviceAccountAPIEntry{
+			AccountName: account.AccountName,
+			OwnerGroup:  account.OwnerGroup,
+			Mail:        account.Mail,
+			CreatedBy:   account.CreatedBy,
+			CreatedAt:   account.CreatedAt,
+			ReviewBy:    account.ReviewBy,
+			Status:      account.Status,
+			Metadata:    metadata,
+		})
+	}
+	b, err := json.Marshal(response)
+	if err != nil {
+		requestLogger(r).Error("Failed marshal", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(b)
+	if err != nil {
+		requestLogger(r).Error("Incomplete write", "err", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testGetServiceAccountsAPI(t *testing.T, state *RuntimeState, admin bool, query string) (int, []serviceAccountAPIEntry) {
+	req, err := http.NewRequest("GET", serviceAccountsAPIPath+"?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	if admin {
+		cookie = testCreateValidAdminCookie(state.authenticator)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.serviceAccountsAPIHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		return rr.Code, nil
+	}
+	var response serviceAccountsAPIResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, response.ServiceAccounts
+}
+
+func TestServiceAccountsAPI(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCreateRotatableServiceAccount(t, &state, "svc_inventory_a")
+	err = insertServiceAccountInDB(serviceAccount{AccountName: "svc_inventory_b", OwnerGroup: "group3",
+		CreatedBy: "user1", CreatedAt: time.Now(), ReviewBy: time.Now().AddDate(0, 0, 5),
+		Status: serviceAccountStatusDisabled}, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().AddDate(0, 0, 5).Format(auditDateLayout)
+	queries := []struct {
+		admin    bool
+		query    string
+		expected []string
+	}{
+		{true, "q=svc_inventory", []string{"svc_inventory_a", "svc_inventory_b"}},
+		{false, "q=svc_inventory", []string{"svc_inventory_a"}},
+		{true, "q=INVENTORY&owner=group3", []string{"svc_inventory_b"}},
+		{true, "q=svc_inventory&status=active", []string{"svc_inventory_a"}},
+		{true, "q=svc_inventory&expires_within=30", []string{"svc_inventory_b"}},
+		{true, "q=svc_inventory&expires_after=" + expires + "&expires_before=" + expires, []string{"svc_inventory_b"}},
+		{true, "q=svc_inventory&owner=group2", []string{}},
+	}
+	for _, test := range queries {
+		code, entries := testGetServiceAccountsAPI(t, &state, test.admin, test.query)
+		if code != http.StatusOK {
+			t.Fatalf("query %s failed with %d", test.query, code)
+		}
+		if len(entries) != len(test.expected) {
+			t.Fatalf("query %s returned %+v", test.query, entries)
+		}
+		for i, entry := range entries {
+			if entry.AccountName != test.expected[i] {
+				t.Fatalf("query %s returned %+v", test.query, entries)
+			}
+		}
+	}
+	for _, query := range []string{"status=gone", "expires_within=-1", "expires_after=tomorrow",
+		"expires_within=3&expires_before=" + expires} {
+		code, _ := testGetServiceAccountsAPI(t, &state, true, query)
+		if code != http.StatusBadRequest {
+			t.Errorf("query %s should fail, got %d", query, code)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Service accounts created before smallpoint tracked them exist in LDAP
+// only. They are imported from a CSV file with a header row, accountname
+// and owner_group are required. When the owner group does not exist it is
+// created as a self-managed group of the owners, a space separated list of
+// usernames.
+
+const maxServiceAccountImportSize = 4 << 20
+
+var serviceAccountImportColumns = []string{"accountname", "owner_group", "owners", "mail", "purpose", "team",
+	"cost_center", "ticket"}
+
+const (
+	importOutcomeImported = "imported"
+	importOutcomeSkipped  = "skipped"
+	importOutcomeFailed   = "failed"
+)
+
+type serviceAccountImportResult struct {
+	Line        int
+	AccountName string
+	Outcome     string
+	Message     string
+}
+
+type serviceAccountImportReport struct {
+	Imported int
+	Skipped  int
+	Failed   int
+	Results  []serviceAccountImportResult
+}
+
+func (report *serviceAccountImportReport) add(result serviceAccountImportResult) {
+	switch result.Outcome {
+	case importOutcomeImported:
+		report.Imported++
+	case importOutcomeSkipped:
+		report.Skipped++
+	default:
+		report.Failed++
+	}
+	report.Results = append(report.Results, result)
+}
+
+// createServiceAccountOwnerGroup creates a self-managed owner group with
+// the owners as members.
+func (state *RuntimeState) createServiceAccountOwnerGroup(r *http.Request, actor string, groupname string,
+	owners []string, details string) error {
+	if len(owners) == 0 {
+		return fmt.Errorf("owner group %s does not exist and no owners were given", groupname)
+	}
+	for _, owner := range owners {
+		exists, err := state.requestUserinfo(r).UsernameExistsornot(owner)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("owner %s does not exist", owner)
+		}
+	}
+	err := state.createLDAPGroup(r, userinfo.GroupInfo{Groupname: groupname, Description: descriptionAttribute,
+		MemberUid: owners}, actor, nil)
+	if err != nil {
+		state.recordAuditEvent(r, actor, auditActionCreateGroup, groupname, "", auditOutcomeFailure, err.Error())
+		return err
+	}
+	state.recordAuditEvent(r, actor, auditActionCreateGroup, groupname, "", auditOutcomeSuccess,
+		"managed by "+descriptionAttribute+", "+details)
+	for _, owner := range owners {
+		state.recordAuditEvent(r, actor, auditActionAddMember, groupname, owner, auditOutcomeSuccess, "")
+	}
+	return nil
+}
+
+func (state *RuntimeState) importServiceAccount(r *http.Request, actor string, record map[string]string) (string, string, error) {
+	accountname := record["accountname"]
+	ownerGroup := record["owner_group"]
+	if accountname == "" || ownerGroup == "" {
+		return importOutcomeFailed, "accountname and owner_group are required", nil
+	}
+	exists, _, err := state.requestUserinfo(r).ServiceAccountExistsornot(accountname)
+	if err != nil {
+		return "", "", err
+	}
+	if !exists {
+		return importOutcomeFailed, "service account does not exist in LDAP", nil
+	}
+	_, err = getServiceAccountFromDB(accountname, state)
+	if err == nil {
+		return importOutcomeSkipped, "service account is already managed", nil
+	}
+	if err != sql.ErrNoRows {
+		return "", "", err
+	}
+	metadata := serviceAccountMetadata{Purpose: record["purpose"], Team: record["team"],
+		CostCenter: record["cost_center"], Ticket: record["ticket"], UpdatedBy: actor, UpdatedAt: time.Now()}
+	for _, value := range []string{metadata.Purpose, metadata.Team, metadata.CostCenter, metadata.Ticket} {
+		if len(value) > serviceAccountMetadataMaxLength {
+			return importOutcomeFailed, fmt.Sprintf("metadata is longer than %d characters",
+				serviceAccountMetadataMaxLength), nil
+		}
+	}
+	message := "imported"
+	ownerGroupExists, _, err := state.requestUserinfo(r).GroupnameExistsornot(ownerGroup)
+	if err != nil {
+		return "", "", err
+	}
+	if !ownerGroupExists {
+		err = state.createServiceAccountOwnerGroup(r, actor, ownerGroup, strings.Fields(record["owners"]),
+			"service account import")
+		if err != nil {
+			return importOutcomeFailed, err.Error(), nil
+		}
+		message = "imported, created owner group " + ownerGroup
+	}
+	now := time.Now()
+	account := serviceAccount{
+		AccountName: accountname,
+		OwnerGroup:  ownerGroup,
+		Mail:        record["mail"],
+		CreatedBy:   actor,
+		CreatedAt:   now,
+		ReviewBy:    now.AddDate(0, 0, state.Config.ServiceAccounts.reviewPeriod()),
+		Status:      serviceAccountStatusActive,
+	}
+	err = insertServiceAccountInDB(account, state)
+	if err != nil {
+		return "", "", err
+	}
+	if metadata.Purpose != "" || metadata.Team != "" || metadata.CostCenter != "" || metadata.Ticket != "" {
+		err = setServiceAccountMetadataInDB(accountname, metadata, state)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	state.recordAuditEvent(r, actor, auditActionImportServiceAccount, accountname, accountname,
+		auditOutcomeSuccess, "owner "+ownerGroup)
+	return importOutcomeImported, message, nil
+}
+
+// importServiceAccounts imports every row of the CSV, a bad row does not
+// stop the import. An error is returned when the CSV itself is invalid.
+func (state *RuntimeState) importServiceAccounts(r *http.Request, actor string, input io.Reader) (serviceAccountImportReport, error) {
+	var report serviceAccountImportReport
+	csvReader := csv.NewReader(input)
+	csvReader.TrimLeadingSpace = true
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err != nil {
+		return report, fmt.Errorf("cannot read the CSV header: %s", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"accountname", "owner_group"} {
+		if _, ok := columns[required]; !ok {
+			return report, fmt.Errorf("the CSV header has no %s column", required)
+		}
+	}
+	for line := 2; ; line++ {
+		fields, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		record := make(map[string]string)
+		for _, name := range serviceAccountImportColumns {
+			if i, ok := columns[name]; ok && i < len(fields) {
+				record[name] = strings.TrimSpace(fields[i])
+			}
+		}
+		outcome, message, err := state.importServiceAccount(r, actor, record)
+		if err != nil {
+			requestLogger(r).Error("cannot import the service account", "account", record["accountname"], "err", err)
+			outcome = importOutcomeFailed
+			message = "internal error"
+			state.recordAuditEvent(r, actor, auditActionImportServiceAccount, record["accountname"],
+				record["accountname"], auditOutcomeFailure, err.Error())
+		}
+		report.add(serviceAccountImportResult{Line: line, AccountName: record["accountname"],
+			Outcome: outcome, Message: message})
+	}
+	return report, nil
+}
+
+func (state *RuntimeState) importServiceAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	pageData := serviceAccountImportPageData{
+		UserName: username,
+		IsAdmin:  true,
+		Title:    "Import Service Accounts",
+	}
+	switch r.Method {
+	case getMethod:
+	case postMethod:
+		var input io.Reader = http.MaxBytesReader(w, r.Body, maxServiceAccountImportSize)
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			err = r.ParseMultipartForm(maxServiceAccountImportSize)
+			if err != nil {
+				state.writeFailureResponse(w, r, fmt.Sprint(err), http.StatusBadRequest)
+				return
+			}
+			file, _, err := r.FormFile("csv")
+			if err != nil {
+				state.writeFailureResponse(w, r, "a csv file is required", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			input = file
+		}
+		report, err := state.importServiceAccounts(r, username, input)
+		if err != nil {
+			state.writeFailureResponse(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pageData.Report = &report
+	default:
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	state.renderTemplateOrReturnJson(w, r, "serviceAccountImportPage", pageData)
+}
+
+// importServiceAccountsCommand implements the import-serviceaccounts
+// command, it returns the process exit code.
+func importServiceAccountsCommand(state *RuntimeState, filename string) int {
+	if filename == "" {
+		fmt.Fprintf(os.Stderr, "import-serviceaccounts requires a CSV file\n")
+		return 2
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open %s: %s\n", filename, err)
+		return 1
+	}
+	defer file.Close()
+	actor := "smallpoint"
+	if currentUser, err := user.Current(); err == nil {
+		actor = currentUser.Username
+	}
+	report, err := state.importServiceAccounts(nil, actor, file)
+	for _, result := range report.Results {
+		fmt.Printf("line %d %s: %s %s\n", result.Line, result.AccountName, result.Outcome, result.Message)
+	}
+	fmt.Printf("imported=%d skipped=%d failed=%d\n", report.Imported, report.Skipped, report.Failed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import FAILED: %s\n", err)
+		return 1
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func testImportServiceAccounts(t *testing.T, state *RuntimeState, admin bool, csvData string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", importServiceAccountsPath, strings.NewReader(csvData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	if admin {
+		cookie = testCreateValidAdminCookie(state.authenticator)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.importServiceAccountsHandler).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestImportServiceAccounts(t *testing.T) {
+	state, err := setupTestStateWithOwnDB(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, accountname := range []string{"svc_legacy1", "svc_legacy2"} {
+		err = state.Userinfo.CreateServiceAccount(userinfo.GroupInfo{Groupname: accountname,
+			Mail: "legacy@example.com", LoginShell: "/bin/false"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	csvData := `accountname,owner_group,owners,mail,purpose,team,cost_center,ticket
+svc_legacy1,group1,,legacy@example.com,ci builds,release,CC-1,OPS-1
+svc_legacy2,legacy_owners,user2 user3,,,,,
+svc_missing,group1,,,,,,
+svc_legacy1,group1,,,,,,
+`
+	if rr := testImportServiceAccounts(t, &state, false, csvData); rr.Code != http.StatusForbidden {
+		t.Fatalf("only admins import, got %d", rr.Code)
+	}
+	if rr := testImportServiceAccounts(t, &state, true, "name,group\nsvc_legacy1,group1\n"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid header should fail, got %d", rr.Code)
+	}
+	rr := testImportServiceAccounts(t, &state, true, csvData)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("import failed with %d", rr.Code)
+	}
+	var pageData serviceAccountImportPageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := pageData.Report
+	if report == nil || report.Imported != 2 || report.Skipped != 1 || report.Failed != 1 {
+		t.Fatalf("bad import report %+v", report)
+	}
+	if report.Results[2].AccountName != "svc_missing" || report.Results[2].Outcome != importOutcomeFailed {
+		t.Fatalf("bad result %+v", report.Results[2])
+	}
+
+	account, err := getServiceAccountFromDB("svc_legacy2", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.OwnerGroup != "legacy_owners" || !account.IsActive() {
+		t.Fatalf("bad imported account %+v", account)
+	}
+	isMember, _, err := state.Userinfo.IsgroupmemberorNot("legacy_owners", "user3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isMember {
+		t.Fatal("owner group was not created with the owners")
+	}
+	metadata, err := getServiceAccountMetadataFromDB("svc_legacy1", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Purpose != "ci builds" || metadata.Ticket != "OPS-1" || metadata.UpdatedBy != "user1" {
+		t.Fatalf("bad imported metadata %+v", metadata)
+	}
+}
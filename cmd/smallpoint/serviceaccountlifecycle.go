@@ -0,0 +1,524 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Owners ask for a service account to be disabled or deleted and an admin
+// approves it, admins act directly. Disabling locks the account in LDAP and
+// keeps it. Deleting also locks it and removes it from every group, the LDAP
+// entries are only removed once the grace period is over so that an admin
+// can still restore the account.
+
+const (
+	serviceAccountStatusPendingDeletion = "pending_deletion"
+
+	serviceAccountActionDisable = "disable"
+	serviceAccountActionDelete  = "delete"
+
+	defaultServiceAccountDeletionGraceDays = 30
+)
+
+var errLifecycleAlreadyRequested = errors.New("a request for this service account is already pending")
+
+func (config serviceAccountConfig) deletionGracePeriod() int {
+	if config.DeletionGraceDays > 0 {
+		return config.DeletionGraceDays
+	}
+	return defaultServiceAccountDeletionGraceDays
+}
+
+type serviceAccountLifecycleRequest struct {
+	ID            int64
+	AccountName   string
+	Action        string
+	RequestedBy   string
+	Justification string
+	Timestamp     time.Time
+}
+
+type serviceAccountDeletion struct {
+	AccountName string
+	ScheduledBy string
+	DeleteAfter time.Time
+}
+
+var insertServiceAccountLifecycleRequestStmt = map[string]string{
+	"sqlite":   "insert into service_account_lifecycle_requests(accountname, action, requested_by, justification, time_stamp) values (?,?,?,?,?);",
+	"postgres": "insert into service_account_lifecycle_requests(accountname, action, requested_by, justification, time_stamp) values ($1,$2,$3,$4,$5);",
+}
+
+var getServiceAccountLifecycleRequestStmt = map[string]string{
+	"sqlite":   "select id, accountname, action, requested_by, justification, time_stamp from service_account_lifecycle_requests where id=?;",
+	"postgres": "select id, accountname, action, requested_by, justification, time_stamp from service_account_lifecycle_requests where id=$1;",
+}
+
+var getAllServiceAccountLifecycleRequestsStmt = "select id, accountname, action, requested_by, justification, time_stamp from service_account_lifecycle_requests order by id;"
+
+var pendingServiceAccountLifecycleRequestExistsStmt = map[string]string{
+	"sqlite":   "select count(*) from service_account_lifecycle_requests where accountname=?;",
+	"postgres": "select count(*) from service_account_lifecycle_requests where accountname=$1;",
+}
+
+var deleteServiceAccountLifecycleRequestsStmt = map[string]string{
+	"sqlite":   "delete from service_account_lifecycle_requests where accountname=?;",
+	"postgres": "delete from service_account_lifecycle_requests where accountname=$1;",
+}
+
+var insertServiceAccountDeletionStmt = map[string]string{
+	"sqlite":   "insert into service_account_deletions(accountname, scheduled_by, delete_after) values (?,?,?);",
+	"postgres": "insert into service_account_deletions(accountname, scheduled_by, delete_after) values ($1,$2,$3);",
+}
+
+var getAllServiceAccountDeletionsStmt = "select accountname, scheduled_by, delete_after from service_account_deletions order by delete_after;"
+
+var getDueServiceAccountDeletionsStmt = map[string]string{
+	"sqlite":   "select accountname, scheduled_by, delete_after from service_account_deletions where delete_after < ? order by delete_after;",
+	"postgres": "select accountname, scheduled_by, delete_after from service_account_deletions where delete_after < $1 order by delete_after;",
+}
+
+var deleteServiceAccountDeletionStmt = map[string]string{
+	"sqlite":   "delete from service_account_deletions where accountname=?;",
+	"postgres": "delete from service_account_deletions where accountname=$1;",
+}
+
+var deleteServiceAccountMetadataStmt = map[string]string{
+	"sqlite":   "delete from service_account_metadata where accountname=?;",
+	"postgres": "delete from service_account_metadata where accountname=$1;",
+}
+
+var deleteServiceAccountStmt = map[string]string{
+	"sqlite":   "delete from service_accounts where accountname=?;",
+	"postgres": "delete from service_accounts where accountname=$1;",
+}
+
+func scanServiceAccountLifecycleRequest(row sqlRowScanner) (serviceAccountLifecycleRequest, error) {
+	var request serviceAccountLifecycleRequest
+	var timeStamp int64
+	err := row.Scan(&request.ID, &request.AccountName, &request.Action, &request.RequestedBy,
+		&request.Justification, &timeStamp)
+	request.Timestamp = time.Unix(timeStamp, 0)
+	return request, err
+}
+
+func getServiceAccountLifecycleRequestFromDB(id int64, state *RuntimeState) (serviceAccountLifecycleRequest, error) {
+	start := time.Now()
+	request, err := scanServiceAccountLifecycleRequest(
+		state.db.QueryRow(getServiceAccountLifecycleRequestStmt[state.dbType], id))
+	if err != nil {
+		return request, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return request, nil
+}
+
+func getAllServiceAccountLifecycleRequestsFromDB(state *RuntimeState) ([]serviceAccountLifecycleRequest, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getAllServiceAccountLifecycleRequestsStmt)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var requests []serviceAccountLifecycleRequest
+	for rows.Next() {
+		request, err := scanServiceAccountLifecycleRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+// insertServiceAccountLifecycleRequestInDB allows a single pending request
+// per account.
+func (state *RuntimeState) insertServiceAccountLifecycleRequestInDB(request serviceAccountLifecycleRequest) error {
+	var count int
+	err := state.db.QueryRow(pendingServiceAccountLifecycleRequestExistsStmt[state.dbType],
+		request.AccountName).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errLifecycleAlreadyRequested
+	}
+	return execServiceAccountUpdate(state, insertServiceAccountLifecycleRequestStmt[state.dbType],
+		request.AccountName, request.Action, request.RequestedBy, request.Justification,
+		request.Timestamp.Unix())
+}
+
+func queryServiceAccountDeletionsFromDB(state *RuntimeState, stmtText string,
+	args ...interface{}) ([]serviceAccountDeletion, error) {
+	start := time.Now()
+	rows, err := state.db.Query(stmtText, args...)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var deletions []serviceAccountDeletion
+	for rows.Next() {
+		var deletion serviceAccountDeletion
+		var deleteAfter int64
+		err = rows.Scan(&deletion.AccountName, &deletion.ScheduledBy, &deleteAfter)
+		if err != nil {
+			return nil, err
+		}
+		deletion.DeleteAfter = time.Unix(deleteAfter, 0)
+		deletions = append(deletions, deletion)
+	}
+	return deletions, rows.Err()
+}
+
+func getAllServiceAccountDeletionsFromDB(state *RuntimeState) ([]serviceAccountDeletion, error) {
+	return queryServiceAccountDeletionsFromDB(state, getAllServiceAccountDeletionsStmt)
+}
+
+// disableServiceAccount locks the account in LDAP and marks it as disabled.
+func (state *RuntimeState) disableServiceAccount(r *http.Request, actor string, account serviceAccount,
+	details string) error {
+	err := state.requestUserinfo(r).DisableServiceAccount(account.AccountName)
+	if err != nil {
+		state.recordAuditEvent(r, actor, auditActionDisableServiceAccount, account.AccountName,
+			account.AccountName, auditOutcomeFailure, err.Error())
+		return err
+	}
+	err = execServiceAccountUpdate(state, updateServiceAccountStatusStmt[state.dbType],
+		serviceAccountStatusDisabled, account.AccountName)
+	if err != nil {
+		return err
+	}
+	state.recordAuditEvent(r, actor, auditActionDisableServiceAccount, account.AccountName,
+		account.AccountName, auditOutcomeSuccess, details)
+	if state.sysLog != nil {
+		state.sysLog.Write([]byte(fmt.Sprintf("Service account %s was disabled by %s, %s",
+			account.AccountName, actor, details)))
+	}
+	return nil
+}
+
+// removeServiceAccountMemberships removes the account from every group it
+// belongs to.
+func (state *RuntimeState) removeServiceAccountMemberships(r *http.Request, actor string,
+	accountname string) error {
+	groups, err := state.requestUserinfo(r).GetgroupsofUser(accountname)
+	if err != nil {
+		return err
+	}
+	for _, groupname := range groups {
+		err = state.requestUserinfo(r).DeletemembersfromGroup(userinfo.GroupInfo{Groupname: groupname,
+			MemberUid: []string{accountname}})
+		if err != nil {
+			state.recordAuditEvent(r, actor, auditActionRemoveMember, groupname, accountname,
+				auditOutcomeFailure, err.Error())
+			return err
+		}
+		state.recordAuditEvent(r, actor, auditActionRemoveMember, groupname, accountname,
+			auditOutcomeSuccess, "service account scheduled for deletion")
+	}
+	return nil
+}
+
+// scheduleServiceAccountDeletion disables the account, removes its group
+// memberships and schedules the removal of its LDAP entries.
+func (state *RuntimeState) scheduleServiceAccountDeletion(r *http.Request, actor string,
+	account serviceAccount, details string) (time.Time, error) {
+	deleteAfter := time.Now().AddDate(0, 0, state.Config.ServiceAccounts.deletionGracePeriod())
+	if account.Status != serviceAccountStatusDisabled {
+		err := state.disableServiceAccount(r, actor, account, details)
+		if err != nil {
+			return deleteAfter, err
+		}
+	}
+	err := state.removeServiceAccountMemberships(r, actor, account.AccountName)
+	if err != nil {
+		return deleteAfter, err
+	}
+	err = execServiceAccountUpdate(state, insertServiceAccountDeletionStmt[state.dbType],
+		account.AccountName, actor, deleteAfter.Unix())
+	if err != nil {
+		return deleteAfter, err
+	}
+	err = execServiceAccountUpdate(state, updateServiceAccountStatusStmt[state.dbType],
+		serviceAccountStatusPendingDeletion, account.AccountName)
+	if err != nil {
+		return deleteAfter, err
+	}
+	state.recordAuditEvent(r, actor, auditActionScheduleServiceAccountDeletion, account.AccountName,
+		account.AccountName, auditOutcomeSuccess,
+		fmt.Sprintf("delete after %s, %s", deleteAfter.Format(auditDateLayout), details))
+	return deleteAfter, nil
+}
+
+// applyServiceAccountAction disables or deletes the account and notifies its
+// owners, it returns the message shown to the user.
+func (state *RuntimeState) applyServiceAccountAction(r *http.Request, actor string, account serviceAccount,
+	action string, details string) (string, error) {
+	var message string
+	switch action {
+	case serviceAccountActionDisable:
+		err := state.disableServiceAccount(r, actor, account, details)
+		if err != nil {
+			return "", err
+		}
+		message = fmt.Sprintf("Service account %s was disabled", account.AccountName)
+	case serviceAccountActionDelete:
+		deleteAfter, err := state.scheduleServiceAccountDeletion(r, actor, account, details)
+		if err != nil {
+			return "", err
+		}
+		message = fmt.Sprintf("Service account %s was disabled and removed from its groups, it will be deleted after %s",
+			account.AccountName, deleteAfter.Format(auditDateLayout))
+	default:
+		return "", fmt.Errorf("invalid service account action '%s'", action)
+	}
+	err := state.sendServiceAccountEmail(account, "Service account "+account.AccountName,
+		message+" by "+actor+".\n")
+	if err != nil {
+		requestLogger(r).Error("cannot notify the owners of the service account", "account", account.AccountName, "err", err)
+	}
+	return message, nil
+}
+
+// runServiceAccountDeletions removes the accounts whose grace period is over.
+func (state *RuntimeState) runServiceAccountDeletions() error {
+	deletions, err := queryServiceAccountDeletionsFromDB(state, getDueServiceAccountDeletionsStmt[state.dbType],
+		time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	for _, deletion := range deletions {
+		details := "scheduled by " + deletion.ScheduledBy
+		// an account removed from the directory by hand is deleted too
+		err = state.Userinfo.DeleteServiceAccount(deletion.AccountName)
+		if err == userinfo.UserDoesNotExist {
+			details += ", already removed from the directory"
+		} else if err != nil {
+			slog.Error("cannot delete the service account", "account", deletion.AccountName, "err", err)
+			state.recordAuditEvent(nil, "smallpoint", auditActionDeleteServiceAccount, deletion.AccountName,
+				deletion.AccountName, auditOutcomeFailure, err.Error())
+			continue
+		}
+		for _, stmt := range []map[string]string{deleteServiceAccountDeletionStmt, deleteServiceAccountStmt,
+			deleteServiceAccountLifecycleRequestsStmt, deleteServiceAccountMetadataStmt} {
+			err = execServiceAccountUpdate(state, stmt[state.dbType], deletion.AccountName)
+			if err != nil {
+				return err
+			}
+		}
+		state.recordAuditEvent(nil, "smallpoint", auditActionDeleteServiceAccount, deletion.AccountName,
+			deletion.AccountName, auditOutcomeSuccess, details)
+		if state.sysLog != nil {
+			state.sysLog.Write([]byte(fmt.Sprintf("Service account %s was deleted, it was scheduled by %s",
+				deletion.AccountName, deletion.ScheduledBy)))
+		}
+	}
+	return nil
+}
+
+// serviceAccountLifecycleHandler disables or deletes a service account when
+// done by an admin and files a request for an admin otherwise. Admins also
+// restore accounts pending deletion, their group memberships are not
+// restored.
+func (state *RuntimeState) serviceAccountLifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("serviceAccountLifecycleHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	accountName := strings.TrimSpace(r.PostFormValue("accountname"))
+	action := r.PostFormValue("action")
+	justification := strings.TrimSpace(r.PostFormValue("justification"))
+	if action != serviceAccountActionDisable && action != serviceAccountActionDelete && action != "restore" {
+		state.writeFailureResponse(w, r, "action must be disable, delete or restore", http.StatusBadRequest)
+		return
+	}
+	account, ok := state.getManageableServiceAccount(w, r, username, accountName)
+	if !ok {
+		return
+	}
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
+	pageData := simpleMessagePageData{
+		UserName:    username,
+		IsAdmin:     isAdmin,
+		Title:       "Service Account " + accountName,
+		ContinueURL: serviceAccountsPath,
+	}
+	if action == "restore" {
+		if !isAdmin {
+			http.Error(w, "you are not authorized", http.StatusForbidden)
+			return
+		}
+		if account.Status != serviceAccountStatusPendingDeletion {
+			state.writeFailureResponse(w, r, "service account is not pending deletion", http.StatusBadRequest)
+			return
+		}
+		err = execServiceAccountUpdate(state, deleteServiceAccountDeletionStmt[state.dbType], accountName)
+		if err == nil {
+			err = execServiceAccountUpdate(state, updateServiceAccountStatusStmt[state.dbType],
+				serviceAccountStatusDisabled, accountName)
+		}
+		if err != nil {
+			requestLogger(r).Error("serviceAccountLifecycleHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		state.recordAuditEvent(r, username, auditActionRestoreServiceAccount, accountName, accountName,
+			auditOutcomeSuccess, justification)
+		pageData.SuccessMessage = fmt.Sprintf("Service account %s will not be deleted, it stays disabled", accountName)
+		state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+		return
+	}
+	if account.Status == serviceAccountStatusPendingDeletion ||
+		(action == serviceAccountActionDisable && !account.IsActive()) {
+		state.writeFailureResponse(w, r, fmt.Sprintf("service account is already %s", account.Status), http.StatusBadRequest)
+		return
+	}
+	if justification == "" {
+		state.writeFailureResponse(w, r, "a justification is required", http.StatusBadRequest)
+		return
+	}
+	if isAdmin {
+		pageData.SuccessMessage, err = state.applyServiceAccountAction(r, username, account, action, justification)
+		if err != nil {
+			requestLogger(r).Error("serviceAccountLifecycleHandler failed", "err", err)
+			state.writeFailureResponse(w, r, "cannot "+action+" the service account", http.StatusInternalServerError)
+			return
+		}
+		state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+		return
+	}
+
+	request := serviceAccountLifecycleRequest{AccountName: accountName, Action: action, RequestedBy: username,
+		Justification: justification, Timestamp: time.Now()}
+	err = state.insertServiceAccountLifecycleRequestInDB(request)
+	if err != nil {
+		if err == errLifecycleAlreadyRequested {
+			state.writeFailureResponse(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requestLogger(r).Error("serviceAccountLifecycleHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.recordAuditEvent(r, username, auditActionRequestServiceAccountAction, accountName, username,
+		auditOutcomeSuccess, action+": "+justification)
+	adminsEmail := state.getAdminsEmail()
+	if len(adminsEmail) > 0 {
+		go state.sendEmailWithAttachments(adminsEmail, "Service account "+accountName,
+			fmt.Sprintf("User %s asked to %s service account %s.\nJustification: %s\nPlease review it at %s%s\n",
+				username, action, accountName, justification, state.Config.Base.Hostname, serviceAccountsPath), nil)
+	}
+	pageData.SuccessMessage = fmt.Sprintf("Your request to %s %s is waiting for an admin approval", action, accountName)
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
+
+// serviceAccountLifecycleApprovalHandler is used by admins to approve or
+// reject a disable or delete request.
+func (state *RuntimeState) serviceAccountLifecycleApprovalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("serviceAccountLifecycleApprovalHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		state.writeFailureResponse(w, r, "invalid request id", http.StatusBadRequest)
+		return
+	}
+	decision := r.PostFormValue("action")
+	if decision != "approve" && decision != "reject" {
+		state.writeFailureResponse(w, r, "action must be approve or reject", http.StatusBadRequest)
+		return
+	}
+	request, err := getServiceAccountLifecycleRequestFromDB(id, state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			state.writeFailureResponse(w, r, "request not found", http.StatusNotFound)
+			return
+		}
+		requestLogger(r).Error("serviceAccountLifecycleApprovalHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	message := fmt.Sprintf("The request to %s service account %s was rejected", request.Action, request.AccountName)
+	if decision == "approve" {
+		account, err := getServiceAccountFromDB(request.AccountName, state)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				state.writeFailureResponse(w, r, "service account not found", http.StatusNotFound)
+				return
+			}
+			requestLogger(r).Error("serviceAccountLifecycleApprovalHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		message, err = state.applyServiceAccountAction(r, username, account, request.Action,
+			fmt.Sprintf("requested by %s: %s", request.RequestedBy, request.Justification))
+		if err != nil {
+			requestLogger(r).Error("serviceAccountLifecycleApprovalHandler failed", "err", err)
+			state.writeFailureResponse(w, r, "cannot "+request.Action+" the service account", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		state.recordAuditEvent(r, username, auditActionRejectServiceAccountAction, request.AccountName,
+			request.RequestedBy, auditOutcomeSuccess, request.Action)
+	}
+	err = execServiceAccountUpdate(state, deleteServiceAccountLifecycleRequestsStmt[state.dbType], request.AccountName)
+	if err != nil {
+		requestLogger(r).Error("serviceAccountLifecycleApprovalHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	requesterEmail, err := state.requestUserinfo(r).GetEmailofauser(request.RequestedBy)
+	if err != nil && err != userinfo.UserDoesNotExist {
+		requestLogger(r).Error("serviceAccountLifecycleApprovalHandler failed", "err", err)
+	}
+	if len(requesterEmail) > 0 {
+		go state.sendEmailWithAttachments(requesterEmail, "Service account "+request.AccountName,
+			message+", reviewed by "+username+".\n", nil)
+	}
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        true,
+		Title:          "Service Account " + request.AccountName,
+		SuccessMessage: message,
+		ContinueURL:    serviceAccountsPath,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestServiceAccountDeletionLifecycle(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	smtpClient = func(addr string) (smtpDialer, error) {
+		return &smtpDialerMock{}, nil
+	}
+	testCreateRotatableServiceAccount(t, &state, "svc_lifecycle")
+	err = state.Userinfo.AddmemberstoExisting(userinfo.GroupInfo{Groupname: "group2",
+		MemberUid: []string{"svc_lifecycle"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lifecycle := func(admin bool, action string, justification string) int {
+		return testPostServiceAccountForm(t, &state, serviceAccountLifecyclePath, state.serviceAccountLifecycleHandler,
+			admin, url.Values{"accountname": {"svc_lifecycle"}, "action": {action}, "justification": {justification}})
+	}
+	if code := lifecycle(false, serviceAccountActionDelete, ""); code != http.StatusBadRequest {
+		t.Fatalf("a justification is required, got %d", code)
+	}
+	if code := lifecycle(false, serviceAccountActionDelete, "project ended"); code != http.StatusOK {
+		t.Fatalf("delete request failed with %d", code)
+	}
+	if code := lifecycle(false, serviceAccountActionDisable, "project ended"); code != http.StatusBadRequest {
+		t.Fatalf("a request is already pending, got %d", code)
+	}
+	requests, err := getAllServiceAccountLifecycleRequestsFromDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request serviceAccountLifecycleRequest
+	for _, pending := range requests {
+		if pending.AccountName == "svc_lifecycle" {
+			request = pending
+		}
+	}
+	if request.RequestedBy != "user2" || request.Action != serviceAccountActionDelete {
+		t.Fatalf("bad lifecycle request %+v", request)
+	}
+	approve := url.Values{"id": {strconv.FormatInt(request.ID, 10)}, "action": {"approve"}}
+	if code := testPostServiceAccountForm(t, &state, serviceAccountApprovalPath,
+		state.serviceAccountLifecycleApprovalHandler, false, approve); code != http.StatusForbidden {
+		t.Fatalf("only admins approve, got %d", code)
+	}
+	if code := testPostServiceAccountForm(t, &state, serviceAccountApprovalPath,
+		state.serviceAccountLifecycleApprovalHandler, true, approve); code != http.StatusOK {
+		t.Fatalf("approval failed with %d", code)
+	}
+
+	account, err := getServiceAccountFromDB("svc_lifecycle", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Status != serviceAccountStatusPendingDeletion {
+		t.Fatalf("bad status %s", account.Status)
+	}
+	isMember, _, err := state.Userinfo.IsgroupmemberorNot("group2", "svc_lifecycle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isMember {
+		t.Fatal("memberships were not removed")
+	}
+	err = state.runServiceAccountDeletions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, _, err := state.Userinfo.ServiceAccountExistsornot("svc_lifecycle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("service account was deleted during the grace period")
+	}
+
+	// grace period over
+	err = execServiceAccountUpdate(&state, "update service_account_deletions set delete_after=? where accountname=?;",
+		time.Now().Add(-time.Minute).Unix(), "svc_lifecycle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.runServiceAccountDeletions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, _, err = state.Userinfo.ServiceAccountExistsornot("svc_lifecycle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("service account was not deleted")
+	}
+	_, err = getServiceAccountFromDB("svc_lifecycle", &state)
+	if err == nil {
+		t.Fatal("service account record was not deleted")
+	}
+}
+
+func TestServiceAccountDisableAndRestore(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	smtpClient = func(addr string) (smtpDialer, error) {
+		return &smtpDialerMock{}, nil
+	}
+	testCreateRotatableServiceAccount(t, &state, "svc_disable")
+	lifecycle := func(action string) int {
+		return testPostServiceAccountForm(t, &state, serviceAccountLifecyclePath, state.serviceAccountLifecycleHandler,
+			true, url.Values{"accountname": {"svc_disable"}, "action": {action}, "justification": {"unused"}})
+	}
+	if code := lifecycle("restore"); code != http.StatusBadRequest {
+		t.Fatalf("restore of an active account should fail, got %d", code)
+	}
+	if code := lifecycle(serviceAccountActionDisable); code != http.StatusOK {
+		t.Fatalf("disable failed with %d", code)
+	}
+	if code := lifecycle(serviceAccountActionDisable); code != http.StatusBadRequest {
+		t.Fatalf("account is already disabled, got %d", code)
+	}
+	if code := lifecycle(serviceAccountActionDelete); code != http.StatusOK {
+		t.Fatalf("delete failed with %d", code)
+	}
+	if code := lifecycle("restore"); code != http.StatusOK {
+		t.Fatalf("restore failed with %d", code)
+	}
+	account, err := getServiceAccountFromDB("svc_disable", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Status != serviceAccountStatusDisabled {
+		t.Fatalf("bad status %s", account.Status)
+	}
+	deletions, err := getAllServiceAccountDeletionsFromDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, deletion := range deletions {
+		if deletion.AccountName == "svc_disable" {
+			t.Fatal("restored account is still scheduled for deletion")
+		}
+	}
+}
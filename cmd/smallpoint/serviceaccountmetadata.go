@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+const serviceAccountMetadataMaxLength = 256
+
+// serviceAccountMetadata describes what a service account is used for, it
+// is kept in the local DB and edited by the owners.
+type serviceAccountMetadata struct {
+	Purpose    string
+	Team       string
+	CostCenter string
+	Ticket     string
+	UpdatedBy  string
+	UpdatedAt  time.Time
+}
+
+var getServiceAccountMetadataStmt = map[string]string{
+	"sqlite":   "select purpose, team, cost_center, ticket, updated_by, time_stamp from service_account_metadata where accountname=?;",
+	"postgres": "select purpose, team, cost_center, ticket, updated_by, time_stamp from service_account_metadata where accountname=$1;",
+}
+
+var setServiceAccountMetadataStmts = map[string][]string{
+	"sqlite": {"delete from service_account_metadata where accountname=?;",
+		"insert into service_account_metadata(accountname, purpose, team, cost_center, ticket, updated_by, time_stamp) values (?,?,?,?,?,?,?);"},
+	"postgres": {"delete from service_account_metadata where accountname=$1;",
+		"insert into service_account_metadata(accountname, purpose, team, cost_center, ticket, updated_by, time_stamp) values ($1,$2,$3,$4,$5,$6,$7);"},
+}
+
+// getServiceAccountMetadataFromDB returns empty metadata for accounts that
+// were never described.
+func getServiceAccountMetadataFromDB(accountname string, state *RuntimeState) (serviceAccountMetadata, error) {
+	start := time.Now()
+	var metadata serviceAccountMetadata
+	var timeStamp int64
+	err := state.db.QueryRow(getServiceAccountMetadataStmt[state.dbType], accountname).Scan(&metadata.Purpose,
+		&metadata.Team, &metadata.CostCenter, &metadata.Ticket, &metadata.UpdatedBy, &timeStamp)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return metadata, nil
+		}
+		return metadata, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	metadata.UpdatedAt = time.Unix(timeStamp, 0)
+	return metadata, nil
+}
+
+func setServiceAccountMetadataInDB(accountname string, metadata serviceAccountMetadata, state *RuntimeState) error {
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := setServiceAccountMetadataStmts[state.dbType]
+	_, err = tx.Exec(stmts[0], accountname)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(stmts[1], accountname, metadata.Purpose, metadata.Team, metadata.CostCenter,
+		metadata.Ticket, metadata.UpdatedBy, metadata.UpdatedAt.Unix())
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// serviceAccountMetadataFromForm returns the metadata and the name of the
+// first field that is too long.
+func serviceAccountMetadataFromForm(r *http.Request) (serviceAccountMetadata, string) {
+	metadata := serviceAccountMetadata{
+		Purpose:    strings.TrimSpace(r.PostFormValue("purpose")),
+		Team:       strings.TrimSpace(r.PostFormValue("team")),
+		CostCenter: strings.TrimSpace(r.PostFormValue("costCenter")),
+		Ticket:     strings.TrimSpace(r.PostFormValue("ticket")),
+	}
+	fields := []struct {
+		name  string
+		value string
+	}{{"purpose", metadata.Purpose}, {"team", metadata.Team}, {"costCenter", metadata.CostCenter},
+		{"ticket", metadata.Ticket}}
+	for _, field := range fields {
+		if len(field.value) > serviceAccountMetadataMaxLength {
+			return metadata, field.name
+		}
+	}
+	return metadata, ""
+}
+
+// serviceAccountInfoHandler shows a service account to its owners, who also
+// edit its metadata with a POST.
+func (state *RuntimeState) serviceAccountInfoHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	accountname := strings.TrimSpace(r.URL.Query().Get("accountname"))
+	var account serviceAccount
+	var metadata serviceAccountMetadata
+	switch r.Method {
+	case getMethod:
+		var ok bool
+		account, ok = state.getManageableServiceAccount(w, r, username, accountname)
+		if !ok {
+			return
+		}
+		metadata, err = getServiceAccountMetadataFromDB(accountname, state)
+		if err != nil {
+			requestLogger(r).Error("serviceAccountInfoHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+	case postMethod:
+		err = r.ParseForm()
+		if err != nil {
+			requestLogger(r).Error("serviceAccountInfoHandler failed", "err", err)
+			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		accountname = strings.TrimSpace(r.PostFormValue("accountname"))
+		var ok bool
+		account, ok = state.getManageableServiceAccount(w, r, username, accountname)
+		if !ok {
+			return
+		}
+		var invalidField string
+		metadata, invalidField = serviceAccountMetadataFromForm(r)
+		if invalidField != "" {
+			state.writeFailureResponse(w, r, fmt.Sprintf("%s is longer than %d characters",
+				invalidField, serviceAccountMetadataMaxLength), http.StatusBadRequest)
+			return
+		}
+		metadata.UpdatedBy = username
+		metadata.UpdatedAt = time.Now()
+		err = setServiceAccountMetadataInDB(accountname, metadata, state)
+		if err != nil {
+			requestLogger(r).Error("serviceAccountInfoHandler failed", "err", err)
+			state.recordAuditEvent(r, username, auditActionUpdateServiceAccountMetadata, accountname, accountname,
+				auditOutcomeFailure, err.Error())
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		state.recordAuditEvent(r, username, auditActionUpdateServiceAccountMetadata, accountname, accountname,
+			auditOutcomeSuccess, fmt.Sprintf("team %s, cost center %s, ticket %s", metadata.Team,
+				metadata.CostCenter, metadata.Ticket))
+	default:
+		state.writeFailureResponse(w, r, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	pageData := serviceAccountInfoPageData{
+		UserName: username,
+		IsAdmin:  state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:    "Service account " + accountname,
+		Account:  account,
+		Metadata: metadata,
+	}
+	state.renderTemplateOrReturnJson(w, r, "serviceAccountInfoPage", pageData)
+}
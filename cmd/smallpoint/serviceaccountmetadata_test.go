@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestServiceAccountMetadata(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCreateRotatableServiceAccount(t, &state, "svc_metadata")
+	formValues := url.Values{"accountname": {"svc_metadata"}, "purpose": {"nightly backups"},
+		"team": {"storage"}, "costCenter": {"CC-1234"}, "ticket": {"OPS-42"}}
+	if code := testPostServiceAccountForm(t, &state, serviceAccountInfoPath, state.serviceAccountInfoHandler,
+		false, formValues); code != http.StatusOK {
+		t.Fatalf("metadata update failed with %d", code)
+	}
+	formValues.Set("purpose", strings.Repeat("x", serviceAccountMetadataMaxLength+1))
+	if code := testPostServiceAccountForm(t, &state, serviceAccountInfoPath, state.serviceAccountInfoHandler,
+		false, formValues); code != http.StatusBadRequest {
+		t.Fatalf("too long purpose should fail, got %d", code)
+	}
+
+	req, err := http.NewRequest("GET", serviceAccountInfoPath+"?accountname=svc_metadata", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.serviceAccountInfoHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("info page failed with %d", rr.Code)
+	}
+	var pageData serviceAccountInfoPageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := pageData.Metadata
+	if metadata.Purpose != "nightly backups" || metadata.Team != "storage" || metadata.CostCenter != "CC-1234" ||
+		metadata.Ticket != "OPS-42" || metadata.UpdatedBy != "user2" {
+		t.Fatalf("bad metadata %+v", metadata)
+	}
+	if pageData.Account.OwnerGroup != "group1" {
+		t.Fatalf("bad account %+v", pageData.Account)
+	}
+
+	// user2 leaves the owner group
+	err = state.Userinfo.DeletemembersfromGroup(userinfo.GroupInfo{Groupname: "group1", MemberUid: []string{"user2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Userinfo.AddmemberstoExisting(userinfo.GroupInfo{Groupname: "group1", MemberUid: []string{"user2"}})
+	if code := testPostServiceAccountForm(t, &state, serviceAccountInfoPath, state.serviceAccountInfoHandler,
+		false, formValues); code != http.StatusForbidden {
+		t.Fatalf("non owners cannot edit metadata, got %d", code)
+	}
+}
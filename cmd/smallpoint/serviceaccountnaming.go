@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const defaultServiceAccountNameMaxLength = 32
+
+// The default pattern keeps names usable as POSIX usernames and safe to
+// embed in LDAP filters and DNs.
+var defaultServiceAccountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// reservedServiceAccountNames can never be used, the configured reserved
+// names are added to them.
+var reservedServiceAccountNames = []string{"root", "admin", "administrator", "nobody", "daemon"}
+
+type serviceAccountNamingConfig struct {
+	// Pattern is a regular expression the whole name must match, the
+	// default allows letters, digits, '_', '.' and '-'.
+	Pattern       string   `yaml:"pattern"`
+	Prefix        string   `yaml:"prefix"`
+	Suffix        string   `yaml:"suffix"`
+	MaxLength     int      `yaml:"max_length"`
+	ReservedNames []string `yaml:"reserved_names"`
+}
+
+func (config serviceAccountNamingConfig) maxLength() int {
+	if config.MaxLength > 0 {
+		return config.MaxLength
+	}
+	return defaultServiceAccountNameMaxLength
+}
+
+// compilePattern returns the default pattern when none is configured, the
+// configured pattern is anchored to match the whole name.
+func (config serviceAccountNamingConfig) compilePattern() (*regexp.Regexp, error) {
+	if config.Pattern == "" {
+		return defaultServiceAccountNamePattern, nil
+	}
+	return regexp.Compile("^(?:" + config.Pattern + ")$")
+}
+
+// describe summarizes the rules for the create service account page.
+func (config serviceAccountNamingConfig) describe() string {
+	rules := []string{fmt.Sprintf("at most %d characters", config.maxLength())}
+	if config.Prefix != "" {
+		rules = append(rules, fmt.Sprintf("starts with '%s'", config.Prefix))
+	}
+	if config.Suffix != "" {
+		rules = append(rules, fmt.Sprintf("ends with '%s'", config.Suffix))
+	}
+	pattern := defaultServiceAccountNamePattern.String()
+	if config.Pattern != "" {
+		pattern = config.Pattern
+	}
+	rules = append(rules, fmt.Sprintf("matches %s", pattern))
+	return strings.Join(rules, ", ")
+}
+
+// checkServiceAccountNamingPolicy returns a message for the user when the
+// name breaks the naming policy.
+func (state *RuntimeState) checkServiceAccountNamingPolicy(accountname string) string {
+	config := state.Config.ServiceAccounts.Naming
+	if accountname == "" {
+		return "A service account name is required"
+	}
+	if len(accountname) > config.maxLength() {
+		return fmt.Sprintf("Service account names must be at most %d characters long", config.maxLength())
+	}
+	if !strings.HasPrefix(accountname, config.Prefix) {
+		return fmt.Sprintf("Service account names must start with '%s'", config.Prefix)
+	}
+	if !strings.HasSuffix(accountname, config.Suffix) {
+		return fmt.Sprintf("Service account names must end with '%s'", config.Suffix)
+	}
+	pattern := state.serviceAccountNamePattern
+	if pattern == nil {
+		pattern = defaultServiceAccountNamePattern
+	}
+	if !pattern.MatchString(accountname) {
+		return fmt.Sprintf("Service account name '%s' does not match %s", accountname, pattern)
+	}
+	for _, reserved := range append(reservedServiceAccountNames, config.ReservedNames...) {
+		if strings.EqualFold(accountname, reserved) {
+			return fmt.Sprintf("'%s' is a reserved name", accountname)
+		}
+	}
+	return ""
+}
+
+// checkServiceAccountName applies the naming policy and returns a message
+// for the user when the name is invalid or collides with a user, a group or
+// another service account.
+func (state *RuntimeState) checkServiceAccountName(accountname string) (string, error) {
+	message := state.checkServiceAccountNamingPolicy(accountname)
+	if message != "" {
+		return message, nil
+	}
+	groupExists, _, err := state.Userinfo.GroupnameExistsornot(accountname)
+	if err != nil {
+		return "", err
+	}
+	if groupExists {
+		return "Bad request! A group already exists with that name!", nil
+	}
+	serviceAccountExists, _, err := state.Userinfo.ServiceAccountExistsornot(accountname)
+	if err != nil {
+		return "", err
+	}
+	if serviceAccountExists {
+		return "Service Account already exists!", nil
+	}
+	userExists, err := state.Userinfo.UsernameExistsornot(accountname)
+	if err != nil {
+		return "", err
+	}
+	if userExists {
+		return "Bad request! A user already exists with that name!", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestServiceAccountNamingPolicy(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.ServiceAccounts.Naming = serviceAccountNamingConfig{Pattern: `[a-z_]+`, Prefix: "svc_",
+		Suffix: "_bot", MaxLength: 20, ReservedNames: []string{"svc_backup_bot"}}
+	state.serviceAccountNamePattern, err = state.Config.ServiceAccounts.Naming.compilePattern()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCreateRotatableServiceAccount(t, &state, "svc_taken_bot")
+	names := map[string]bool{
+		"svc_deploy_bot":            true,
+		"":                          false,
+		"deploy_bot":                false,
+		"svc_deploy":                false,
+		"svc_Deploy_bot":            false,
+		"svc_a_very_long_name_bot":  false,
+		"svc_backup_bot":            false,
+		"SVC_BACKUP_BOT":            false,
+		"svc_taken_bot":             false,
+		"svc_deploy_bot)(uid=*_bot": false,
+	}
+	for name, valid := range names {
+		message, err := state.checkServiceAccountName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != (message == "") {
+			t.Errorf("name '%s' valid=%v got '%s'", name, valid, message)
+		}
+	}
+	_, err = (serviceAccountNamingConfig{Pattern: "(["}).compilePattern()
+	if err == nil {
+		t.Fatal("invalid pattern should fail")
+	}
+
+	// collisions with users and groups use the default policy
+	state.Config.ServiceAccounts.Naming = serviceAccountNamingConfig{}
+	state.serviceAccountNamePattern = nil
+	for _, name := range []string{"user3", "group2", "root"} {
+		code := testPostServiceAccountForm(t, &state, createServiceAccountPath, state.createServiceAccounthandler,
+			true, url.Values{"AccountName": {name}, "mail": {"team@example.com"}, "loginShell": {"/bin/false"}})
+		if code != http.StatusBadRequest {
+			t.Errorf("creating %s should fail, got %d", name, code)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Owners and admins transfer a service account directly. Anybody else, for
+// example when every owner has left the company, can ask to take it over on
+// behalf of a group they belong to and an admin approves or rejects it.
+
+var errTakeoverAlreadyRequested = errors.New("a takeover of this service account is already pending")
+
+type serviceAccountTakeover struct {
+	ID            int64
+	AccountName   string
+	RequestedBy   string
+	OwnerGroup    string
+	Justification string
+	Timestamp     time.Time
+}
+
+var updateServiceAccountOwnerStmt = map[string]string{
+	"sqlite":   "update service_accounts set owner_group=? where accountname=?;",
+	"postgres": "update service_accounts set owner_group=$1 where accountname=$2;",
+}
+
+var insertServiceAccountTakeoverStmt = map[string]string{
+	"sqlite":   "insert into service_account_takeovers(accountname, requested_by, owner_group, justification, time_stamp) values (?,?,?,?,?);",
+	"postgres": "insert into service_account_takeovers(accountname, requested_by, owner_group, justification, time_stamp) values ($1,$2,$3,$4,$5);",
+}
+
+var getServiceAccountTakeoverStmt = map[string]string{
+	"sqlite":   "select id, accountname, requested_by, owner_group, justification, time_stamp from service_account_takeovers where id=?;",
+	"postgres": "select id, accountname, requested_by, owner_group, justification, time_stamp from service_account_takeovers where id=$1;",
+}
+
+var getAllServiceAccountTakeoversStmt = "select id, accountname, requested_by, owner_group, justification, time_stamp from service_account_takeovers order by id;"
+
+var deleteServiceAccountTakeoverStmt = map[string]string{
+	"sqlite":   "delete from service_account_takeovers where id=?;",
+	"postgres": "delete from service_account_takeovers where id=$1;",
+}
+
+var pendingServiceAccountTakeoverExistsStmt = map[string]string{
+	"sqlite":   "select count(*) from service_account_takeovers where accountname=? and requested_by=?;",
+	"postgres": "select count(*) from service_account_takeovers where accountname=$1 and requested_by=$2;",
+}
+
+func scanServiceAccountTakeover(row sqlRowScanner) (serviceAccountTakeover, error) {
+	var takeover serviceAccountTakeover
+	var timeStamp int64
+	err := row.Scan(&takeover.ID, &takeover.AccountName, &takeover.RequestedBy, &takeover.OwnerGroup,
+		&takeover.Justification, &timeStamp)
+	takeover.Timestamp = time.Unix(timeStamp, 0)
+	return takeover, err
+}
+
+func getServiceAccountTakeoverFromDB(id int64, state *RuntimeState) (serviceAccountTakeover, error) {
+	start := time.Now()
+	takeover, err := scanServiceAccountTakeover(state.db.QueryRow(getServiceAccountTakeoverStmt[state.dbType], id))
+	if err != nil {
+		return takeover, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return takeover, nil
+}
+
+func getAllServiceAccountTakeoversFromDB(state *RuntimeState) ([]serviceAccountTakeover, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getAllServiceAccountTakeoversStmt)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var takeovers []serviceAccountTakeover
+	for rows.Next() {
+		takeover, err := scanServiceAccountTakeover(rows)
+		if err != nil {
+			return nil, err
+		}
+		takeovers = append(takeovers, takeover)
+	}
+	return takeovers, rows.Err()
+}
+
+func (state *RuntimeState) insertServiceAccountTakeoverInDB(takeover serviceAccountTakeover) error {
+	var count int
+	err := state.db.QueryRow(pendingServiceAccountTakeoverExistsStmt[state.dbType], takeover.AccountName,
+		takeover.RequestedBy).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errTakeoverAlreadyRequested
+	}
+	return execServiceAccountUpdate(state, insertServiceAccountTakeoverStmt[state.dbType], takeover.AccountName,
+		takeover.RequestedBy, takeover.OwnerGroup, takeover.Justification, takeover.Timestamp.Unix())
+}
+
+// getAdminsEmail returns the email of every superadmin that has one.
+func (state *RuntimeState) getAdminsEmail() []string {
+	var adminsEmail []string
+	for _, admin := range state.Userinfo.ParseSuperadmins() {
+		email, err := state.Userinfo.GetEmailofauser(admin)
+		if err != nil {
+			slog.Error("cannot get the email of the admin", "username", admin, "err", err)
+			continue
+		}
+		adminsEmail = append(adminsEmail, email...)
+	}
+	return adminsEmail
+}
+
+func (state *RuntimeState) transferServiceAccount(r *http.Request, actor string, account serviceAccount,
+	ownerGroup string, details string) error {
+	err := execServiceAccountUpdate(state, updateServiceAccountOwnerStmt[state.dbType], ownerGroup,
+		account.AccountName)
+	if err != nil {
+		state.recordAuditEvent(r, actor, auditActionChangeServiceAccountOwner, account.AccountName,
+			account.AccountName, auditOutcomeFailure, err.Error())
+		return err
+	}
+	if state.sysLog != nil {
+		state.sysLog.Write([]byte(fmt.Sprintf("Service account %s is owned by %s now, this change was made by %s.",
+			account.AccountName, ownerGroup, actor)))
+	}
+	details = fmt.Sprintf("owner %s -> %s%s", account.OwnerGroup, ownerGroup, details)
+	state.recordAuditEvent(r, actor, auditActionChangeServiceAccountOwner, account.AccountName,
+		account.AccountName, auditOutcomeSuccess, details)
+	return nil
+}
+
+func (state *RuntimeState) changeServiceAccountOwnerWebpageHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	pageData := changeServiceAccountOwnerPageData{
+		UserName:    username,
+		IsAdmin:     state.requestUserinfo(r).UserisadminOrNot(username),
+		Title:       "Change Service Account Owner",
+		AccountName: r.URL.Query().Get("accountname"),
+	}
+	w.Header().Set("Cache-Control", "private, max-age=30")
+	err = state.executeTemplate(w, "changeServiceAccountOwnerPage", pageData)
+	if err != nil {
+		requestLogger(r).Error("Failed to execute the template", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// changeServiceAccountOwnerHandler transfers the account when done by an
+// owner or an admin and files a takeover request otherwise.
+func (state *RuntimeState) changeServiceAccountOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("changeServiceAccountOwnerHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	accountName := strings.TrimSpace(r.PostFormValue("accountname"))
+	ownerGroup := strings.TrimSpace(r.PostFormValue("ownerGroup"))
+	justification := strings.TrimSpace(r.PostFormValue("justification"))
+	if ownerGroup == "" {
+		state.writeFailureResponse(w, r, "ownerGroup is required", http.StatusBadRequest)
+		return
+	}
+	account, err := getServiceAccountFromDB(accountName, state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			state.writeFailureResponse(w, r, "service account not found", http.StatusNotFound)
+			return
+		}
+		requestLogger(r).Error("changeServiceAccountOwnerHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if account.OwnerGroup == ownerGroup {
+		state.writeFailureResponse(w, r, "service account is already owned by "+ownerGroup, http.StatusBadRequest)
+		return
+	}
+	ownerGroupExists, _, err := state.requestUserinfo(r).GroupnameExistsornot(ownerGroup)
+	if err != nil {
+		requestLogger(r).Error("changeServiceAccountOwnerHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !ownerGroupExists {
+		state.writeFailureResponse(w, r, "group "+ownerGroup+" does not exist", http.StatusBadRequest)
+		return
+	}
+	if !state.requireRecentAuthentication(w, r) {
+		return
+	}
+	isAdmin := state.requestUserinfo(r).UserisadminOrNot(username)
+	allowed, err := state.canManageServiceAccount(username, account)
+	if err != nil {
+		requestLogger(r).Error("changeServiceAccountOwnerHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if allowed {
+		err = state.transferServiceAccount(r, username, account, ownerGroup, "")
+		if err != nil {
+			requestLogger(r).Error("changeServiceAccountOwnerHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		pageData := simpleMessagePageData{
+			UserName:       username,
+			IsAdmin:        isAdmin,
+			Title:          "Change Service Account Owner Success",
+			SuccessMessage: fmt.Sprintf("Service account %s is now owned by %s", accountName, ownerGroup),
+			ContinueURL:    serviceAccountsPath,
+		}
+		state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+		return
+	}
+
+	// takeover
+	if justification == "" {
+		state.writeFailureResponse(w, r, "a justification is required to take over a service account", http.StatusBadRequest)
+		return
+	}
+	isMember, _, err := state.requestUserinfo(r).IsgroupmemberorNot(ownerGroup, username)
+	if err != nil {
+		requestLogger(r).Error("changeServiceAccountOwnerHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		state.writeFailureResponse(w, r, "you can only take over a service account for a group you belong to", http.StatusForbidden)
+		return
+	}
+	takeover := serviceAccountTakeover{AccountName: accountName, RequestedBy: username, OwnerGroup: ownerGroup,
+		Justification: justification, Timestamp: time.Now()}
+	err = state.insertServiceAccountTakeoverInDB(takeover)
+	if err != nil {
+		if err == errTakeoverAlreadyRequested {
+			state.writeFailureResponse(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requestLogger(r).Error("changeServiceAccountOwnerHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.recordAuditEvent(r, username, auditActionRequestServiceAccountTakeover, accountName, username,
+		auditOutcomeSuccess, "owner "+ownerGroup+": "+justification)
+	adminsEmail := state.getAdminsEmail()
+	if len(adminsEmail) > 0 {
+		go state.sendEmailWithAttachments(adminsEmail, "Takeover of service account "+accountName,
+			fmt.Sprintf("User %s asked to transfer service account %s from %s to %s.\nJustification: %s\nPlease review it at %s%s\n",
+				username, accountName, account.OwnerGroup, ownerGroup, justification,
+				state.Config.Base.Hostname, serviceAccountsPath), nil)
+	}
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        isAdmin,
+		Title:          "Service Account Takeover Requested",
+		SuccessMessage: fmt.Sprintf("Your request to take over %s is waiting for an admin approval", accountName),
+		ContinueURL:    serviceAccountsPath,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
+
+// serviceAccountTakeoverHandler is used by admins to approve or reject a
+// takeover request.
+func (state *RuntimeState) serviceAccountTakeoverHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("serviceAccountTakeoverHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		state.writeFailureResponse(w, r, "invalid takeover id", http.StatusBadRequest)
+		return
+	}
+	action := r.PostFormValue("action")
+	if action != "approve" && action != "reject" {
+		state.writeFailureResponse(w, r, "action must be approve or reject", http.StatusBadRequest)
+		return
+	}
+	takeover, err := getServiceAccountTakeoverFromDB(id, state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			state.writeFailureResponse(w, r, "takeover request not found", http.StatusNotFound)
+			return
+		}
+		requestLogger(r).Error("serviceAccountTakeoverHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	message := fmt.Sprintf("The takeover of service account %s by %s was rejected", takeover.AccountName, takeover.OwnerGroup)
+	if action == "approve" {
+		account, err := getServiceAccountFromDB(takeover.AccountName, state)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				state.writeFailureResponse(w, r, "service account not found", http.StatusNotFound)
+				return
+			}
+			requestLogger(r).Error("serviceAccountTakeoverHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		err = state.transferServiceAccount(r, username, account, takeover.OwnerGroup,
+			fmt.Sprintf(", takeover requested by %s", takeover.RequestedBy))
+		if err != nil {
+			requestLogger(r).Error("serviceAccountTakeoverHandler failed", "err", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		message = fmt.Sprintf("Service account %s is now owned by %s", takeover.AccountName, takeover.OwnerGroup)
+	} else {
+		state.recordAuditEvent(r, username, auditActionRejectServiceAccountTakeover, takeover.AccountName,
+			takeover.RequestedBy, auditOutcomeSuccess, "owner "+takeover.OwnerGroup)
+	}
+	err = execServiceAccountUpdate(state, deleteServiceAccountTakeoverStmt[state.dbType], id)
+	if err != nil {
+		requestLogger(r).Error("serviceAccountTakeoverHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	requesterEmail, err := state.requestUserinfo(r).GetEmailofauser(takeover.RequestedBy)
+	if err != nil && err != userinfo.UserDoesNotExist {
+		requestLogger(r).Error("serviceAccountTakeoverHandler failed", "err", err)
+	}
+	if len(requesterEmail) > 0 {
+		go state.sendEmailWithAttachments(requesterEmail, "Takeover of service account "+takeover.AccountName,
+			message+" by "+username+".\n", nil)
+	}
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        true,
+		Title:          "Service Account Takeover",
+		SuccessMessage: message,
+		ContinueURL:    serviceAccountsPath,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Users who are not admins request service accounts, the request waits in
+// the pending queue of the admins. On approval the account is created and,
+// when the requested owner group does not exist yet, the owner group is
+// created as a self-managed group of the requester.
+
+var errServiceAccountAlreadyRequested = errors.New("this service account was already requested")
+
+// errServiceAccountNotRecorded is returned when the account was created in
+// LDAP but not in the DB.
+var errServiceAccountNotRecorded = errors.New("service account created but not recorded")
+
+type serviceAccountRequest struct {
+	ID            int64
+	AccountName   string
+	Mail          string
+	LoginShell    string
+	OwnerGroup    string
+	ReviewBy      time.Time
+	Justification string
+	RequestedBy   string
+	Timestamp     time.Time
+}
+
+const serviceAccountRequestColumns = "id, accountname, mail, login_shell, owner_group, review_by, justification, requested_by, time_stamp"
+
+var insertServiceAccountRequestStmt = map[string]string{
+	"sqlite":   "insert into service_account_requests(accountname, mail, login_shell, owner_group, review_by, justification, requested_by, time_stamp) values (?,?,?,?,?,?,?,?);",
+	"postgres": "insert into service_account_requests(accountname, mail, login_shell, owner_group, review_by, justification, requested_by, time_stamp) values ($1,$2,$3,$4,$5,$6,$7,$8);",
+}
+
+var getServiceAccountRequestStmt = map[string]string{
+	"sqlite":   "select " + serviceAccountRequestColumns + " from service_account_requests where id=?;",
+	"postgres": "select " + serviceAccountRequestColumns + " from service_account_requests where id=$1;",
+}
+
+var getAllServiceAccountRequestsStmt = "select " + serviceAccountRequestColumns + " from service_account_requests order by id;"
+
+var serviceAccountRequestExistsStmt = map[string]string{
+	"sqlite":   "select count(*) from service_account_requests where accountname=?;",
+	"postgres": "select count(*) from service_account_requests where accountname=$1;",
+}
+
+var deleteServiceAccountRequestStmt = map[string]string{
+	"sqlite":   "delete from service_account_requests where id=?;",
+	"postgres": "delete from service_account_requests where id=$1;",
+}
+
+func scanServiceAccountRequest(row sqlRowScanner) (serviceAccountRequest, error) {
+	var request serviceAccountRequest
+	var reviewBy, timeStamp int64
+	err := row.Scan(&request.ID, &request.AccountName, &request.Mail, &request.LoginShell, &request.OwnerGroup,
+		&reviewBy, &request.Justification, &request.RequestedBy, &timeStamp)
+	request.ReviewBy = time.Unix(reviewBy, 0)
+	request.Timestamp = time.Unix(timeStamp, 0)
+	return request, err
+}
+
+func getServiceAccountRequestFromDB(id int64, state *RuntimeState) (serviceAccountRequest, error) {
+	start := time.Now()
+	request, err := scanServiceAccountRequest(state.db.QueryRow(getServiceAccountRequestStmt[state.dbType], id))
+	if err != nil {
+		return request, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return request, nil
+}
+
+func getAllServiceAccountRequestsFromDB(state *RuntimeState) ([]serviceAccountRequest, error) {
+	start := time.Now()
+	rows, err := state.db.Query(getAllServiceAccountRequestsStmt)
+	if err != nil {
+		slog.Error("Problem with db", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var requests []serviceAccountRequest
+	for rows.Next() {
+		request, err := scanServiceAccountRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+// createServiceAccount creates the account in LDAP and records it in the DB.
+func (state *RuntimeState) createServiceAccount(r *http.Request, actor string, createdBy string,
+	groupinfo userinfo.GroupInfo, ownerGroup string, reviewBy time.Time) error {
+	err := state.requestUserinfo(r).CreateServiceAccount(groupinfo)
+	if err != nil {
+		state.recordAuditEvent(r, actor, auditActionCreateServiceAccount, groupinfo.Groupname, groupinfo.Groupname, auditOutcomeFailure, err.Error())
+		return err
+	}
+	if state.sysLog != nil {
+		state.sysLog.Write([]byte(fmt.Sprintf("Service account "+"%s"+" was created by "+"%s", groupinfo.Groupname, actor)))
+	}
+	details := ""
+	if createdBy != actor {
+		details = "requested by " + createdBy
+	}
+	state.recordAuditEvent(r, actor, auditActionCreateServiceAccount, groupinfo.Groupname, groupinfo.Groupname, auditOutcomeSuccess, details)
+	err = insertServiceAccountInDB(serviceAccount{
+		AccountName: groupinfo.Groupname,
+		OwnerGroup:  ownerGroup,
+		Mail:        groupinfo.Mail,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now(),
+		ReviewBy:    reviewBy,
+		Status:      serviceAccountStatusActive,
+	}, state)
+	if err != nil {
+		requestLogger(r).Error("createServiceAccount failed", "err", err)
+		return errServiceAccountNotRecorded
+	}
+	return nil
+}
+
+// fileServiceAccountRequest queues the request and notifies the admins.
+func (state *RuntimeState) fileServiceAccountRequest(r *http.Request, request serviceAccountRequest) error {
+	var count int
+	err := state.db.QueryRow(serviceAccountRequestExistsStmt[state.dbType], request.AccountName).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errServiceAccountAlreadyRequested
+	}
+	err = execServiceAccountUpdate(state, insertServiceAccountRequestStmt[state.dbType], request.AccountName,
+		request.Mail, request.LoginShell, request.OwnerGroup, request.ReviewBy.Unix(), request.Justification,
+		request.RequestedBy, request.Timestamp.Unix())
+	if err != nil {
+		return err
+	}
+	state.recordAuditEvent(r, request.RequestedBy, auditActionRequestServiceAccount, request.AccountName,
+		request.RequestedBy, auditOutcomeSuccess, "owner "+request.OwnerGroup+": "+request.Justification)
+	adminsEmail := state.getAdminsEmail()
+	if len(adminsEmail) > 0 {
+		go state.sendEmailWithAttachments(adminsEmail, "Request for service account "+request.AccountName,
+			fmt.Sprintf("User %s requested the service account %s owned by %s.\nJustification: %s\nPlease review it at %s%s\n",
+				request.RequestedBy, request.AccountName, request.OwnerGroup, request.Justification,
+				state.Config.Base.Hostname, serviceAccountsPath), nil)
+	}
+	return nil
+}
+
+// approveServiceAccountRequest creates the owner group when needed and then
+// the account, it returns a message for the user when the request cannot
+// be fulfilled anymore.
+func (state *RuntimeState) approveServiceAccountRequest(r *http.Request, actor string,
+	request serviceAccountRequest) (string, error) {
+	message, err := state.checkServiceAccountName(request.AccountName)
+	if err != nil || message != "" {
+		return message, err
+	}
+	ownerGroupExists, _, err := state.requestUserinfo(r).GroupnameExistsornot(request.OwnerGroup)
+	if err != nil {
+		return "", err
+	}
+	if !ownerGroupExists {
+		err = state.createServiceAccountOwnerGroup(r, actor, request.OwnerGroup, []string{request.RequestedBy},
+			"service account request of "+request.RequestedBy)
+		if err != nil {
+			return "", err
+		}
+	}
+	groupinfo := userinfo.GroupInfo{Groupname: request.AccountName, Mail: request.Mail,
+		LoginShell: request.LoginShell}
+	return "", state.createServiceAccount(r, actor, request.RequestedBy, groupinfo, request.OwnerGroup,
+		request.ReviewBy)
+}
+
+// serviceAccountRequestHandler is used by admins to approve or reject a
+// service account request.
+func (state *RuntimeState) serviceAccountRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	if !state.requestUserinfo(r).UserisadminOrNot(username) {
+		http.Error(w, "you are not authorized", http.StatusForbidden)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		requestLogger(r).Error("serviceAccountRequestHandler failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		state.writeFailureResponse(w, r, "invalid request id", http.StatusBadRequest)
+		return
+	}
+	action := r.PostFormValue("action")
+	if action != "approve" && action != "reject" {
+		state.writeFailureResponse(w, r, "action must be approve or reject", http.StatusBadRequest)
+		return
+	}
+	request, err := getServiceAccountRequestFromDB(id, state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			state.writeFailureResponse(w, r, "request not found", http.StatusNotFound)
+			return
+		}
+		requestLogger(r).Error("serviceAccountRequestHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	message := fmt.Sprintf("The request for service account %s was rejected", request.AccountName)
+	if action == "approve" {
+		failure, err := state.approveServiceAccountRequest(r, username, request)
+		if err != nil && err != errServiceAccountNotRecorded {
+			requestLogger(r).Error("serviceAccountRequestHandler failed", "err", err)
+			state.writeFailureResponse(w, r, "cannot create the service account", http.StatusInternalServerError)
+			return
+		}
+		if failure != "" {
+			state.writeFailureResponse(w, r, failure, http.StatusBadRequest)
+			return
+		}
+		message = fmt.Sprintf("Service account %s was created, it is owned by %s", request.AccountName,
+			request.OwnerGroup)
+	} else {
+		state.recordAuditEvent(r, username, auditActionRejectServiceAccountRequest, request.AccountName,
+			request.RequestedBy, auditOutcomeSuccess, "owner "+request.OwnerGroup)
+	}
+	err = execServiceAccountUpdate(state, deleteServiceAccountRequestStmt[state.dbType], id)
+	if err != nil {
+		requestLogger(r).Error("serviceAccountRequestHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	requesterEmail, err := state.requestUserinfo(r).GetEmailofauser(request.RequestedBy)
+	if err != nil && err != userinfo.UserDoesNotExist {
+		requestLogger(r).Error("serviceAccountRequestHandler failed", "err", err)
+	}
+	if len(requesterEmail) > 0 {
+		go state.sendEmailWithAttachments(requesterEmail, "Request for service account "+request.AccountName,
+			message+", reviewed by "+username+".\n", nil)
+	}
+	pageData := simpleMessagePageData{
+		UserName:       username,
+		IsAdmin:        true,
+		Title:          "Service Account Request",
+		SuccessMessage: message,
+		ContinueURL:    serviceAccountsPath,
+	}
+	state.renderTemplateOrReturnJson(w, r, "simpleMessagePage", pageData)
+}
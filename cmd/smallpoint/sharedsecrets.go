@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The shared secrets sign the session cookies, a weak one lets anybody
+// forge a session. The server refuses to start with a short or repetitive
+// secret. The small deployments may leave the secret to the server with
+// base.provision_shared_secrets: "file" writes a strong secret to the
+// missing cluster_shared_secret_filename, readable by the server user only,
+// and "db" stores one in the database, sealed when db_encryption is set,
+// for every instance to share. Without any secret each process signs with
+// a random secret of its own and the sessions end on restart.
+
+const (
+	sharedSecretProvisioningFile = "file"
+	sharedSecretProvisioningDB   = "db"
+
+	minSharedSecretLength        = 32
+	minSharedSecretDistinctChars = 10
+	provisionedSecretSize        = 48
+	sessionSecretName            = "session_secret"
+)
+
+var provisionedSecretsColumn = encryptedColumn{Table: "provisioned_secrets", KeyColumn: "name",
+	ValueColumn: "value"}
+
+var insertProvisionedSecretStmt = map[string]string{
+	"sqlite":   "insert into provisioned_secrets(name, value, created_at) values (?,?,?) on conflict(name) do nothing;",
+	"postgres": "insert into provisioned_secrets(name, value, created_at) values ($1,$2,$3) on conflict(name) do nothing;",
+}
+
+var getProvisionedSecretStmt = map[string]string{
+	"sqlite":   "select value from provisioned_secrets where name=?;",
+	"postgres": "select value from provisioned_secrets where name=$1;",
+}
+
+func checkSharedSecretProvisioning(provisioning string, secretsFilename string) error {
+	switch provisioning {
+	case "", sharedSecretProvisioningDB:
+	case sharedSecretProvisioningFile:
+		if secretsFilename == "" {
+			return errors.New("provision_shared_secrets file requires cluster_shared_secret_filename")
+		}
+	default:
+		return fmt.Errorf("provision_shared_secrets must be file or db, not %s", provisioning)
+	}
+	return nil
+}
+
+// checkSharedSecrets rejects the secrets too short or too repetitive to
+// resist guessing.
+func checkSharedSecrets(secrets []string) error {
+	for i, secret := range secrets {
+		if len(secret) < minSharedSecretLength {
+			return fmt.Errorf("shared secret %d has %d characters, at least %d are required", i+1,
+				len(secret), minSharedSecretLength)
+		}
+		distinct := make(map[rune]bool)
+		for _, c := range secret {
+			distinct[c] = true
+		}
+		if len(distinct) < minSharedSecretDistinctChars {
+			return fmt.Errorf("shared secret %d has only %d distinct characters", i+1, len(distinct))
+		}
+	}
+	return nil
+}
+
+func generateSharedSecret() (string, error) {
+	secret := make([]byte, provisionedSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// provisionClusterSecretsFile writes a new secret to the missing file, an
+// instance starting at the same time reads the secret of the other.
+func provisionClusterSecretsFile(filename string) ([]string, error) {
+	secret, err := generateSharedSecret()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = file.WriteString(secret + "\n")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(filename)
+			return nil, err
+		}
+		slog.Info("provisioned the cluster shared secret", "filename", filename)
+	} else if !os.IsExist(err) {
+		return nil, err
+	}
+	return getClusterSecretsFile(filename)
+}
+
+// provisionDBSharedSecret returns the secret of the database, the first
+// instance stores it.
+func (state *RuntimeState) provisionDBSharedSecret() (string, error) {
+	secret, err := generateSharedSecret()
+	if err != nil {
+		return "", err
+	}
+	context := provisionedSecretsColumn.context(sessionSecretName)
+	sealed, err := state.valueEncrypter.Encrypt(secret, context)
+	if err != nil {
+		return "", err
+	}
+	start := time.Now()
+	_, err = state.db.Exec(insertProvisionedSecretStmt[state.dbType], sessionSecretName, sealed,
+		time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	var value string
+	err = state.db.QueryRow(getProvisionedSecretStmt[state.dbType], sessionSecretName).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", errors.New("the provisioned secret is missing")
+	}
+	if err != nil {
+		return "", err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return state.valueEncrypter.Decrypt(value, context)
+}
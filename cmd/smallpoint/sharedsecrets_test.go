@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSharedSecrets(t *testing.T) {
+	strong, err := generateSharedSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSharedSecrets([]string{strong}); err != nil {
+		t.Fatal(err)
+	}
+	for _, secrets := range [][]string{
+		{"supersecret"},
+		{strong, "supersecret"},
+		{strings.Repeat("ab", 32)},
+	} {
+		if err := checkSharedSecrets(secrets); err == nil {
+			t.Fatalf("the weak secrets %q were accepted", secrets)
+		}
+	}
+	if err := checkSharedSecretProvisioning(sharedSecretProvisioningFile, ""); err == nil {
+		t.Fatal("the file provisioning without a filename was accepted")
+	}
+	if err := checkSharedSecretProvisioning("vault", ""); err == nil {
+		t.Fatal("the unknown provisioning was accepted")
+	}
+}
+
+func TestProvisionSharedSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shared_secrets_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFilename := filepath.Join(dir, "config-test.yml")
+	secretsFilename := filepath.Join(dir, "sharedSecrets.txt")
+	appConfig := AppConfigFile{}
+	appConfig.Base.TemplatesPath = dir
+	appConfig.Base.StorageURL = "sqlite:" + filepath.Join(dir, "demodb.sqlite")
+	appConfig.Base.ClusterSharedSecretFilename = secretsFilename
+	loadSecrets := func() []string {
+		err := writeConfig(configFilename, &appConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := loadConfig(configFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(state.Config.Base.SharedSecrets) != 1 {
+			t.Fatalf("%d shared secrets", len(state.Config.Base.SharedSecrets))
+		}
+		return state.Config.Base.SharedSecrets
+	}
+
+	// the weak secret is refused
+	err = ioutil.WriteFile(secretsFilename, []byte("supersecret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeConfig(configFilename, &appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(configFilename); err == nil {
+		t.Fatal("the weak shared secret was accepted")
+	}
+
+	// the missing file is written once and kept
+	os.Remove(secretsFilename)
+	appConfig.Base.ProvisionSharedSecrets = sharedSecretProvisioningFile
+	secrets := loadSecrets()
+	info, err := os.Stat(secretsFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("the secrets file mode is %s", info.Mode())
+	}
+	if loadSecrets()[0] != secrets[0] {
+		t.Fatal("the provisioned file secret changed")
+	}
+
+	// the database secret is shared by the instances
+	appConfig.Base.ClusterSharedSecretFilename = ""
+	appConfig.Base.ProvisionSharedSecrets = sharedSecretProvisioningDB
+	secrets = loadSecrets()
+	if loadSecrets()[0] != secrets[0] {
+		t.Fatal("the provisioned database secret changed")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// On SIGTERM or SIGINT the instance reports itself unready and keeps
+// serving for the unready delay, so that the load balancers stop sending it
+// requests. Then the server stops accepting connections, waits for the
+// requests in flight and for the periodic jobs running at the time for up
+// to the drain timeout, and exits. It gives up the scheduler lease so that
+// another instance takes the jobs over at once.
+//
+// For upgrades without downtime the listening socket is either passed by
+// systemd socket activation, and kept open by systemd across the restarts,
+// or bound with SO_REUSEPORT so that the new process listens on the
+// address before the old one stops.
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+	defaultUnreadyDelay = 5 * time.Second
+)
+
+type shutdownConfig struct {
+	// DrainTimeout bounds the wait for the requests and the jobs on
+	// shutdown, 30s by default.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	// UnreadyDelay is how long the instance keeps serving once it reports
+	// itself unready, 5s by default. It should be longer than the interval
+	// of the readiness checks of the load balancers.
+	UnreadyDelay time.Duration `yaml:"unready_delay"`
+	// ReusePort binds the address with SO_REUSEPORT, for several processes
+	// to listen on it during an upgrade.
+	ReusePort bool `yaml:"reuse_port"`
+}
+
+func (config shutdownConfig) drainTimeout() time.Duration {
+	if config.DrainTimeout > 0 {
+		return config.DrainTimeout
+	}
+	return defaultDrainTimeout
+}
+
+func (config shutdownConfig) unreadyDelay() time.Duration {
+	if config.UnreadyDelay > 0 {
+		return config.UnreadyDelay
+	}
+	return defaultUnreadyDelay
+}
+
+// jobRunner tracks the goroutines of the periodic jobs to stop them on
+// shutdown, the zero value is ready to use.
+type jobRunner struct {
+	mutex   sync.Mutex
+	stop    chan struct{}
+	stopped bool
+	running sync.WaitGroup
+}
+
+func (jobs *jobRunner) stopChannel() chan struct{} {
+	jobs.mutex.Lock()
+	defer jobs.mutex.Unlock()
+	if jobs.stop == nil {
+		jobs.stop = make(chan struct{})
+	}
+	return jobs.stop
+}
+
+// sleep waits for the interval, it returns false when the jobs are
+// stopped meanwhile.
+func (jobs *jobRunner) sleep(interval time.Duration) bool {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-jobs.stopChannel():
+		return false
+	}
+}
+
+// shutdown stops the jobs and waits for the running ones until ctx is
+// done.
+func (jobs *jobRunner) shutdown(ctx context.Context) error {
+	stop := jobs.stopChannel()
+	jobs.mutex.Lock()
+	if !jobs.stopped {
+		jobs.stopped = true
+		close(stop)
+	}
+	jobs.mutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		jobs.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func setReusePort(network string, address string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// listen returns the listener of the server, the socket passed by systemd
+// when the process is socket activated.
+func listen(address string, reusePort bool) (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
+		fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if fds != 1 {
+			return nil, fmt.Errorf("systemd passed %d sockets, one is expected", fds)
+		}
+		// the sockets are not for the child processes
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		// the passed sockets start at file descriptor 3
+		file := os.NewFile(3, "systemd-socket")
+		defer file.Close()
+		return net.FileListener(file)
+	}
+	var listenConfig net.ListenConfig
+	if reusePort {
+		listenConfig.Control = setReusePort
+	}
+	return listenConfig.Listen(context.Background(), "tcp", address)
+}
+
+// serve serves the TLS connections of the listener, the plain HTTP ones in
+// dev mode, until a signal is received, and then shuts down.
+func (state *RuntimeState) serve(server *http.Server, listener net.Listener, certFilename string,
+	keyFilename string, signals <-chan os.Signal) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if state.devMode {
+			serveErr <- server.Serve(listener)
+			return
+		}
+		serveErr <- server.ServeTLS(listener, certFilename, keyFilename)
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case received := <-signals:
+		slog.Info("shutting down", "signal", received.String())
+	}
+	state.shutdown(server)
+	return nil
+}
+
+// shutdown drains the requests and the jobs, the timeouts are logged.
+func (state *RuntimeState) shutdown(server *http.Server) {
+	start := time.Now()
+	state.shuttingDown.Store(true)
+	time.Sleep(state.Config.Shutdown.unreadyDelay())
+	ctx, cancel := context.WithTimeout(context.Background(), state.Config.Shutdown.drainTimeout())
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("the requests in flight were not drained", "err", err)
+	}
+	err = state.jobs.shutdown(ctx)
+	if err != nil {
+		slog.Warn("the running jobs were not drained", "err", err)
+	}
+	if state.leaderElector != nil {
+		err = state.leaderElector.release()
+		if err != nil {
+			slog.Error("cannot release the scheduler lease", "err", err)
+		}
+	}
+	slog.Info("shutdown done", "duration", time.Since(start))
+}
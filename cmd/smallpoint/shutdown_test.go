@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsRequestsAndJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shutdown_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFilename, keyFilename := testWriteCertificate(t, dir)
+	var state RuntimeState
+	state.Config.Shutdown.DrainTimeout = 5 * time.Second
+	state.Config.Shutdown.UnreadyDelay = 300 * time.Millisecond
+
+	var jobRuns int32
+	jobStarted := make(chan struct{})
+	state.startPeriodicJob("test", time.Hour, func() error {
+		if atomic.AddInt32(&jobRuns, 1) == 1 {
+			close(jobStarted)
+		}
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	<-jobStarted
+
+	requestStarted := make(chan struct{})
+	releaseRequest := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		<-releaseRequest
+		w.Write([]byte("done"))
+	})}
+	listener, err := listen("127.0.0.1:0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- state.serve(server, listener, certFilename, keyFilename, signals)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	response := make(chan error, 1)
+	go func() {
+		resp, err := client.Get("https://" + address + "/")
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "done" {
+				err = fmt.Errorf("unexpected body %q", body)
+			}
+		}
+		response <- err
+	}()
+	<-requestStarted
+	signals <- syscall.SIGTERM
+	// the instance is unready while it still accepts connections
+	for !state.shuttingDown.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("the listener was closed during the unready delay: %s", err)
+	}
+	conn.Close()
+	// the listener is closed while the request in flight is drained
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the listener is still open")
+		}
+	}
+	if !state.shuttingDown.Load() {
+		t.Fatal("the readiness does not report the shutdown")
+	}
+	close(releaseRequest)
+	if err := <-response; err != nil {
+		t.Fatalf("the request in flight failed: %s", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	if state.jobs.sleep(time.Millisecond) || atomic.LoadInt32(&jobRuns) != 1 {
+		t.Fatalf("the jobs were not stopped, runs=%d", jobRuns)
+	}
+}
@@ -0,0 +1,846 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo"
+)
+
+// The SQL directory keeps the users, the groups, their members and the
+// service accounts in the database instead of LDAP, for the deployments that
+// want the request and approval workflow and the audit trail without a
+// writable directory. It replaces target_config when enabled. The users are
+// copied from source_config when they first sign in, like with LDAP, and
+// are created from their username when there is no source directory.
+//
+// The groups are flat: the member DNs of the nested groups are not kept.
+// The multiple values of the mail and given name attributes are stored one
+// per line.
+
+const sqlDirectoryFirstGidNumber = 10000
+
+const sqlDirectoryValueSeparator = "\n"
+
+type sqlDirectoryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// SuperAdmins is the comma separated list of the usernames of the super
+	// admins, like super_admins of target_config.
+	SuperAdmins string `yaml:"super_admins"`
+	// MailDomain is the domain of the mail of the users created when there
+	// is no source directory, username@MailDomain.
+	MailDomain string `yaml:"mail_domain"`
+}
+
+func (config sqlDirectoryConfig) check(source string) error {
+	if !config.Enabled {
+		return nil
+	}
+	if strings.TrimSpace(config.SuperAdmins) == "" {
+		return errors.New("super_admins is required")
+	}
+	if source == "" && config.MailDomain == "" {
+		return errors.New("mail_domain is required without source_config")
+	}
+	return nil
+}
+
+const getSQLDirectoryUsersStmt = "select username from sql_directory_users order by username;"
+
+const getSQLDirectoryGroupsStmt = "select groupname, managed_by from sql_directory_groups order by groupname;"
+
+const getSQLDirectoryGidNumbersStmt = "select gid_number from sql_directory_groups union all " +
+	"select gid_number from sql_directory_service_accounts;"
+
+var getSQLDirectoryUserStmt = map[string]string{
+	"sqlite":   "select email, given_name from sql_directory_users where username=?;",
+	"postgres": "select email, given_name from sql_directory_users where username=$1;",
+}
+
+var searchSQLDirectoryUsersStmt = map[string]string{
+	"sqlite": "select username, given_name, email from sql_directory_users where lower(username) like ? escape '\\' " +
+		"or lower(given_name) like ? escape '\\' or lower(email) like ? escape '\\' order by username limit ?;",
+	"postgres": "select username, given_name, email from sql_directory_users where lower(username) like $1 escape '\\' " +
+		"or lower(given_name) like $2 escape '\\' or lower(email) like $3 escape '\\' order by username limit $4;",
+}
+
+var insertSQLDirectoryUserStmt = map[string]string{
+	"sqlite":   "insert into sql_directory_users(username, email, given_name) values (?,?,?);",
+	"postgres": "insert into sql_directory_users(username, email, given_name) values ($1,$2,$3);",
+}
+
+var getSQLDirectoryGroupStmt = map[string]string{
+	"sqlite":   "select managed_by from sql_directory_groups where groupname=?;",
+	"postgres": "select managed_by from sql_directory_groups where groupname=$1;",
+}
+
+var getSQLDirectoryMembersStmt = map[string]string{
+	"sqlite":   "select username from sql_directory_members where groupname=? order by username;",
+	"postgres": "select username from sql_directory_members where groupname=$1 order by username;",
+}
+
+var getSQLDirectoryUserGroupsStmt = map[string]string{
+	"sqlite": "select sql_directory_groups.groupname, sql_directory_groups.managed_by from sql_directory_members " +
+		"join sql_directory_groups on sql_directory_groups.groupname=sql_directory_members.groupname " +
+		"where sql_directory_members.username=? order by sql_directory_groups.groupname;",
+	"postgres": "select sql_directory_groups.groupname, sql_directory_groups.managed_by from sql_directory_members " +
+		"join sql_directory_groups on sql_directory_groups.groupname=sql_directory_members.groupname " +
+		"where sql_directory_members.username=$1 order by sql_directory_groups.groupname;",
+}
+
+var getSQLDirectoryMemberStmt = map[string]string{
+	"sqlite":   "select count(*) from sql_directory_members where groupname=? and username=?;",
+	"postgres": "select count(*) from sql_directory_members where groupname=$1 and username=$2;",
+}
+
+var insertSQLDirectoryGroupStmt = map[string]string{
+	"sqlite":   "insert into sql_directory_groups(groupname, managed_by, gid_number, mail) values (?,?,?,'');",
+	"postgres": "insert into sql_directory_groups(groupname, managed_by, gid_number, mail) values ($1,$2,$3,'');",
+}
+
+var insertSQLDirectoryMemberStmt = map[string]string{
+	"sqlite":   "insert into sql_directory_members(groupname, username) values (?,?) on conflict do nothing;",
+	"postgres": "insert into sql_directory_members(groupname, username) values ($1,$2) on conflict do nothing;",
+}
+
+var deleteSQLDirectoryMemberStmt = map[string]string{
+	"sqlite":   "delete from sql_directory_members where groupname=? and username=?;",
+	"postgres": "delete from sql_directory_members where groupname=$1 and username=$2;",
+}
+
+var deleteSQLDirectoryGroupStmts = map[string][]string{
+	"sqlite": {"delete from sql_directory_groups where groupname=?;",
+		"delete from sql_directory_members where groupname=?;"},
+	"postgres": {"delete from sql_directory_groups where groupname=$1;",
+		"delete from sql_directory_members where groupname=$1;"},
+}
+
+var updateSQLDirectoryManagerStmt = map[string]string{
+	"sqlite":   "update sql_directory_groups set managed_by=? where groupname=?;",
+	"postgres": "update sql_directory_groups set managed_by=$1 where groupname=$2;",
+}
+
+var updateSQLDirectoryGroupMailStmt = map[string]string{
+	"sqlite":   "update sql_directory_groups set mail=? where groupname=?;",
+	"postgres": "update sql_directory_groups set mail=$1 where groupname=$2;",
+}
+
+// renameSQLDirectoryGroupStmts take the new name then the old one, the
+// groups managed by the group follow it.
+var renameSQLDirectoryGroupStmts = map[string][]string{
+	"sqlite": {"update sql_directory_groups set groupname=? where groupname=?;",
+		"update sql_directory_members set groupname=? where groupname=?;",
+		"update sql_directory_groups set managed_by=? where managed_by=?;"},
+	"postgres": {"update sql_directory_groups set groupname=$1 where groupname=$2;",
+		"update sql_directory_members set groupname=$1 where groupname=$2;",
+		"update sql_directory_groups set managed_by=$1 where managed_by=$2;"},
+}
+
+// getSQLDirectoryMailOwnersStmts select the name and the mail of the
+// entries whose mail contains the address.
+var getSQLDirectoryMailOwnersStmts = map[string][]string{
+	"sqlite": {"select username, email from sql_directory_users where email like ? escape '\\';",
+		"select groupname, mail from sql_directory_groups where mail like ? escape '\\';",
+		"select accountname, mail from sql_directory_service_accounts where mail like ? escape '\\';"},
+	"postgres": {"select username, email from sql_directory_users where email like $1 escape '\\';",
+		"select groupname, mail from sql_directory_groups where mail like $1 escape '\\';",
+		"select accountname, mail from sql_directory_service_accounts where mail like $1 escape '\\';"},
+}
+
+var getSQLDirectoryServiceAccountStmt = map[string]string{
+	"sqlite":   "select accountname from sql_directory_service_accounts where accountname=?;",
+	"postgres": "select accountname from sql_directory_service_accounts where accountname=$1;",
+}
+
+var insertSQLDirectoryServiceAccountStmt = map[string]string{
+	"sqlite": "insert into sql_directory_service_accounts(accountname, uid_number, gid_number, mail, login_shell, password, disabled) " +
+		"values (?,?,?,?,?,'',0);",
+	"postgres": "insert into sql_directory_service_accounts(accountname, uid_number, gid_number, mail, login_shell, password, disabled) " +
+		"values ($1,$2,$3,$4,$5,'',0);",
+}
+
+var updateSQLDirectoryServiceAccountPasswordStmt = map[string]string{
+	"sqlite":   "update sql_directory_service_accounts set password=? where accountname=?;",
+	"postgres": "update sql_directory_service_accounts set password=$1 where accountname=$2;",
+}
+
+var disableSQLDirectoryServiceAccountStmt = map[string]string{
+	"sqlite":   "update sql_directory_service_accounts set disabled=1, login_shell='/bin/false' where accountname=?;",
+	"postgres": "update sql_directory_service_accounts set disabled=1, login_shell='/bin/false' where accountname=$1;",
+}
+
+var deleteSQLDirectoryServiceAccountStmt = map[string]string{
+	"sqlite":   "delete from sql_directory_service_accounts where accountname=?;",
+	"postgres": "delete from sql_directory_service_accounts where accountname=$1;",
+}
+
+// sqlUserInfo is the userinfo.UserInfoBackend of the SQL directory.
+type sqlUserInfo struct {
+	state  *RuntimeState
+	config sqlDirectoryConfig
+}
+
+func newSQLUserInfo(state *RuntimeState, config sqlDirectoryConfig) *sqlUserInfo {
+	return &sqlUserInfo{state: state, config: config}
+}
+
+// sqlDirectoryUserSource creates the users of the SQL directory when there
+// is no source directory: their given name is their username and their mail
+// is in the mail domain.
+type sqlDirectoryUserSource struct {
+	*sqlUserInfo
+}
+
+func (u sqlDirectoryUserSource) GetUserAttributes(username string) ([]string, []string, error) {
+	return []string{username + "@" + u.config.MailDomain}, []string{username}, nil
+}
+
+func joinSQLDirectoryValues(values []string) string {
+	return strings.Join(values, sqlDirectoryValueSeparator)
+}
+
+func splitSQLDirectoryValues(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, sqlDirectoryValueSeparator)
+}
+
+// likePattern returns the pattern of the values containing s, or starting
+// with it.
+func likePattern(s string, prefix bool) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	if prefix {
+		return s + "%"
+	}
+	return "%" + s + "%"
+}
+
+func (u *sqlUserInfo) exec(stmtText string, args ...interface{}) (int64, error) {
+	start := time.Now()
+	result, err := u.state.db.Exec(stmtText, args...)
+	if err != nil {
+		return 0, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return result.RowsAffected()
+}
+
+// execTx runs the statements of the same arguments in one transaction.
+func (u *sqlUserInfo) execTx(stmtTexts []string, args ...interface{}) error {
+	start := time.Now()
+	tx, err := u.state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmtText := range stmtTexts {
+		_, err = tx.Exec(stmtText, args...)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func (u *sqlUserInfo) queryGroupTuples(stmtText string, args ...interface{}) ([][]string, error) {
+	start := time.Now()
+	rows, err := u.state.db.Query(stmtText, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	groups := [][]string{}
+	for rows.Next() {
+		var groupname, managedBy string
+		err = rows.Scan(&groupname, &managedBy)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, []string{groupname, managedBy})
+	}
+	return groups, rows.Err()
+}
+
+// getGroup returns userinfo.GroupDoesNotExist for a missing group.
+func (u *sqlUserInfo) getGroup(groupname string) ([]string, string, error) {
+	state := u.state
+	var managedBy string
+	err := state.db.QueryRow(getSQLDirectoryGroupStmt[state.dbType], groupname).Scan(&managedBy)
+	if err == sql.ErrNoRows {
+		return nil, "", userinfo.GroupDoesNotExist
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	members, err := queryStringsFromDB(state, getSQLDirectoryMembersStmt[state.dbType], groupname)
+	if err != nil {
+		return nil, "", err
+	}
+	return members, managedBy, nil
+}
+
+// getUser returns userinfo.UserDoesNotExist for a missing user.
+func (u *sqlUserInfo) getUser(username string) ([]string, []string, error) {
+	state := u.state
+	var email, givenName string
+	err := state.db.QueryRow(getSQLDirectoryUserStmt[state.dbType], username).Scan(&email, &givenName)
+	if err == sql.ErrNoRows {
+		return nil, nil, userinfo.UserDoesNotExist
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return splitSQLDirectoryValues(email), splitSQLDirectoryValues(givenName), nil
+}
+
+func (u *sqlUserInfo) isMember(groupname string, username string) (bool, error) {
+	state := u.state
+	var count int
+	err := state.db.QueryRow(getSQLDirectoryMemberStmt[state.dbType], groupname, username).Scan(&count)
+	return count > 0, err
+}
+
+func (u *sqlUserInfo) getGidNumbers() ([]int, error) {
+	values, err := queryStringsFromDB(u.state, getSQLDirectoryGidNumbersStmt)
+	if err != nil {
+		return nil, err
+	}
+	var gidNumbers []int
+	for _, value := range values {
+		gidNumber, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		gidNumbers = append(gidNumbers, gidNumber)
+	}
+	return gidNumbers, nil
+}
+
+// nextGidNumber returns the number after the highest one, like LDAP does.
+func (u *sqlUserInfo) nextGidNumber() (int, error) {
+	gidNumbers, err := u.getGidNumbers()
+	if err != nil {
+		return 0, err
+	}
+	next := sqlDirectoryFirstGidNumber
+	for _, gidNumber := range gidNumbers {
+		if gidNumber >= next {
+			next = gidNumber + 1
+		}
+	}
+	return next, nil
+}
+
+func (u *sqlUserInfo) GetallUsers() ([]string, error) {
+	return queryStringsFromDB(u.state, getSQLDirectoryUsersStmt)
+}
+
+func (u *sqlUserInfo) SearchUsers(prefix string, limit int) ([]userinfo.UserSearchResult, error) {
+	state := u.state
+	pattern := likePattern(strings.ToLower(prefix), true)
+	start := time.Now()
+	rows, err := state.db.Query(searchSQLDirectoryUsersStmt[state.dbType], pattern, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	var users []userinfo.UserSearchResult
+	for rows.Next() {
+		var username, givenName, email string
+		err = rows.Scan(&username, &givenName, &email)
+		if err != nil {
+			return nil, err
+		}
+		user := userinfo.UserSearchResult{Username: username, DisplayName: username}
+		if givenNames := splitSQLDirectoryValues(givenName); len(givenNames) > 0 {
+			user.DisplayName = givenNames[0]
+		}
+		if emails := splitSQLDirectoryValues(email); len(emails) > 0 {
+			user.Email = emails[0]
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (u *sqlUserInfo) CreateUser(username string, givenName, email []string) error {
+	_, err := u.exec(insertSQLDirectoryUserStmt[u.state.dbType], username, joinSQLDirectoryValues(email),
+		joinSQLDirectoryValues(givenName))
+	return err
+}
+
+func (u *sqlUserInfo) GetUserAttributes(username string) ([]string, []string, error) {
+	return u.getUser(username)
+}
+
+func (u *sqlUserInfo) GetEmailofauser(username string) ([]string, error) {
+	email, _, err := u.getUser(username)
+	return email, err
+}
+
+func (u *sqlUserInfo) UsernameExistsornot(username string) (bool, error) {
+	_, _, err := u.getUser(username)
+	if err == nil {
+		return true, nil
+	}
+	if err != userinfo.UserDoesNotExist {
+		return false, err
+	}
+	// like LDAP, the service accounts are users too
+	exists, _, err := u.ServiceAccountExistsornot(username)
+	return exists, err
+}
+
+func (u *sqlUserInfo) ParseSuperadmins() []string {
+	var superAdmins []string
+	for _, admin := range strings.Split(u.config.SuperAdmins, ",") {
+		superAdmins = append(superAdmins, strings.TrimSpace(admin))
+	}
+	return superAdmins
+}
+
+func (u *sqlUserInfo) UserisadminOrNot(username string) bool {
+	for _, admin := range u.ParseSuperadmins() {
+		if admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *sqlUserInfo) CreateGroup(groupinfo userinfo.GroupInfo) error {
+	state := u.state
+	exists, _, err := u.GroupnameExistsornot(groupinfo.Groupname)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("group %s already exists", groupinfo.Groupname)
+	}
+	gidNumber := groupinfo.GidNumber
+	if gidNumber == "" {
+		next, err := u.nextGidNumber()
+		if err != nil {
+			return err
+		}
+		gidNumber = strconv.Itoa(next)
+	}
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(insertSQLDirectoryGroupStmt[state.dbType], groupinfo.Groupname, groupinfo.Description, gidNumber)
+	if err != nil {
+		return err
+	}
+	for _, member := range groupinfo.MemberUid {
+		_, err = tx.Exec(insertSQLDirectoryMemberStmt[state.dbType], groupinfo.Groupname, member)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func (u *sqlUserInfo) GetUsedGidNumbers(min int, max int) (map[int]bool, error) {
+	gidNumbers, err := u.getGidNumbers()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[int]bool)
+	for _, gidNumber := range gidNumbers {
+		if gidNumber >= min && gidNumber <= max {
+			used[gidNumber] = true
+		}
+	}
+	return used, nil
+}
+
+func (u *sqlUserInfo) DeleteGroup(groupnames []string) error {
+	for _, groupname := range groupnames {
+		err := u.execTx(deleteSQLDirectoryGroupStmts[u.state.dbType], groupname)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *sqlUserInfo) ChangeDescription(groupname string, managegroup string) error {
+	updated, err := u.exec(updateSQLDirectoryManagerStmt[u.state.dbType], managegroup, groupname)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return userinfo.GroupDoesNotExist
+	}
+	return nil
+}
+
+func (u *sqlUserInfo) SetGroupMail(groupname string, addresses []string) error {
+	updated, err := u.exec(updateSQLDirectoryGroupMailStmt[u.state.dbType], joinSQLDirectoryValues(addresses),
+		groupname)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return userinfo.GroupDoesNotExist
+	}
+	return nil
+}
+
+func (u *sqlUserInfo) GetMailOwners(address string) ([]string, error) {
+	state := u.state
+	var owners []string
+	for _, stmtText := range getSQLDirectoryMailOwnersStmts[state.dbType] {
+		start := time.Now()
+		rows, err := state.db.Query(stmtText, likePattern(address, false))
+		if err != nil {
+			return nil, err
+		}
+		metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+		for rows.Next() {
+			var name, mail string
+			err = rows.Scan(&name, &mail)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			for _, value := range splitSQLDirectoryValues(mail) {
+				if value == address {
+					owners = append(owners, name)
+					break
+				}
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return owners, nil
+}
+
+func (u *sqlUserInfo) RenameGroup(groupname string, newname string) error {
+	exists, _, err := u.GroupnameExistsornot(groupname)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return userinfo.GroupDoesNotExist
+	}
+	exists, _, err = u.GroupnameExistsornot(newname)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("group %s already exists", newname)
+	}
+	return u.execTx(renameSQLDirectoryGroupStmts[u.state.dbType], newname, groupname)
+}
+
+func (u *sqlUserInfo) GetallGroups() ([]string, error) {
+	groups, err := u.queryGroupTuples(getSQLDirectoryGroupsStmt)
+	if err != nil {
+		return nil, err
+	}
+	var groupnames []string
+	for _, group := range groups {
+		groupnames = append(groupnames, group[0])
+	}
+	return groupnames, nil
+}
+
+func (u *sqlUserInfo) GetgroupsofUser(username string) ([]string, error) {
+	groups, err := u.queryGroupTuples(getSQLDirectoryUserGroupsStmt[u.state.dbType], username)
+	if err != nil {
+		return nil, err
+	}
+	var groupnames []string
+	for _, group := range groups {
+		groupnames = append(groupnames, group[0])
+	}
+	return groupnames, nil
+}
+
+func (u *sqlUserInfo) GetusersofaGroup(groupname string) ([]string, string, error) {
+	return u.getGroup(groupname)
+}
+
+func (u *sqlUserInfo) GetGroupUsersAndManagers(groupname string) ([]string, []string, string, error) {
+	members, managedBy, err := u.getGroup(groupname)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	managers, _, err := u.getGroup(managedBy)
+	if err != nil && err != userinfo.GroupDoesNotExist {
+		return nil, nil, "", err
+	}
+	return members, managers, managedBy, nil
+}
+
+func (u *sqlUserInfo) AddmemberstoExisting(groupinfo userinfo.GroupInfo) error {
+	state := u.state
+	if _, _, err := u.getGroup(groupinfo.Groupname); err != nil {
+		return err
+	}
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, member := range groupinfo.MemberUid {
+		_, err = tx.Exec(insertSQLDirectoryMemberStmt[state.dbType], groupinfo.Groupname, member)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+func (u *sqlUserInfo) DeletemembersfromGroup(groupinfo userinfo.GroupInfo) error {
+	if _, _, err := u.getGroup(groupinfo.Groupname); err != nil {
+		return err
+	}
+	for _, member := range groupinfo.MemberUid {
+		_, err := u.exec(deleteSQLDirectoryMemberStmt[u.state.dbType], groupinfo.Groupname, member)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *sqlUserInfo) IsgroupmemberorNot(groupname string, username string) (bool, string, error) {
+	managedBy, err := u.GetDescriptionvalue(groupname)
+	if err != nil {
+		return false, "", err
+	}
+	isMember, err := u.isMember(groupname, username)
+	if err != nil {
+		return false, "", err
+	}
+	return isMember, managedBy, nil
+}
+
+func (u *sqlUserInfo) GetDescriptionvalue(groupname string) (string, error) {
+	state := u.state
+	var managedBy string
+	err := state.db.QueryRow(getSQLDirectoryGroupStmt[state.dbType], groupname).Scan(&managedBy)
+	if err == sql.ErrNoRows {
+		return "", userinfo.GroupDoesNotExist
+	}
+	return managedBy, err
+}
+
+func (u *sqlUserInfo) GetEmailofusersingroup(groupname string) ([]string, error) {
+	members, _, err := u.getGroup(groupname)
+	if err != nil {
+		return nil, err
+	}
+	var emails []string
+	for _, member := range members {
+		email, _, err := u.getUser(member)
+		// like LDAP, the members without mail are left out
+		if err == userinfo.UserDoesNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email...)
+	}
+	return emails, nil
+}
+
+func (u *sqlUserInfo) IsgroupAdminorNot(username string, groupname string) (bool, error) {
+	if u.UserisadminOrNot(username) {
+		return true, nil
+	}
+	managedBy, err := u.GetDescriptionvalue(groupname)
+	if err != nil {
+		return false, err
+	}
+	if managedBy == descriptionAttribute {
+		managedBy = groupname
+	}
+	return u.isMember(managedBy, username)
+}
+
+func (u *sqlUserInfo) GroupnameExistsornot(groupname string) (bool, string, error) {
+	managedBy, err := u.GetDescriptionvalue(groupname)
+	if err == nil {
+		return true, managedBy, nil
+	}
+	if err != userinfo.GroupDoesNotExist {
+		return false, "", err
+	}
+	// like LDAP, the groups of the service accounts are groups too
+	exists, _, err := u.ServiceAccountExistsornot(groupname)
+	return exists, "", err
+}
+
+func (u *sqlUserInfo) CreateServiceAccount(groupinfo userinfo.GroupInfo) error {
+	exists, err := u.UsernameExistsornot(groupinfo.Groupname)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("user %s already exists", groupinfo.Groupname)
+	}
+	gidNumber := groupinfo.GidNumber
+	if gidNumber == "" {
+		next, err := u.nextGidNumber()
+		if err != nil {
+			return err
+		}
+		gidNumber = strconv.Itoa(next)
+	}
+	// the account is the only member of its own group
+	_, err = u.exec(insertSQLDirectoryServiceAccountStmt[u.state.dbType], groupinfo.Groupname, gidNumber,
+		gidNumber, groupinfo.Mail, groupinfo.LoginShell)
+	return err
+}
+
+func (u *sqlUserInfo) ServiceAccountExistsornot(groupname string) (bool, string, error) {
+	state := u.state
+	var accountname string
+	err := state.db.QueryRow(getSQLDirectoryServiceAccountStmt[state.dbType], groupname).Scan(&accountname)
+	if err == sql.ErrNoRows {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, "", nil
+}
+
+// updateServiceAccount fails when the account does not exist.
+func (u *sqlUserInfo) updateServiceAccount(accountname string, stmtText string, args ...interface{}) error {
+	updated, err := u.exec(stmtText, args...)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("service account %s does not exist", accountname)
+	}
+	return nil
+}
+
+func (u *sqlUserInfo) DisableServiceAccount(accountname string) error {
+	return u.updateServiceAccount(accountname, disableSQLDirectoryServiceAccountStmt[u.state.dbType], accountname)
+}
+
+func (u *sqlUserInfo) DeleteServiceAccount(accountname string) error {
+	deleted, err := u.exec(deleteSQLDirectoryServiceAccountStmt[u.state.dbType], accountname)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return userinfo.UserDoesNotExist
+	}
+	return nil
+}
+
+// SetServiceAccountPassword stores the salted SHA-256 of the password in the
+// {SSHA256} format of the LDAP servers.
+func (u *sqlUserInfo) SetServiceAccountPassword(accountname string, password string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(append([]byte(password), salt...))
+	hashed := "{SSHA256}" + base64.StdEncoding.EncodeToString(append(sum[:], salt...))
+	return u.updateServiceAccount(accountname, updateSQLDirectoryServiceAccountPasswordStmt[u.state.dbType],
+		hashed, accountname)
+}
+
+func (u *sqlUserInfo) GetAllGroupsManagedBy() ([][]string, error) {
+	return u.queryGroupTuples(getSQLDirectoryGroupsStmt)
+}
+
+// GetGroupsInfoOfUser ignores the base DN, the SQL directory has one.
+func (u *sqlUserInfo) GetGroupsInfoOfUser(groupdn string, username string) ([][]string, error) {
+	return u.queryGroupTuples(getSQLDirectoryUserGroupsStmt[u.state.dbType], username)
+}
+
+func (u *sqlUserInfo) GetGroupandManagedbyAttributeValue(groupnames []string) ([][]string, error) {
+	var groups [][]string
+	for _, groupname := range groupnames {
+		managedBy, err := u.GetDescriptionvalue(groupname)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, []string{groupname, managedBy})
+	}
+	return groups, nil
+}
+
+// GetSubgroupsofGroup returns none, the groups of the SQL directory are
+// flat.
+func (u *sqlUserInfo) GetSubgroupsofGroup(groupname string) ([]string, error) {
+	_, err := u.GetDescriptionvalue(groupname)
+	return nil, err
+}
+
+func (u *sqlUserInfo) GetParentgroupsofGroup(groupname string) ([]string, error) {
+	_, err := u.GetDescriptionvalue(groupname)
+	return nil, err
+}
+
+func (u *sqlUserInfo) Ping() error {
+	return u.state.db.Ping()
+}
+
+// Diagnose checks the database for the doctor command, the SQL directory
+// has no schema of its own to check.
+func (u *sqlUserInfo) Diagnose(options ldapuserinfo.DiagnoseOptions) []ldapuserinfo.DiagnosticResult {
+	result := ldapuserinfo.DiagnosticResult{Check: "sql directory", Passed: true,
+		Detail: "the directory is in the database"}
+	if err := u.Ping(); err != nil {
+		result.Passed = false
+		result.Detail = err.Error()
+	}
+	return []ldapuserinfo.DiagnosticResult{result}
+}
+
+// MissingBaseDNs returns none for the bootstrap, the SQL directory has no
+// base entries.
+func (u *sqlUserInfo) MissingBaseDNs() ([]string, error) {
+	return nil, nil
+}
+
+func (u *sqlUserInfo) CreateOrganizationalUnit(dn string) error {
+	return errors.New("the SQL directory has no organizational units")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/userinfotest"
+)
+
+var _ userinfo.UserInfoBackend = &sqlUserInfo{}
+
+func testSQLDirectoryState(t *testing.T, config sqlDirectoryConfig) (*RuntimeState, *sqlUserInfo) {
+	state := testInitDB(t, filepath.Join(t.TempDir(), "sqldirectory.db"))
+	t.Cleanup(func() { state.db.Close() })
+	directory := newSQLUserInfo(state, config)
+	state.Userinfo = directory
+	return state, directory
+}
+
+func TestSQLDirectoryContract(t *testing.T) {
+	_, directory := testSQLDirectoryState(t, sqlDirectoryConfig{Enabled: true, SuperAdmins: userinfotest.SuperAdmin})
+	userinfotest.TestBackend(t, directory)
+}
+
+func TestSQLDirectory(t *testing.T) {
+	config := sqlDirectoryConfig{Enabled: true, SuperAdmins: "admin", MailDomain: "example.org"}
+	state, directory := testSQLDirectoryState(t, config)
+	state.allUsersCacheValue = make(map[string]time.Time)
+	state.UserSourceinfo = sqlDirectoryUserSource{directory}
+
+	// without a source directory the users are created from their username
+	if err := state.createUserorNot("user_1"); err != nil {
+		t.Fatal(err)
+	}
+	email, givenName, err := directory.GetUserAttributes("user_1")
+	if err != nil || len(email) != 1 || email[0] != "user_1@example.org" || givenName[0] != "user_1" {
+		t.Fatalf("unexpected attributes %v %v, err %v", email, givenName, err)
+	}
+	// the wildcards of LIKE match themselves only
+	if err := directory.CreateUser("userx1", []string{"Mary Ann", "Mary"}, []string{"userx1@example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := directory.SearchUsers("user_", 10)
+	if err != nil || len(found) != 1 || found[0].Username != "user_1" {
+		t.Fatalf("unexpected search results %+v, err %v", found, err)
+	}
+	_, givenName, err = directory.GetUserAttributes("userx1")
+	if err != nil || strings.Join(givenName, ",") != "Mary Ann,Mary" {
+		t.Fatalf("unexpected given names %v, err %v", givenName, err)
+	}
+
+	// the groups are flat
+	err = directory.CreateGroup(userinfo.GroupInfo{Groupname: "parent", Description: descriptionAttribute,
+		MemberUid: []string{"user_1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = directory.AddmemberstoExisting(userinfo.GroupInfo{Groupname: "parent",
+		Member: []string{"cn=child,ou=groups,dc=example,dc=org"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, _, err := directory.GetusersofaGroup("parent")
+	if err != nil || len(members) != 1 {
+		t.Fatalf("unexpected members %v, err %v", members, err)
+	}
+	used, err := directory.GetUsedGidNumbers(sqlDirectoryFirstGidNumber, sqlDirectoryFirstGidNumber)
+	if err != nil || !used[sqlDirectoryFirstGidNumber] {
+		t.Fatalf("the first gidNumber is not used %v, err %v", used, err)
+	}
+
+	err = directory.CreateServiceAccount(userinfo.GroupInfo{Groupname: "svc", Mail: "svc@example.org",
+		LoginShell: "/bin/bash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := directory.CreateServiceAccount(userinfo.GroupInfo{Groupname: "user_1"}); err == nil {
+		t.Fatal("a service account took the name of a user")
+	}
+	if err := directory.SetServiceAccountPassword("svc", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	var password, loginShell string
+	err = state.db.QueryRow("select password, login_shell from sql_directory_service_accounts;").Scan(&password,
+		&loginShell)
+	if err != nil || !strings.HasPrefix(password, "{SSHA256}") || strings.Contains(password, "secret") {
+		t.Fatalf("the password is stored as %q, err %v", password, err)
+	}
+	if err := directory.DisableServiceAccount("svc"); err != nil {
+		t.Fatal(err)
+	}
+	err = state.db.QueryRow("select login_shell from sql_directory_service_accounts;").Scan(&loginShell)
+	if err != nil || loginShell != "/bin/false" {
+		t.Fatalf("the disabled account has the shell %q, err %v", loginShell, err)
+	}
+	if results := directory.Diagnose(ldapuserinfo.DiagnoseOptions{}); len(results) != 1 || !results[0].Passed {
+		t.Fatalf("unexpected diagnosis %+v", results)
+	}
+	if missing, err := directory.MissingBaseDNs(); err != nil || len(missing) != 0 {
+		t.Fatalf("unexpected missing base DNs %v, err %v", missing, err)
+	}
+
+	for _, test := range []struct {
+		config sqlDirectoryConfig
+		source string
+		valid  bool
+	}{
+		{sqlDirectoryConfig{}, "", true},
+		{config, "", true},
+		{sqlDirectoryConfig{Enabled: true, SuperAdmins: "admin"}, "ldaps://ldap.example.org", true},
+		{sqlDirectoryConfig{Enabled: true, SuperAdmins: "admin"}, "", false},
+		{sqlDirectoryConfig{Enabled: true, MailDomain: "example.org"}, "", false},
+	} {
+		if err := test.config.check(test.source); (err == nil) != test.valid {
+			t.Errorf("%+v with source %q: err %v", test.config, test.source, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The SQLite database runs in WAL mode so that the readers do not block the
+// writer, the connections wait for the lock of another writer for the busy
+// timeout and the transactions take the write lock when they begin, an
+// upgrade from a read lock could not wait and would fail with "database is
+// locked". The freed pages are returned by the periodic maintenance, which
+// also checkpoints the WAL and refreshes the query planner statistics.
+
+const (
+	defaultSQLiteBusyTimeout         = 5 * time.Second
+	defaultSQLiteMaintenanceInterval = 24 * time.Hour
+	sqliteIncrementalAutoVacuum      = 2
+)
+
+type sqliteConfig struct {
+	// BusyTimeout is how long a statement waits for the database lock,
+	// 5s by default.
+	BusyTimeout time.Duration `yaml:"busy_timeout"`
+	// MaintenanceInterval between the vacuums and the WAL checkpoints,
+	// 24h by default.
+	MaintenanceInterval time.Duration `yaml:"maintenance_interval"`
+}
+
+func (config sqliteConfig) busyTimeout() time.Duration {
+	if config.BusyTimeout > 0 {
+		return config.BusyTimeout
+	}
+	return defaultSQLiteBusyTimeout
+}
+
+func (config sqliteConfig) maintenanceInterval() time.Duration {
+	if config.MaintenanceInterval > 0 {
+		return config.MaintenanceInterval
+	}
+	return defaultSQLiteMaintenanceInterval
+}
+
+// sqliteDSN adds the connection parameters to the database filename, the
+// parameters given in the storage URL are kept.
+func sqliteDSN(filename string, config sqliteConfig) (string, error) {
+	// the temporary databases take no parameters
+	if filename == "" {
+		return filename, nil
+	}
+	params := url.Values{}
+	if pos := strings.IndexRune(filename, '?'); pos >= 0 {
+		var err error
+		params, err = url.ParseQuery(filename[pos+1:])
+		if err != nil {
+			return "", err
+		}
+		filename = filename[:pos]
+	}
+	defaults := map[string]string{
+		"_journal_mode": "WAL",
+		"_synchronous":  "NORMAL",
+		"_busy_timeout": strconv.FormatInt(config.busyTimeout().Milliseconds(), 10),
+		"_txlock":       "immediate",
+	}
+	for key, value := range defaults {
+		if _, ok := params[key]; !ok {
+			params.Set(key, value)
+		}
+	}
+	return filename + "?" + params.Encode(), nil
+}
+
+// setupSQLiteAutoVacuum switches the database to incremental auto vacuum,
+// the databases created without it are vacuumed once.
+func setupSQLiteAutoVacuum(state *RuntimeState) error {
+	var autoVacuum int
+	err := state.db.QueryRow("PRAGMA auto_vacuum;").Scan(&autoVacuum)
+	if err != nil {
+		return err
+	}
+	if autoVacuum == sqliteIncrementalAutoVacuum {
+		return nil
+	}
+	_, err = state.db.Exec("PRAGMA auto_vacuum = INCREMENTAL;")
+	if err != nil {
+		return err
+	}
+	slog.Info("vacuuming the database for incremental auto vacuum")
+	_, err = state.db.Exec("VACUUM;")
+	return err
+}
+
+func (state *RuntimeState) runSQLiteMaintenance() error {
+	for _, stmt := range []string{
+		"PRAGMA incremental_vacuum;",
+		"PRAGMA wal_checkpoint(TRUNCATE);",
+		"PRAGMA optimize;",
+	} {
+		_, err := state.db.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDSN(t *testing.T) {
+	dsn, err := sqliteDSN("/var/lib/smallpoint/requests.db?_busy_timeout=100&cache=shared",
+		sqliteConfig{BusyTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "/var/lib/smallpoint/requests.db?_busy_timeout=100&_journal_mode=WAL&" +
+		"_synchronous=NORMAL&_txlock=immediate&cache=shared"
+	if dsn != expected {
+		t.Fatalf("unexpected DSN %q", dsn)
+	}
+	dsn, err = sqliteDSN("", sqliteConfig{})
+	if err != nil || dsn != "" {
+		t.Fatalf("the temporary database DSN is %q err: %v", dsn, err)
+	}
+}
+
+func TestSQLiteSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "requests.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var journalMode string
+	var busyTimeout, autoVacuum int
+	err = state.db.QueryRow("PRAGMA journal_mode;").Scan(&journalMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.db.QueryRow("PRAGMA busy_timeout;").Scan(&busyTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.db.QueryRow("PRAGMA auto_vacuum;").Scan(&autoVacuum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journalMode != "wal" || busyTimeout != int(defaultSQLiteBusyTimeout.Milliseconds()) ||
+		autoVacuum != sqliteIncrementalAutoVacuum {
+		t.Fatalf("unexpected settings journal_mode=%s busy_timeout=%d auto_vacuum=%d",
+			journalMode, busyTimeout, autoVacuum)
+	}
+
+	// the concurrent transactions wait for each other
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx, err := state.db.Begin()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer tx.Rollback()
+			var count int
+			err = tx.QueryRow("select count(*) from pending_requests;").Scan(&count)
+			if err == nil {
+				_, err = tx.Exec(insertRequestStmt[state.dbType], "user1", "group1", time.Now().Unix())
+			}
+			if err == nil {
+				err = tx.Commit()
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	err = state.runSQLiteMaintenance()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The static assets are loaded at startup and served under names that carry
+// a hash of their content, the browsers can keep them for a year and fetch
+// them again only when they change. The text assets are served gzipped, a
+// brotli copy is served when a precompressed name.br file is next to the
+// asset.
+//
+// The assets are built into the binary, the files of the templates path and
+// of the override directory replace the built in ones of the same name.
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// embeddedTemplatesFS returns the built in templates directory.
+func embeddedTemplatesFS() fs.FS {
+	templates, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}
+
+const (
+	staticAssetHashLength     = 12
+	hashedAssetCacheControl   = "public, max-age=31536000, immutable"
+	unhashedAssetCacheControl = "no-cache"
+)
+
+var compressibleAssetExtensions = map[string]bool{
+	".css":  true,
+	".ico":  true,
+	".js":   true,
+	".json": true,
+	".svg":  true,
+	".txt":  true,
+}
+
+type staticAsset struct {
+	contentType string
+	etag        string
+	content     []byte
+	gzipped     []byte
+	brotli      []byte
+}
+
+type staticAssets struct {
+	// assets are keyed by both the plain and the hashed name.
+	assets      map[string]*staticAsset
+	hashedNames map[string]string
+	isHashed    map[string]bool
+	fallback    http.Handler
+}
+
+func hashedAssetName(name string, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+func gzipAsset(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(content)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// loadStaticAssets loads the assets below the dirs of the roots, the assets
+// of a root replace the ones of the same name of the previous roots. The
+// requests for other files go to fallback.
+func loadStaticAssets(roots []fs.FS, dirs []string, fallback http.Handler) (*staticAssets, error) {
+	assets := &staticAssets{
+		assets:      make(map[string]*staticAsset),
+		hashedNames: make(map[string]string),
+		isHashed:    make(map[string]bool),
+		fallback:    fallback,
+	}
+	for _, root := range roots {
+		err := assets.loadDirs(root, dirs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return assets, nil
+}
+
+// loadDirs loads the assets below the dirs of root, they replace the loaded
+// assets of the same name.
+func (a *staticAssets) loadDirs(root fs.FS, dirs []string) error {
+	for _, dir := range dirs {
+		dirPath := strings.Trim(dir, "/")
+		if _, err := fs.Stat(root, dirPath); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		err := fs.WalkDir(root, dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || path.Ext(filePath) == ".br" || path.Ext(filePath) == ".gz" {
+				return nil
+			}
+			return a.load(root, filePath)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *staticAssets) load(root fs.FS, filePath string) error {
+	name := "/" + filePath
+	content, err := fs.ReadFile(root, filePath)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])[:staticAssetHashLength]
+	asset := &staticAsset{
+		contentType: mime.TypeByExtension(path.Ext(name)),
+		etag:        `"` + hash + `"`,
+		content:     content,
+	}
+	if compressibleAssetExtensions[path.Ext(name)] {
+		gzipped, err := gzipAsset(content)
+		if err != nil {
+			return err
+		}
+		if len(gzipped) < len(content) {
+			asset.gzipped = gzipped
+		}
+		asset.brotli, err = fs.ReadFile(root, filePath+".br")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if asset.contentType == "" {
+		asset.contentType = http.DetectContentType(content)
+	}
+	hashedName := hashedAssetName(name, hash)
+	if oldHashedName, ok := a.hashedNames[name]; ok {
+		delete(a.assets, oldHashedName)
+		delete(a.isHashed, oldHashedName)
+	}
+	a.assets[name] = asset
+	a.assets[hashedName] = asset
+	a.hashedNames[name] = hashedName
+	a.isHashed[hashedName] = true
+	return nil
+}
+
+// layeredFileSystem opens the files from the first file system that has
+// them, the override directory is listed before the templates path and the
+// built in files.
+type layeredFileSystem []http.FileSystem
+
+func (layers layeredFileSystem) Open(name string) (http.File, error) {
+	var firstErr error
+	for _, layer := range layers {
+		file, err := layer.Open(name)
+		if err == nil {
+			return file, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return nil, os.ErrNotExist
+	}
+	return nil, firstErr
+}
+
+// url returns the hashed name of an asset, or name when the asset is not
+// loaded.
+func (a *staticAssets) url(name string) string {
+	if a != nil {
+		if hashedName, ok := a.hashedNames[name]; ok {
+			return hashedName
+		}
+	}
+	return name
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of r lists
+// encoding without a zero quality.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, value := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(value, ";")
+		if strings.TrimSpace(parts[0]) != encoding {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				quality, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil && quality == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (a *staticAssets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	asset, ok := a.assets[r.URL.Path]
+	if !ok {
+		a.fallback.ServeHTTP(w, r)
+		return
+	}
+	if a.isHashed[r.URL.Path] {
+		w.Header().Set("Cache-Control", hashedAssetCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", unhashedAssetCacheControl)
+	}
+	w.Header().Set("ETag", asset.etag)
+	w.Header().Set("Vary", "Accept-Encoding")
+	if r.Header.Get("If-None-Match") == asset.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	body := asset.content
+	switch {
+	case asset.brotli != nil && acceptsEncoding(r, "br"):
+		w.Header().Set("Content-Encoding", "br")
+		body = asset.brotli
+	case asset.gzipped != nil && acceptsEncoding(r, "gzip"):
+		w.Header().Set("Content-Encoding", "gzip")
+		body = asset.gzipped
+	}
+	w.Header().Set("Content-Type", asset.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStaticAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "staticassets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := strings.Repeat("console.log('smallpoint');\n", 100)
+	files := map[string]string{
+		"js/app.js":       content,
+		"css/site.css":    "body {}",
+		"css/site.css.br": "brotli bytes",
+	}
+	for name, value := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(filePath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filePath, []byte(value), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "fallback", http.StatusTeapot)
+	})
+	assets, err := loadStaticAssets([]fs.FS{os.DirFS(dir)}, []string{cssPath, imagesPath, jsPath}, fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(path string, acceptEncoding string, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(getMethod, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		assets.ServeHTTP(rr, req)
+		return rr
+	}
+
+	hashedName := assets.url("/js/app.js")
+	if hashedName == "/js/app.js" || !strings.HasPrefix(hashedName, "/js/app.") || !strings.HasSuffix(hashedName, ".js") {
+		t.Fatalf("unexpected hashed name %s", hashedName)
+	}
+	if name := assets.url("/js/missing.js"); name != "/js/missing.js" {
+		t.Fatalf("unknown asset got the name %s", name)
+	}
+	if name := assets.url("/css/site.css.br"); name != "/css/site.css.br" {
+		t.Fatal("precompressed copies are not assets")
+	}
+
+	rr := serve(hashedName, "gzip, deflate", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("hashed asset returned %d", rr.Code)
+	}
+	if rr.Header().Get("Cache-Control") != hashedAssetCacheControl {
+		t.Fatalf("unexpected Cache-Control %q", rr.Header().Get("Cache-Control"))
+	}
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("asset should be gzipped")
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != content {
+		t.Fatal("gzipped asset does not match")
+	}
+	etag := rr.Header().Get("ETag")
+
+	rr = serve("/js/app.js", "gzip;q=0", "")
+	if rr.Header().Get("Cache-Control") != unhashedAssetCacheControl {
+		t.Fatalf("unexpected Cache-Control %q", rr.Header().Get("Cache-Control"))
+	}
+	if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != content {
+		t.Fatal("asset should not be compressed")
+	}
+	if rr = serve("/js/app.js", "", etag); rr.Code != http.StatusNotModified {
+		t.Fatalf("revalidation returned %d", rr.Code)
+	}
+
+	rr = serve(assets.url("/css/site.css"), "gzip, br", "")
+	if rr.Header().Get("Content-Encoding") != "br" || rr.Body.String() != "brotli bytes" {
+		t.Fatal("the brotli copy should be served")
+	}
+	// compressing the small file does not pay off
+	rr = serve(assets.url("/css/site.css"), "gzip", "")
+	if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != "body {}" {
+		t.Fatal("small asset should not be gzipped")
+	}
+
+	if rr = serve("/js/missing.js", "", ""); rr.Code != http.StatusTeapot {
+		t.Fatalf("unknown asset returned %d", rr.Code)
+	}
+}
+
+func TestPagesLinkHashedAssets(t *testing.T) {
+	state := RuntimeState{}
+	state.Config.Base.TemplatesPath = "templates"
+	err := state.loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = state.htmlTemplate.ExecuteTemplate(&buf, "commonJS", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashedName := state.staticAssets.url("/js/newtable.js")
+	if hashedName == "/js/newtable.js" || !strings.Contains(buf.String(), hashedName) {
+		t.Fatalf("page does not link %s: %s", hashedName, buf.String())
+	}
+}
+
+func TestEmbeddedAssets(t *testing.T) {
+	// without a templates path the binary serves its built in assets
+	state := RuntimeState{}
+	err := state.loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashedName := state.staticAssets.url("/js/newtable.js")
+	if hashedName == "/js/newtable.js" {
+		t.Fatal("the built in assets were not loaded")
+	}
+	rr := httptest.NewRecorder()
+	state.staticAssets.ServeHTTP(rr, httptest.NewRequest(getMethod, hashedName, nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "function tablePages") {
+		t.Fatalf("%s returned %d", hashedName, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	http.FileServer(state.staticFileSystem()).ServeHTTP(rr, httptest.NewRequest(getMethod, "/css/new.css", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("/css/new.css returned %d", rr.Code)
+	}
+
+	// the files of the templates path replace the built in ones
+	dir, err := ioutil.TempDir("", "embeddedassets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.MkdirAll(filepath.Join(dir, "js"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "js", "newtable.js"), []byte("// local"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.TemplatesPath = dir
+	err = state.loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.staticAssets.url("/js/newtable.js") == hashedName {
+		t.Fatal("the asset of the templates path was not loaded")
+	}
+	if state.staticAssets.url("/css/new.css") == "/css/new.css" {
+		t.Fatal("the built in assets missing from the templates path were not loaded")
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The group and group members tables are too large to send at once for the
+// big directories, the pages read them a page at a time from the endpoints
+// below. The member counts of the group tables are queried for the rows of
+// the page only.
+
+const (
+	defaultTablePageSize = 50
+	maxTablePageSize     = 1000
+)
+
+// The sort keys of the tables map to the column of the rows, the first key
+// is the default.
+var groupsTableSortKeys = []string{"groupname", "managed_by"}
+var groupMembersTableSortKeys = []string{"username"}
+
+type tablePageRequest struct {
+	Page       int
+	Size       int
+	Column     int
+	Descending bool
+	Search     string
+	// Draw is sent back to let the tables drop the late responses.
+	Draw int
+}
+
+type tablePage struct {
+	Draw     int
+	Total    int
+	Filtered int
+	Rows     [][]string
+}
+
+func parseTableIntParam(r *http.Request, name string, value int, min int, max int) (int, error) {
+	text := r.FormValue(name)
+	if text == "" {
+		return value, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("invalid %s '%s'", name, text)
+	}
+	return value, nil
+}
+
+// parseTablePageRequest reads page, size, sort, order, search and draw from
+// the query string.
+func parseTablePageRequest(r *http.Request, sortKeys []string) (tablePageRequest, error) {
+	var request tablePageRequest
+	var err error
+	request.Page, err = parseTableIntParam(r, "page", 0, 0, math.MaxInt32)
+	if err != nil {
+		return request, err
+	}
+	request.Size, err = parseTableIntParam(r, "size", defaultTablePageSize, 1, maxTablePageSize)
+	if err != nil {
+		return request, err
+	}
+	request.Draw, err = parseTableIntParam(r, "draw", 0, 0, math.MaxInt32)
+	if err != nil {
+		return request, err
+	}
+	request.Column = -1
+	sortKey := r.FormValue("sort")
+	if sortKey == "" {
+		sortKey = sortKeys[0]
+	}
+	for column, key := range sortKeys {
+		if key == sortKey {
+			request.Column = column
+		}
+	}
+	if request.Column < 0 {
+		return request, fmt.Errorf("invalid sort '%s'", sortKey)
+	}
+	switch r.FormValue("order") {
+	case "", "asc":
+	case "desc":
+		request.Descending = true
+	default:
+		return request, fmt.Errorf("invalid order '%s'", r.FormValue("order"))
+	}
+	request.Search = strings.ToLower(strings.TrimSpace(r.FormValue("search")))
+	return request, nil
+}
+
+// pageTableRows returns the page of the rows that match the search, rows is
+// sorted in place.
+func pageTableRows(rows [][]string, request tablePageRequest) tablePage {
+	page := tablePage{Draw: request.Draw, Total: len(rows), Rows: [][]string{}}
+	matching := rows
+	if request.Search != "" {
+		matching = nil
+		for _, row := range rows {
+			for _, value := range row {
+				if strings.Contains(strings.ToLower(value), request.Search) {
+					matching = append(matching, row)
+					break
+				}
+			}
+		}
+	}
+	page.Filtered = len(matching)
+	sort.SliceStable(matching, func(i, j int) bool {
+		a, b := matching[i][request.Column], matching[j][request.Column]
+		if request.Descending {
+			a, b = b, a
+		}
+		if strings.ToLower(a) != strings.ToLower(b) {
+			return strings.ToLower(a) < strings.ToLower(b)
+		}
+		return a < b
+	})
+	start := request.Page * request.Size
+	if start >= len(matching) || start < 0 {
+		return page
+	}
+	end := start + request.Size
+	if end > len(matching) {
+		end = len(matching)
+	}
+	page.Rows = matching[start:end]
+	return page
+}
+
+func writeTablePage(w http.ResponseWriter, page tablePage) {
+	b, err := json.Marshal(page)
+	if err != nil {
+		slog.Error("Failed marshal", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(b)
+	if err != nil {
+		slog.Error("Incomplete write", "err", err)
+	}
+}
+
+// writeTablePagesJS writes the script that sets up a table reading its pages
+// from path.
+func (state *RuntimeState) writeTablePagesJS(w http.ResponseWriter, scriptText string, path string,
+	params url.Values) {
+	encodedURL, err := json.Marshal(path + "?" + params.Encode())
+	if err != nil {
+		slog.Error("writeTablePagesJS failed", "err", err)
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=15")
+	w.Header().Set("Content-Type", "application/javascript")
+	fmt.Fprintf(w, scriptText, encodedURL)
+}
+
+// groupsTableHandler returns a page of the groups listed by getGroups with
+// their managing group and member count, the tag parameter selects the
+// groups as in the all groups page.
+func (state *RuntimeState) groupsTableHandler(w http.ResponseWriter, r *http.Request,
+	getGroups func(username string) ([][]string, error)) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	request, err := parseTablePageRequest(r, groupsTableSortKeys)
+	if err != nil {
+		state.writeFailureResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groups, err := getGroups(username)
+	if err != nil {
+		requestLogger(r).Error("groupsTableHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	keep, err := state.listedGroupFilter(r.FormValue("tag"))
+	if err != nil {
+		requestLogger(r).Error("groupsTableHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	page := pageTableRows(filterGroupTuples(groups, keep), request)
+	page.Rows, err = state.appendMemberCounts(page.Rows)
+	if err != nil {
+		requestLogger(r).Error("groupsTableHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	writeTablePage(w, page)
+}
+
+func (state *RuntimeState) allGroupsTableHandler(w http.ResponseWriter, r *http.Request) {
+	state.groupsTableHandler(w, r, func(username string) ([][]string, error) {
+		return state.requestUserinfo(r).GetAllGroupsManagedBy()
+	})
+}
+
+// myGroupsTableHandler pages the groups of the user.
+func (state *RuntimeState) myGroupsTableHandler(w http.ResponseWriter, r *http.Request) {
+	state.groupsTableHandler(w, r, func(username string) ([][]string, error) {
+		return state.requestUserinfo(r).GetGroupsInfoOfUser(state.Config.TargetLDAP.GroupSearchBaseDNs, username)
+	})
+}
+
+// managedGroupsTableHandler pages the groups managed by a group of the user.
+func (state *RuntimeState) managedGroupsTableHandler(w http.ResponseWriter, r *http.Request) {
+	state.groupsTableHandler(w, r, func(username string) ([][]string, error) {
+		return state.getGroupsManagedByUser(r, username)
+	})
+}
+
+// groupMembersTableHandler returns a page of the members of a group.
+func (state *RuntimeState) groupMembersTableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	_, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	request, err := parseTablePageRequest(r, groupMembersTableSortKeys)
+	if err != nil {
+		state.writeFailureResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groupname := r.FormValue("groupname")
+	if groupname == "" {
+		state.writeFailureResponse(w, r, "groupname is required", http.StatusBadRequest)
+		return
+	}
+	members, _, err := state.requestUserinfo(r).GetusersofaGroup(groupname)
+	if err != nil {
+		if err == userinfo.GroupDoesNotExist {
+			state.writeFailureResponse(w, r, "Group doesn't exist!", http.StatusNotFound)
+			return
+		}
+		requestLogger(r).Error("groupMembersTableHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if r.FormValue("format") == "csv" {
+		err = writeGroupMembersCSV(w, groupname, members)
+		if err != nil {
+			requestLogger(r).Error("groupMembersTableHandler failed", "err", err)
+		}
+		return
+	}
+	rows := make([][]string, len(members))
+	for i, member := range members {
+		rows[i] = []string{member}
+	}
+	writeTablePage(w, pageTableRows(rows, request))
+}
+
+// writeGroupMembersCSV exports every member of the group, the export of the
+// group info page.
+func writeGroupMembersCSV(w http.ResponseWriter, groupname string, members []string) error {
+	sorted := append([]string{}, members...)
+	sort.Strings(sorted)
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", groupname+"_members.csv"))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write([]string{"user"})
+	if err != nil {
+		return err
+	}
+	for _, member := range sorted {
+		err = csvWriter.Write([]string{member})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTablePageRequest(t *testing.T) {
+	req := httptest.NewRequest(getMethod, allGroupsTablePath+"?page=2&size=10&sort=managed_by&order=desc&search=+Ops+&draw=7", nil)
+	request, err := parseTablePageRequest(req, groupsTableSortKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := tablePageRequest{Page: 2, Size: 10, Column: 1, Descending: true, Search: "ops", Draw: 7}
+	if request != expected {
+		t.Fatalf("bad request %+v", request)
+	}
+	req = httptest.NewRequest(getMethod, allGroupsTablePath, nil)
+	request, err = parseTablePageRequest(req, groupsTableSortKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Size != defaultTablePageSize || request.Column != 0 || request.Descending {
+		t.Fatalf("bad default request %+v", request)
+	}
+	for _, query := range []string{"page=-1", "size=0", "size=100000", "sort=members", "order=up", "draw=x"} {
+		req = httptest.NewRequest(getMethod, allGroupsTablePath+"?"+query, nil)
+		_, err = parseTablePageRequest(req, groupsTableSortKeys)
+		if err == nil {
+			t.Errorf("%s should be invalid", query)
+		}
+	}
+}
+
+func TestPageTableRows(t *testing.T) {
+	rows := [][]string{{"web", "ops"}, {"Admins", "self-managed"}, {"db", "ops"}, {"backup", "db"}}
+	page := pageTableRows(rows, tablePageRequest{Size: 2})
+	if page.Total != 4 || page.Filtered != 4 ||
+		!reflect.DeepEqual(page.Rows, [][]string{{"Admins", "self-managed"}, {"backup", "db"}}) {
+		t.Fatalf("bad first page %+v", page)
+	}
+	page = pageTableRows(rows, tablePageRequest{Page: 1, Size: 2})
+	if !reflect.DeepEqual(page.Rows, [][]string{{"db", "ops"}, {"web", "ops"}}) {
+		t.Fatalf("bad second page %+v", page)
+	}
+	page = pageTableRows(rows, tablePageRequest{Page: 2, Size: 2})
+	if len(page.Rows) != 0 || page.Rows == nil {
+		t.Fatalf("bad page past the end %+v", page)
+	}
+	page = pageTableRows(rows, tablePageRequest{Size: 10, Column: 1, Descending: true, Search: "ops", Draw: 3})
+	if page.Total != 4 || page.Filtered != 2 || page.Draw != 3 ||
+		!reflect.DeepEqual(page.Rows, [][]string{{"db", "ops"}, {"web", "ops"}}) {
+		t.Fatalf("bad searched page %+v", page)
+	}
+}
+
+func testGetTablePage(t *testing.T, state *RuntimeState, path string, handler http.HandlerFunc,
+	params url.Values, expectedCode int) tablePage {
+	req, err := http.NewRequest(getMethod, path+"?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != expectedCode {
+		t.Fatalf("%s?%s returned %d", path, params.Encode(), rr.Code)
+	}
+	var page tablePage
+	if expectedCode == http.StatusOK {
+		err = json.Unmarshal(rr.Body.Bytes(), &page)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return page
+}
+
+func TestTablePagesHandlers(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := testGetTablePage(t, &state, allGroupsTablePath, state.allGroupsTableHandler,
+		url.Values{"size": {"1"}, "search": {"group1"}}, http.StatusOK)
+	// group3 is managed by group1
+	if page.Filtered != 2 || len(page.Rows) != 1 {
+		t.Fatalf("bad all groups page %+v", page)
+	}
+	if page.Rows[0][0] != "group1" || page.Rows[0][2] != "2" {
+		t.Fatalf("bad all groups row %v", page.Rows[0])
+	}
+	testGetTablePage(t, &state, allGroupsTablePath, state.allGroupsTableHandler,
+		url.Values{"sort": {"members"}}, http.StatusBadRequest)
+
+	page = testGetTablePage(t, &state, groupMembersTablePath, state.groupMembersTableHandler,
+		url.Values{"groupname": {"group1"}, "order": {"desc"}}, http.StatusOK)
+	if page.Total != 2 || !reflect.DeepEqual(page.Rows, [][]string{{"user2"}, {"user1"}}) {
+		t.Fatalf("bad members page %+v", page)
+	}
+	testGetTablePage(t, &state, groupMembersTablePath, state.groupMembersTableHandler,
+		url.Values{"groupname": {"nonexistent"}}, http.StatusNotFound)
+
+	page = testGetTablePage(t, &state, myGroupsTablePath, state.myGroupsTableHandler,
+		url.Values{}, http.StatusOK)
+	if page.Total != 2 || page.Rows[0][0] != "group1" || page.Rows[1][0] != "group2" {
+		t.Fatalf("bad my groups page %+v", page)
+	}
+	page = testGetTablePage(t, &state, managedGroupsTablePath, state.managedGroupsTableHandler,
+		url.Values{"search": {"group3"}}, http.StatusOK)
+	if page.Filtered != 1 || page.Rows[0][1] != "group1" {
+		t.Fatalf("bad managed groups page %+v", page)
+	}
+
+	// the pages set up the tables with the table URLs
+	req, err := http.NewRequest(getMethod, getGroupsJSPath+"?type=managedByMe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	state.getGroupsJSHandler(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), managedGroupsTablePath) {
+		t.Fatalf("bad managed groups script %d %s", rr.Code, rr.Body.String())
+	}
+	req, err = http.NewRequest(getMethod, getUsersJSPath+"?type=group&groupName=group1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr = httptest.NewRecorder()
+	state.getUsersJSHandler(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), groupMembersTablePath+"?groupname=group1") {
+		t.Fatalf("bad members script %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestGroupInfoOwnersAndExport(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	req, err := http.NewRequest(getMethod, groupinfoPath+"?groupname=group3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.groupInfoWebpage).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("group info failed with %d", rr.Code)
+	}
+	var pageData groupInfoPageData
+	err = json.Unmarshal(rr.Body.Bytes(), &pageData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// group3 has no members and is managed by group1
+	if pageData.MemberCount != 0 || !reflect.DeepEqual(pageData.Owners, []string{"user1", "user2"}) {
+		t.Fatalf("bad group info %d %v", pageData.MemberCount, pageData.Owners)
+	}
+
+	req, err = http.NewRequest(getMethod, groupMembersTablePath+"?groupname=group1&format=csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(state.groupMembersTableHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("export failed with %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if rr.Body.String() != "user\nuser1\nuser2\n" {
+		t.Fatalf("bad export %q", rr.Body.String())
+	}
+}
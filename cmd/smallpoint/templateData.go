@@ -1,5 +1,9 @@
 package main
 
+import (
+	"github.com/Symantec/ldap-group-management/lib/authn"
+)
+
 const commonCSSText = `
 {{define "commonCSS"}}
     <style>
@@ -7,7 +11,7 @@ const commonCSSText = `
 
     </style>
     <style type="text/css" media="screen">
-        @import url("/css/new.css");
+        @import url("{{asset "/css/new.css"}}");
         @import url("https://cdnjs.cloudflare.com/ajax/libs/font-awesome/4.7.0/css/font-awesome.min.css");
         @import url("https://cdn.datatables.net/1.10.16/css/jquery.dataTables.min.css");
         @import url("https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/css/bootstrap.min.css");
@@ -23,8 +27,9 @@ const commonJSText = `
     <script src="https://cdn.datatables.net/1.10.16/js/jquery.dataTables.min.js"></script>
     <script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/js/bootstrap.min.js"></script>
     <script src="https://cdn.datatables.net/select/1.2.5/js/dataTables.select.min.js"></script>
-    <script type="text/javascript" src="/js/newtable.js"></script>
-    <script type="text/javascript" src="/js/sidebar.js"></script>
+    <script type="text/javascript" src="{{asset "/js/csrf.js"}}"></script>
+    <script type="text/javascript" src="{{asset "/js/newtable.js"}}"></script>
+    <script type="text/javascript" src="{{asset "/js/sidebar.js"}}"></script>
 {{end}}
 `
 
@@ -34,12 +39,14 @@ const commonHeadText = `
     <title>{{.Title}}</title>
     <meta name="viewport" content="width=device-width, initial-scale=1">
     {{template "commonCSS"}}
+    {{template "themeCSS" .}}
     {{template "commonJS"}}
     {{if .JSSources -}}
     {{- range .JSSources }}
     <script type="text/javascript" src="{{.}}"></script>
     {{- end}}
     {{- end}}
+    {{template "brandingHead"}}
 {{end}}
 `
 
@@ -49,8 +56,10 @@ const headerHTMLText = `
 <div class="w3-bar w3-top w3-new-blue w3-large" style="z-index: 4">
 <button class="w3-bar-item w3-button w3-hide-large w3-hover-none w3-hover-text-light-grey" id="hamburger_menu_button"><i class="fa fa-bars"></i> &nbsp;Menu</button>
     <div>
-        <img src="/images/darkBG.svg" alt="CPE Logo" style="height: 28px">
-        <span class="w3-bar-item w3-right w3-text-new-white"><strong><b>LDAP GROUP MANAGEMENT</b></strong></span>
+        {{with theme}}
+        <img src="{{if .LogoURL}}{{.LogoURL}}{{else}}{{asset "/images/darkBG.svg"}}{{end}}" alt="{{.ProductName}} Logo" style="height: 28px">
+        <span class="w3-bar-item w3-right w3-text-new-white"><strong><b>{{.ProductName}}</b></strong></span>
+        {{end}}
     </div>
 </div>
 <div id="side">
@@ -67,17 +76,52 @@ const footerHTMLText = `
 {{define "footer"}}
 <footer class="w3-container w3-padding-16 w3-light-grey w3-bottom">
         <p>Copyright 2018-2019 Symantec Corporation. | <a href="https://confluence.ges.symantec.com/display/CPEINFRAENG/Smallpoint+Usage+Guide" target="_blank">Documentation</a></p>
+        {{template "brandingFooter"}}
 </footer>
 
 {{end}}`
 
+// themeCSS applies the configured colors and the dark stylesheet the user
+// chose.
+const themeCSSText = `
+{{define "themeCSS"}}
+    {{with theme}}
+    {{if or .PrimaryColor .PrimaryTextColor}}
+    <style>
+        {{if .PrimaryColor}}.w3-new-blue,.w3-hover-new-blue:hover{background-color:{{.PrimaryColor}}!important}{{end}}
+        {{if .PrimaryTextColor}}.w3-text-new-white,.w3-hover-text-new-white:hover{color:{{.PrimaryTextColor}}!important}{{end}}
+    </style>
+    {{end}}
+    {{end}}
+    {{if darkMode .UserName}}
+    <link rel="stylesheet" type="text/css" href="{{asset "/css/dark.css"}}">
+    {{end}}
+{{end}}`
+
+// The branding templates are empty, the templates of the override directory
+// define them to add a stylesheet to the pages or text to the footer without
+// replacing the whole head or footer.
+const brandingHTMLText = `
+{{define "brandingHead"}}{{end}}
+{{define "brandingFooter"}}{{end}}`
+
+const directorySyncHTMLText = `
+{{define "directorySync"}}
+{{if .Enabled}}
+<p class="w3-small w3-text-grey" id="directory_sync">
+{{if .Stale}}The directory mirror is stale{{if not .SyncedAt.IsZero}} (last synced {{.SyncedAt.UTC.Format "2006-01-02 15:04:05"}} UTC){{end}}, showing live LDAP data.
+{{else}}Directory data as of {{.SyncedAt.UTC.Format "2006-01-02 15:04:05"}} UTC.{{end}}
+</p>
+{{end}}
+{{end}}`
+
 const sidebarHTMLText = `
 {{define "sidebar"}}
 
 <nav class="w3-sidebar w3-collapse w3-white w3-animate-left" style="z-index:3;width:300px;" id="mySidebar"><br>
     <div class="w3-container w3-row">
         <div class="w3-col s4">
-            <img src="/images/avatar2.png" class="w3-circle w3-margin-right" style="width:46px">
+            <img src="{{asset "/images/avatar2.png"}}" class="w3-circle w3-margin-right" style="width:46px">
         </div>
         <div class="w3-col s8 w3-bar">
 	    {{if .UserName}}
@@ -95,14 +139,29 @@ const sidebarHTMLText = `
         <a href="/my_managed_groups" class="w3-bar-item w3-button w3-padding"><i class="fa fa-users fa-fw"></i>&nbsp; My Managed Groups</a>
 	<a href="/pending-actions" class="w3-bar-item w3-button w3-padding"><i class="fa fa-cog fa-fw"></i>&nbsp; My Pending Actions <span style="background-color: red;color:white;border-radius:5px;" id="pending_action_count"></span> </a>
 	<a href="/pending-requests" class="w3-bar-item w3-button w3-padding"><i class="fa fa-cog fa-fw"></i>&nbsp; My Pending Requests</a>
+	<a href="/my_requests" class="w3-bar-item w3-button w3-padding"><i class="fa fa-list fa-fw"></i>&nbsp; My Requests</a>
+	<a href="/profile" class="w3-bar-item w3-button w3-padding"><i class="fa fa-user fa-fw"></i>&nbsp; My Profile</a>
         {{if .IsAdmin}}
         <a href="/create_group" class="w3-bar-item w3-button w3-padding"><i class="fa fa-users fa-fw"></i>&nbsp; Create Group</a>
         <a href="/delete_group" class="w3-bar-item w3-button w3-padding"><i class="fa fa-users fa-fw"></i>&nbsp; Delete Group</a>
-        <a href="/create_serviceaccount" class="w3-bar-item w3-button w3-padding"><i class="fa fa-users fa-fw"></i>&nbsp; Create Service Account</a>
         <a href="/change_owner" class="w3-bar-item w3-button w3-padding"><i class="fa fa-users fa-fw"></i>&nbsp; Change Group Ownership(RegExp)</a>
+        <a href="/audit_log" class="w3-bar-item w3-button w3-padding"><i class="fa fa-history fa-fw"></i>&nbsp; Audit Log</a>
+        <a href="/access_report" class="w3-bar-item w3-button w3-padding"><i class="fa fa-check-square-o fa-fw"></i>&nbsp; Access Report</a>
+        <a href="/github_teams" class="w3-bar-item w3-button w3-padding"><i class="fa fa-github fa-fw"></i>&nbsp; GitHub Teams</a>
+        <a href="/okta_groups" class="w3-bar-item w3-button w3-padding"><i class="fa fa-cloud-upload fa-fw"></i>&nbsp; Okta Groups</a>
+        <a href="/held_changes" class="w3-bar-item w3-button w3-padding"><i class="fa fa-shield fa-fw"></i>&nbsp; Held Changes</a>
         {{end}}
+        <a href="/create_serviceaccount" class="w3-bar-item w3-button w3-padding"><i class="fa fa-users fa-fw"></i>&nbsp; {{if .IsAdmin}}Create{{else}}Request{{end}} Service Account</a>
+        <a href="/service_accounts" class="w3-bar-item w3-button w3-padding"><i class="fa fa-user-secret fa-fw"></i>&nbsp; Service Accounts</a>
         <a href="/addmembers" class="w3-bar-item w3-button w3-padding"><i class="fa fa-users fa-fw"></i>&nbsp; Add Members to Group</a>
         <a href="/deletemembers" class="w3-bar-item w3-button w3-padding"><i class="fa fa-users fa-fw"></i>&nbsp; Remove Members from Group</a>
+        {{if .UserName}}
+        {{$darkMode := darkMode .UserName}}
+        <form method="POST" action="/preferences">
+            <input type="hidden" name="dark_mode" value="{{not $darkMode}}">
+            <button type="submit" class="w3-bar-item w3-button w3-padding"><i class="fa fa-adjust fa-fw"></i>&nbsp; {{if $darkMode}}Light{{else}}Dark{{end}} Mode</button>
+        </form>
+        {{end}}
 
         <br><br>
     </div>
@@ -123,7 +182,8 @@ type myGroupsPageData struct {
 	GroupManagedbyValue string
 	GroupUsers          []string
 	*/
-	JSSources []string
+	JSSources     []string
+	DirectorySync directorySyncStatus
 }
 
 const myGroupsPageText = `
@@ -164,6 +224,7 @@ const myGroupsPageText = `
         </div>
 
     </h5>
+    {{template "directorySync" .DirectorySync}}
   </header>
   <div class="w3-panel">
     <table class="w3-table w3-striped w3-white" id="display" style="width:100%;margin:0;">
@@ -182,8 +243,12 @@ type allGroupsPageData struct {
 	Title   string
 	IsAdmin bool
 
-	UserName  string
-	JSSources []string
+	UserName string
+	// Tag selects the groups shown, Tags lists every tag in use.
+	Tag           string
+	Tags          []groupTagCount
+	JSSources     []string
+	DirectorySync directorySyncStatus
 }
 
 const allGroupsPageText = `
@@ -192,7 +257,7 @@ const allGroupsPageText = `
 
 <head>
     {{template "commonHead" . }}
-    <script type="text/javascript" src="/getGroups.js?type=all"></script>
+    <script type="text/javascript" src="/getGroups.js?type=all{{if .Tag}}&tag={{.Tag}}{{end}}"></script>
 </head>
 <body class="w3-light-grey" >
 {{template "header" .}}
@@ -202,8 +267,16 @@ const allGroupsPageText = `
   <div id="content" style="min-height: 500px;margin-bottom:100px;">
 
   <header class="w3-container" style="padding-top:12px">
-    <h5><b><i class="fa fa-group"></i>All Ldap Groups</b>
+    <h5><b><i class="fa fa-group"></i>All Ldap Groups</b>{{if .Tag}} tagged <b>{{.Tag}}</b>{{end}}
     </h5>
+    {{if .Tags}}
+    <p>
+      {{if .Tag}}<a class="w3-tag w3-round w3-light-grey" href="/allGroups">all</a>{{end}}
+      {{$current := .Tag}}
+      {{range .Tags}}<a class="w3-tag w3-round {{if eq .Tag $current}}w3-new-blue{{else}}w3-white{{end}}" href="/allGroups?tag={{.Tag}}">{{.Tag}} ({{.Groups}})</a> {{end}}
+    </p>
+    {{end}}
+    {{template "directorySync" .DirectorySync}}
   </header>
 
   <div class="w3-panel">
@@ -219,6 +292,7 @@ const allGroupsPageText = `
                 </div>
                 <div class="modal-body">
                     <p>Are you sure you want to request access for these <span id="add_here"></span> selected groups?</p>
+                    Justification: <textarea id="request_justification" name="justification" rows="3" style="width:100%"></textarea>
                 </div>
                 <div class="modal-footer">
                     <button type="button" class="btn btn-default" id="btn_requestaccess" data-dismiss="modal">Confirm</button>
@@ -372,6 +446,7 @@ const pendingActionsPageText = `
                 </div>
                 <div class="modal-body">
                     <p>Are you sure you want to reject <span id="add_here1"></span> selected requests?</p>
+                    Comment: <textarea id="reject_comment" name="comment" rows="3" style="width:100%"></textarea>
                 </div>
                 <div class="modal-footer">
                     <button type="button" class="btn btn-default" id="btn_reject" data-dismiss="modal">Confirm</button>
@@ -392,6 +467,7 @@ const pendingActionsPageText = `
                 </div>
                 <div class="modal-body">
                     <p>Are you sure you want to approve the <span id="add_here2"></span> selected requests?</p>
+                    Comment: <textarea id="approve_comment" name="comment" rows="3" style="width:100%"></textarea>
                 </div>
                 <div class="modal-footer">
                     <button type="button" class="btn btn-default" id="btn_approve" data-dismiss="modal">Confirm</button>
@@ -437,6 +513,9 @@ type createGroupPageData struct {
 	IsAdmin bool
 
 	UserName  string
+	Templates []groupTemplate
+	// Template is the template pre-filling the form, if any.
+	Template  *groupTemplate
 	JSSources []string
 }
 
@@ -446,9 +525,9 @@ const createGroupPageText = `
 
 <head>
     {{template "commonHead" . }}
-    <script type="text/javascript" src="/js/createGroup.js"></script>
+    <script type="text/javascript" src="{{asset "/js/createGroup.js"}}"></script>
     <script type="text/javascript" src="/getGroups.js?type=allNoManager"></script>
-    <script type="text/javascript" src="/getUsers.js"></script>
+    <script type="text/javascript" src="{{asset "/js/userSearch.js"}}"></script>
 </head>
 <body class="w3-light-grey" >
 {{template "header" .}}
@@ -464,6 +543,25 @@ const createGroupPageText = `
 </header>
 
 <div class="w3-panel">
+        {{if .IsAdmin}}<p><a href="/group_templates">Manage group templates</a> or <a href="/import_groups">import groups from a file</a></p>{{end}}
+        {{if .Templates}}
+        <form method="GET" action="/create_group">
+            Template: <select name="template">
+                <option value="">none</option>
+                {{$current := ""}}{{with .Template}}{{$current = .Name}}{{end}}
+                {{range .Templates}}<option value="{{.Name}}" {{if eq .Name $current}}selected{{end}}>{{.Name}}</option>{{end}}
+            </select>
+            <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Use Template</button>
+        </form>
+        {{end}}
+        {{with .Template}}
+        <p id="group_template">Template <b>{{.Name}}</b>
+            {{if .Members}}adds {{range $i, $member := .Members}}{{if $i}}, {{end}}{{$member}}{{end}},{{end}}
+            {{if .GidMin}}allocates the gidNumber in {{.GidMin}}-{{.GidMax}},{{end}}
+            {{if .Tags}}tags the group {{range $i, $tag := .Tags}}{{if $i}}, {{end}}{{$tag}}{{end}},{{end}}
+            {{if .Description}}describes it as "{{.Description}}"{{end}}
+        </p>
+        {{end}}
         <table class="w3-table w3-striped w3-white" id="creategroup">
             <tr>
                 <td>Group Name</td>
@@ -472,6 +570,7 @@ const createGroupPageText = `
             <tr>
                 <td>description</td>
                 <td><select  id="select_groups" required="required" name="description" type="text">
+                    {{with .Template}}{{if .ManagedBy}}<option value="{{.ManagedBy}}" selected>{{.ManagedBy}}</option>{{end}}{{end}}
                     <option value="self-managed">self-managed</option>
                 </select><br/></td>
             </tr>
@@ -499,6 +598,7 @@ const createGroupPageText = `
                 <div class="modal-body">
                     <p>Are you sure you want to create this group?</p>
                     <form id="form_create_group" method="POST" action="/create_group/?username={{.UserName}}" autocomplete="off">
+                        {{with .Template}}<input name="template" type="hidden" value="{{.Name}}">{{end}}
                         GroupName: <input autocomplete="off" id='group_groupname' name="groupname" required type="text" readonly/><br/>
                         Managedby: <input autocomplete="off" id="group_managedby" name="description" required type="text" readonly><br/>
                         Members  : <input autocomplete="off" class='group_members' id='group_members' name="members" required="required" type="text" readonly/><br/>
@@ -537,7 +637,7 @@ const deleteGroupPageText = `
 
 <head>
     {{template "commonHead" . }}
-    <script type="text/javascript" src="/js/deleteGroup.js"></script>
+    <script type="text/javascript" src="{{asset "/js/deleteGroup.js"}}"></script>
     <script type="text/javascript" src="/getGroups.js?type=allNoManager"></script>
 </head>
 <body class="w3-light-grey" >
@@ -553,6 +653,7 @@ const deleteGroupPageText = `
 </header>
 
 <div class="w3-panel">
+        <p>Deleted groups are archived first, they can be restored from the <a href="/group_archive">archived groups</a> until the retention period is over.</p>
         <table class="w3-table w3-striped w3-white" id="deletegroup">
             <tr>
                 <td>Group Names</td>
@@ -607,6 +708,7 @@ type simpleMessagePageData struct {
 	SuccessMessage string   `json:",omitempty"`
 	ContinueURL    string   `json:",omitempty"`
 	ErrorMessage   string   `json:",omitempty"`
+	RequestID      string   `json:",omitempty"`
 }
 
 const simpleMessagePageText = `
@@ -628,6 +730,7 @@ const simpleMessagePageText = `
      {{.ErrorMessage}}
      {{end}}
      </p>
+     {{if .RequestID}}<p>Request ID: {{.RequestID}}</p>{{end}}
      {{if .ContinueURL}}<p>Click <a href="{{.ContinueURL}}">Here </a> to continue</p>{{end}}
   </div><!-- end of content div -->
 {{template "footer"}}
@@ -652,9 +755,9 @@ const addMembersToGroupPageText = `
 
 <head>
     {{template "commonHead" . }}
-    <script type="text/javascript" src="/js/addMemberToGroup.js"></script>
+    <script type="text/javascript" src="{{asset "/js/addMemberToGroup.js"}}"></script>
     <script type="text/javascript" src="/getGroups.js?type=allNoManager"></script>
-    <script type="text/javascript" src="/getUsers.js"></script>
+    <script type="text/javascript" src="{{asset "/js/userSearch.js"}}"></script>
 </head>
 <body class="w3-light-grey" >
 {{template "header" .}}
@@ -729,11 +832,22 @@ type groupInfoPageData struct {
 	IsAdmin  bool
 	UserName string
 
-	IsMember            bool
-	IsGroupAdmin        bool
-	GroupName           string
-	GroupManagedbyValue string
-	JSSources           []string
+	IsMember             bool
+	IsGroupAdmin         bool
+	GroupName            string
+	GroupManagedbyValue  string
+	Classification       string
+	GroupClassifications []string
+	Metadata             groupMetadata
+	Tags                 []string
+	Mail                 groupMailAddresses
+	Archive              *groupArchive
+	JSSources            []string
+	DirectorySync        directorySyncStatus
+	MemberCount          int
+	// Owners are the members of the managing group, the group itself
+	// when it is self-managed.
+	Owners []string
 }
 
 const groupInfoPageText = `
@@ -742,9 +856,9 @@ const groupInfoPageText = `
 
 <head>
     {{template "commonHead" . }}
-    <script type="text/javascript" src="/js/groupInfo.js"></script>
+    <script type="text/javascript" src="{{asset "/js/groupInfo.js"}}"></script>
     <script type="text/javascript" src="/getUsers.js?type=group&groupName={{.GroupName}}"></script>
-    <script type="text/javascript" src="/getUsers.js"></script>
+    <script type="text/javascript" src="{{asset "/js/userSearch.js"}}"></script>
     </head>
 <body class="w3-light-grey">
 {{template "header" .}}
@@ -760,6 +874,80 @@ const groupInfoPageText = `
     <br>
     <br>
     <h4><b>Group Managed Attribute:<strong id="group_managedby">{{.GroupManagedbyValue}}</strong></b></h4>
+    <h4><b>Members:<strong id="group_member_count">{{.MemberCount}}</strong></b>
+        <a id="group_members_export" href="/api/v1/tables/group_members?groupname={{.GroupName}}&format=csv"><i class="fa fa-download"></i> Export CSV</a></h4>
+    <div class="w3-panel w3-white" id="group_owners">
+        <h5><b>Owners</b>{{if and (ne .GroupManagedbyValue "self-managed") (ne .GroupManagedbyValue .GroupName)}} (members of <a href="/group_info/?groupname={{.GroupManagedbyValue}}">{{.GroupManagedbyValue}}</a>){{end}}</h5>
+        <p>{{range $i, $owner := .Owners}}{{if $i}}, {{end}}{{$owner}}{{else}}No owners{{end}}</p>
+    </div>
+    {{with .Archive}}
+    <div class="w3-panel w3-pale-red">
+        <p id="group_archived">Archived by {{.ArchivedBy}} on {{.ArchivedAt.UTC.Format "2006-01-02"}}, the group will be deleted after {{.DeleteAfter.UTC.Format "2006-01-02"}}.</p>
+        {{if $.IsAdmin}}
+        <form method="POST" action="/group_archive">
+            <input name="groupname" type="hidden" value="{{.Groupname}}">
+            <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="restore" type="submit">Restore Group</button>
+        </form>
+        {{end}}
+    </div>
+    {{end}}
+    {{if .Classification}}<h4><b>Classification:<strong id="group_classification">{{.Classification}}</strong></b></h4>{{end}}
+    {{with .Metadata}}
+    {{if .Description}}<p id="group_description">{{.Description}}</p>{{end}}
+    {{if .Purpose}}<h4><b>Purpose:<strong id="group_purpose">{{.Purpose}}</strong></b></h4>{{end}}
+    {{if .ContactEmail}}<h4><b>Contact:<a id="group_contact" href="mailto:{{.ContactEmail}}">{{.ContactEmail}}</a></b></h4>{{end}}
+    {{end}}
+    {{with .Mail}}{{if .Address}}<h4><b>Mailing list:<a id="group_mail" href="mailto:{{.Address}}">{{.Address}}</a></b>{{range .Aliases}} {{.}}{{end}}</h4>{{end}}{{end}}
+    {{if .Tags}}<h4><b>Tags:</b>{{range .Tags}} <a class="w3-tag w3-round w3-new-blue" href="/allGroups?tag={{.}}">{{.}}</a>{{end}}</h4>{{end}}
+    <a href="/group_history?groupname={{.GroupName}}">Membership history</a>
+    {{if .IsGroupAdmin}}
+    <form method="POST" action="/group_metadata/">
+        <input name="groupname" type="hidden" value="{{.GroupName}}">
+        Description: <input name="description" type="text" maxlength="1024" value="{{.Metadata.Description}}"><br/>
+        Contact email: <input name="contactEmail" type="email" maxlength="1024" value="{{.Metadata.ContactEmail}}"><br/>
+        Purpose: <input name="purpose" type="text" maxlength="1024" value="{{.Metadata.Purpose}}"><br/>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Save Group Metadata</button>
+    </form>
+    <form method="POST" action="/group_tags/">
+        <input name="groupname" type="hidden" value="{{.GroupName}}">
+        Tags: <input name="tags" type="text" placeholder="prod-access, mailing-list" value="{{range $i, $tag := .Tags}}{{if $i}}, {{end}}{{$tag}}{{end}}"><br/>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Save Tags</button>
+    </form>
+    <form method="POST" action="/group_mail/">
+        <input name="groupname" type="hidden" value="{{.GroupName}}">
+        Mail address: <input name="address" type="email" maxlength="254" value="{{.Mail.Address}}"><br/>
+        Aliases: <input name="aliases" type="text" value="{{range $i, $alias := .Mail.Aliases}}{{if $i}}, {{end}}{{$alias}}{{end}}"><br/>
+        <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="set" type="submit">Save Mailing List</button>
+        {{if .Mail.Address}}<button class="w3-button w3-text-new-white w3-new-blue" name="action" value="remove" type="submit">Remove Mailing List</button>{{end}}
+    </form>
+    {{end}}
+    {{if .IsAdmin}}
+    <form method="POST" action="/group_classification/">
+        <input name="groupname" type="hidden" value="{{.GroupName}}">
+        <select name="classification">
+            <option value="">any member</option>
+            {{$current := .Classification}}
+            {{range .GroupClassifications}}<option value="{{.}}" {{if eq . $current}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Set Classification</button>
+    </form>
+    <form method="POST" action="/rename_group/">
+        <input name="groupname" type="hidden" value="{{.GroupName}}">
+        New name: <input name="newname" type="text" required>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Rename Group</button>
+    </form>
+    <form method="POST" action="/merge_group/">
+        <input name="groupname" type="hidden" value="{{.GroupName}}">
+        Merge group: <input name="mergedGroup" type="text" required> into this group
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Merge</button>
+    </form>
+    <form method="POST" action="/clone_group/">
+        <input name="groupname" type="hidden" value="{{.GroupName}}">
+        New group name: <input name="newname" type="text" required>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Clone Group</button>
+    </form>
+    {{end}}
+    {{template "directorySync" .DirectorySync}}
 </header>
 
 <div class="w3-panel">
@@ -879,6 +1067,7 @@ const groupInfoPageText = `
                 <div class="modal-body">
                     <p>Are you sure you want to request access for this group?</p>
                     GroupName: <input name="groupname" id="groupinfo_join_nonmember" type="text" value="{{.GroupName}}" readonly><br/>
+                    Justification: <textarea id="groupinfo_join_justification" name="justification" rows="3" style="width:100%"></textarea>
                 </div>
                 <div class="modal-footer">
                     <button type="button" class="btn btn-default" id="btn_joingroup" data-dismiss="modal">Confirm</button>
@@ -910,8 +1099,10 @@ type createServiceAccountPageData struct {
 	Title   string
 	IsAdmin bool
 
-	UserName  string
-	JSSources []string
+	UserName    string
+	ReviewDays  int
+	NamingRules string
+	JSSources   []string
 }
 
 const createServiceAccountPageText = `
@@ -933,15 +1124,17 @@ const createServiceAccountPageText = `
 
 <!-- Header -->
 <header class="w3-container" style="padding-top:12px">
-    <h5><b><i class="fa fa-group"></i>Create a Service Account</b></h5>
+    <h5><b><i class="fa fa-group"></i>{{if .IsAdmin}}Create{{else}}Request{{end}} a Service Account</b></h5>
 </header>
 
 <div class="w3-panel">
+    {{if not .IsAdmin}}<p>Your request is reviewed by an admin. An owner group that does not exist yet is created with you as its member.</p>{{end}}
     <form method="POST" action="/create_serviceaccount/?username={{.UserName}}">
         <table class="w3-table w3-striped w3-white" id="creategroup">
             <tr>
                 <td><label for="AccountName">Service Account Name</label></td>
-                <td><input autocomplete="off" id="AccountName" name="AccountName" required type="text"/><br/></td>
+                <td><input autocomplete="off" id="AccountName" name="AccountName" required type="text"/><br/>
+                    <small>The name {{.NamingRules}}.</small></td>
             </tr>
             <tr>
                 <td><label id="labelEmailAddress" for="EmailAddress">DL Email Address Only</label></td>
@@ -954,7 +1147,21 @@ const createServiceAccountPageText = `
                     <option value="/bin/bash">/bin/bash</option>
                 </select></td>
             </tr>
-            <button class="w3-button w3-right w3-text-new-white w3-new-blue" type="submit" >Create Service Account</button>
+            <tr>
+                <td><label for="ownerGroup">Owner Group</label></td>
+                <td><input autocomplete="off" id="ownerGroup" name="ownerGroup" type="text"{{if not .IsAdmin}} required{{end}}/><br/></td>
+            </tr>
+            {{if not .IsAdmin}}
+            <tr>
+                <td><label for="justification">Justification</label></td>
+                <td><input autocomplete="off" id="justification" name="justification" required type="text"/><br/></td>
+            </tr>
+            {{end}}
+            <tr>
+                <td><label for="reviewDate">Review Date (defaults to {{.ReviewDays}} days)</label></td>
+                <td><input id="reviewDate" name="reviewDate" type="date"/><br/></td>
+            </tr>
+            <button class="w3-button w3-right w3-text-new-white w3-new-blue" type="submit" >{{if .IsAdmin}}Create{{else}}Request{{end}} Service Account</button>
         </table>
     </form>
 </div>
@@ -983,7 +1190,7 @@ const changeGroupOwnershipPageText = `
 
 <head>
     {{template "commonHead" . }}
-    <script type="text/javascript" src="/js/changeGroupOwnership.js"></script>
+    <script type="text/javascript" src="{{asset "/js/changeGroupOwnership.js"}}"></script>
     <script type="text/javascript" src="/getGroups.js?type=allNoManager"></script>
 </head>
 <body class="w3-light-grey" >
@@ -1071,7 +1278,7 @@ const deleteMembersFromGroupPageText = `
 
 <head>
     {{template "commonHead" . }}
-    <script type="text/javascript" src="/js/deleteMembersFromGroup.js"></script>
+    <script type="text/javascript" src="{{asset "/js/deleteMembersFromGroup.js"}}"></script>
     <script type="text/javascript" src="/getGroups.js?type=allNoManager"></script>
     <script type="text/javascript" src="/getUsers.js"></script>
 </head>
@@ -1144,3 +1351,1485 @@ const deleteMembersFromGroupPageText = `
 </html>
 {{end}}
 `
+
+type auditLogPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Actions      []string
+	Actor        string
+	Groupname    string
+	Action       string
+	From         string
+	To           string
+	Events       []auditEvent
+	Truncated    bool
+	CSVExportURL string
+	JSSources    []string
+}
+
+const auditLogPageText = `
+{{define "auditLogPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-history"></i> Audit Log</b></h5>
+</header>
+
+<div class="w3-panel">
+    <form method="GET" action="/audit_log" autocomplete="off">
+        Actor: <input name="actor" type="text" value="{{.Actor}}">
+        Group: <input name="groupname" type="text" value="{{.Groupname}}">
+        Action: <select name="action">
+            <option value="">any</option>
+            {{range .Actions}}
+            <option value="{{.}}" {{if eq . $.Action}}selected{{end}}>{{.}}</option>
+            {{end}}
+        </select>
+        From: <input name="from" type="date" value="{{.From}}">
+        To: <input name="to" type="date" value="{{.To}}">
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Filter</button>
+        <a href="{{.CSVExportURL}}">Export CSV</a>
+    </form>
+    {{if .Truncated}}
+    <p>Only the most recent entries are shown, use the CSV export for the full result.</p>
+    {{end}}
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Time</th>
+            <th>Actor</th>
+            <th>Action</th>
+            <th>Group</th>
+            <th>User</th>
+            <th>Source</th>
+            <th>Outcome</th>
+            <th>Details</th>
+            <th>Evidence</th>
+        </tr>
+        {{range .Events}}
+        <tr>
+            <td>{{.Timestamp.UTC.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Actor}}</td>
+            <td>{{.Action}}</td>
+            <td>{{.Groupname}}</td>
+            <td>{{.Username}}</td>
+            <td>{{.RemoteAddr}}</td>
+            <td>{{.Outcome}}</td>
+            <td>{{.Details}}</td>
+            <td>{{if .IsMembershipChange}}<a href="/audit_evidence?id={{.ID}}&format=pdf">PDF</a> <a href="/audit_evidence?id={{.ID}}">JSON</a>{{end}}</td>
+        </tr>
+        {{end}}
+    </table>
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type accessReportPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Groupname    string
+	Username     string
+	Subject      string
+	Entries      []accessReportEntry
+	CSVExportURL string
+	JSSources    []string
+}
+
+const accessReportPageText = `
+{{define "accessReportPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+    <script type="text/javascript" src="/getGroups.js"></script>
+    <script type="text/javascript" src="/getUsers.js"></script>
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-check-square-o"></i> Access Report</b></h5>
+</header>
+
+<div class="w3-panel">
+    <form method="GET" action="/access_report" autocomplete="off">
+        Group: <input name="groupname" type="text" value="{{.Groupname}}">
+        or User: <input name="username" type="text" value="{{.Username}}">
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Generate</button>
+    </form>
+    {{if .Subject}}
+    <p>Effective access for <strong>{{.Subject}}</strong>, {{len .Entries}} entries. <a href="{{.CSVExportURL}}">Download CSV</a></p>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>User</th>
+            <th>Group</th>
+            <th>Role</th>
+            <th>Via</th>
+        </tr>
+        {{range .Entries}}
+        <tr>
+            <td>{{.Username}}</td>
+            <td>{{.Groupname}}</td>
+            <td>{{.Role}}</td>
+            <td>{{.Via}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type groupHistoryPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	GroupName   string
+	From        string
+	To          string
+	HasDiff     bool
+	DiffAdded   []string
+	DiffRemoved []string
+	Changes     []membershipChange
+	JSSources   []string
+}
+
+const groupHistoryPageText = `
+{{define "groupHistoryPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-history"></i> Membership history of <a href="/group_info/?groupname={{.GroupName}}">{{.GroupName}}</a></b></h5>
+</header>
+
+<div class="w3-panel">
+    <form method="GET" action="/group_history" autocomplete="off">
+        <input name="groupname" type="hidden" value="{{.GroupName}}">
+        Compare <input name="from" type="date" value="{{.From}}" required>
+        with <input name="to" type="date" value="{{.To}}" required>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Diff</button>
+    </form>
+    {{if .HasDiff}}
+    <h5>Changes between {{.From}} and {{.To}}</h5>
+    <table class="w3-table w3-striped w3-white">
+        <tr><th>Added</th><th>Removed</th></tr>
+        <tr>
+            <td>{{range .DiffAdded}}{{.}}<br>{{else}}none{{end}}</td>
+            <td>{{range .DiffRemoved}}{{.}}<br>{{else}}none{{end}}</td>
+        </tr>
+    </table>
+    {{end}}
+    <h5>All changes</h5>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Time</th>
+            <th>User</th>
+            <th>Change</th>
+        </tr>
+        {{range .Changes}}
+        <tr>
+            <td>{{.Timestamp.UTC.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Username}}</td>
+            <td>{{.Change}}</td>
+        </tr>
+        {{else}}
+        <tr><td colspan="3">No history recorded for this group yet</td></tr>
+        {{end}}
+    </table>
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type serviceAccountsPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	ServiceAccounts          []serviceAccount
+	PendingRequests          []serviceAccountRequest
+	PendingTakeovers         []serviceAccountTakeover
+	PendingLifecycleRequests []serviceAccountLifecycleRequest
+	ScheduledDeletions       []serviceAccountDeletion
+	JSSources                []string
+}
+
+const serviceAccountsPageText = `
+{{define "serviceAccountsPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-user-secret"></i> Service Accounts</b></h5>
+</header>
+
+<div class="w3-panel">
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Account</th>
+            <th>Owner Group</th>
+            <th>Mail</th>
+            <th>Created</th>
+            <th>Review By</th>
+            <th>Status</th>
+            <th></th>
+        </tr>
+        {{range .ServiceAccounts}}
+        <tr>
+            <td><a href="/serviceaccount_info?accountname={{.AccountName}}">{{.AccountName}}</a></td>
+            <td>{{if .OwnerGroup}}<a href="/group_info/?groupname={{.OwnerGroup}}">{{.OwnerGroup}}</a>{{end}}</td>
+            <td>{{.Mail}}</td>
+            <td>{{.CreatedAt.Format "2006-01-02"}} by {{.CreatedBy}}</td>
+            <td>{{.ReviewBy.Format "2006-01-02"}}</td>
+            <td>{{.Status}}</td>
+            <td>{{if .IsActive}}
+                <form method="POST" action="/review_serviceaccount/">
+                    <input name="accountname" type="hidden" value="{{.AccountName}}">
+                    <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Mark Reviewed</button>
+                </form>
+            {{end}}
+                <a href="/change_serviceaccount_owner?accountname={{.AccountName}}">Change Owner</a>
+                <a href="/serviceaccount_credentials?accountname={{.AccountName}}">Credentials</a>
+            {{if ne .Status "pending_deletion"}}
+                <form method="POST" action="/serviceaccount_lifecycle/">
+                    <input name="accountname" type="hidden" value="{{.AccountName}}">
+                    <input name="justification" type="text" placeholder="Justification" required>
+                    {{if .IsActive}}<button class="w3-button w3-text-new-white w3-new-blue" name="action" value="disable" type="submit">Disable</button>{{end}}
+                    <button class="w3-button w3-text-new-white w3-red" name="action" value="delete" type="submit">Delete</button>
+                </form>
+            {{end}}
+            </td>
+        </tr>
+        {{else}}
+        <tr><td colspan="7">No service accounts</td></tr>
+        {{end}}
+    </table>
+    <p>To take over a service account whose owners have left, use <a href="/change_serviceaccount_owner">Change Owner</a>.</p>
+    {{if .IsAdmin}}<p>Legacy service accounts can be <a href="/import_serviceaccounts">imported from a CSV file</a>.</p>{{end}}
+    {{if .PendingRequests}}
+    <h5>Pending service account requests</h5>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Account</th>
+            <th>Owner Group</th>
+            <th>Mail</th>
+            <th>Requested By</th>
+            <th>Time</th>
+            <th>Justification</th>
+            <th></th>
+        </tr>
+        {{$isAdmin := .IsAdmin}}
+        {{range .PendingRequests}}
+        <tr>
+            <td>{{.AccountName}}</td>
+            <td>{{.OwnerGroup}}</td>
+            <td>{{.Mail}}</td>
+            <td>{{.RequestedBy}}</td>
+            <td>{{.Timestamp.UTC.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Justification}}</td>
+            <td>{{if $isAdmin}}
+                <form method="POST" action="/serviceaccount_request/">
+                    <input name="id" type="hidden" value="{{.ID}}">
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="approve" type="submit">Approve</button>
+                    <button class="w3-button w3-text-new-white w3-red" name="action" value="reject" type="submit">Reject</button>
+                </form>
+            {{end}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{end}}
+    {{if .PendingTakeovers}}
+    <h5>Pending takeover requests</h5>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Account</th>
+            <th>New Owner Group</th>
+            <th>Requested By</th>
+            <th>Time</th>
+            <th>Justification</th>
+            <th></th>
+        </tr>
+        {{range .PendingTakeovers}}
+        <tr>
+            <td>{{.AccountName}}</td>
+            <td>{{.OwnerGroup}}</td>
+            <td>{{.RequestedBy}}</td>
+            <td>{{.Timestamp.UTC.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Justification}}</td>
+            <td>
+                <form method="POST" action="/serviceaccount_takeover/">
+                    <input name="id" type="hidden" value="{{.ID}}">
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="approve" type="submit">Approve</button>
+                    <button class="w3-button w3-text-new-white w3-red" name="action" value="reject" type="submit">Reject</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{end}}
+    {{if .PendingLifecycleRequests}}
+    <h5>Pending disable and delete requests</h5>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Account</th>
+            <th>Action</th>
+            <th>Requested By</th>
+            <th>Time</th>
+            <th>Justification</th>
+            <th></th>
+        </tr>
+        {{range .PendingLifecycleRequests}}
+        <tr>
+            <td>{{.AccountName}}</td>
+            <td>{{.Action}}</td>
+            <td>{{.RequestedBy}}</td>
+            <td>{{.Timestamp.UTC.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Justification}}</td>
+            <td>
+                <form method="POST" action="/serviceaccount_lifecycle_approval/">
+                    <input name="id" type="hidden" value="{{.ID}}">
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="approve" type="submit">Approve</button>
+                    <button class="w3-button w3-text-new-white w3-red" name="action" value="reject" type="submit">Reject</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{end}}
+    {{if .ScheduledDeletions}}
+    <h5>Scheduled deletions</h5>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Account</th>
+            <th>Scheduled By</th>
+            <th>Delete After</th>
+            <th></th>
+        </tr>
+        {{range .ScheduledDeletions}}
+        <tr>
+            <td>{{.AccountName}}</td>
+            <td>{{.ScheduledBy}}</td>
+            <td>{{.DeleteAfter.Format "2006-01-02"}}</td>
+            <td>
+                <form method="POST" action="/serviceaccount_lifecycle/">
+                    <input name="accountname" type="hidden" value="{{.AccountName}}">
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="restore" type="submit">Restore</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type changeServiceAccountOwnerPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	AccountName string
+	JSSources   []string
+}
+
+const changeServiceAccountOwnerPageText = `
+{{define "changeServiceAccountOwnerPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-user-secret"></i> Change Service Account Owner</b></h5>
+</header>
+
+<div class="w3-panel">
+    <p>Owners and admins transfer the account right away. Otherwise a takeover request on behalf of
+    a group you belong to is sent to the admins for approval, for example when the owners have left.</p>
+    <form method="POST" action="/change_serviceaccount_owner/" autocomplete="off">
+        <table class="w3-table w3-striped w3-white">
+            <tr>
+                <td><label for="accountname">Service Account Name</label></td>
+                <td><input id="accountname" name="accountname" required type="text" value="{{.AccountName}}"/></td>
+            </tr>
+            <tr>
+                <td><label for="ownerGroup">New Owner Group</label></td>
+                <td><input id="ownerGroup" name="ownerGroup" required type="text"/></td>
+            </tr>
+            <tr>
+                <td><label for="justification">Justification (required for takeovers)</label></td>
+                <td><textarea id="justification" name="justification" rows="3" cols="60"></textarea></td>
+            </tr>
+        </table>
+        <button class="w3-button w3-right w3-text-new-white w3-new-blue" type="submit">Change Owner</button>
+    </form>
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type credentialRotationsPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	AccountName string
+	// NewSecret is only set right after a rotation that returns one.
+	NewSecret string `json:",omitempty"`
+	Rotations []credentialRotation
+	JSSources []string
+}
+
+const credentialRotationsPageText = `
+{{define "credentialRotationsPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-key"></i> Credentials of {{.AccountName}}</b></h5>
+</header>
+
+<div class="w3-panel">
+    {{if .NewSecret}}
+    <div class="w3-panel w3-pale-yellow w3-border">
+        <p>The new password of {{.AccountName}} is shown only once, store it now:</p>
+        <p><code>{{.NewSecret}}</code></p>
+    </div>
+    {{end}}
+    <form method="POST" action="/serviceaccount_credentials" onsubmit="return confirm('Rotate the credentials of {{.AccountName}}? The current ones will stop working.');">
+        <input name="accountname" type="hidden" value="{{.AccountName}}">
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Rotate Credentials</button>
+    </form>
+    <h5>Rotation history</h5>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Time</th>
+            <th>Rotated By</th>
+            <th>Backend</th>
+            <th>Outcome</th>
+            <th>Reference</th>
+        </tr>
+        {{range .Rotations}}
+        <tr>
+            <td>{{.Timestamp.UTC.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.RotatedBy}}</td>
+            <td>{{.Backend}}</td>
+            <td>{{.Outcome}}</td>
+            <td>{{.Reference}}</td>
+        </tr>
+        {{else}}
+        <tr><td colspan="5">The credentials were never rotated through smallpoint</td></tr>
+        {{end}}
+    </table>
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type serviceAccountInfoPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Account   serviceAccount
+	Metadata  serviceAccountMetadata
+	JSSources []string
+}
+
+const serviceAccountInfoPageText = `
+{{define "serviceAccountInfoPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-user-secret"></i> Service account {{.Account.AccountName}}</b></h5>
+</header>
+
+<div class="w3-panel">
+    <table class="w3-table w3-striped w3-white">
+        {{with .Account}}
+        <tr><th>Owner Group</th><td>{{if .OwnerGroup}}<a href="/group_info/?groupname={{.OwnerGroup}}">{{.OwnerGroup}}</a>{{end}}</td></tr>
+        <tr><th>Mail</th><td>{{.Mail}}</td></tr>
+        <tr><th>Created</th><td>{{.CreatedAt.Format "2006-01-02"}} by {{.CreatedBy}}</td></tr>
+        <tr><th>Review By</th><td>{{.ReviewBy.Format "2006-01-02"}}</td></tr>
+        <tr><th>Status</th><td>{{.Status}}</td></tr>
+        {{end}}
+    </table>
+    <h5>Metadata</h5>
+    <form method="POST" action="/serviceaccount_info">
+        <input name="accountname" type="hidden" value="{{.Account.AccountName}}">
+        <table class="w3-table w3-white">
+            {{with .Metadata}}
+            <tr><th>Purpose</th><td><input class="w3-input" name="purpose" type="text" maxlength="256" value="{{.Purpose}}"></td></tr>
+            <tr><th>Owning Team</th><td><input class="w3-input" name="team" type="text" maxlength="256" value="{{.Team}}"></td></tr>
+            <tr><th>Cost Center</th><td><input class="w3-input" name="costCenter" type="text" maxlength="256" value="{{.CostCenter}}"></td></tr>
+            <tr><th>Ticket</th><td><input class="w3-input" name="ticket" type="text" maxlength="256" value="{{.Ticket}}"></td></tr>
+            {{if .UpdatedBy}}<tr><th>Last Update</th><td>{{.UpdatedAt.UTC.Format "2006-01-02 15:04:05"}} by {{.UpdatedBy}}</td></tr>{{end}}
+            {{end}}
+        </table>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Save</button>
+    </form>
+    <p>
+        <a href="/change_serviceaccount_owner?accountname={{.Account.AccountName}}">Change Owner</a>
+        <a href="/serviceaccount_credentials?accountname={{.Account.AccountName}}">Credentials</a>
+    </p>
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type serviceAccountImportPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	// Report is only set after an import.
+	Report    *serviceAccountImportReport `json:",omitempty"`
+	JSSources []string
+}
+
+const serviceAccountImportPageText = `
+{{define "serviceAccountImportPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-upload"></i> Import Service Accounts</b></h5>
+</header>
+
+<div class="w3-panel">
+    <p>The CSV file needs a header row with the columns accountname, owner_group, owners, mail, purpose, team,
+    cost_center and ticket. Only accountname and owner_group are required. Missing owner groups are created as
+    self-managed groups of the owners, a space separated list of usernames.</p>
+    <form method="POST" action="/import_serviceaccounts" enctype="multipart/form-data">
+        <input name="csv" type="file" accept=".csv,text/csv" required>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Import</button>
+    </form>
+    {{with .Report}}
+    <h5>Imported {{.Imported}}, skipped {{.Skipped}}, failed {{.Failed}}</h5>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Line</th>
+            <th>Account</th>
+            <th>Outcome</th>
+            <th>Message</th>
+        </tr>
+        {{range .Results}}
+        <tr>
+            <td>{{.Line}}</td>
+            <td>{{.AccountName}}</td>
+            <td>{{.Outcome}}</td>
+            <td>{{.Message}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type groupTemplatesPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Templates []groupTemplate
+	JSSources []string
+}
+
+const groupTemplatesPageText = `
+{{define "groupTemplatesPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-clone"></i> Group Templates</b></h5>
+</header>
+
+<div class="w3-panel">
+    <p>Templates pre-fill the <a href="/create_group">create group</a> form. Saving a template with an existing name replaces it.</p>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Name</th>
+            <th>Managed By</th>
+            <th>Members</th>
+            <th>gidNumber Range</th>
+            <th>Tags</th>
+            <th>Description</th>
+            <th>Last Update</th>
+            <th></th>
+        </tr>
+        {{range .Templates}}
+        <tr>
+            <td><a href="/create_group?template={{.Name}}">{{.Name}}</a></td>
+            <td>{{.ManagedBy}}</td>
+            <td>{{range $i, $member := .Members}}{{if $i}}, {{end}}{{$member}}{{end}}</td>
+            <td>{{if .GidMin}}{{.GidMin}}-{{.GidMax}}{{end}}</td>
+            <td>{{range $i, $tag := .Tags}}{{if $i}}, {{end}}{{$tag}}{{end}}</td>
+            <td>{{.Description}}</td>
+            <td>{{.UpdatedAt.UTC.Format "2006-01-02"}} by {{.UpdatedBy}}</td>
+            <td>
+                <form method="POST" action="/group_templates">
+                    <input name="name" type="hidden" value="{{.Name}}">
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="delete" type="submit">Delete</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    <h5>Save a template</h5>
+    <form method="POST" action="/group_templates" autocomplete="off">
+        <input name="action" type="hidden" value="save">
+        <table class="w3-table w3-white">
+            <tr><th>Name</th><td><input class="w3-input" name="name" type="text" required></td></tr>
+            <tr><th>Managed By</th><td><input class="w3-input" name="managedBy" type="text" placeholder="group name or self-managed"></td></tr>
+            <tr><th>Initial Members</th><td><input class="w3-input" name="members" type="text" placeholder="comma separated usernames"></td></tr>
+            <tr><th>gidNumber Range</th><td><input name="gidMin" type="number" min="1"> - <input name="gidMax" type="number" min="1"></td></tr>
+            <tr><th>Tags</th><td><input class="w3-input" name="tags" type="text" placeholder="prod-access, mailing-list"></td></tr>
+            <tr><th>Description</th><td><input class="w3-input" name="description" type="text" maxlength="1024"></td></tr>
+        </table>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Save Template</button>
+    </form>
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type groupArchivePageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Archives  []groupArchive
+	JSSources []string
+}
+
+const groupArchivePageText = `
+{{define "groupArchivePage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-archive"></i> Archived Groups</b></h5>
+</header>
+
+<div class="w3-panel">
+    <p>Restoring a group adds its members back.</p>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Group</th>
+            <th>Members</th>
+            <th>Archived</th>
+            <th>Deleted After</th>
+            <th></th>
+        </tr>
+        {{range .Archives}}
+        <tr>
+            <td><a href="/group_info/?groupname={{.Groupname}}">{{.Groupname}}</a></td>
+            <td>{{range $i, $member := .Members}}{{if $i}}, {{end}}{{$member}}{{end}}</td>
+            <td>{{.ArchivedAt.UTC.Format "2006-01-02"}} by {{.ArchivedBy}}</td>
+            <td>{{.DeleteAfter.UTC.Format "2006-01-02"}}</td>
+            <td>
+                <form method="POST" action="/group_archive">
+                    <input name="groupname" type="hidden" value="{{.Groupname}}">
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="restore" type="submit">Restore</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type membershipUndoPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	GroupName string
+	Removals  []membershipRemoval
+	JSSources []string
+}
+
+const membershipUndoPageText = `
+{{define "membershipUndoPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-undo"></i> Undo Member Removals{{if .GroupName}} of {{.GroupName}}{{end}}</b></h5>
+</header>
+
+<div class="w3-panel">
+    {{if .Removals}}
+    <form method="POST" action="/membership_undo">
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th></th>
+            <th>Group</th>
+            <th>Member</th>
+            <th>Removed</th>
+            <th>Undo Until</th>
+        </tr>
+        {{range .Removals}}
+        <tr>
+            <td><input name="id" type="checkbox" value="{{.ID}}" checked></td>
+            <td><a href="/group_info/?groupname={{.Groupname}}">{{.Groupname}}</a></td>
+            <td>{{.Username}}</td>
+            <td>{{.RemovedAt.UTC.Format "2006-01-02 15:04"}} by {{.RemovedBy}}</td>
+            <td>{{.UndoUntil.UTC.Format "2006-01-02 15:04"}}</td>
+        </tr>
+        {{end}}
+    </table>
+    <p><button class="w3-button w3-text-new-white w3-new-blue" type="submit">Add Back The Selected Members</button></p>
+    </form>
+    {{else}}
+    <p>There are no member removals to undo.</p>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type heldChangesPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Changes   []heldChange
+	Message   string
+	JSSources []string
+}
+
+const heldChangesPageText = `
+{{define "heldChangesPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-shield"></i> Changes Held For Approval</b></h5>
+</header>
+
+<div class="w3-panel">
+    {{if .Message}}<p>{{.Message}}</p>{{end}}
+    {{if .Changes}}
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Group</th>
+            <th>Change</th>
+            <th>Users</th>
+            <th>Risk</th>
+            <th>Requested</th>
+            <th></th>
+        </tr>
+        {{range .Changes}}
+        <tr>
+            <td><a href="/group_info/?groupname={{.Groupname}}">{{.Groupname}}</a></td>
+            <td>{{.Action}}</td>
+            <td>{{range $i, $user := .Usernames}}{{if $i}}, {{end}}{{$user}}{{end}}</td>
+            <td>{{.Risk}}</td>
+            <td>{{.Timestamp.UTC.Format "2006-01-02 15:04"}} by {{.RequestedBy}}</td>
+            <td>
+                <form method="POST" action="/held_changes" style="display:inline">
+                    <input type="hidden" name="id" value="{{.ID}}">
+                    <button class="w3-button w3-small w3-text-new-white w3-new-blue" name="action" value="approve" type="submit">Approve</button>
+                    <button class="w3-button w3-small w3-red" name="action" value="reject" type="submit">Reject</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>There are no changes held for approval.</p>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type groupMergePageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Report    groupMergeReport
+	JSSources []string
+}
+
+const groupMergePageText = `
+{{define "groupMergePage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-compress"></i> Group Merge Report</b></h5>
+</header>
+
+{{with .Report}}
+<div class="w3-panel">
+    <p>Group <a href="/group_info/?groupname={{.MergedGroup}}">{{.MergedGroup}}</a> was merged into <a href="/group_info/?groupname={{.Group}}">{{.Group}}</a>,
+    it is archived and will be deleted after {{.DeleteAfter.UTC.Format "2006-01-02"}}.</p>
+    <table class="w3-table w3-striped w3-white">
+        <tr><th>Members added to {{.Group}}</th><td>{{range $i, $name := .AddedMembers}}{{if $i}}, {{end}}{{$name}}{{else}}none{{end}}</td></tr>
+        <tr><th>Groups now managed by {{.Group}}</th><td>{{range $i, $name := .ManagedGroups}}{{if $i}}, {{end}}{{$name}}{{else}}none{{end}}</td></tr>
+        <tr><th>Service accounts now owned by {{.Group}}</th><td>{{range $i, $name := .ServiceAccounts}}{{if $i}}, {{end}}{{$name}}{{else}}none{{end}}</td></tr>
+        <tr><th>Requests retargeted to {{.Group}}</th><td>{{range $i, $name := .RetargetedRequests}}{{if $i}}, {{end}}{{$name}}{{else}}none{{end}}</td></tr>
+        <tr><th>Requests dropped</th><td>{{range $i, $name := .DroppedRequests}}{{if $i}}, {{end}}{{$name}}{{else}}none{{end}}</td></tr>
+    </table>
+</div>
+{{end}}
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type myRequestsPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Requests  []myRequest
+	JSSources []string
+}
+
+const myRequestsPageText = `
+{{define "myRequestsPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-list"></i> My Requests</b></h5>
+</header>
+
+<div class="w3-panel">
+    {{if .Requests}}
+    <table class="w3-table w3-striped w3-white" id="my_requests">
+        <tr>
+            <th>Group</th>
+            <th>Status</th>
+            <th>Age</th>
+            <th>Approver Group</th>
+            <th>Justification</th>
+            <th>Decision</th>
+            <th></th>
+        </tr>
+        {{range .Requests}}
+        <tr>
+            <td><a href="/group_info/?groupname={{.Groupname}}">{{.Groupname}}</a></td>
+            <td>{{.State}}</td>
+            <td title="{{.CreatedAt.UTC.Format "2006-01-02 15:04"}} UTC">{{.Age}}</td>
+            <td>{{if .ApproverGroup}}<a href="/group_info/?groupname={{.ApproverGroup}}">{{.ApproverGroup}}</a>{{end}}</td>
+            <td>{{.Justification}}</td>
+            <td>{{if not .DecidedAt.IsZero}}{{.DecidedBy}} on {{.DecidedAt.UTC.Format "2006-01-02"}}{{if .DecisionComment}}: {{.DecisionComment}}{{end}}{{end}}</td>
+            <td>{{if eq .State "pending"}}
+                <form method="POST" action="/cancel_request">
+                    <input name="id" type="hidden" value="{{.ID}}">
+                    <button class="w3-button w3-text-new-white w3-red" type="submit">Cancel</button>
+                </form>
+            {{end}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>You have not requested to join any group.</p>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type profilePageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Memberships     []profileMembership
+	OwnedGroups     []string
+	Requests        []accessRequest
+	ServiceAccounts []serviceAccount
+	Session         *authn.Session
+	JSSources       []string
+}
+
+const profilePageText = `
+{{define "profilePage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-user"></i> {{.UserName}}</b></h5>
+</header>
+
+<div class="w3-panel">
+    <h5>Memberships</h5>
+    {{if .Memberships}}
+    <table class="w3-table w3-striped w3-white" id="profile_memberships">
+        <tr><th>Group</th><th>Via</th></tr>
+        {{range .Memberships}}
+        <tr>
+            <td><a href="/group_info/?groupname={{.Groupname}}">{{.Groupname}}</a></td>
+            <td>{{if .Via}}{{.Via}}{{else}}direct{{end}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>You are not a member of any group.</p>
+    {{end}}
+</div>
+
+<div class="w3-panel">
+    <h5>Groups you own</h5>
+    {{if .OwnedGroups}}
+    <ul class="w3-ul w3-white" id="profile_owned_groups">
+        {{range .OwnedGroups}}
+        <li><a href="/group_info/?groupname={{.}}">{{.}}</a></li>
+        {{end}}
+    </ul>
+    {{else}}
+    <p>You do not own any group.</p>
+    {{end}}
+</div>
+
+<div class="w3-panel">
+    <h5>Outstanding requests</h5>
+    {{if .Requests}}
+    <table class="w3-table w3-striped w3-white" id="profile_requests">
+        <tr><th>Group</th><th>Requested</th><th>Justification</th></tr>
+        {{range .Requests}}
+        <tr>
+            <td><a href="/group_info/?groupname={{.Groupname}}">{{.Groupname}}</a></td>
+            <td>{{.CreatedAt.UTC.Format "2006-01-02 15:04"}} UTC</td>
+            <td>{{.Justification}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>You have no outstanding requests.</p>
+    {{end}}
+    <p><a href="/my_requests">All my requests</a></p>
+</div>
+
+<div class="w3-panel">
+    <h5>Service accounts</h5>
+    {{if .ServiceAccounts}}
+    <table class="w3-table w3-striped w3-white" id="profile_service_accounts">
+        <tr><th>Account</th><th>Owner Group</th><th>Review By</th><th>Status</th></tr>
+        {{range .ServiceAccounts}}
+        <tr>
+            <td>{{.AccountName}}</td>
+            <td>{{.OwnerGroup}}</td>
+            <td>{{.ReviewBy.UTC.Format "2006-01-02"}}</td>
+            <td>{{.Status}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>Your groups do not own any service account.</p>
+    {{end}}
+</div>
+
+<div class="w3-panel">
+    <h5>Session</h5>
+    {{with .Session}}
+    <p id="profile_session">Signed in with a {{.Method}}{{if not .ExpiresAt.IsZero}} on {{.IssuedAt.UTC.Format "2006-01-02 15:04"}} UTC, the session expires on {{.ExpiresAt.UTC.Format "2006-01-02 15:04"}} UTC{{end}}.</p>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type groupImportPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	// Report is only set after an import.
+	Report    *groupImportReport `json:",omitempty"`
+	JSSources []string
+}
+
+const groupImportPageText = `
+{{define "groupImportPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-upload"></i> Import Groups</b></h5>
+</header>
+
+<div class="w3-panel">
+    <p>The groups of the file are created or changed to have the managing group and the members of the file,
+    the groups missing from the file are left alone. A YAML file has a groups list with the name, managed_by
+    and members of each group, a CSV file has a header row with the groupname, managed_by and members columns
+    and the members are a space separated list of usernames. The members of a group without a members list
+    are left alone.</p>
+    <p>The current groups can be exported as <a href="/export_groups?format=yaml">YAML</a> or
+    <a href="/export_groups?format=csv">CSV</a>.</p>
+    <form method="POST" action="/import_groups" enctype="multipart/form-data">
+        <input name="file" type="file" accept=".yaml,.yml,.csv,text/csv" required>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Import</button>
+    </form>
+    {{with .Report}}
+    <h5>Created {{.Created}}, changed {{.Changed}}, skipped {{.Skipped}}, failed {{.Failed}}</h5>
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Group</th>
+            <th>Outcome</th>
+            <th>Message</th>
+        </tr>
+        {{range .Results}}
+        <tr>
+            <td>{{.Group}}</td>
+            <td>{{.Outcome}}</td>
+            <td>{{.Message}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type githubTeamsPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Enabled       bool
+	Organizations []string
+	Mappings      []githubTeamMapping
+	Message       string
+	JSSources     []string
+}
+
+const githubTeamsPageText = `
+{{define "githubTeamsPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-github"></i> GitHub Teams</b></h5>
+</header>
+
+<div class="w3-panel">
+    {{if not .Enabled}}
+    <p>GitHub team sync is not configured, set github_team_sync in the configuration to map groups to teams.</p>
+    {{else}}
+    <p>The members of each team are kept in sync with the members of its group: the members missing from the
+    team are added and the other members of the team are removed. The teams are synced periodically and after
+    each membership change of their group.</p>
+    {{with .Message}}<p class="w3-text-green">{{.}}</p>{{end}}
+    {{end}}
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Group</th>
+            <th>Team</th>
+            <th>Mapped</th>
+            <th>Last Sync</th>
+            <th></th>
+        </tr>
+        {{range .Mappings}}
+        <tr>
+            <td><a href="/group_info/?groupname={{.Groupname}}">{{.Groupname}}</a></td>
+            <td>{{.Organization}}/{{.Team}}</td>
+            <td>{{.CreatedAt.UTC.Format "2006-01-02"}} by {{.CreatedBy}}</td>
+            <td>{{if .LastSync.Unix}}{{.LastSync.UTC.Format "2006-01-02 15:04:05"}}{{if .LastSyncError}}: {{.LastSyncError}}{{else}}: ok{{end}}{{else}}never{{end}}</td>
+            <td>
+                <form method="POST" action="/github_teams">
+                    <input name="groupname" type="hidden" value="{{.Groupname}}">
+                    <input name="organization" type="hidden" value="{{.Organization}}">
+                    <input name="team" type="hidden" value="{{.Team}}">
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="sync" type="submit">Sync</button>
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="delete" type="submit">Delete</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{if .Enabled}}
+    <h5>Map a group to a team</h5>
+    <form method="POST" action="/github_teams" autocomplete="off">
+        <input name="action" type="hidden" value="add">
+        <table class="w3-table w3-white">
+            <tr><th>Group</th><td><input class="w3-input" name="groupname" type="text" required></td></tr>
+            <tr><th>Organization</th><td><select class="w3-select" name="organization">{{range .Organizations}}<option value="{{.}}">{{.}}</option>{{end}}</select></td></tr>
+            <tr><th>Team</th><td><input class="w3-input" name="team" type="text" placeholder="team slug" required></td></tr>
+        </table>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Map Group</button>
+    </form>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
+
+type oktaGroupsPageData struct {
+	Title    string
+	IsAdmin  bool
+	UserName string
+
+	Enabled    bool
+	AlertAfter int
+	Pushes     []oktaGroupPush
+	Message    string
+	JSSources  []string
+}
+
+const oktaGroupsPageText = `
+{{define "oktaGroupsPage"}}
+<html>
+
+<head>
+    {{template "commonHead" . }}
+</head>
+<body class="w3-light-grey">
+{{template "header" .}}
+
+<!-- !PAGE CONTENT! -->
+<div class="w3-main" style="margin-left:300px;margin-top:43px;">
+  <div id="content" style="min-height: 500px;margin-bottom:100px;">
+
+<!-- Header -->
+<header class="w3-container" style="padding-top:12px">
+    <h5><b><i class="fa fa-cloud-upload"></i> Okta Groups</b></h5>
+</header>
+
+<div class="w3-panel">
+    {{if not .Enabled}}
+    <p>Okta group push is not configured, set okta in the configuration to push groups to Okta.</p>
+    {{else}}
+    <p>The members of the enabled groups are pushed to their Okta group: the members missing from the Okta
+    group are added and the other users of the Okta group are removed. The groups are pushed periodically and
+    after each membership change. The alert recipients are mailed after {{.AlertAfter}} failed pushes in a row.</p>
+    {{with .Message}}<p class="w3-text-green">{{.}}</p>{{end}}
+    {{end}}
+    <table class="w3-table w3-striped w3-white">
+        <tr>
+            <th>Group</th>
+            <th>Okta Group</th>
+            <th>Push</th>
+            <th>Last Update</th>
+            <th>Last Push</th>
+            <th></th>
+        </tr>
+        {{range .Pushes}}
+        <tr>
+            <td><a href="/group_info/?groupname={{.Groupname}}">{{.Groupname}}</a></td>
+            <td>{{.OktaGroupID}}</td>
+            <td>{{if .Enabled}}enabled{{else}}disabled{{end}}</td>
+            <td>{{.UpdatedAt.UTC.Format "2006-01-02"}} by {{.UpdatedBy}}</td>
+            <td>{{if .LastPush.Unix}}{{.LastPush.UTC.Format "2006-01-02 15:04:05"}}{{if .LastPushError}}: {{.LastPushError}} ({{.Failures}} failures in a row){{else}}: ok{{end}}{{else}}never{{end}}</td>
+            <td>
+                <form method="POST" action="/okta_groups">
+                    <input name="groupname" type="hidden" value="{{.Groupname}}">
+                    {{if .Enabled}}
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="push" type="submit">Push</button>
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="disable" type="submit">Disable</button>
+                    {{else}}
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="enable" type="submit">Enable</button>
+                    {{end}}
+                    <button class="w3-button w3-text-new-white w3-new-blue" name="action" value="delete" type="submit">Delete</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{if .Enabled}}
+    <h5>Push a group to Okta</h5>
+    <form method="POST" action="/okta_groups" autocomplete="off">
+        <input name="action" type="hidden" value="set">
+        <table class="w3-table w3-white">
+            <tr><th>Group</th><td><input class="w3-input" name="groupname" type="text" required></td></tr>
+            <tr><th>Okta Group</th><td><input class="w3-input" name="okta_group" type="text" placeholder="Okta group id" required></td></tr>
+        </table>
+        <button class="w3-button w3-text-new-white w3-new-blue" type="submit">Push Group</button>
+    </form>
+    {{end}}
+</div>
+
+  </div><!-- end of content div -->
+{{template "footer"}}
+</div>
+
+</body>
+</html>
+{{end}}
+`
@@ -0,0 +1,36 @@
+// The state-changing requests carry the CSRF token of the session, the
+// server gives it to the pages in the csrf_token cookie.
+function csrfToken() {
+    var match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : "";
+}
+
+function setCSRFHeader(xhttp) {
+    xhttp.setRequestHeader("X-CSRF-Token", csrfToken());
+}
+
+// addCSRFField adds the token to a POST form.
+function addCSRFField(form) {
+    if ((form.getAttribute("method") || "").toUpperCase() !== "POST") {
+        return;
+    }
+    var field = form.querySelector("input[name=csrf_token]");
+    if (!field) {
+        field = document.createElement("input");
+        field.type = "hidden";
+        field.name = "csrf_token";
+        form.appendChild(field);
+    }
+    field.value = csrfToken();
+}
+
+// the forms the scripts submit get the token when the page loads, the
+// others again as they are submitted
+document.addEventListener("DOMContentLoaded", function () {
+    for (var i = 0; i < document.forms.length; i++) {
+        addCSRFField(document.forms[i]);
+    }
+});
+document.addEventListener("submit", function (event) {
+    addCSRFField(event.target);
+}, true);
@@ -44,6 +44,8 @@ function array(groupnames) {//[["1","2"]]
         }else{
             groupname[2] ='<a title="click for groupinfo" href=/group_info/?groupname='+groupnames[i][1]+'>'+groupnames[i][1]+'</a>';
         }
+        //member count, not sent for pending requests
+        groupname[3] = groupnames[i].length > 2 ? groupnames[i][2] : '';
         groupname[0]='';
         group_description[i]=groupname;
         groupname=[];
@@ -51,13 +53,20 @@ function array(groupnames) {//[["1","2"]]
     return group_description;//=[[][][][]]
 }
 
-function arrayPendingActions(PendingActions) {
+//escapeHTML escapes the text typed by the users
+function escapeHTML(text) {
+    return $('<div>').text(text).html();
+}
+
+function arrayPendingActions(PendingActions) {//[[username,groupname,requested,justification]]
     var groupname=[];
     var group_description=[];
     for(i=0;i<PendingActions.length;i++){
         groupname[1]='<a>'+PendingActions[i][0]+'</a>';
         //groupname[0]=groupnames[i][0];
         groupname[2] ='<a title="click for groupinfo" href=/group_info/?groupname='+PendingActions[i][1]+'>'+PendingActions[i][1]+'</a>';
+        groupname[3] = PendingActions[i].length > 2 ? PendingActions[i][2] : '';
+        groupname[4] = PendingActions[i].length > 3 ? escapeHTML(PendingActions[i][3]) : '';
         groupname[0]='';
         group_description[i]=groupname;
         groupname=[];
@@ -89,15 +98,45 @@ function Parsearray(array) {
     return result;
 }
 
-function RequestAccess(final_groupnames) {
+//tablePages returns the ajax option of a table reading its pages from url,
+//sortKeys names the sort key of each column and toRows converts the rows
+function tablePages(url, sortKeys, toRows) {
+    return function (data, callback, settings) {
+        var query = {
+            draw: data.draw,
+            page: Math.floor(data.start / data.length),
+            size: data.length,
+            search: data.search.value
+        };
+        if (data.order.length > 0 && sortKeys[data.order[0].column] != null) {
+            query.sort = sortKeys[data.order[0].column];
+            query.order = data.order[0].dir;
+        }
+        $.getJSON(url, query, function (page) {
+            callback({
+                draw: page.Draw,
+                recordsTotal: page.Total,
+                recordsFiltered: page.Filtered,
+                data: toRows(page.Rows)
+            });
+        }).fail(function (xhr) {
+            console.log("Status error: " + xhr.status);
+        });
+    };
+}
+
+//RequestAccess shows final_groupnames, or the pages read by pages when the
+//groups are too many to send at once
+function RequestAccess(final_groupnames, pages) {
 
     $(document).ready(function() {
-        $('#display').DataTable( {
+        var options = {
             data: final_groupnames,
             columns: [
                 {title:"select"},
                 {title:"groups"},
-                {title:"managed by"}
+                {title:"managed by"},
+                {title:"members"}
             ],
             columnDefs: [ {
                 orderable: false,
@@ -109,7 +148,16 @@ function RequestAccess(final_groupnames) {
                 selector: 'td:first-child'
             },
             order:[[1,'asc']]
-        } );
+        };
+        if (pages != null) {
+            delete options.data;
+            options.serverSide = true;
+            options.ajax = pages;
+            options.searchDelay = 400;
+            //the member counts are only known for the rows of a page
+            options.columnDefs.push({orderable: false, targets: 3});
+        }
+        $('#display').DataTable(options);
     } );
 
     $(document).ready(function() {
@@ -126,6 +174,7 @@ function RequestAccess(final_groupnames) {
             var data_selected=table.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/requestaccess");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -133,8 +182,9 @@ function RequestAccess(final_groupnames) {
                 result=parsestring(data_selected[i][1]);
                 request_groups.groups.push(result);
             }
+            var justification=document.getElementById('request_justification').value;
             xhttp.onreadystatechange = function(){ReloadOnSuccessOrAlert(xhttp);};
-            xhttp.send(JSON.stringify({groups:request_groups.groups}));
+            xhttp.send(JSON.stringify({groups:request_groups.groups,justification:[justification]}));
         } );
 
         //delete requests confirm button
@@ -143,6 +193,7 @@ function RequestAccess(final_groupnames) {
             var data_selected=table.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/deleterequests");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -160,6 +211,7 @@ function RequestAccess(final_groupnames) {
             var data_selected=table.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/exitgroup");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -182,7 +234,9 @@ function pendingActionsTable(PendingActions) {
             columns: [
                 {title:"select"},
                 {title:"username"},
-                {title:"groupname"}
+                {title:"groupname"},
+                {title:"requested"},
+                {title:"justification"}
             ],
             columnDefs: [ {
                 orderable: false,
@@ -210,6 +264,7 @@ function pendingActionsTable(PendingActions) {
             var data_selected=table2.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/reject-request");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -221,8 +276,9 @@ function pendingActionsTable(PendingActions) {
                 request_groups.groups.push(result);
                 result=[];
             }
+            var comment=document.getElementById('reject_comment').value;
             xhttp.onreadystatechange = function(){ReloadOnSuccessOrAlert(xhttp);};
-            xhttp.send(JSON.stringify({groups:request_groups.groups}));
+            xhttp.send(JSON.stringify({groups:request_groups.groups,comment:comment}));
         } );
         $('#length_btn2').click( function () {
             var length=table2.rows('.selected').data().length;
@@ -233,6 +289,7 @@ function pendingActionsTable(PendingActions) {
             var data_selected=table2.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/approve-request");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -244,8 +301,9 @@ function pendingActionsTable(PendingActions) {
                 request_groups.groups.push(result);
                 result=[];
             }
+            var comment=document.getElementById('approve_comment').value;
             xhttp.onreadystatechange = function(){ReloadOnSuccessOrAlert(xhttp);};
-            xhttp.send(JSON.stringify({groups:request_groups.groups}));
+            xhttp.send(JSON.stringify({groups:request_groups.groups,comment:comment}));
         } );
     } );
 
@@ -278,8 +336,29 @@ function ReloadOnSuccessOrAlert(xhttp) {
 
 
 
-function Group_Info(users) {
+//pagedUsers lists the members of a page in the remove members form
+function pagedUsers(users) {
+    $('#select_members_remove').empty();
+    for (i=0;i<users.length;i++){
+        $('#select_members_remove').append("<option id='option-"+users[i]+"' value='" + users[i] + "'>"+users[i]+"</option>");
+    }
+    return users;
+}
+
+//Group_Info shows users, or the pages read by pages for the large groups
+function Group_Info(users, pages) {
     $(document).ready(function() {
+        if (pages != null) {
+            $('#table_groupinfo').DataTable( {
+                serverSide: true,
+                ajax: pages,
+                searchDelay: 400,
+                columns: [
+                    {title:"Members of the group"}
+                ]
+            } );
+            return;
+        }
         $('#table_groupinfo').DataTable( {
             data: users,
             columns: [
@@ -296,6 +375,7 @@ function Group_Info(users) {
         var groupname=document.getElementById('groupinfo_exit').value;
         var xhttp = new XMLHttpRequest();   // new HttpRequest instance
         xhttp.open("POST", "/exitgroup");
+        setCSRFHeader(xhttp);
         xhttp.setRequestHeader("Content-Type", "application/json");
         var request_groups={};
         request_groups.groups=[];
@@ -309,12 +389,14 @@ function Group_Info(users) {
         var data_selected=document.getElementById('groupinfo_join_nonmember').value;
         var xhttp = new XMLHttpRequest();   // new HttpRequest instance
         xhttp.open("POST", "/requestaccess");
+        setCSRFHeader(xhttp);
         xhttp.setRequestHeader("Content-Type", "application/json");
         var request_groups={};
         request_groups.groups=[];
         request_groups.groups.push(data_selected);
+        var justification=document.getElementById('groupinfo_join_justification').value;
         xhttp.onreadystatechange = function(){ReloadOnSuccessOrAlert(xhttp);};
-        xhttp.send(JSON.stringify({groups:request_groups.groups}));
+        xhttp.send(JSON.stringify({groups:request_groups.groups,justification:[justification]}));
     } );
 }
 
@@ -126,6 +126,7 @@ function RequestAccess(final_groupnames) {
             var data_selected=table.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/requestaccess");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -143,6 +144,7 @@ function RequestAccess(final_groupnames) {
             var data_selected=table.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/deleterequests");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -160,6 +162,7 @@ function RequestAccess(final_groupnames) {
             var data_selected=table.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/exitgroup");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -210,6 +213,7 @@ function pendingActionsTable(PendingActions) {
             var data_selected=table2.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/reject-request");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -233,6 +237,7 @@ function pendingActionsTable(PendingActions) {
             var data_selected=table2.rows('.selected').data();
             var xhttp = new XMLHttpRequest();   // new HttpRequest instance
             xhttp.open("POST", "/approve-request");
+            setCSRFHeader(xhttp);
             xhttp.setRequestHeader("Content-Type", "application/json");
             var request_groups={};
             request_groups.groups=[];
@@ -324,6 +329,7 @@ function Group_Info(users) {
         var groupname=document.getElementById('groupinfo_exit').value;
         var xhttp = new XMLHttpRequest();   // new HttpRequest instance
         xhttp.open("POST", "/exitgroup");
+        setCSRFHeader(xhttp);
         xhttp.setRequestHeader("Content-Type", "application/json");
         var request_groups={};
         request_groups.groups=[];
@@ -337,6 +343,7 @@ function Group_Info(users) {
         var data_selected=document.getElementById('groupinfo_join_nonmember').value;
         var xhttp = new XMLHttpRequest();   // new HttpRequest instance
         xhttp.open("POST", "/requestaccess");
+        setCSRFHeader(xhttp);
         xhttp.setRequestHeader("Content-Type", "application/json");
         var request_groups={};
         request_groups.groups=[];
@@ -0,0 +1,59 @@
+// The members inputs suggest the users whose uid, name or mail start with
+// the text typed, the suggestions are read from the user search API.
+document.addEventListener('DOMContentLoaded', function () {
+    var input = document.getElementById('cg_members');
+    if (input === null) {
+        return;
+    }
+    var timer = null;
+    input.addEventListener('input', function () {
+        clearTimeout(timer);
+        var prefix = input.value.trim();
+        if (prefix === '' || document.getElementById('option-' + prefix) !== null) {
+            return;
+        }
+        timer = setTimeout(function () {
+            search_users(input, prefix);
+        }, 250);
+    });
+});
+
+function search_users(input, prefix) {
+    var xhttp = new XMLHttpRequest();
+    xhttp.onreadystatechange = function () {
+        if (xhttp.readyState !== 4) {
+            return;
+        }
+        if (xhttp.status !== 200) {
+            console.log("Status error: " + xhttp.status);
+            return;
+        }
+        // the text changed while the request ran
+        if (input.value.trim() !== prefix) {
+            return;
+        }
+        var selected = {};
+        $("div.suggestion div b").each(function () {
+            selected[$(this).text()] = true;
+        });
+        var users = JSON.parse(xhttp.responseText).Users;
+        var datalist = document.getElementById('select_members');
+        $(datalist).empty();
+        for (var i = 0; i < users.length; i++) {
+            if (selected[users[i].Username]) {
+                continue;
+            }
+            var option = document.createElement('option');
+            option.id = 'option-' + users[i].Username;
+            option.value = users[i].Username;
+            var label = users[i].DisplayName;
+            if (users[i].Email !== '') {
+                label += ' <' + users[i].Email + '>';
+            }
+            option.textContent = label;
+            datalist.appendChild(option);
+        }
+    };
+    xhttp.open('GET', '/api/v1/users/search?q=' + encodeURIComponent(prefix) + '&limit=20');
+    xhttp.send();
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testRenderTemplate(t *testing.T, state *RuntimeState, name string) string {
+	var buf bytes.Buffer
+	err := state.executeTemplate(&buf, name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	customPath := filepath.Join(dir, "custom.tmpl")
+	writeCustom := func(text string) {
+		err := ioutil.WriteFile(customPath, []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := RuntimeState{}
+	state.Config.Base.TemplatesPath = dir
+
+	// the custom templates replace the built in ones
+	writeCustom(`{{define "footer"}}custom footer{{end}}`)
+	err = state.loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if footer := testRenderTemplate(t, &state, "footer"); footer != "custom footer" {
+		t.Fatalf("unexpected footer %q", footer)
+	}
+
+	// escaping errors are reported at startup
+	writeCustom(`{{define "brokenPage"}}<a href="{{.URL}}{{end}}`)
+	err = state.loadTemplates()
+	if err == nil || !strings.Contains(err.Error(), "brokenPage") {
+		t.Fatalf("the broken template was not reported, err=%v", err)
+	}
+
+	// in dev mode the changes show without a restart
+	state.Config.Base.TemplatesDevMode = true
+	writeCustom(`{{define "devPage"}}first <script src="{{asset "/js/newtable.js"}}"></script>{{end}}`)
+	err = state.loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := testRenderTemplate(t, &state, "devPage")
+	if page != `first <script src="/js/newtable.js"></script>` {
+		t.Fatalf("unexpected page %q", page)
+	}
+	writeCustom(`{{define "devPage"}}second{{end}}`)
+	if page := testRenderTemplate(t, &state, "devPage"); page != "second" {
+		t.Fatalf("the template was not reloaded, got %q", page)
+	}
+}
+
+func TestOverrideTemplatesAndAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "override")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"templates/footer.tmpl":    `{{define "footer"}}base footer{{end}}`,
+		"templates/css/site.css":   "body {}",
+		"templates/js/app.js":      "base",
+		"override/branding.tmpl":   `{{define "brandingFooter"}}Example Corp{{end}}{{define "footer"}}override footer {{template "brandingFooter"}}{{end}}`,
+		"override/css/site.css":    "body { color: red; }",
+		"override/images/logo.png": "logo",
+	}
+	for name, value := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(filePath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filePath, []byte(value), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := RuntimeState{}
+	state.Config.Base.TemplatesPath = filepath.Join(dir, "templates")
+	state.Config.Base.OverridePath = filepath.Join(dir, "override")
+	serve := func(path string) string {
+		rr := httptest.NewRecorder()
+		state.staticAssets.ServeHTTP(rr, httptest.NewRequest(getMethod, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s returned %d", path, rr.Code)
+		}
+		return rr.Body.String()
+	}
+
+	err = state.loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if footer := testRenderTemplate(t, &state, "footer"); footer != "override footer Example Corp" {
+		t.Fatalf("unexpected footer %q", footer)
+	}
+	if body := serve("/css/site.css"); body != "body { color: red; }" {
+		t.Fatalf("the stylesheet was not overridden, got %q", body)
+	}
+	if body := serve(state.staticAssets.url("/css/site.css")); body != "body { color: red; }" {
+		t.Fatalf("the hashed stylesheet was not overridden, got %q", body)
+	}
+	if body := serve("/js/app.js"); body != "base" {
+		t.Fatalf("unexpected script %q", body)
+	}
+	if body := serve("/images/logo.png"); body != "logo" {
+		t.Fatalf("unexpected logo %q", body)
+	}
+
+	// in dev mode the files are served from the disk
+	state.Config.Base.TemplatesDevMode = true
+	rr := httptest.NewRecorder()
+	http.FileServer(state.staticFileSystem()).ServeHTTP(rr, httptest.NewRequest(getMethod, "/css/site.css", nil))
+	if rr.Body.String() != "body { color: red; }" {
+		t.Fatalf("the dev mode stylesheet was not overridden, got %q", rr.Body.String())
+	}
+
+	state.Config.Base.OverridePath = filepath.Join(dir, "missing")
+	if err = state.loadTemplates(); err == nil {
+		t.Fatal("the missing override directory was not reported")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The theme brands every page, the templates read it with the theme
+// function. The users choose the dark stylesheet from the sidebar, their
+// choice is kept in the DB.
+
+const defaultThemeProductName = "LDAP GROUP MANAGEMENT"
+
+var themeColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+type themeConfig struct {
+	ProductName string `yaml:"product_name"`
+	// LogoURL replaces the logo of the header.
+	LogoURL string `yaml:"logo_url"`
+	// PrimaryColor and PrimaryTextColor are the background and text colors
+	// of the header, as #rgb or #rrggbb.
+	PrimaryColor     string `yaml:"primary_color"`
+	PrimaryTextColor string `yaml:"primary_text_color"`
+}
+
+func (config themeConfig) check() error {
+	for name, color := range map[string]string{"primary_color": config.PrimaryColor,
+		"primary_text_color": config.PrimaryTextColor} {
+		if color != "" && !themeColorPattern.MatchString(color) {
+			return fmt.Errorf("%s '%s' is not a #rgb or #rrggbb color", name, color)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns the theme the templates use.
+func (config themeConfig) withDefaults() themeConfig {
+	if config.ProductName == "" {
+		config.ProductName = defaultThemeProductName
+	}
+	return config
+}
+
+var getDarkModeStmt = map[string]string{
+	"sqlite":   "select dark_mode from user_preferences where username=?;",
+	"postgres": "select dark_mode from user_preferences where username=$1;",
+}
+
+var setDarkModeStmt = map[string]string{
+	"sqlite":   "insert into user_preferences(username, dark_mode, updated_at) values (?,?,?) on conflict(username) do update set dark_mode=excluded.dark_mode, updated_at=excluded.updated_at;",
+	"postgres": "insert into user_preferences(username, dark_mode, updated_at) values ($1,$2,$3) on conflict(username) do update set dark_mode=excluded.dark_mode, updated_at=excluded.updated_at;",
+}
+
+func getDarkModeFromDB(username string, state *RuntimeState) (bool, error) {
+	start := time.Now()
+	var darkMode int
+	err := state.db.QueryRow(getDarkModeStmt[state.dbType], username).Scan(&darkMode)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return darkMode != 0, nil
+}
+
+func setDarkModeInDB(username string, darkMode bool, state *RuntimeState) error {
+	start := time.Now()
+	value := 0
+	if darkMode {
+		value = 1
+	}
+	_, err := state.db.Exec(setDarkModeStmt[state.dbType], username, value, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return nil
+}
+
+// userPrefersDarkMode is the darkMode function of the templates, the pages
+// render in the light theme when the preference cannot be read.
+func (state *RuntimeState) userPrefersDarkMode(username string) bool {
+	if username == "" {
+		return false
+	}
+	darkMode, err := getDarkModeFromDB(username, state)
+	if err != nil {
+		slog.Error("cannot read the theme preference", "user", username, "err", err)
+		return false
+	}
+	return darkMode
+}
+
+// userPreferencesHandler saves the dark mode choice of the user and returns
+// to the page the choice was made from.
+func (state *RuntimeState) userPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != postMethod {
+		state.writeFailureResponse(w, r, "POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	username, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	darkMode, err := strconv.ParseBool(r.FormValue("dark_mode"))
+	if err != nil {
+		state.writeFailureResponse(w, r, "dark_mode must be true or false", http.StatusBadRequest)
+		return
+	}
+	err = setDarkModeInDB(username, darkMode, state)
+	if err != nil {
+		requestLogger(r).Error("userPreferencesHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	// only the path of the referer is kept, the redirect stays on this site
+	returnTo := "/"
+	if referer, err := url.Parse(r.Referer()); err == nil && strings.HasPrefix(referer.Path, "/") &&
+		!strings.HasPrefix(referer.Path, "//") {
+		returnTo = (&url.URL{Path: referer.Path, RawQuery: referer.RawQuery}).String()
+	}
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestThemeConfigCheck(t *testing.T) {
+	valid := themeConfig{PrimaryColor: "#213c60", PrimaryTextColor: "#fff"}
+	if err := valid.check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, color := range []string{"red", "#12345", "#213c60;}body{display:none"} {
+		if err := (themeConfig{PrimaryColor: color}).check(); err == nil {
+			t.Errorf("the color '%s' was accepted", color)
+		}
+	}
+}
+
+func TestThemeAndDarkMode(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "theme_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "theme.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Theme = themeConfig{ProductName: "Example Groups", LogoURL: "https://example.com/logo.svg",
+		PrimaryColor: "#336699"}
+	err = state.loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func() string {
+		var buf bytes.Buffer
+		data := simpleMessagePageData{UserName: "user2", Title: "Theme"}
+		err := state.htmlTemplate.ExecuteTemplate(&buf, "simpleMessagePage", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	page := render()
+	for _, expected := range []string{"Example Groups", `src="https://example.com/logo.svg"`, "#336699",
+		`name="dark_mode" value="true"`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("the page does not contain %s", expected)
+		}
+	}
+	if strings.Contains(page, "/css/dark") {
+		t.Fatal("the dark stylesheet is linked before it was chosen")
+	}
+
+	postPreference := func(value string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", userPreferencesPath,
+			strings.NewReader(url.Values{"dark_mode": {value}}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Referer", "https://smallpoint.example.com/group_info/?groupname=group1")
+		cookie := testCreateValidCookie(state.authenticator)
+		testAddAuthCookie(req, state.authenticator, cookie)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(state.userPreferencesHandler).ServeHTTP(rr, req)
+		return rr
+	}
+	rr := postPreference("true")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/group_info/?groupname=group1" {
+		t.Fatalf("unexpected response %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	page = render()
+	if !strings.Contains(page, "/css/dark") || !strings.Contains(page, "Light Mode") {
+		t.Fatal("the dark stylesheet is not linked")
+	}
+	if rr = postPreference("dim"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid preference returned %d", rr.Code)
+	}
+	if rr = postPreference("false"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected response %d", rr.Code)
+	}
+	if state.userPrefersDarkMode("user2") {
+		t.Fatal("the dark mode was not turned off")
+	}
+}
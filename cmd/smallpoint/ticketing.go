@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+)
+
+// The access requests and the group creations get a ticket in Jira or
+// ServiceNow for the teams that track their access changes there. The
+// requester can link an existing ticket instead, otherwise one is created.
+// The decision of a request is posted back to its ticket as a comment, the
+// ticket is left open for its workflow to close.
+
+const (
+	ticketingSystemJira       = "jira"
+	ticketingSystemServiceNow = "servicenow"
+	defaultTicketingTimeout   = 30 * time.Second
+	defaultJiraIssueType      = "Task"
+	defaultServiceNowTable    = "incident"
+	ticketingServiceName      = "ticketing"
+	maxTicketingResponseSize  = 1 << 20
+)
+
+var ticketIDPatterns = map[string]*regexp.Regexp{
+	ticketingSystemJira:       regexp.MustCompile(`^[A-Z][A-Z0-9_]+-[0-9]+$`),
+	ticketingSystemServiceNow: regexp.MustCompile(`^[A-Z]+[0-9]+$`),
+}
+
+// the decisions posted to the tickets
+var ticketedRequestDecisions = map[string]string{
+	auditActionApproveRequest: "approved",
+	auditActionRejectRequest:  "rejected",
+	auditActionCancelRequest:  "cancelled",
+	auditActionExpireRequest:  "expired",
+}
+
+type ticketingConfig struct {
+	// System is jira or servicenow.
+	System string `yaml:"system"`
+	// URL is the base URL of the instance, e.g. https://example.atlassian.net.
+	URL string `yaml:"url"`
+	// Username is the account of the API token, Jira takes the token as a
+	// bearer token without it.
+	Username string `yaml:"username"`
+	// TokenFilename holds the API token (Jira) or the password
+	// (ServiceNow), the integration is disabled without it.
+	TokenFilename string `yaml:"token_filename"`
+	// Project and IssueType are the Jira project and type of the created
+	// issues.
+	Project   string `yaml:"project"`
+	IssueType string `yaml:"issue_type"`
+	// Table is the ServiceNow table of the created records.
+	Table   string        `yaml:"table"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (config ticketingConfig) enabled() bool {
+	return config.TokenFilename != ""
+}
+
+func (config ticketingConfig) check() error {
+	if !config.enabled() {
+		return nil
+	}
+	parsedURL, err := url.Parse(config.URL)
+	if err != nil || parsedURL.Scheme != "https" || parsedURL.Host == "" {
+		return fmt.Errorf("url must be an https URL")
+	}
+	switch config.System {
+	case ticketingSystemJira:
+		if config.Project == "" {
+			return fmt.Errorf("project is required for jira")
+		}
+	case ticketingSystemServiceNow:
+		if config.Username == "" {
+			return fmt.Errorf("username is required for servicenow")
+		}
+	default:
+		return fmt.Errorf("system must be %s or %s", ticketingSystemJira, ticketingSystemServiceNow)
+	}
+	return nil
+}
+
+// checkTicketID returns the message of an invalid ticket id.
+func (config ticketingConfig) checkTicketID(ticketID string) string {
+	if !ticketIDPatterns[config.System].MatchString(ticketID) {
+		return fmt.Sprintf("invalid ticket %q", ticketID)
+	}
+	return ""
+}
+
+type ticketingClient interface {
+	createTicket(summary string, description string) (string, error)
+	comment(ticketID string, text string) error
+}
+
+// ticketingHTTPClient does the requests of both systems.
+type ticketingHTTPClient struct {
+	baseURL  string
+	username string
+	token    string
+	client   *http.Client
+}
+
+func newTicketingClient(config ticketingConfig) (ticketingClient, error) {
+	token, err := ioutil.ReadFile(config.TokenFilename)
+	if err != nil {
+		return nil, err
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultTicketingTimeout
+	}
+	client := ticketingHTTPClient{
+		baseURL:  strings.TrimSuffix(config.URL, "/"),
+		username: config.Username,
+		token:    strings.TrimSpace(string(token)),
+		client:   &http.Client{Timeout: timeout},
+	}
+	if config.System == ticketingSystemServiceNow {
+		table := config.Table
+		if table == "" {
+			table = defaultServiceNowTable
+		}
+		return &serviceNowClient{ticketingHTTPClient: client, table: table}, nil
+	}
+	issueType := config.IssueType
+	if issueType == "" {
+		issueType = defaultJiraIssueType
+	}
+	return &jiraClient{ticketingHTTPClient: client, project: config.Project, issueType: issueType}, nil
+}
+
+func (c *ticketingHTTPClient) do(method string, path string, body interface{}, response interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	metrics.MetricLogExternalServiceDuration(ticketingServiceName, time.Since(start))
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTicketingResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s failed with status %d", method, req.URL.Path, resp.StatusCode)
+	}
+	if response != nil {
+		return json.Unmarshal(content, response)
+	}
+	return nil
+}
+
+type jiraClient struct {
+	ticketingHTTPClient
+	project   string
+	issueType string
+}
+
+func (c *jiraClient) createTicket(summary string, description string) (string, error) {
+	request := map[string]interface{}{"fields": map[string]interface{}{
+		"project":     map[string]string{"key": c.project},
+		"issuetype":   map[string]string{"name": c.issueType},
+		"summary":     summary,
+		"description": description,
+	}}
+	var issue struct {
+		Key string `json:"key"`
+	}
+	err := c.do(postMethod, "/rest/api/2/issue", request, &issue)
+	if err != nil {
+		return "", err
+	}
+	if issue.Key == "" {
+		return "", fmt.Errorf("jira returned no issue key")
+	}
+	return issue.Key, nil
+}
+
+func (c *jiraClient) comment(ticketID string, text string) error {
+	return c.do(postMethod, "/rest/api/2/issue/"+url.PathEscape(ticketID)+"/comment",
+		map[string]string{"body": text}, nil)
+}
+
+type serviceNowClient struct {
+	ticketingHTTPClient
+	table string
+}
+
+type serviceNowRecord struct {
+	SysID  string `json:"sys_id"`
+	Number string `json:"number"`
+}
+
+func (c *serviceNowClient) tablePath() string {
+	return "/api/now/table/" + url.PathEscape(c.table)
+}
+
+// createTicket returns the number of the record, the id the people use.
+func (c *serviceNowClient) createTicket(summary string, description string) (string, error) {
+	var response struct {
+		Result serviceNowRecord `json:"result"`
+	}
+	err := c.do(postMethod, c.tablePath(),
+		map[string]string{"short_description": summary, "description": description}, &response)
+	if err != nil {
+		return "", err
+	}
+	if response.Result.Number == "" {
+		return "", fmt.Errorf("servicenow returned no record number")
+	}
+	return response.Result.Number, nil
+}
+
+// comment adds a work note to the record of the number.
+func (c *serviceNowClient) comment(ticketID string, text string) error {
+	query := url.Values{"sysparm_query": {"number=" + ticketID}, "sysparm_fields": {"sys_id"},
+		"sysparm_limit": {"1"}}
+	var response struct {
+		Result []serviceNowRecord `json:"result"`
+	}
+	err := c.do(getMethod, c.tablePath()+"?"+query.Encode(), nil, &response)
+	if err != nil {
+		return err
+	}
+	if len(response.Result) == 0 || response.Result[0].SysID == "" {
+		return fmt.Errorf("servicenow record %s not found", ticketID)
+	}
+	return c.do(http.MethodPatch, c.tablePath()+"/"+url.PathEscape(response.Result[0].SysID),
+		map[string]string{"work_notes": text}, nil)
+}
+
+var setAccessRequestTicketStmt = map[string]string{
+	"sqlite":   "update access_requests set ticket_id=? where username=? and groupname=? and state='pending';",
+	"postgres": "update access_requests set ticket_id=$1 where username=$2 and groupname=$3 and state='pending';",
+}
+
+var setAccessRequestTicketByIDStmt = map[string]string{
+	"sqlite":   "update access_requests set ticket_id=? where id=?;",
+	"postgres": "update access_requests set ticket_id=$1 where id=$2;",
+}
+
+var getGroupTicketStmt = map[string]string{
+	"sqlite":   "select ticket_id from group_tickets where groupname=?;",
+	"postgres": "select ticket_id from group_tickets where groupname=$1;",
+}
+
+var insertGroupTicketStmt = map[string]string{
+	"sqlite":   "insert into group_tickets(groupname, ticket_id, created_at) values (?,?,?);",
+	"postgres": "insert into group_tickets(groupname, ticket_id, created_at) values ($1,$2,$3);",
+}
+
+var deleteGroupTicketStmt = map[string]string{
+	"sqlite":   "delete from group_tickets where groupname=?;",
+	"postgres": "delete from group_tickets where groupname=$1;",
+}
+
+var setGroupTicketStmt = map[string]string{
+	"sqlite":   "update group_tickets set ticket_id=? where groupname=?;",
+	"postgres": "update group_tickets set ticket_id=$1 where groupname=$2;",
+}
+
+// getGroupTicketFromDB returns the ticket of the creation of the group,
+// empty without one.
+func getGroupTicketFromDB(groupname string, state *RuntimeState) (string, error) {
+	start := time.Now()
+	var ticketID string
+	err := state.db.QueryRow(getGroupTicketStmt[state.dbType], groupname).Scan(&ticketID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	metrics.MetricLogExternalServiceDuration("storage", time.Since(start))
+	return ticketID, nil
+}
+
+// setGroupTicketInDB keeps the ticket of the group created last under the
+// name.
+func setGroupTicketInDB(groupname string, ticketID string, state *RuntimeState) error {
+	current, err := getGroupTicketFromDB(groupname, state)
+	if err != nil {
+		return err
+	}
+	if current != "" {
+		return execServiceAccountUpdate(state, setGroupTicketStmt[state.dbType], ticketID, groupname)
+	}
+	return execServiceAccountUpdate(state, insertGroupTicketStmt[state.dbType], groupname, ticketID,
+		time.Now().Unix())
+}
+
+// requestedTicketID returns the ticket the requester linked, and the
+// message when it is invalid. The ticket is ignored while the integration
+// is disabled.
+func (state *RuntimeState) requestedTicketID(ticketID string) (string, string) {
+	ticketID = strings.TrimSpace(ticketID)
+	if ticketID == "" || !state.Config.Ticketing.enabled() {
+		return "", ""
+	}
+	return ticketID, state.Config.Ticketing.checkTicketID(ticketID)
+}
+
+// linkAccessRequestTickets links the pending requests of the user to the
+// ticket.
+func (state *RuntimeState) linkAccessRequestTickets(r *http.Request, username string, groupnames []string,
+	ticketID string) error {
+	if ticketID == "" || state.isDryRun(r) {
+		return nil
+	}
+	for _, groupname := range groupnames {
+		err := execServiceAccountUpdate(state, setAccessRequestTicketStmt[state.dbType], ticketID, username,
+			groupname)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkGroupTicket links the creation of the group to the ticket, without
+// one the ticket of a former group of the name is dropped.
+func (state *RuntimeState) linkGroupTicket(r *http.Request, groupname string, ticketID string) error {
+	if !state.Config.Ticketing.enabled() || state.isDryRun(r) {
+		return nil
+	}
+	if ticketID == "" {
+		return execServiceAccountUpdate(state, deleteGroupTicketStmt[state.dbType], groupname)
+	}
+	return setGroupTicketInDB(groupname, ticketID, state)
+}
+
+// latestAccessRequest returns the last request of the user to join the
+// group, nil without one.
+func latestAccessRequest(username string, groupname string, state *RuntimeState) (*accessRequest, error) {
+	requests, err := searchAccessRequestsInDB(accessRequestFilter{Username: username, Groupname: groupname},
+		state)
+	if err != nil || len(requests) == 0 {
+		return nil, err
+	}
+	return &requests[len(requests)-1], nil
+}
+
+// ticketAccessRequest creates the ticket of the new request, or comments
+// on the ticket the requester linked.
+func (state *RuntimeState) ticketAccessRequest(client ticketingClient, event auditEvent) error {
+	request, err := latestAccessRequest(event.Username, event.Groupname, state)
+	if err != nil || request == nil || request.State != requestStatePending {
+		return err
+	}
+	description := fmt.Sprintf("%s requested to join the group %s.", event.Username, event.Groupname)
+	if request.Justification != "" {
+		description += "\n\nJustification: " + request.Justification
+	}
+	if request.TicketID != "" {
+		return client.comment(request.TicketID, "This ticket was linked to the access request. "+description)
+	}
+	ticketID, err := client.createTicket(
+		fmt.Sprintf("Access request: %s to join %s", event.Username, event.Groupname), description)
+	if err != nil {
+		return err
+	}
+	return execServiceAccountUpdate(state, setAccessRequestTicketByIDStmt[state.dbType], ticketID, request.ID)
+}
+
+// ticketRequestDecision posts the decision to the ticket of the request.
+func (state *RuntimeState) ticketRequestDecision(client ticketingClient, event auditEvent) error {
+	request, err := latestAccessRequest(event.Username, event.Groupname, state)
+	if err != nil || request == nil || request.TicketID == "" {
+		return err
+	}
+	text := fmt.Sprintf("The access request of %s to join %s was %s by %s.", event.Username, event.Groupname,
+		ticketedRequestDecisions[event.Action], event.Actor)
+	if event.Details != "" {
+		text += "\n\nComment: " + event.Details
+	}
+	return client.comment(request.TicketID, text)
+}
+
+// ticketGroupCreation creates the ticket of the new group, or comments on
+// the ticket the creator linked.
+func (state *RuntimeState) ticketGroupCreation(client ticketingClient, event auditEvent) error {
+	ticketID, err := getGroupTicketFromDB(event.Groupname, state)
+	if err != nil {
+		return err
+	}
+	description := fmt.Sprintf("%s created the group %s, %s.", event.Actor, event.Groupname, event.Details)
+	if ticketID != "" {
+		return client.comment(ticketID, "This ticket was linked to the group creation. "+description)
+	}
+	ticketID, err = client.createTicket("Group creation: "+event.Groupname, description)
+	if err != nil {
+		return err
+	}
+	return setGroupTicketInDB(event.Groupname, ticketID, state)
+}
+
+func (state *RuntimeState) updateTickets(event auditEvent) error {
+	var update func(ticketingClient, auditEvent) error
+	switch event.Action {
+	case auditActionRequestAccess:
+		update = state.ticketAccessRequest
+	case auditActionCreateGroup:
+		update = state.ticketGroupCreation
+	default:
+		if ticketedRequestDecisions[event.Action] == "" {
+			return nil
+		}
+		update = state.ticketRequestDecision
+	}
+	client, err := newTicketingClient(state.Config.Ticketing)
+	if err != nil {
+		return err
+	}
+	return update(client, event)
+}
+
+// ticketingAuditEvent updates the tickets of the successful requests and
+// group creations in the background.
+func (state *RuntimeState) ticketingAuditEvent(event auditEvent) {
+	if !state.Config.Ticketing.enabled() || event.Outcome != auditOutcomeSuccess {
+		return
+	}
+	go func() {
+		err := state.updateTickets(event)
+		if err != nil {
+			slog.Error("cannot update the ticket of the audit event", "event", event, "err", err)
+		}
+	}()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testRequestAccessWithTicket(t *testing.T, state *RuntimeState, group string, ticket string) int {
+	jsonBytes, _ := json.Marshal(map[string][]string{"groups": {group}, "ticket": {ticket}})
+	req, err := http.NewRequest(postMethod, requestaccessPath, bytes.NewReader(jsonBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(state.requestAccessHandler).ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestTicketing(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "ticketing_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.Config.Base.StorageURL = "sqlite:" + filepath.Join(dir, "ticketing.db")
+	err = initDB(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenFilename := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(tokenFilename, []byte("secret-token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	var calls []string
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Body   string `json:"body"`
+			Fields struct {
+				Summary string `json:"summary"`
+				Project struct {
+					Key string `json:"key"`
+				} `json:"project"`
+			} `json:"fields"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.URL.Path == "/rest/api/2/issue" {
+			calls = append(calls, "create "+body.Fields.Project.Key+" "+body.Fields.Summary)
+			w.Write([]byte(`{"id":"10001","key":"OPS-1"}`))
+			return
+		}
+		calls = append(calls, strings.TrimPrefix(r.URL.Path, "/rest/api/2/issue/")+" "+body.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"20001"}`))
+	}))
+	defer jira.Close()
+	state.Config.Ticketing = ticketingConfig{System: ticketingSystemJira, URL: jira.URL,
+		TokenFilename: tokenFilename, Project: "OPS"}
+	// the updates run in the background
+	waitForCall := func(prefix string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			mutex.Lock()
+			for _, call := range calls {
+				if strings.HasPrefix(call, prefix) {
+					mutex.Unlock()
+					return
+				}
+			}
+			mutex.Unlock()
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("no call %q in %q", prefix, calls)
+	}
+
+	if code := testRequestAccessWithTicket(t, &state, "group3", "not a ticket"); code != http.StatusBadRequest {
+		t.Fatalf("invalid ticket returned %d", code)
+	}
+	if code := testRequestAccessWithTicket(t, &state, "group3", ""); code != http.StatusOK {
+		t.Fatalf("request returned %d", code)
+	}
+	waitForCall("create OPS Access request: user2 to join group3")
+	deadline := time.Now().Add(5 * time.Second)
+	var request *accessRequest
+	for time.Now().Before(deadline) {
+		request, err = latestAccessRequest("user2", "group3", &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if request.TicketID != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if request.TicketID != "OPS-1" {
+		t.Fatalf("ticket of the request %q", request.TicketID)
+	}
+	err = closeRequestInDB("user2", "group3", requestStateApproved, "user1", "needed", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.recordAuditEvent(nil, "user1", auditActionApproveRequest, "group3", "user2", auditOutcomeSuccess,
+		"needed")
+	waitForCall("OPS-1/comment The access request of user2 to join group3 was approved by user1.")
+
+	// the linked tickets get a comment instead of a new ticket
+	code := testPostServiceAccountForm(t, &state, creategroupPath, state.createGrouphandler, true,
+		url.Values{"groupname": {"ticket_group"}, "description": {"self-managed"}, "members": {"user1"},
+			"ticket": {"OPS-9"}})
+	if code != http.StatusOK {
+		t.Fatalf("create group returned %d", code)
+	}
+	waitForCall("OPS-9/comment This ticket was linked to the group creation.")
+	ticketID, err := getGroupTicketFromDB("ticket_group", &state)
+	if err != nil || ticketID != "OPS-9" {
+		t.Fatalf("ticket of the group %q, err %v", ticketID, err)
+	}
+	if code := testRequestAccessWithTicket(t, &state, "ticket_group", "OPS-77"); code != http.StatusOK {
+		t.Fatalf("request with a ticket returned %d", code)
+	}
+	waitForCall("OPS-77/comment This ticket was linked to the access request.")
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(calls) != 4 {
+		t.Fatalf("unexpected calls %q", calls)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// smallpoint serves HTTPS itself. The certificate is the one of
+// base.tls_cert_filename or, with tls.acme, one obtained and renewed from an
+// ACME CA such as Let's Encrypt with the TLS-ALPN-01 challenge on the HTTPS
+// port, and the HTTP-01 challenge when tls.acme.http_address is set, the
+// other requests to that address are redirected to HTTPS. The minimum
+// version and the cipher suites of TLS 1.2 are configurable, only the
+// secure suites are accepted, and require_client_cert makes every client
+// present a certificate of base.client_ca_filename.
+
+const defaultACMEDirectoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type acmeConfig struct {
+	// Hostnames are the names of the certificate, ACME is off without
+	// them.
+	Hostnames      []string `yaml:"hostnames"`
+	Email          string   `yaml:"email"`
+	DirectoryURL   string   `yaml:"directory_url"`
+	CacheDirectory string   `yaml:"cache_directory"`
+	// HTTPAddress serves the HTTP-01 challenges and the redirects to
+	// HTTPS, e.g. ":80".
+	HTTPAddress string `yaml:"http_address"`
+}
+
+func (config acmeConfig) enabled() bool {
+	return len(config.Hostnames) > 0
+}
+
+type tlsServerConfig struct {
+	// MinVersion is "1.2", the default, or "1.3".
+	MinVersion string `yaml:"min_version"`
+	// CipherSuites are the names of the TLS 1.2 suites, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+	CipherSuites      []string   `yaml:"cipher_suites"`
+	RequireClientCert bool       `yaml:"require_client_cert"`
+	ACME              acmeConfig `yaml:"acme"`
+}
+
+func (config tlsServerConfig) check(base baseConfig, audit auditConfig) error {
+	if _, ok := tlsVersions[config.MinVersion]; !ok {
+		return fmt.Errorf("min_version must be 1.2 or 1.3, not %s", config.MinVersion)
+	}
+	_, err := cipherSuiteIDs(config.CipherSuites)
+	if err != nil {
+		return err
+	}
+	if config.RequireClientCert && base.ClientCAFilename == "" {
+		return errors.New("require_client_cert requires base.client_ca_filename")
+	}
+	if config.ACME.enabled() {
+		if base.TLSCertFilename != "" || base.TLSKeyFilename != "" {
+			return errors.New("acme cannot be used with base.tls_cert_filename")
+		}
+		if config.ACME.CacheDirectory == "" {
+			return errors.New("acme requires cache_directory")
+		}
+		// the entries are signed with the key of base.tls_key_filename
+		if audit.SignChain {
+			return errors.New("acme cannot be used with audit.sign_chain")
+		}
+	} else if config.ACME.HTTPAddress != "" {
+		return errors.New("acme.http_address requires acme.hostnames")
+	}
+	return nil
+}
+
+// cipherSuiteIDs returns the ids of the named suites, the default ones
+// without names.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return defaultCipherSuites, nil
+	}
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func loadClientCAs(filename string) (*x509.CertPool, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	caCert, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no PEM certificates in %s", filename)
+	}
+	return pool, nil
+}
+
+// newTLSConfig returns the TLS config of the server, its certificates come
+// from the ACME manager when there is one.
+func newTLSConfig(config tlsServerConfig, clientCAs *x509.CertPool, manager *autocert.Manager) (*tls.Config, error) {
+	cipherSuites, err := cipherSuiteIDs(config.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:       tlsVersions[config.MinVersion],
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP384, tls.CurveP256},
+		CipherSuites:     cipherSuites,
+		ClientAuth:       tls.VerifyClientCertIfGiven,
+		ClientCAs:        clientCAs,
+		NextProtos:       []string{"h2", "http/1.1"},
+	}
+	if config.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if manager != nil {
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+	}
+	return tlsConfig, nil
+}
+
+func newACMEManager(config acmeConfig) *autocert.Manager {
+	directoryURL := config.DirectoryURL
+	if directoryURL == "" {
+		directoryURL = defaultACMEDirectoryURL
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(config.CacheDirectory),
+		HostPolicy: autocert.HostWhitelist(config.Hostnames...),
+		Email:      config.Email,
+		Client:     &acme.Client{DirectoryURL: directoryURL},
+	}
+}
+
+// serveACMEHTTP answers the HTTP-01 challenges and redirects the other
+// requests to HTTPS.
+func serveACMEHTTP(address string, manager *autocert.Manager) {
+	server := &http.Server{
+		Addr:         address,
+		Handler:      manager.HTTPHandler(nil),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil {
+			slog.Error("the ACME HTTP listener failed", "address", address, "err", err)
+		}
+	}()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestTLSServerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsserver_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFilename, keyFilename := testWriteCertificate(t, dir)
+	certificate, err := tls.LoadX509KeyPair(certFilename, keyFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect := func(config tlsServerConfig, maxVersion uint16) error {
+		tlsConfig, err := newTLSConfig(config, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = tlsConfig
+		server.StartTLS()
+		defer server.Close()
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion}}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	// the default suites serve the ECDSA certificates over TLS 1.2
+	if err := connect(tlsServerConfig{}, tls.VersionTLS12); err != nil {
+		t.Fatal(err)
+	}
+	if err := connect(tlsServerConfig{MinVersion: "1.3"}, tls.VersionTLS12); err == nil {
+		t.Fatal("TLS 1.2 was accepted with min_version 1.3")
+	}
+	if err := connect(tlsServerConfig{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+		tls.VersionTLS12); err == nil {
+		t.Fatal("the ECDSA certificate was served without an ECDSA suite")
+	}
+
+	tlsConfig, err := newTLSConfig(tlsServerConfig{RequireClientCert: true}, nil,
+		newACMEManager(acmeConfig{Hostnames: []string{"smallpoint.example.com"}, CacheDirectory: dir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.GetCertificate == nil ||
+		tlsConfig.NextProtos[len(tlsConfig.NextProtos)-1] != acme.ALPNProto {
+		t.Fatal("the client certificates or the ACME challenges are not set up")
+	}
+
+	base := baseConfig{TLSCertFilename: certFilename, TLSKeyFilename: keyFilename}
+	for _, config := range []tlsServerConfig{
+		{MinVersion: "1.1"},
+		{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{RequireClientCert: true},
+		{ACME: acmeConfig{Hostnames: []string{"smallpoint.example.com"}, CacheDirectory: dir}},
+		{ACME: acmeConfig{HTTPAddress: ":80"}},
+	} {
+		if err := config.check(base, auditConfig{}); err == nil {
+			t.Errorf("the config %+v was accepted", config)
+		}
+	}
+	acme := acmeConfig{Hostnames: []string{"smallpoint.example.com"}}
+	if err := (tlsServerConfig{ACME: acme}).check(baseConfig{}, auditConfig{}); err == nil {
+		t.Error("acme without a cache directory was accepted")
+	}
+	acme.CacheDirectory = dir
+	if err := (tlsServerConfig{ACME: acme}).check(baseConfig{}, auditConfig{}); err != nil {
+		t.Errorf("acme was refused: %s", err)
+	}
+	if err := (tlsServerConfig{ACME: acme}).check(baseConfig{}, auditConfig{SignChain: true}); err == nil {
+		t.Error("acme with the audit chain signing was accepted")
+	}
+}
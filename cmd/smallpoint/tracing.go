@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The requests are traced with OpenTelemetry when an OTLP endpoint is
+// configured. The server span of a request is named after the handler
+// pattern, the directory calls made with requestUserinfo and the calls to
+// the OpenID provider are its children. The DB helpers and the directory
+// calls of the background jobs take no request and are not traced.
+
+const tracerName = "github.com/Symantec/ldap-group-management/cmd/smallpoint"
+
+type tracingConfig struct {
+	// OTLPEndpoint is the host:port of the OTLP/HTTP collector, tracing is
+	// disabled when empty.
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	// Insecure exports over HTTP instead of HTTPS.
+	Insecure    bool   `yaml:"insecure"`
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is the ratio of the traces started by smallpoint that are
+	// sampled, all of them by default. The sampling decision of the callers
+	// is kept.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+func (config tracingConfig) sampler() (sdktrace.Sampler, error) {
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid sample ratio %v", config.SampleRatio)
+	}
+	ratio := config.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+}
+
+func (state *RuntimeState) setupTracing() error {
+	config := state.Config.Tracing
+	if config.OTLPEndpoint == "" {
+		return nil
+	}
+	sampler, err := config.sampler()
+	if err != nil {
+		return err
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.OTLPEndpoint)}
+	if config.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return err
+	}
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "smallpoint"
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(Version))))
+	// the client of the OpenID provider uses the global provider
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{},
+		propagation.Baggage{}))
+	state.tracerProvider = provider
+	return nil
+}
+
+// tracingHandler starts the server span of the requests. mux gives the
+// handler patterns.
+func (state *RuntimeState) tracingHandler(handler http.Handler, mux *http.ServeMux) http.Handler {
+	if state.tracerProvider == nil {
+		return handler
+	}
+	return otelhttp.NewHandler(handler, "smallpoint",
+		otelhttp.WithTracerProvider(state.tracerProvider),
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			_, pattern := mux.Handler(r)
+			return r.Method + " " + pattern
+		}))
+}
+
+// requestUserinfo returns the directory of the request, its calls are traced
+// as children of the request span and its changes are only logged on a dry
+// run. r is nil outside of a request.
+func (state *RuntimeState) requestUserinfo(r *http.Request) userinfo.UserInfoBackend {
+	directory := state.Userinfo
+	if !state.dryRun && isDryRunRequest(r) {
+		directory = newDryRunUserInfo(directory, requestLogger(r), state.Config.TargetLDAP.GroupManageAttribute)
+	}
+	if state.tracerProvider == nil || r == nil {
+		return directory
+	}
+	return &tracedUserInfo{
+		UserInfoBackend: directory,
+		ctx:             r.Context(),
+		tracer:          state.tracerProvider.Tracer(tracerName),
+	}
+}
+
+type tracedUserInfo struct {
+	userinfo.UserInfoBackend
+	ctx    context.Context
+	tracer trace.Tracer
+}
+
+// trace starts the span of the method, the returned function ends it with
+// the error of the call.
+func (u *tracedUserInfo) trace(method string) func(*error) {
+	_, span := u.tracer.Start(u.ctx, "userinfo."+method, trace.WithSpanKind(trace.SpanKindClient))
+	return func(err *error) {
+		if err != nil && *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+	}
+}
+
+func (u *tracedUserInfo) GetallUsers() (users []string, err error) {
+	defer u.trace("GetallUsers")(&err)
+	return u.UserInfoBackend.GetallUsers()
+}
+
+func (u *tracedUserInfo) CreateGroup(groupinfo userinfo.GroupInfo) (err error) {
+	defer u.trace("CreateGroup")(&err)
+	return u.UserInfoBackend.CreateGroup(groupinfo)
+}
+
+func (u *tracedUserInfo) GetUsedGidNumbers(min int, max int) (used map[int]bool, err error) {
+	defer u.trace("GetUsedGidNumbers")(&err)
+	return u.UserInfoBackend.GetUsedGidNumbers(min, max)
+}
+
+func (u *tracedUserInfo) DeleteGroup(groupnames []string) (err error) {
+	defer u.trace("DeleteGroup")(&err)
+	return u.UserInfoBackend.DeleteGroup(groupnames)
+}
+
+func (u *tracedUserInfo) ChangeDescription(groupname string, managegroup string) (err error) {
+	defer u.trace("ChangeDescription")(&err)
+	return u.UserInfoBackend.ChangeDescription(groupname, managegroup)
+}
+
+func (u *tracedUserInfo) SetGroupMail(groupname string, addresses []string) (err error) {
+	defer u.trace("SetGroupMail")(&err)
+	return u.UserInfoBackend.SetGroupMail(groupname, addresses)
+}
+
+func (u *tracedUserInfo) GetMailOwners(address string) (owners []string, err error) {
+	defer u.trace("GetMailOwners")(&err)
+	return u.UserInfoBackend.GetMailOwners(address)
+}
+
+func (u *tracedUserInfo) RenameGroup(groupname string, newname string) (err error) {
+	defer u.trace("RenameGroup")(&err)
+	return u.UserInfoBackend.RenameGroup(groupname, newname)
+}
+
+func (u *tracedUserInfo) GetallGroups() (groups []string, err error) {
+	defer u.trace("GetallGroups")(&err)
+	return u.UserInfoBackend.GetallGroups()
+}
+
+func (u *tracedUserInfo) GetgroupsofUser(username string) (groups []string, err error) {
+	defer u.trace("GetgroupsofUser")(&err)
+	return u.UserInfoBackend.GetgroupsofUser(username)
+}
+
+func (u *tracedUserInfo) GetusersofaGroup(groupname string) (users []string, managedBy string, err error) {
+	defer u.trace("GetusersofaGroup")(&err)
+	return u.UserInfoBackend.GetusersofaGroup(groupname)
+}
+
+func (u *tracedUserInfo) GetGroupUsersAndManagers(groupname string) (users []string, managers []string, managedBy string, err error) {
+	defer u.trace("GetGroupUsersAndManagers")(&err)
+	return u.UserInfoBackend.GetGroupUsersAndManagers(groupname)
+}
+
+func (u *tracedUserInfo) ParseSuperadmins() (superadmins []string) {
+	defer u.trace("ParseSuperadmins")(nil)
+	return u.UserInfoBackend.ParseSuperadmins()
+}
+
+func (u *tracedUserInfo) UserisadminOrNot(username string) (isAdmin bool) {
+	defer u.trace("UserisadminOrNot")(nil)
+	return u.UserInfoBackend.UserisadminOrNot(username)
+}
+
+func (u *tracedUserInfo) AddmemberstoExisting(groupinfo userinfo.GroupInfo) (err error) {
+	defer u.trace("AddmemberstoExisting")(&err)
+	return u.UserInfoBackend.AddmemberstoExisting(groupinfo)
+}
+
+func (u *tracedUserInfo) DeletemembersfromGroup(groupinfo userinfo.GroupInfo) (err error) {
+	defer u.trace("DeletemembersfromGroup")(&err)
+	return u.UserInfoBackend.DeletemembersfromGroup(groupinfo)
+}
+
+func (u *tracedUserInfo) IsgroupmemberorNot(groupname string, username string) (isMember bool, managedBy string, err error) {
+	defer u.trace("IsgroupmemberorNot")(&err)
+	return u.UserInfoBackend.IsgroupmemberorNot(groupname, username)
+}
+
+func (u *tracedUserInfo) GetDescriptionvalue(groupname string) (description string, err error) {
+	defer u.trace("GetDescriptionvalue")(&err)
+	return u.UserInfoBackend.GetDescriptionvalue(groupname)
+}
+
+func (u *tracedUserInfo) GetEmailofauser(username string) (emails []string, err error) {
+	defer u.trace("GetEmailofauser")(&err)
+	return u.UserInfoBackend.GetEmailofauser(username)
+}
+
+func (u *tracedUserInfo) GetEmailofusersingroup(groupname string) (emails []string, err error) {
+	defer u.trace("GetEmailofusersingroup")(&err)
+	return u.UserInfoBackend.GetEmailofusersingroup(groupname)
+}
+
+func (u *tracedUserInfo) CreateServiceAccount(groupinfo userinfo.GroupInfo) (err error) {
+	defer u.trace("CreateServiceAccount")(&err)
+	return u.UserInfoBackend.CreateServiceAccount(groupinfo)
+}
+
+func (u *tracedUserInfo) IsgroupAdminorNot(username string, groupname string) (isAdmin bool, err error) {
+	defer u.trace("IsgroupAdminorNot")(&err)
+	return u.UserInfoBackend.IsgroupAdminorNot(username, groupname)
+}
+
+func (u *tracedUserInfo) UsernameExistsornot(username string) (exists bool, err error) {
+	defer u.trace("UsernameExistsornot")(&err)
+	return u.UserInfoBackend.UsernameExistsornot(username)
+}
+
+func (u *tracedUserInfo) GroupnameExistsornot(groupname string) (exists bool, description string, err error) {
+	defer u.trace("GroupnameExistsornot")(&err)
+	return u.UserInfoBackend.GroupnameExistsornot(groupname)
+}
+
+func (u *tracedUserInfo) ServiceAccountExistsornot(groupname string) (exists bool, description string, err error) {
+	defer u.trace("ServiceAccountExistsornot")(&err)
+	return u.UserInfoBackend.ServiceAccountExistsornot(groupname)
+}
+
+func (u *tracedUserInfo) DisableServiceAccount(accountname string) (err error) {
+	defer u.trace("DisableServiceAccount")(&err)
+	return u.UserInfoBackend.DisableServiceAccount(accountname)
+}
+
+func (u *tracedUserInfo) DeleteServiceAccount(accountname string) (err error) {
+	defer u.trace("DeleteServiceAccount")(&err)
+	return u.UserInfoBackend.DeleteServiceAccount(accountname)
+}
+
+func (u *tracedUserInfo) SetServiceAccountPassword(accountname string, password string) (err error) {
+	defer u.trace("SetServiceAccountPassword")(&err)
+	return u.UserInfoBackend.SetServiceAccountPassword(accountname, password)
+}
+
+func (u *tracedUserInfo) GetAllGroupsManagedBy() (groups [][]string, err error) {
+	defer u.trace("GetAllGroupsManagedBy")(&err)
+	return u.UserInfoBackend.GetAllGroupsManagedBy()
+}
+
+func (u *tracedUserInfo) GetGroupsInfoOfUser(groupdn string, username string) (groups [][]string, err error) {
+	defer u.trace("GetGroupsInfoOfUser")(&err)
+	return u.UserInfoBackend.GetGroupsInfoOfUser(groupdn, username)
+}
+
+func (u *tracedUserInfo) GetGroupandManagedbyAttributeValue(groupnames []string) (groups [][]string, err error) {
+	defer u.trace("GetGroupandManagedbyAttributeValue")(&err)
+	return u.UserInfoBackend.GetGroupandManagedbyAttributeValue(groupnames)
+}
+
+func (u *tracedUserInfo) CreateUser(username string, givenName, email []string) (err error) {
+	defer u.trace("CreateUser")(&err)
+	return u.UserInfoBackend.CreateUser(username, givenName, email)
+}
+
+func (u *tracedUserInfo) SearchUsers(prefix string, limit int) (users []userinfo.UserSearchResult, err error) {
+	defer u.trace("SearchUsers")(&err)
+	return u.UserInfoBackend.SearchUsers(prefix, limit)
+}
+
+func (u *tracedUserInfo) GetUserAttributes(username string) (givenNames []string, emails []string, err error) {
+	defer u.trace("GetUserAttributes")(&err)
+	return u.UserInfoBackend.GetUserAttributes(username)
+}
+
+func (u *tracedUserInfo) GetSubgroupsofGroup(groupname string) (groups []string, err error) {
+	defer u.trace("GetSubgroupsofGroup")(&err)
+	return u.UserInfoBackend.GetSubgroupsofGroup(groupname)
+}
+
+func (u *tracedUserInfo) GetParentgroupsofGroup(groupname string) (groups []string, err error) {
+	defer u.trace("GetParentgroupsofGroup")(&err)
+	return u.UserInfoBackend.GetParentgroupsofGroup(groupname)
+}
+
+func (u *tracedUserInfo) Ping() (err error) {
+	defer u.trace("Ping")(&err)
+	return u.UserInfoBackend.Ping()
+}
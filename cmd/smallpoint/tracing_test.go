@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingConfig(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.setupTracing()
+	if err != nil || state.tracerProvider != nil {
+		t.Fatalf("tracing without an endpoint was set up, err=%v", err)
+	}
+	for _, ratio := range []float64{-0.5, 1.5} {
+		_, err = tracingConfig{SampleRatio: ratio}.sampler()
+		if err == nil {
+			t.Errorf("the sample ratio %v should be invalid", ratio)
+		}
+	}
+}
+
+func TestTracingHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(groupinfoPath, func(w http.ResponseWriter, r *http.Request) {
+		if state.requestUserinfo(r) != state.Userinfo {
+			t.Error("the directory of the request is traced while tracing is disabled")
+		}
+	})
+	rr := httptest.NewRecorder()
+	state.tracingHandler(mux, mux).ServeHTTP(rr, httptest.NewRequest(getMethod, groupinfoPath, nil))
+
+	recorder := tracetest.NewSpanRecorder()
+	state.tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	mux = http.NewServeMux()
+	mux.HandleFunc(groupinfoPath, func(w http.ResponseWriter, r *http.Request) {
+		directory := state.requestUserinfo(r)
+		_, _, err := directory.GetusersofaGroup("group1")
+		if err != nil {
+			t.Error(err)
+		}
+		_, _, err = directory.GetusersofaGroup("nonexistent")
+		if err == nil {
+			t.Error("the nonexistent group has members")
+		}
+	})
+	rr = httptest.NewRecorder()
+	state.tracingHandler(mux, mux).ServeHTTP(rr, httptest.NewRequest(getMethod, groupinfoPath, nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+	server := spans[2]
+	if server.Name() != getMethod+" "+groupinfoPath {
+		t.Fatalf("unexpected server span %q", server.Name())
+	}
+	for i, span := range spans[:2] {
+		if span.Name() != "userinfo.GetusersofaGroup" ||
+			span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Fatalf("unexpected directory span %q parent %s", span.Name(), span.Parent().SpanID())
+		}
+		if failed := span.Status().Code == codes.Error; failed != (i == 1) {
+			t.Fatalf("unexpected status %v of the directory span %d", span.Status(), i)
+		}
+	}
+}
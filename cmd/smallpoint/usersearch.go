@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// The members inputs suggest the users matching what the group owners type,
+// instead of listing every user of the directory in the page.
+
+const (
+	defaultUserSearchLimit = 10
+	maxUserSearchLimit     = 50
+)
+
+type userSearchResponse struct {
+	Users []userinfo.UserSearchResult
+}
+
+// userSearchHandler returns the users whose uid, name or mail start with the
+// q parameter.
+func (state *RuntimeState) userSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	_, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	q := r.URL.Query()
+	prefix := strings.TrimSpace(q.Get("q"))
+	if prefix == "" {
+		state.writeFailureResponse(w, r, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit := defaultUserSearchLimit
+	if limitText := q.Get("limit"); limitText != "" {
+		limit, err = strconv.Atoi(limitText)
+		if err != nil || limit < 1 || limit > maxUserSearchLimit {
+			state.writeFailureResponse(w, r, fmt.Sprintf("limit must be between 1 and %d", maxUserSearchLimit),
+				http.StatusBadRequest)
+			return
+		}
+	}
+	users, err := state.requestUserinfo(r).SearchUsers(prefix, limit)
+	if err != nil {
+		requestLogger(r).Error("userSearchHandler failed", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	response := userSearchResponse{Users: []userinfo.UserSearchResult{}}
+	response.Users = append(response.Users, users...)
+	b, err := json.Marshal(response)
+	if err != nil {
+		requestLogger(r).Error("Failed marshal", "err", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=15")
+	_, err = w.Write(b)
+	if err != nil {
+		requestLogger(r).Error("userSearchHandler failed", "err", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserSearchHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query    string
+		code     int
+		expected []string
+	}{
+		{"q=user", http.StatusOK, []string{"user1", "user2", "user3"}},
+		{"q=USER&limit=2", http.StatusOK, []string{"user1", "user2"}},
+		{"q=user3%40example", http.StatusOK, []string{"user3"}},
+		{"q=nobody", http.StatusOK, []string{}},
+		{"q=", http.StatusBadRequest, nil},
+		{"q=user&limit=500", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", userSearchAPIPath+"?"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookie := testCreateValidCookie(state.authenticator)
+		testAddAuthCookie(req, state.authenticator, cookie)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(state.userSearchHandler).ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("query '%s' returned %d: %s", test.query, rr.Code, rr.Body.String())
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		var response userSearchResponse
+		err = json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var usernames []string
+		for _, user := range response.Users {
+			usernames = append(usernames, user.Username)
+		}
+		if len(usernames) != len(test.expected) {
+			t.Errorf("query '%s' returned %v", test.query, usernames)
+			continue
+		}
+		for i := range usernames {
+			if usernames[i] != test.expected[i] {
+				t.Errorf("query '%s' returned %v", test.query, usernames)
+				break
+			}
+		}
+	}
+}
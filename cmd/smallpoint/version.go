@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// The version endpoint tells the operators what is deployed: the release,
+// the commit and the build date, set with -ldflags by the Makefile, and the
+// optional features enabled by the configuration. The binaries built
+// without the Makefile report the commit and the date recorded by the go
+// tool.
+
+var (
+	GitCommit = ""
+	BuildDate = ""
+)
+
+type versionInfo struct {
+	Version   string   `json:"version"`
+	GitCommit string   `json:"git_commit"`
+	BuildDate string   `json:"build_date"`
+	GoVersion string   `json:"go_version"`
+	Features  []string `json:"features,omitempty"`
+}
+
+func buildVersionInfo() versionInfo {
+	info := versionInfo{Version: Version, GitCommit: GitCommit, BuildDate: BuildDate,
+		GoVersion: runtime.Version()}
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range buildInfo.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && info.GitCommit == "":
+			info.GitCommit = setting.Value
+		case setting.Key == "vcs.time" && info.BuildDate == "":
+			info.BuildDate = setting.Value
+		}
+	}
+	return info
+}
+
+// enabledFeatures returns the names of the optional features enabled by
+// the configuration, sorted.
+func (state *RuntimeState) enabledFeatures() []string {
+	config := &state.Config
+	enabled := map[string]bool{
+		"audit_chain_signing":  config.Audit.SignChain,
+		"audit_syslog":         config.Audit.Syslog.Address != "",
+		"compliance_reports":   len(config.ComplianceReports.Periods) > 0,
+		"db_encryption":        config.DBEncryption.KeysFilename != "",
+		"directory_sync":       config.DirectorySync.Interval > 0,
+		"dry_run":              state.dryRun,
+		"error_reporting":      config.ErrorReporting.DSN != "",
+		"gid_allocation":       len(config.GidAllocation.Ranges) > 0,
+		"group_listing_cache":  config.GroupListingCache.TTL >= 0,
+		"leader_election":      config.LeaderElection.Enabled,
+		"mailing_list_webhook": config.MailingLists.WebhookURL != "",
+		"membership_snapshots": config.History.SnapshotInterval > 0,
+		"redis":                state.redisClient != nil,
+		"retention":            config.Retention.enabled(),
+		"tracing":              config.Tracing.OTLPEndpoint != "",
+	}
+	var features []string
+	for name, isEnabled := range enabled {
+		if isEnabled {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+func writeVersion(out io.Writer, info versionInfo) {
+	fmt.Fprintf(out, "version %s\n", info.Version)
+	if info.GitCommit != "" {
+		fmt.Fprintf(out, "commit %s\n", info.GitCommit)
+	}
+	if info.BuildDate != "" {
+		fmt.Fprintf(out, "built %s\n", info.BuildDate)
+	}
+	fmt.Fprintf(out, "go %s\n", info.GoVersion)
+}
+
+func (state *RuntimeState) versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != getMethod {
+		state.writeFailureResponse(w, r, "GET Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	_, err := state.GetRemoteUserName(w, r)
+	if err != nil {
+		return
+	}
+	info := buildVersionInfo()
+	info.Features = state.enabledFeatures()
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(info)
+	if err != nil {
+		requestLogger(r).Error("cannot write the version response", "err", err)
+	}
+}
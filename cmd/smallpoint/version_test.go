@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVersionHandler(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	state.versionHandler(rr, httptest.NewRequest(getMethod, versionPath, nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("unauthenticated request returned %d", rr.Code)
+	}
+
+	state.dryRun = true
+	state.Config.LeaderElection.Enabled = true
+	state.Config.History.SnapshotInterval = time.Hour
+	state.Config.GroupListingCache.TTL = -1
+	req := httptest.NewRequest(getMethod, versionPath, nil)
+	cookie := testCreateValidCookie(state.authenticator)
+	testAddAuthCookie(req, state.authenticator, cookie)
+	rr = httptest.NewRecorder()
+	state.versionHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var info versionInfo
+	err = json.Unmarshal(rr.Body.Bytes(), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != Version || info.GoVersion == "" {
+		t.Fatalf("unexpected version %+v", info)
+	}
+	expected := []string{"dry_run", "leader_election", "membership_snapshots"}
+	if !reflect.DeepEqual(info.Features, expected) {
+		t.Fatalf("features %v, expected %v", info.Features, expected)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The webhooks smallpoint sends carry a unique delivery id in the
+// Smallpoint-Delivery header and, with the secret of webhook_signing, an
+// HMAC-SHA256 signature in the Smallpoint-Signature header like the one of
+// the HR webhook, "t=<unix time>,v1=<hex digest>", but with the digest over
+// "<unix time>.<delivery id>.<body>". The receivers authenticate the
+// payloads and reject the old timestamps and the delivery ids they have
+// already seen.
+
+const (
+	webhookSignatureHeader = "Smallpoint-Signature"
+	webhookDeliveryHeader  = "Smallpoint-Delivery"
+)
+
+type webhookSigningConfig struct {
+	// SecretFilename holds the secret shared with the receivers, the
+	// webhooks are not signed without it.
+	SecretFilename string `yaml:"secret_filename"`
+}
+
+func (config webhookSigningConfig) enabled() bool {
+	return config.SecretFilename != ""
+}
+
+func newWebhookDeliveryID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// webhookSignature returns the Smallpoint-Signature header of the delivery.
+func webhookSignature(secret []byte, timestamp time.Time, deliveryID string, body []byte) string {
+	unixTime := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unixTime + "." + deliveryID + "."))
+	mac.Write(body)
+	return "t=" + unixTime + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookRequest returns the POST of the JSON body with a new delivery
+// id and its signature.
+func (state *RuntimeState) newWebhookRequest(url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	deliveryID := newWebhookDeliveryID()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookDeliveryHeader, deliveryID)
+	if len(state.webhookSigningSecret) > 0 {
+		req.Header.Set(webhookSignatureHeader, webhookSignature(state.webhookSigningSecret, time.Now(),
+			deliveryID, body))
+	}
+	return req, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSigning(t *testing.T) {
+	state, err := setupTestState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.webhookSigningSecret = []byte("0123456789abcdef")
+	deliveries := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		deliveryID := r.Header.Get(webhookDeliveryHeader)
+		if len(deliveryID) != 32 || deliveries[deliveryID] {
+			t.Errorf("bad delivery id %q", deliveryID)
+		}
+		deliveries[deliveryID] = true
+		// the digest is the one of the HR webhook over the delivery id and
+		// the body
+		err = verifyHRWebhookSignature(state.webhookSigningSecret, r.Header.Get(webhookSignatureHeader),
+			append([]byte(deliveryID+"."), body...), time.Now())
+		if err != nil {
+			t.Error(err)
+		}
+		// a replay under another delivery id does not verify
+		err = verifyHRWebhookSignature(state.webhookSigningSecret, r.Header.Get(webhookSignatureHeader),
+			append([]byte(newWebhookDeliveryID()+"."), body...), time.Now())
+		if err == nil {
+			t.Error("the signature verified with another delivery id")
+		}
+	}))
+	defer server.Close()
+	state.Config.MailingLists.WebhookURL = server.URL
+	err = setGroupMailAddressesInDB("signed-list", groupMailAddresses{Address: "signed-list@example.com"},
+		"user1", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		err = state.notifyMailingListChange(auditEvent{Actor: "user1", Action: auditActionAddMember,
+			Groupname: "signed-list", Username: "user3", Outcome: auditOutcomeSuccess})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("%d deliveries", len(deliveries))
+	}
+
+	// without a secret the deliveries are not signed
+	state.webhookSigningSecret = nil
+	req, err := state.newWebhookRequest(server.URL, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get(webhookSignatureHeader) != "" || req.Header.Get(webhookDeliveryHeader) == "" {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+}
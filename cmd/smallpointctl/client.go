@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// apiClient calls the smallpoint handlers as the user of the client
+// certificate. The handlers answer in JSON unless the request accepts
+// text/html.
+type apiClient struct {
+	baseURL    string
+	httpClient *http.Client
+	dryRun     bool
+}
+
+type clientConfig struct {
+	ServerURL    string
+	CertFilename string
+	KeyFilename  string
+	CAFilename   string
+	Timeout      time.Duration
+	// DryRun asks the server to log the LDAP changes instead of applying
+	// them.
+	DryRun bool
+}
+
+func newAPIClient(config clientConfig) (*apiClient, error) {
+	if config.ServerURL == "" {
+		return nil, errors.New("a server URL is required")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CertFilename != "" || config.KeyFilename != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFilename, config.KeyFilename)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.CAFilename != "" {
+		caCert, err := ioutil.ReadFile(config.CAFilename)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates in %s", config.CAFilename)
+		}
+	}
+	return &apiClient{
+		baseURL: strings.TrimSuffix(config.ServerURL, "/"),
+		dryRun:  config.DryRun,
+		httpClient: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			// the unauthenticated requests are redirected to the login
+			// page, they are reported instead
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// apiError is a failed call, Message is the error message of the server.
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *apiError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", err.StatusCode, err.Message)
+}
+
+type messageResponse struct {
+	SuccessMessage string
+	ErrorMessage   string
+}
+
+func (client *apiClient) do(method string, path string, query url.Values, contentType string,
+	body io.Reader, response interface{}) error {
+	requestURL := client.baseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if client.dryRun {
+		req.Header.Set(dryRunHeader, "true")
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		message := strings.TrimSpace(string(responseBody))
+		var failure messageResponse
+		if json.Unmarshal(responseBody, &failure) == nil && failure.ErrorMessage != "" {
+			message = strings.TrimSpace(failure.ErrorMessage)
+		}
+		if resp.StatusCode < 400 {
+			message = "not authenticated, redirected to " + resp.Header.Get("Location")
+		}
+		return &apiError{StatusCode: resp.StatusCode, Message: message}
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(responseBody, response)
+}
+
+func (client *apiClient) get(path string, query url.Values, response interface{}) error {
+	return client.do(http.MethodGet, path, query, "", nil, response)
+}
+
+func (client *apiClient) postForm(path string, form url.Values, response interface{}) error {
+	return client.do(http.MethodPost, path, nil, "application/x-www-form-urlencoded",
+		strings.NewReader(form.Encode()), response)
+}
+
+func (client *apiClient) postJSON(path string, body interface{}, response interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return client.do(http.MethodPost, path, nil, "application/json", bytes.NewReader(b), response)
+}
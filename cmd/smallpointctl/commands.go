@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The paths of the smallpoint handlers used by the commands.
+const (
+	allGroupsTablePath       = "/api/v1/tables/all_groups"
+	myGroupsTablePath        = "/api/v1/tables/my_groups"
+	managedGroupsTablePath   = "/api/v1/tables/managed_groups"
+	groupMembersTablePath    = "/api/v1/tables/group_members"
+	addMembersPath           = "/addmembers/"
+	deleteMembersPath        = "/deletemembers/"
+	getGroupsJSPath          = "/getGroups.js"
+	approveRequestPath       = "/approve-request"
+	rejectRequestPath        = "/reject-request"
+	createServiceAccountPath = "/create_serviceaccount/"
+	versionPath              = "/api/version"
+)
+
+// dryRunHeader asks the server for a dry run of the request.
+const dryRunHeader = "Smallpoint-Dry-Run"
+
+// tablePageSize is the largest page the table endpoints return.
+const tablePageSize = 1000
+
+// errUsage is returned for invalid arguments, the usage of the command is
+// printed.
+var errUsage = errors.New("invalid arguments")
+
+type command struct {
+	usage       string
+	description string
+	run         func(client *apiClient, out io.Writer, args []string) error
+}
+
+var commands = map[string]command{
+	"groups": {"groups [-mine|-managed] [-tag TAG]",
+		"list the groups with their managing group and member count", listGroupsCommand},
+	"members":     {"members GROUP", "list the members of a group", listMembersCommand},
+	"add-members": {"add-members GROUP USER...", "add users to a group", addMembersCommand},
+	"remove-members": {"remove-members GROUP USER...", "remove users from a group",
+		removeMembersCommand},
+	"requests": {"requests", "list the requests waiting for your decision", listRequestsCommand},
+	"approve": {"approve [-comment TEXT] USER GROUP", "approve the request of a user to join a group",
+		approveCommand},
+	"reject": {"reject [-comment TEXT] USER GROUP", "reject the request of a user to join a group",
+		rejectCommand},
+	"create-serviceaccount": {"create-serviceaccount [-mail MAIL] [-shell SHELL] [-owner GROUP] [-justification TEXT] NAME",
+		"create a service account, or request it when you are not an admin", createServiceAccountCommand},
+	"version": {"version", "print the version of smallpointctl and of the server", versionCommand},
+}
+
+func newCommandFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	return flags
+}
+
+type tablePage struct {
+	Total    int
+	Filtered int
+	Rows     [][]string
+}
+
+// getTableRows reads every page of a table endpoint.
+func (client *apiClient) getTableRows(path string, query url.Values) ([][]string, error) {
+	var rows [][]string
+	for page := 0; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		query.Set("size", strconv.Itoa(tablePageSize))
+		var response tablePage
+		err := client.get(path, query, &response)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, response.Rows...)
+		if len(response.Rows) < tablePageSize || len(rows) >= response.Filtered {
+			return rows, nil
+		}
+	}
+}
+
+func writeRows(out io.Writer, rows [][]string) {
+	for _, row := range rows {
+		fmt.Fprintln(out, strings.Join(row, "\t"))
+	}
+}
+
+func listGroupsCommand(client *apiClient, out io.Writer, args []string) error {
+	flags := newCommandFlags("groups")
+	mine := flags.Bool("mine", false, "list the groups you are a member of")
+	managed := flags.Bool("managed", false, "list the groups you manage")
+	tag := flags.String("tag", "", "list the groups with the tag")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 || (*mine && *managed) {
+		return errUsage
+	}
+	path := allGroupsTablePath
+	switch {
+	case *mine:
+		path = myGroupsTablePath
+	case *managed:
+		path = managedGroupsTablePath
+	}
+	query := url.Values{}
+	if *tag != "" {
+		query.Set("tag", *tag)
+	}
+	rows, err := client.getTableRows(path, query)
+	if err != nil {
+		return err
+	}
+	writeRows(out, rows)
+	return nil
+}
+
+func listMembersCommand(client *apiClient, out io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	rows, err := client.getTableRows(groupMembersTablePath, url.Values{"groupname": {args[0]}})
+	if err != nil {
+		return err
+	}
+	writeRows(out, rows)
+	return nil
+}
+
+func changeMembers(client *apiClient, out io.Writer, name string, path string, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	form := url.Values{
+		"groupname": {args[0]},
+		"members":   {strings.Join(args[1:], ",")},
+	}
+	var response messageResponse
+	err := client.postForm(path, form, &response)
+	if err != nil {
+		return err
+	}
+	if response.SuccessMessage != "" {
+		fmt.Fprintln(out, response.SuccessMessage)
+	}
+	return nil
+}
+
+func addMembersCommand(client *apiClient, out io.Writer, args []string) error {
+	return changeMembers(client, out, "add-members", addMembersPath, args)
+}
+
+func removeMembersCommand(client *apiClient, out io.Writer, args []string) error {
+	return changeMembers(client, out, "remove-members", deleteMembersPath, args)
+}
+
+func listRequestsCommand(client *apiClient, out io.Writer, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	var response struct {
+		Groups [][]string
+	}
+	err := client.get(getGroupsJSPath, url.Values{"type": {"pendingActions"}, "encoding": {"json"}},
+		&response)
+	if err != nil {
+		return err
+	}
+	writeRows(out, response.Groups)
+	return nil
+}
+
+func versionCommand(client *apiClient, out io.Writer, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	fmt.Fprintf(out, "smallpointctl %s\n", Version)
+	var response struct {
+		Version   string   `json:"version"`
+		GitCommit string   `json:"git_commit"`
+		BuildDate string   `json:"build_date"`
+		GoVersion string   `json:"go_version"`
+		Features  []string `json:"features"`
+	}
+	err := client.get(versionPath, nil, &response)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "server %s\n", response.Version)
+	writeRows(out, [][]string{
+		{"commit", response.GitCommit},
+		{"built", response.BuildDate},
+		{"go", response.GoVersion},
+		{"features", strings.Join(response.Features, " ")},
+	})
+	return nil
+}
+
+// requestDecision is the body of the approve and reject requests.
+type requestDecision struct {
+	Groups  [][]string `json:"groups"`
+	Comment string     `json:"comment"`
+}
+
+func decideRequest(client *apiClient, out io.Writer, name string, path string, decided string,
+	args []string) error {
+	flags := newCommandFlags(name)
+	comment := flags.String("comment", "", "the comment recorded with the decision")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	username, groupname := flags.Arg(0), flags.Arg(1)
+	err = client.postJSON(path, requestDecision{Groups: [][]string{{username, groupname}}, Comment: *comment},
+		nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s the request of %s to join %s\n", decided, username, groupname)
+	return nil
+}
+
+func approveCommand(client *apiClient, out io.Writer, args []string) error {
+	return decideRequest(client, out, "approve", approveRequestPath, "Approved", args)
+}
+
+func rejectCommand(client *apiClient, out io.Writer, args []string) error {
+	return decideRequest(client, out, "reject", rejectRequestPath, "Rejected", args)
+}
+
+func createServiceAccountCommand(client *apiClient, out io.Writer, args []string) error {
+	flags := newCommandFlags("create-serviceaccount")
+	mail := flags.String("mail", "", "the mail of the account")
+	shell := flags.String("shell", "/bin/false", "the login shell, /bin/false or /bin/bash")
+	owner := flags.String("owner", "", "the group owning the account")
+	justification := flags.String("justification", "", "why the account is needed")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	form := url.Values{
+		"AccountName":   {flags.Arg(0)},
+		"mail":          {*mail},
+		"loginShell":    {*shell},
+		"ownerGroup":    {*owner},
+		"justification": {*justification},
+	}
+	var response messageResponse
+	err = client.postForm(createServiceAccountPath, form, &response)
+	if err != nil {
+		return err
+	}
+	if response.SuccessMessage != "" {
+		fmt.Fprintln(out, response.SuccessMessage)
+	}
+	return nil
+}
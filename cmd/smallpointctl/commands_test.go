@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func testAPIClient(t *testing.T, handler http.Handler) *apiClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := newAPIClient(clientConfig{ServerURL: server.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestListGroupsReadsEveryPage(t *testing.T) {
+	const groups = tablePageSize + 5
+	client := testAPIClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != managedGroupsTablePath || r.Header.Get("Accept") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		page, _ := strconv.Atoi(r.FormValue("page"))
+		size, _ := strconv.Atoi(r.FormValue("size"))
+		response := tablePage{Total: groups, Filtered: groups}
+		for i := page * size; i < groups && i < (page+1)*size; i++ {
+			response.Rows = append(response.Rows, []string{fmt.Sprintf("group%d", i), "admins", "1"})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	var out bytes.Buffer
+	err := listGroupsCommand(client, &out, []string{"-managed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != groups {
+		t.Fatalf("listed %d groups", lines)
+	}
+	if err = listGroupsCommand(client, &out, []string{"-mine", "-managed"}); err != errUsage {
+		t.Fatalf("conflicting flags returned %v", err)
+	}
+}
+
+func TestChangeMembersAndDecisions(t *testing.T) {
+	var form map[string][]string
+	var decision requestDecision
+	client := testAPIClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case addMembersPath:
+			r.ParseForm()
+			form = r.PostForm
+			w.Write([]byte(`{"SuccessMessage":"Group has been updated"}`))
+		case approveRequestPath:
+			json.NewDecoder(r.Body).Decode(&decision)
+		case deleteMembersPath:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"ErrorMessage":"403 Forbidden. you are not authorized\n"}`))
+		default:
+			http.Redirect(w, r, "/login", http.StatusFound)
+		}
+	}))
+	var out bytes.Buffer
+	err := addMembersCommand(client, &out, []string{"group1", "user1", "user2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form["groupname"][0] != "group1" || form["members"][0] != "user1,user2" {
+		t.Fatalf("bad form %v", form)
+	}
+	if out.String() != "Group has been updated\n" {
+		t.Fatalf("bad output %q", out.String())
+	}
+
+	err = approveCommand(client, &out, []string{"-comment", "on call", "user3", "group3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decision.Groups) != 1 || decision.Groups[0][0] != "user3" || decision.Groups[0][1] != "group3" ||
+		decision.Comment != "on call" {
+		t.Fatalf("bad decision %+v", decision)
+	}
+
+	err = removeMembersCommand(client, &out, []string{"group1", "user1"})
+	if apiErr, ok := err.(*apiError); !ok || apiErr.StatusCode != http.StatusForbidden ||
+		apiErr.Message != "403 Forbidden. you are not authorized" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// the login redirects are reported
+	err = listRequestsCommand(client, &out, nil)
+	if apiErr, ok := err.(*apiError); !ok || apiErr.StatusCode != http.StatusFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err = removeMembersCommand(client, &out, []string{"group1"}); err != errUsage {
+		t.Fatalf("missing members returned %v", err)
+	}
+}
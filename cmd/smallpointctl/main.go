@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// smallpointctl runs the common operations of smallpoint from the command
+// line through its API, for scripts and for when the pages are not
+// available. It authenticates with a client certificate signed by the
+// client CA of the server.
+
+var (
+	Version      = "No version provided"
+	serverURL    = flag.String("server", "https://localhost", "The URL of the smallpoint server")
+	certFilename = flag.String("cert", "", "The filename of the client certificate")
+	keyFilename  = flag.String("key", "", "The filename of the key of the client certificate")
+	caFilename   = flag.String("ca", "", "The filename of the CA of the server, the system CAs when empty")
+	timeout      = flag.Duration("timeout", time.Minute, "The timeout of each API call")
+	dryRun       = flag.Bool("dry-run", false, "Ask the server to log the LDAP changes instead of applying them")
+	printVersion = flag.Bool("version", false, "Print the version and exit")
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s (version %s):\n", os.Args[0], Version)
+	fmt.Fprintf(os.Stderr, "  %s [flags] command [args]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", commands[name].usage, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = Usage
+	flag.Parse()
+
+	if *printVersion {
+		fmt.Printf("smallpointctl %s\n", Version)
+		os.Exit(0)
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+	client, err := newAPIClient(clientConfig{
+		ServerURL:    *serverURL,
+		CertFilename: *certFilename,
+		KeyFilename:  *keyFilename,
+		CAFilename:   *caFilename,
+		Timeout:      *timeout,
+		DryRun:       *dryRun,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot set up the client: %s\n", err)
+		os.Exit(1)
+	}
+	err = command.run(client, os.Stdout, flag.Args()[1:])
+	if err == errUsage {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] %s\n", os.Args[0], command.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
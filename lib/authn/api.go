@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -22,15 +23,40 @@ type AuthCookie struct {
 	ExpiresAt time.Time
 }
 
-type SetHeadersFunc func(w http.ResponseWriter) error
+// SecurityEventFunc is called with the security events of the requests and
+// the reason of the event.
+type SecurityEventFunc func(r *http.Request, event string, reason error)
+
+// The security events.
+const (
+	// SecurityEventInvalidCookie is an auth cookie rejected by
+	// GetRemoteUserName.
+	SecurityEventInvalidCookie = "invalid_auth_cookie"
+	// SecurityEventInvalidState is an OAuth2 redirect with a missing or
+	// invalid state JWT.
+	SecurityEventInvalidState = "invalid_oauth2_state"
+	// SecurityEventExpiredCookie is an expired auth cookie with a valid
+	// signature, the user logs in again.
+	SecurityEventExpiredCookie = "expired_auth_cookie"
+	// SecurityEventLoginFailed is an OAuth2 redirect with a valid state
+	// whose code the provider rejected.
+	SecurityEventLoginFailed = "oauth2_login_failed"
+)
 
 type Authenticator struct {
-	openID         OpenIDConfig
-	sharedSecrets  []string
-	appName        string
-	netClient      *http.Client
-	logger         *log.Logger
-	setHeadersFunc SetHeadersFunc
+	openID        OpenIDConfig
+	sharedSecrets []string
+	appName       string
+	netClient     *http.Client
+	logger        *log.Logger
+
+	securityEventFunc SecurityEventFunc
+	// insecureTransport serves the logins over plain HTTP.
+	insecureTransport bool
+
+	// secretsMutex protects the secrets, they may be replaced while the
+	// requests are served.
+	secretsMutex sync.RWMutex
 }
 
 const Oauth2redirectPath = "/oauth2/redirect"
@@ -39,15 +65,13 @@ const AuthCookieName = "authn_cookie"
 const secsBetweenCleanup = 30
 
 func NewAuthenticator(config OpenIDConfig, appName string, netClient *http.Client,
-	sharedSecrets []string, logger *log.Logger,
-	setHeadersFunc SetHeadersFunc) *Authenticator {
+	sharedSecrets []string, logger *log.Logger) *Authenticator {
 	authenticator := Authenticator{
-		openID:         config,
-		appName:        appName,
-		sharedSecrets:  sharedSecrets,
-		netClient:      netClient,
-		logger:         logger,
-		setHeadersFunc: setHeadersFunc}
+		openID:        config,
+		appName:       appName,
+		sharedSecrets: sharedSecrets,
+		netClient:     netClient,
+		logger:        logger}
 	if logger == nil {
 		authenticator.logger = log.New(os.Stdout, appName, log.LstdFlags)
 	}
@@ -64,9 +88,100 @@ func NewAuthenticator(config OpenIDConfig, appName string, netClient *http.Clien
 	return &authenticator
 }
 
+// SetSecurityEventFunc sets the function called with the security events.
+func (a *Authenticator) SetSecurityEventFunc(securityEventFunc SecurityEventFunc) {
+	a.securityEventFunc = securityEventFunc
+}
+
+// SetInsecureTransport drops the Secure flag of the cookies and redirects
+// the users back over plain HTTP, for the local development only.
+func (a *Authenticator) SetInsecureTransport(insecure bool) {
+	a.insecureTransport = insecure
+}
+
+// SetClientSecret replaces the OpenID client secret, e.g. after a rotation.
+func (a *Authenticator) SetClientSecret(clientSecret string) {
+	a.secretsMutex.Lock()
+	defer a.secretsMutex.Unlock()
+	a.openID.ClientSecret = clientSecret
+}
+
+// SetSharedSecrets replaces the shared secrets, the first one signs the new
+// cookies and the others still validate the older ones. Empty lists are
+// ignored.
+func (a *Authenticator) SetSharedSecrets(sharedSecrets []string) {
+	if len(sharedSecrets) < 1 {
+		return
+	}
+	a.secretsMutex.Lock()
+	defer a.secretsMutex.Unlock()
+	a.sharedSecrets = append([]string(nil), sharedSecrets...)
+}
+
 func (a *Authenticator) GetRemoteUserName(w http.ResponseWriter, r *http.Request) (string, error) {
 	return a.getRemoteUserName(w, r)
 }
+
+// GetVerifiedUserName returns the user of a valid client certificate or auth
+// cookie, or "" when the request is not authenticated. Unlike
+// GetRemoteUserName it never redirects.
+func (a *Authenticator) GetVerifiedUserName(r *http.Request) string {
+	return a.getVerifiedUserName(r)
+}
+
+// Session describes how a request is authenticated. The auth cookies are not
+// stored server side, so the session of the request is the only one known.
+type Session struct {
+	Username string
+	// Method is SessionMethodCertificate or SessionMethodCookie.
+	Method    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// AuthTime is when the user last authenticated at the provider, zero
+	// when unknown, and ACR the authentication class the provider reported.
+	AuthTime time.Time
+	ACR      string
+}
+
+const (
+	SessionMethodCertificate = "client certificate"
+	SessionMethodCookie      = "cookie"
+)
+
+// GetSession returns the session of the request or nil when the request is
+// not authenticated. The times are zero for the client certificates.
+func (a *Authenticator) GetSession(r *http.Request) *Session {
+	return a.getSession(r)
+}
+
+// ClaimedUserName returns the user named by the auth cookie of the request
+// without verifying the cookie, or "". It only keys the throttling of the
+// invalid cookies and must not be trusted otherwise.
+func (a *Authenticator) ClaimedUserName(r *http.Request) string {
+	return a.claimedUserName(r)
+}
+
+// CSRFToken returns the CSRF token of the auth cookie of the request, or ""
+// without a valid auth cookie. The token changes with the cookie and is the
+// same on every instance sharing the secrets.
+func (a *Authenticator) CSRFToken(r *http.Request) string {
+	return a.csrfToken(r)
+}
+
+// CheckCSRFToken reports whether the token is the CSRF token of the auth
+// cookie of the request, the tokens of the older secrets are accepted too.
+func (a *Authenticator) CheckCSRFToken(r *http.Request, token string) bool {
+	return a.checkCSRFToken(r, token)
+}
+
+// ReauthenticationURL returns the URL of the provider prompting the user to
+// log in again, with the authentication classes of acrValues when set. The
+// user then returns to returnURL with a session whose AuthTime is the new
+// login.
+func (a *Authenticator) ReauthenticationURL(r *http.Request, returnURL string, acrValues string) (string, error) {
+	return a.reauthenticationURL(r, returnURL, acrValues)
+}
+
 func (a *Authenticator) Oauth2RedirectPathHandler(w http.ResponseWriter, r *http.Request) {
 	a.oauth2RedirectPathHandler(w, r)
 }
@@ -75,3 +190,9 @@ func (a *Authenticator) Oauth2RedirectPathHandler(w http.ResponseWriter, r *http
 func (a *Authenticator) GenUserCookieValue(username string, expires time.Time) (string, error) {
 	return a.genUserCookieValue(username, expires)
 }
+
+// This function is only for testing purposes, should not be used in prod
+func (a *Authenticator) GenAuthenticatedCookieValue(username string, expires time.Time, authTime time.Time,
+	acr string) (string, error) {
+	return a.genAuthCookieValue(username, expires, authTime.Unix(), acr)
+}
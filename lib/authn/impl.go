@@ -2,10 +2,14 @@ package authn
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -25,6 +29,8 @@ type oauth2StateJWT struct {
 	NotBefore  int64    `json:"nbf,omitempty"`
 	IssuedAt   int64    `json:"iat,omitempty"`
 	ReturnURL  string   `json:"return_url,omitempty"`
+	// Reauthenticate marks the logins the user was prompted for again.
+	Reauthenticate bool `json:"reauth,omitempty"`
 }
 
 type accessToken struct {
@@ -43,6 +49,13 @@ type openidConnectUserInfo struct {
 	Email             string `json:"email,omitempty"`
 }
 
+// idTokenClaims are the claims of the ID token describing the
+// authentication of the user.
+type idTokenClaims struct {
+	AuthTime int64  `json:"auth_time,omitempty"`
+	ACR      string `json:"acr,omitempty"`
+}
+
 type authNCookieJWT struct {
 	Issuer     string   `json:"iss,omitempty"`
 	Subject    string   `json:"sub,omitempty"`
@@ -51,6 +64,8 @@ type authNCookieJWT struct {
 	Expiration int64    `json:"exp,omitempty"`
 	NotBefore  int64    `json:"nbf,omitempty"`
 	IssuedAt   int64    `json:"iat,omitempty"`
+	AuthTime   int64    `json:"auth_time,omitempty"`
+	ACR        string   `json:"acr,omitempty"`
 }
 
 func randomStringGeneration() (string, error) {
@@ -65,11 +80,32 @@ func randomStringGeneration() (string, error) {
 
 const cookieExpirationHours = 2
 
+// secrets returns the shared secrets, the first one signs.
+func (a *Authenticator) secrets() []string {
+	a.secretsMutex.RLock()
+	defer a.secretsMutex.RUnlock()
+	return a.sharedSecrets
+}
+
+func (a *Authenticator) clientSecret() string {
+	a.secretsMutex.RLock()
+	defer a.secretsMutex.RUnlock()
+	return a.openID.ClientSecret
+}
+
 func (a *Authenticator) genUserCookieValue(username string, expires time.Time) (string, error) {
-	if len(a.sharedSecrets[0]) < 1 {
+	return a.genAuthCookieValue(username, expires, 0, "")
+}
+
+// genAuthCookieValue returns the cookie of the user authenticated by the
+// provider at authTime, 0 when the provider did not tell.
+func (a *Authenticator) genAuthCookieValue(username string, expires time.Time, authTime int64,
+	acr string) (string, error) {
+	secret := a.secrets()[0]
+	if len(secret) < 1 {
 		return "", errors.New("invalid authenticator state, no shared secrets")
 	}
-	key := []byte(a.sharedSecrets[0])
+	key := []byte(secret)
 	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
 	if err != nil {
 		a.logger.Printf("New jose signer error err: %s", err)
@@ -84,35 +120,47 @@ func (a *Authenticator) genUserCookieValue(username string, expires time.Time) (
 		Audience:   []string{issuer},
 		NotBefore:  now,
 		IssuedAt:   now,
-		Expiration: expires.Unix()}
+		Expiration: expires.Unix(),
+		AuthTime:   authTime,
+		ACR:        acr}
 	return jwt.Signed(sig).Claims(stateToken).CompactSerialize()
 }
 
-func (s *Authenticator) setAndStoreAuthCookie(w http.ResponseWriter, username string) error {
+func (s *Authenticator) setAndStoreAuthCookie(w http.ResponseWriter, username string, authTime int64,
+	acr string) error {
 	expires := time.Now().Add(time.Hour * cookieExpirationHours)
-	cookieValue, err := s.genUserCookieValue(username, expires)
+	cookieValue, err := s.genAuthCookieValue(username, expires, authTime, acr)
 	if err != nil {
 		return err
 	}
-	userCookie := http.Cookie{Name: AuthCookieName, Value: cookieValue, Path: "/", Expires: expires, HttpOnly: true, Secure: true}
+	userCookie := http.Cookie{Name: AuthCookieName, Value: cookieValue, Path: "/", Expires: expires, HttpOnly: true,
+		Secure: !s.insecureTransport}
 	http.SetCookie(w, &userCookie)
 	return nil
 }
 
-func getRedirURL(r *http.Request) string {
+func (s *Authenticator) getRedirURL(r *http.Request) string {
+	if s.insecureTransport {
+		return "http://" + r.Host + Oauth2redirectPath
+	}
 	return "https://" + r.Host + Oauth2redirectPath
 }
 
-func (s *Authenticator) generateAuthCodeURL(state string, r *http.Request) string {
+// generateAuthCodeURL returns the authorization URL of the provider, with the
+// extra parameters of the reauthentications.
+func (s *Authenticator) generateAuthCodeURL(state string, r *http.Request, extra url.Values) string {
 	var buf bytes.Buffer
 	buf.WriteString(s.openID.AuthURL)
-	redirectURL := getRedirURL(r)
+	redirectURL := s.getRedirURL(r)
 	v := url.Values{
 		"response_type": {"code"},
 		"client_id":     {s.openID.ClientID},
 		"scope":         {s.openID.Scopes},
 		"redirect_uri":  {redirectURL},
 	}
+	for key, values := range extra {
+		v[key] = values
+	}
 
 	if state != "" {
 		// TODO(light): Docs say never to omit state; don't allow empty.
@@ -131,10 +179,15 @@ const redirCookieName = "redir_cookie"
 const maxAgeSecondsRedirCookie = 300
 
 func (s *Authenticator) generateValidStateString(r *http.Request) (string, error) {
-	if len(s.sharedSecrets[0]) < 1 {
+	return s.generateStateString(r.URL.String(), false)
+}
+
+func (s *Authenticator) generateStateString(returnURL string, reauthenticate bool) (string, error) {
+	secret := s.secrets()[0]
+	if len(secret) < 1 {
 		return "", errors.New("invalid authenticator state, no shared secrets")
 	}
-	key := []byte(s.sharedSecrets[0])
+	key := []byte(secret)
 	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
 	if err != nil {
 		log.Printf("New jose signer error err: %s", err)
@@ -144,12 +197,13 @@ func (s *Authenticator) generateValidStateString(r *http.Request) (string, error
 	subject := "state:" + redirCookieName
 	now := time.Now().Unix()
 	stateToken := oauth2StateJWT{Issuer: issuer,
-		Subject:    subject,
-		Audience:   []string{issuer},
-		ReturnURL:  r.URL.String(),
-		NotBefore:  now,
-		IssuedAt:   now,
-		Expiration: now + maxAgeSecondsRedirCookie}
+		Subject:        subject,
+		Audience:       []string{issuer},
+		ReturnURL:      returnURL,
+		NotBefore:      now,
+		IssuedAt:       now,
+		Expiration:     now + maxAgeSecondsRedirCookie,
+		Reauthenticate: reauthenticate}
 	return jwt.Signed(sig).Claims(stateToken).CompactSerialize()
 }
 
@@ -161,12 +215,44 @@ func (s *Authenticator) oauth2DoRedirectoToProviderHandler(w http.ResponseWriter
 		http.Error(w, "Internal Error ", http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, s.generateAuthCodeURL(stateString, r), http.StatusFound)
+	http.Redirect(w, r, s.generateAuthCodeURL(stateString, r, nil), http.StatusFound)
+}
+
+// reauthenticationURL returns the URL prompting the user to log in again at
+// the provider, the user then returns to returnURL.
+func (s *Authenticator) reauthenticationURL(r *http.Request, returnURL string, acrValues string) (string, error) {
+	stateString, err := s.generateStateString(returnURL, true)
+	if err != nil {
+		return "", err
+	}
+	extra := url.Values{"prompt": {"login"}, "max_age": {"0"}}
+	if acrValues != "" {
+		extra.Set("acr_values", acrValues)
+	}
+	return s.generateAuthCodeURL(stateString, r, extra), nil
+}
+
+// idTokenAuthentication returns the authentication time and class of the ID
+// token. The token comes straight from the token endpoint over TLS, its
+// signature is not checked.
+func idTokenAuthentication(idToken string) (int64, string) {
+	if idToken == "" {
+		return 0, ""
+	}
+	tok, err := jwt.ParseSigned(idToken)
+	if err != nil {
+		return 0, ""
+	}
+	var claims idTokenClaims
+	if err := tok.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return 0, ""
+	}
+	return claims.AuthTime, claims.ACR
 }
 
 // Next are the functions for checking the callback
 func (s *Authenticator) JWTClaims(t *jwt.JSONWebToken, dest ...interface{}) (err error) {
-	for _, key := range s.sharedSecrets {
+	for _, key := range s.secrets() {
 		binkey := []byte(key)
 		err = t.Claims(binkey, dest...)
 		if err == nil {
@@ -193,8 +279,15 @@ func getUsernameFromUserinfo(userInfo openidConnectUserInfo) string {
 	return username
 }
 
-func (s *Authenticator) getBytesFromSuccessfullPost(url string, data url.Values) ([]byte, error) {
-	response, err := s.netClient.PostForm(url, data)
+// getBytesFromSuccessfullPost posts the form with the context of the request
+// being authenticated, the calls to the provider show in its trace.
+func (s *Authenticator) getBytesFromSuccessfullPost(ctx context.Context, url string, data url.Values) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := s.netClient.Do(req.WithContext(ctx))
 	if err != nil {
 		//s.logger.Debugf(1, "client post error err: %s\n", err)
 		return nil, err
@@ -255,20 +348,22 @@ func (s *Authenticator) oauth2RedirectPathHandler(w http.ResponseWriter, r *http
 	inboundJWT, err := s.getVerifyReturnStateJWT(r)
 	if err != nil {
 		s.logger.Printf("error processing state err: %s\n", err)
+		s.securityEvent(r, SecurityEventInvalidState, err)
 		http.Error(w, "null or bad inboundState", http.StatusUnauthorized)
 		return
 	}
 	// OK state  is valid.. now we perform the token exchange
-	redirectURL := getRedirURL(r)
-	tokenRespBody, err := s.getBytesFromSuccessfullPost(s.openID.TokenURL,
+	redirectURL := s.getRedirURL(r)
+	tokenRespBody, err := s.getBytesFromSuccessfullPost(r.Context(), s.openID.TokenURL,
 		url.Values{"redirect_uri": {redirectURL},
 			"code":          {authCode},
 			"grant_type":    {"authorization_code"},
 			"client_id":     {s.openID.ClientID},
-			"client_secret": {s.openID.ClientSecret},
+			"client_secret": {s.clientSecret()},
 		})
 	if err != nil {
 		s.logger.Printf("Error getting byes fom post err: %s", err)
+		s.securityEvent(r, SecurityEventLoginFailed, err)
 		http.Error(w, "bad transaction with openic context ", http.StatusInternalServerError)
 		return
 	}
@@ -287,7 +382,7 @@ func (s *Authenticator) oauth2RedirectPathHandler(w http.ResponseWriter, r *http
 	}
 
 	// Now we use the access_token (from token exchange) to get userinfo
-	userInfoRespBody, err := s.getBytesFromSuccessfullPost(s.openID.UserinfoURL,
+	userInfoRespBody, err := s.getBytesFromSuccessfullPost(r.Context(), s.openID.UserinfoURL,
 		url.Values{"access_token": {oauth2AccessToken.AccessToken}})
 	if err != nil {
 		s.logger.Println(err)
@@ -303,8 +398,13 @@ func (s *Authenticator) oauth2RedirectPathHandler(w http.ResponseWriter, r *http
 		return
 	}
 	username := getUsernameFromUserinfo(userInfo)
+	authTime, acr := idTokenAuthentication(oauth2AccessToken.IDToken)
+	if authTime == 0 && inboundJWT.Reauthenticate {
+		// the provider was asked to prompt the user
+		authTime = time.Now().Unix()
+	}
 
-	err = s.setAndStoreAuthCookie(w, username)
+	err = s.setAndStoreAuthCookie(w, username, authTime, acr)
 	if err != nil {
 		s.logger.Println(err)
 		http.Error(w, "cannot set auth Cookie", http.StatusInternalServerError)
@@ -315,39 +415,161 @@ func (s *Authenticator) oauth2RedirectPathHandler(w http.ResponseWriter, r *http
 	http.Redirect(w, r, destinationPath, http.StatusFound)
 }
 
-// validateUserCookieValue returns "" if no or bad username, returns non-nil error for fatal errors only
-func (s *Authenticator) validateUserCookieValue(remoteCookieValue string) (string, error) {
+func (s *Authenticator) securityEvent(r *http.Request, event string, reason error) {
+	if s.securityEventFunc != nil {
+		s.securityEventFunc(r, event, reason)
+	}
+}
+
+var errBadCookieState = errors.New("bad cookie Vauue state")
+var errExpiredCookie = errors.New("expired cookie")
+
+// checkUserCookieValue returns the username of the cookie or the reason why
+// the cookie is invalid, errBadCookieState is the only fatal error.
+func (s *Authenticator) checkUserCookieValue(remoteCookieValue string) (string, error) {
+	inboundJWT, err := s.checkUserCookieClaims(remoteCookieValue)
+	if err != nil {
+		return "", err
+	}
+	return inboundJWT.Username, nil
+}
+
+// checkUserCookieClaims returns the claims of a valid cookie.
+func (s *Authenticator) checkUserCookieClaims(remoteCookieValue string) (authNCookieJWT, error) {
 	inboundJWT := authNCookieJWT{}
 	if len(remoteCookieValue) < 1 {
-		s.logger.Printf("Invalid cookie value (too small)")
-		return "", nil
+		return inboundJWT, errors.New("Invalid cookie value (too small)")
 	}
 	tok, err := jwt.ParseSigned(remoteCookieValue)
 	if err != nil {
-		s.logger.Printf("Invalid cookie value(jwt) (%s)", err)
-		return "", nil
+		return inboundJWT, fmt.Errorf("Invalid cookie value(jwt) (%s)", err)
 	}
 	if err := s.JWTClaims(tok, &inboundJWT); err != nil {
-		s.logger.Printf("error validating JWT claims err: %s\n", err)
 		// TODO: this path could have fatal errors, need to take this into account
 		// to avoid a potential redirect loop.
-		return "", nil
+		return inboundJWT, fmt.Errorf("error validating JWT claims err: %s", err)
 	}
 	// At this point we know the signature is valid, but now we must
 	// validate the contents of the JWT token
 	issuer := s.appName
 	subject := "state:" + AuthCookieName
 	if inboundJWT.Issuer != issuer || inboundJWT.Subject != subject ||
-		inboundJWT.NotBefore > time.Now().Unix() || inboundJWT.Expiration < time.Now().Unix() {
-		s.logger.Printf("invalid JWT values")
+		inboundJWT.NotBefore > time.Now().Unix() {
+		return inboundJWT, errors.New("invalid JWT values")
+	}
+	if inboundJWT.Expiration < time.Now().Unix() {
+		return inboundJWT, errExpiredCookie
+	}
+	if len(inboundJWT.Username) < 1 {
+		return inboundJWT, errBadCookieState
+	}
+	return inboundJWT, nil
+}
+
+// validateUserCookieValue returns "" if no or bad username, returns non-nil error for fatal errors only
+func (s *Authenticator) validateUserCookieValue(remoteCookieValue string) (string, error) {
+	username, err := s.checkUserCookieValue(remoteCookieValue)
+	if err == errBadCookieState {
+		return "", err
+	}
+	if err != nil {
+		s.logger.Println(err)
 		return "", nil
 	}
-	username := inboundJWT.Username
-	if len(username) < 1 {
-		return "", errors.New("bad cookie Vauue state")
+	return username, nil
+}
+
+func (s *Authenticator) getVerifiedUserName(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName
 	}
-	return inboundJWT.Username, nil
+	remoteCookie, err := r.Cookie(AuthCookieName)
+	if err != nil {
+		return ""
+	}
+	username, err := s.validateUserCookieValue(remoteCookie.Value)
+	if err != nil {
+		return ""
+	}
+	return username
+}
+
+func (s *Authenticator) claimedUserName(r *http.Request) string {
+	remoteCookie, err := r.Cookie(AuthCookieName)
+	if err != nil {
+		return ""
+	}
+	tok, err := jwt.ParseSigned(remoteCookie.Value)
+	if err != nil {
+		return ""
+	}
+	var claims authNCookieJWT
+	if err := tok.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return ""
+	}
+	return claims.Username
+}
+
+func (s *Authenticator) getSession(r *http.Request) *Session {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return &Session{Username: r.TLS.VerifiedChains[0][0].Subject.CommonName,
+			Method: SessionMethodCertificate}
+	}
+	remoteCookie, err := r.Cookie(AuthCookieName)
+	if err != nil {
+		return nil
+	}
+	claims, err := s.checkUserCookieClaims(remoteCookie.Value)
+	if err != nil {
+		return nil
+	}
+	session := &Session{Username: claims.Username, Method: SessionMethodCookie,
+		IssuedAt: time.Unix(claims.IssuedAt, 0), ExpiresAt: time.Unix(claims.Expiration, 0), ACR: claims.ACR}
+	if claims.AuthTime > 0 {
+		session.AuthTime = time.Unix(claims.AuthTime, 0)
+	}
+	return session
+}
+
+// validCookieValue returns the value of the valid auth cookie of the
+// request, or "".
+func (s *Authenticator) validCookieValue(r *http.Request) string {
+	remoteCookie, err := r.Cookie(AuthCookieName)
+	if err != nil {
+		return ""
+	}
+	_, err = s.checkUserCookieClaims(remoteCookie.Value)
+	if err != nil {
+		return ""
+	}
+	return remoteCookie.Value
+}
+
+func csrfTokenOf(key string, cookieValue string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("csrf:" + cookieValue))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Authenticator) csrfToken(r *http.Request) string {
+	cookieValue := s.validCookieValue(r)
+	if cookieValue == "" {
+		return ""
+	}
+	return csrfTokenOf(s.secrets()[0], cookieValue)
+}
 
+func (s *Authenticator) checkCSRFToken(r *http.Request, token string) bool {
+	cookieValue := s.validCookieValue(r)
+	if cookieValue == "" || token == "" {
+		return false
+	}
+	for _, key := range s.secrets() {
+		if hmac.Equal([]byte(token), []byte(csrfTokenOf(key, cookieValue))) {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *Authenticator) getRemoteUserName(w http.ResponseWriter, r *http.Request) (string, error) {
@@ -359,26 +581,24 @@ func (s *Authenticator) getRemoteUserName(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	if s.setHeadersFunc != nil {
-		err := s.setHeadersFunc(w)
-		if err != nil {
-			return "", err
-		}
-	}
-
 	remoteCookie, err := r.Cookie(AuthCookieName)
 	if err != nil {
 		//s.logger.Debugf(1, "Err cookie %s", err)
 		s.oauth2DoRedirectoToProviderHandler(w, r)
 		return "", err
 	}
-	username, err := s.validateUserCookieValue(remoteCookie.Value)
-	if err != nil {
+	username, err := s.checkUserCookieValue(remoteCookie.Value)
+	if err == errBadCookieState {
 		http.Error(w, "bad transaction with openic context ", http.StatusInternalServerError)
 		return "", err
 	}
-	if username == "" {
-		log.Printf("invalid Cookie Value")
+	if err != nil {
+		log.Printf("invalid Cookie Value: %s", err)
+		if err == errExpiredCookie {
+			s.securityEvent(r, SecurityEventExpiredCookie, err)
+		} else {
+			s.securityEvent(r, SecurityEventInvalidCookie, err)
+		}
 		s.oauth2DoRedirectoToProviderHandler(w, r)
 		return "", errors.New("Invalid Cookie Value")
 
@@ -3,20 +3,24 @@ package authn
 import (
 	"errors"
 	"fmt"
-	//"log"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	//"os"
 	"testing"
 	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 func TestOauth2RedirectHandlerSucccess(t *testing.T) {
 	//slogger := stdlog.New(os.Stderr, "", stdlog.LstdFlags)
 	//logger := debuglogger.New(slogger)
 
-	authenticator := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil, nil)
+	authenticator := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil)
 
 	req, err := http.NewRequest("GET", "/", nil)
 	if err != nil {
@@ -71,7 +75,7 @@ func checkRequestHandlerCode(req *http.Request, handlerFunc http.HandlerFunc, ex
 }
 
 func TestValidateUserCookieValue(t *testing.T) {
-	a := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil, nil)
+	a := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil)
 	knownInvalidCookies := []string{
 		"",                  //too small
 		"supersecret",       //not JWT at all
@@ -86,7 +90,7 @@ func TestValidateUserCookieValue(t *testing.T) {
 			t.Fatal("should have not failed")
 		}
 	}
-	a2 := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil, nil)
+	a2 := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil)
 	expires := time.Now().Add(time.Hour * cookieExpirationHours)
 	a2ValidCookie, err := a2.GenUserCookieValue("username", expires)
 	if err != nil {
@@ -104,7 +108,7 @@ func TestValidateUserCookieValue(t *testing.T) {
 
 func TestGetRemoteUserNameHandler(t *testing.T) {
 
-	authenticator := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil, nil)
+	authenticator := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil)
 
 	// Test with no cookies... inmediate redirect
 	urlList := []string{"/", "/static/foo"}
@@ -183,3 +187,234 @@ func TestGetRemoteUserNameHandler(t *testing.T) {
 	}, http.StatusFound)
 
 }
+
+func TestSecurityEvents(t *testing.T) {
+	authenticator := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil)
+	var events []string
+	authenticator.SetSecurityEventFunc(func(r *http.Request, event string, reason error) {
+		if reason == nil {
+			t.Errorf("the %s event has no reason", event)
+		}
+		events = append(events, event)
+	})
+
+	// a missing cookie is not an event
+	req := httptest.NewRequest("GET", "/", nil)
+	authenticator.getRemoteUserName(httptest.NewRecorder(), req)
+	expired, err := authenticator.GenUserCookieValue("username", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: AuthCookieName, Value: expired})
+	authenticator.getRemoteUserName(httptest.NewRecorder(), req)
+	// the request logging validates the cookies without events
+	authenticator.GetVerifiedUserName(req)
+	other := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil)
+	forged, err := other.GenUserCookieValue("victim", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: AuthCookieName, Value: forged})
+	authenticator.getRemoteUserName(httptest.NewRecorder(), req)
+	if username := authenticator.ClaimedUserName(req); username != "victim" {
+		t.Fatalf("claimed user %q", username)
+	}
+
+	v := url.Values{"state": {"forged"}, "code": {"12345"}}
+	_, err = checkRequestHandlerCode(httptest.NewRequest("GET", Oauth2redirectPath+"?"+v.Encode(), nil),
+		authenticator.Oauth2RedirectPathHandler, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0] != SecurityEventExpiredCookie || events[1] != SecurityEventInvalidCookie ||
+		events[2] != SecurityEventInvalidState {
+		t.Fatalf("unexpected events %v", events)
+	}
+}
+
+func TestGetSession(t *testing.T) {
+	a := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{}, nil)
+	req := httptest.NewRequest("GET", "/", nil)
+	if session := a.GetSession(req); session != nil {
+		t.Fatalf("got a session without a cookie %+v", session)
+	}
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	cookieValue, err := a.GenUserCookieValue("username", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: AuthCookieName, Value: cookieValue})
+	session := a.GetSession(req)
+	if session == nil || session.Username != "username" || session.Method != SessionMethodCookie ||
+		!session.ExpiresAt.Equal(expires) || session.IssuedAt.IsZero() {
+		t.Fatalf("bad session %+v", session)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	a := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{"old-secret", "new-secret"}, nil)
+	req := httptest.NewRequest("POST", "/", nil)
+	if token := a.CSRFToken(req); token != "" || a.CheckCSRFToken(req, "") {
+		t.Fatalf("got a token without a cookie %q", token)
+	}
+	cookieValue, err := a.GenUserCookieValue("username", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: AuthCookieName, Value: cookieValue})
+	token := a.CSRFToken(req)
+	if token == "" || !a.CheckCSRFToken(req, token) || a.CheckCSRFToken(req, token+"x") {
+		t.Fatalf("bad token %q", token)
+	}
+	// the tokens of the former secret stay valid while it is shared
+	rotated := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{"new-secret", "old-secret"}, nil)
+	if !rotated.CheckCSRFToken(req, token) || rotated.CSRFToken(req) == token {
+		t.Fatal("the token of the former secret was rejected")
+	}
+	otherCookie, err := a.GenUserCookieValue("other", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := httptest.NewRequest("POST", "/", nil)
+	other.AddCookie(&http.Cookie{Name: AuthCookieName, Value: otherCookie})
+	if a.CheckCSRFToken(other, token) {
+		t.Fatal("the token of another session was accepted")
+	}
+}
+
+func TestSetSharedSecrets(t *testing.T) {
+	a := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{"old-secret"}, nil)
+	cookieValue, err := a.GenUserCookieValue("username", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetSharedSecrets(nil)
+	a.SetSharedSecrets([]string{"new-secret", "old-secret"})
+	if username, err := a.validateUserCookieValue(cookieValue); err != nil || username != "username" {
+		t.Fatalf("the cookie of the former secret was rejected %q, err %v", username, err)
+	}
+	a.SetSharedSecrets([]string{"new-secret"})
+	if username, _ := a.validateUserCookieValue(cookieValue); username != "" {
+		t.Fatal("the cookie of a dropped secret was accepted")
+	}
+	a.SetClientSecret("client-secret")
+	if a.clientSecret() != "client-secret" {
+		t.Fatal("the client secret was not replaced")
+	}
+}
+
+func TestReauthentication(t *testing.T) {
+	a := NewAuthenticator(OpenIDConfig{AuthURL: "https://provider/auth"}, "smallpoint", nil, []string{}, nil)
+	req := httptest.NewRequest("POST", "/delete_group/", nil)
+	reauthURL, err := a.ReauthenticationURL(req, "/delete_group", "mfa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedURL, err := url.Parse(reauthURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsedURL.Query()
+	if query.Get("prompt") != "login" || query.Get("max_age") != "0" || query.Get("acr_values") != "mfa" {
+		t.Fatalf("bad reauthentication URL %s", reauthURL)
+	}
+
+	// the ID token tells when the user authenticated
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte("provider-key")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authTime := time.Now().Add(-time.Minute).Unix()
+	idToken, err := jwt.Signed(signer).Claims(idTokenClaims{AuthTime: authTime, ACR: "mfa"}).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokenResponse string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, tokenResponse)
+	}))
+	defer ts.Close()
+	a.netClient = ts.Client()
+	a.openID.TokenURL = ts.URL
+	a.openID.UserinfoURL = ts.URL
+	login := func(state string) *Session {
+		v := url.Values{"state": {state}, "code": {"12345"}}
+		rr, err := checkRequestHandlerCode(httptest.NewRequest("GET", "/?"+v.Encode(), nil),
+			a.Oauth2RedirectPathHandler, http.StatusFound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		return a.GetSession(req)
+	}
+	tokenResponse = `{"access_token": "6789", "token_type": "Bearer", "username": "user", "id_token": "` +
+		idToken + `"}`
+	session := login(query.Get("state"))
+	if session == nil || session.AuthTime.Unix() != authTime || session.ACR != "mfa" {
+		t.Fatalf("bad session %+v", session)
+	}
+
+	// without auth_time only the reauthentications are known to be recent
+	tokenResponse = `{"access_token": "6789", "token_type": "Bearer", "username": "user"}`
+	if session := login(query.Get("state")); session == nil || time.Since(session.AuthTime) > time.Minute {
+		t.Fatalf("bad reauthenticated session %+v", session)
+	}
+	state, err := a.generateValidStateString(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session := login(state); session == nil || !session.AuthTime.IsZero() {
+		t.Fatalf("bad session %+v", session)
+	}
+}
+
+func FuzzValidateUserCookieValue(f *testing.F) {
+	logger := log.New(ioutil.Discard, "", 0)
+	a := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{"fuzz-secret"}, logger)
+	other := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{"other-secret"}, logger)
+	expires := time.Now().Add(time.Hour * cookieExpirationHours)
+	for _, authenticator := range []*Authenticator{a, other} {
+		cookie, err := authenticator.GenUserCookieValue("username", expires)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(cookie)
+		f.Add(cookie[:len(cookie)/2])
+	}
+	f.Add("")
+	f.Add("eee??.aaa$$.fff66")
+	f.Fuzz(func(t *testing.T, value string) {
+		username, err := a.validateUserCookieValue(value)
+		if err != nil && err != errBadCookieState {
+			t.Fatalf("unexpected error %s", err)
+		}
+		// only the cookies signed with the secret have a username
+		if username != "" && username != "username" {
+			t.Fatalf("forged cookie of %q", username)
+		}
+	})
+}
+
+func FuzzGetVerifyReturnStateJWT(f *testing.F) {
+	a := NewAuthenticator(OpenIDConfig{}, "smallpoint", nil, []string{"fuzz-secret"},
+		log.New(ioutil.Discard, "", 0))
+	state, err := a.generateStateString("/groups", false)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(state)
+	f.Add(state[:len(state)-1])
+	f.Add("")
+	f.Add("a.b.c")
+	f.Fuzz(func(t *testing.T, state string) {
+		req := httptest.NewRequest("GET", "/?"+url.Values{"state": {state}}.Encode(), nil)
+		claims, err := a.getVerifyReturnStateJWT(req)
+		if err == nil && claims.ReturnURL != "/groups" {
+			t.Fatalf("forged state returning to %q", claims.ReturnURL)
+		}
+	})
+}
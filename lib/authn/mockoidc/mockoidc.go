@@ -0,0 +1,371 @@
+// Package mockoidc is a small OpenID Connect provider of fake users for the
+// local development and the integration tests. Whoever picks a user on its
+// login page is signed in as that user, never use it in production.
+package mockoidc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// The paths of the endpoints, under the prefix given to RegisterHandlers.
+const (
+	AuthorizePath = "/authorize"
+	TokenPath     = "/token"
+	UserinfoPath  = "/userinfo"
+)
+
+const (
+	codeLifetime  = time.Minute
+	tokenLifetime = time.Hour
+	// loginPageUsers is the number of users offered on the login page.
+	loginPageUsers = 50
+)
+
+type User struct {
+	Username string
+	Name     string
+	Email    string
+}
+
+// Directory returns the users of the provider.
+type Directory interface {
+	// User returns the user named username, nil when there is none.
+	User(username string) (*User, error)
+	// Users returns at most limit users to pick from on the login page.
+	Users(limit int) ([]User, error)
+}
+
+// StaticDirectory is a fixed list of users.
+type StaticDirectory []User
+
+func (d StaticDirectory) User(username string) (*User, error) {
+	for _, user := range d {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d StaticDirectory) Users(limit int) ([]User, error) {
+	if len(d) > limit {
+		return d[:limit], nil
+	}
+	return d, nil
+}
+
+// grant is the login of a user, first under its code and then under its
+// access token.
+type grant struct {
+	user        User
+	clientID    string
+	redirectURI string
+	nonce       string
+	acr         string
+	authTime    time.Time
+	expires     time.Time
+}
+
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	directory    Directory
+
+	mutex  sync.Mutex
+	codes  map[string]grant
+	tokens map[string]grant
+}
+
+// New returns the provider of the single client clientID, the ID tokens
+// are signed with HS256 and the client secret.
+func New(issuer string, clientID string, clientSecret string, directory Directory) *Provider {
+	return &Provider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		directory:    directory,
+		codes:        make(map[string]grant),
+		tokens:       make(map[string]grant),
+	}
+}
+
+// RegisterHandlers serves the endpoints under prefix.
+func (p *Provider) RegisterHandlers(mux *http.ServeMux, prefix string) {
+	mux.HandleFunc(prefix+AuthorizePath, p.authorizeHandler)
+	mux.HandleFunc(prefix+TokenPath, p.tokenHandler)
+	mux.HandleFunc(prefix+UserinfoPath, p.userinfoHandler)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// store keeps the grant under a new random key, the expired grants are
+// dropped on the way.
+func (p *Provider) store(grants map[string]grant, g grant) (string, error) {
+	key, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	for k, existing := range grants {
+		if now.After(existing.expires) {
+			delete(grants, k)
+		}
+	}
+	grants[key] = g
+	return key, nil
+}
+
+// take returns the unexpired grant of the key, the codes are used once.
+func (p *Provider) take(grants map[string]grant, key string, remove bool) (grant, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	g, ok := grants[key]
+	if !ok {
+		return g, false
+	}
+	if remove {
+		delete(grants, key)
+	}
+	return g, time.Now().Before(g.expires)
+}
+
+type loginPageData struct {
+	Params url.Values
+	Users  []User
+	Error  string
+}
+
+var loginPageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Mock OpenID Connect Login</title></head>
+<body>
+<h3>Mock OpenID Connect Login</h3>
+<p>Pick the user to sign in as, there are no passwords.</p>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+<form method="POST">
+{{range $name, $values := .Params}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}
+<p><input name="username" placeholder="username" autofocus> <button type="submit">Sign in</button></p>
+<ul>
+{{range .Users}}<li><button name="username" value="{{.Username}}" type="submit">{{.Username}}</button> {{.Name}} {{.Email}}</li>
+{{end}}
+</ul>
+</form>
+</body>
+</html>
+`))
+
+// authorizeHandler shows the login page, the chosen user is sent back to
+// the client with a code. A login_hint naming a user skips the page.
+func (p *Provider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "GET or POST Method is required", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	params := url.Values{}
+	for _, name := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce",
+		"acr_values"} {
+		if value := r.Form.Get(name); value != "" {
+			params.Set(name, value)
+		}
+	}
+	redirectURI, err := url.Parse(params.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if params.Get("client_id") != p.clientID || params.Get("response_type") != "code" {
+		http.Error(w, "unknown client_id or unsupported response_type", http.StatusBadRequest)
+		return
+	}
+	username := r.PostForm.Get("username")
+	if username == "" {
+		username = r.Form.Get("login_hint")
+	}
+	var user *User
+	pageData := loginPageData{Params: params}
+	if username != "" {
+		user, err = p.directory.User(username)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			pageData.Error = "unknown user " + username
+		}
+	}
+	if user == nil {
+		pageData.Users, err = p.directory.Users(loginPageUsers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPageTemplate.Execute(w, pageData)
+		return
+	}
+	now := time.Now()
+	acr := strings.Fields(params.Get("acr_values"))
+	g := grant{user: *user, clientID: p.clientID, redirectURI: redirectURI.String(),
+		nonce: params.Get("nonce"), authTime: now, expires: now.Add(codeLifetime)}
+	if len(acr) > 0 {
+		g.acr = acr[0]
+	}
+	code, err := p.store(p.codes, g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	query := redirectURI.Query()
+	query.Set("code", code)
+	if state := params.Get("state"); state != "" {
+		query.Set("state", state)
+	}
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+}
+
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          []string `json:"aud"`
+	Expiration        int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	AuthTime          int64    `json:"auth_time"`
+	Nonce             string   `json:"nonce,omitempty"`
+	ACR               string   `json:"acr,omitempty"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email,omitempty"`
+}
+
+func (p *Provider) idToken(g grant) (string, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte(p.clientSecret)},
+		(&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := idTokenClaims{
+		Issuer:            p.issuer,
+		Subject:           g.user.Username,
+		Audience:          []string{g.clientID},
+		Expiration:        now.Add(tokenLifetime).Unix(),
+		IssuedAt:          now.Unix(),
+		AuthTime:          g.authTime.Unix(),
+		Nonce:             g.nonce,
+		ACR:               g.acr,
+		PreferredUsername: g.user.Username,
+		Email:             g.user.Email,
+	}
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// tokenHandler exchanges the codes for the access and ID tokens.
+func (p *Provider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID ||
+		subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.clientSecret)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	g, ok := p.take(p.codes, r.PostForm.Get("code"), true)
+	if !ok || g.clientID != clientID || g.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	idToken, err := p.idToken(g)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	g.expires = time.Now().Add(tokenLifetime)
+	accessToken, err := p.store(p.tokens, g)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{AccessToken: accessToken, TokenType: "Bearer",
+		ExpiresIn: int(tokenLifetime / time.Second), IDToken: idToken})
+}
+
+type userinfoResponse struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email,omitempty"`
+}
+
+// userinfoHandler returns the user of the access token, given as a bearer
+// token or as the access_token form value.
+func (p *Provider) userinfoHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if accessToken == "" {
+		accessToken = r.FormValue("access_token")
+	}
+	g, ok := p.take(p.tokens, accessToken, false)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	writeJSON(w, http.StatusOK, userinfoResponse{Subject: g.user.Username, Name: g.user.Name,
+		PreferredUsername: g.user.Username, Email: g.user.Email})
+}
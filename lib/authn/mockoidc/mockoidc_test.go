@@ -0,0 +1,107 @@
+package mockoidc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/authn"
+)
+
+func TestLoginFlow(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	provider := New(server.URL+"/oidc", "client", "client-secret",
+		StaticDirectory{{Username: "alice", Name: "Alice", Email: "alice@example.com"}, {Username: "bob"}})
+	provider.RegisterHandlers(mux, "/oidc")
+	authenticator := authn.NewAuthenticator(authn.OpenIDConfig{
+		ClientID:     "client",
+		ClientSecret: "client-secret",
+		AuthURL:      server.URL + "/oidc" + AuthorizePath,
+		TokenURL:     server.URL + "/oidc" + TokenPath,
+		UserinfoURL:  server.URL + "/oidc" + UserinfoPath,
+	}, "smallpoint", nil, []string{"secret"}, nil)
+	authenticator.SetInsecureTransport(true)
+	mux.HandleFunc(authn.Oauth2redirectPath, authenticator.Oauth2RedirectPathHandler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		username, err := authenticator.GetRemoteUserName(w, r)
+		if err != nil {
+			return
+		}
+		fmt.Fprint(w, username)
+	})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+	get := func(resp *http.Response, err error) (*http.Response, string) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	// the login page lists the users
+	resp, body := get(client.Get(server.URL + "/"))
+	if resp.Request.URL.Path != "/oidc"+AuthorizePath || !strings.Contains(body, `value="alice"`) {
+		t.Fatalf("unexpected login page %s %s", resp.Request.URL, body)
+	}
+	form := resp.Request.URL.Query()
+	form.Set("username", "nobody")
+	if _, body = get(client.PostForm(resp.Request.URL.String(), form)); !strings.Contains(body, "unknown user") {
+		t.Fatalf("the unknown user was not refused: %s", body)
+	}
+	form.Set("username", "alice")
+	if resp, body = get(client.PostForm(resp.Request.URL.String(), form)); body != "alice" {
+		t.Fatalf("the login failed with %d %s", resp.StatusCode, body)
+	}
+	var authCookie *http.Cookie
+	for _, cookie := range jar.Cookies(resp.Request.URL) {
+		if cookie.Name == authn.AuthCookieName {
+			authCookie = cookie
+		}
+	}
+	if authCookie == nil {
+		t.Fatal("no auth cookie")
+	}
+
+	// a login hint skips the page
+	form.Del("username")
+	form.Set("login_hint", "bob")
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, _ = get(noRedirect.Get(server.URL + "/oidc" + AuthorizePath + "?" + form.Encode()))
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound || location.Query().Get("code") == "" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	exchange := url.Values{"grant_type": {"authorization_code"}, "code": {location.Query().Get("code")},
+		"redirect_uri": {form.Get("redirect_uri")}, "client_id": {"client"}, "client_secret": {"wrong"}}
+	if resp, _ = get(http.PostForm(server.URL+"/oidc"+TokenPath, exchange)); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("the wrong client secret got %d", resp.StatusCode)
+	}
+	exchange.Set("client_secret", "client-secret")
+	if resp, body = get(http.PostForm(server.URL+"/oidc"+TokenPath, exchange)); resp.StatusCode != http.StatusOK ||
+		!strings.Contains(body, `"id_token"`) {
+		t.Fatalf("the exchange failed with %d %s", resp.StatusCode, body)
+	}
+	if resp, _ = get(http.PostForm(server.URL+"/oidc"+TokenPath, exchange)); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("the code was used twice, got %d", resp.StatusCode)
+	}
+	if resp, _ = get(http.PostForm(server.URL+"/oidc"+UserinfoPath, url.Values{"access_token": {"x"}})); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("the invalid access token got %d", resp.StatusCode)
+	}
+}
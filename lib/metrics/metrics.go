@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"database/sql"
 	"github.com/prometheus/client_golang/prometheus"
 	"sync"
 	"time"
@@ -16,10 +17,131 @@ var (
 		},
 		[]string{"service_name"},
 	)
+	ldapOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "smallpoint_ldap_operation_duration",
+			Help:    "Time spent in LDAP operations in ms",
+			Buckets: []float64{1, 2.5, 5, 7.5, 10, 15, 25, 50, 75, 100, 150, 250, 500, 750, 1000, 2500, 5000, 10000},
+		},
+		[]string{"operation", "outcome"},
+	)
+	dbQueryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "smallpoint_db_query_duration",
+			Help:    "Time spent in DB queries in ms",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 7.5, 10, 15, 25, 50, 75, 100, 250, 500, 1000, 2500},
+		},
+		[]string{"query", "outcome"},
+	)
+	securityEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "smallpoint_security_events_total",
+			Help: "Number of security events by event type",
+		},
+		[]string{"event"},
+	)
+	authFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "smallpoint_auth_failures_total",
+			Help: "Number of failed authentications by reason",
+		},
+		[]string{"reason"},
+	)
+	authLockouts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "smallpoint_auth_lockouts",
+			Help: "Number of IPs and users locked out after failed authentications by key type",
+		},
+		[]string{"key_type"},
+	)
+	groupPushFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "smallpoint_group_push_failures_total",
+			Help: "Number of failed pushes of group memberships to other systems by integration",
+		},
+		[]string{"integration"},
+	)
+	dbUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "smallpoint_db_up",
+			Help: "Whether the last ping of the app database succeeded",
+		},
+	)
+	dbStatsFunc     func() sql.DBStats
+	schedulerLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "smallpoint_scheduler_leader",
+			Help: "Whether the instance holds the scheduler lease of the leader election",
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(externalServiceDurationTotal)
+	prometheus.MustRegister(ldapOperationDuration)
+	prometheus.MustRegister(dbQueryDuration)
+	prometheus.MustRegister(securityEventsTotal)
+	prometheus.MustRegister(authFailuresTotal)
+	prometheus.MustRegister(authLockouts)
+	prometheus.MustRegister(groupPushFailuresTotal)
+	prometheus.MustRegister(dbUp)
+	prometheus.MustRegister(schedulerLeader)
+	gauges := map[string]func(sql.DBStats) float64{
+		"smallpoint_db_max_open_connections": func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) },
+		"smallpoint_db_open_connections":     func(s sql.DBStats) float64 { return float64(s.OpenConnections) },
+		"smallpoint_db_in_use_connections":   func(s sql.DBStats) float64 { return float64(s.InUse) },
+		"smallpoint_db_idle_connections":     func(s sql.DBStats) float64 { return float64(s.Idle) },
+	}
+	for name, value := range gauges {
+		prometheus.MustRegister(prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{Name: name, Help: "Connection pool statistic of the app database"},
+			dbStat(value)))
+	}
+	counters := map[string]func(sql.DBStats) float64{
+		"smallpoint_db_wait_count_total":           func(s sql.DBStats) float64 { return float64(s.WaitCount) },
+		"smallpoint_db_wait_duration_total":        func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() * 1000 },
+		"smallpoint_db_max_idle_closed_total":      func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) },
+		"smallpoint_db_max_idle_time_closed_total": func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) },
+		"smallpoint_db_max_lifetime_closed_total":  func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) },
+	}
+	for name, value := range counters {
+		prometheus.MustRegister(prometheus.NewCounterFunc(
+			prometheus.CounterOpts{Name: name, Help: "Connection pool statistic of the app database, durations in ms"},
+			dbStat(value)))
+	}
+}
+
+// dbStat reads a statistic of the connection pool, 0 until the pool is set.
+func dbStat(value func(sql.DBStats) float64) func() float64 {
+	return func() float64 {
+		metricsMutex.Lock()
+		stats := dbStatsFunc
+		metricsMutex.Unlock()
+		if stats == nil {
+			return 0
+		}
+		return value(stats())
+	}
+}
+
+// SetDBStatsFunc sets the source of the connection pool statistics.
+func SetDBStatsFunc(stats func() sql.DBStats) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	dbStatsFunc = stats
+}
+
+// MetricLogDBPing records a ping of the app database.
+func MetricLogDBPing(duration time.Duration, err error) {
+	val := duration.Seconds() * 1000
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	dbQueryDuration.WithLabelValues("ping", outcome(err)).Observe(val)
+	if err != nil {
+		dbUp.Set(0)
+		return
+	}
+	dbUp.Set(1)
 }
 
 func MetricLogExternalServiceDuration(service string, duration time.Duration) {
@@ -28,3 +150,69 @@ func MetricLogExternalServiceDuration(service string, duration time.Duration) {
 	defer metricsMutex.Unlock()
 	externalServiceDurationTotal.WithLabelValues(service).Observe(val)
 }
+
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// MetricLogLDAPOperationDuration records an LDAP operation: search, add,
+// modify, delete, bind or password_modify.
+func MetricLogLDAPOperationDuration(operation string, duration time.Duration, err error) {
+	val := duration.Seconds() * 1000
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	ldapOperationDuration.WithLabelValues(operation, outcome(err)).Observe(val)
+}
+
+// MetricLogDBQueryDuration records a DB query, query names the statement and
+// its table, e.g. "select pending_requests".
+func MetricLogDBQueryDuration(query string, duration time.Duration, err error) {
+	val := duration.Seconds() * 1000
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	dbQueryDuration.WithLabelValues(query, outcome(err)).Observe(val)
+}
+
+func MetricLogSecurityEvent(event string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	securityEventsTotal.WithLabelValues(event).Inc()
+}
+
+// MetricLogAuthFailure counts a failed authentication.
+func MetricLogAuthFailure(reason string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	authFailuresTotal.WithLabelValues(reason).Inc()
+}
+
+// MetricSetAuthLockouts records the number of locked out keys of a type, ip
+// or user.
+func MetricSetAuthLockouts(keyType string, count int) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	authLockouts.WithLabelValues(keyType).Set(float64(count))
+}
+
+// MetricLogGroupPushFailure counts a failed push of the members of a group
+// to another system.
+func MetricLogGroupPushFailure(integration string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	groupPushFailuresTotal.WithLabelValues(integration).Inc()
+}
+
+// MetricSetSchedulerLeader records whether the instance holds the scheduler
+// lease.
+func MetricSetSchedulerLeader(leader bool) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if leader {
+		schedulerLeader.Set(1)
+		return
+	}
+	schedulerLeader.Set(0)
+}
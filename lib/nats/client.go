@@ -0,0 +1,240 @@
+// Package nats is a small client of the NATS protocol that publishes the
+// smallpoint events. It only publishes, a subscriber needs a full client.
+package nats
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	clientName     = "smallpoint"
+)
+
+// Error is an -ERR message of the server.
+type Error string
+
+func (e Error) Error() string {
+	return "nats: " + string(e)
+}
+
+type Config struct {
+	// Address of the server, host:port.
+	Address  string `yaml:"address"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+	TLS      bool   `yaml:"tls"`
+	// Timeout of the connection and of each publish, 5s by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (config Config) timeout() time.Duration {
+	if config.Timeout > 0 {
+		return config.Timeout
+	}
+	return defaultTimeout
+}
+
+// A Message is published on Subject.
+type Message struct {
+	Subject string
+	Data    []byte
+}
+
+// A Client publishes on a single connection, opened on the first publish
+// and again after an error. It is safe for concurrent use.
+type Client struct {
+	config Config
+
+	mutex   sync.Mutex
+	netConn net.Conn
+	reader  *bufio.Reader
+}
+
+func New(config Config) *Client {
+	return &Client{config: config}
+}
+
+type serverInfo struct {
+	TLSRequired  bool  `json:"tls_required"`
+	AuthRequired bool  `json:"auth_required"`
+	MaxPayload   int64 `json:"max_payload"`
+}
+
+type connectOptions struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	Protocol  int    `json:"protocol"`
+	User      string `json:"user,omitempty"`
+	Password  string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// connect is called with the mutex held.
+func (c *Client) connect() error {
+	dialer := &net.Dialer{Timeout: c.config.timeout()}
+	netConn, err := dialer.Dial("tcp", c.config.Address)
+	if err != nil {
+		return err
+	}
+	err = netConn.SetDeadline(time.Now().Add(c.config.timeout()))
+	if err != nil {
+		netConn.Close()
+		return err
+	}
+	reader := bufio.NewReader(netConn)
+	line, err := readLine(reader)
+	if err != nil {
+		netConn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		netConn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q", line)
+	}
+	var info serverInfo
+	err = json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+	if err != nil {
+		netConn.Close()
+		return err
+	}
+	if info.TLSRequired && !c.config.TLS {
+		netConn.Close()
+		return errors.New("nats: the server requires TLS")
+	}
+	if c.config.TLS {
+		host, _, _ := net.SplitHostPort(c.config.Address)
+		tlsConn := tls.Client(netConn, &tls.Config{ServerName: host})
+		err = tlsConn.Handshake()
+		if err != nil {
+			netConn.Close()
+			return err
+		}
+		netConn = tlsConn
+		reader = bufio.NewReader(netConn)
+	}
+	options, err := json.Marshal(connectOptions{
+		Name:      clientName,
+		Lang:      "go",
+		Version:   "1.0",
+		Protocol:  1,
+		User:      c.config.User,
+		Password:  c.config.Password,
+		AuthToken: c.config.Token,
+	})
+	if err != nil {
+		netConn.Close()
+		return err
+	}
+	c.netConn = netConn
+	c.reader = reader
+	_, err = io.WriteString(netConn, "CONNECT "+string(options)+"\r\n")
+	if err == nil {
+		err = c.flush()
+	}
+	if err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// flush sends a PING and waits for its PONG, the server has then processed
+// the earlier messages. It is called with the mutex held.
+func (c *Client) flush() error {
+	_, err := io.WriteString(c.netConn, "PING\r\n")
+	if err != nil {
+		return err
+	}
+	for {
+		line, err := readLine(c.reader)
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			_, err = io.WriteString(c.netConn, "PONG\r\n")
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return Error(strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		case line == "+OK" || strings.HasPrefix(line, "INFO "):
+		default:
+			return fmt.Errorf("nats: unexpected message %q", line)
+		}
+	}
+}
+
+// close is called with the mutex held.
+func (c *Client) close() {
+	if c.netConn != nil {
+		c.netConn.Close()
+		c.netConn = nil
+		c.reader = nil
+	}
+}
+
+// Publish sends the messages and returns once the server has received
+// them, the connection is closed on errors.
+func (c *Client) Publish(messages ...Message) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.netConn == nil {
+		err := c.connect()
+		if err != nil {
+			return err
+		}
+	}
+	err := c.netConn.SetDeadline(time.Now().Add(c.config.timeout()))
+	if err == nil {
+		writer := bufio.NewWriter(c.netConn)
+		for _, message := range messages {
+			if message.Subject == "" || strings.ContainsAny(message.Subject, " \t\r\n") {
+				c.close()
+				return fmt.Errorf("nats: invalid subject %q", message.Subject)
+			}
+			fmt.Fprintf(writer, "PUB %s %d\r\n", message.Subject, len(message.Data))
+			writer.Write(message.Data)
+			writer.WriteString("\r\n")
+		}
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = c.flush()
+	}
+	if err != nil {
+		c.close()
+	}
+	return err
+}
+
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.close()
+	return nil
+}
@@ -0,0 +1,43 @@
+package nats
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/nats/natstest"
+)
+
+func TestClient(t *testing.T) {
+	server, err := natstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.Token = "secret"
+
+	client := New(Config{Address: server.Addr, Token: "wrong"})
+	if _, ok := client.Publish(Message{Subject: "a", Data: []byte("x")}).(Error); !ok {
+		t.Fatal("the wrong token was accepted")
+	}
+
+	client = New(Config{Address: server.Addr, Token: "secret"})
+	defer client.Close()
+	err = client.Publish(Message{Subject: "events.add", Data: []byte("multi\r\nline")},
+		Message{Subject: "events.remove", Data: nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Publish(Message{Subject: "with space"}); err == nil {
+		t.Fatal("invalid subject was published")
+	}
+	// the connection is opened again after the error
+	err = client.Publish(Message{Subject: "events.add", Data: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []natstest.Message{{Subject: "events.add", Data: "multi\r\nline"},
+		{Subject: "events.remove", Data: ""}, {Subject: "events.add", Data: "{}"}}
+	if messages := server.Messages(); !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("messages %q", messages)
+	}
+}
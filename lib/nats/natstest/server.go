@@ -0,0 +1,121 @@
+// Package natstest runs an in-memory NATS server that records the published
+// messages, for the tests.
+package natstest
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Message struct {
+	Subject string
+	Data    string
+}
+
+type Server struct {
+	Addr     string
+	listener net.Listener
+	// Token is required from the clients when set.
+	Token string
+
+	mutex    sync.Mutex
+	messages []Message
+}
+
+// NewServer starts a server on a local port.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: listener.Addr().String(), listener: listener}
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// Messages returns the published messages in order.
+func (s *Server) Messages() []Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	_, err := io.WriteString(conn, `INFO {"server_id":"natstest","max_payload":1048576,"auth_required":`+
+		strconv.FormatBool(s.Token != "")+"}\r\n")
+	if err != nil {
+		return
+	}
+	reader := bufio.NewReader(conn)
+	connected := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		reply := ""
+		switch strings.ToUpper(fields[0]) {
+		case "CONNECT":
+			var options struct {
+				AuthToken string `json:"auth_token"`
+			}
+			json.Unmarshal([]byte(strings.TrimSpace(line[len("CONNECT"):])), &options)
+			if options.AuthToken != s.Token {
+				io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+			connected = true
+		case "PING":
+			reply = "PONG\r\n"
+		case "PUB":
+			if !connected || len(fields) != 3 {
+				io.WriteString(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+				return
+			}
+			length, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return
+			}
+			data := make([]byte, length+2)
+			_, err = io.ReadFull(reader, data)
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.messages = append(s.messages, Message{Subject: fields[1], Data: string(data[:length])})
+			s.mutex.Unlock()
+		default:
+			reply = "-ERR 'Unknown Protocol Operation'\r\n"
+		}
+		if reply != "" {
+			_, err = io.WriteString(conn, reply)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Package redis is a small client of the Redis protocol, for the state that
+// smallpoint instances share: the cached group listings and the session
+// signing secret.
+package redis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 2 * time.Second
+	maxIdleConns   = 8
+)
+
+// ErrNil is returned for the missing keys.
+var ErrNil = errors.New("redis: nil reply")
+
+// Error is an error reply of the server.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+type Config struct {
+	// Address of the server, host:port.
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	TLS      bool   `yaml:"tls"`
+	// Timeout of the connections and of each command, 2s by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (config Config) timeout() time.Duration {
+	if config.Timeout > 0 {
+		return config.Timeout
+	}
+	return defaultTimeout
+}
+
+// A Client keeps a pool of connections, it is safe for concurrent use.
+type Client struct {
+	config Config
+
+	mutex sync.Mutex
+	idle  []*conn
+}
+
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+}
+
+func New(config Config) *Client {
+	return &Client{config: config}
+}
+
+func (c *Client) dial() (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.config.timeout()}
+	var netConn net.Conn
+	var err error
+	if c.config.TLS {
+		host, _, _ := net.SplitHostPort(c.config.Address)
+		netConn, err = tls.DialWithDialer(dialer, "tcp", c.config.Address, &tls.Config{ServerName: host})
+	} else {
+		netConn, err = dialer.Dial("tcp", c.config.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{netConn: netConn, reader: bufio.NewReader(netConn)}
+	if c.config.Password != "" {
+		_, err = c.roundTrip(cn, "AUTH", c.config.Password)
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	if c.config.DB != 0 {
+		_, err = c.roundTrip(cn, "SELECT", strconv.Itoa(c.config.DB))
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// get returns an idle connection or a new one, pooled tells which.
+func (c *Client) get() (cn *conn, pooled bool, err error) {
+	c.mutex.Lock()
+	if len(c.idle) > 0 {
+		cn := c.idle[len(c.idle)-1]
+		c.idle = c.idle[:len(c.idle)-1]
+		c.mutex.Unlock()
+		return cn, true, nil
+	}
+	c.mutex.Unlock()
+	cn, err = c.dial()
+	return cn, false, err
+}
+
+func (c *Client) put(cn *conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.idle) >= maxIdleConns {
+		cn.netConn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+func (c *Client) roundTrip(cn *conn, args ...string) (interface{}, error) {
+	err := cn.netConn.SetDeadline(time.Now().Add(c.config.timeout()))
+	if err != nil {
+		return nil, err
+	}
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err = io.WriteString(cn.netConn, command.String())
+	if err != nil {
+		return nil, err
+	}
+	return readReply(cn.reader)
+}
+
+// Do sends a command and returns its reply: a string, an int64, a
+// []interface{} of replies or nil. The error replies are returned as Error.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	cn, pooled, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.roundTrip(cn, args...)
+	if _, ok := err.(Error); err != nil && !ok {
+		// the connection is in an unknown state
+		cn.netConn.Close()
+		if !pooled {
+			return nil, err
+		}
+		// the server may have closed the idle connection
+		cn, err = c.dial()
+		if err != nil {
+			return nil, err
+		}
+		reply, err = c.roundTrip(cn, args...)
+		if _, ok := err.(Error); err != nil && !ok {
+			cn.netConn.Close()
+			return nil, err
+		}
+	}
+	c.put(cn)
+	return reply, err
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("redis: malformed reply")
+	}
+	return line[:len(line)-2], nil
+}
+
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		data := make([]byte, length+2)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+		return string(data[:length]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, count)
+		for i := range replies {
+			replies[i], err = readReply(reader)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", line[0])
+}
+
+// Get returns ErrNil when the key is missing.
+func (c *Client) Get(key string) (string, error) {
+	reply, err := c.Do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	if reply == nil {
+		return "", ErrNil
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	return value, nil
+}
+
+// Set sets the value of key, expiring after ttl unless ttl is 0.
+func (c *Client) Set(key string, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := c.Do(args...)
+	return err
+}
+
+// SetNX sets the value of key unless it exists and returns whether it was
+// set.
+func (c *Client) SetNX(key string, value string) (bool, error) {
+	reply, err := c.Do("SET", key, value, "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+func (c *Client) Incr(key string) (int64, error) {
+	reply, err := c.Do("INCR", key)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	return value, nil
+}
+
+func (c *Client) Ping() error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// Close closes the idle connections.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, cn := range c.idle {
+		cn.netConn.Close()
+	}
+	c.idle = nil
+	return nil
+}
@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/redis/redistest"
+)
+
+func TestClient(t *testing.T) {
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := New(Config{Address: server.Addr, Password: "password", DB: 1})
+	defer client.Close()
+	err = client.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get("missing"); err != ErrNil {
+		t.Fatalf("unexpected error %v for a missing key", err)
+	}
+	err = client.Set("key", "multi\r\nline", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := client.Get("key")
+	if err != nil || value != "multi\r\nline" {
+		t.Fatalf("unexpected value %q err: %v", value, err)
+	}
+	set, err := client.SetNX("key", "other")
+	if err != nil || set {
+		t.Fatalf("the existing key was set %v err: %v", set, err)
+	}
+	for i := int64(1); i <= 2; i++ {
+		counter, err := client.Incr("counter")
+		if err != nil || counter != i {
+			t.Fatalf("unexpected counter %d err: %v", counter, err)
+		}
+	}
+	if _, err := client.Incr("key"); err == nil {
+		t.Fatal("no error reply")
+	} else if _, ok := err.(Error); !ok {
+		t.Fatalf("unexpected error %T %v", err, err)
+	}
+	err = client.Set("expiring", "value", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := client.Get("expiring"); err != ErrNil {
+		t.Fatalf("the key did not expire, err: %v", err)
+	}
+
+	// the closed idle connections are replaced
+	client.mutex.Lock()
+	for _, cn := range client.idle {
+		cn.netConn.Close()
+	}
+	client.mutex.Unlock()
+	err = client.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,188 @@
+// Package redistest runs an in-memory server of the few Redis commands used
+// by smallpoint, for the tests.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	value     string
+	expiresAt time.Time
+}
+
+type Server struct {
+	Addr     string
+	listener net.Listener
+
+	mutex  sync.Mutex
+	values map[string]entry
+}
+
+// NewServer starts a server on a local port.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: listener.Addr().String(), listener: listener, values: make(map[string]entry)}
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// Keys returns the keys that have not expired.
+func (s *Server) Keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var keys []string
+	for key := range s.values {
+		if _, ok := s.lookup(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	return strings.TrimSuffix(line, "\r\n"), err
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimPrefix(line, "*"))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		line, err = readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, length+2)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:length])
+	}
+	return args, nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil || len(args) == 0 {
+			return
+		}
+		_, err = conn.Write([]byte(s.execute(args)))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// lookup is called with the mutex held.
+func (s *Server) lookup(key string) (entry, bool) {
+	e, ok := s.values[key]
+	if ok && !e.expiresAt.IsZero() && !time.Now().Before(e.expiresAt) {
+		delete(s.values, key)
+		return e, false
+	}
+	return e, ok
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func (s *Server) execute(args []string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		e, ok := s.lookup(args[1])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(e.value)
+	case "SET":
+		e := entry{value: args[2]}
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				if _, ok := s.lookup(args[1]); ok {
+					return "$-1\r\n"
+				}
+			case "PX":
+				i++
+				ms, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil {
+					return "-ERR value is not an integer\r\n"
+				}
+				e.expiresAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+		}
+		s.values[args[1]] = e
+		return "+OK\r\n"
+	case "INCR":
+		e, _ := s.lookup(args[1])
+		value := int64(0)
+		if e.value != "" {
+			var err error
+			value, err = strconv.ParseInt(e.value, 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer\r\n"
+			}
+		}
+		value++
+		e.value = strconv.FormatInt(value, 10)
+		s.values[args[1]] = e
+		return fmt.Sprintf(":%d\r\n", value)
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := s.lookup(key); ok {
+				delete(s.values, key)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
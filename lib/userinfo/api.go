@@ -9,6 +9,10 @@ var UserDoesNotExist = errors.New("User does not exist")
 var UserDoesNotHaveEmail = errors.New("User does not have mail")
 var UserDoesNotHaveGivenName = errors.New("User does not have givenName")
 
+// DirectoryUnavailable is returned without contacting the directory while it
+// is considered down after repeated failures.
+var DirectoryUnavailable = errors.New("Directory is unavailable")
+
 type AccountType int
 
 type GroupInfo struct {
@@ -19,58 +23,155 @@ type GroupInfo struct {
 	Cn          string
 	Mail        string
 	LoginShell  string
+	// GidNumber is used by CreateGroup when set, otherwise the next
+	// gidNumber is allocated.
+	GidNumber string
+}
+
+// UserSearchResult is a user found by SearchUsers.
+type UserSearchResult struct {
+	Username    string
+	DisplayName string
+	Email       string
 }
 
-type UserInfo interface {
+// UserInfoBackend is the directory of the users, groups and service
+// accounts, every directory operation of smallpoint goes through it. The
+// LDAP directory (ldapuserinfo) and the in-memory one of the tests (mock)
+// implement it, the contract every implementation follows is checked by
+// the userinfotest package.
+//
+// A group is managed by the members of its managing group, or by its own
+// members when it is self-managed. The operations on a missing group return
+// GroupDoesNotExist unless stated otherwise.
+type UserInfoBackend interface {
+	// GetallUsers returns the usernames of all the users.
 	GetallUsers() ([]string, error)
 
+	// SearchUsers returns at most limit users whose uid, name or mail
+	// start with prefix, ignoring case, sorted by uid.
+	SearchUsers(prefix string, limit int) ([]UserSearchResult, error)
+
+	// CreateGroup creates the group of the members MemberUid, managed by
+	// the group named by Description. It fails when the group exists.
 	CreateGroup(groupinfo GroupInfo) error
 
+	// GetUsedGidNumbers returns the gidNumbers in [min, max] used by groups
+	// or service accounts.
+	GetUsedGidNumbers(min int, max int) (map[int]bool, error)
+
+	// DeleteGroup removes the groups, the users are no longer their
+	// members.
 	DeleteGroup(groupnames []string) error
 
+	// ChangeDescription makes managegroup the managing group of the group.
 	ChangeDescription(groupname string, managegroup string) error
 
+	// SetGroupMail replaces the mail addresses of the group, an empty list
+	// removes them.
+	SetGroupMail(groupname string, addresses []string) error
+
+	// GetMailOwners returns the users, groups and service accounts using
+	// the mail address.
+	GetMailOwners(address string) ([]string, error)
+
+	// RenameGroup renames the group and updates the groups it manages.
+	RenameGroup(groupname string, newname string) error
+
+	// GetallGroups returns the names of all the groups.
 	GetallGroups() ([]string, error)
 
+	// GetgroupsofUser returns the groups the user is a member of, none for
+	// an unknown user.
 	GetgroupsofUser(username string) ([]string, error)
 
+	// GetusersofaGroup returns the members of the group and its managing
+	// group.
 	GetusersofaGroup(groupname string) ([]string, string, error)
 
+	// GetGroupUsersAndManagers returns the members of the group, the
+	// members of its managing group and the managing group.
 	GetGroupUsersAndManagers(groupname string) ([]string, []string, string, error)
 
+	// ParseSuperadmins returns the super admins, who manage every group.
 	ParseSuperadmins() []string
 
 	UserisadminOrNot(username string) bool
 
+	// AddmemberstoExisting adds the users of MemberUid to the group.
 	AddmemberstoExisting(groupinfo GroupInfo) error
 
+	// DeletemembersfromGroup removes the users of MemberUid from the group.
 	DeletemembersfromGroup(groupinfo GroupInfo) error
 
+	// IsgroupmemberorNot returns whether the user is a member of the group
+	// and the managing group of the group.
 	IsgroupmemberorNot(groupname string, username string) (bool, string, error)
 
+	// GetDescriptionvalue returns the managing group of the group.
 	GetDescriptionvalue(groupname string) (string, error)
 
+	// GetEmailofauser returns the mail addresses of the user,
+	// UserDoesNotExist for an unknown user.
 	GetEmailofauser(username string) ([]string, error)
 
+	// GetEmailofusersingroup returns the mail addresses of the members of
+	// the group.
 	GetEmailofusersingroup(groupname string) ([]string, error)
 
+	// CreateServiceAccount creates the service account named Groupname, a
+	// user and a group of the same name, with Mail and LoginShell.
 	CreateServiceAccount(groupinfo GroupInfo) error
 
+	// IsgroupAdminorNot returns whether the user manages the group.
 	IsgroupAdminorNot(username string, groupname string) (bool, error)
 
+	// UsernameExistsornot returns whether the user or service account
+	// exists.
 	UsernameExistsornot(username string) (bool, error)
 
+	// GroupnameExistsornot returns whether the group or the group of the
+	// service account exists, and its managing group.
 	GroupnameExistsornot(groupname string) (bool, string, error)
 
+	// ServiceAccountExistsornot returns whether the service account
+	// exists.
 	ServiceAccountExistsornot(groupname string) (bool, string, error)
 
+	// DisableServiceAccount locks the service account, its entry is kept.
+	DisableServiceAccount(accountname string) error
+
+	// DeleteServiceAccount removes the service account and its group.
+	DeleteServiceAccount(accountname string) error
+
+	// SetServiceAccountPassword replaces the password of the service account.
+	SetServiceAccountPassword(accountname string, password string) error
+
+	// GetAllGroupsManagedBy returns the name and managing group pairs of
+	// all the groups.
 	GetAllGroupsManagedBy() ([][]string, error)
 
+	// GetGroupsInfoOfUser returns the name and managing group pairs of the
+	// groups of the user under the groupdn base DN.
 	GetGroupsInfoOfUser(groupdn string, username string) ([][]string, error)
 
+	// GetGroupandManagedbyAttributeValue returns the name and managing
+	// group pairs of the groups.
 	GetGroupandManagedbyAttributeValue(groupnames []string) ([][]string, error)
 
+	// CreateUser creates the user with the given names and mail addresses.
 	CreateUser(username string, givenName, email []string) error
 
+	// GetUserAttributes returns the mail addresses and given names of the
+	// user.
 	GetUserAttributes(username string) ([]string, []string, error)
+
+	// GetSubgroupsofGroup returns the groups that are members of groupname.
+	GetSubgroupsofGroup(groupname string) ([]string, error)
+
+	// GetParentgroupsofGroup returns the groups where groupname is a member.
+	GetParentgroupsofGroup(groupname string) ([]string, error)
+
+	// Ping checks that the directory is reachable.
+	Ping() error
 }
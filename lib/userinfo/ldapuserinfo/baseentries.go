@@ -0,0 +1,103 @@
+package ldapuserinfo
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+// The base DNs of the users, groups and service accounts are
+// organizationalUnit entries, a new directory gets them from the bootstrap
+// of smallpoint.
+
+// splitDN returns the RDNs of the DN, the escaped commas are kept.
+func splitDN(dn string) []string {
+	var rdns []string
+	start := 0
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			rdns = append(rdns, strings.TrimSpace(dn[start:i]))
+			start = i + 1
+		}
+	}
+	return append(rdns, strings.TrimSpace(dn[start:]))
+}
+
+func entryExists(conn *ldap.Conn, dn string) (bool, error) {
+	searchRequest := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{"dn"}, nil)
+	_, err := ldapSearch(conn, searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MissingBaseDNs returns the entries missing from the directory for the
+// user, group and service account base DNs, including their missing
+// parents, the parents first.
+func (u *UserInfoLDAPSource) MissingBaseDNs() ([]string, error) {
+	conn, err := u.getTargetLDAPConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var missing []string
+	isMissing := make(map[string]bool)
+	for _, baseDN := range []string{u.UserSearchBaseDNs, u.GroupSearchBaseDNs, u.ServiceAccountBaseDNs} {
+		if baseDN == "" {
+			continue
+		}
+		rdns := splitDN(baseDN)
+		// the missing ancestors are found from the base DN up
+		var chain []string
+		for i := range rdns {
+			dn := strings.Join(rdns[i:], ",")
+			if isMissing[dn] {
+				break
+			}
+			exists, err := entryExists(conn, dn)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				break
+			}
+			chain = append(chain, dn)
+		}
+		for i := len(chain) - 1; i >= 0; i-- {
+			isMissing[chain[i]] = true
+			missing = append(missing, chain[i])
+		}
+	}
+	return missing, nil
+}
+
+// CreateOrganizationalUnit adds the organizationalUnit entry of the DN, its
+// parent must exist.
+func (u *UserInfoLDAPSource) CreateOrganizationalUnit(dn string) error {
+	rdn, err := ldap.ParseDN(splitDN(dn)[0])
+	if err != nil {
+		return err
+	}
+	if len(rdn.RDNs) != 1 || len(rdn.RDNs[0].Attributes) != 1 ||
+		!strings.EqualFold(rdn.RDNs[0].Attributes[0].Type, "ou") {
+		return fmt.Errorf("%s is not an organizationalUnit DN", dn)
+	}
+	conn, err := u.getTargetLDAPWriteConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	request := ldap.NewAddRequest(dn)
+	request.Attribute("objectClass", []string{"organizationalUnit", "top"})
+	request.Attribute("ou", []string{rdn.RDNs[0].Attributes[0].Value})
+	return ldapAdd(conn, request)
+}
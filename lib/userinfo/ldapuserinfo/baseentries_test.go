@@ -0,0 +1,18 @@
+package ldapuserinfo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitDN(t *testing.T) {
+	rdns := splitDN(`ou=Smith\, Jones,ou=groups, dc=example,dc=com`)
+	expected := []string{`ou=Smith\, Jones`, "ou=groups", "dc=example", "dc=com"}
+	if !reflect.DeepEqual(rdns, expected) {
+		t.Fatalf("got %q", rdns)
+	}
+	u := &UserInfoLDAPSource{}
+	if err := u.CreateOrganizationalUnit("cn=groups,dc=example,dc=com"); err == nil {
+		t.Fatal("a cn entry should not be created")
+	}
+}
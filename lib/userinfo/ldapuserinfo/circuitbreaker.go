@@ -0,0 +1,109 @@
+package ldapuserinfo
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCircuitBreakerFailures = 5
+	defaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// circuitBreaker stops dialing the directory after repeated failed
+// connections, so that the handlers fail at once instead of waiting for the
+// timeouts of every server. Once the cooldown is over a single connection is
+// let through to probe the directory, its result closes or reopens the
+// circuit.
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	// now is replaced by the tests.
+	now func() time.Time
+}
+
+func circuitBreakerSettings(failures int, cooldown time.Duration) (int, time.Duration) {
+	if failures == 0 {
+		failures = defaultCircuitBreakerFailures
+	}
+	if cooldown <= 0 {
+		cooldown = defaultCircuitBreakerCooldown
+	}
+	return failures, cooldown
+}
+
+func (b *circuitBreaker) currentTime() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// allow tells if a connection may be attempted.
+func (b *circuitBreaker) allow(failures int, cooldown time.Duration) bool {
+	failures, _ = circuitBreakerSettings(failures, cooldown)
+	if failures < 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures < failures {
+		return true
+	}
+	if b.probing || b.currentTime().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record updates the circuit with the result of a connection.
+func (b *circuitBreaker) record(success bool, failures int, cooldown time.Duration) {
+	failures, cooldown = circuitBreakerSettings(failures, cooldown)
+	if failures < 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+	if success {
+		if b.failures >= failures {
+			log.Printf("ldap circuit closed")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= failures {
+		b.openUntil = b.currentTime().Add(cooldown)
+		log.Printf("ldap circuit open for %s after %d failed connections", cooldown, b.failures)
+	}
+}
+
+// retryAfter returns how long the circuit stays open, 0 when it is closed.
+func (b *circuitBreaker) retryAfter(failures int, cooldown time.Duration) time.Duration {
+	failures, _ = circuitBreakerSettings(failures, cooldown)
+	if failures < 0 {
+		return 0
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures < failures {
+		return 0
+	}
+	wait := b.openUntil.Sub(b.currentTime())
+	if wait <= 0 {
+		// the probe is on its way
+		return time.Second
+	}
+	return wait
+}
+
+// Unavailable returns how long the directory is considered down, 0 when the
+// operations go through.
+func (u *UserInfoLDAPSource) Unavailable() time.Duration {
+	return u.breaker.retryAfter(u.CircuitBreakerFailures, u.CircuitBreakerCooldown)
+}
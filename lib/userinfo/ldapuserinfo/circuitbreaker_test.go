@@ -0,0 +1,79 @@
+package ldapuserinfo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	// no URL can be parsed, every connection fails without dialing
+	u := &UserInfoLDAPSource{CircuitBreakerFailures: 2, CircuitBreakerCooldown: time.Minute}
+	u.breaker.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		_, err := u.getTargetLDAPConnection()
+		if err == nil || err == userinfo.DirectoryUnavailable {
+			t.Fatalf("connection %d returned %v", i, err)
+		}
+	}
+	if u.Unavailable() != time.Minute {
+		t.Fatalf("the circuit should be open, wait=%s", u.Unavailable())
+	}
+	_, err := u.getTargetLDAPWriteConnection()
+	if err != userinfo.DirectoryUnavailable {
+		t.Fatalf("the open circuit returned %v", err)
+	}
+
+	// after the cooldown a single probe goes through
+	now = now.Add(time.Minute)
+	if !u.breaker.allow(u.CircuitBreakerFailures, u.CircuitBreakerCooldown) {
+		t.Fatal("the probe was not allowed")
+	}
+	if u.breaker.allow(u.CircuitBreakerFailures, u.CircuitBreakerCooldown) {
+		t.Fatal("a second probe was allowed")
+	}
+	u.breaker.record(false, u.CircuitBreakerFailures, u.CircuitBreakerCooldown)
+	if u.Unavailable() != time.Minute {
+		t.Fatal("the failed probe should reopen the circuit")
+	}
+	now = now.Add(time.Minute)
+	u.breaker.allow(u.CircuitBreakerFailures, u.CircuitBreakerCooldown)
+	u.breaker.record(true, u.CircuitBreakerFailures, u.CircuitBreakerCooldown)
+	if u.Unavailable() != 0 {
+		t.Fatal("the successful probe should close the circuit")
+	}
+
+	// a negative threshold disables the breaker
+	u = &UserInfoLDAPSource{CircuitBreakerFailures: -1}
+	for i := 0; i < 10; i++ {
+		_, err := u.getTargetLDAPConnection()
+		if err == userinfo.DirectoryUnavailable {
+			t.Fatal("the disabled breaker opened")
+		}
+	}
+}
+
+func TestListingsServedWhileUnavailable(t *testing.T) {
+	u := &UserInfoLDAPSource{CircuitBreakerFailures: 1}
+	_, err := u.GetallGroups()
+	if err == nil || err == userinfo.DirectoryUnavailable {
+		t.Fatalf("the first listing returned %v", err)
+	}
+	_, err = u.GetallGroups()
+	if err != userinfo.DirectoryUnavailable {
+		t.Fatalf("the listing without a cached value returned %v", err)
+	}
+	u.allGroupsCacheValue = []string{"group1"}
+	u.allGroupsAndManagerCacheValue = [][]string{{"group1", "group1"}}
+	groups, err := u.GetallGroups()
+	if err != nil || !reflect.DeepEqual(groups, []string{"group1"}) {
+		t.Fatalf("the expired listing was not served: %v %v", groups, err)
+	}
+	tuples, err := u.GetAllGroupsManagedBy()
+	if err != nil || len(tuples) != 1 {
+		t.Fatalf("the expired listing was not served: %v %v", tuples, err)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build integration
+
+package ldapuserinfo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo/openldaptest"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/userinfotest"
+)
+
+var _ userinfo.UserInfoBackend = &ldapuserinfo.UserInfoLDAPSource{}
+
+func TestIntegrationContract(t *testing.T) {
+	server, err := openldaptest.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate(context.Background())
+	userinfotest.TestBackend(t, server.Source(userinfotest.SuperAdmin))
+}
@@ -0,0 +1,266 @@
+package ldapuserinfo
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/authutil"
+	"gopkg.in/ldap.v2"
+)
+
+// Diagnose checks the setup of the directory one step at a time, for the
+// doctor command of smallpoint: the TLS certificates and the bind of each
+// server, the base DNs, the object classes and the attributes smallpoint
+// writes, and the permission to add entries under the group and service
+// account base DNs.
+
+// certificateExpiryWarning is the validity left under which a certificate
+// check fails.
+const certificateExpiryWarning = 14 * 24 * time.Hour
+
+// The object classes and the attributes of the entries smallpoint creates.
+var (
+	requiredObjectClasses = []string{"groupOfNames", "inetOrgPerson", "ldapPublicKey", "organizationalUnit",
+		"posixAccount", "posixGroup", "shadowAccount"}
+	requiredAttributes = []string{"cn", "gidNumber", "mail", "member", "memberUid", "sshPublicKey", "uid",
+		"uidNumber"}
+)
+
+// DiagnosticResult is the outcome of one check of the directory.
+type DiagnosticResult struct {
+	Check  string
+	Passed bool
+	Detail string
+}
+
+type diagnosis struct {
+	results []DiagnosticResult
+}
+
+func (d *diagnosis) add(check string, err error, detail string) bool {
+	if err != nil {
+		d.results = append(d.results, DiagnosticResult{Check: check, Detail: err.Error()})
+		return false
+	}
+	d.results = append(d.results, DiagnosticResult{Check: check, Passed: true, Detail: detail})
+	return true
+}
+
+// checkCertificate connects to the server and verifies its certificate
+// chain and its expiration.
+func (u *UserInfoLDAPSource) checkCertificate(hostPort string, serverName string) (string, error) {
+	tlsConn, err := tls.DialWithDialer(&net.Dialer{Timeout: ldapTimeout(u.ConnectTimeout)}, "tcp", hostPort,
+		&tls.Config{ServerName: serverName, RootCAs: u.RootCAs})
+	if err != nil {
+		return "", err
+	}
+	defer tlsConn.Close()
+	leaf := tlsConn.ConnectionState().PeerCertificates[0]
+	left := time.Until(leaf.NotAfter)
+	if left < certificateExpiryWarning {
+		return "", fmt.Errorf("the certificate of %s expires on %s", leaf.Subject.CommonName,
+			leaf.NotAfter.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s issued by %s, valid for %d days", leaf.Subject.CommonName,
+		leaf.Issuer.CommonName, int(left.Hours()/24)), nil
+}
+
+// bind returns a bound connection to the server.
+func (u *UserInfoLDAPSource) bind(ldapURL string) (*ldap.Conn, error) {
+	parsedURL, err := authutil.ParseLDAPURL(ldapURL)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := getLDAPConnection(*parsedURL, ldapTimeout(u.ConnectTimeout), u.RootCAs)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout(u.SearchTimeout))
+	conn.Start()
+	err = ldapBind(conn, u.BindUsername, u.bindPassword())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+var schemaNamePattern = regexp.MustCompile(`NAME\s+(?:'([^']+)'|\(([^)]*)\))`)
+
+// schemaNames returns the lower case names of the object class or
+// attribute type definitions.
+func schemaNames(definitions []string) map[string]bool {
+	names := make(map[string]bool)
+	for _, definition := range definitions {
+		match := schemaNamePattern.FindStringSubmatch(definition)
+		if match == nil {
+			continue
+		}
+		if match[1] != "" {
+			names[strings.ToLower(match[1])] = true
+			continue
+		}
+		for _, name := range strings.Fields(match[2]) {
+			names[strings.ToLower(strings.Trim(name, "'"))] = true
+		}
+	}
+	return names
+}
+
+func missingNames(names map[string]bool, required []string) []string {
+	var missing []string
+	for _, name := range required {
+		if name != "" && !names[strings.ToLower(name)] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// checkSchema reads the subschema of the directory and returns the missing
+// object classes and attributes.
+func (u *UserInfoLDAPSource) checkSchema(conn *ldap.Conn) error {
+	rootDSE, err := ldapSearch(conn, ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, 0, false, "(objectClass=*)", []string{"subschemaSubentry"}, nil))
+	if err != nil {
+		return err
+	}
+	if len(rootDSE.Entries) == 0 || rootDSE.Entries[0].GetAttributeValue("subschemaSubentry") == "" {
+		return fmt.Errorf("the directory does not publish its schema")
+	}
+	schema, err := ldapSearch(conn, ldap.NewSearchRequest(rootDSE.Entries[0].GetAttributeValue("subschemaSubentry"),
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false, "(objectClass=subschema)",
+		[]string{"objectClasses", "attributeTypes"}, nil))
+	if err != nil {
+		return err
+	}
+	if len(schema.Entries) == 0 {
+		return fmt.Errorf("the schema is empty")
+	}
+	var problems []string
+	missing := missingNames(schemaNames(schema.Entries[0].GetAttributeValues("objectClasses")),
+		requiredObjectClasses)
+	if len(missing) > 0 {
+		problems = append(problems, "missing object classes "+strings.Join(missing, ", "))
+	}
+	missing = missingNames(schemaNames(schema.Entries[0].GetAttributeValues("attributeTypes")),
+		append([]string{u.GroupManageAttribute, u.SearchAttribute}, requiredAttributes...))
+	if len(missing) > 0 {
+		problems = append(problems, "missing attributes "+strings.Join(missing, ", "))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checkWrite adds a group like the ones smallpoint creates under the base
+// DN and deletes it.
+func checkWrite(conn *ldap.Conn, baseDN string) error {
+	suffix := make([]byte, 6)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+	name := "smallpoint-doctor-" + hex.EncodeToString(suffix)
+	dn := "cn=" + name + "," + baseDN
+	request := ldap.NewAddRequest(dn)
+	request.Attribute("objectClass", []string{"posixGroup", "top", "groupOfNames"})
+	request.Attribute("cn", []string{name})
+	request.Attribute("gidNumber", []string{"0"})
+	err = ldapAdd(conn, request)
+	if err != nil {
+		return fmt.Errorf("cannot add %s: %s", dn, err)
+	}
+	err = ldapDelete(conn, ldap.NewDelRequest(dn, nil))
+	if err != nil {
+		return fmt.Errorf("cannot delete %s, delete it by hand: %s", dn, err)
+	}
+	return nil
+}
+
+// DiagnoseOptions select the optional checks of Diagnose.
+type DiagnoseOptions struct {
+	// Schema checks the object classes and the attributes of the entries
+	// smallpoint creates.
+	Schema bool
+	// Write adds and deletes a temporary group under the group and service
+	// account base DNs.
+	Write bool
+}
+
+// Diagnose returns the results of the checks of the directory. The checks
+// needing a bound connection are skipped when no server accepts the bind.
+func (u *UserInfoLDAPSource) Diagnose(options DiagnoseOptions) []DiagnosticResult {
+	d := &diagnosis{}
+	var conn *ldap.Conn
+	for _, ldapURL := range strings.Split(u.LDAPTargetURLs, ",") {
+		ldapURL = strings.TrimSpace(ldapURL)
+		parsedURL, err := authutil.ParseLDAPURL(ldapURL)
+		if err == nil && parsedURL.Scheme != "ldaps" {
+			err = fmt.Errorf("only ldaps URLs are supported")
+		}
+		if err != nil {
+			d.add("url "+ldapURL, err, "")
+			continue
+		}
+		host, port, err := net.SplitHostPort(parsedURL.Host)
+		if err != nil {
+			host, port = parsedURL.Host, "636"
+		}
+		detail, err := u.checkCertificate(net.JoinHostPort(host, port), host)
+		if !d.add("tls "+ldapURL, err, detail) {
+			continue
+		}
+		serverConn, err := u.bind(ldapURL)
+		if !d.add("bind "+ldapURL, err, "bound as "+u.BindUsername) {
+			continue
+		}
+		if conn == nil {
+			conn = serverConn
+			continue
+		}
+		serverConn.Close()
+	}
+	if conn == nil {
+		return d.results
+	}
+	defer conn.Close()
+	for _, baseDN := range []struct {
+		setting string
+		dn      string
+	}{
+		{"user_search_base_dns", u.UserSearchBaseDNs},
+		{"group_search_base_dns", u.GroupSearchBaseDNs},
+		{"service_search_base_dns", u.ServiceAccountBaseDNs},
+		{"Main_base_dns", u.MainBaseDN},
+	} {
+		if baseDN.dn == "" {
+			continue
+		}
+		exists, err := entryExists(conn, baseDN.dn)
+		if err == nil && !exists {
+			err = fmt.Errorf("%s does not exist", baseDN.dn)
+		}
+		d.add("base DN "+baseDN.setting, err, baseDN.dn)
+	}
+	if options.Schema {
+		d.add("schema", u.checkSchema(conn), "the object classes and attributes are defined")
+	}
+	if !options.Write {
+		return d.results
+	}
+	for i, baseDN := range []string{u.GroupSearchBaseDNs, u.ServiceAccountBaseDNs} {
+		if baseDN == "" || (i > 0 && baseDN == u.GroupSearchBaseDNs) {
+			continue
+		}
+		d.add("write "+baseDN, checkWrite(conn, baseDN), "can add and delete entries")
+	}
+	return d.results
+}
@@ -0,0 +1,38 @@
+package ldapuserinfo
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaNames(t *testing.T) {
+	names := schemaNames([]string{
+		"( 1.3.6.1.1.1.2.2 NAME 'posixGroup' DESC 'Abstraction of a group of accounts' SUP top AUXILIARY )",
+		"( 2.5.4.3 NAME ( 'cn' 'commonName' ) SUP name )",
+		"( 1.2.3 DESC 'no name' )",
+	})
+	expected := map[string]bool{"posixgroup": true, "cn": true, "commonname": true}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("got %v", names)
+	}
+	missing := missingNames(names, []string{"posixGroup", "CommonName", "memberUid", ""})
+	if !reflect.DeepEqual(missing, []string{"memberUid"}) {
+		t.Fatalf("missing %v", missing)
+	}
+}
+
+func TestDiagnoseUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	u := &UserInfoLDAPSource{LDAPTargetURLs: "ldap://" + address + ",ldaps://" + address}
+	results := u.Diagnose(DiagnoseOptions{Schema: true, Write: true})
+	if len(results) != 2 || results[0].Passed || results[0].Check != "url ldap://"+address ||
+		results[1].Passed || results[1].Check != "tls ldaps://"+address {
+		t.Fatalf("unexpected results %+v", results)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"net"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -46,6 +47,17 @@ type UserInfoLDAPSource struct {
 	GroupManageAttribute  string `yaml:"group_Manage_Attribute"`
 	SearchAttribute       string `yaml:"searchAttribute"`
 
+	// The timeouts of the connections and of the searches and writes, the
+	// default is ldapTimeoutSecs.
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	SearchTimeout  time.Duration `yaml:"search_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	// After CircuitBreakerFailures consecutive failed connections the
+	// operations fail at once with userinfo.DirectoryUnavailable for
+	// CircuitBreakerCooldown. A negative CircuitBreakerFailures disables it.
+	CircuitBreakerFailures int           `yaml:"circuit_breaker_failures"`
+	CircuitBreakerCooldown time.Duration `yaml:"circuit_breaker_cooldown"`
+
 	RootCAs *x509.CertPool
 
 	allUsersRWLock                     sync.RWMutex
@@ -57,6 +69,30 @@ type UserInfoLDAPSource struct {
 	allGroupsAndManagerCacheMutex      sync.Mutex
 	allGroupsAndManagerCacheValue      [][]string
 	allGroupsAndManagerCacheExpiration time.Time
+
+	breaker circuitBreaker
+
+	// rotatedBindPassword replaces BindPassword once set by
+	// SetBindPassword.
+	bindPasswordMutex   sync.RWMutex
+	rotatedBindPassword string
+}
+
+// SetBindPassword replaces the bind password of the new connections, e.g.
+// after a rotation.
+func (u *UserInfoLDAPSource) SetBindPassword(password string) {
+	u.bindPasswordMutex.Lock()
+	defer u.bindPasswordMutex.Unlock()
+	u.rotatedBindPassword = password
+}
+
+func (u *UserInfoLDAPSource) bindPassword() string {
+	u.bindPasswordMutex.RLock()
+	defer u.bindPasswordMutex.RUnlock()
+	if u.rotatedBindPassword != "" {
+		return u.rotatedBindPassword
+	}
+	return u.BindPassword
 }
 
 func (u *UserInfoLDAPSource) GetUserAttributes(username string) ([]string, []string, error) {
@@ -107,7 +143,7 @@ func extractCNFromDNString(input []string) (output []string, err error) {
 	return output, nil
 }
 
-func getLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) (*ldap.Conn, string, error) {
+func getLDAPConnection(u url.URL, timeout time.Duration, rootCAs *x509.CertPool) (*ldap.Conn, string, error) {
 	if u.Scheme != "ldaps" {
 		err := errors.New("Invalid ldaputil scheme (we only support ldaps)")
 		log.Println(err)
@@ -124,7 +160,6 @@ func getLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) (*ld
 	server := serverPort[0]
 	hostnamePort := server + ":" + port
 
-	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
 	start := time.Now()
 
 	tlsConn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp",
@@ -143,7 +178,27 @@ func getLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) (*ld
 	return conn, server, nil
 }
 
+func ldapTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return ldapTimeoutSecs * time.Second
+	}
+	return timeout
+}
+
+// getTargetLDAPConnection returns a connection for the searches.
 func (u *UserInfoLDAPSource) getTargetLDAPConnection() (*ldap.Conn, error) {
+	return u.getTargetLDAPConnectionWithTimeout(ldapTimeout(u.SearchTimeout))
+}
+
+// getTargetLDAPWriteConnection returns a connection for the changes.
+func (u *UserInfoLDAPSource) getTargetLDAPWriteConnection() (*ldap.Conn, error) {
+	return u.getTargetLDAPConnectionWithTimeout(ldapTimeout(u.WriteTimeout))
+}
+
+func (u *UserInfoLDAPSource) getTargetLDAPConnectionWithTimeout(timeout time.Duration) (*ldap.Conn, error) {
+	if !u.breaker.allow(u.CircuitBreakerFailures, u.CircuitBreakerCooldown) {
+		return nil, userinfo.DirectoryUnavailable
+	}
 	var ldapURL []*url.URL
 	for _, ldapURLString := range strings.Split(u.LDAPTargetURLs, ",") {
 		newURL, err := authutil.ParseLDAPURL(ldapURLString)
@@ -155,23 +210,25 @@ func (u *UserInfoLDAPSource) getTargetLDAPConnection() (*ldap.Conn, error) {
 	}
 
 	for _, TargetLdapUrl := range ldapURL {
-		conn, _, err := getLDAPConnection(*TargetLdapUrl, ldapTimeoutSecs, u.RootCAs)
+		conn, _, err := getLDAPConnection(*TargetLdapUrl, ldapTimeout(u.ConnectTimeout), u.RootCAs)
 
 		if err != nil {
 			log.Println(err)
 			continue
 		}
-		timeout := time.Duration(time.Duration(ldapTimeoutSecs) * time.Second)
 		conn.SetTimeout(timeout)
 		conn.Start()
 
-		err = conn.Bind(u.BindUsername, u.BindPassword)
+		err = ldapBind(conn, u.BindUsername, u.bindPassword())
 		if err != nil {
 			log.Println(err)
+			conn.Close()
 			continue
 		}
+		u.breaker.record(true, u.CircuitBreakerFailures, u.CircuitBreakerCooldown)
 		return conn, nil
 	}
+	u.breaker.record(false, u.CircuitBreakerFailures, u.CircuitBreakerCooldown)
 	return nil, errors.New("cannot connect to LDAP server")
 }
 
@@ -191,7 +248,7 @@ func (u *UserInfoLDAPSource) getallUsersNonCached() ([]string, error) {
 		searchrequest := ldap.NewSearchRequest(searchPath, ldap.ScopeWholeSubtree,
 			ldap.NeverDerefAliases, 0, 0, false, u.UserSearchFilter, Attributes, nil)
 		t0 := time.Now()
-		result, err := conn.SearchWithPaging(searchrequest, pageSearchSize)
+		result, err := ldapSearchWithPaging(conn, searchrequest, pageSearchSize)
 		t1 := time.Now()
 		log.Printf("GetallUsers search Took %v to run", t1.Sub(t0))
 		if err != nil {
@@ -222,6 +279,9 @@ func (u *UserInfoLDAPSource) GetallUsers() ([]string, error) {
 		return allUsers, nil
 	}
 	allUsers, err := u.getallUsersNonCached()
+	if err == userinfo.DirectoryUnavailable && u.allUsersCacheValue != nil {
+		return u.allUsersCacheValue, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -266,7 +326,7 @@ func (u *UserInfoLDAPSource) getUserDN(conn *ldap.Conn, username string) (string
 			[]string{"uid", "dn"}, //memberOf (if searching other way around using usersdn instead of groupdn)
 			nil,
 		)
-		sr, err := conn.Search(searchRequest)
+		sr, err := ldapSearch(conn, searchRequest)
 		if err != nil {
 			log.Println(err)
 			return "", err
@@ -287,7 +347,7 @@ func (u *UserInfoLDAPSource) getUserDN(conn *ldap.Conn, username string) (string
 
 //Creating a Group --required
 func (u *UserInfoLDAPSource) CreateGroup(groupinfo userinfo.GroupInfo) error {
-	conn, err := u.getTargetLDAPConnection()
+	conn, err := u.getTargetLDAPWriteConnection()
 	if err != nil {
 		log.Println(err)
 		return err
@@ -295,10 +355,13 @@ func (u *UserInfoLDAPSource) CreateGroup(groupinfo userinfo.GroupInfo) error {
 	defer conn.Close()
 
 	entry := u.createGroupDN(groupinfo.Groupname)
-	gidnum, err := u.getMaximumGIDNumber(conn, u.GroupSearchBaseDNs)
-	if err != nil {
-		log.Println(err)
-		return err
+	gidnum := groupinfo.GidNumber
+	if gidnum == "" {
+		gidnum, err = u.getMaximumGIDNumber(conn, u.GroupSearchBaseDNs)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
 	}
 
 	var managerAttributeValue string
@@ -334,7 +397,7 @@ func (u *UserInfoLDAPSource) CreateGroup(groupinfo userinfo.GroupInfo) error {
 		group.Attribute("memberUid", groupinfo.MemberUid)
 	}
 	group.Attribute("gidNumber", []string{gidnum})
-	err = conn.Add(group)
+	err = ldapAdd(conn, group)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -349,7 +412,7 @@ func (u *UserInfoLDAPSource) CreateGroup(groupinfo userinfo.GroupInfo) error {
 
 //deleting a Group from target ldaputil. --required
 func (u *UserInfoLDAPSource) DeleteGroup(groupnames []string) error {
-	conn, err := u.getTargetLDAPConnection()
+	conn, err := u.getTargetLDAPWriteConnection()
 	if err != nil {
 		log.Println(err)
 		return err
@@ -363,7 +426,7 @@ func (u *UserInfoLDAPSource) DeleteGroup(groupnames []string) error {
 			return err
 		}
 		DelReq := ldap.NewDelRequest(groupdn, nil)
-		err = conn.Del(DelReq)
+		err = ldapDelete(conn, DelReq)
 		if err != nil {
 			log.Println(err)
 			return err
@@ -375,7 +438,7 @@ func (u *UserInfoLDAPSource) DeleteGroup(groupnames []string) error {
 
 //Change group description --required
 func (u *UserInfoLDAPSource) ChangeDescription(groupname string, managegroup string) error {
-	conn, err := u.getTargetLDAPConnection()
+	conn, err := u.getTargetLDAPWriteConnection()
 	if err != nil {
 		log.Println(err)
 		return err
@@ -400,7 +463,30 @@ func (u *UserInfoLDAPSource) ChangeDescription(groupname string, managegroup str
 	}
 	modify := ldap.NewModifyRequest(entry)
 	modify.Replace(u.GroupManageAttribute, []string{attributeValue})
-	err = conn.Modify(modify)
+	err = ldapModify(conn, modify)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (u *UserInfoLDAPSource) SetGroupMail(groupname string, addresses []string) error {
+	conn, err := u.getTargetLDAPWriteConnection()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer conn.Close()
+	entry, err := u.getGroupDN(conn, groupname)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	// replacing with no values removes the attribute
+	modify := ldap.NewModifyRequest(entry)
+	modify.Replace("mail", addresses)
+	err = ldapModify(conn, modify)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -408,6 +494,133 @@ func (u *UserInfoLDAPSource) ChangeDescription(groupname string, managegroup str
 	return nil
 }
 
+func (u *UserInfoLDAPSource) GetMailOwners(address string) ([]string, error) {
+	conn, err := u.getTargetLDAPConnection()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	var owners []string
+	for _, searchBaseDN := range []string{u.UserSearchBaseDNs, u.GroupSearchBaseDNs, u.ServiceAccountBaseDNs} {
+		searchRequest := ldap.NewSearchRequest(
+			searchBaseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			"(mail="+ldap.EscapeFilter(address)+")",
+			[]string{"uid", "cn"},
+			nil,
+		)
+		sr, err := ldapSearch(conn, searchRequest)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		for _, entry := range sr.Entries {
+			name := entry.GetAttributeValue("uid")
+			if name == "" {
+				name = entry.GetAttributeValue("cn")
+			}
+			owners = append(owners, name)
+		}
+	}
+	return owners, nil
+}
+
+// RenameGroup copies the group entry to its new DN and deletes the old entry,
+// ldap.v2 has no modify DN operation. The manager attribute of the groups
+// managed by the group is updated, it holds the group DN with the owner
+// attribute and the group name otherwise.
+func (u *UserInfoLDAPSource) RenameGroup(groupname string, newname string) error {
+	conn, err := u.getTargetLDAPWriteConnection()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer conn.Close()
+	oldDN, err := u.getGroupDN(conn, groupname)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	searchRequest := ldap.NewSearchRequest(
+		oldDN,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		[]string{"*"},
+		nil,
+	)
+	sr, err := ldapSearch(conn, searchRequest)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if len(sr.Entries) != 1 {
+		return userinfo.GroupDoesNotExist
+	}
+	newDN := "cn=" + newname + oldDN[strings.Index(oldDN, ","):]
+	isOwnerAttribute := strings.ToLower(u.GroupManageAttribute) == "owner"
+	oldManagerValue, newManagerValue := groupname, newname
+	if isOwnerAttribute {
+		oldManagerValue, newManagerValue = oldDN, newDN
+	}
+	group := ldap.NewAddRequest(newDN)
+	for _, attribute := range sr.Entries[0].Attributes {
+		values := attribute.Values
+		switch {
+		case strings.ToLower(attribute.Name) == "cn":
+			values = []string{newname}
+		case strings.ToLower(attribute.Name) == strings.ToLower(u.GroupManageAttribute):
+			values = nil
+			for _, value := range attribute.Values {
+				if strings.ToLower(value) == strings.ToLower(oldManagerValue) {
+					value = newManagerValue
+				}
+				values = append(values, value)
+			}
+		}
+		group.Attribute(attribute.Name, values)
+	}
+	err = ldapAdd(conn, group)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	err = ldapDelete(conn, ldap.NewDelRequest(oldDN, nil))
+	if err != nil {
+		log.Println(err)
+		delErr := ldapDelete(conn, ldap.NewDelRequest(newDN, nil))
+		if delErr != nil {
+			log.Printf("cannot remove the copy %s of the group err: %s", newDN, delErr)
+		}
+		return err
+	}
+	u.flushGroupCaches()
+
+	searchRequest = ldap.NewSearchRequest(
+		u.GroupSearchBaseDNs,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		"("+u.GroupManageAttribute+"="+ldap.EscapeFilter(oldManagerValue)+")",
+		[]string{"cn"},
+		nil,
+	)
+	sr, err = ldapSearch(conn, searchRequest)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	for _, entry := range sr.Entries {
+		modify := ldap.NewModifyRequest(entry.DN)
+		modify.Replace(u.GroupManageAttribute, []string{newManagerValue})
+		err = ldapModify(conn, modify)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+	return nil
+}
+
 //function to get all the groups in Target ldaputil and put it in array --required
 func (u *UserInfoLDAPSource) getallGroupsNonCached() ([]string, error) {
 	conn, err := u.getTargetLDAPConnection()
@@ -421,7 +634,7 @@ func (u *UserInfoLDAPSource) getallGroupsNonCached() ([]string, error) {
 	searchrequest := ldap.NewSearchRequest(u.GroupSearchBaseDNs, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
 		0, 0, false, u.GroupSearchFilter, []string{"cn"}, nil)
 	t0 := time.Now()
-	result, err := conn.SearchWithPaging(searchrequest, pageSearchSize)
+	result, err := ldapSearchWithPaging(conn, searchrequest, pageSearchSize)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -444,6 +657,9 @@ func (u *UserInfoLDAPSource) GetallGroups() ([]string, error) {
 		return u.allGroupsCacheValue, nil
 	}
 	allGroups, err := u.getallGroupsNonCached()
+	if err == userinfo.DirectoryUnavailable && u.allGroupsCacheValue != nil {
+		return u.allGroupsCacheValue, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -468,7 +684,7 @@ func (u *UserInfoLDAPSource) GetgroupsofUser(username string) ([]string, error)
 		[]string{"cn"}, //memberOf (if searching other way around using usersdn instead of groupdn)
 		nil,
 	)
-	sr, err := conn.Search(searchRequest)
+	sr, err := ldapSearch(conn, searchRequest)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -523,7 +739,7 @@ func (u *UserInfoLDAPSource) getGroupUsersInternal(conn *ldap.Conn, groupname st
 		[]string{"memberUid", u.GroupManageAttribute},
 		nil,
 	)
-	sr, err := conn.Search(searchRequest)
+	sr, err := ldapSearch(conn, searchRequest)
 	if err != nil {
 		log.Println(err)
 		return nil, "", err
@@ -585,7 +801,7 @@ func (u *UserInfoLDAPSource) getMaximumGIDNumber(conn *ldap.Conn, searchBaseDN s
 		[]string{"gidNumber"},
 		nil,
 	)
-	sr, err := conn.Search(searchRequest)
+	sr, err := ldapSearch(conn, searchRequest)
 	if err != nil {
 		log.Println(err)
 		return "error in ldapsearch", err
@@ -605,6 +821,44 @@ func (u *UserInfoLDAPSource) getMaximumGIDNumber(conn *ldap.Conn, searchBaseDN s
 	return fmt.Sprint(max + 1), nil
 }
 
+func (u *UserInfoLDAPSource) GetUsedGidNumbers(min int, max int) (map[int]bool, error) {
+	conn, err := u.getTargetLDAPConnection()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	// gidNumber has no ordering rule in the nis schema, so the range is
+	// checked here.
+	used := make(map[int]bool)
+	for _, searchBaseDN := range []string{u.GroupSearchBaseDNs, u.ServiceAccountBaseDNs} {
+		searchRequest := ldap.NewSearchRequest(
+			searchBaseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			"(&(gidNumber=*))",
+			[]string{"gidNumber"},
+			nil,
+		)
+		sr, err := ldapSearchWithPaging(conn, searchRequest, pageSearchSize)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		for _, entry := range sr.Entries {
+			value, err := strconv.Atoi(entry.GetAttributeValue("gidNumber"))
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if value >= min && value <= max {
+				used[value] = true
+			}
+		}
+	}
+	return used, nil
+}
+
 func (u *UserInfoLDAPSource) getMaximumUIDNumber(conn *ldap.Conn, searchBaseDN string) (string, error) {
 	searchRequest := ldap.NewSearchRequest(
 		searchBaseDN,
@@ -613,7 +867,7 @@ func (u *UserInfoLDAPSource) getMaximumUIDNumber(conn *ldap.Conn, searchBaseDN s
 		[]string{"uidNumber"},
 		nil,
 	)
-	sr, err := conn.SearchWithPaging(searchRequest, pageSearchSize)
+	sr, err := ldapSearchWithPaging(conn, searchRequest, pageSearchSize)
 	if err != nil {
 		log.Println(err)
 		return "error in ldapsearch", err
@@ -635,7 +889,7 @@ func (u *UserInfoLDAPSource) getMaximumUIDNumber(conn *ldap.Conn, searchBaseDN s
 
 //adding members to existing group
 func (u *UserInfoLDAPSource) AddmemberstoExisting(groupinfo userinfo.GroupInfo) error {
-	conn, err := u.getTargetLDAPConnection()
+	conn, err := u.getTargetLDAPWriteConnection()
 	if err != nil {
 		log.Println(err)
 		return err
@@ -658,7 +912,7 @@ func (u *UserInfoLDAPSource) AddmemberstoExisting(groupinfo userinfo.GroupInfo)
 	modify := ldap.NewModifyRequest(entry)
 	modify.Add("member", groupinfo.Member)
 	modify.Add("memberUid", groupinfo.MemberUid)
-	err = conn.Modify(modify)
+	err = ldapModify(conn, modify)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -668,7 +922,7 @@ func (u *UserInfoLDAPSource) AddmemberstoExisting(groupinfo userinfo.GroupInfo)
 
 //remove members from existing group
 func (u *UserInfoLDAPSource) DeletemembersfromGroup(groupinfo userinfo.GroupInfo) error {
-	conn, err := u.getTargetLDAPConnection()
+	conn, err := u.getTargetLDAPWriteConnection()
 	if err != nil {
 		log.Println(err)
 		return err
@@ -694,7 +948,7 @@ func (u *UserInfoLDAPSource) DeletemembersfromGroup(groupinfo userinfo.GroupInfo
 	}
 
 	modify.Delete("member", groupinfo.Member)
-	err = conn.Modify(modify)
+	err = ldapModify(conn, modify)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -734,7 +988,7 @@ func (u *UserInfoLDAPSource) GetDescriptionvalue(groupname string) (string, erro
 		[]string{u.GroupManageAttribute, "cn"},
 		nil,
 	)
-	sr, err := conn.Search(searchRequest)
+	sr, err := ldapSearch(conn, searchRequest)
 	if err != nil {
 		log.Println(err)
 		return "", err
@@ -796,7 +1050,7 @@ func (u *UserInfoLDAPSource) getInfoofUserInternal(conn *ldap.Conn, username str
 
 	searchrequest := ldap.NewSearchRequest(Userdn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
 		0, 0, false, "(&("+u.SearchAttribute+"="+username+"))", searchParams, nil)
-	result, err := conn.Search(searchrequest)
+	result, err := ldapSearch(conn, searchrequest)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -859,7 +1113,7 @@ func (u *UserInfoLDAPSource) GetEmailofusersingroup(groupname string) ([]string,
 }
 
 func (u *UserInfoLDAPSource) CreateServiceAccount(groupinfo userinfo.GroupInfo) error {
-	conn, err := u.getTargetLDAPConnection()
+	conn, err := u.getTargetLDAPWriteConnection()
 	if err != nil {
 		log.Println(err)
 		return err
@@ -882,7 +1136,7 @@ func (u *UserInfoLDAPSource) CreateServiceAccount(groupinfo userinfo.GroupInfo)
 	group.Attribute("objectClass", []string{"posixGroup", "top", "groupOfNames"})
 	group.Attribute("cn", []string{groupinfo.Groupname})
 	group.Attribute("gidNumber", []string{gidnum})
-	err = conn.Add(group)
+	err = ldapAdd(conn, group)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -912,7 +1166,74 @@ func (u *UserInfoLDAPSource) CreateServiceAccount(groupinfo userinfo.GroupInfo)
 	user.Attribute("gidNumber", []string{gidnum})
 	user.Attribute("uidNumber", []string{uidnum})
 
-	err = conn.Add(user)
+	err = ldapAdd(conn, user)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (u *UserInfoLDAPSource) DisableServiceAccount(accountname string) error {
+	conn, err := u.getTargetLDAPWriteConnection()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer conn.Close()
+
+	modify := ldap.NewModifyRequest(u.createServiceDN(accountname, UserServiceAccount))
+	modify.Replace("nsaccountLock", nsaccountLock)
+	// expired since 1970-01-02 for systems not honoring nsaccountLock
+	modify.Replace("shadowExpire", []string{"1"})
+	modify.Replace("loginShell", []string{"/bin/false"})
+	err = ldapModify(conn, modify)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (u *UserInfoLDAPSource) DeleteServiceAccount(accountname string) error {
+	conn, err := u.getTargetLDAPWriteConnection()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer conn.Close()
+
+	// the entries already removed are skipped, the account does not
+	// exist without its user entry
+	missing := false
+	for _, accountType := range []userinfo.AccountType{UserServiceAccount, GroupServiceAccount} {
+		delReq := ldap.NewDelRequest(u.createServiceDN(accountname, accountType), nil)
+		err = ldapDelete(conn, delReq)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			missing = missing || accountType == UserServiceAccount
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+	if missing {
+		return userinfo.UserDoesNotExist
+	}
+	return nil
+}
+
+func (u *UserInfoLDAPSource) SetServiceAccountPassword(accountname string, password string) error {
+	conn, err := u.getTargetLDAPWriteConnection()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer conn.Close()
+
+	passwordModify := ldap.NewPasswordModifyRequest(u.createServiceDN(accountname, UserServiceAccount), "", password)
+	_, err = ldapPasswordModify(conn, passwordModify)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -970,7 +1291,7 @@ func (u *UserInfoLDAPSource) UsernameExistsornot(username string) (bool, error)
 	Attributes := []string{"uid"}
 	searchrequest := ldap.NewSearchRequest(u.MainBaseDN, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases, 0, 0, false, "(&(uid="+username+" ))", Attributes, nil)
-	result, err := conn.Search(searchrequest)
+	result, err := ldapSearch(conn, searchrequest)
 	if err != nil {
 		log.Println("Error in Ldap Search")
 		return false, err
@@ -1005,7 +1326,7 @@ func (u *UserInfoLDAPSource) GroupnameExistsornot(groupname string) (bool, strin
 			ldap.NeverDerefAliases, 0, 0, false, "(&(cn="+groupname+")(objectClass=posixGroup))",
 			nil, nil)
 
-		result, err := conn.Search(searchrequest)
+		result, err := ldapSearch(conn, searchrequest)
 		if err != nil {
 			log.Println("Error in ldap search")
 			return false, "", err
@@ -1041,7 +1362,7 @@ func (u *UserInfoLDAPSource) ServiceAccountExistsornot(groupname string) (bool,
 	searchrequest := ldap.NewSearchRequest(u.ServiceAccountBaseDNs, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases, 0, 0, false, "(|(cn="+groupname+" )(uid="+groupname+"))",
 		Attributes, nil)
-	result, err := conn.Search(searchrequest)
+	result, err := ldapSearch(conn, searchrequest)
 	if err != nil {
 		log.Println(err)
 		return false, "", err
@@ -1074,7 +1395,7 @@ func (u *UserInfoLDAPSource) getGroupDN(conn *ldap.Conn, groupname string) (stri
 			nil,
 			nil,
 		)
-		sr, err := conn.Search(searchRequest)
+		sr, err := ldapSearch(conn, searchRequest)
 		if err != nil {
 			log.Println(err)
 			return "", err
@@ -1110,7 +1431,7 @@ func (u *UserInfoLDAPSource) GetGroupsInfoOfUser(groupdn string, username string
 		[]string{"dn", "cn", u.GroupManageAttribute},
 		nil,
 	)
-	sr, err := conn.Search(searchRequest)
+	sr, err := ldapSearch(conn, searchRequest)
 	if err != nil {
 		return nil, err
 	}
@@ -1148,7 +1469,7 @@ func (u *UserInfoLDAPSource) getAllGroupsManagedByNonCached() ([][]string, error
 		"(|(objectClass=posixGroup)(objectClass=groupofNames))", []string{"cn", u.GroupManageAttribute}, nil)
 
 	t0 := time.Now()
-	result, err := conn.Search(searchrequest)
+	result, err := ldapSearch(conn, searchrequest)
 	if err != nil {
 		return nil, err
 	}
@@ -1183,6 +1504,9 @@ func (u *UserInfoLDAPSource) GetAllGroupsManagedBy() ([][]string, error) {
 		return u.allGroupsAndManagerCacheValue, nil
 	}
 	allGroups, err := u.getAllGroupsManagedByNonCached()
+	if err == userinfo.DirectoryUnavailable && u.allGroupsAndManagerCacheValue != nil {
+		return u.allGroupsAndManagerCacheValue, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -1211,7 +1535,7 @@ func (u *UserInfoLDAPSource) GetGroupandManagedbyAttributeValue(groupnames []str
 }
 
 func (u *UserInfoLDAPSource) CreateUser(username string, givenName, email []string) error {
-	conn, err := u.getTargetLDAPConnection()
+	conn, err := u.getTargetLDAPWriteConnection()
 	if err != nil {
 		log.Println(err)
 		return err
@@ -1248,10 +1572,130 @@ func (u *UserInfoLDAPSource) CreateUser(username string, givenName, email []stri
 	user.Attribute("uidNumber", []string{uidnum})
 	user.Attribute("gidNumber", []string{"100"})
 
-	err = conn.Add(user)
+	err = ldapAdd(conn, user)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// returns the groups which are listed as members of a group, only entries under
+// the group search base are considered groups.
+func (u *UserInfoLDAPSource) GetSubgroupsofGroup(groupname string) ([]string, error) {
+	conn, err := u.getTargetLDAPConnection()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	searchRequest := ldap.NewSearchRequest(
+		u.GroupSearchBaseDNs,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		"(&(cn="+ldap.EscapeFilter(groupname)+")(objectClass=groupOfNames))",
+		[]string{"member"},
+		nil,
+	)
+	sr, err := ldapSearch(conn, searchRequest)
 	if err != nil {
 		log.Println(err)
+		return nil, err
+	}
+	if len(sr.Entries) > 1 {
+		return nil, errors.New("GetSubgroupsofGroup: Multiple entries found, Contact the administrator!")
+	}
+	if len(sr.Entries) < 1 {
+		return nil, userinfo.GroupDoesNotExist
+	}
+	groupBaseDN := strings.ToLower(u.GroupSearchBaseDNs)
+	var subgroupDNs []string
+	for _, memberDN := range sr.Entries[0].GetAttributeValues("member") {
+		if strings.HasSuffix(strings.ToLower(memberDN), ","+groupBaseDN) {
+			subgroupDNs = append(subgroupDNs, memberDN)
+		}
+	}
+	return extractCNFromDNString(subgroupDNs)
+}
+
+// returns the groups that have the given group as a member.
+func (u *UserInfoLDAPSource) GetParentgroupsofGroup(groupname string) ([]string, error) {
+	conn, err := u.getTargetLDAPConnection()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	groupDN, err := u.getGroupDN(conn, groupname)
+	if err != nil {
+		return nil, err
+	}
+	searchRequest := ldap.NewSearchRequest(
+		u.GroupSearchBaseDNs,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		"(&(member="+ldap.EscapeFilter(groupDN)+")(objectClass=groupOfNames))",
+		[]string{"cn"},
+		nil,
+	)
+	sr, err := ldapSearch(conn, searchRequest)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	var groups []string
+	for _, entry := range sr.Entries {
+		groups = append(groups, entry.GetAttributeValue("cn"))
+	}
+	return groups, nil
+}
+
+// SearchUsers asks the directory for at most limit users, the directory
+// returns the users it found before reaching the limit.
+func (u *UserInfoLDAPSource) SearchUsers(prefix string, limit int) ([]userinfo.UserSearchResult, error) {
+	conn, err := u.getTargetLDAPConnection()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	prefix = ldap.EscapeFilter(prefix)
+	filter := "(&" + u.UserSearchFilter + "(|(uid=" + prefix + "*)(cn=" + prefix + "*)(givenName=" +
+		prefix + "*)(sn=" + prefix + "*)(mail=" + prefix + "*)))"
+	searchRequest := ldap.NewSearchRequest(
+		u.UserSearchBaseDNs,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, limit, 0, false,
+		filter,
+		[]string{"uid", "cn", "mail"},
+		nil,
+	)
+	sr, err := ldapSearch(conn, searchRequest)
+	if err != nil && !(ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) && sr != nil) {
+		log.Println(err)
+		return nil, err
+	}
+	var users []userinfo.UserSearchResult
+	for _, entry := range sr.Entries {
+		users = append(users, userinfo.UserSearchResult{
+			Username:    entry.GetAttributeValue("uid"),
+			DisplayName: entry.GetAttributeValue("cn"),
+			Email:       entry.GetAttributeValue("mail"),
+		})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// Ping connects and binds to the target directory.
+func (u *UserInfoLDAPSource) Ping() error {
+	conn, err := u.getTargetLDAPConnection()
+	if err != nil {
 		return err
 	}
+	conn.Close()
 	return nil
 }
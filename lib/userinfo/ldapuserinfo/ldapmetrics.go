@@ -0,0 +1,60 @@
+package ldapuserinfo
+
+import (
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/metrics"
+	"gopkg.in/ldap.v2"
+)
+
+// The operations on the connections go through these functions, which record
+// their latency by operation type.
+
+func ldapSearch(conn *ldap.Conn, request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	start := time.Now()
+	result, err := conn.Search(request)
+	metrics.MetricLogLDAPOperationDuration("search", time.Since(start), err)
+	return result, err
+}
+
+func ldapSearchWithPaging(conn *ldap.Conn, request *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	start := time.Now()
+	result, err := conn.SearchWithPaging(request, pagingSize)
+	metrics.MetricLogLDAPOperationDuration("search", time.Since(start), err)
+	return result, err
+}
+
+func ldapAdd(conn *ldap.Conn, request *ldap.AddRequest) error {
+	start := time.Now()
+	err := conn.Add(request)
+	metrics.MetricLogLDAPOperationDuration("add", time.Since(start), err)
+	return err
+}
+
+func ldapModify(conn *ldap.Conn, request *ldap.ModifyRequest) error {
+	start := time.Now()
+	err := conn.Modify(request)
+	metrics.MetricLogLDAPOperationDuration("modify", time.Since(start), err)
+	return err
+}
+
+func ldapDelete(conn *ldap.Conn, request *ldap.DelRequest) error {
+	start := time.Now()
+	err := conn.Del(request)
+	metrics.MetricLogLDAPOperationDuration("delete", time.Since(start), err)
+	return err
+}
+
+func ldapBind(conn *ldap.Conn, username string, password string) error {
+	start := time.Now()
+	err := conn.Bind(username, password)
+	metrics.MetricLogLDAPOperationDuration("bind", time.Since(start), err)
+	return err
+}
+
+func ldapPasswordModify(conn *ldap.Conn, request *ldap.PasswordModifyRequest) (*ldap.PasswordModifyResult, error) {
+	start := time.Now()
+	result, err := conn.PasswordModify(request)
+	metrics.MetricLogLDAPOperationDuration("password_modify", time.Since(start), err)
+	return result, err
+}
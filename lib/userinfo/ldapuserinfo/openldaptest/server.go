@@ -0,0 +1,266 @@
+//go:build integration
+
+// Package openldaptest runs OpenLDAP in a container for the integration
+// tests, with the schema smallpoint expects and a bind account of its own
+// whose rights come from an access rule, like in production. It needs
+// Docker and is built with the integration tag only.
+package openldaptest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo/ldapuserinfo"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	image = "osixia/openldap:1.5.0"
+
+	BaseDN        = "dc=example,dc=com"
+	UserBaseDN    = "ou=people," + BaseDN
+	GroupBaseDN   = "ou=groups," + BaseDN
+	ServiceBaseDN = "ou=services," + BaseDN
+	// BindDN is the account smallpoint binds as.
+	BindDN = "cn=smallpoint,ou=system," + BaseDN
+
+	adminDN        = "cn=admin," + BaseDN
+	configAdminDN  = "cn=admin,cn=config"
+	databaseDN     = "olcDatabase={1}mdb,cn=config"
+	certsDirectory = "/container/service/slapd/assets/certs"
+	schemaFilename = "/container/service/slapd/assets/config/bootstrap/schema/custom/smallpoint.schema"
+	startupTimeout = 3 * time.Minute
+)
+
+// bindAccessRule lets the bind account change the whole tree, the others
+// go on with the rules of the image.
+const bindAccessRule = `{0}to * by dn.exact="` + BindDN + `" write by * break`
+
+//go:embed smallpoint.schema
+var schema []byte
+
+type Server struct {
+	// URL is the ldaps URL of the server.
+	URL          string
+	RootCAs      *x509.CertPool
+	BindPassword string
+
+	adminPassword string
+	tlsConfig     *tls.Config
+	address       string
+	container     testcontainers.Container
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// writeCertificate writes the self signed certificate of localhost and its
+// key to dir.
+func writeCertificate(dir string) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(dir, "cert.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(dir, "key.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certDER)
+}
+
+// Start runs the server and creates the base entries and the bind account.
+// The caller terminates it.
+func Start(ctx context.Context) (*Server, error) {
+	dir, err := os.MkdirTemp("", "openldaptest")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	cert, err := writeCertificate(dir)
+	if err != nil {
+		return nil, err
+	}
+	schemaPath := filepath.Join(dir, "smallpoint.schema")
+	if err := os.WriteFile(schemaPath, schema, 0644); err != nil {
+		return nil, err
+	}
+	s := &Server{RootCAs: x509.NewCertPool()}
+	s.RootCAs.AddCert(cert)
+	s.tlsConfig = &tls.Config{ServerName: "localhost", RootCAs: s.RootCAs}
+	if s.adminPassword, err = randomPassword(); err != nil {
+		return nil, err
+	}
+	if s.BindPassword, err = randomPassword(); err != nil {
+		return nil, err
+	}
+	request := testcontainers.ContainerRequest{
+		Image:        image,
+		ExposedPorts: []string{"636/tcp"},
+		Env: map[string]string{
+			"LDAP_DOMAIN":              "example.com",
+			"LDAP_ADMIN_PASSWORD":      s.adminPassword,
+			"LDAP_CONFIG_PASSWORD":     s.adminPassword,
+			"LDAP_RFC2307BIS_SCHEMA":   "true",
+			"LDAP_TLS_CRT_FILENAME":    "cert.pem",
+			"LDAP_TLS_KEY_FILENAME":    "key.pem",
+			"LDAP_TLS_CA_CRT_FILENAME": "cert.pem",
+			"LDAP_TLS_VERIFY_CLIENT":   "never",
+		},
+		Files: []testcontainers.ContainerFile{
+			{HostFilePath: filepath.Join(dir, "cert.pem"), ContainerFilePath: certsDirectory + "/cert.pem", FileMode: 0644},
+			{HostFilePath: filepath.Join(dir, "key.pem"), ContainerFilePath: certsDirectory + "/key.pem", FileMode: 0644},
+			{HostFilePath: schemaPath, ContainerFilePath: schemaFilename, FileMode: 0644},
+		},
+		WaitingFor: wait.ForLog("slapd starting").WithStartupTimeout(startupTimeout),
+	}
+	s.container, err = testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: request,
+		Started:          true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	host, err := s.container.Host(ctx)
+	if err != nil {
+		s.Terminate(ctx)
+		return nil, err
+	}
+	port, err := s.container.MappedPort(ctx, "636/tcp")
+	if err != nil {
+		s.Terminate(ctx)
+		return nil, err
+	}
+	s.address = net.JoinHostPort(host, port.Port())
+	s.URL = "ldaps://" + s.address
+	if err := s.bootstrap(); err != nil {
+		s.Terminate(ctx)
+		return nil, err
+	}
+	return s, nil
+}
+
+// dial returns a connection bound as the DN, the server may still be
+// restarting after its bootstrap.
+func (s *Server) dial(dn string, password string) (*ldap.Conn, error) {
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		conn, err := ldap.DialTLS("tcp", s.address, s.tlsConfig)
+		if err == nil {
+			if err = conn.Bind(dn, password); err == nil {
+				return conn, nil
+			}
+			conn.Close()
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("cannot bind as %s: %s", dn, err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (s *Server) bootstrap() error {
+	conn, err := s.dial(configAdminDN, s.adminPassword)
+	if err != nil {
+		return err
+	}
+	modify := ldap.NewModifyRequest(databaseDN)
+	modify.Add("olcAccess", []string{bindAccessRule})
+	err = conn.Modify(modify)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	conn, err = s.dial(adminDN, s.adminPassword)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, ou := range []string{"system", "people", "groups", "services"} {
+		dn := "ou=" + ou + "," + BaseDN
+		request := ldap.NewAddRequest(dn)
+		request.Attribute("objectClass", []string{"organizationalUnit", "top"})
+		request.Attribute("ou", []string{ou})
+		if err := conn.Add(request); err != nil {
+			return fmt.Errorf("cannot add %s: %s", dn, err)
+		}
+	}
+	request := ldap.NewAddRequest(BindDN)
+	request.Attribute("objectClass", []string{"organizationalRole", "simpleSecurityObject", "top"})
+	request.Attribute("cn", []string{"smallpoint"})
+	request.Attribute("userPassword", []string{s.BindPassword})
+	if err := conn.Add(request); err != nil {
+		return fmt.Errorf("cannot add %s: %s", BindDN, err)
+	}
+	return nil
+}
+
+// Source returns the directory of smallpoint on the server, bound as
+// BindDN. The users of admins are the super admins.
+func (s *Server) Source(admins string) *ldapuserinfo.UserInfoLDAPSource {
+	return &ldapuserinfo.UserInfoLDAPSource{
+		BindUsername:          BindDN,
+		BindPassword:          s.BindPassword,
+		LDAPTargetURLs:        s.URL,
+		UserSearchBaseDNs:     UserBaseDN,
+		UserSearchFilter:      "(&(uid=*)(objectClass=person))",
+		GroupSearchBaseDNs:    GroupBaseDN,
+		GroupSearchFilter:     "(|(objectClass=posixGroup)(objectClass=groupofNames))",
+		Admins:                admins,
+		ServiceAccountBaseDNs: ServiceBaseDN,
+		MainBaseDN:            BaseDN,
+		GroupManageAttribute:  "description",
+		SearchAttribute:       "uid",
+		RootCAs:               s.RootCAs,
+	}
+}
+
+func (s *Server) Terminate(ctx context.Context) error {
+	return s.container.Terminate(ctx)
+}
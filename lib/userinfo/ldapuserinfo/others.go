@@ -24,7 +24,7 @@ func (u *UserInfoLDAPSource) getDisabledAccountsinLDAP() ([]string, error) {
 	}
 	defer conn.Close()
 
-	result, err := conn.SearchWithPaging(searchrequest, maximumPagingsize)
+	result, err := ldapSearchWithPaging(conn, searchrequest, maximumPagingsize)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -42,7 +42,7 @@ func (u *UserInfoLDAPSource) getDisabledAccountsinLDAP() ([]string, error) {
 //function which compares the users disabled accounts in Source LDAP and Target LDAP and adds the attribute nsaccountLock in TARGET LDAP for the disbaled USer.
 //---required
 func (u *UserInfoLDAPSource) DisableaccountsinLdap(result []string) error {
-	conn, err := u.getTargetLDAPConnection()
+	conn, err := u.getTargetLDAPWriteConnection()
 	if err != nil {
 		return err
 	}
@@ -53,7 +53,7 @@ func (u *UserInfoLDAPSource) DisableaccountsinLdap(result []string) error {
 
 		modify := ldap.NewModifyRequest(entry)
 		modify.Replace("nsaccountLock", nsaccountLock)
-		err := conn.Modify(modify)
+		err := ldapModify(conn, modify)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,28 @@
+package mock
+
+import (
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+	"github.com/Symantec/ldap-group-management/lib/userinfo/userinfotest"
+)
+
+var _ userinfo.UserInfoBackend = &MockLdap{}
+var _ userinfo.UserInfoBackend = &Synchronized{}
+
+func newEmptyMockLdap() *MockLdap {
+	return &MockLdap{
+		Groups:      make(map[string]LdapGroupInfo),
+		Users:       make(map[string]LdapUserInfo),
+		Services:    make(map[string]LdapServiceInfo),
+		SuperAdmins: userinfotest.SuperAdmin,
+	}
+}
+
+func TestMockLdapContract(t *testing.T) {
+	userinfotest.TestBackend(t, newEmptyMockLdap())
+}
+
+func TestSynchronizedContract(t *testing.T) {
+	userinfotest.TestBackend(t, NewSynchronized(newEmptyMockLdap()))
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/Symantec/ldap-group-management/lib/userinfo"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -29,6 +30,7 @@ type LdapGroupInfo struct {
 	objectClass []string
 	member      []string
 	memberUid   []string
+	mail        []string
 }
 
 const LdapUserDN = "ou=people,dc=mgmt,dc=example,dc=com"
@@ -56,6 +58,9 @@ type LdapServiceInfo struct {
 	mail        string
 	cn          string
 	description string
+	loginShell  string
+	locked      bool
+	password    string
 }
 
 func New() *MockLdap {
@@ -132,6 +137,25 @@ func (m *MockLdap) GetallUsers() ([]string, error) {
 	return allusers, nil
 }
 
+func (m *MockLdap) SearchUsers(prefix string, limit int) ([]userinfo.UserSearchResult, error) {
+	prefix = strings.ToLower(prefix)
+	var users []userinfo.UserSearchResult
+	for _, value := range m.Users {
+		for _, attribute := range []string{value.uid, value.cn, value.givenName, value.mail} {
+			if strings.HasPrefix(strings.ToLower(attribute), prefix) {
+				users = append(users, userinfo.UserSearchResult{Username: value.uid, DisplayName: value.cn,
+					Email: value.mail})
+				break
+			}
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
 func (m *MockLdap) createUserDN(username string) string {
 	userDN := "uid=" + username + "," + LdapUserDN
 	return userDN
@@ -156,13 +180,27 @@ func (m *MockLdap) createServiceDN(groupname string, accountType userinfo.Accoun
 
 func (m *MockLdap) CreateGroup(groupinfo userinfo.GroupInfo) error {
 	groupdn := m.CreategroupDn(groupinfo.Groupname)
+	if _, ok := m.Groups[groupdn]; ok {
+		return fmt.Errorf("group %s already exists", groupinfo.Groupname)
+	}
 	var group LdapGroupInfo
 	group.cn = groupinfo.Groupname
+	group.dn = groupdn
 	group.description = groupinfo.Description
 	group.memberUid = groupinfo.MemberUid
+	group.member = groupinfo.Member
+	if len(group.member) == 0 {
+		for _, memberUid := range groupinfo.MemberUid {
+			group.member = append(group.member, m.createUserDN(memberUid))
+		}
+	}
 	group.objectClass = []string{"posixGroup", "top", "groupOfNames"}
-	group.gidNumber, _ = m.GetmaximumGidnumber(LdapGroupDN)
+	group.gidNumber = groupinfo.GidNumber
+	if group.gidNumber == "" {
+		group.gidNumber, _ = m.GetmaximumGidnumber(LdapGroupDN)
+	}
 	m.Groups[groupdn] = group
+	m.setMemberOf(groupdn, group.memberUid, true)
 
 	return nil
 
@@ -171,6 +209,7 @@ func (m *MockLdap) CreateGroup(groupinfo userinfo.GroupInfo) error {
 func (m *MockLdap) DeleteGroup(groupnames []string) error {
 	for _, groupname := range groupnames {
 		groupdn := m.CreategroupDn(groupname)
+		m.setMemberOf(groupdn, m.Groups[groupdn].memberUid, false)
 		delete(m.Groups, groupdn)
 	}
 	return nil
@@ -190,7 +229,18 @@ func (m *MockLdap) GetallGroups() ([]string, error) {
 func (m *MockLdap) GetgroupsofUser(username string) ([]string, error) {
 	var usergroups []string
 	userdn := m.createUserDN(username)
-	Userinfo := m.Users[userdn]
+	Userinfo, ok := m.Users[userdn]
+	if !ok {
+		// service accounts have no memberOf, search memberUid like LDAP does
+		for _, group := range m.Groups {
+			for _, memberUid := range group.memberUid {
+				if memberUid == username {
+					usergroups = append(usergroups, group.cn)
+				}
+			}
+		}
+		return usergroups, nil
+	}
 	for _, groupdn := range Userinfo.memberOf {
 		Groupinfo := m.Groups[groupdn]
 		usergroups = append(usergroups, Groupinfo.cn)
@@ -241,6 +291,27 @@ func (m *MockLdap) UserisadminOrNot(username string) bool {
 	return false
 }
 
+func (m *MockLdap) GetUsedGidNumbers(min int, max int) (map[int]bool, error) {
+	var gidNumbers []string
+	for _, value := range m.Groups {
+		gidNumbers = append(gidNumbers, value.gidNumber)
+	}
+	for _, value := range m.Services {
+		gidNumbers = append(gidNumbers, value.gidNumber)
+	}
+	used := make(map[int]bool)
+	for _, gidNumber := range gidNumbers {
+		gidnum, err := strconv.Atoi(gidNumber)
+		if err != nil {
+			continue
+		}
+		if gidnum >= min && gidnum <= max {
+			used[gidnum] = true
+		}
+	}
+	return used, nil
+}
+
 func (m *MockLdap) GetmaximumGidnumber(s string) (string, error) {
 	var max = 0
 	if s == LdapGroupDN {
@@ -296,9 +367,33 @@ func (m *MockLdap) AddmemberstoExisting(groupinfo userinfo.GroupInfo) error {
 		groupinformation.member = append(groupinformation.member, member)
 	}
 	m.Groups[groupdn] = groupinformation
+	m.setMemberOf(groupdn, groupinfo.MemberUid, true)
 	return nil
 }
 
+// setMemberOf keeps the memberOf of the users in sync with the memberUid of
+// the group, as the memberOf overlay of the directory does.
+func (m *MockLdap) setMemberOf(groupdn string, usernames []string, isMember bool) {
+	for _, username := range usernames {
+		userdn := m.createUserDN(username)
+		user, ok := m.Users[userdn]
+		if !ok {
+			continue
+		}
+		var memberOf []string
+		for _, dn := range user.memberOf {
+			if dn != groupdn {
+				memberOf = append(memberOf, dn)
+			}
+		}
+		if isMember {
+			memberOf = append(memberOf, groupdn)
+		}
+		user.memberOf = memberOf
+		m.Users[userdn] = user
+	}
+}
+
 func (m *MockLdap) DeletemembersfromGroup(groupinfo userinfo.GroupInfo) error {
 	groupdn := m.CreategroupDn(groupinfo.Groupname)
 	groupinformation, ok := m.Groups[groupdn]
@@ -308,6 +403,7 @@ func (m *MockLdap) DeletemembersfromGroup(groupinfo userinfo.GroupInfo) error {
 	groupinformation.memberUid = removeElements(groupinformation.memberUid, groupinfo.MemberUid)
 	groupinformation.member = removeElements(groupinformation.member, groupinfo.Member)
 	m.Groups[groupdn] = groupinformation
+	m.setMemberOf(groupdn, groupinfo.MemberUid, false)
 	return nil
 }
 
@@ -338,7 +434,10 @@ func (m *MockLdap) GetDescriptionvalue(groupname string) (string, error) {
 
 func (m *MockLdap) GetEmailofauser(username string) ([]string, error) {
 	userdn := m.createUserDN(username)
-	usersinfo := m.Users[userdn]
+	usersinfo, ok := m.Users[userdn]
+	if !ok {
+		return nil, userinfo.UserDoesNotExist
+	}
 
 	return []string{usersinfo.mail}, nil
 }
@@ -377,6 +476,7 @@ func (m *MockLdap) CreateServiceAccount(groupinfo userinfo.GroupInfo) error {
 	user.cn = groupinfo.Groupname
 	user.uid = groupinfo.Groupname
 	user.mail = groupinfo.Mail
+	user.loginShell = groupinfo.LoginShell
 	user.objectClass = []string{"top", "person", "inetOrgPerson", "posixAccount", "organizationalPerson"}
 	user.gidNumber = gidNum
 	user.uidNumber, _ = m.GetmaximumUidnumber(LdapServiceDN)
@@ -385,24 +485,58 @@ func (m *MockLdap) CreateServiceAccount(groupinfo userinfo.GroupInfo) error {
 	return nil
 }
 
+func (m *MockLdap) DisableServiceAccount(accountname string) error {
+	userdn := m.createServiceDN(accountname, UserServiceAccount)
+	user, ok := m.Services[userdn]
+	if !ok {
+		return userinfo.UserDoesNotExist
+	}
+	user.loginShell = "/bin/false"
+	user.locked = true
+	m.Services[userdn] = user
+	return nil
+}
+
+func (m *MockLdap) DeleteServiceAccount(accountname string) error {
+	userdn := m.createServiceDN(accountname, UserServiceAccount)
+	if _, ok := m.Services[userdn]; !ok {
+		return userinfo.UserDoesNotExist
+	}
+	delete(m.Services, userdn)
+	delete(m.Services, m.createServiceDN(accountname, GroupServiceAccount))
+	return nil
+}
+
+func (m *MockLdap) SetServiceAccountPassword(accountname string, password string) error {
+	userdn := m.createServiceDN(accountname, UserServiceAccount)
+	user, ok := m.Services[userdn]
+	if !ok {
+		return userinfo.UserDoesNotExist
+	}
+	user.password = password
+	m.Services[userdn] = user
+	return nil
+}
+
 func (m *MockLdap) IsgroupAdminorNot(username string, groupname string) (bool, error) {
 	managedby, err := m.GetDescriptionvalue(groupname)
 	if err != nil {
 		return false, err
 	}
-	if managedby == "self-managed" {
-		Isgroupmember, _, err := m.IsgroupmemberorNot(groupname, username)
-		if !Isgroupmember && err != nil {
-			return false, err
-		}
+	if m.UserisadminOrNot(username) {
 		return true, nil
 	}
+	if managedby == "self-managed" {
+		managedby = groupname
+	}
+	if _, ok := m.Groups[m.CreategroupDn(managedby)]; !ok {
+		return false, nil
+	}
 	Isgroupmember, _, err := m.IsgroupmemberorNot(managedby, username)
-	if !Isgroupmember && err != nil {
+	if err != nil {
 		return false, err
 	}
-
-	return true, nil
+	return Isgroupmember, nil
 }
 
 func (m *MockLdap) UsernameExistsornot(username string) (bool, error) {
@@ -414,6 +548,12 @@ func (m *MockLdap) UsernameExistsornot(username string) (bool, error) {
 		}
 
 	}
+	// like LDAP, service accounts are users too
+	for _, entry := range m.Services {
+		if entry.uid != "" && entry.uid == username {
+			return true, nil
+		}
+	}
 
 	return false, nil
 }
@@ -426,6 +566,10 @@ func (m *MockLdap) GroupnameExistsornot(groupname string) (bool, string, error)
 		}
 
 	}
+	// like LDAP, the groups of the service accounts are groups too
+	if _, ok := m.Services[m.createServiceDN(groupname, GroupServiceAccount)]; ok {
+		return true, "", nil
+	}
 
 	return false, "", nil
 }
@@ -496,9 +640,70 @@ func (m *MockLdap) GetGroupandManagedbyAttributeValue(groupnames []string) ([][]
 }
 
 func (m *MockLdap) ChangeDescription(groupname string, managegroup string) error {
+	groupdn := m.CreategroupDn(groupname)
+	group, ok := m.Groups[groupdn]
+	if !ok {
+		return userinfo.GroupDoesNotExist
+	}
+	group.description = managegroup
+	m.Groups[groupdn] = group
+	return nil
+}
+
+func (m *MockLdap) RenameGroup(groupname string, newname string) error {
+	groupdn := m.CreategroupDn(groupname)
+	group, ok := m.Groups[groupdn]
+	if !ok {
+		return userinfo.GroupDoesNotExist
+	}
+	delete(m.Groups, groupdn)
+	m.setMemberOf(groupdn, group.memberUid, false)
+	group.cn = newname
+	group.dn = m.CreategroupDn(newname)
+	m.Groups[group.dn] = group
+	m.setMemberOf(group.dn, group.memberUid, true)
+	for dn, managed := range m.Groups {
+		if managed.description == groupname {
+			managed.description = newname
+			m.Groups[dn] = managed
+		}
+	}
+	return nil
+}
+
+func (m *MockLdap) SetGroupMail(groupname string, addresses []string) error {
+	groupdn := m.CreategroupDn(groupname)
+	group, ok := m.Groups[groupdn]
+	if !ok {
+		return userinfo.GroupDoesNotExist
+	}
+	group.mail = addresses
+	m.Groups[groupdn] = group
 	return nil
 }
 
+func (m *MockLdap) GetMailOwners(address string) ([]string, error) {
+	var owners []string
+	for _, user := range m.Users {
+		if user.mail == address {
+			owners = append(owners, user.uid)
+		}
+	}
+	for _, group := range m.Groups {
+		for _, mail := range group.mail {
+			if mail == address {
+				owners = append(owners, group.cn)
+			}
+		}
+	}
+	for _, service := range m.Services {
+		if service.mail == address {
+			owners = append(owners, service.cn)
+		}
+	}
+	return owners, nil
+}
+
 func (m *MockLdap) CreateUser(username string, givenName, email []string) error {
 
 	userdn := m.createUserDN(username)
@@ -508,6 +713,9 @@ func (m *MockLdap) CreateUser(username string, givenName, email []string) error
 	user.uidNumber, _ = m.GetmaximumUidnumber(LdapUserDN)
 	user.mail = email[0]
 	user.cn = username
+	if len(givenName) > 0 {
+		user.givenName = givenName[0]
+	}
 	m.Users[userdn] = user
 	return nil
 }
@@ -527,3 +735,39 @@ func (m *MockLdap) GetUserAttributes(username string) ([]string, []string, error
 
 	return []string{usersinfo.mail}, []string{usersinfo.givenName}, nil
 }
+
+func (m *MockLdap) GetSubgroupsofGroup(groupname string) ([]string, error) {
+	groupinfo, ok := m.Groups[m.CreategroupDn(groupname)]
+	if !ok {
+		return nil, userinfo.GroupDoesNotExist
+	}
+	var subgroups []string
+	for _, member := range groupinfo.member {
+		subgroup, ok := m.Groups[member]
+		if ok {
+			subgroups = append(subgroups, subgroup.cn)
+		}
+	}
+	return subgroups, nil
+}
+
+func (m *MockLdap) GetParentgroupsofGroup(groupname string) ([]string, error) {
+	groupdn := m.CreategroupDn(groupname)
+	if _, ok := m.Groups[groupdn]; !ok {
+		return nil, userinfo.GroupDoesNotExist
+	}
+	var parents []string
+	for _, value := range m.Groups {
+		for _, member := range value.member {
+			if member == groupdn {
+				parents = append(parents, value.cn)
+				break
+			}
+		}
+	}
+	return parents, nil
+}
+
+func (m *MockLdap) Ping() error {
+	return nil
+}
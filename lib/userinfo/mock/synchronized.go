@@ -0,0 +1,240 @@
+package mock
+
+import (
+	"sync"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// Synchronized serializes the calls to the in-memory directory so that the
+// requests of a running server can share it.
+type Synchronized struct {
+	mutex     sync.Mutex
+	directory *MockLdap
+}
+
+func NewSynchronized(directory *MockLdap) *Synchronized {
+	return &Synchronized{directory: directory}
+}
+
+func (s *Synchronized) GetallUsers() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetallUsers()
+}
+
+func (s *Synchronized) SearchUsers(prefix string, limit int) ([]userinfo.UserSearchResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.SearchUsers(prefix, limit)
+}
+
+func (s *Synchronized) CreateGroup(groupinfo userinfo.GroupInfo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.CreateGroup(groupinfo)
+}
+
+func (s *Synchronized) GetUsedGidNumbers(min int, max int) (map[int]bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetUsedGidNumbers(min, max)
+}
+
+func (s *Synchronized) DeleteGroup(groupnames []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.DeleteGroup(groupnames)
+}
+
+func (s *Synchronized) ChangeDescription(groupname string, managegroup string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.ChangeDescription(groupname, managegroup)
+}
+
+func (s *Synchronized) SetGroupMail(groupname string, addresses []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.SetGroupMail(groupname, addresses)
+}
+
+func (s *Synchronized) GetMailOwners(address string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetMailOwners(address)
+}
+
+func (s *Synchronized) RenameGroup(groupname string, newname string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.RenameGroup(groupname, newname)
+}
+
+func (s *Synchronized) GetallGroups() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetallGroups()
+}
+
+func (s *Synchronized) GetgroupsofUser(username string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetgroupsofUser(username)
+}
+
+func (s *Synchronized) GetusersofaGroup(groupname string) ([]string, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetusersofaGroup(groupname)
+}
+
+func (s *Synchronized) GetGroupUsersAndManagers(groupname string) ([]string, []string, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetGroupUsersAndManagers(groupname)
+}
+
+func (s *Synchronized) ParseSuperadmins() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.ParseSuperadmins()
+}
+
+func (s *Synchronized) UserisadminOrNot(username string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.UserisadminOrNot(username)
+}
+
+func (s *Synchronized) AddmemberstoExisting(groupinfo userinfo.GroupInfo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.AddmemberstoExisting(groupinfo)
+}
+
+func (s *Synchronized) DeletemembersfromGroup(groupinfo userinfo.GroupInfo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.DeletemembersfromGroup(groupinfo)
+}
+
+func (s *Synchronized) IsgroupmemberorNot(groupname string, username string) (bool, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.IsgroupmemberorNot(groupname, username)
+}
+
+func (s *Synchronized) GetDescriptionvalue(groupname string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetDescriptionvalue(groupname)
+}
+
+func (s *Synchronized) GetEmailofauser(username string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetEmailofauser(username)
+}
+
+func (s *Synchronized) GetEmailofusersingroup(groupname string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetEmailofusersingroup(groupname)
+}
+
+func (s *Synchronized) CreateServiceAccount(groupinfo userinfo.GroupInfo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.CreateServiceAccount(groupinfo)
+}
+
+func (s *Synchronized) IsgroupAdminorNot(username string, groupname string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.IsgroupAdminorNot(username, groupname)
+}
+
+func (s *Synchronized) UsernameExistsornot(username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.UsernameExistsornot(username)
+}
+
+func (s *Synchronized) GroupnameExistsornot(groupname string) (bool, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GroupnameExistsornot(groupname)
+}
+
+func (s *Synchronized) ServiceAccountExistsornot(groupname string) (bool, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.ServiceAccountExistsornot(groupname)
+}
+
+func (s *Synchronized) DisableServiceAccount(accountname string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.DisableServiceAccount(accountname)
+}
+
+func (s *Synchronized) DeleteServiceAccount(accountname string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.DeleteServiceAccount(accountname)
+}
+
+func (s *Synchronized) SetServiceAccountPassword(accountname string, password string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.SetServiceAccountPassword(accountname, password)
+}
+
+func (s *Synchronized) GetAllGroupsManagedBy() ([][]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetAllGroupsManagedBy()
+}
+
+func (s *Synchronized) GetGroupsInfoOfUser(groupdn string, username string) ([][]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetGroupsInfoOfUser(groupdn, username)
+}
+
+func (s *Synchronized) GetGroupandManagedbyAttributeValue(groupnames []string) ([][]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetGroupandManagedbyAttributeValue(groupnames)
+}
+
+func (s *Synchronized) CreateUser(username string, givenName, email []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.CreateUser(username, givenName, email)
+}
+
+func (s *Synchronized) GetUserAttributes(username string) ([]string, []string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetUserAttributes(username)
+}
+
+func (s *Synchronized) GetSubgroupsofGroup(groupname string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetSubgroupsofGroup(groupname)
+}
+
+func (s *Synchronized) GetParentgroupsofGroup(groupname string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.GetParentgroupsofGroup(groupname)
+}
+
+func (s *Synchronized) Ping() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.directory.Ping()
+}
@@ -0,0 +1,406 @@
+// Package userinfotest checks that the implementations of
+// userinfo.UserInfoBackend behave alike, so that the handlers tested against
+// one of them work with the others.
+package userinfotest
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Symantec/ldap-group-management/lib/userinfo"
+)
+
+// SuperAdmin is the only super admin of the backends given to TestBackend.
+const SuperAdmin = "contract-admin"
+
+const selfManaged = "self-managed"
+
+// TestBackend checks the contract of userinfo.UserInfoBackend on the
+// backend, which must be empty, with SuperAdmin as its super admin. The
+// parts of the contract are checked in order on the same backend.
+func TestBackend(t *testing.T, backend userinfo.UserInfoBackend) {
+	c := contract{backend: backend}
+	for _, part := range []struct {
+		name string
+		test func(t *testing.T)
+	}{
+		{"Users", c.testUsers},
+		{"Groups", c.testGroups},
+		{"Membership", c.testMembership},
+		{"GroupChanges", c.testGroupChanges},
+		{"ServiceAccounts", c.testServiceAccounts},
+	} {
+		if !t.Run(part.name, part.test) {
+			// the next parts need the state of this one
+			return
+		}
+	}
+}
+
+type contract struct {
+	backend userinfo.UserInfoBackend
+}
+
+func sorted(values []string) []string {
+	values = append([]string{}, values...)
+	sort.Strings(values)
+	return values
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func checkEqual(t *testing.T, what string, got interface{}, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+func checkGroupsOfUser(t *testing.T, backend userinfo.UserInfoBackend, username string, want ...string) {
+	t.Helper()
+	groups, err := backend.GetgroupsofUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "groups of "+username, sorted(groups), sorted(want))
+}
+
+func checkMember(t *testing.T, backend userinfo.UserInfoBackend, groupname string, username string,
+	want bool) {
+	t.Helper()
+	isMember, _, err := backend.IsgroupmemberorNot(groupname, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isMember != want {
+		t.Errorf("%s is a member of %s: %v", username, groupname, isMember)
+	}
+}
+
+func checkAdmin(t *testing.T, backend userinfo.UserInfoBackend, username string, groupname string,
+	want bool) {
+	t.Helper()
+	isAdmin, err := backend.IsgroupAdminorNot(username, groupname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isAdmin != want {
+		t.Errorf("%s manages %s: %v", username, groupname, isAdmin)
+	}
+}
+
+func (c contract) testUsers(t *testing.T) {
+	b := c.backend
+	if err := b.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		err := b.CreateUser(name, []string{"Given " + name}, []string{name + "@example.com"})
+		if err != nil {
+			t.Fatalf("cannot create %s: %s", name, err)
+		}
+	}
+	exists, err := b.UsernameExistsornot("alice")
+	if err != nil || !exists {
+		t.Fatalf("alice does not exist, err %v", err)
+	}
+	exists, err = b.UsernameExistsornot("nobody")
+	if err != nil || exists {
+		t.Fatalf("nobody exists, err %v", err)
+	}
+	users, err := b.GetallUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		if !contains(users, name) {
+			t.Errorf("%s is missing from %v", name, users)
+		}
+	}
+	mails, givenNames, err := b.GetUserAttributes("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "mails of alice", mails, []string{"alice@example.com"})
+	checkEqual(t, "given names of alice", givenNames, []string{"Given alice"})
+	mails, err = b.GetEmailofauser("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "mails of bob", mails, []string{"bob@example.com"})
+	if _, err := b.GetEmailofauser("nobody"); err != userinfo.UserDoesNotExist {
+		t.Errorf("the mails of nobody: err %v", err)
+	}
+
+	found, err := b.SearchUsers("AL", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Username != "alice" || found[0].Email != "alice@example.com" {
+		t.Errorf("unexpected search results %+v", found)
+	}
+	found, err = b.SearchUsers("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].Username > found[1].Username {
+		t.Errorf("the search results are not limited and sorted %+v", found)
+	}
+
+	checkEqual(t, "super admins", b.ParseSuperadmins(), []string{SuperAdmin})
+	if !b.UserisadminOrNot(SuperAdmin) || b.UserisadminOrNot("alice") {
+		t.Errorf("%s is not the only super admin", SuperAdmin)
+	}
+}
+
+func (c contract) testGroups(t *testing.T) {
+	b := c.backend
+	err := b.CreateGroup(userinfo.GroupInfo{Groupname: "admins", Description: selfManaged,
+		MemberUid: []string{"alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.CreateGroup(userinfo.GroupInfo{Groupname: "eng", Description: "admins",
+		MemberUid: []string{"bob", "carol"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.CreateGroup(userinfo.GroupInfo{Groupname: "eng", Description: "admins",
+		MemberUid: []string{"dave"}})
+	if err == nil {
+		t.Error("an existing group was created again")
+	}
+
+	exists, managedBy, err := b.GroupnameExistsornot("eng")
+	if err != nil || !exists || managedBy != "admins" {
+		t.Errorf("eng exists %v managed by %q, err %v", exists, managedBy, err)
+	}
+	exists, _, err = b.GroupnameExistsornot("nogroup")
+	if err != nil || exists {
+		t.Errorf("nogroup exists, err %v", err)
+	}
+	groups, err := b.GetallGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(groups, "admins") || !contains(groups, "eng") {
+		t.Errorf("the groups are missing from %v", groups)
+	}
+
+	members, managedBy, err := b.GetusersofaGroup("eng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "members of eng", sorted(members), []string{"bob", "carol"})
+	checkEqual(t, "manager of eng", managedBy, "admins")
+	if _, _, err := b.GetusersofaGroup("nogroup"); err != userinfo.GroupDoesNotExist {
+		t.Errorf("the members of nogroup: err %v", err)
+	}
+	managedBy, err = b.GetDescriptionvalue("admins")
+	if err != nil || managedBy != selfManaged {
+		t.Errorf("admins is managed by %q, err %v", managedBy, err)
+	}
+	if _, err := b.GetDescriptionvalue("nogroup"); err != userinfo.GroupDoesNotExist {
+		t.Errorf("the manager of nogroup: err %v", err)
+	}
+	members, managers, managedBy, err := b.GetGroupUsersAndManagers("eng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "members of eng", sorted(members), []string{"bob", "carol"})
+	checkEqual(t, "managers of eng", managers, []string{"alice"})
+	checkEqual(t, "manager of eng", managedBy, "admins")
+	mails, err := b.GetEmailofusersingroup("eng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "mails of eng", sorted(mails), []string{"bob@example.com", "carol@example.com"})
+	pairs, err := b.GetGroupandManagedbyAttributeValue([]string{"eng"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "eng and its manager", pairs, [][]string{{"eng", "admins"}})
+	pairs, err = b.GetAllGroupsManagedBy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range [][]string{{"admins", selfManaged}, {"eng", "admins"}} {
+		found := false
+		for _, pair := range pairs {
+			found = found || reflect.DeepEqual(pair, want)
+		}
+		if !found {
+			t.Errorf("%v is missing from %v", want, pairs)
+		}
+	}
+
+	checkMember(t, b, "eng", "bob", true)
+	checkMember(t, b, "eng", "alice", false)
+	checkGroupsOfUser(t, b, "bob", "eng")
+	checkGroupsOfUser(t, b, "dave")
+	checkGroupsOfUser(t, b, "nobody")
+	checkAdmin(t, b, "alice", "eng", true)
+	checkAdmin(t, b, "bob", "eng", false)
+	checkAdmin(t, b, "alice", "admins", true)
+	checkAdmin(t, b, "bob", "admins", false)
+	checkAdmin(t, b, SuperAdmin, "eng", true)
+
+	subgroups, err := b.GetSubgroupsofGroup("eng")
+	if err != nil || len(subgroups) != 0 {
+		t.Errorf("unexpected subgroups %v, err %v", subgroups, err)
+	}
+	parents, err := b.GetParentgroupsofGroup("eng")
+	if err != nil || len(parents) != 0 {
+		t.Errorf("unexpected parent groups %v, err %v", parents, err)
+	}
+}
+
+func (c contract) testMembership(t *testing.T) {
+	b := c.backend
+	err := b.AddmemberstoExisting(userinfo.GroupInfo{Groupname: "eng", MemberUid: []string{"dave"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMember(t, b, "eng", "dave", true)
+	checkGroupsOfUser(t, b, "dave", "eng")
+	err = b.DeletemembersfromGroup(userinfo.GroupInfo{Groupname: "eng", MemberUid: []string{"bob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMember(t, b, "eng", "bob", false)
+	checkGroupsOfUser(t, b, "bob")
+	err = b.AddmemberstoExisting(userinfo.GroupInfo{Groupname: "nogroup", MemberUid: []string{"bob"}})
+	if err == nil {
+		t.Error("members were added to nogroup")
+	}
+}
+
+func (c contract) testGroupChanges(t *testing.T) {
+	b := c.backend
+	err := b.CreateGroup(userinfo.GroupInfo{Groupname: "ops", Description: "eng",
+		MemberUid: []string{"carol"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAdmin(t, b, "dave", "ops", true)
+	if err := b.ChangeDescription("ops", "admins"); err != nil {
+		t.Fatal(err)
+	}
+	managedBy, err := b.GetDescriptionvalue("ops")
+	if err != nil || managedBy != "admins" {
+		t.Errorf("ops is managed by %q, err %v", managedBy, err)
+	}
+	checkAdmin(t, b, "dave", "ops", false)
+
+	if err := b.SetGroupMail("ops", []string{"ops@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	owners, err := b.GetMailOwners("ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "owners of ops@example.com", owners, []string{"ops"})
+	owners, err = b.GetMailOwners("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "owners of alice@example.com", owners, []string{"alice"})
+	if err := b.SetGroupMail("ops", nil); err != nil {
+		t.Fatal(err)
+	}
+	owners, err = b.GetMailOwners("ops@example.com")
+	if err != nil || len(owners) != 0 {
+		t.Errorf("the removed mail is owned by %v, err %v", owners, err)
+	}
+
+	// the groups managed by the renamed group follow it
+	if err := b.ChangeDescription("ops", "eng"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RenameGroup("eng", "engineering"); err != nil {
+		t.Fatal(err)
+	}
+	exists, _, err := b.GroupnameExistsornot("eng")
+	if err != nil || exists {
+		t.Errorf("the renamed group exists, err %v", err)
+	}
+	members, managedBy, err := b.GetusersofaGroup("engineering")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, "members of engineering", sorted(members), []string{"carol", "dave"})
+	checkEqual(t, "manager of engineering", managedBy, "admins")
+	managedBy, err = b.GetDescriptionvalue("ops")
+	if err != nil || managedBy != "engineering" {
+		t.Errorf("ops is managed by %q, err %v", managedBy, err)
+	}
+	checkGroupsOfUser(t, b, "carol", "engineering", "ops")
+
+	if err := b.DeleteGroup([]string{"ops"}); err != nil {
+		t.Fatal(err)
+	}
+	exists, _, err = b.GroupnameExistsornot("ops")
+	if err != nil || exists {
+		t.Errorf("the deleted group exists, err %v", err)
+	}
+	checkGroupsOfUser(t, b, "carol", "engineering")
+}
+
+func (c contract) testServiceAccounts(t *testing.T) {
+	b := c.backend
+	err := b.CreateServiceAccount(userinfo.GroupInfo{Groupname: "svc-build", Mail: "build@example.com",
+		LoginShell: "/bin/false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, _, err := b.ServiceAccountExistsornot("svc-build")
+	if err != nil || !exists {
+		t.Fatalf("the service account does not exist, err %v", err)
+	}
+	exists, err = b.UsernameExistsornot("svc-build")
+	if err != nil || !exists {
+		t.Errorf("the service account is not a user, err %v", err)
+	}
+	exists, _, err = b.GroupnameExistsornot("svc-build")
+	if err != nil || !exists {
+		t.Errorf("the service account has no group, err %v", err)
+	}
+	owners, err := b.GetMailOwners("build@example.com")
+	if err != nil || !contains(owners, "svc-build") {
+		t.Errorf("the mail of the service account is owned by %v, err %v", owners, err)
+	}
+	used, err := b.GetUsedGidNumbers(0, 1<<30)
+	if err != nil || len(used) == 0 {
+		t.Errorf("no gidNumber is used, err %v", err)
+	}
+
+	if err := b.SetServiceAccountPassword("svc-build", "correct horse battery staple"); err != nil {
+		t.Error(err)
+	}
+	if err := b.DisableServiceAccount("svc-build"); err != nil {
+		t.Error(err)
+	}
+	if err := b.DeleteServiceAccount("svc-build"); err != nil {
+		t.Fatal(err)
+	}
+	exists, _, err = b.ServiceAccountExistsornot("svc-build")
+	if err != nil || exists {
+		t.Errorf("the deleted service account exists, err %v", err)
+	}
+	exists, err = b.UsernameExistsornot("svc-build")
+	if err != nil || exists {
+		t.Errorf("the deleted service account is a user, err %v", err)
+	}
+	// the deletion jobs are done with the accounts removed by hand
+	if err := b.DeleteServiceAccount("svc-missing"); err != userinfo.UserDoesNotExist {
+		t.Errorf("deleting a missing service account returned %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+// Package vault is a small client of HashiCorp Vault that reads the
+// smallpoint secrets from the KV engines. It logs in with a token, AppRole
+// or the Kubernetes service account, and renews its token or logs in again before
+// the token expires.
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout           = 10 * time.Second
+	defaultKubernetesJWT     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	maxResponseSize          = 1 << 20
+	AuthMethodToken          = "token"
+	AuthMethodAppRole        = "approle"
+	AuthMethodKubernetes     = "kubernetes"
+	tokenEnvironmentVariable = "VAULT_TOKEN"
+)
+
+// ErrNotFound is returned for a missing secret or key.
+var ErrNotFound = errors.New("vault: secret not found")
+
+type Config struct {
+	// Address of the server, e.g. https://vault.example.com:8200.
+	Address string `yaml:"address"`
+	// InsecureHTTP allows an http address, the tokens and the secrets are
+	// then sent in the clear.
+	InsecureHTTP bool `yaml:"insecure_http"`
+	// Namespace is the Vault Enterprise namespace.
+	Namespace  string `yaml:"namespace"`
+	CAFilename string `yaml:"ca_filename"`
+	// AuthMethod is token (the default), approle or kubernetes. The token
+	// is read from TokenFilename or else the VAULT_TOKEN environment
+	// variable.
+	AuthMethod    string `yaml:"auth_method"`
+	TokenFilename string `yaml:"token_filename"`
+	// AuthMount is the path of the auth method, the method by default.
+	AuthMount string `yaml:"auth_mount"`
+	// Role is the Kubernetes role or the AppRole role id.
+	Role string `yaml:"role"`
+	// SecretIDFilename holds the AppRole secret id.
+	SecretIDFilename string `yaml:"secret_id_filename"`
+	// JWTFilename holds the Kubernetes service account token, the one of
+	// the pod by default.
+	JWTFilename string        `yaml:"jwt_filename"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+func (config Config) Check() error {
+	if strings.HasPrefix(config.Address, "http://") && !config.InsecureHTTP {
+		return errors.New("address must be an https URL, set insecure_http for http")
+	}
+	if !strings.HasPrefix(config.Address, "https://") && !strings.HasPrefix(config.Address, "http://") {
+		return errors.New("address must be an https URL")
+	}
+	switch config.AuthMethod {
+	case "", AuthMethodToken:
+	case AuthMethodAppRole:
+		if config.Role == "" || config.SecretIDFilename == "" {
+			return errors.New("role and secret_id_filename are required for approle")
+		}
+	case AuthMethodKubernetes:
+		if config.Role == "" {
+			return errors.New("role is required for kubernetes")
+		}
+	default:
+		return fmt.Errorf("unknown auth_method %s", config.AuthMethod)
+	}
+	return nil
+}
+
+// A Client reads the secrets with its token, it is safe for concurrent use.
+type Client struct {
+	config     Config
+	httpClient *http.Client
+
+	mutex sync.Mutex
+	token string
+	// renewAt is half the lifetime of the token, zero for the tokens
+	// that do not expire.
+	renewAt   time.Time
+	expiresAt time.Time
+	renewable bool
+}
+
+func New(config Config) (*Client, error) {
+	err := config.Check()
+	if err != nil {
+		return nil, err
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.CAFilename != "" {
+		caCert, err := ioutil.ReadFile(config.CAFilename)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates in %s", config.CAFilename)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	}
+	config.Address = strings.TrimSuffix(config.Address, "/")
+	return &Client{config: config, httpClient: &http.Client{Timeout: timeout, Transport: transport}}, nil
+}
+
+type authResponse struct {
+	Auth *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+func (c *Client) do(method string, path string, token string, body interface{}, response interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, c.config.Address+"/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.config.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(content, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("vault: %s %s failed with status %d: %s", method, path, resp.StatusCode,
+				strings.Join(vaultErr.Errors, ", "))
+		}
+		return fmt.Errorf("vault: %s %s failed with status %d", method, path, resp.StatusCode)
+	}
+	if response != nil {
+		return json.Unmarshal(content, response)
+	}
+	return nil
+}
+
+func readTrimmedFile(filename string) (string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// login gets a new token, the caller holds the mutex.
+func (c *Client) login() error {
+	method := c.config.AuthMethod
+	if method == "" || method == AuthMethodToken {
+		token := os.Getenv(tokenEnvironmentVariable)
+		if c.config.TokenFilename != "" {
+			var err error
+			token, err = readTrimmedFile(c.config.TokenFilename)
+			if err != nil {
+				return err
+			}
+		}
+		if token == "" {
+			return errors.New("vault: no token")
+		}
+		c.token = token
+		// the lifetime of the token is learnt on its first renewal
+		c.renewable = true
+		c.renewAt = time.Now()
+		c.expiresAt = time.Time{}
+		return nil
+	}
+	var body map[string]string
+	if method == AuthMethodAppRole {
+		secretID, err := readTrimmedFile(c.config.SecretIDFilename)
+		if err != nil {
+			return err
+		}
+		body = map[string]string{"role_id": c.config.Role, "secret_id": secretID}
+	} else {
+		jwtFilename := c.config.JWTFilename
+		if jwtFilename == "" {
+			jwtFilename = defaultKubernetesJWT
+		}
+		jwt, err := readTrimmedFile(jwtFilename)
+		if err != nil {
+			return err
+		}
+		body = map[string]string{"role": c.config.Role, "jwt": jwt}
+	}
+	mount := c.config.AuthMount
+	if mount == "" {
+		mount = method
+	}
+	var response authResponse
+	err := c.do(http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", "", body, &response)
+	if err != nil {
+		return err
+	}
+	return c.setAuth(response)
+}
+
+func (c *Client) setAuth(response authResponse) error {
+	if response.Auth == nil || response.Auth.ClientToken == "" {
+		return errors.New("vault: no token in the response")
+	}
+	now := time.Now()
+	c.token = response.Auth.ClientToken
+	c.renewable = response.Auth.Renewable
+	c.renewAt = time.Time{}
+	c.expiresAt = time.Time{}
+	if response.Auth.LeaseDuration > 0 {
+		lifetime := time.Duration(response.Auth.LeaseDuration) * time.Second
+		c.renewAt = now.Add(lifetime / 2)
+		c.expiresAt = now.Add(lifetime)
+	}
+	return nil
+}
+
+// validToken returns the token, renewed or replaced past half its
+// lifetime. A failed renewal logs in again.
+func (c *Client) validToken() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token == "" {
+		err := c.login()
+		if err != nil {
+			return "", err
+		}
+	}
+	if c.renewAt.IsZero() || time.Now().Before(c.renewAt) {
+		return c.token, nil
+	}
+	if c.renewable {
+		var response authResponse
+		err := c.do(http.MethodPost, "auth/token/renew-self", c.token, map[string]string{}, &response)
+		if err == nil {
+			err = c.setAuth(response)
+		}
+		if err == nil {
+			return c.token, nil
+		}
+		if !c.expiresAt.IsZero() && time.Now().Before(c.expiresAt) &&
+			(c.config.AuthMethod == "" || c.config.AuthMethod == AuthMethodToken) {
+			// a static token is kept until it expires
+			return c.token, nil
+		}
+	}
+	err := c.login()
+	if err != nil {
+		return "", err
+	}
+	return c.token, nil
+}
+
+// Read returns the data of the secret at the path, the data of the
+// current version for the KV version 2 engines.
+func (c *Client) Read(path string) (map[string]interface{}, error) {
+	token, err := c.validToken()
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = c.do(http.MethodGet, strings.Trim(path, "/"), token, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Data == nil {
+		return nil, ErrNotFound
+	}
+	// the KV version 2 responses nest the data with its metadata
+	if data, ok := response.Data["data"].(map[string]interface{}); ok {
+		if _, ok := response.Data["metadata"]; ok {
+			return data, nil
+		}
+	}
+	return response.Data, nil
+}
+
+// Secret returns the value of the reference path#key.
+func (c *Client) Secret(reference string) (string, error) {
+	path, key, err := ParseReference(reference)
+	if err != nil {
+		return "", err
+	}
+	data, err := c.Read(path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%w: no string %s in %s", ErrNotFound, key, path)
+	}
+	return value, nil
+}
+
+// ParseReference splits the reference path#key of a secret.
+func ParseReference(reference string) (string, string, error) {
+	index := strings.LastIndex(reference, "#")
+	if index <= 0 || index == len(reference)-1 {
+		return "", "", fmt.Errorf("invalid secret reference %q, path#key is expected", reference)
+	}
+	return reference[:index], reference[index+1:], nil
+}
@@ -0,0 +1,88 @@
+package vault
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Symantec/ldap-group-management/lib/vault/vaulttest"
+)
+
+func TestClient(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.SetSecret("smallpoint/oidc", map[string]interface{}{"client_secret": "s3cret"})
+	dir, err := ioutil.TempDir("", "vault_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFilename := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(tokenFilename, []byte(server.Token+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := New(Config{Address: server.URL, InsecureHTTP: true, TokenFilename: tokenFilename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := client.Secret("secret/data/smallpoint/oidc#client_secret")
+	if err != nil || value != "s3cret" {
+		t.Fatalf("secret %q, err %v", value, err)
+	}
+	if _, err := client.Secret("secret/data/smallpoint/oidc#other"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing key returned %v", err)
+	}
+	if _, err := client.Secret("secret/data/smallpoint/missing#key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing secret returned %v", err)
+	}
+	if _, err := client.Secret("secret/data/smallpoint/oidc"); err == nil {
+		t.Fatal("reference without key was accepted")
+	}
+	// the lifetime of a static token is learnt on its first renewal
+	if _, renewals, _ := server.Counts(); renewals != 1 {
+		t.Fatalf("%d renewals", renewals)
+	}
+
+	secretIDFilename := filepath.Join(dir, "secret_id")
+	err = ioutil.WriteFile(secretIDFilename, []byte("secret-id"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RoleID = "role-id"
+	server.SecretID = "secret-id"
+	client, err = New(Config{Address: server.URL, InsecureHTTP: true, AuthMethod: AuthMethodAppRole, Role: "role-id",
+		SecretIDFilename: secretIDFilename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.Secret("secret/data/smallpoint/oidc#client_secret"); err != nil || value != "s3cret" {
+		t.Fatalf("approle secret %q, err %v", value, err)
+	}
+	// past half its lifetime the failed renewal of a revoked token is
+	// replaced by a new login
+	server.RevokeTokens()
+	client.mutex.Lock()
+	client.renewAt = time.Now().Add(-time.Second)
+	client.mutex.Unlock()
+	if _, err := client.Secret("secret/data/smallpoint/oidc#client_secret"); err != nil {
+		t.Fatal(err)
+	}
+	if logins, _, _ := server.Counts(); logins != 2 {
+		t.Fatalf("%d logins", logins)
+	}
+
+	if _, err := New(Config{Address: "vault:8200"}); err == nil {
+		t.Fatal("address without scheme was accepted")
+	}
+	if _, err := New(Config{Address: server.URL, TokenFilename: tokenFilename}); err == nil {
+		t.Fatal("http address without insecure_http was accepted")
+	}
+	if _, err := New(Config{Address: server.URL, InsecureHTTP: true, AuthMethod: AuthMethodAppRole}); err == nil {
+		t.Fatal("approle without role was accepted")
+	}
+}
@@ -0,0 +1,124 @@
+// Package vaulttest runs an in-memory Vault server with a KV version 2
+// engine and the AppRole login, for the tests.
+package vaulttest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+type Server struct {
+	URL    string
+	server *httptest.Server
+	// Token is accepted from the clients, the logins get other tokens.
+	Token string
+	// RoleID and SecretID are the AppRole credentials.
+	RoleID   string
+	SecretID string
+	// LeaseDuration of the tokens, in seconds.
+	LeaseDuration int64
+
+	mutex    sync.Mutex
+	tokens   map[string]bool
+	secrets  map[string]map[string]interface{}
+	logins   int
+	renewals int
+	reads    int
+}
+
+// NewServer starts a server on a local port.
+func NewServer() *Server {
+	s := &Server{Token: "root-token", LeaseDuration: 3600, tokens: make(map[string]bool),
+		secrets: make(map[string]map[string]interface{})}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetSecret writes the data of the secret at the path of the KV version 2
+// engine mounted at secret, e.g. smallpoint/oidc for secret/data/smallpoint/oidc.
+func (s *Server) SetSecret(path string, data map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.secrets[strings.Trim(path, "/")] = data
+}
+
+// RevokeTokens revokes the tokens of the logins.
+func (s *Server) RevokeTokens() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+// Counts returns the numbers of logins, token renewals and secret reads.
+func (s *Server) Counts() (int, int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.logins, s.renewals, s.reads
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string][]string{"errors": {message}})
+}
+
+func (s *Server) auth(token string) map[string]interface{} {
+	return map[string]interface{}{"auth": map[string]interface{}{"client_token": token,
+		"lease_duration": s.LeaseDuration, "renewable": true}}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if path == "auth/approle/login" && r.Method == http.MethodPost {
+		var body struct {
+			RoleID   string `json:"role_id"`
+			SecretID string `json:"secret_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.RoleID == "" || body.RoleID != s.RoleID || body.SecretID != s.SecretID {
+			writeError(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		s.logins++
+		token := "login-token-" + string(rune('a'+s.logins%26))
+		s.tokens[token] = true
+		writeJSON(w, http.StatusOK, s.auth(token))
+		return
+	}
+	token := r.Header.Get("X-Vault-Token")
+	if token != s.Token && !s.tokens[token] {
+		writeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	if path == "auth/token/renew-self" && r.Method == http.MethodPost {
+		s.renewals++
+		writeJSON(w, http.StatusOK, s.auth(token))
+		return
+	}
+	if !strings.HasPrefix(path, "secret/data/") || r.Method != http.MethodGet {
+		writeError(w, http.StatusNotFound, "unsupported path")
+		return
+	}
+	s.reads++
+	data, ok := s.secrets[strings.TrimPrefix(path, "secret/data/")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string][]string{"errors": {}})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"data": data,
+		"metadata": map[string]interface{}{"version": 1}}})
+}
@@ -25,30 +25,14 @@ make
 %install
 #%make_install
 %{__install} -Dp -m0755 ~/go/bin/smallpoint %{buildroot}%{_sbindir}/smallpoint
+%{__install} -Dp -m0755 ~/go/bin/smallpointctl %{buildroot}%{_bindir}/smallpointctl
 
 install -d %{buildroot}/usr/lib/systemd/system
 install -p -m 0644 misc/startup/smallpoint.service %{buildroot}/usr/lib/systemd/system/smallpoint.service
+# the templates and static assets are built into the binary, the files put
+# in the templates directory replace the built in ones
 install -d %{buildroot}/%{_datarootdir}/smallpoint/templates/
 
-install -d %{buildroot}/%{_datarootdir}/smallpoint/templates/css/
-install -p -m 0644 cmd/smallpoint/templates/css/new.css %{buildroot}/%{_datarootdir}/smallpoint/templates/css/new.css
-
-install -d %{buildroot}/%{_datarootdir}/smallpoint/templates/js/
-install -p -m 0644 cmd/smallpoint/templates/js/addMemberToGroup.js %{buildroot}/%{_datarootdir}/smallpoint/templates/js/addMemberToGroup.js
-install -p -m 0644 cmd/smallpoint/templates/js/changeGroupOwnership.js %{buildroot}/%{_datarootdir}/smallpoint/templates/js/changeGroupOwnership.js
-install -p -m 0644 cmd/smallpoint/templates/js/createGroup.js %{buildroot}/%{_datarootdir}/smallpoint/templates/js/createGroup.js
-install -p -m 0644 cmd/smallpoint/templates/js/deleteGroup.js %{buildroot}/%{_datarootdir}/smallpoint/templates/js/deleteGroup.js
-install -p -m 0644 cmd/smallpoint/templates/js/deleteMembersFromGroup.js %{buildroot}/%{_datarootdir}/smallpoint/templates/js/deleteMembersFromGroup.js
-install -p -m 0644 cmd/smallpoint/templates/js/groupInfo.js %{buildroot}/%{_datarootdir}/smallpoint/templates/js/groupInfo.js
-install -p -m 0644 cmd/smallpoint/templates/js/newtable.js %{buildroot}/%{_datarootdir}/smallpoint/templates/js/newtable.js
-install -p -m 0644 cmd/smallpoint/templates/js/sidebar.js %{buildroot}/%{_datarootdir}/smallpoint/templates/js/sidebar.js
-install -p -m 0644 cmd/smallpoint/templates/js/table.js %{buildroot}/%{_datarootdir}/smallpoint/templates/js/table.js
-
-install -d %{buildroot}/%{_datarootdir}/smallpoint/templates/images/
-install -p -m 0644 cmd/smallpoint/templates/images/avatar2.png %{buildroot}/%{_datarootdir}/smallpoint/templates/images/avatar2.png
-install -p -m 0644 cmd/smallpoint/templates/images/favicon.ico %{buildroot}/%{_datarootdir}/smallpoint/templates/images/favicon.ico
-install -p -m 0644 cmd/smallpoint/templates/images/darkBG.svg %{buildroot}/%{_datarootdir}/smallpoint/templates/images/darkBG.svg
-
 %post
 systemctl daemon-reload
 
@@ -60,6 +44,7 @@ systemctl daemon-reload
 %files
 #%doc
 %{_sbindir}/smallpoint
+%{_bindir}/smallpointctl
 /usr/lib/systemd/system/smallpoint.service
-%{_datarootdir}/smallpoint/templates/*
+%dir %{_datarootdir}/smallpoint/templates/
 %changelog